@@ -22,6 +22,7 @@ func main() {
 	// Disable the built-in -v flag (version), to avoid collisions with the
 	// verbosity flags.
 	app.HideVersion = true
+	app.EnableBashCompletion = true
 	app.Before = func(c *cli.Context) error {
 		configureLogging(c)
 		return nil
@@ -35,6 +36,12 @@ func main() {
 }
 
 func configureLogging(c *cli.Context) {
+	// Logs are written to stdout; keep them out of shell completions.
+	if n := len(os.Args); n > 0 && os.Args[n-1] == "--generate-bash-completion" {
+		logging.SetLevel(zapcore.FatalLevel)
+		return
+	}
+
 	// The LOG_LEVEL environment variable takes precedence.
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		var l zapcore.Level
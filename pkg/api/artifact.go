@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// ArtifactManager is the interface to be implemented by a builder that can
+// enumerate and remove the artifacts it has produced, such as docker images
+// or executables.
+type ArtifactManager interface {
+	// ListArtifacts returns all the artifacts produced by this builder that
+	// are still present.
+	ListArtifacts(ctx context.Context, engine Engine, ow *rpc.OutputWriter) ([]*Artifact, error)
+
+	// RemoveArtifact removes an artifact returned by ListArtifacts.
+	RemoveArtifact(ctx context.Context, engine Engine, artifact *Artifact, ow *rpc.OutputWriter) error
+}
+
+// Artifact is a build artifact, as found by an ArtifactManager.
+type Artifact struct {
+	// ID is what a composition refers to as the artifact of a group, i.e. the
+	// docker image ID, or the path of the executable.
+	ID string `json:"id"`
+
+	// Builder is the ID of the builder that produced the artifact.
+	Builder string `json:"builder"`
+
+	// Plan is the name of the test plan the artifact was built from.
+	Plan string `json:"plan"`
+
+	// Created is the time at which the artifact was built.
+	Created time.Time `json:"created"`
+
+	// Size is the size of the artifact in bytes.
+	Size int64 `json:"size"`
+
+	// Tags are the docker tags referencing the artifact, if any.
+	Tags []string `json:"tags,omitempty"`
+
+	// Labels are the docker labels of the artifact, if any.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Overrides are the dependency overrides the artifact was built with, e.g.
+	// github.com/libp2p/go-libp2p => v0.18.0, if any.
+	Overrides map[string]string `json:"overrides,omitempty"`
+
+	// Dependencies are the versions of the dependencies the build resolved,
+	// if the engine recorded them.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+
+	// Provenance records the inputs the artifact was built from, if the
+	// engine recorded them.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance records the exact inputs of a build, so that an artifact, and the
+// results of the runs of it, can be traced back to them, and the artifact be
+// rebuilt.
+type Provenance struct {
+	// Builder and Plan are the builder and the test plan of the build.
+	Builder string `json:"builder"`
+	Plan    string `json:"plan"`
+
+	// Commit is the git commit of the sources of the plan, suffixed with
+	// +dirty if they had uncommitted changes, if the client could tell.
+	Commit string `json:"commit,omitempty"`
+
+	// Sources is the sha256 hash of the sources of the plan, of the linked
+	// SDK and of the extra sources, as sent to the daemon.
+	Sources string `json:"sources"`
+
+	// Toolchain is the toolchain of the build, e.g. go1.17.13, or the build
+	// base image of docker builds.
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Selectors, Platforms and Config are the selectors, the platforms and the
+	// configuration of the builder the artifact was built with.
+	Selectors []string    `json:"selectors,omitempty"`
+	Platforms []string    `json:"platforms,omitempty"`
+	Config    interface{} `json:"config,omitempty"`
+
+	// Overrides are the dependency overrides of the build; see
+	// Artifact#Overrides.
+	Overrides map[string]string `json:"overrides,omitempty"`
+
+	// Dependencies are the versions of the dependencies the build resolved,
+	// and Checksums their hashes, as in go.sum, by module@version.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+	Checksums    map[string]string `json:"checksums,omitempty"`
+
+	// Created is the time at which the artifact was built.
+	Created time.Time `json:"created"`
+}
+
+// ArtifactFilter selects build artifacts.
+type ArtifactFilter struct {
+	// Plan, if set, selects the artifacts built from this test plan only.
+	Plan string `json:"plan"`
+
+	// ID, if set, selects the artifacts whose ID starts with this value.
+	ID string `json:"id"`
+
+	// OlderThan, if set, selects the artifacts that were built longer than
+	// this ago.
+	OlderThan time.Duration `json:"older_than"`
+
+	// LargerThan, if set, selects the artifacts whose size exceeds this amount
+	// of bytes.
+	LargerThan int64 `json:"larger_than"`
+
+	// KeepLast, if set, excludes the most recent KeepLast artifacts of every
+	// builder and test plan from the selection.
+	KeepLast int `json:"keep_last"`
+}
+
+// Select returns the artifacts matching the filter, newest first. The age of
+// the artifacts is calculated relative to now.
+func (f ArtifactFilter) Select(artifacts []*Artifact, now time.Time) []*Artifact {
+	res := make([]*Artifact, 0, len(artifacts))
+	for _, a := range artifacts {
+		if f.Plan != "" && a.Plan != f.Plan {
+			continue
+		}
+		if f.ID != "" && !strings.HasPrefix(a.ID, f.ID) {
+			continue
+		}
+		res = append(res, a)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Created.After(res[j].Created)
+	})
+
+	var (
+		kept = make(map[[2]string]int)
+		sel  = res[:0]
+	)
+	for _, a := range res {
+		key := [2]string{a.Builder, a.Plan}
+		if kept[key] < f.KeepLast {
+			kept[key]++
+			continue
+		}
+		if f.OlderThan > 0 && now.Sub(a.Created) < f.OlderThan {
+			continue
+		}
+		if f.LargerThan > 0 && a.Size <= f.LargerThan {
+			continue
+		}
+		sel = append(sel, a)
+	}
+	return sel
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactFilterSelect(t *testing.T) {
+	now := time.Now()
+	artifacts := []*Artifact{
+		{ID: "a1", Builder: "docker:go", Plan: "a", Created: now.Add(-1 * time.Hour), Size: 100},
+		{ID: "a3", Builder: "docker:go", Plan: "a", Created: now.Add(-3 * time.Hour), Size: 300},
+		{ID: "a2", Builder: "docker:go", Plan: "a", Created: now.Add(-2 * time.Hour), Size: 200},
+		{ID: "b1", Builder: "docker:go", Plan: "b", Created: now.Add(-4 * time.Hour), Size: 100},
+		{ID: "c1", Builder: "exec:go", Plan: "a", Created: now.Add(-5 * time.Hour), Size: 100},
+	}
+
+	ids := func(f ArtifactFilter) []string {
+		var res []string
+		for _, a := range f.Select(artifacts, now) {
+			res = append(res, a.ID)
+		}
+		return res
+	}
+
+	require.Equal(t, []string{"a1", "a2", "a3", "b1", "c1"}, ids(ArtifactFilter{}))
+	require.Equal(t, []string{"a1", "a2", "a3", "c1"}, ids(ArtifactFilter{Plan: "a"}))
+	require.Equal(t, []string{"a1", "a2", "a3"}, ids(ArtifactFilter{ID: "a"}))
+	require.Equal(t, []string{"a3", "b1", "c1"}, ids(ArtifactFilter{OlderThan: 150 * time.Minute}))
+	require.Equal(t, []string{"a2", "a3"}, ids(ArtifactFilter{LargerThan: 100}))
+
+	// the most recent artifacts of every builder and plan are kept, regardless of
+	// the other filters.
+	require.Equal(t, []string{"a3"}, ids(ArtifactFilter{KeepLast: 2}))
+	require.Equal(t, []string{"a3"}, ids(ArtifactFilter{KeepLast: 1, LargerThan: 200}))
+}
@@ -49,11 +49,18 @@ type BuildInput struct {
 	// to build against. For a go build, this could be e.g.:
 	//  github.com/ipfs/go-ipfs=v0.4.22
 	//  github.com/libp2p/go-libp2p=github.com/user/fork@v0.2.8
+	//  github.com/libp2p/go-libp2p-core=../go-libp2p-core
 	Dependencies map[string]DependencyTarget
 
 	// BuildConfig is the configuration of the build job sourced from the test
 	// plan manifest, coalesced with any user-provided overrides.
 	BuildConfig interface{}
+
+	// Platforms are the os/arch pairs the artifacts must run on, e.g.
+	// linux/arm64, from the run config of the groups. Builders that can
+	// cross-build target them; the platform of the builder is assumed if
+	// empty.
+	Platforms []string
 }
 
 // BuildOutput encapsulates the output from a build action.
@@ -69,6 +76,10 @@ type BuildOutput struct {
 	// containing the collapsed transitive upstream dependency set of this
 	// build.
 	Dependencies map[string]string
+
+	// Toolchain is the toolchain the artifact was built with, e.g. go1.17.13,
+	// or the build base image of docker builds, if the builder knows it.
+	Toolchain string
 }
 
 // DependencyTarget encapsulates the target and version of a dependency.
@@ -79,4 +90,37 @@ type DependencyTarget struct {
 
 	// Version is the version of the dependency we want to use.
 	Version string
+
+	// Dir is the directory of the sources of the build holding the local
+	// replacement of the dependency, if it's replaced with a local directory.
+	Dir string
+}
+
+// ArtifactKind is the kind of the artifacts that builders produce, and that
+// runners run.
+type ArtifactKind string
+
+const (
+	// ArtifactDockerImage is a docker image, referenced by its ID or tag.
+	ArtifactDockerImage ArtifactKind = "docker image"
+
+	// ArtifactExecutable is an executable file, referenced by its path.
+	ArtifactExecutable ArtifactKind = "executable"
+)
+
+// ArtifactSpec describes the artifacts a builder produces.
+type ArtifactSpec struct {
+	// Kind is the kind of the artifacts.
+	Kind ArtifactKind
+
+	// Platforms are the os/arch pairs the artifacts are built for, e.g.
+	// "linux/amd64". Empty if they aren't known in advance.
+	Platforms []string
+}
+
+// ArtifactProducer is the interface to be implemented by a builder that
+// describes the artifacts it produces, so that they are matched against the
+// capabilities of runners.
+type ArtifactProducer interface {
+	Produces() ArtifactSpec
 }
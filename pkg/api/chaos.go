@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EnvChaos is the variable of the environment of the instances of a group with
+// chaos, holding the JSON encoding of its ChaosActions.
+const EnvChaos = "TESTGROUND_CHAOS"
+
+// ChaosTopic is the topic of the sync service that a plan publishes
+// ChaosActions to, for the sidecars of the instances they target to inject.
+const ChaosTopic = "chaos"
+
+// ChaosClockTopic is the topic of the sync service, suffixed with the hostname
+// of an instance, that the sidecar publishes the ClockSkews of the instance
+// to. The instances share the clock of their host, so plans that opt in to
+// clock signals apply the skew to the time they read.
+const ChaosClockTopic = "chaos-clock:"
+
+// ChaosClockAckState is the state of the sync service, suffixed with the
+// hostname of an instance, that the instance signals once it applies a
+// ClockSkew, but for the ends of the skews.
+const ChaosClockAckState = "chaos-clock-ack:"
+
+// ChaosOutput is the output file of the faults injected to an instance, which
+// the sidecar writes as they are injected; see ChaosEvent.
+const ChaosOutput = "chaos.out"
+
+// ChaosKind is a kind of fault that the sidecar injects to instances.
+type ChaosKind string
+
+const (
+	// ChaosKill kills the instances, for good.
+	ChaosKill = ChaosKind("kill")
+
+	// ChaosPause pauses the instances for the duration of the fault.
+	ChaosPause = ChaosKind("pause")
+
+	// ChaosRestart restarts the instances.
+	ChaosRestart = ChaosKind("restart")
+
+	// ChaosClockSignal signals the instances to skew their clocks by the skew
+	// of the fault, for its duration, or until the end of the run. It's a
+	// cooperative fault: the sidecar can't skew the clock of an instance, so
+	// it publishes the skew on the ChaosClockTopic, and records the fault once
+	// the instance signals the ChaosClockAckState. Plans that don't opt in
+	// ignore it.
+	ChaosClockSignal = ChaosKind("clock-signal")
+
+	// ChaosDiskFill fills the disk of the instances with a file of the size
+	// of the fault, for its duration, or until the end of the run.
+	ChaosDiskFill = ChaosKind("disk-fill")
+)
+
+// Chaos is a fault that the sidecar injects to the instances of a group at a
+// time of the run, e.g.:
+//
+//	[[groups.chaos]]
+//	at = "60s"
+//	action = "pause"
+//	percent = 30
+//	duration = "10s"
+//
+//	[[groups.chaos]]
+//	at = "2m"
+//	action = "clock-signal"
+//	skew = "-5m"
+//
+// Every fault is injected to the given percentage of the instances, the first
+// ones whose sidecars are ready, and recorded in their ChaosOutput. It
+// requires a runner with a sidecar.
+type Chaos struct {
+	// At is the time of the fault, counted from when the networks of all the
+	// instances are ready.
+	At string `toml:"at" json:"at"`
+
+	// Action is the kind of the fault; see ChaosKind.
+	Action ChaosKind `toml:"action" json:"action"`
+
+	// Percent is the percentage of the instances of the group that the fault
+	// is injected to; all of them if 0.
+	Percent int `toml:"percent" json:"percent,omitempty"`
+
+	// Duration is how long a pause, a clock signal or a disk fill lasts; the
+	// latter two last until the end of the run if empty.
+	Duration string `toml:"duration" json:"duration,omitempty"`
+
+	// Skew is the skew of the clocks of a clock signal, e.g. "-5m".
+	Skew string `toml:"skew" json:"skew,omitempty"`
+
+	// Size is the size of the file of a disk fill, in MiB.
+	Size int `toml:"size" json:"size,omitempty"`
+}
+
+// ChaosAction is a fault, as injected by the sidecar. Plans may publish them
+// on the ChaosTopic too, e.g. to kill a peer after a step of a test; their At
+// then counts from when the sidecars receive them.
+type ChaosAction struct {
+	At       time.Duration `json:"at"`
+	Action   ChaosKind     `json:"action"`
+	Percent  int           `json:"percent"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Skew     time.Duration `json:"skew,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+
+	// Group is the group of the instances that the action of a plan targets;
+	// all of them if empty. It's ignored in compositions.
+	Group string `json:"group,omitempty"`
+}
+
+// Validate checks that the action is well-formed.
+func (a *ChaosAction) Validate() error {
+	switch a.Action {
+	case ChaosKill, ChaosRestart:
+	case ChaosPause:
+		if a.Duration <= 0 {
+			return fmt.Errorf("%s requires a duration", a.Action)
+		}
+	case ChaosClockSignal:
+		if a.Skew == 0 {
+			return fmt.Errorf("%s requires a skew", a.Action)
+		}
+	case ChaosDiskFill:
+		if a.Size <= 0 {
+			return fmt.Errorf("%s requires a size", a.Action)
+		}
+	default:
+		return fmt.Errorf("unknown chaos action %q; expected one of %s, %s, %s, %s or %s", a.Action, ChaosKill, ChaosPause, ChaosRestart, ChaosClockSignal, ChaosDiskFill)
+	}
+	if a.At < 0 || a.Duration < 0 {
+		return fmt.Errorf("at and duration must not be negative")
+	}
+	if a.Percent < 0 || a.Percent > 100 {
+		return fmt.Errorf("invalid percent %d: must be a percentage", a.Percent)
+	}
+	return nil
+}
+
+// Instances returns the number of the instances of a group of the given size
+// that the action is injected to.
+func (a *ChaosAction) Instances(total int) int {
+	if a.Percent == 0 {
+		return total
+	}
+	// rounded up, for every action to target an instance at least.
+	return (a.Percent*total + 99) / 100
+}
+
+// Spec returns the action for the sidecar.
+func (c *Chaos) Spec() (*ChaosAction, error) {
+	a := &ChaosAction{Action: c.Action, Percent: c.Percent, Size: int64(c.Size) << 20}
+	var err error
+	if c.At == "" {
+		return nil, fmt.Errorf("expected a time")
+	}
+	if a.At, err = parseLinkDuration(c.At); err != nil {
+		return nil, fmt.Errorf("invalid time: %w", err)
+	}
+	if a.Duration, err = parseLinkDuration(c.Duration); err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+	if c.Skew != "" {
+		if a.Skew, err = time.ParseDuration(c.Skew); err != nil {
+			return nil, fmt.Errorf("invalid skew: %w", err)
+		}
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ClockSkew is the skew of the clock of an instance, which the sidecar
+// publishes on its ChaosClockTopic; 0 once the skew is over.
+type ClockSkew struct {
+	Skew time.Duration `json:"skew"`
+}
+
+// ChaosEvent is a fault injected to an instance, as recorded in its
+// ChaosOutput.
+type ChaosEvent struct {
+	Time     time.Time     `json:"time"`
+	Action   ChaosKind     `json:"action"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Skew     time.Duration `json:"skew,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+
+	// Source is where the action came from: "composition" or "plan".
+	Source string `json:"source"`
+
+	// Error is the error the injection failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// ChaosEnv returns the variables of the environment of the instances of the
+// group that carry its chaos to the sidecar, if any.
+func (g *RunGroup) ChaosEnv() map[string]string {
+	env := make(map[string]string, 1)
+	if len(g.Chaos) > 0 {
+		b, _ := json.Marshal(g.Chaos)
+		env[EnvChaos] = string(b)
+	}
+	return env
+}
@@ -4,10 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/config"
 
 	"github.com/go-playground/validator/v10"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var compositionValidator = func() *validator.Validate {
@@ -35,10 +41,95 @@ func (gs Groups) Validate(c *Composition) error {
 		}
 	}
 
+	// Validate the resources of every group
+	for _, g := range gs {
+		if err := g.Resources.Validate(); err != nil {
+			return fmt.Errorf("group %s has invalid resources: %w", g.ID, err)
+		}
+	}
+
+	// Validate the dependency overrides of every group
+	if c.Global.Build != nil {
+		if err := c.Global.Build.Dependencies.Validate(); err != nil {
+			return fmt.Errorf("global build has invalid dependencies: %w", err)
+		}
+	}
+	for _, g := range gs {
+		if err := g.Build.Dependencies.Validate(); err != nil {
+			return fmt.Errorf("group %s has invalid dependencies: %w", g.ID, err)
+		}
+	}
+
+	// Validate the timeout of every group
+	for _, g := range gs {
+		if _, err := parseTimeout(g.Timeout); err != nil {
+			return fmt.Errorf("group %s has an invalid timeout: %w", g.ID, err)
+		}
+	}
+
+	// Validate the network profile of every group
+	for _, g := range gs {
+		if g.Network == nil {
+			continue
+		}
+		if err := g.Network.Validate(); err != nil {
+			return fmt.Errorf("group %s has an invalid network profile: %w", g.ID, err)
+		}
+		for _, l := range g.Network.Links {
+			if _, ok := m[l.Group]; l.Group != "" && !ok {
+				return fmt.Errorf("group %s has a link to unknown group %s", g.ID, l.Group)
+			}
+		}
+	}
+
+	// Validate the chaos of every group
+	for _, g := range gs {
+		for i, c := range g.Chaos {
+			if _, err := c.Spec(); err != nil {
+				return fmt.Errorf("group %s has an invalid chaos action %d: %w", g.ID, i, err)
+			}
+		}
+	}
+
+	// Validate the start conditions refer to existing groups, without cycles
+	after := make(map[string]string, len(gs))
+	for _, g := range gs {
+		if g.StartAfter == nil {
+			continue
+		}
+		if err := g.StartAfter.Validate(g.ID); err != nil {
+			return err
+		}
+		if g.StartAfter.Group == "" {
+			continue
+		}
+		if _, ok := m[g.StartAfter.Group]; !ok {
+			return fmt.Errorf("group %s starts after unknown group %s", g.ID, g.StartAfter.Group)
+		}
+		after[g.ID] = g.StartAfter.Group
+	}
+	for _, g := range gs {
+		for id, n := after[g.ID], 0; id != ""; id, n = after[id], n+1 {
+			if id == g.ID || n > len(gs) {
+				return fmt.Errorf("group %s: start_after forms a cycle", g.ID)
+			}
+		}
+	}
+
 	return nil
 }
 
 type Composition struct {
+	// Version is the schema version of this composition; see SchemaVersion.
+	Version int `toml:"version" json:"version"`
+
+	// Include lists the composition fragments, files or https URLs, this
+	// composition inherits settings and groups from. Includes are resolved by
+	// the loader; see ResolveIncludes. Fragments fetched by URL are rendered
+	// without the environment of the client, and plain http URLs require
+	// --insecure-includes.
+	Include []string `toml:"include" json:"include,omitempty"`
+
 	// Metadata expresses optional metadata about this composition.
 	Metadata Metadata `toml:"metadata" json:"metadata"`
 
@@ -48,6 +139,11 @@ type Composition struct {
 	// Groups enumerates the instances groups that participate in this
 	// composition.
 	Groups Groups `toml:"groups" json:"groups" validate:"required,gt=0"`
+
+	// Sweep maps test params to lists of values. A composition with a sweep
+	// is expanded into one run per combination of values (the cartesian
+	// product); see ExpandSweep.
+	Sweep map[string][]string `toml:"sweep" json:"sweep"`
 }
 
 type Global struct {
@@ -55,7 +151,12 @@ type Global struct {
 	Plan string `toml:"plan" json:"plan" validate:"required"`
 
 	// Case is the test case we want to run.
-	Case string `toml:"case" json:"case" validate:"required"`
+	Case string `toml:"case" json:"case" validate:"required_without=Cases,excluded_with=Cases"`
+
+	// Cases, if set instead of Case, turns this composition into a suite: an
+	// ordered list of test cases that run one after the other, against the
+	// same build artifacts. See ExpandSuite.
+	Cases []string `toml:"cases" json:"cases" validate:"omitempty,unique,dive,required"`
 
 	// TotalInstances defines the total number of instances that participate in
 	// this composition; it is the sum of all instances in all groups.
@@ -85,6 +186,124 @@ type Global struct {
 
 	// DisableMetrics is used to disable metrics batching.
 	DisableMetrics bool `toml:"disable_metrics" json:"disable_metrics"`
+
+	// RunTimeout is the time the run may take, e.g. "30m". The engine stops
+	// runs that take longer, tears them down and marks them as timed out;
+	// the outputs they produced until then can still be collected.
+	RunTimeout string `toml:"run_timeout" json:"run_timeout,omitempty"`
+
+	// Retries, if set, makes the engine queue the run again when it fails
+	// for one of the reasons listed; see Retries.
+	Retries *Retries `toml:"retries" json:"retries,omitempty"`
+
+	// AllowFailures is how many instances may fail, as a count, e.g. "3", or
+	// a percentage of the total instances, e.g. "5%", for the run to still
+	// pass, with the success_with_exceptions outcome, and the instances that
+	// failed reported in its result. Runs are all-or-nothing by default.
+	AllowFailures string `toml:"allow_failures" json:"allow_failures,omitempty"`
+
+	// Outputs, if set, overrides where in the object store the outputs of
+	// the run are uploaded to, when the daemon is configured with one.
+	Outputs *OutputsLocation `toml:"outputs" json:"outputs,omitempty"`
+
+	// Stagger, if set, makes the runner start the instances of the run in
+	// batches; see Stagger.
+	Stagger *Stagger `toml:"stagger" json:"stagger,omitempty"`
+}
+
+// Stagger starts the instances of a run in batches, e.g.
+// stagger = { batch = 100, interval = "10s" }, so that thousands of them
+// starting at once don't overwhelm the container runtime, or the instances
+// that the others bootstrap off. Batches are formed in the order instances
+// would start otherwise, i.e. after the groups they start after.
+type Stagger struct {
+	// Batch is the number of instances started at a time.
+	Batch int `toml:"batch" json:"batch"`
+
+	// Interval is the time between the starts of two batches, e.g. "10s".
+	Interval string `toml:"interval" json:"interval"`
+
+	// State, if set, also holds back every batch until all the instances of
+	// the batches before it have signalled this sync service state, e.g.
+	// "bootstrapped".
+	State string `toml:"state" json:"state,omitempty"`
+}
+
+// Validate checks that the batches are well-defined.
+func (s *Stagger) Validate() error {
+	if s.Batch < 1 {
+		return fmt.Errorf("stagger: batch must be at least 1")
+	}
+	if s.Interval == "" && s.State == "" {
+		return fmt.Errorf("stagger: requires an interval or a state")
+	}
+	if s.Interval != "" {
+		if d, err := time.ParseDuration(s.Interval); err != nil || d < 0 {
+			return fmt.Errorf("stagger: invalid interval %q", s.Interval)
+		}
+	}
+	return nil
+}
+
+// IntervalDuration returns the parsed Interval; Validate MUST have succeeded.
+func (s *Stagger) IntervalDuration() time.Duration {
+	d, _ := time.ParseDuration(s.Interval)
+	return d
+}
+
+// OutputsLocation is where in the object store the archive of the outputs
+// of a run is uploaded to, e.g. outputs = { bucket = "tg", prefix = "ci" }.
+// Unset values default to those of the daemon.
+type OutputsLocation struct {
+	Bucket string `toml:"bucket" json:"bucket,omitempty"`
+	Prefix string `toml:"prefix" json:"prefix,omitempty"`
+}
+
+// Retries is the policy to retry runs that fail for reasons other than the
+// test plan, e.g. retries = { attempts = 3, on = ["infra"] }. The attempts
+// that failed are recorded in the task, and in the result of the run.
+type Retries struct {
+	// Attempts is the maximum number of attempts of a run, including the
+	// first one.
+	Attempts int `toml:"attempts" json:"attempts"`
+
+	// On lists the classes of failures that are retried; only FailureInfra
+	// is supported for now, and is the default.
+	On []string `toml:"on" json:"on"`
+}
+
+// Validate checks that the retries policy is consistent.
+func (r *Retries) Validate() error {
+	if r.Attempts < 1 {
+		return fmt.Errorf("retries: attempts must be at least 1")
+	}
+	for _, on := range r.On {
+		if on != FailureInfra {
+			return fmt.Errorf("retries: unsupported failure class %q; supported: %s", on, FailureInfra)
+		}
+	}
+	return nil
+}
+
+// Retry returns whether a run that failed with err, in its given attempt,
+// counting from 1, should be attempted again.
+func (r *Retries) Retry(attempt int, err error) bool {
+	if r == nil || err == nil || attempt >= r.Attempts {
+		return false
+	}
+	class := FailureClass(err)
+	if class == "" {
+		return false
+	}
+	if len(r.On) == 0 {
+		return class == FailureInfra
+	}
+	for _, on := range r.On {
+		if on == class {
+			return true
+		}
+	}
+	return false
 }
 
 type Metadata struct {
@@ -95,16 +314,50 @@ type Metadata struct {
 	Author string `toml:"author" json:"author"`
 }
 
+// Resources are the resources each instance of a group requires. They are
+// expressed as Kubernetes quantities, e.g. cpu = "500m", memory = "1Gi". Each
+// runner decides how to honour them; the docker runners set container limits,
+// and the Kubernetes runner sets pod resource requests.
 type Resources struct {
 	Memory string `toml:"memory" json:"memory"`
 	CPU    string `toml:"cpu" json:"cpu"`
+	Disk   string `toml:"disk" json:"disk"`
+
+	// GPUs is the number of GPUs of each instance; only runners that
+	// provide GPUs run groups that request them.
+	GPUs int `toml:"gpus" json:"gpus,omitempty"`
+}
+
+// Validate checks that the resources are valid, positive quantities.
+func (r Resources) Validate() error {
+	for name, v := range map[string]string{"cpu": r.CPU, "memory": r.Memory, "disk": r.Disk} {
+		if v == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s quantity %q: %w", name, v, err)
+		}
+		if q.Sign() <= 0 {
+			return fmt.Errorf("invalid %s quantity %q: must be positive", name, v)
+		}
+	}
+	if r.GPUs < 0 {
+		return fmt.Errorf("invalid number of gpus %d: must not be negative", r.GPUs)
+	}
+	return nil
+}
+
+// IsZero returns whether no resources are set.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
 }
 
 type Group struct {
 	// ID is the unique ID of this group.
 	ID string `toml:"id" json:"id"`
 
-	// Resources requested for each pod from the Kubernetes cluster
+	// Resources requested for each instance of this group.
 	Resources Resources `toml:"resources" json:"resources"`
 
 	// Instances defines the number of instances that belong to this group.
@@ -113,15 +366,41 @@ type Group struct {
 	// Builder is the builder we're using.
 	Builder string `toml:"builder" json:"builder"`
 
-	// BuildConfig specifies the build configuration for this run.
+	// BuildConfig specifies the build configuration for this group. It is
+	// deep-merged over the global build configuration: tables only need to
+	// carry the keys they override.
 	BuildConfig map[string]interface{} `toml:"build_config" json:"build_config"`
 
 	// Build specifies the build configuration for this group.
 	Build Build `toml:"build" json:"build"`
 
+	// RunConfig specifies the run configuration for this group. It is
+	// deep-merged over the global run configuration, like BuildConfig. Runners
+	// apply the settings that make sense per instance, such as the log level,
+	// to the instances of this group; the rest apply to the run as a whole,
+	// and are taken from the global run configuration.
+	RunConfig map[string]interface{} `toml:"run_config" json:"run_config"`
+
 	// Run specifies the run configuration for this group.
 	Run Run `toml:"run" json:"run"`
 
+	// StartAfter, if set, delays the start of the instances of this group;
+	// see StartAfter.
+	StartAfter *StartAfter `toml:"start_after" json:"start_after,omitempty"`
+
+	// Timeout is the time the instances of this group may run for, counted
+	// from the start of the run, e.g. "10m". Instances still running then are
+	// stopped, and the group and the run are marked as timed out.
+	Timeout string `toml:"timeout" json:"timeout,omitempty"`
+
+	// Network, if set, is the static shape of the data network of the
+	// instances of this group; see NetworkProfile.
+	Network *NetworkProfile `toml:"network" json:"network,omitempty"`
+
+	// Chaos are the faults injected to the instances of this group over the
+	// run; see Chaos.
+	Chaos []Chaos `toml:"chaos" json:"chaos,omitempty"`
+
 	// calculatedInstanceCnt caches the actual amount of instances in this
 	// group.
 	calculatedInstanceCnt uint
@@ -134,6 +413,83 @@ func (g *Group) CalculatedInstanceCount() uint {
 	return g.calculatedInstanceCnt
 }
 
+// TimeoutDuration returns the parsed Timeout, or zero if the group has none;
+// ValidateForRun MUST have succeeded.
+func (g *Group) TimeoutDuration() time.Duration {
+	d, _ := parseTimeout(g.Timeout)
+	return d
+}
+
+// parseTimeout parses a timeout of the composition; an empty timeout is no
+// timeout.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout %q must be positive", s)
+	}
+	return d, nil
+}
+
+// StartAfter delays the start of the instances of a group, until another
+// group has started, until the instances of that group have signalled a
+// state through the sync service, and/or for a fixed delay, in that order.
+// Runners report the group as started once all its instances have been
+// started, not once they are ready; use a state to wait for readiness.
+type StartAfter struct {
+	// Group is the ID of the group to start after.
+	Group string `toml:"group" json:"group"`
+
+	// State is a sync service state that the instances of Group signal, e.g.
+	// with SignalEntry, when this group can start. Requires Group.
+	State string `toml:"state" json:"state"`
+
+	// Target is the number of entries State needs before this group starts;
+	// it defaults to the number of instances of Group.
+	Target int `toml:"target" json:"target"`
+
+	// Delay is the time to wait before starting this group, once the other
+	// conditions are met, e.g. "10s".
+	Delay string `toml:"delay" json:"delay"`
+}
+
+// Validate checks that the start conditions of group g are consistent.
+// Whether the groups it refers to exist is checked by Groups#Validate.
+func (s *StartAfter) Validate(g string) error {
+	if s.Group == "" && s.Delay == "" {
+		return fmt.Errorf("group %s: start_after requires a group or a delay", g)
+	}
+	if s.Group == g {
+		return fmt.Errorf("group %s: cannot start after itself", g)
+	}
+	if s.State == "" && s.Target != 0 {
+		return fmt.Errorf("group %s: start_after target requires a state", g)
+	}
+	if s.State != "" && s.Group == "" {
+		return fmt.Errorf("group %s: start_after state requires a group", g)
+	}
+	if s.Target < 0 {
+		return fmt.Errorf("group %s: start_after target must not be negative", g)
+	}
+	if s.Delay != "" {
+		if d, err := time.ParseDuration(s.Delay); err != nil || d < 0 {
+			return fmt.Errorf("group %s: invalid start_after delay %q", g, s.Delay)
+		}
+	}
+	return nil
+}
+
+// DelayDuration returns the parsed Delay; Validate MUST have succeeded.
+func (s *StartAfter) DelayDuration() time.Duration {
+	d, _ := time.ParseDuration(s.Delay)
+	return d
+}
+
 type Instances struct {
 	// Count specifies the exact number of instances that belong to a group.
 	//
@@ -168,11 +524,15 @@ func (g Group) BuildKey() string {
 		panic("group must have a builder")
 	}
 
+	// groups that run on different platforms can't share their artifacts.
+	platforms, _ := g.Platforms()
+
 	data := struct {
 		Builder     string                 `json:"builder"`
 		BuildConfig map[string]interface{} `json:"build_config"`
 		BuildAsKey  string                 `json:"build_as_key"`
-	}{Builder: g.Builder, BuildConfig: g.BuildConfig, BuildAsKey: g.Build.BuildKey()}
+		Platforms   []string               `json:"platforms,omitempty"`
+	}{Builder: g.Builder, BuildConfig: g.BuildConfig, BuildAsKey: g.Build.BuildKey(), Platforms: platforms}
 
 	j, err := json.Marshal(data)
 
@@ -183,6 +543,13 @@ func (g Group) BuildKey() string {
 	return string(j)
 }
 
+// Platforms returns the platforms the instances of the group run on, set in
+// the platform key of its run config, which the artifacts of the group are
+// built for. See ParsePlatforms.
+func (g Group) Platforms() ([]string, error) {
+	return ParsePlatforms(g.RunConfig["platform"])
+}
+
 // BuildKey returns a composite key that identifies this build, suitable for
 // deduplication.
 func (b Build) BuildKey() string {
@@ -202,7 +569,7 @@ func (b Build) BuildKey() string {
 	})
 	sb.WriteString("dependencies=")
 	for _, d := range dependencies {
-		sb.WriteString(fmt.Sprintf("%s:%s|", d.Module, d.Version))
+		sb.WriteString(fmt.Sprintf("%s:%s@%s|", d.Module, d.Target, d.Version))
 	}
 
 	return sb.String()
@@ -227,17 +594,105 @@ func (d Dependencies) ApplyDefaults(defaults Dependencies) Dependencies {
 	copy(ret[:], d)
 
 	into := d.AsMap()
-	for mod, ver := range defaults.AsMap() {
-		if _, present := into[mod]; !present {
-			ret = append(ret, Dependency{
-				Module:  mod,
-				Version: ver,
-			})
+	for _, dep := range defaults {
+		if _, present := into[dep.Module]; !present {
+			ret = append(ret, dep)
 		}
 	}
 	return ret
 }
 
+// Validate validates that every dependency names a module, and a version to
+// override it with, unless it's replaced with a local directory.
+func (d Dependencies) Validate() error {
+	seen := make(map[string]struct{}, len(d))
+	for _, dep := range d {
+		if dep.Module == "" {
+			return fmt.Errorf("dependency is missing a module")
+		}
+		if _, ok := seen[dep.Module]; ok {
+			return fmt.Errorf("module %s is overridden twice", dep.Module)
+		}
+		seen[dep.Module] = struct{}{}
+		if dep.Version == "" && !dep.IsLocal() {
+			return fmt.Errorf("dependency %s is missing a version", dep.Module)
+		}
+	}
+	return nil
+}
+
+// UnmarshalTOML decodes the dependencies from an array of tables, with the
+// module, target and version keys, or from a table mapping the modules to
+// their overrides (see ParseDependency), e.g.
+//
+//	[groups.build.dependencies]
+//	"github.com/libp2p/go-libp2p" = "github.com/user/go-libp2p@v0.19.1-0.20220301102345-1a2b3c4d5e6f"
+//	"github.com/libp2p/go-libp2p-kad-dht" = "v0.15.0"
+//	"github.com/libp2p/go-libp2p-core" = "../go-libp2p-core"
+func (d *Dependencies) UnmarshalTOML(v interface{}) error {
+	str := func(m map[string]interface{}, k string) (string, error) {
+		switch v := m[k].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		default:
+			return "", fmt.Errorf("dependency %s must be a string; got %v", k, v)
+		}
+	}
+
+	var res Dependencies
+	switch v := v.(type) {
+	case []map[string]interface{}:
+		for _, m := range v {
+			var (
+				dep Dependency
+				err error
+			)
+			if dep.Module, err = str(m, "module"); err != nil {
+				return err
+			}
+			if dep.Target, err = str(m, "target"); err != nil {
+				return err
+			}
+			if dep.Version, err = str(m, "version"); err != nil {
+				return err
+			}
+			res = append(res, dep)
+		}
+	case map[string]interface{}:
+		for mod := range v {
+			override, err := str(v, mod)
+			if err != nil {
+				return err
+			}
+			res = append(res, ParseDependency(mod, override))
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Module < res[j].Module })
+	default:
+		return fmt.Errorf("invalid dependencies: %v", v)
+	}
+
+	*d = res
+	return nil
+}
+
+// ParseDependency parses the override of a module: a version, e.g. v0.15.0, a
+// module at a version, e.g. github.com/user/go-libp2p@v0.19.1, or a local
+// directory, e.g. ../go-libp2p, whose sources are shipped with the build.
+func ParseDependency(module, override string) Dependency {
+	dep := Dependency{Module: module}
+	switch i := strings.LastIndex(override, "@"); {
+	case isLocalPath(override):
+		dep.Target = override
+	case i >= 0:
+		dep.Target, dep.Version = override[:i], override[i+1:]
+	default:
+		dep.Version = override
+	}
+	return dep
+}
+
 type Run struct {
 	// Artifact specifies the build artifact to use for this run.
 	Artifact string `toml:"artifact" json:"artifact"`
@@ -262,17 +717,33 @@ type Dependency struct {
 	// Module is the module name/path for the import to be overridden.
 	Module string `toml:"module" json:"module" validate:"required"`
 
-	// Target is the override module.
-	Target string `toml:"target" json:"target" validate:"target"`
+	// Target is the override module, or the local directory replacing the
+	// module, if any.
+	Target string `toml:"target" json:"target"`
+
+	// Version is the override version. Local replacements have none.
+	Version string `toml:"version" json:"version"`
+}
 
-	// Version is the override version.
-	Version string `toml:"version" json:"version" validate:"required"`
+// IsLocal returns whether the module is replaced with a local directory, e.g.
+// ../go-libp2p, rather than with a version of a module.
+func (d Dependency) IsLocal() bool {
+	return isLocalPath(d.Target)
+}
+
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, ".") || filepath.IsAbs(p)
 }
 
 // ValidateForBuild validates that this Composition is correct for a build.
 func (c *Composition) ValidateForBuild() error {
+	if err := c.validateVersion(); err != nil {
+		return err
+	}
+
 	err := compositionValidator.StructExcept(c,
 		"Global.Case",
+		"Global.Cases",
 		"Global.TotalInstances",
 		"Global.Runner",
 	)
@@ -283,8 +754,25 @@ func (c *Composition) ValidateForBuild() error {
 	return c.Groups.Validate(c)
 }
 
+// validateVersion checks that this Composition was written for a schema
+// version we understand; compositions from older clients carry no version.
+// Its includes must have been resolved by the loader.
+func (c *Composition) validateVersion() error {
+	if c.Version < 0 || c.Version > SchemaVersion {
+		return fmt.Errorf("unsupported composition schema version %d; this testground supports versions 1 to %d", c.Version, SchemaVersion)
+	}
+	if len(c.Include) > 0 {
+		return fmt.Errorf("unresolved includes: %s", strings.Join(c.Include, ", "))
+	}
+	return nil
+}
+
 // ValidateForRun validates that this Composition is correct for a run.
 func (c *Composition) ValidateForRun() error {
+	if err := c.validateVersion(); err != nil {
+		return err
+	}
+
 	// Perform structural validation.
 	if err := compositionValidator.Struct(c); err != nil {
 		return err
@@ -305,13 +793,91 @@ func (c *Composition) ValidateForRun() error {
 		return fmt.Errorf("sum of calculated instances per group doesn't match total; total=%d, calculated=%d", total, cum)
 	}
 
+	if _, err := parseTimeout(c.Global.RunTimeout); err != nil {
+		return fmt.Errorf("invalid run_timeout: %w", err)
+	}
+
+	if r := c.Global.Retries; r != nil {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if s := c.Global.Stagger; s != nil {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := parseAllowFailures(c.Global.AllowFailures, total); err != nil {
+		return fmt.Errorf("invalid allow_failures: %w", err)
+	}
+
 	return c.Groups.Validate(c)
 }
 
+// AllowedFailures returns how many instances may fail for the run to pass.
+// ValidateForRun MUST have succeeded.
+func (c *Composition) AllowedFailures() int {
+	n, _ := parseAllowFailures(c.Global.AllowFailures, c.Global.TotalInstances)
+	return n
+}
+
+// parseAllowFailures parses allow_failures into a number of instances, out
+// of the total: a count, or a percentage of the total, rounded down.
+func parseAllowFailures(s string, total uint) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if pct := strings.TrimSuffix(s, "%"); pct != s {
+		f, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || f < 0 || f > 100 {
+			return 0, fmt.Errorf("%q is not a percentage between 0%% and 100%%", s)
+		}
+		return int(math.Floor(f * float64(total) / 100)), nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is neither a count of instances nor a percentage", s)
+	}
+	return n, nil
+}
+
+// RunTimeout returns the time after which the engine stops the run: the run
+// timeout, bounded by the longest group timeout when every group has one,
+// since the run can't outlast all its groups. Zero means no limit.
+// ValidateForRun MUST have succeeded.
+func (c *Composition) RunTimeout() time.Duration {
+	timeout, _ := parseTimeout(c.Global.RunTimeout)
+
+	var longest time.Duration
+	for _, g := range c.Groups {
+		d := g.TimeoutDuration()
+		if d == 0 {
+			return timeout
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+	if timeout != 0 && timeout < longest {
+		return timeout
+	}
+	if longest == 0 {
+		return timeout
+	}
+	return longest
+}
+
 // PrepareForBuild verifies that this composition is compatible with
 // the provided manifest for the purposes of a build, and applies any manifest-
 // mandated defaults for the builder configuration.
 //
+// Build configurations are deep-merged, with the following precedence
+// (highest to lowest): the group build_config, the global build_config, and
+// the defaults of the builder in the manifest. When building, the daemon then
+// merges the result over the builder settings of its .env.toml.
+//
 // This method doesn't modify the composition, it returns a new one.
 func (c Composition) PrepareForBuild(manifest *TestPlanManifest) (*Composition, error) {
 	// override the composition plan name with what's in the manifest
@@ -325,17 +891,10 @@ func (c Composition) PrepareForBuild(manifest *TestPlanManifest) (*Composition,
 		return nil, fmt.Errorf("plan supports no builders; review the manifest")
 	}
 
-	// Apply manifest-mandated build configuration.
+	// Apply manifest-mandated build configuration, for the parameters that are
+	// not explicitly set in the Composition.
 	if bcfg, ok := manifest.Builders[c.Global.Builder]; ok {
-		if c.Global.BuildConfig == nil {
-			c.Global.BuildConfig = make(map[string]interface{})
-		}
-		for k, v := range bcfg {
-			// Apply parameters that are not explicitly set in the Composition.
-			if _, ok := c.Global.BuildConfig[k]; !ok {
-				c.Global.BuildConfig[k] = v
-			}
-		}
+		c.Global.BuildConfig = config.Inherit(c.Global.BuildConfig, bcfg)
 	}
 
 	// Trickle global build defaults to groups, if any.
@@ -349,19 +908,8 @@ func (c Composition) PrepareForBuild(manifest *TestPlanManifest) (*Composition,
 	}
 
 	// Trickle global build config to groups, if any.
-	if len(c.Global.BuildConfig) > 0 {
-		for _, grp := range c.Groups {
-			if grp.BuildConfig == nil {
-				grp.BuildConfig = make(map[string]interface{})
-			}
-
-			for k, v := range c.Global.BuildConfig {
-				// Note: we only merge root values.
-				if _, ok := grp.BuildConfig[k]; !ok {
-					grp.BuildConfig[k] = v
-				}
-			}
-		}
+	for _, grp := range c.Groups {
+		grp.BuildConfig = config.Inherit(grp.BuildConfig, c.Global.BuildConfig)
 	}
 
 	// Trickle builder configuration
@@ -404,6 +952,11 @@ func (c *Composition) ListBuilders() []string {
 // within bounds, applies any manifest-mandated defaults for the runner
 // configuration, and applies default run parameters.
 //
+// Run configurations are deep-merged like build configurations in
+// PrepareForBuild: the group run_config takes precedence over the global
+// run_config, which takes precedence over the defaults of the runner in the
+// manifest.
+//
 // This method doesn't modify the composition, it returns a new one.
 func (c Composition) PrepareForRun(manifest *TestPlanManifest) (*Composition, error) {
 	// override the composition plan name with what's in the manifest
@@ -431,17 +984,15 @@ func (c Composition) PrepareForRun(manifest *TestPlanManifest) (*Composition, er
 		return nil, fmt.Errorf("plan does not support runner %s; supported: %v", c.Global.Runner, runners)
 	}
 
-	// Apply manifest-mandated run configuration.
+	// Apply manifest-mandated run configuration, for the parameters that are
+	// not explicitly set in the Composition.
 	if rcfg, ok := manifest.Runners[c.Global.Runner]; ok {
-		if c.Global.RunConfig == nil {
-			c.Global.RunConfig = make(map[string]interface{})
-		}
-		for k, v := range rcfg {
-			// Apply parameters that are not explicitly set in the Composition.
-			if _, ok := c.Global.RunConfig[k]; !ok {
-				c.Global.RunConfig[k] = v
-			}
-		}
+		c.Global.RunConfig = config.Inherit(c.Global.RunConfig, rcfg)
+	}
+
+	// Trickle global run config to groups, if any.
+	for _, grp := range c.Groups {
+		grp.RunConfig = config.Inherit(grp.RunConfig, c.Global.RunConfig)
 	}
 
 	// Validate the desired number of instances is within bounds.
@@ -451,6 +1002,27 @@ func (c Composition) PrepareForRun(manifest *TestPlanManifest) (*Composition, er
 		return nil, err
 	}
 
+	// Validate the groups don't request less memory than the test case
+	// requires.
+	if err := tcase.Requires.Validate(); err != nil {
+		return nil, fmt.Errorf("test case %s: %w", tcase.Name, err)
+	}
+	if min := tcase.Requires.MinMemory; min != "" {
+		required := resource.MustParse(min)
+		for _, grp := range c.Groups {
+			if grp.Resources.Memory == "" {
+				continue
+			}
+			mem, err := resource.ParseQuantity(grp.Resources.Memory)
+			if err != nil {
+				return nil, fmt.Errorf("group %s has invalid resources: %w", grp.ID, err)
+			}
+			if mem.Cmp(required) < 0 {
+				return nil, fmt.Errorf("group %s requests %s of memory, but test case %s requires at least %s per instance", grp.ID, grp.Resources.Memory, tcase.Name, min)
+			}
+		}
+	}
+
 	// Trickle global run defaults to groups, if any.
 	if def := c.Global.Run; def != nil {
 		for _, grp := range c.Groups {
@@ -489,10 +1061,13 @@ func (c Composition) PrepareForRun(manifest *TestPlanManifest) (*Composition, er
 
 	// Apply test case param defaults. First parse all defaults as JSON data
 	// types; then iterate through all the groups in the composition, and apply
-	// the parameters that are absent.
+	// the parameters that are absent. Parameters without a default stay
+	// absent, for validation to require them if they are required.
 	defaults := make(map[string]string, len(tcase.Parameters))
 	for n, v := range tcase.Parameters {
 		switch dv := v.Default.(type) {
+		case nil:
+			continue
 		case string:
 			defaults[n] = dv
 		default:
@@ -515,11 +1090,114 @@ func (c Composition) PrepareForRun(manifest *TestPlanManifest) (*Composition, er
 				m[k] = v
 			}
 		}
+
+		if err := tcase.ValidateParams(m); err != nil {
+			return nil, fmt.Errorf("invalid test params for group %s: %w", g.ID, err)
+		}
 	}
 
 	return &c, nil
 }
 
+// ExpandSweep expands this composition into one composition per combination
+// of sweep values, each of them setting the swept test params on all groups,
+// overriding any values set in the composition. Combinations are enumerated
+// in a stable order, iterating over params sorted by name.
+//
+// If the composition has no sweep, the result contains only the composition
+// itself. The returned compositions carry no sweep.
+func (c Composition) ExpandSweep() ([]*Composition, error) {
+	params := make([]string, 0, len(c.Sweep))
+	for k, vals := range c.Sweep {
+		if len(vals) == 0 {
+			return nil, fmt.Errorf("sweep over test param %s has no values", k)
+		}
+		params = append(params, k)
+	}
+	sort.Strings(params)
+
+	combinations := []map[string]string{{}}
+	for _, p := range params {
+		next := make([]map[string]string, 0, len(combinations)*len(c.Sweep[p]))
+		for _, comb := range combinations {
+			for _, v := range c.Sweep[p] {
+				m := make(map[string]string, len(comb)+1)
+				for k, v := range comb {
+					m[k] = v
+				}
+				m[p] = v
+				next = append(next, m)
+			}
+		}
+		combinations = next
+	}
+
+	res := make([]*Composition, 0, len(combinations))
+	for _, comb := range combinations {
+		cc := c
+		cc.Sweep = nil
+		cc.Groups = make(Groups, 0, len(c.Groups))
+		for _, g := range c.Groups {
+			gg := *g
+			gg.Run.TestParams = make(map[string]string, len(g.Run.TestParams)+len(comb))
+			for k, v := range g.Run.TestParams {
+				gg.Run.TestParams[k] = v
+			}
+			for k, v := range comb {
+				gg.Run.TestParams[k] = v
+			}
+			cc.Groups = append(cc.Groups, &gg)
+		}
+		res = append(res, &cc)
+	}
+	return res, nil
+}
+
+// IsSuite returns whether this composition runs a suite of test cases.
+func (c *Composition) IsSuite() bool {
+	return len(c.Global.Cases) > 0
+}
+
+// ExpandSuite expands a suite into one composition per test case, in order.
+// The groups of every resulting composition are copies, so that preparing one
+// for its run, e.g. applying the defaults of its test case, doesn't affect the
+// others. A composition that is not a suite expands into a copy of itself.
+func (c Composition) ExpandSuite() []*Composition {
+	cases := c.Global.Cases
+	if len(cases) == 0 {
+		cases = []string{c.Global.Case}
+	}
+
+	res := make([]*Composition, 0, len(cases))
+	for _, tc := range cases {
+		cc := c
+		cc.Global.Case = tc
+		cc.Global.Cases = nil
+		cc.Groups = make(Groups, 0, len(c.Groups))
+		for _, g := range c.Groups {
+			gg := *g
+			gg.BuildConfig = config.Inherit(g.BuildConfig, nil)
+			gg.RunConfig = config.Inherit(g.RunConfig, nil)
+			gg.Run.TestParams = copyStringMap(g.Run.TestParams)
+			gg.Run.Profiles = copyStringMap(g.Run.Profiles)
+			cc.Groups = append(cc.Groups, &gg)
+		}
+		res = append(res, &cc)
+	}
+	return res
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
 // PickGroups clones this composition, retaining only the specified groups.
 func (c Composition) PickGroups(indices ...int) (Composition, error) {
 	for _, i := range indices {
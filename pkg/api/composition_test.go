@@ -1,10 +1,13 @@
 package api
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/testground/testground/pkg/config"
 
+	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/require"
 )
 
@@ -147,6 +150,17 @@ func TestBuildKeyDependsOnBuilder(t *testing.T) {
 	require.EqualValues(t, k2, k3)
 }
 
+func TestBuildKeyDependsOnPlatforms(t *testing.T) {
+	g1 := &Group{ID: "amd64", Builder: "docker:go"}
+	g2 := &Group{ID: "arm64", Builder: "docker:go", RunConfig: map[string]interface{}{"platform": "linux/arm64"}}
+	g3 := &Group{ID: "multi", Builder: "docker:go", RunConfig: map[string]interface{}{"platform": "linux/arm64,linux/amd64"}}
+	g4 := &Group{ID: "multi-array", Builder: "docker:go", RunConfig: map[string]interface{}{"platform": []interface{}{"linux/amd64", "linux/arm64"}}}
+
+	require.NotEqualValues(t, g1.BuildKey(), g2.BuildKey())
+	require.NotEqualValues(t, g2.BuildKey(), g3.BuildKey())
+	require.EqualValues(t, g3.BuildKey(), g4.BuildKey())
+}
+
 func TestDefaultTestParamsApplied(t *testing.T) {
 	c := &Composition{
 		Metadata: Metadata{},
@@ -408,6 +422,109 @@ func TestDefaultBuildConfigTrickleDown(t *testing.T) {
 	require.EqualValues(t, "docker:generic", ret.Groups[3].Builder)
 }
 
+func TestGroupConfigDeepMerge(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			TotalInstances: 2,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			BuildConfig: map[string]interface{}{
+				"dockerfile_extensions": map[string]interface{}{
+					"pre_build": "global_pre_build",
+				},
+			},
+			RunConfig: map[string]interface{}{
+				"log_level": "info",
+				"exposed_ports": map[string]interface{}{
+					"pprof": "6060",
+				},
+			},
+		},
+		Groups: []*Group{
+			{
+				ID:        "defaults",
+				Instances: Instances{Count: 1},
+			},
+			{
+				ID:        "overrides",
+				Instances: Instances{Count: 1},
+				BuildConfig: map[string]interface{}{
+					"dockerfile_extensions": map[string]interface{}{
+						"post_build": "group_post_build",
+					},
+				},
+				RunConfig: map[string]interface{}{
+					"log_level": "debug",
+					"exposed_ports": map[string]interface{}{
+						"http": "8080",
+					},
+				},
+			},
+		},
+	}
+
+	manifest := &TestPlanManifest{
+		Name: "foo_plan",
+		Builders: map[string]config.ConfigMap{
+			"docker:go": {
+				"dockerfile_extensions": map[string]interface{}{
+					"pre_mod_download": "manifest_pre_mod_download",
+					"pre_build":        "manifest_pre_build",
+				},
+			},
+		},
+		Runners: map[string]config.ConfigMap{
+			"local:docker": {
+				"exposed_ports": map[string]interface{}{
+					"metrics": "9090",
+				},
+			},
+		},
+		TestCases: []*TestCase{
+			{
+				Name:      "foo_case",
+				Instances: InstanceConstraints{Minimum: 1, Maximum: 100},
+			},
+		},
+	}
+
+	ret, err := c.PrepareForBuild(manifest)
+	require.NoError(t, err)
+
+	// group > global > manifest.
+	require.EqualValues(t, map[string]interface{}{
+		"pre_mod_download": "manifest_pre_mod_download",
+		"pre_build":        "global_pre_build",
+	}, ret.Groups[0].BuildConfig["dockerfile_extensions"])
+	require.EqualValues(t, map[string]interface{}{
+		"pre_mod_download": "manifest_pre_mod_download",
+		"pre_build":        "global_pre_build",
+		"post_build":       "group_post_build",
+	}, ret.Groups[1].BuildConfig["dockerfile_extensions"])
+
+	ret, err = ret.PrepareForRun(manifest)
+	require.NoError(t, err)
+
+	require.EqualValues(t, "info", ret.Groups[0].RunConfig["log_level"])
+	require.EqualValues(t, map[string]interface{}{
+		"metrics": "9090",
+		"pprof":   "6060",
+	}, ret.Groups[0].RunConfig["exposed_ports"])
+
+	require.EqualValues(t, "debug", ret.Groups[1].RunConfig["log_level"])
+	require.EqualValues(t, map[string]interface{}{
+		"metrics": "9090",
+		"pprof":   "6060",
+		"http":    "8080",
+	}, ret.Groups[1].RunConfig["exposed_ports"])
+
+	// the global config is not affected by group overrides.
+	require.EqualValues(t, "info", ret.Global.RunConfig["log_level"])
+	require.Len(t, ret.Global.RunConfig["exposed_ports"], 2)
+}
+
 func TestGroupsMayDefineBuilder(t *testing.T) {
 	g := &Group{
 		ID:      "foo",
@@ -503,3 +620,814 @@ func TestValidateForBuildVerifiesThatBuildersAreDefined(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, ret)
 }
+
+func TestPrepareForRunValidatesTestParams(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			TotalInstances: 1,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Run: Run{
+					TestParams: map[string]string{
+						"count": "many",
+					},
+				},
+			},
+		},
+	}
+
+	manifest := &TestPlanManifest{
+		Name: "foo_plan",
+		Builders: map[string]config.ConfigMap{
+			"docker:go": {},
+		},
+		Runners: map[string]config.ConfigMap{
+			"local:docker": {},
+		},
+		TestCases: []*TestCase{
+			{
+				Name:      "foo_case",
+				Instances: InstanceConstraints{Minimum: 1, Maximum: 100},
+				Parameters: map[string]Parameter{
+					"count": {Type: "int", Default: 1},
+				},
+			},
+		},
+	}
+
+	_, err := c.PrepareForRun(manifest)
+	require.Error(t, err)
+
+	c.Groups[0].Run.TestParams["count"] = "5"
+	ret, err := c.PrepareForRun(manifest)
+	require.NoError(t, err)
+	require.EqualValues(t, "5", ret.Groups[0].Run.TestParams["count"])
+}
+
+func TestPrepareForRunSkipsAbsentDefaults(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			TotalInstances: 1,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+			},
+		},
+	}
+
+	manifest := &TestPlanManifest{
+		Name: "foo_plan",
+		Builders: map[string]config.ConfigMap{
+			"docker:go": {},
+		},
+		Runners: map[string]config.ConfigMap{
+			"local:docker": {},
+		},
+		TestCases: []*TestCase{
+			{
+				Name:      "foo_case",
+				Instances: InstanceConstraints{Minimum: 1, Maximum: 100},
+				Parameters: map[string]Parameter{
+					"some_param": {Type: "int"},
+				},
+			},
+		},
+	}
+
+	ret, err := c.PrepareForRun(manifest)
+	require.NoError(t, err, "a parameter without a default should not be set")
+	require.NotContains(t, ret.Groups[0].Run.TestParams, "some_param")
+
+	manifest.TestCases[0].Parameters["needed"] = Parameter{Type: "int", Required: true}
+	_, err = c.PrepareForRun(manifest)
+	require.Error(t, err, "a required parameter should be set")
+
+	c.Groups[0].Run.TestParams = map[string]string{"needed": "3"}
+	_, err = c.PrepareForRun(manifest)
+	require.NoError(t, err)
+}
+
+func TestPrepareForRunChecksMinMemory(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			TotalInstances: 1,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Resources: Resources{Memory: "256Mi"},
+			},
+		},
+	}
+
+	manifest := &TestPlanManifest{
+		Name: "foo_plan",
+		Builders: map[string]config.ConfigMap{
+			"docker:go": {},
+		},
+		Runners: map[string]config.ConfigMap{
+			"local:docker": {},
+		},
+		TestCases: []*TestCase{
+			{
+				Name:      "foo_case",
+				Instances: InstanceConstraints{Minimum: 1, Maximum: 100},
+				Requires:  Requirements{MinMemory: "512Mi"},
+			},
+		},
+	}
+
+	_, err := c.PrepareForRun(manifest)
+	require.Error(t, err)
+
+	c.Groups[0].Resources.Memory = "1Gi"
+	_, err = c.PrepareForRun(manifest)
+	require.NoError(t, err)
+
+	// groups that don't request memory get the default of the runner.
+	c.Groups[0].Resources.Memory = ""
+	_, err = c.PrepareForRun(manifest)
+	require.NoError(t, err)
+}
+
+func TestExpandSweep(t *testing.T) {
+	c := Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			TotalInstances: 2,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Run: Run{
+					TestParams: map[string]string{"size": "1", "other": "x"},
+				},
+			},
+			{
+				ID:        "b",
+				Instances: Instances{Count: 1},
+			},
+		},
+		Sweep: map[string][]string{
+			"size":  {"1", "2", "4"},
+			"proto": {"tcp", "udp"},
+		},
+	}
+
+	comps, err := c.ExpandSweep()
+	require.NoError(t, err)
+	require.Len(t, comps, 6)
+
+	// params are iterated in name order: proto, then size.
+	require.EqualValues(t, map[string]string{"size": "1", "proto": "tcp", "other": "x"}, comps[0].Groups[0].Run.TestParams)
+	require.EqualValues(t, map[string]string{"size": "1", "proto": "tcp"}, comps[0].Groups[1].Run.TestParams)
+	require.EqualValues(t, map[string]string{"size": "4", "proto": "udp"}, comps[5].Groups[1].Run.TestParams)
+
+	for _, cc := range comps {
+		require.Nil(t, cc.Sweep)
+	}
+
+	// the original composition is left untouched.
+	require.EqualValues(t, map[string]string{"size": "1", "other": "x"}, c.Groups[0].Run.TestParams)
+	require.Nil(t, c.Groups[1].Run.TestParams)
+
+	// no sweep yields the composition itself.
+	c.Sweep = nil
+	comps, err = c.ExpandSweep()
+	require.NoError(t, err)
+	require.Len(t, comps, 1)
+
+	c.Sweep = map[string][]string{"size": {}}
+	_, err = c.ExpandSweep()
+	require.Error(t, err)
+}
+
+func TestExpandSuite(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Cases:          []string{"first", "second"},
+			TotalInstances: 1,
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Run:       Run{TestParams: map[string]string{"size": "1"}},
+			},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+	require.True(t, c.IsSuite())
+
+	comps := c.ExpandSuite()
+	require.Len(t, comps, 2)
+	require.Equal(t, "first", comps[0].Global.Case)
+	require.Equal(t, "second", comps[1].Global.Case)
+	for _, cc := range comps {
+		require.False(t, cc.IsSuite())
+		require.NoError(t, cc.ValidateForRun())
+	}
+
+	// groups are copied.
+	comps[0].Groups[0].Run.TestParams["size"] = "2"
+	require.Equal(t, "1", comps[1].Groups[0].Run.TestParams["size"])
+	require.Equal(t, "1", c.Groups[0].Run.TestParams["size"])
+
+	// a composition is either a suite, or runs a single case.
+	c.Global.Case = "first"
+	require.Error(t, c.ValidateForRun())
+
+	c.Global.Cases = nil
+	require.Len(t, c.ExpandSuite(), 1)
+
+	c.Global.Case = ""
+	require.Error(t, c.ValidateForRun())
+
+	c.Global.Cases = []string{"first", "first"}
+	require.Error(t, c.ValidateForRun())
+}
+
+func TestGroupResourcesValidate(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 1,
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Resources: Resources{CPU: "500m", Memory: "1Gi", Disk: "10G", GPUs: 1},
+			},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+
+	c.Groups[0].Resources.Memory = "lots"
+	require.Error(t, c.ValidateForRun())
+
+	c.Groups[0].Resources.Memory = "1Gi"
+	c.Groups[0].Resources.CPU = "0"
+	require.Error(t, c.ValidateForRun())
+
+	c.Groups[0].Resources.CPU = "500m"
+	c.Groups[0].Resources.GPUs = -1
+	require.Error(t, c.ValidateForRun())
+}
+
+func TestGroupNetworkProfile(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 1,
+		},
+		Groups: []*Group{
+			{
+				ID:        "a",
+				Instances: Instances{Count: 1},
+				Network:   &NetworkProfile{LinkProfile: LinkProfile{Latency: "100ms", Jitter: "10ms", Bandwidth: "10Mbit", Loss: 0.5}},
+			},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+
+	shape, err := c.Groups[0].Network.LinkShape()
+	require.NoError(t, err)
+	require.Equal(t, 100*time.Millisecond, shape.Latency)
+	require.Equal(t, 10*time.Millisecond, shape.Jitter)
+	require.Equal(t, uint64(1250000), shape.Bandwidth)
+	require.Equal(t, float32(0.5), shape.Loss)
+
+	for _, p := range []LinkProfile{
+		{Latency: "soon"},
+		{Jitter: "-1ms"},
+		{Bandwidth: "10"},
+		{Bandwidth: "fast Mbit"},
+		{Bandwidth: "0Gbps"},
+		{Loss: 101},
+	} {
+		c.Groups[0].Network = &NetworkProfile{LinkProfile: p}
+		require.Error(t, c.ValidateForRun(), "%+v", p)
+	}
+
+	for _, nat := range []NATType{NATFullCone, NATRestricted, NATPortRestricted, NATSymmetric} {
+		c.Groups[0].Network = &NetworkProfile{NAT: nat}
+		require.NoError(t, c.ValidateForRun(), nat)
+	}
+	c.Groups[0].Network = &NetworkProfile{NAT: "carrier-grade"}
+	require.Error(t, c.ValidateForRun())
+}
+
+func TestGroupNetworkTimeline(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 1
+
+[[groups]]
+id = "a"
+instances = { count = 1 }
+
+  [groups.network]
+  bandwidth = "1Gbps"
+
+    [[groups.network.timeline]]
+    at = "60s"
+    bandwidth = "10Mbps"
+
+    [[groups.network.timeline]]
+    at = "120s"
+    bandwidth = "10Mbps"
+    loss = 5.0
+    state = "lossy"
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	steps, err := comp.Groups[0].Network.Steps()
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	require.Equal(t, 60*time.Second, steps[0].At)
+	require.Equal(t, uint64(1250000), steps[0].Shape.Bandwidth)
+	require.Equal(t, "network-timeline-0", steps[0].State)
+	require.Equal(t, float32(5), steps[1].Shape.Loss)
+	require.Equal(t, "lossy", steps[1].State)
+
+	// steps must be in order, and have a time.
+	comp.Groups[0].Network.Timeline[1].At = "30s"
+	require.Error(t, comp.ValidateForRun())
+	comp.Groups[0].Network.Timeline[1].At = ""
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestGroupNetworkLinks(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 2
+
+[[groups]]
+id = "local"
+instances = { count = 1 }
+
+  [[groups.network.links]]
+  group = "local"
+  latency = "10ms"
+
+  [[groups.network.links]]
+  group = "remote"
+  latency = "200ms"
+
+  [[groups.network.links]]
+  subnet = "16.0.1.2/32"
+  loss = 5.0
+
+[[groups]]
+id = "remote"
+instances = { count = 1 }
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	rules, err := comp.Groups[0].Network.Rules()
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	require.Equal(t, "remote", rules[1].Group)
+	require.Equal(t, 200*time.Millisecond, rules[1].Shape.Latency)
+	require.Equal(t, "16.0.1.2/32", rules[2].Subnet.String())
+
+	links := comp.Groups[0].Network.Links
+
+	// links are to existing groups, or to valid subnets, but not both.
+	links[1].Group = "elsewhere"
+	require.Error(t, comp.ValidateForRun())
+	links[1].Group, links[1].Subnet = "remote", "16.0.0.0/16"
+	require.Error(t, comp.ValidateForRun())
+	links[1].Group = ""
+	require.NoError(t, comp.ValidateForRun())
+	links[2].Subnet = "16.0.1.2"
+	require.Error(t, comp.ValidateForRun())
+
+	// and there is one link per group or subnet.
+	links[2].Subnet = "16.0.0.0/16"
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestGroupNetworkCapture(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 2
+
+[[groups]]
+id = "single"
+instances = { count = 2 }
+
+  [groups.network.capture]
+  start = "30s"
+  duration = "1m"
+  instances = 1
+  max_file_size = 50
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	capture := comp.Groups[0].Network.Capture
+	spec, err := capture.Spec()
+	require.NoError(t, err)
+	require.Equal(t, &CaptureSpec{
+		Start:       30 * time.Second,
+		Duration:    time.Minute,
+		Instances:   1,
+		MaxFileSize: 50 << 20,
+		MaxFiles:    10,
+		Snaplen:     65535,
+	}, spec)
+
+	capture.Duration = "forever"
+	require.Error(t, comp.ValidateForRun())
+	capture.Duration, capture.MaxFiles = "", -1
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestGroupNetworkMetrics(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 2
+
+[[groups]]
+id = "single"
+instances = { count = 2 }
+
+  [groups.network]
+  metrics = "10s"
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	d, err := comp.Groups[0].Network.MetricsInterval()
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, d)
+
+	g := &RunGroup{NetworkMetrics: d}
+	require.Equal(t, "10s", g.NetworkProfileEnv()[EnvNetworkMetrics])
+
+	comp.Groups[0].Network.Metrics = "often"
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestGroupNetworkDNS(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 2
+
+[[groups]]
+id = "single"
+instances = { count = 2 }
+
+  [groups.network.dns]
+
+    [[groups.network.dns.failures]]
+    name = "single-1"
+    error = "nxdomain"
+
+    [[groups.network.dns.failures]]
+    name = "single.run.local"
+    delay = "2s"
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	dns := comp.Groups[0].Network.DNS
+	spec, err := dns.Spec()
+	require.NoError(t, err)
+	require.Equal(t, &DNSSpec{
+		Domain: DefaultDNSDomain,
+		Failures: []DNSFailure{
+			{Name: "single-1", Error: DNSNXDomain},
+			{Name: "single.run.local", Delay: 2 * time.Second},
+		},
+	}, spec)
+
+	dns.Failures[0].Error = "refused"
+	require.Error(t, comp.ValidateForRun())
+	dns.Failures[0].Error, dns.Failures[0].Delay = "", ""
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestGroupChaos(t *testing.T) {
+	comp, _, err := DecodeComposition(`
+version = 2
+
+[global]
+plan = "network"
+case = "ping-pong"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 3
+
+[[groups]]
+id = "single"
+instances = { count = 3 }
+
+  [[groups.chaos]]
+  at = "60s"
+  action = "pause"
+  percent = 50
+  duration = "10s"
+
+  [[groups.chaos]]
+  at = "2m"
+  action = "clock-signal"
+  skew = "-5m"
+`)
+	require.NoError(t, err)
+	require.NoError(t, comp.ValidateForRun())
+
+	a, err := comp.Groups[0].Chaos[0].Spec()
+	require.NoError(t, err)
+	require.Equal(t, &ChaosAction{At: time.Minute, Action: ChaosPause, Percent: 50, Duration: 10 * time.Second}, a)
+	require.Equal(t, 2, a.Instances(3))
+
+	a, err = comp.Groups[0].Chaos[1].Spec()
+	require.NoError(t, err)
+	require.Equal(t, -5*time.Minute, a.Skew)
+	require.Equal(t, 3, a.Instances(3))
+
+	comp.Groups[0].Chaos[0].Duration = ""
+	require.Error(t, comp.ValidateForRun(), "pauses require a duration")
+	comp.Groups[0].Chaos[0].Duration, comp.Groups[0].Chaos[0].Action = "10s", "explode"
+	require.Error(t, comp.ValidateForRun())
+	comp.Groups[0].Chaos[0].Action, comp.Groups[0].Chaos[0].Percent = ChaosKill, 150
+	require.Error(t, comp.ValidateForRun())
+}
+
+func TestStaggerValidate(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 1,
+			Stagger:        &Stagger{Batch: 100, Interval: "10s"},
+		},
+		Groups: []*Group{{ID: "a", Instances: Instances{Count: 1}}},
+	}
+	require.NoError(t, c.ValidateForRun())
+	require.Equal(t, 10*time.Second, c.Global.Stagger.IntervalDuration())
+
+	c.Global.Stagger = &Stagger{Batch: 100, State: "bootstrapped"}
+	require.NoError(t, c.ValidateForRun())
+
+	for _, s := range []*Stagger{
+		{Interval: "10s"},
+		{Batch: 100},
+		{Batch: 100, Interval: "soon"},
+		{Batch: 100, Interval: "-1s"},
+	} {
+		c.Global.Stagger = s
+		require.Error(t, c.ValidateForRun(), "%+v", s)
+	}
+}
+
+func TestGroupStartAfterValidate(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 2,
+		},
+		Groups: []*Group{
+			{ID: "bootstrap", Instances: Instances{Count: 1}},
+			{ID: "clients", Instances: Instances{Count: 1}},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+
+	c.Groups[1].StartAfter = &StartAfter{Group: "bootstrap", State: "ready", Delay: "5s"}
+	require.NoError(t, c.ValidateForRun())
+
+	c.Groups[1].StartAfter = &StartAfter{Group: "unknown"}
+	require.Error(t, c.ValidateForRun())
+
+	c.Groups[1].StartAfter = &StartAfter{State: "ready"}
+	require.Error(t, c.ValidateForRun())
+
+	c.Groups[1].StartAfter = &StartAfter{Delay: "soon"}
+	require.Error(t, c.ValidateForRun())
+
+	c.Groups[1].StartAfter = &StartAfter{}
+	require.Error(t, c.ValidateForRun())
+
+	// cycles are rejected.
+	c.Groups[0].StartAfter = &StartAfter{Group: "clients"}
+	c.Groups[1].StartAfter = &StartAfter{Group: "bootstrap"}
+	require.Error(t, c.ValidateForRun())
+}
+
+func TestRunTimeout(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 2,
+		},
+		Groups: []*Group{
+			{ID: "a", Instances: Instances{Count: 1}},
+			{ID: "b", Instances: Instances{Count: 1}},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+	require.Zero(t, c.RunTimeout())
+
+	c.Global.RunTimeout = "30m"
+	c.Groups[0].Timeout = "10m"
+	require.NoError(t, c.ValidateForRun())
+	require.Equal(t, 30*time.Minute, c.RunTimeout())
+	require.Equal(t, 10*time.Minute, c.Groups[0].TimeoutDuration())
+
+	// the run can't outlast all its groups.
+	c.Groups[1].Timeout = "20m"
+	require.Equal(t, 20*time.Minute, c.RunTimeout())
+
+	c.Global.RunTimeout = "5m"
+	require.Equal(t, 5*time.Minute, c.RunTimeout())
+
+	c.Global.RunTimeout = "forever"
+	require.Error(t, c.ValidateForRun())
+
+	c.Global.RunTimeout = ""
+	c.Groups[1].Timeout = "0s"
+	require.Error(t, c.ValidateForRun())
+}
+
+func TestRetries(t *testing.T) {
+	infra := NewInfraError(fmt.Errorf("image pull failed"))
+	require.Equal(t, FailureInfra, FailureClass(fmt.Errorf("run failed: %w", infra)))
+	require.Equal(t, "", FailureClass(fmt.Errorf("test failed")))
+	require.EqualError(t, infra, "infrastructure failure: image pull failed")
+
+	r := &Retries{Attempts: 3}
+	require.NoError(t, r.Validate())
+	require.True(t, r.Retry(1, infra))
+	require.True(t, r.Retry(2, infra))
+	require.False(t, r.Retry(3, infra))
+	require.False(t, r.Retry(1, fmt.Errorf("test failed")))
+	require.False(t, r.Retry(1, nil))
+
+	// runs without a policy aren't retried.
+	require.False(t, (*Retries)(nil).Retry(1, infra))
+
+	require.Error(t, (&Retries{Attempts: 0}).Validate())
+	require.Error(t, (&Retries{Attempts: 2, On: []string{"flakes"}}).Validate())
+}
+
+func TestAllowFailures(t *testing.T) {
+	c := &Composition{
+		Global: Global{
+			Plan:           "foo_plan",
+			Case:           "foo_case",
+			Builder:        "docker:go",
+			Runner:         "local:docker",
+			TotalInstances: 50,
+		},
+		Groups: []*Group{
+			{ID: "a", Instances: Instances{Count: 50}},
+		},
+	}
+	require.NoError(t, c.ValidateForRun())
+	require.Zero(t, c.AllowedFailures())
+
+	c.Global.AllowFailures = "3"
+	require.NoError(t, c.ValidateForRun())
+	require.Equal(t, 3, c.AllowedFailures())
+
+	// percentages of the total are rounded down.
+	c.Global.AllowFailures = "5%"
+	require.NoError(t, c.ValidateForRun())
+	require.Equal(t, 2, c.AllowedFailures())
+
+	for _, v := range []string{"-1", "101%", "some", "x%"} {
+		c.Global.AllowFailures = v
+		require.Error(t, c.ValidateForRun(), v)
+	}
+}
+
+func TestDependenciesUnmarshalTOML(t *testing.T) {
+	var tables struct {
+		Dependencies Dependencies `toml:"dependencies"`
+	}
+	_, err := toml.Decode(`
+[[dependencies]]
+module = "github.com/libp2p/go-libp2p"
+target = "github.com/user/go-libp2p"
+version = "v0.19.1"
+`, &tables)
+	require.NoError(t, err)
+	require.Equal(t, Dependencies{
+		{Module: "github.com/libp2p/go-libp2p", Target: "github.com/user/go-libp2p", Version: "v0.19.1"},
+	}, tables.Dependencies)
+
+	var mapped struct {
+		Dependencies Dependencies `toml:"dependencies"`
+	}
+	_, err = toml.Decode(`
+[dependencies]
+"github.com/libp2p/go-libp2p" = "github.com/user/go-libp2p@v0.19.1"
+"github.com/libp2p/go-libp2p-kad-dht" = "v0.15.0"
+"github.com/libp2p/go-libp2p-core" = "../go-libp2p-core"
+`, &mapped)
+	require.NoError(t, err)
+	require.Equal(t, Dependencies{
+		{Module: "github.com/libp2p/go-libp2p", Target: "github.com/user/go-libp2p", Version: "v0.19.1"},
+		{Module: "github.com/libp2p/go-libp2p-core", Target: "../go-libp2p-core"},
+		{Module: "github.com/libp2p/go-libp2p-kad-dht", Version: "v0.15.0"},
+	}, mapped.Dependencies)
+	require.True(t, mapped.Dependencies[1].IsLocal())
+	require.NoError(t, mapped.Dependencies.Validate())
+}
+
+func TestDependenciesValidate(t *testing.T) {
+	require.Error(t, Dependencies{{Version: "v1.0.0"}}.Validate())
+	require.Error(t, Dependencies{{Module: "github.com/a/b"}}.Validate())
+	require.Error(t, Dependencies{
+		{Module: "github.com/a/b", Version: "v1.0.0"},
+		{Module: "github.com/a/b", Version: "v1.1.0"},
+	}.Validate())
+	require.NoError(t, Dependencies{{Module: "github.com/a/b", Target: "/src/b"}}.Validate())
+}
+
+func TestDependenciesApplyDefaultsKeepsTargets(t *testing.T) {
+	defaults := Dependencies{
+		{Module: "github.com/a/b", Target: "github.com/user/b", Version: "v1.0.0"},
+		{Module: "github.com/a/c", Version: "v2.0.0"},
+	}
+	deps := Dependencies{{Module: "github.com/a/c", Version: "v2.1.0"}}.ApplyDefaults(defaults)
+	require.ElementsMatch(t, Dependencies{
+		{Module: "github.com/a/b", Target: "github.com/user/b", Version: "v1.0.0"},
+		{Module: "github.com/a/c", Version: "v2.1.0"},
+	}, deps)
+}
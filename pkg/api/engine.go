@@ -3,6 +3,8 @@ package api
 import (
 	"context"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/testground/testground/pkg/config"
@@ -44,10 +46,36 @@ type TasksFilters struct {
 	Before   *time.Time
 	TestPlan string
 	TestCase string
+	User     string // the user who created the tasks, if set
+}
+
+// SplitRunID returns the ID of the task of a run, and the number of the case
+// of the run, if the task is a suite: the cases of a suite run with IDs
+// <task id>-<n>.
+func SplitRunID(runID string) (taskID string, n int) {
+	if i := strings.LastIndex(runID, "-"); i > 0 {
+		if v, err := strconv.Atoi(runID[i+1:]); err == nil && v > 0 {
+			return runID[:i], v
+		}
+	}
+	return runID, 0
+}
+
+// RunsFilters select the records of the run history. Empty fields match all
+// records.
+type RunsFilters struct {
+	Type     task.Type
+	TestPlan string
+	TestCase string
+	Since    *time.Time
+	Limit    int    // the number of most recent records to return, if positive
+	User     string // the user who created the tasks, if set
+	Schedule string // the schedule that queued the tasks, if set
 }
 
 type Engine interface {
 	TasksManager
+	SchedulesManager
 
 	BuilderByName(name string) (Builder, bool)
 	RunnerByName(name string) (Runner, bool)
@@ -59,9 +87,11 @@ type Engine interface {
 	QueueRun(request *RunRequest, sources *UnpackedSources) (string, error)
 
 	DoBuildPurge(ctx context.Context, builder, plan string, ow *rpc.OutputWriter) error
+	DoBuildArtifacts(ctx context.Context, builder string, filter ArtifactFilter, remove bool, ow *rpc.OutputWriter) ([]*Artifact, error)
 	DoCollectOutputs(ctx context.Context, runID string, ow *rpc.OutputWriter) error
 	DoTerminate(ctx context.Context, ctype ComponentType, ref string, ow *rpc.OutputWriter) error
-	DoHealthcheck(ctx context.Context, runner string, fix bool, ow *rpc.OutputWriter) (*HealthcheckReport, error)
+	DoCleanup(ctx context.Context, runner string, runIDs []string, ow *rpc.OutputWriter) ([]string, error)
+	DoHealthcheck(ctx context.Context, ctype ComponentType, ref string, fix bool, ow *rpc.OutputWriter) (*HealthcheckReport, error)
 
 	EnvConfig() config.EnvConfig
 	Context() context.Context
@@ -69,8 +99,22 @@ type Engine interface {
 
 type TasksManager interface {
 	Tasks(filters TasksFilters) ([]task.Task, error)
+	Runs(filters RunsFilters) ([]*task.Record, error)
 	GetTask(id string) (*task.Task, error)
 	Kill(taskId string) error
 	DeleteTask(taskId string) error
 	Logs(ctx context.Context, taskId string, follow bool, cancel bool, w io.Writer) (*task.Task, error)
+
+	// DeadLetters returns the tasks created by the user, or by every user if
+	// empty, that were quarantined for crashing the daemon too many times.
+	DeadLetters(user string) ([]*task.Task, error)
+	RequeueDeadLetter(taskId string) (*task.Task, error)
+}
+
+// SchedulesManager manages the schedules of recurring runs.
+type SchedulesManager interface {
+	CreateSchedule(request *ScheduleRequest, sources *UnpackedSources) (*task.Schedule, error)
+	Schedules(user string) ([]*task.Schedule, error)
+	GetSchedule(id string) (*task.Schedule, error)
+	DeleteSchedule(id string) error
 }
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"time"
+
+	"github.com/testground/testground/pkg/task"
+)
+
+// TaskEventType is a state transition of a task.
+type TaskEventType string
+
+const (
+	EventQueued   TaskEventType = "queued"
+	EventStarted  TaskEventType = "started"
+	EventFinished TaskEventType = "finished" // the task succeeded
+	EventFailed   TaskEventType = "failed"
+	EventCanceled TaskEventType = "canceled"
+)
+
+// TaskEvents are the types of the events that the daemon notifies.
+var TaskEvents = []TaskEventType{EventQueued, EventStarted, EventFinished, EventFailed, EventCanceled}
+
+// TaskEvent is the payload that the daemon posts to the webhooks it's
+// configured with, when the state of a task changes.
+type TaskEvent struct {
+	Event     TaskEventType  `json:"event"`
+	Time      time.Time      `json:"time"`
+	TaskID    string         `json:"task_id"`
+	Type      task.Type      `json:"type"`
+	Name      string         `json:"name"`
+	Plan      string         `json:"plan"`
+	Case      string         `json:"case,omitempty"`
+	Runner    string         `json:"runner,omitempty"`
+	CreatedBy task.CreatedBy `json:"created_by"`
+
+	// Outcome, Summary and Took are set once the task is done.
+	Outcome task.Outcome `json:"outcome,omitempty"`
+	Summary string       `json:"summary,omitempty"`
+	Took    string       `json:"took,omitempty"`
+	Error   string       `json:"error,omitempty"`
+
+	// Links are the URLs of the pages of the task on the daemon, keyed by
+	// "task", "logs" and "outputs". They're set when the daemon has a root
+	// URL.
+	Links map[string]string `json:"links,omitempty"`
+}
@@ -79,6 +79,31 @@ func (hr *HealthcheckReport) FixesSucceeded() bool {
 	return true
 }
 
+// Healthy returns true if every check succeeded, or failed and was then
+// successfully fixed.
+func (hr *HealthcheckReport) Healthy() bool {
+	fixed := make(map[string]bool, len(hr.Fixes))
+	for _, f := range hr.Fixes {
+		fixed[f.Name] = f.Status == HealthcheckStatusOK
+	}
+
+	for _, c := range hr.Checks {
+		switch c.Status {
+		case HealthcheckStatusOK, HealthcheckStatusUnnecessary:
+			continue
+		case HealthcheckStatusOmitted:
+			// omitted checks depend on a required check that failed, which
+			// is reported separately.
+			continue
+		default:
+			if !fixed[c.Name] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (hr *HealthcheckReport) String() string {
 	b := new(strings.Builder)
 
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthcheckReportHealthy(t *testing.T) {
+	report := &HealthcheckReport{
+		Checks: []HealthcheckItem{
+			{Name: "network", Status: HealthcheckStatusOK},
+			{Name: "redis", Status: HealthcheckStatusFailed},
+		},
+	}
+	require.False(t, report.Healthy())
+
+	report.Fixes = []HealthcheckItem{
+		{Name: "network", Status: HealthcheckStatusUnnecessary},
+		{Name: "redis", Status: HealthcheckStatusOK},
+	}
+	require.True(t, report.Healthy())
+
+	report.Checks = append(report.Checks, HealthcheckItem{Name: "sidecar", Status: HealthcheckStatusAborted})
+	require.False(t, report.Healthy())
+}
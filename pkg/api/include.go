@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/testground/testground/pkg/config"
+
+	"github.com/imdario/mergo"
+)
+
+// MaxIncludeDepth is the maximum depth of nested includes.
+const MaxIncludeDepth = 8
+
+// IncludeLoader returns the source of the composition fragment at ref, a
+// file path or an http(s) URL, ready to be decoded; it's where the loader
+// renders templates.
+type IncludeLoader func(ref string) (string, error)
+
+// ResolveIncludes merges the fragments listed in the Include field of the
+// composition into it, recursively, and clears the field. Relative
+// references are resolved against base, the file path or URL of the
+// composition.
+//
+// Fragments are partial compositions. The composition inherits the settings
+// it doesn't set from them, tables being merged recursively, and the groups
+// it doesn't define; a group it defines with the same ID as a group of a
+// fragment inherits the settings of that group. Fragments are merged in
+// order, so that earlier ones take precedence over later ones.
+//
+// It returns the warnings found while decoding the fragments.
+func (c *Composition) ResolveIncludes(base string, load IncludeLoader) ([]string, error) {
+	if !IsIncludeURL(base) {
+		base = filepath.Clean(base)
+	}
+	return c.resolveIncludes(base, load, []string{base})
+}
+
+func (c *Composition) resolveIncludes(base string, load IncludeLoader, stack []string) ([]string, error) {
+	includes := c.Include
+	c.Include = nil
+
+	var warnings []string
+	for _, inc := range includes {
+		ref, err := resolveRef(base, inc)
+		if err != nil {
+			return warnings, fmt.Errorf("invalid include %q: %w", inc, err)
+		}
+		for _, s := range stack {
+			if s == ref {
+				return warnings, fmt.Errorf("include cycle: %s", strings.Join(append(stack, ref), " -> "))
+			}
+		}
+		if len(stack) > MaxIncludeDepth {
+			return warnings, fmt.Errorf("includes nested deeper than %d levels at %s", MaxIncludeDepth, ref)
+		}
+
+		src, err := load(ref)
+		if err != nil {
+			return warnings, fmt.Errorf("failed to load include %s: %w", ref, err)
+		}
+		frag, ws, err := DecodeComposition(src)
+		if err != nil {
+			return warnings, fmt.Errorf("failed to process include %s: %w", ref, err)
+		}
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", ref, w))
+		}
+
+		nested, err := frag.resolveIncludes(ref, load, append(stack, ref))
+		warnings = append(warnings, nested...)
+		if err != nil {
+			return warnings, err
+		}
+
+		if err := c.inherit(frag); err != nil {
+			return warnings, fmt.Errorf("failed to merge include %s: %w", ref, err)
+		}
+	}
+	return warnings, nil
+}
+
+// inherit fills in the settings of c that are not set from the fragment.
+func (c *Composition) inherit(frag *Composition) error {
+	version := c.Version
+
+	var (
+		bcfg = config.Inherit(c.Global.BuildConfig, frag.Global.BuildConfig)
+		rcfg = config.Inherit(c.Global.RunConfig, frag.Global.RunConfig)
+	)
+	fragGroups := frag.Groups
+	frag.Groups = nil
+
+	if err := mergo.Merge(c, frag); err != nil {
+		return err
+	}
+	c.Version = version
+	c.Global.BuildConfig, c.Global.RunConfig = bcfg, rcfg
+
+	// groups of the fragment come first, in the order they are included.
+	groups := make(Groups, 0, len(fragGroups)+len(c.Groups))
+	own := make(map[string]*Group, len(c.Groups))
+	for _, g := range c.Groups {
+		own[g.ID] = g
+	}
+	for _, fg := range fragGroups {
+		g, ok := own[fg.ID]
+		if !ok {
+			groups = append(groups, fg)
+			continue
+		}
+		var (
+			bcfg = config.Inherit(g.BuildConfig, fg.BuildConfig)
+			rcfg = config.Inherit(g.RunConfig, fg.RunConfig)
+		)
+		if err := mergo.Merge(g, fg); err != nil {
+			return fmt.Errorf("group %s: %w", g.ID, err)
+		}
+		g.BuildConfig, g.RunConfig = bcfg, rcfg
+		groups = append(groups, g)
+		delete(own, g.ID)
+	}
+	for _, g := range c.Groups {
+		if _, ok := own[g.ID]; ok {
+			groups = append(groups, g)
+		}
+	}
+	c.Groups = groups
+	return nil
+}
+
+// resolveRef resolves the include ref against the file path or URL base.
+func resolveRef(base, ref string) (string, error) {
+	if IsIncludeURL(ref) {
+		return ref, nil
+	}
+	if IsIncludeURL(base) {
+		b, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		r, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return b.ResolveReference(r).String(), nil
+	}
+	if filepath.IsAbs(ref) {
+		return filepath.Clean(ref), nil
+	}
+	return filepath.Join(filepath.Dir(base), ref), nil
+}
+
+// IsIncludeURL returns whether the include ref is an http(s) URL, rather than
+// a file path.
+func IsIncludeURL(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
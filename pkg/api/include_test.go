@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveIncludes(t *testing.T) {
+	files := map[string]string{
+		"/comps/shared/cluster.toml": `
+version = 2
+include = ["base.toml"]
+
+[global]
+  runner = "cluster:k8s"
+  [global.run_config]
+    keep_service = true
+    provider = "aws"
+
+[[groups]]
+  id = "bootstrap"
+  resources = { memory = "256Mi" }
+  [groups.instances]
+    count = 1
+`,
+		"/comps/shared/base.toml": `
+version = 2
+
+[global]
+  plan = "network"
+  runner = "local:docker"
+  builder = "docker:go"
+`,
+	}
+	load := func(ref string) (string, error) {
+		src, ok := files[ref]
+		if !ok {
+			return "", fmt.Errorf("no such file: %s", ref)
+		}
+		return src, nil
+	}
+
+	comp, _, err := DecodeComposition(`
+version = 2
+include = ["shared/cluster.toml"]
+
+[global]
+  case = "ping-pong"
+  total_instances = 3
+  [global.run_config]
+    provider = "gcp"
+
+[[groups]]
+  id = "peers"
+  [groups.instances]
+    count = 1
+
+[[groups]]
+  id = "bootstrap"
+  [groups.instances]
+    count = 2
+`)
+	require.NoError(t, err)
+
+	warnings, err := comp.ResolveIncludes("/comps/main.toml", load)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Empty(t, comp.Include)
+
+	// the closest settings win; tables are merged.
+	require.Equal(t, "network", comp.Global.Plan)
+	require.Equal(t, "cluster:k8s", comp.Global.Runner)
+	require.Equal(t, "docker:go", comp.Global.Builder)
+	require.Equal(t, map[string]interface{}{"keep_service": true, "provider": "gcp"}, comp.Global.RunConfig)
+
+	// included groups come first, and groups defined again inherit from them.
+	require.Len(t, comp.Groups, 2)
+	require.Equal(t, "bootstrap", comp.Groups[0].ID)
+	require.EqualValues(t, 2, comp.Groups[0].Instances.Count)
+	require.Equal(t, "256Mi", comp.Groups[0].Resources.Memory)
+	require.Equal(t, "peers", comp.Groups[1].ID)
+
+	require.NoError(t, comp.ValidateForBuild())
+}
+
+func TestResolveIncludesErrors(t *testing.T) {
+	files := map[string]string{
+		"/a.toml": "version = 2\ninclude = [\"b.toml\"]\n",
+		"/b.toml": "version = 2\ninclude = [\"a.toml\"]\n",
+		"/c.toml": "version = 2\n[global]\n  unknown = 1\n",
+	}
+	load := func(ref string) (string, error) {
+		src, ok := files[ref]
+		if !ok {
+			return "", fmt.Errorf("no such file: %s", ref)
+		}
+		return src, nil
+	}
+
+	comp := &Composition{Include: []string{"a.toml"}}
+	_, err := comp.ResolveIncludes("/main.toml", load)
+	require.EqualError(t, err, "include cycle: /main.toml -> /a.toml -> /b.toml -> /a.toml")
+
+	comp = &Composition{Include: []string{"missing.toml"}}
+	_, err = comp.ResolveIncludes("/main.toml", load)
+	require.Error(t, err)
+
+	comp = &Composition{Include: []string{"c.toml"}}
+	_, err = comp.ResolveIncludes("/main.toml", load)
+	require.Error(t, err)
+
+	// unresolved includes don't validate.
+	comp = &Composition{Include: []string{"c.toml"}}
+	require.Error(t, comp.ValidateForBuild())
+}
+
+func TestResolveRef(t *testing.T) {
+	for _, tc := range []struct{ base, ref, want string }{
+		{"comps/main.toml", "shared/cluster.toml", "comps/shared/cluster.toml"},
+		{"comps/main.toml", "/etc/cluster.toml", "/etc/cluster.toml"},
+		{"comps/main.toml", "https://example.com/cluster.toml", "https://example.com/cluster.toml"},
+		{"https://example.com/comps/main.toml", "../shared/cluster.toml", "https://example.com/shared/cluster.toml"},
+	} {
+		got, err := resolveRef(tc.base, tc.ref)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+}
@@ -1,17 +1,25 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"text/tabwriter"
+	"time"
 
 	"github.com/testground/testground/pkg/config"
 
 	"github.com/mitchellh/go-wordwrap"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // TestPlanManifest represents a test plan known by the system.
 type TestPlanManifest struct {
+	// Version is the schema version of this manifest; see SchemaVersion.
+	Version int `toml:"version"`
+
 	Name      string
 	Builders  map[string]config.ConfigMap `toml:"builders"`
 	Runners   map[string]config.ConfigMap `toml:"runners"`
@@ -31,6 +39,84 @@ type TestCase struct {
 	Instances InstanceConstraints
 	// Parameters that can be passed to this test case.
 	Parameters map[string]Parameter `toml:"params"`
+	// Requires are the capabilities this test case needs from the runner.
+	Requires Requirements `toml:"requires"`
+}
+
+// Requirements are the capabilities a test case needs from the runner, as
+// declared in the [testcases.requires] table of the manifest. The engine
+// refuses to run a test case on a runner that can't meet them.
+type Requirements struct {
+	// TrafficShaping requires a sidecar able to shape the traffic of the
+	// instances through the network API of the sdk.
+	TrafficShaping bool `toml:"traffic_shaping"`
+
+	// IPv6 requires the data network to carry IPv6 traffic.
+	IPv6 bool `toml:"ipv6"`
+
+	// Privileged requires the instances to run in privileged mode, e.g. to
+	// change kernel settings.
+	Privileged bool `toml:"privileged"`
+
+	// MinMemory is the minimum memory each instance needs, as a Kubernetes
+	// quantity, e.g. "512Mi". Groups must not request less.
+	MinMemory string `toml:"min_memory"`
+
+	// GPUs requires the instances to have GPUs. Groups that request GPUs in
+	// their resources require them too.
+	GPUs bool `toml:"gpus"`
+}
+
+// Validate checks that the requirements are well-formed.
+func (r Requirements) Validate() error {
+	if r.MinMemory == "" {
+		return nil
+	}
+	if _, err := resource.ParseQuantity(r.MinMemory); err != nil {
+		return fmt.Errorf("invalid min_memory quantity %q: %w", r.MinMemory, err)
+	}
+	return nil
+}
+
+// Features returns the names of the features required, e.g. for messages.
+func (r Requirements) Features() []string {
+	var res []string
+	if r.TrafficShaping {
+		res = append(res, "traffic shaping")
+	}
+	if r.IPv6 {
+		res = append(res, "ipv6")
+	}
+	if r.Privileged {
+		res = append(res, "privileged mode")
+	}
+	if r.GPUs {
+		res = append(res, "gpus")
+	}
+	return res
+}
+
+// Merge returns the requirements that satisfy both r and o, i.e. all the
+// features of either, and the larger minimum memory. Suites need the merged
+// requirements of their test cases.
+func (r Requirements) Merge(o Requirements) Requirements {
+	res := Requirements{
+		TrafficShaping: r.TrafficShaping || o.TrafficShaping,
+		IPv6:           r.IPv6 || o.IPv6,
+		Privileged:     r.Privileged || o.Privileged,
+		GPUs:           r.GPUs || o.GPUs,
+		MinMemory:      r.MinMemory,
+	}
+	if res.MinMemory == "" {
+		res.MinMemory = o.MinMemory
+	} else if o.MinMemory != "" {
+		a, err1 := resource.ParseQuantity(r.MinMemory)
+		b, err2 := resource.ParseQuantity(o.MinMemory)
+		if err1 == nil && err2 == nil && b.Cmp(a) > 0 {
+			res.MinMemory = o.MinMemory
+		}
+	}
+	return res
 }
 
 // Parameter is metadata about a test case parameter.
@@ -39,12 +125,58 @@ type Parameter struct {
 	Description string `toml:"desc"`
 	Unit        string
 	Default     interface{}
+
+	// Required marks this parameter as mandatory; a run is rejected if no
+	// value is supplied and the manifest declares no default.
+	Required bool
+
+	// Allowed enumerates the values this parameter can take. If empty, any
+	// value of the declared type is accepted.
+	Allowed []string `toml:"allowed"`
+}
+
+// Validate checks that the supplied encoded value conforms to the declared
+// type and, if set, to the allowed values of this parameter. Unknown types are
+// not checked, so that SDK-specific types keep working.
+func (p Parameter) Validate(value string) error {
+	var err error
+	switch p.Type {
+	case "int":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	case "json":
+		if !json.Valid([]byte(value)) {
+			err = fmt.Errorf("not valid json")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("value %q is not of type %s: %w", value, p.Type, err)
+	}
+
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+	for _, a := range p.Allowed {
+		if a == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("value %q is not allowed; allowed: %v", value, p.Allowed)
 }
 
 // InstanceConstraints expresses how many instances this test case can run.
 type InstanceConstraints struct {
 	Minimum int `toml:"min"`
 	Maximum int `toml:"max"`
+
+	// Default is the number of instances the test case is usually run with;
+	// it is informational.
+	Default int `toml:"default"`
 }
 
 // TestCaseByName returns a test case by name.
@@ -57,6 +189,45 @@ func (tp *TestPlanManifest) TestCaseByName(name string) (idx int, tc *TestCase,
 	return -1, nil, false
 }
 
+// ValidateParams validates the supplied test params against the parameters
+// declared by this test case. Params that are not declared are not validated;
+// use UndeclaredParams to find them.
+func (tc *TestCase) ValidateParams(params map[string]string) error {
+	names := make([]string, 0, len(tc.Parameters))
+	for n := range tc.Parameters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		p := tc.Parameters[n]
+		v, ok := params[n]
+		if !ok {
+			if p.Required {
+				return fmt.Errorf("required parameter %s was not set", n)
+			}
+			continue
+		}
+		if err := p.Validate(v); err != nil {
+			return fmt.Errorf("invalid parameter %s: %w", n, err)
+		}
+	}
+	return nil
+}
+
+// UndeclaredParams returns the sorted names of the supplied test params that
+// are not declared by this test case; they are usually typos.
+func (tc *TestCase) UndeclaredParams(params map[string]string) []string {
+	var res []string
+	for n := range params {
+		if _, ok := tc.Parameters[n]; !ok {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
 func (tp *TestPlanManifest) HasBuilder(name string) bool {
 	for k := range tp.Builders {
 		if k == name {
@@ -107,6 +278,15 @@ func (tc *TestCase) Describe(w io.Writer) {
 	_, _ = fmt.Fprintf(w, "  Instances:\n")
 	_, _ = fmt.Fprintf(w, "    minimum: %d\n", tc.Instances.Minimum)
 	_, _ = fmt.Fprintf(w, "    maximum: %d\n", tc.Instances.Maximum)
+	if r := tc.Requires; r != (Requirements{}) {
+		_, _ = fmt.Fprintf(w, "  Requires:\n")
+		for _, f := range r.Features() {
+			_, _ = fmt.Fprintf(w, "    %s\n", f)
+		}
+		if r.MinMemory != "" {
+			_, _ = fmt.Fprintf(w, "    memory: %s per instance\n", r.MinMemory)
+		}
+	}
 	_, _ = fmt.Fprintf(w, "  Parameters:\n")
 
 	tw := tabwriter.NewWriter(w, 1, 0, 1, ' ', tabwriter.Debug)
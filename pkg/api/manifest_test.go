@@ -22,3 +22,93 @@ func TestManifestHasBuilder(t *testing.T) {
 	require.False(t, m.HasBuilder("docker:rust"))
 	require.False(t, m.HasBuilder("anything"))
 }
+
+func TestParameterValidate(t *testing.T) {
+	intp := Parameter{Type: "int"}
+	require.NoError(t, intp.Validate("10"))
+	require.Error(t, intp.Validate("ten"))
+
+	boolp := Parameter{Type: "bool"}
+	require.NoError(t, boolp.Validate("true"))
+	require.Error(t, boolp.Validate("yes"))
+
+	floatp := Parameter{Type: "float"}
+	require.NoError(t, floatp.Validate("0.25"))
+	require.Error(t, floatp.Validate("quarter"))
+
+	durp := Parameter{Type: "duration"}
+	require.NoError(t, durp.Validate("2m30s"))
+	require.Error(t, durp.Validate("150"))
+
+	jsonp := Parameter{Type: "json"}
+	require.NoError(t, jsonp.Validate(`["a","b"]`))
+	require.Error(t, jsonp.Validate(`["a",`))
+
+	enump := Parameter{Type: "string", Allowed: []string{"tcp", "udp"}}
+	require.NoError(t, enump.Validate("udp"))
+	require.Error(t, enump.Validate("quic"))
+
+	// unknown types are not checked.
+	require.NoError(t, Parameter{Type: "custom"}.Validate("anything"))
+}
+
+func TestTestCaseValidateParams(t *testing.T) {
+	tc := &TestCase{
+		Name: "case",
+		Parameters: map[string]Parameter{
+			"count":    {Type: "int", Required: true},
+			"protocol": {Type: "string", Allowed: []string{"tcp", "udp"}},
+		},
+	}
+
+	require.NoError(t, tc.ValidateParams(map[string]string{"count": "1"}))
+	require.NoError(t, tc.ValidateParams(map[string]string{"count": "1", "protocol": "tcp"}))
+	require.Error(t, tc.ValidateParams(map[string]string{"protocol": "tcp"}))
+	require.Error(t, tc.ValidateParams(map[string]string{"count": "one"}))
+	require.Error(t, tc.ValidateParams(map[string]string{"count": "1", "protocol": "quic"}))
+
+	require.Empty(t, tc.UndeclaredParams(map[string]string{"count": "1"}))
+	require.EqualValues(t, []string{"cuont", "proto"}, tc.UndeclaredParams(map[string]string{"cuont": "1", "proto": "tcp"}))
+}
+
+func TestRequirementsMerge(t *testing.T) {
+	a := Requirements{TrafficShaping: true, MinMemory: "256Mi"}
+	b := Requirements{Privileged: true, MinMemory: "1Gi"}
+
+	m := a.Merge(b)
+	require.Equal(t, Requirements{TrafficShaping: true, Privileged: true, MinMemory: "1Gi"}, m)
+	require.Equal(t, m, b.Merge(a))
+	require.Equal(t, a, a.Merge(Requirements{}))
+	require.Equal(t, []string{"traffic shaping", "privileged mode"}, m.Features())
+
+	require.Error(t, Requirements{MinMemory: "lots"}.Validate())
+}
+
+func TestCapabilitiesCheck(t *testing.T) {
+	caps := Capabilities{TrafficShaping: true, Privileged: true}
+	require.NoError(t, caps.Check(Requirements{TrafficShaping: true, MinMemory: "1Gi"}))
+
+	err := caps.Check(Requirements{TrafficShaping: true, IPv6: true})
+	require.EqualError(t, err, "it doesn't provide ipv6")
+
+	err = Capabilities{}.Check(Requirements{TrafficShaping: true, Privileged: true})
+	require.EqualError(t, err, "it doesn't provide traffic shaping, privileged mode")
+
+	err = caps.Check(Requirements{GPUs: true})
+	require.EqualError(t, err, "it doesn't provide gpus")
+	require.NoError(t, Capabilities{GPUs: true}.Check(Requirements{GPUs: true}))
+}
+
+func TestCapabilitiesAccepts(t *testing.T) {
+	caps := Capabilities{Artifacts: []ArtifactKind{ArtifactExecutable}, Platforms: []string{"linux/amd64"}}
+	require.NoError(t, caps.Accepts(ArtifactSpec{Kind: ArtifactExecutable, Platforms: []string{"linux/amd64"}}))
+	require.NoError(t, caps.Accepts(ArtifactSpec{Kind: ArtifactExecutable}))
+
+	err := caps.Accepts(ArtifactSpec{Kind: ArtifactDockerImage})
+	require.EqualError(t, err, "it doesn't run docker image artifacts")
+
+	err = caps.Accepts(ArtifactSpec{Kind: ArtifactExecutable, Platforms: []string{"linux/arm64"}})
+	require.EqualError(t, err, "it runs artifacts on linux/amd64, but they are built for linux/arm64")
+
+	require.NoError(t, Capabilities{}.Accepts(ArtifactSpec{Kind: ArtifactDockerImage}))
+}
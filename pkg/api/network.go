@@ -0,0 +1,646 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/testground/sdk-go/network"
+	"github.com/testground/sdk-go/ptypes"
+)
+
+// EnvNetworkProfile is the variable of the environment of the instances of a
+// group with a network profile, holding the JSON encoding of the
+// network.LinkShape that the sidecar applies to their data network.
+const EnvNetworkProfile = "TESTGROUND_NETWORK_PROFILE"
+
+// EnvNetworkTimeline is the variable of the environment of the instances of a
+// group with a network timeline, holding the JSON encoding of its steps.
+const EnvNetworkTimeline = "TESTGROUND_NETWORK_TIMELINE"
+
+// EnvNetworkRules is the variable of the environment of the instances of a
+// group with links of its own, holding the JSON encoding of their rules.
+const EnvNetworkRules = "TESTGROUND_NETWORK_RULES"
+
+// EnvNetworkNAT is the variable of the environment of the instances of a group
+// behind a NAT, holding its NATType.
+const EnvNetworkNAT = "TESTGROUND_NETWORK_NAT"
+
+// EnvNetworkCapture is the variable of the environment of the instances of a
+// group with a packet capture, holding the JSON encoding of its CaptureSpec.
+const EnvNetworkCapture = "TESTGROUND_NETWORK_CAPTURE"
+
+// EnvNetworkMetrics is the variable of the environment of the instances of a
+// group with network metrics, holding their interval, e.g. 10s.
+const EnvNetworkMetrics = "TESTGROUND_NETWORK_METRICS"
+
+// EnvNetworkDNS is the variable of the environment of the instances of a group
+// with a DNS view of the data network, holding the JSON encoding of its
+// DNSSpec.
+const EnvNetworkDNS = "TESTGROUND_NETWORK_DNS"
+
+// NetworkMetricsOutput is the output file of the network metrics of an
+// instance, which the sidecar writes like the SDKs write SDKResultsOutput.
+const NetworkMetricsOutput = "network.out"
+
+// NetworkCaptureTopic is the topic of the sync service, suffixed with the
+// hostname of an instance, that a plan publishes a CaptureRequest to for the
+// sidecar of the instance to capture its packets.
+const NetworkCaptureTopic = "network-capture:"
+
+// NetworkDNSTopic is the topic of the sync service, suffixed with the hostname
+// of an instance, that a plan publishes DNSFailures to for the sidecar of the
+// instance to inject in its DNS view of the data network.
+const NetworkDNSTopic = "network-dns:"
+
+// DNSAddress is the address, in the network namespace of the instances of the
+// groups with a DNS view of the data network, that the sidecar serves it on.
+const DNSAddress = "127.0.0.1:53"
+
+// DefaultDNSDomain is the domain of the DNS view of the data network, if the
+// group doesn't set one.
+const DefaultDNSDomain = "run.local"
+
+// NetworkTimelineTopic is the topic of the sync service, suffixed with the
+// hostname of an instance, that a plan publishes a NetworkTimeline to for the
+// sidecar of the instance to execute.
+const NetworkTimelineTopic = "network-timeline:"
+
+// bandwidthUnits are the units of bandwidths, in bits per second.
+var bandwidthUnits = []struct {
+	suffix string
+	bits   float64
+}{
+	{"gbps", 1e9}, {"gbit", 1e9},
+	{"mbps", 1e6}, {"mbit", 1e6},
+	{"kbps", 1e3}, {"kbit", 1e3},
+	{"bps", 1}, {"bit", 1},
+}
+
+// NetworkProfile is the shape of the data network of the instances of a
+// group, e.g.:
+//
+//	[groups.network]
+//	latency = "100ms"
+//	jitter = "10ms"
+//	bandwidth = "10Mbit"
+//	loss = 0.5
+//
+//	  [[groups.network.timeline]]
+//	  at = "60s"
+//	  bandwidth = "10Mbit"
+//
+//	  [[groups.network.timeline]]
+//	  at = "120s"
+//	  loss = 5.0
+//
+//	  [[groups.network.links]]
+//	  group = "remote"
+//	  latency = "200ms"
+//
+// Groups may be put behind a NAT too, with e.g. nat = "symmetric"; see
+// NATType. The packets of the instances may be captured, with a
+// [groups.network.capture] table; see NetworkCapture. And the sidecar may
+// record their network usage, with e.g. metrics = "10s"; see Metrics. Or serve
+// them a DNS view of the data network, with a [groups.network.dns] table; see
+// NetworkDNS.
+//
+// The sidecar applies the link profile to the instances at the start of the
+// run, before they are told that the network is initialized, so that plans get
+// a static topology without programming the network client. Then it executes
+// the timeline, if any. Plans may still reshape the network with the network
+// client. It requires a runner that shapes traffic.
+type NetworkProfile struct {
+	LinkProfile
+
+	// Timeline are the steps by which the shape of the network changes over
+	// the run, in order; see NetworkStep.
+	Timeline []NetworkStep `toml:"timeline" json:"timeline,omitempty"`
+
+	// Links are the shapes of the traffic to other groups, or subnets, which
+	// differ from the link profile; see NetworkLink.
+	Links []NetworkLink `toml:"links" json:"links,omitempty"`
+
+	// NAT is the type of NAT that the instances are behind, if any.
+	NAT NATType `toml:"nat" json:"nat,omitempty"`
+
+	// Capture is the capture of the packets of the instances, if any.
+	Capture *NetworkCapture `toml:"capture" json:"capture,omitempty"`
+
+	// Metrics is the interval at which the sidecar records the bytes and the
+	// packets sent and received by the instances on the data network, the
+	// ones dropped, and their TCP retransmits, as counters of their
+	// NetworkMetricsOutput, e.g. network.tx_bytes; none if empty.
+	Metrics string `toml:"metrics" json:"metrics,omitempty"`
+
+	// DNS is the DNS view of the data network served to the instances, if
+	// any.
+	DNS *NetworkDNS `toml:"dns" json:"dns,omitempty"`
+}
+
+// MetricsInterval returns the interval of the network metrics, or 0 if none.
+func (p *NetworkProfile) MetricsInterval() (time.Duration, error) {
+	d, err := parseLinkDuration(p.Metrics)
+	if err != nil {
+		return 0, fmt.Errorf("invalid metrics interval: %w", err)
+	}
+	return d, nil
+}
+
+// NetworkCapture is the capture of the packets of the data network of the
+// instances of a group, e.g.:
+//
+//	[groups.network.capture]
+//	start = "30s"
+//	duration = "60s"
+//	instances = 2
+//	max_file_size = 50
+//	max_files = 4
+//
+// The sidecar writes the packets, in pcap files of at most MaxFileSize MiB,
+// to the outputs of the instances, as capture-<n>.pcap, and only keeps the
+// last MaxFiles of them.
+type NetworkCapture struct {
+	// Start is the time the capture starts at, counted from when the
+	// networks of all the instances are ready; at once if empty.
+	Start string `toml:"start" json:"start,omitempty"`
+
+	// Duration is how long the capture lasts; until the end of the run if
+	// empty.
+	Duration string `toml:"duration" json:"duration,omitempty"`
+
+	// Instances is the number of instances of the group to capture the
+	// packets of, e.g. 1; all of them if 0.
+	Instances int `toml:"instances" json:"instances,omitempty"`
+
+	// MaxFileSize is the size of the pcap files, in MiB; 100 if 0.
+	MaxFileSize int `toml:"max_file_size" json:"max_file_size,omitempty"`
+
+	// MaxFiles is the number of pcap files kept; 10 if 0.
+	MaxFiles int `toml:"max_files" json:"max_files,omitempty"`
+
+	// Snaplen is the number of bytes of every packet captured; 65535 if 0.
+	Snaplen int `toml:"snaplen" json:"snaplen,omitempty"`
+}
+
+// CaptureSpec is the capture of the packets of an instance, as executed by the
+// sidecar.
+type CaptureSpec struct {
+	Start       time.Duration `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	Instances   int           `json:"instances"`
+	MaxFileSize int64         `json:"max_file_size"`
+	MaxFiles    int           `json:"max_files"`
+	Snaplen     int           `json:"snaplen"`
+}
+
+// DefaultCaptureSpec is the capture of the packets of the instances that plans
+// request without their group declaring one.
+var DefaultCaptureSpec = CaptureSpec{
+	MaxFileSize: 100 << 20,
+	MaxFiles:    10,
+	Snaplen:     65535,
+}
+
+// Spec returns the capture for the sidecar.
+func (c *NetworkCapture) Spec() (*CaptureSpec, error) {
+	spec := DefaultCaptureSpec
+	var err error
+	if spec.Start, err = parseLinkDuration(c.Start); err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	if spec.Duration, err = parseLinkDuration(c.Duration); err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+	if c.Instances < 0 || c.MaxFileSize < 0 || c.MaxFiles < 0 || c.Snaplen < 0 {
+		return nil, fmt.Errorf("instances, max_file_size, max_files and snaplen must not be negative")
+	}
+	spec.Instances = c.Instances
+	if c.MaxFileSize > 0 {
+		spec.MaxFileSize = int64(c.MaxFileSize) << 20
+	}
+	if c.MaxFiles > 0 {
+		spec.MaxFiles = c.MaxFiles
+	}
+	if c.Snaplen > 0 {
+		spec.Snaplen = c.Snaplen
+	}
+	return &spec, nil
+}
+
+// CaptureRequest is a capture of the packets of an instance that a plan
+// requests, through the NetworkCaptureTopic of the instance, e.g. around the
+// step of a test that fails. It's written like the capture of the group, if
+// any, and replaces the capture in progress.
+type CaptureRequest struct {
+	// Duration is how long the capture lasts; until the end of the run if 0.
+	Duration time.Duration `json:"duration"`
+
+	// State is the state of the sync service that the sidecar signals once
+	// the capture started, if any.
+	State string `json:"state,omitempty"`
+}
+
+// NetworkDNS is the DNS view of the data network that the sidecar serves to
+// the instances of a group, on DNSAddress, e.g.:
+//
+//	[groups.network.dns]
+//	domain = "run.local"
+//
+//	  [[groups.network.dns.failures]]
+//	  name = "bootstrap-1"
+//	  error = "nxdomain"
+//
+//	  [[groups.network.dns.failures]]
+//	  name = "bootstrap.run.local"
+//	  delay = "2s"
+//
+// It resolves <group>.<domain> to the addresses of all the instances of a
+// group, and <group>-<n>.<domain> to those of its n-th instance, counted from 1
+// in the order of their addresses on the data network, so that an instance
+// finds its own name by looking its address up in those of its group. Names
+// out of the domain are refused.
+type NetworkDNS struct {
+	// Domain is the domain of the names of the instances; DefaultDNSDomain if
+	// empty.
+	Domain string `toml:"domain" json:"domain,omitempty"`
+
+	// Failures are the failures injected for some of the names; see
+	// NetworkDNSFailure.
+	Failures []NetworkDNSFailure `toml:"failures" json:"failures,omitempty"`
+}
+
+// NetworkDNSFailure is a failure of the resolution of a name of the DNS view
+// of the data network.
+type NetworkDNSFailure struct {
+	// Name is the name that fails, relative to the domain unless it ends with
+	// it.
+	Name string `toml:"name" json:"name"`
+
+	// Error is the error that the name resolves to, if any; see DNSError.
+	Error DNSError `toml:"error" json:"error,omitempty"`
+
+	// Delay is the time the answers for the name are delayed by, e.g. "2s".
+	Delay string `toml:"delay" json:"delay,omitempty"`
+}
+
+// DNSSpec is the DNS view of the data network of an instance, as served by the
+// sidecar.
+type DNSSpec struct {
+	Domain   string       `json:"domain"`
+	Failures []DNSFailure `json:"failures,omitempty"`
+}
+
+// DNSFailure is a failure of the resolution of a name, as injected by the
+// sidecar.
+type DNSFailure struct {
+	Name  string        `json:"name"`
+	Error DNSError      `json:"error,omitempty"`
+	Delay time.Duration `json:"delay,omitempty"`
+}
+
+// DNSFailures are the failures that a plan injects in the DNS view of the data
+// network of an instance, through its NetworkDNSTopic, e.g. to fail its
+// discovery of some peers. They replace the failures injected before, those of
+// the group included.
+type DNSFailures struct {
+	Failures []DNSFailure `json:"failures"`
+
+	// State is the state of the sync service that the sidecar signals once
+	// the failures are injected, if any.
+	State string `json:"state,omitempty"`
+}
+
+// DNSError is an error that the sidecar answers a DNS query with.
+type DNSError string
+
+const (
+	// DNSNXDomain answers that the name doesn't exist.
+	DNSNXDomain = DNSError("nxdomain")
+
+	// DNSServFail answers that the server failed.
+	DNSServFail = DNSError("servfail")
+
+	// DNSTimeout doesn't answer, for the query to time out.
+	DNSTimeout = DNSError("timeout")
+)
+
+// Validate checks that the DNS error is known.
+func (e DNSError) Validate() error {
+	switch e {
+	case "", DNSNXDomain, DNSServFail, DNSTimeout:
+		return nil
+	default:
+		return fmt.Errorf("unknown dns error %q; expected one of %s, %s or %s", e, DNSNXDomain, DNSServFail, DNSTimeout)
+	}
+}
+
+// Spec returns the DNS view for the sidecar.
+func (d *NetworkDNS) Spec() (*DNSSpec, error) {
+	spec := &DNSSpec{Domain: strings.ToLower(strings.Trim(d.Domain, "."))}
+	if spec.Domain == "" {
+		spec.Domain = DefaultDNSDomain
+	}
+	if strings.ContainsAny(spec.Domain, " \t") {
+		return nil, fmt.Errorf("invalid domain %q", d.Domain)
+	}
+	for i, f := range d.Failures {
+		if f.Name == "" {
+			return nil, fmt.Errorf("failure %d: expected a name", i)
+		}
+		if err := f.Error.Validate(); err != nil {
+			return nil, fmt.Errorf("failure %d: %w", i, err)
+		}
+		delay, err := parseLinkDuration(f.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("failure %d: invalid delay: %w", i, err)
+		}
+		if f.Error == "" && delay == 0 {
+			return nil, fmt.Errorf("failure %d: expected an error or a delay", i)
+		}
+		spec.Failures = append(spec.Failures, DNSFailure{Name: f.Name, Error: f.Error, Delay: delay})
+	}
+	return spec, nil
+}
+
+// NATType is a type of NAT that the sidecar emulates for the instances of a
+// group, with the firewall of their network namespace. The instances keep
+// their addresses on the data network; the NAT filters the traffic from the
+// other instances, as per the type, and, if symmetric, maps the ports of the
+// instances anew for every destination.
+type NATType string
+
+const (
+	// NATFullCone accepts the traffic from any instance: the mappings, and
+	// the filtering, are endpoint independent.
+	NATFullCone = NATType("full-cone")
+
+	// NATRestricted accepts the traffic from the instances that the instance
+	// sent traffic to, from any of their ports.
+	NATRestricted = NATType("restricted")
+
+	// NATPortRestricted accepts the traffic from the addresses and ports that
+	// the instance sent traffic to.
+	NATPortRestricted = NATType("port-restricted")
+
+	// NATSymmetric filters the traffic like NATPortRestricted, and maps the
+	// ports of the instance to random ones for every destination, so that
+	// peers observe different ports of it.
+	NATSymmetric = NATType("symmetric")
+)
+
+// Validate checks that the NAT type is known.
+func (t NATType) Validate() error {
+	switch t {
+	case "", NATFullCone, NATRestricted, NATPortRestricted, NATSymmetric:
+		return nil
+	default:
+		return fmt.Errorf("unknown nat type %q; expected one of %s, %s, %s or %s", t, NATFullCone, NATRestricted, NATPortRestricted, NATSymmetric)
+	}
+}
+
+// NetworkLink is the shape of the traffic of the instances of a group to the
+// instances of a group, which may be their own, or to a subnet, e.g. to
+// emulate geographic topologies. The links hold over the timeline, whose steps
+// only change the shape of the rest of the traffic, and over the network
+// changes of plans that don't set rules of their own.
+type NetworkLink struct {
+	// Group is the group the traffic to which is shaped.
+	Group string `toml:"group" json:"group,omitempty"`
+
+	// Subnet is the subnet the traffic to which is shaped, e.g. a single
+	// peer, with 16.0.1.2/32; exclusive with Group.
+	Subnet string `toml:"subnet" json:"subnet,omitempty"`
+
+	LinkProfile
+}
+
+// NetworkRule is the rule of a link, as applied by the sidecar: the traffic to
+// the instances of Group, or to Subnet, has Shape.
+type NetworkRule struct {
+	Group  string            `json:"group,omitempty"`
+	Subnet *ptypes.IPNet     `json:"subnet,omitempty"`
+	Shape  network.LinkShape `json:"shape"`
+}
+
+// NetworkStep is a step of a network timeline: the shape of the network from
+// a time on, counted from when the networks of all the instances are ready.
+// Every step replaces the link profile of the previous one.
+type NetworkStep struct {
+	// At is the time of the step, e.g. "60s".
+	At string `toml:"at" json:"at"`
+
+	// State is the state of the sync service that the sidecar of every
+	// instance signals once it applied the step, for plans to wait on;
+	// network-timeline-<n> if empty, where n is the index of the step.
+	State string `toml:"state" json:"state,omitempty"`
+
+	LinkProfile
+}
+
+// TimelineStep is a step of a network timeline, as executed by the sidecar.
+type TimelineStep struct {
+	At    time.Duration     `json:"at"`
+	Shape network.LinkShape `json:"shape"`
+	State string            `json:"state"`
+}
+
+// NetworkTimeline is a network timeline that a plan supplies, through the
+// NetworkTimelineTopic of an instance. Its steps count from when the sidecar
+// receives it, and it replaces the timeline that the sidecar was executing.
+type NetworkTimeline struct {
+	Steps []TimelineStep `json:"steps"`
+}
+
+// LinkProfile is the shape of the links of an instance.
+type LinkProfile struct {
+	// Latency and Jitter are the egress latency, and its jitter, e.g. "100ms".
+	Latency string `toml:"latency" json:"latency,omitempty"`
+	Jitter  string `toml:"jitter" json:"jitter,omitempty"`
+
+	// Bandwidth is the egress bandwidth, in bits per second, e.g. "10Mbit",
+	// "1Gbps" or "512kbit"; unlimited if empty.
+	Bandwidth string `toml:"bandwidth" json:"bandwidth,omitempty"`
+
+	// Loss is the egress packet loss, in percent.
+	Loss float64 `toml:"loss" json:"loss,omitempty"`
+}
+
+// LinkShape returns the shape of the links of the instances for the profile.
+func (p *LinkProfile) LinkShape() (network.LinkShape, error) {
+	var (
+		shape network.LinkShape
+		err   error
+	)
+	if shape.Latency, err = parseLinkDuration(p.Latency); err != nil {
+		return shape, fmt.Errorf("invalid latency: %w", err)
+	}
+	if shape.Jitter, err = parseLinkDuration(p.Jitter); err != nil {
+		return shape, fmt.Errorf("invalid jitter: %w", err)
+	}
+	if shape.Bandwidth, err = parseBandwidth(p.Bandwidth); err != nil {
+		return shape, fmt.Errorf("invalid bandwidth: %w", err)
+	}
+	if p.Loss < 0 || p.Loss > 100 {
+		return shape, fmt.Errorf("invalid loss %v: must be a percentage", p.Loss)
+	}
+	shape.Loss = float32(p.Loss)
+	return shape, nil
+}
+
+// Validate checks that the settings of the profile, and the steps of its
+// timeline, are valid.
+func (p *NetworkProfile) Validate() error {
+	if _, err := p.LinkShape(); err != nil {
+		return err
+	}
+	if _, err := p.Steps(); err != nil {
+		return err
+	}
+	if _, err := p.Rules(); err != nil {
+		return err
+	}
+	if p.Capture != nil {
+		if _, err := p.Capture.Spec(); err != nil {
+			return fmt.Errorf("invalid capture: %w", err)
+		}
+	}
+	if _, err := p.MetricsInterval(); err != nil {
+		return err
+	}
+	if p.DNS != nil {
+		if _, err := p.DNS.Spec(); err != nil {
+			return fmt.Errorf("invalid dns: %w", err)
+		}
+	}
+	return p.NAT.Validate()
+}
+
+// Rules returns the rules of the links of the profile, for the sidecar.
+func (p *NetworkProfile) Rules() ([]NetworkRule, error) {
+	rules := make([]NetworkRule, 0, len(p.Links))
+	seen := make(map[string]bool, len(p.Links))
+	for i, l := range p.Links {
+		if (l.Group == "") == (l.Subnet == "") {
+			return nil, fmt.Errorf("link %d: expected either a group or a subnet", i)
+		}
+		shape, err := l.LinkShape()
+		if err != nil {
+			return nil, fmt.Errorf("link %d: %w", i, err)
+		}
+		rule := NetworkRule{Group: l.Group, Shape: shape}
+		if l.Subnet != "" {
+			_, subnet, err := net.ParseCIDR(l.Subnet)
+			if err != nil {
+				return nil, fmt.Errorf("link %d: invalid subnet: %w", i, err)
+			}
+			rule.Subnet = &ptypes.IPNet{IPNet: *subnet}
+		}
+
+		key := l.Group
+		if rule.Subnet != nil {
+			key = rule.Subnet.String()
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("link %d: duplicate link to %s", i, key)
+		}
+		seen[key] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Steps returns the steps of the timeline of the profile, for the sidecar.
+func (p *NetworkProfile) Steps() ([]TimelineStep, error) {
+	steps := make([]TimelineStep, 0, len(p.Timeline))
+	for i, s := range p.Timeline {
+		at, err := parseLinkDuration(s.At)
+		if err != nil || s.At == "" {
+			return nil, fmt.Errorf("timeline step %d: invalid time %q", i, s.At)
+		}
+		if i > 0 && at < steps[i-1].At {
+			return nil, fmt.Errorf("timeline step %d: steps must be in order; %s is before %s", i, s.At, p.Timeline[i-1].At)
+		}
+		shape, err := s.LinkShape()
+		if err != nil {
+			return nil, fmt.Errorf("timeline step %d: %w", i, err)
+		}
+		state := s.State
+		if state == "" {
+			state = fmt.Sprintf("network-timeline-%d", i)
+		}
+		steps = append(steps, TimelineStep{At: at, Shape: shape, State: state})
+	}
+	return steps, nil
+}
+
+func parseLinkDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%q must not be negative", s)
+	}
+	return d, nil
+}
+
+// parseBandwidth parses a bandwidth in bits per second into the bytes per
+// second of network.LinkShape#Bandwidth.
+func parseBandwidth(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.ToLower(strings.TrimSpace(s))
+	for _, u := range bandwidthUnits {
+		if !strings.HasSuffix(v, u.suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a positive bandwidth", s)
+		}
+		return uint64(n * u.bits / 8), nil
+	}
+	return 0, fmt.Errorf("%q has no unit; expected e.g. 10Mbit or 1Gbps", s)
+}
+
+// NetworkProfileEnv returns the variables of the environment of the instances
+// of the group that carry its network profile, timeline, rules, NAT, capture,
+// metrics and DNS view to the sidecar, if any.
+func (g *RunGroup) NetworkProfileEnv() map[string]string {
+	env := make(map[string]string, 7)
+	if g.Network != nil {
+		b, _ := json.Marshal(g.Network)
+		env[EnvNetworkProfile] = string(b)
+	}
+	if len(g.NetworkTimeline) > 0 {
+		b, _ := json.Marshal(g.NetworkTimeline)
+		env[EnvNetworkTimeline] = string(b)
+	}
+	if len(g.NetworkRules) > 0 {
+		b, _ := json.Marshal(g.NetworkRules)
+		env[EnvNetworkRules] = string(b)
+	}
+	if g.NAT != "" {
+		env[EnvNetworkNAT] = string(g.NAT)
+	}
+	if g.Capture != nil {
+		b, _ := json.Marshal(g.Capture)
+		env[EnvNetworkCapture] = string(b)
+	}
+	if g.NetworkMetrics > 0 {
+		env[EnvNetworkMetrics] = g.NetworkMetrics.String()
+	}
+	if g.DNS != nil {
+		b, _ := json.Marshal(g.DNS)
+		env[EnvNetworkDNS] = string(b)
+	}
+	return env
+}
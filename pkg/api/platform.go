@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParsePlatforms parses the platform setting of a run config: an os/arch
+// pair, with an optional variant, e.g. linux/arm64 or linux/arm/v7, or a
+// comma-separated list or an array of them for multi-platform images. It
+// returns the platforms sorted, or nil if v is nil or empty.
+func ParsePlatforms(v interface{}) ([]string, error) {
+	var in []string
+	switch v := v.(type) {
+	case nil:
+	case string:
+		in = strings.Split(v, ",")
+	case []string:
+		in = v
+	case []interface{}:
+		for _, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid platform: %v", p)
+			}
+			in = append(in, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid platform: %v", v)
+	}
+
+	var res []string
+	seen := make(map[string]struct{}, len(in))
+	for _, p := range in {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q; expected os/arch[/variant], e.g. linux/arm64", p)
+		}
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			res = append(res, p)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// RunPlatform returns the platform that the instances of a run are run on,
+// given its platform setting: the platform if there's one, or else empty, for
+// the one of the hosts. The hosts pick the image of their own platform out of
+// a multi-platform one.
+func RunPlatform(v string) (string, error) {
+	platforms, err := ParsePlatforms(v)
+	if err != nil || len(platforms) != 1 {
+		return "", err
+	}
+	return platforms[0], nil
+}
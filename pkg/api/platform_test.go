@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	p, err := ParsePlatforms(nil)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	p, err = ParsePlatforms("linux/arm64")
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux/arm64"}, p)
+
+	p, err = ParsePlatforms(" linux/arm64, Linux/AMD64,linux/arm64 ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux/amd64", "linux/arm64"}, p)
+
+	p, err = ParsePlatforms([]interface{}{"linux/arm/v7", "linux/amd64"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux/amd64", "linux/arm/v7"}, p)
+
+	for _, v := range []interface{}{"arm64", "linux/", "linux/arm/v7/x", []interface{}{1}, 42} {
+		_, err = ParsePlatforms(v)
+		require.Error(t, err, "%v", v)
+	}
+}
+
+func TestRunPlatform(t *testing.T) {
+	p, err := RunPlatform("")
+	require.NoError(t, err)
+	require.Empty(t, p)
+
+	p, err = RunPlatform("linux/arm64")
+	require.NoError(t, err)
+	require.Equal(t, "linux/arm64", p)
+
+	// the hosts pick their platform out of a multi-platform image.
+	p, err = RunPlatform("linux/amd64,linux/arm64")
+	require.NoError(t, err)
+	require.Empty(t, p)
+
+	_, err = RunPlatform("arm64")
+	require.Error(t, err)
+}
@@ -21,6 +21,12 @@ type BuildRequest struct {
 	Composition Composition      `json:"composition"`
 	Manifest    TestPlanManifest `json:"manifest"`
 	CreatedBy   CreatedBy        `json:"created_by"`
+	// NoCache rebuilds the artifacts, rather than reusing those of previous
+	// builds of the same sources, dependencies and configuration.
+	NoCache bool `json:"no_cache,omitempty"`
+	// SourceCommit is the git commit of the sources of the plan, recorded in
+	// the provenance of the artifacts; see Provenance#Commit.
+	SourceCommit string `json:"source_commit,omitempty"`
 }
 
 // RunRequest is the request struct for the `run` function.
@@ -30,10 +36,25 @@ type RunRequest struct {
 	Composition Composition      `json:"composition"`
 	Manifest    TestPlanManifest `json:"manifest"`
 	CreatedBy   CreatedBy        `json:"created_by"`
+	// FollowInstances streams the output of the instances live in the logs
+	// of the run; see RunInput#FollowInstances.
+	FollowInstances bool `json:"follow_instances,omitempty"`
+	// NoCache rebuilds the artifacts of BuildGroups; see BuildRequest#NoCache.
+	NoCache bool `json:"no_cache,omitempty"`
+	// SourceCommit is the git commit of the sources of the plan; see
+	// BuildRequest#SourceCommit.
+	SourceCommit string `json:"source_commit,omitempty"`
 }
 
 type CreatedBy task.CreatedBy
 
+// ScheduleRequest is the request struct for the `schedule` function: a run
+// request, queued whenever the cron expression matches.
+type ScheduleRequest struct {
+	Cron string `json:"cron"`
+	RunRequest
+}
+
 type OutputsRequest struct {
 	Runner string `json:"runner"`
 	RunID  string `json:"run_id"`
@@ -44,9 +65,18 @@ type TerminateRequest struct {
 	Builder string `json:"builder"`
 }
 
+// CleanupRequest removes the leftovers of runs with a runner, or with all the
+// runners that support it if Runner is empty: those of the runs of RunIDs, or
+// of all the runs that are over if there are none.
+type CleanupRequest struct {
+	Runner string   `json:"runner"`
+	RunIDs []string `json:"run_ids"`
+}
+
 type HealthcheckRequest struct {
-	Runner string `json:"runner"`
-	Fix    bool   `json:"fix"`
+	Runner  string `json:"runner"`
+	Builder string `json:"builder"`
+	Fix     bool   `json:"fix"`
 }
 
 type BuildPurgeRequest struct {
@@ -54,8 +84,18 @@ type BuildPurgeRequest struct {
 	Testplan string `json:"testplan"`
 }
 
+// BuildArtifactsRequest selects the artifacts of a builder, or of all
+// builders if Builder is empty, and optionally removes them.
+type BuildArtifactsRequest struct {
+	Builder string         `json:"builder"`
+	Filter  ArtifactFilter `json:"filter"`
+	Remove  bool           `json:"remove"`
+}
+
 type TasksRequest = TasksFilters
 
+type RunsRequest = RunsFilters
+
 type StatusRequest struct {
 	TaskID string `json:"task_id"`
 }
@@ -70,6 +110,9 @@ type LogsRequest struct {
 	// CancelWithContext indicates if the task should be cancelled
 	// on context cancellation.
 	CancelWithContext bool `json:"cancel_with_context"`
+	// Instances includes the output of the instances streamed live by the
+	// runner, if the run follows them.
+	Instances bool `json:"instances"`
 }
 
 // ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -88,6 +131,11 @@ type CollectResponse struct {
 
 type HealthcheckResponse = HealthcheckReport
 
+type BuildArtifactsResponse = []*Artifact
+
+// CleanupResponse are the IDs of the runs that were cleaned up after.
+type CleanupResponse = []string
+
 type StatusResponse = task.Task
 
 type LogsResponse = task.Task
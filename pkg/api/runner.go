@@ -2,7 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
+	"time"
+
+	"github.com/testground/sdk-go/network"
 
 	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/rpc"
@@ -58,8 +64,26 @@ type RunInput struct {
 	// DisableMetrics disables metrics batching.
 	DisableMetrics bool
 
+	// FollowInstances asks the runner to stream the output of the instances
+	// live to the client, as instance chunks, rather than only making it
+	// available after the run. Runners that can't ignore it.
+	FollowInstances bool
+
+	// Stagger, if not nil, is the batches to start the instances in.
+	Stagger *Stagger
+
+	// Resume is set when a restart of the daemon interrupted the run. Runners
+	// that checkpoint their runs resume it from its checkpoint, picking up
+	// the instances that are still running; others start it over.
+	Resume bool
+
 	// Groups enumerates the groups participating in this run.
 	Groups []*RunGroup
+
+	// Requirements are the capabilities the test case requires, which the
+	// runner has been checked to provide. Runners that provide a capability
+	// only on demand, e.g. privileged mode, enable it when required.
+	Requirements Requirements
 }
 
 type RunGroup struct {
@@ -82,6 +106,59 @@ type RunGroup struct {
 	// Profiles specifies the profiles to capture. Refer to the docs
 	// on Run#Profiles for more info.
 	Profiles map[string]string
+
+	// RunnerConfig is the runner configuration of this group, of the same type
+	// as RunInput#RunnerConfig. It is coalesced from the group run config,
+	// which inherits from the global one. Runners use it for the settings they
+	// support per group.
+	RunnerConfig interface{}
+
+	// StartAfter, if set, are the conditions the runner must wait for before
+	// starting the instances of this group.
+	StartAfter *StartAfter
+
+	// Timeout, if set, is the time the instances of this group may run for,
+	// from the start of the run. The engine bounds the run accordingly;
+	// runners that can stop a group on its own stop its instances once it
+	// elapses.
+	Timeout time.Duration
+
+	// Network, if set, is the shape of the data network of the instances of
+	// this group, which the sidecar applies at the start of the run. Runners
+	// with a sidecar pass it to the instances with NetworkProfileEnv.
+	Network *network.LinkShape
+
+	// NetworkTimeline are the steps of the network timeline of this group,
+	// which the sidecar executes once the networks are ready; passed in
+	// NetworkProfileEnv too.
+	NetworkTimeline []TimelineStep
+
+	// NetworkRules are the rules of the links of this group, which the
+	// sidecar applies with its network profile; passed in NetworkProfileEnv
+	// too.
+	NetworkRules []NetworkRule
+
+	// NAT is the type of NAT that the instances of this group are behind, if
+	// any, which the sidecar emulates; passed in NetworkProfileEnv too.
+	NAT NATType
+
+	// Capture is the capture of the packets of the instances of this group,
+	// if any, which the sidecar writes to their outputs; passed in
+	// NetworkProfileEnv too.
+	Capture *CaptureSpec
+
+	// NetworkMetrics is the interval at which the sidecar records the network
+	// usage of the instances of this group, if any; passed in
+	// NetworkProfileEnv too.
+	NetworkMetrics time.Duration
+
+	// DNS is the DNS view of the data network served to the instances of this
+	// group, if any; passed in NetworkProfileEnv too.
+	DNS *DNSSpec
+
+	// Chaos are the faults injected to the instances of this group, if any,
+	// by the sidecar; passed in ChaosEnv.
+	Chaos []ChaosAction
 }
 
 type RunOutput struct {
@@ -118,3 +195,122 @@ type CollectionInput struct {
 type Terminatable interface {
 	TerminateAll(context.Context, *rpc.OutputWriter) error
 }
+
+// Cleaner is the interface to be implemented by a runner that can remove what
+// runs leave behind, e.g. containers, pods or networks, which it identifies by
+// their testground.run_id label. The janitor of the daemon uses it to reap
+// the leftovers of runs that crashed.
+type Cleaner interface {
+	// Cleanup removes what the run left behind, if anything.
+	Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error
+
+	// Leftovers returns the IDs of the runs that left something behind.
+	Leftovers(ctx context.Context) ([]string, error)
+}
+
+// Capable is the interface to be implemented by a runner that provides
+// optional capabilities to the test instances, such as traffic shaping.
+// Runners that don't implement it provide none.
+type Capable interface {
+	Capabilities() Capabilities
+}
+
+// Capabilities are the optional capabilities a runner provides, matched
+// against the Requirements of test cases.
+type Capabilities struct {
+	TrafficShaping bool
+	IPv6           bool
+	Privileged     bool
+	GPUs           bool
+
+	// Artifacts are the kinds of artifacts the runner runs. Empty if the
+	// runner doesn't describe them; its compatible builders are then all
+	// there is to know.
+	Artifacts []ArtifactKind
+
+	// Platforms are the os/arch pairs the runner runs artifacts on, e.g.
+	// "linux/amd64". Empty if it runs them on any.
+	Platforms []string
+}
+
+// Check returns an error naming the required features that these
+// capabilities lack, if any.
+func (c Capabilities) Check(r Requirements) error {
+	missing := Requirements{
+		TrafficShaping: r.TrafficShaping && !c.TrafficShaping,
+		IPv6:           r.IPv6 && !c.IPv6,
+		Privileged:     r.Privileged && !c.Privileged,
+		GPUs:           r.GPUs && !c.GPUs,
+	}
+	if f := missing.Features(); len(f) > 0 {
+		return fmt.Errorf("it doesn't provide %s", strings.Join(f, ", "))
+	}
+	return nil
+}
+
+// Accepts returns an error if the runner can't run the artifacts described,
+// because of their kind or platforms. Artifacts or capabilities that leave
+// them out are accepted.
+func (c Capabilities) Accepts(a ArtifactSpec) error {
+	if len(c.Artifacts) > 0 && a.Kind != "" {
+		ok := false
+		for _, k := range c.Artifacts {
+			ok = ok || k == a.Kind
+		}
+		if !ok {
+			return fmt.Errorf("it doesn't run %s artifacts", a.Kind)
+		}
+	}
+
+	if len(c.Platforms) > 0 && len(a.Platforms) > 0 {
+		for _, p := range a.Platforms {
+			for _, q := range c.Platforms {
+				if p == q {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("it runs artifacts on %s, but they are built for %s", strings.Join(c.Platforms, ", "), strings.Join(a.Platforms, ", "))
+	}
+	return nil
+}
+
+// FailureInfra is the class of failures caused by the infrastructure a run
+// depends on, rather than by the test plan: images that can't be pulled,
+// evicted nodes, an unreachable sync service, failed healthchecks, etc.
+// Compositions can retry the runs that fail for this reason; see Retries.
+const FailureInfra = "infra"
+
+// InfraError is an error caused by the infrastructure; see FailureInfra.
+// Runners and builders wrap the errors they classify as such with
+// NewInfraError.
+type InfraError struct {
+	Err error
+}
+
+// NewInfraError classifies err as an infrastructure failure.
+func NewInfraError(err error) error {
+	return &InfraError{Err: err}
+}
+
+func (e *InfraError) Error() string {
+	return InfraErrorPrefix + e.Err.Error()
+}
+
+func (e *InfraError) Unwrap() error {
+	return e.Err
+}
+
+// InfraErrorPrefix prefixes the messages of infrastructure errors, so that
+// their class survives being carried as text, e.g. by plugins.
+const InfraErrorPrefix = "infrastructure failure: "
+
+// FailureClass returns the class of the failure of a run that failed with
+// err: FailureInfra, or the empty string if the failure isn't classified.
+func FailureClass(err error) string {
+	var ie *InfraError
+	if errors.As(err, &ie) {
+		return FailureInfra
+	}
+	return ""
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// SchemaVersion is the version of the composition and test plan manifest
+// formats understood by this version of testground.
+//
+// Files that don't declare a version are version 1. They are decoded
+// leniently, as they always were: unknown keys are reported as warnings, and
+// otherwise ignored. Files of the current version are decoded strictly, and
+// unknown keys are errors. `testground composition migrate` upgrades version 1
+// files.
+const SchemaVersion = 2
+
+// SchemaError is a problem found in a composition or manifest file, at the
+// line of the offending key when it can be located.
+type SchemaError struct {
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Key, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+// SchemaErrors are the problems found in a composition or manifest file.
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// DecodeComposition decodes a TOML composition, and upgrades it to the
+// current schema version. Version 1 compositions are decoded leniently; the
+// problems found in them are returned as warnings.
+func DecodeComposition(data string) (*Composition, SchemaErrors, error) {
+	comp := new(Composition)
+	warnings, err := decodeVersioned(data, comp, &comp.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return comp, warnings, nil
+}
+
+// DecodeManifest decodes a TOML test plan manifest, and upgrades it to the
+// current schema version, like DecodeComposition.
+func DecodeManifest(data string) (*TestPlanManifest, SchemaErrors, error) {
+	manifest := new(TestPlanManifest)
+	warnings, err := decodeVersioned(data, manifest, &manifest.Version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return manifest, warnings, nil
+}
+
+func decodeVersioned(data string, v interface{}, version *int) (SchemaErrors, error) {
+	md, err := toml.Decode(data, v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *version == 0:
+		*version = 1
+	case *version < 0 || *version > SchemaVersion:
+		return nil, SchemaErrors{{
+			Line: keyLine(data, toml.Key{"version"}),
+			Key:  "version",
+			Msg:  fmt.Sprintf("unsupported schema version %d; this testground supports versions 1 to %d", *version, SchemaVersion),
+		}}
+	}
+
+	var errs SchemaErrors
+	for _, k := range unknownKeys(reflect.TypeOf(v), md.Undecoded()) {
+		errs = append(errs, &SchemaError{Line: keyLine(data, k), Key: k.String(), Msg: "unknown key"})
+	}
+
+	if *version < SchemaVersion {
+		errs = append(SchemaErrors{{
+			Key: "version",
+			Msg: fmt.Sprintf("schema version %d is deprecated; upgrade with `testground composition migrate`", *version),
+		}}, errs...)
+		*version = SchemaVersion
+		return errs, nil
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return nil, nil
+}
+
+// unknownKeys returns the keys that don't map to a field of t. It drops the
+// keys nested under other unknown keys, and the keys repeated across an array
+// of tables, so that every unknown key is reported once.
+func unknownKeys(t reflect.Type, keys []toml.Key) []toml.Key {
+	var res []toml.Key
+	for _, k := range keys {
+		if freeForm(t, k) {
+			continue
+		}
+		nested := false
+		for _, p := range res {
+			if len(p) <= len(k) && p.String() == k[:len(p)].String() {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// freeForm returns whether key falls within a free-form value of t, such as
+// a map[string]interface{}. The decoder doesn't mark the keys nested in those
+// as decoded.
+func freeForm(t reflect.Type, key toml.Key) bool {
+	for _, part := range key {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Interface:
+			return true
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			f, ok := tomlField(t, part)
+			if !ok {
+				return false
+			}
+			t = f.Type
+		default:
+			return false
+		}
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Interface
+}
+
+// tomlField returns the field of struct t that the decoder maps name to: the
+// one tagged with it, or else the one named like it, ignoring case.
+func tomlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag := strings.Split(f.Tag.Get("toml"), ",")[0]; tag == name {
+			return f, true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("toml") == "" && strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+var (
+	tableHeaderRe = regexp.MustCompile(`^\s*\[\[?\s*([^\]]+?)\s*\]\]?`)
+	keyValueRe    = regexp.MustCompile(`^\s*([A-Za-z0-9_\-."' ]+?)\s*=`)
+)
+
+// keyLine returns the line at which a key, or its closest enclosing table, is
+// defined in data, or 0 if it can't be found. It gets the common cases right
+// without a full TOML parser: table headers, and keys assigned in them.
+func keyLine(data string, key toml.Key) int {
+	var (
+		table      toml.Key
+		best, line int
+		bestLen    int
+	)
+	match := func(k toml.Key) {
+		n := 0
+		for n < len(k) && n < len(key) && k[n] == key[n] {
+			n++
+		}
+		if n == len(k) && n > bestLen {
+			best, bestLen = line, n
+		}
+	}
+
+	s := bufio.NewScanner(strings.NewReader(data))
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if m := tableHeaderRe.FindStringSubmatch(text); m != nil {
+			table = splitKey(m[1])
+			match(table)
+			continue
+		}
+		if m := keyValueRe.FindStringSubmatch(text); m != nil {
+			match(append(append(toml.Key{}, table...), splitKey(m[1])...))
+		}
+	}
+	return best
+}
+
+// splitKey splits a dotted TOML key, honouring quoted parts.
+func splitKey(s string) toml.Key {
+	var (
+		key   toml.Key
+		part  strings.Builder
+		quote rune
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			part.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			key = append(key, strings.TrimSpace(part.String()))
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(key, strings.TrimSpace(part.String()))
+}
+
+var versionRe = regexp.MustCompile(`^\s*version\s*=\s*(\d+)\s*(#.*)?$`)
+
+// MigrateComposition upgrades the source of a composition to the current
+// schema version. It works on the text, so that comments, formatting, and
+// template directives are preserved. It returns the migrated source, and
+// notes about the changes made.
+func MigrateComposition(src string) (string, []string, error) {
+	return migrate(src, nil)
+}
+
+// MigrateManifest upgrades the source of a test plan manifest to the current
+// schema version, like MigrateComposition.
+func MigrateManifest(src string) (string, []string, error) {
+	return migrate(src, []func(lines []string) ([]string, []string){
+		1: migrateManifestDefaults,
+	})
+}
+
+// migrate applies the migrations of every version from the version of src up
+// to the current one, where migrations[v] upgrades a version v file.
+func migrate(src string, migrations []func(lines []string) ([]string, []string)) (string, []string, error) {
+	lines := strings.Split(src, "\n")
+
+	version, at := 1, -1
+	for i, l := range lines {
+		if tableHeaderRe.MatchString(l) {
+			break
+		}
+		if m := versionRe.FindStringSubmatch(l); m != nil {
+			version, _ = strconv.Atoi(m[1])
+			at = i
+			break
+		}
+	}
+
+	switch {
+	case version > SchemaVersion:
+		return "", nil, fmt.Errorf("unsupported schema version %d; this testground supports versions 1 to %d", version, SchemaVersion)
+	case version == SchemaVersion:
+		return src, nil, nil
+	}
+
+	var notes []string
+	for v := version; v < SchemaVersion; v++ {
+		if v < len(migrations) && migrations[v] != nil {
+			var n []string
+			lines, n = migrations[v](lines)
+			notes = append(notes, n...)
+		}
+	}
+
+	stamp := fmt.Sprintf("version = %d", SchemaVersion)
+	if at >= 0 {
+		lines[at] = stamp
+	} else {
+		// the version goes first, after any leading comments.
+		i := 0
+		for i < len(lines) && (strings.HasPrefix(strings.TrimSpace(lines[i]), "#") || strings.TrimSpace(lines[i]) == "") {
+			i++
+		}
+		lines = append(lines[:i], append([]string{stamp, ""}, lines[i:]...)...)
+	}
+	notes = append(notes, fmt.Sprintf("set the schema version to %d", SchemaVersion))
+	return strings.Join(lines, "\n"), notes, nil
+}
+
+// migrateManifestDefaults comments out the [defaults] table of version 1
+// manifests. It was never read by testground, and is an unknown key in
+// version 2.
+func migrateManifestDefaults(lines []string) ([]string, []string) {
+	var (
+		res   = make([]string, 0, len(lines))
+		found bool
+		in    bool
+	)
+	for _, l := range lines {
+		if m := tableHeaderRe.FindStringSubmatch(l); m != nil {
+			in = m[1] == "defaults"
+			if in {
+				found = true
+				res = append(res, "# [defaults] is not read by testground; set the builder and runner in")
+				res = append(res, "# compositions, or in the [client] section of .env.toml instead.")
+			}
+		}
+		if in && strings.TrimSpace(l) != "" {
+			l = "# " + l
+		}
+		res = append(res, l)
+	}
+	if !found {
+		return lines, nil
+	}
+	return res, []string{"commented out the [defaults] table, which testground doesn't read"}
+}
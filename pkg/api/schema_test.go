@@ -0,0 +1,79 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testComposition = `
+[metadata]
+name = "foo"
+
+[global]
+plan = "foo_plan"
+case = "foo_case"
+
+  [global.run_config]
+  anything = { goes = "here" }
+
+[[groups]]
+id = "a"
+instances = { count = 1 }
+typo = true
+`
+
+func TestDecodeCompositionVersions(t *testing.T) {
+	// version 1 compositions are decoded leniently.
+	comp, warnings, err := DecodeComposition(testComposition)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, comp.Version)
+	require.Equal(t, "foo_plan", comp.Global.Plan)
+	require.Len(t, warnings, 2)
+	require.Equal(t, "version", warnings[0].Key)
+	require.Equal(t, "groups.typo", warnings[1].Key)
+	require.Equal(t, 15, warnings[1].Line)
+
+	// the current version is decoded strictly.
+	_, _, err = DecodeComposition("version = 2\n" + testComposition)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 16: groups.typo: unknown key")
+
+	comp, warnings, err = DecodeComposition("version = 2\n" + strings.Replace(testComposition, "typo = true", "", 1))
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Equal(t, "here", comp.Global.RunConfig["anything"].(map[string]interface{})["goes"])
+
+	_, _, err = DecodeComposition("version = 3\n" + testComposition)
+	require.Error(t, err)
+}
+
+func TestMigrateManifest(t *testing.T) {
+	src := `# a comment
+name = "foo"
+
+[defaults]
+builder = "exec:go"
+
+[builders."exec:go"]
+enabled = true
+`
+	res, notes, err := MigrateManifest(src)
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+	require.True(t, strings.HasPrefix(res, "# a comment\nversion = 2\n"))
+	require.Contains(t, res, "# builder = \"exec:go\"")
+
+	manifest, warnings, err := DecodeManifest(res)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Equal(t, "foo", manifest.Name)
+	require.Contains(t, manifest.Builders, "exec:go")
+
+	// migrating is idempotent.
+	again, notes, err := MigrateManifest(res)
+	require.NoError(t, err)
+	require.Empty(t, notes)
+	require.Equal(t, res, again)
+}
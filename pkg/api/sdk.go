@@ -0,0 +1,74 @@
+package api
+
+import "fmt"
+
+// The contract between testground and the SDKs that test plans are written
+// with, e.g. sdk-go, or a Rust SDK built by exec:rust or docker:rust.
+//
+// The runners pass the run environment to the instances in the environment
+// variables of SDKEnvVars. The instances connect to the sync service over a
+// websocket, at ws://$SYNC_SERVICE_HOST:$SYNC_SERVICE_PORT, and publish their
+// events, the JSON encoding of sdk-go's runtime.Event, e.g.
+// {"success_event":{"group":"peers"}}, on the topic of SDKRunEventsTopic; a
+// run succeeds once every instance published a success_event. They write
+// their logs and events, one JSON object per line, to SDKRunOutput, and their
+// metrics to SDKResultsOutput, in $TEST_OUTPUTS_PATH. Coverage builds of Go
+// plans write their coverage data, as with go build -cover, to $GOCOVERDIR, in
+// $TEST_OUTPUTS_PATH too, for the collector to merge.
+const (
+	// SDKRunEventsTopic is the format of the sync service topic the instances
+	// publish their events on, given the run, the plan and the case.
+	SDKRunEventsTopic = "run:%s:plan:%s:case:%s:run_events"
+
+	// SDKRunOutput is the output file of the logs and events of an instance.
+	SDKRunOutput = "run.out"
+
+	// SDKResultsOutput is the output file of the metrics of an instance, one
+	// JSON object per line, e.g. {"ts":...,"type":"counter","name":...}.
+	SDKResultsOutput = "results.out"
+
+	// SDKDefaultSyncServicePort is the port of the sync service, unless
+	// SYNC_SERVICE_PORT is set.
+	SDKDefaultSyncServicePort = 5050
+
+	// SDKEnvTestSubnetV6 is the variable of the IPv6 subnet of a dual-stack
+	// data network, which SDKs expose as the TestSubnetV6 of the run
+	// environment, next to the TestSubnet of TEST_SUBNET.
+	SDKEnvTestSubnetV6 = "TEST_SUBNET_V6"
+)
+
+// SDKEnvVar is an environment variable of the run environment of the
+// instances.
+type SDKEnvVar struct {
+	Name        string
+	Description string
+}
+
+// SDKEnvVars are the environment variables set by the runners, which the SDKs
+// read the run environment from.
+var SDKEnvVars = []SDKEnvVar{
+	{"TEST_PLAN", "the name of the test plan"},
+	{"TEST_CASE", "the name of the test case"},
+	{"TEST_RUN", "the ID of the run"},
+	{"TEST_REPO", "the repository of the test plan, if any"},
+	{"TEST_BRANCH", "the branch of the test plan, if any"},
+	{"TEST_TAG", "the tag of the test plan, if any"},
+	{"TEST_GROUP_ID", "the ID of the group of the instance"},
+	{"TEST_GROUP_INSTANCE_COUNT", "the number of instances of the group"},
+	{"TEST_INSTANCE_COUNT", "the number of instances of the run"},
+	{"TEST_INSTANCE_ROLE", "the role of the instance, if any"},
+	{"TEST_INSTANCE_PARAMS", "the parameters of the instance, as key=value pairs separated by |"},
+	{"TEST_OUTPUTS_PATH", "the directory the instance writes its outputs to"},
+	{"TEST_TEMP_PATH", "the directory the instance writes its temporary files to"},
+	{"TEST_SIDECAR", "true if the sidecar shapes the network of the instance"},
+	{"TEST_SUBNET", "the subnet of the data network of the run, in CIDR notation"},
+	{SDKEnvTestSubnetV6, "the IPv6 subnet of the data network of the run, in CIDR notation, if it's dual-stack"},
+	{"TEST_START_TIME", "the time the run started at, in RFC 3339"},
+	{"TEST_CAPTURE_PROFILES", "the profiles to capture, as kind=interval pairs separated by |"},
+	{"TEST_DISABLE_METRICS", "true if the instance mustn't send metrics to InfluxDB"},
+	{"GOCOVERDIR", "the directory coverage builds write their coverage data to; $TEST_OUTPUTS_PATH"},
+	{"SYNC_SERVICE_HOST", "the host of the sync service"},
+	{"SYNC_SERVICE_PORT", fmt.Sprintf("the port of the sync service; %d if unset", SDKDefaultSyncServicePort)},
+	{"INFLUXDB_URL", "the URL of InfluxDB, which the instance sends its metrics to"},
+	{"REDIS_HOST", "the host of redis, for SDKs that predate the sync service"},
+}
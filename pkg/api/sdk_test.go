@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/sdk-go/sync"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDKEnvVarsCoverSDK(t *testing.T) {
+	names := make(map[string]bool, len(SDKEnvVars))
+	for _, v := range SDKEnvVars {
+		require.False(t, names[v.Name], "duplicate env var %s", v.Name)
+		names[v.Name] = true
+	}
+
+	_, subnet, _ := net.ParseCIDR("16.0.0.0/16")
+	rp := runtime.RunParams{TestSubnet: &ptypes.IPNet{IPNet: *subnet}}
+	for name := range rp.ToEnvVars() {
+		require.True(t, names[name], "env var %s of sdk-go is missing", name)
+	}
+	for _, name := range []string{sync.EnvServiceHost, sync.EnvServicePort, runtime.EnvInfluxDBURL} {
+		require.True(t, names[name], "env var %s of sdk-go is missing", name)
+	}
+}
+
+func TestSDKEventsFormat(t *testing.T) {
+	b, err := json.Marshal(&runtime.Event{SuccessEvent: &runtime.SuccessEvent{TestGroupID: "peers"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"success_event":{"group":"peers"}}`, string(b))
+}
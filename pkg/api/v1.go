@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+)
+
+// The types below are the payloads of the version 1 of the HTTP API of the
+// daemon, served under /v1, which are not engine types. The API is described
+// by the OpenAPI document served at /v1/openapi.json.
+
+// QueuedResponse is the response to requests that queue a task.
+type QueuedResponse struct {
+	ID string `json:"id"`
+}
+
+// ErrorResponse is the body of the responses of failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// LogsMessageType is the type of a message of the logs of a task, streamed
+// over a WebSocket.
+type LogsMessageType string
+
+const (
+	LogsMessageLog      LogsMessageType = "log"      // Data holds output of the task
+	LogsMessageEnd      LogsMessageType = "end"      // Task holds the task, once the logs are over
+	LogsMessageError    LogsMessageType = "error"    // Error holds why streaming the logs failed
+	LogsMessageState    LogsMessageType = "state"    // Task holds the task, when its state or progress changed
+	LogsMessageInstance LogsMessageType = "instance" // Instance holds a line of output of an instance
+)
+
+// LogsMessage is a message of the logs of a task, streamed over a WebSocket.
+type LogsMessage struct {
+	Type  LogsMessageType `json:"type"`
+	Data  string          `json:"data,omitempty"`
+	Task  *task.Task      `json:"task,omitempty"`
+	Error string          `json:"error,omitempty"`
+
+	Instance *rpc.InstanceOutput `json:"instance,omitempty"`
+}
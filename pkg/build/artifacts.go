@@ -0,0 +1,108 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// Labels applied to the images built by the docker builders, so that they can
+// be found later on for artifact management. They must not vary between
+// builds of the same sources, or they would defeat the docker build cache.
+const (
+	artifactLabelBuilder = "testground.builder"
+	artifactLabelPlan    = "testground.plan"
+)
+
+func artifactLabels(builder, plan string) map[string]string {
+	return map[string]string{
+		artifactLabelBuilder: builder,
+		artifactLabelPlan:    plan,
+	}
+}
+
+// listDockerArtifacts returns the images labelled as built by the specified
+// builder.
+func listDockerArtifacts(ctx context.Context, builder string) ([]*api.Artifact, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	opts := types.ImageListOptions{Filters: filters.NewArgs()}
+	opts.Filters.Add("label", artifactLabelBuilder+"="+builder)
+
+	images, err := cli.ImageList(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("docker image list failed: %w", err)
+	}
+
+	res := make([]*api.Artifact, 0, len(images))
+	for _, img := range images {
+		res = append(res, &api.Artifact{
+			// use the short ID, as the builders report it.
+			ID:      strings.TrimPrefix(img.ID, "sha256:")[:12],
+			Builder: builder,
+			Plan:    img.Labels[artifactLabelPlan],
+			Created: time.Unix(img.Created, 0),
+			Size:    img.Size,
+			Tags:    img.RepoTags,
+			Labels:  img.Labels,
+		})
+	}
+	return res, nil
+}
+
+// removeDockerArtifact removes an image returned by listDockerArtifacts,
+// along with all its tags.
+func removeDockerArtifact(ctx context.Context, artifact *api.Artifact) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+
+	_, err = cli.ImageRemove(ctx, artifact.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+	return err
+}
+
+// listExecArtifacts returns the executables found in dir whose names are
+// <prefix><plan>-<build id>.
+func listExecArtifacts(dir, prefix, builder string) ([]*api.Artifact, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var res []*api.Artifact
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		i := strings.LastIndex(name, "-")
+		if i < len(prefix) {
+			continue
+		}
+		res = append(res, &api.Artifact{
+			ID:      filepath.Join(dir, name),
+			Builder: builder,
+			Plan:    name[len(prefix):i],
+			Created: e.ModTime(),
+			Size:    e.Size(),
+		})
+	}
+	return res, nil
+}
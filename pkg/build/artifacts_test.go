@@ -0,0 +1,39 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListExecArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"exec-go--network-8d3f0a1b2c4e", "exec-go--my-plan-0a1b2c3d4e5f", "exec-go--", "other"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("bin"), 0755))
+	}
+
+	artifacts, err := listExecArtifacts(dir, "exec-go--", "exec:go")
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+
+	plans := map[string]string{}
+	for _, a := range artifacts {
+		require.Equal(t, "exec:go", a.Builder)
+		require.EqualValues(t, 3, a.Size)
+		plans[filepath.Base(a.ID)] = a.Plan
+	}
+	require.Equal(t, map[string]string{
+		"exec-go--network-8d3f0a1b2c4e": "network",
+		"exec-go--my-plan-0a1b2c3d4e5f": "my-plan",
+	}, plans)
+
+	artifacts, err = listExecArtifacts(filepath.Join(dir, "missing"), "exec-go--", "exec:go")
+	require.NoError(t, err)
+	require.Empty(t, artifacts)
+}
@@ -0,0 +1,186 @@
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// RustSDKCrate is the crate of the Rust SDK, which is patched with the SDK
+// sources when the build is linked against them.
+const RustSDKCrate = "testground"
+
+// rustTargets maps the platforms of runs to the target triples that cargo
+// builds for.
+var rustTargets = map[string]string{
+	"linux/amd64":   "x86_64-unknown-linux-gnu",
+	"linux/arm64":   "aarch64-unknown-linux-gnu",
+	"linux/arm/v7":  "armv7-unknown-linux-gnueabihf",
+	"linux/386":     "i686-unknown-linux-gnu",
+	"darwin/amd64":  "x86_64-apple-darwin",
+	"darwin/arm64":  "aarch64-apple-darwin",
+	"windows/amd64": "x86_64-pc-windows-gnu",
+}
+
+// rustTarget returns the target triple of the platform, e.g.
+// aarch64-unknown-linux-gnu for linux/arm64.
+func rustTarget(platform string) (string, error) {
+	t, ok := rustTargets[platform]
+	if !ok {
+		return "", fmt.Errorf("no rust target known for platform %s; set the target option of the builder", platform)
+	}
+	return t, nil
+}
+
+// cargoProfileDir returns the directory of the target directory that cargo
+// writes the artifacts of the profile to.
+func cargoProfileDir(profile string) string {
+	switch profile {
+	case "", "release":
+		return "release"
+	case "dev", "test":
+		return "debug"
+	default:
+		return profile
+	}
+}
+
+// cargoProfileArgs returns the arguments selecting the profile of a cargo
+// build; release by default.
+func cargoProfileArgs(profile string) []string {
+	switch profile {
+	case "", "release":
+		return []string{"--release"}
+	default:
+		return []string{"--profile", profile}
+	}
+}
+
+type cargoManifest struct {
+	Package struct {
+		Name string `toml:"name"`
+	} `toml:"package"`
+	Bin []struct {
+		Name string `toml:"name"`
+	} `toml:"bin"`
+}
+
+// cargoBin returns the binary target of the package in dir to build: bin if
+// set, or else the only binary target of the package.
+func cargoBin(dir, bin string) (string, error) {
+	if bin != "" {
+		return bin, nil
+	}
+
+	var m cargoManifest
+	if _, err := toml.DecodeFile(filepath.Join(dir, "Cargo.toml"), &m); err != nil {
+		return "", fmt.Errorf("failed to read Cargo.toml: %w", err)
+	}
+
+	switch len(m.Bin) {
+	case 0:
+		if m.Package.Name == "" {
+			return "", fmt.Errorf("Cargo.toml declares no package")
+		}
+		return m.Package.Name, nil
+	case 1:
+		return m.Bin[0].Name, nil
+	default:
+		return "", fmt.Errorf("the package has %d binary targets; set the bin option of the builder", len(m.Bin))
+	}
+}
+
+// parseCargoLock returns the packages of a Cargo.lock file (as keys), and
+// their versions (as values). Packages locked at several versions map to
+// the versions joined with commas.
+func parseCargoLock(raw string) (map[string]string, error) {
+	var lock struct {
+		Package []struct {
+			Name    string `toml:"name"`
+			Version string `toml:"version"`
+		} `toml:"package"`
+	}
+	if _, err := toml.Decode(raw, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse Cargo.lock: %w", err)
+	}
+
+	versions := make(map[string][]string, len(lock.Package))
+	for _, p := range lock.Package {
+		versions[p.Name] = append(versions[p.Name], p.Version)
+	}
+
+	res := make(map[string]string, len(versions))
+	for name, vs := range versions {
+		sort.Strings(vs)
+		res[name] = strings.Join(vs, ",")
+	}
+	return res, nil
+}
+
+// cargoOverrides translates the dependency overrides of a build into crates.io
+// patches, and into the packages to pin to a version with cargo update. A
+// dependency is pinned when it targets itself, e.g. serde=1.0.130; it's
+// patched with the local crate at its directory when it's replaced with a
+// local directory, and with a git repository, at the version as a revision,
+// otherwise, e.g. serde=https://github.com/user/serde@a1b2c3d. The SDK crate is
+// patched with the SDK at sdk, if set.
+func cargoOverrides(deps map[string]api.DependencyTarget, sdk string) (patches []string, pins [][2]string) {
+	for name, dep := range deps {
+		switch {
+		case dep.Dir != "":
+			patches = append(patches, fmt.Sprintf("%s = { path = %s }", name, strconv.Quote(filepath.ToSlash(dep.Dir))))
+		case dep.Target == "" || dep.Target == name:
+			pins = append(pins, [2]string{name, dep.Version})
+		case dep.Version != "":
+			patches = append(patches, fmt.Sprintf("%s = { git = %s, rev = %s }", name, strconv.Quote(dep.Target), strconv.Quote(dep.Version)))
+		default:
+			patches = append(patches, fmt.Sprintf("%s = { git = %s }", name, strconv.Quote(dep.Target)))
+		}
+	}
+
+	if sdk != "" {
+		patches = append(patches, fmt.Sprintf("%s = { path = %s }", RustSDKCrate, strconv.Quote(sdk)))
+	}
+
+	sort.Strings(patches)
+	sort.Slice(pins, func(i, j int) bool { return pins[i][0] < pins[j][0] })
+	return patches, pins
+}
+
+// writeCargoPatches adds the crates.io patches to the cargo configuration of
+// the package in dir, which must not patch crates.io already.
+func writeCargoPatches(dir string, patches []string) error {
+	if len(patches) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(dir, ".cargo", "config.toml")
+	existing, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case strings.Contains(string(existing), "[patch.crates-io]"):
+		return fmt.Errorf("%s patches crates.io already; can't override dependencies", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.Write(existing)
+	b.WriteString("\n[patch.crates-io]\n")
+	for _, p := range patches {
+		b.WriteString(p + "\n")
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
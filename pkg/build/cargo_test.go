@@ -0,0 +1,100 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/testground/testground/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCargoBin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cargo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "Cargo.toml")
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("[package]\nname = \"testplan\"\n"), 0644))
+
+	bin, err := cargoBin(dir, "")
+	require.NoError(t, err)
+	require.Equal(t, "testplan", bin)
+
+	bin, err = cargoBin(dir, "other")
+	require.NoError(t, err)
+	require.Equal(t, "other", bin)
+
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("[package]\nname = \"testplan\"\n\n[[bin]]\nname = \"a\"\n\n[[bin]]\nname = \"b\"\n"), 0644))
+	_, err = cargoBin(dir, "")
+	require.Error(t, err)
+}
+
+func TestParseCargoLock(t *testing.T) {
+	deps, err := parseCargoLock(`version = 3
+
+[[package]]
+name = "serde"
+version = "1.0.136"
+
+[[package]]
+name = "rand"
+version = "0.8.5"
+
+[[package]]
+name = "rand"
+version = "0.7.3"
+`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"serde": "1.0.136", "rand": "0.7.3,0.8.5"}, deps)
+}
+
+func TestCargoOverrides(t *testing.T) {
+	patches, pins := cargoOverrides(map[string]api.DependencyTarget{
+		"serde": {Target: "serde", Version: "1.0.130"},
+		"rand":  {Target: "https://github.com/user/rand", Version: "a1b2c3d"},
+		"tokio": {Target: "/home/user/tokio", Dir: "../extra/tokio"},
+	}, "/sdk")
+
+	require.Equal(t, []string{
+		`rand = { git = "https://github.com/user/rand", rev = "a1b2c3d" }`,
+		`testground = { path = "/sdk" }`,
+		`tokio = { path = "../extra/tokio" }`,
+	}, patches)
+	require.Equal(t, [][2]string{{"serde", "1.0.130"}}, pins)
+}
+
+func TestWriteCargoPatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cargo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, writeCargoPatches(dir, []string{`testground = { path = "/sdk" }`}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, ".cargo", "config.toml"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "[patch.crates-io]\ntestground = { path = \"/sdk\" }\n")
+
+	// crates.io can only be patched once.
+	require.Error(t, writeCargoPatches(dir, []string{`rand = { path = "../rand" }`}))
+}
+
+func TestExecRustTarget(t *testing.T) {
+	target, err := execRustTarget(&ExecRustBuilderConfig{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, target)
+
+	target, err = execRustTarget(&ExecRustBuilderConfig{}, []string{"linux/arm/v7"})
+	require.NoError(t, err)
+	require.Equal(t, "armv7-unknown-linux-gnueabihf", target)
+
+	target, err = execRustTarget(&ExecRustBuilderConfig{Target: "x86_64-unknown-linux-musl"}, []string{"darwin/arm64"})
+	require.NoError(t, err)
+	require.Equal(t, "x86_64-unknown-linux-musl", target)
+
+	_, err = execRustTarget(&ExecRustBuilderConfig{}, []string{"linux/amd64", "linux/arm64"})
+	require.Error(t, err)
+	_, err = execRustTarget(&ExecRustBuilderConfig{}, []string{"plan9/amd64"})
+	require.Error(t, err)
+}
@@ -2,18 +2,61 @@ package build
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/rpc"
 )
 
+// goReplace returns the go mod edit flag replacing the module with the
+// version of its target, or with the local directory dir, if set.
+func goReplace(mod string, dep api.DependencyTarget, dir string) string {
+	if dir != "" {
+		return fmt.Sprintf("-replace=%s=%s", mod, filepath.ToSlash(dir))
+	}
+	if dep.Target == "" {
+		dep.Target = mod
+	}
+	return fmt.Sprintf("-replace=%s=%s@%s", mod, dep.Target, dep.Version)
+}
+
+// hasLocalDependencies returns whether a dependency is replaced with a local
+// directory.
+func hasLocalDependencies(deps map[string]api.DependencyTarget) bool {
+	for _, dep := range deps {
+		if dep.Dir != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// containerDependencies returns the dependencies with the directories of their
+// local replacements relative to dir.
+func containerDependencies(dir string, deps map[string]api.DependencyTarget) (map[string]api.DependencyTarget, error) {
+	res := make(map[string]api.DependencyTarget, len(deps))
+	for mod, dep := range deps {
+		if dep.Dir != "" {
+			rel, err := filepath.Rel(dir, dep.Dir)
+			if err != nil {
+				return nil, err
+			}
+			dep.Dir = rel
+		}
+		res[mod] = dep
+	}
+	return res, nil
+}
+
 func parseDependencies(raw string) map[string]string {
 	rawModules := strings.Split(raw, "\n")
 	modules := map[string]string{}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/testground/testground/pkg/api"
 )
 
 var testParseDependencies = []struct {
@@ -49,3 +51,20 @@ func TestParseDependencies(t *testing.T) {
 		}
 	}
 }
+
+func TestGoReplace(t *testing.T) {
+	tests := []struct {
+		dep  api.DependencyTarget
+		dir  string
+		want string
+	}{
+		{api.DependencyTarget{Version: "v0.18.0"}, "", "-replace=github.com/libp2p/go-libp2p=github.com/libp2p/go-libp2p@v0.18.0"},
+		{api.DependencyTarget{Target: "github.com/user/go-libp2p", Version: "v0.18.1"}, "", "-replace=github.com/libp2p/go-libp2p=github.com/user/go-libp2p@v0.18.1"},
+		{api.DependencyTarget{Target: "/home/user/go-libp2p"}, "../extra/go-libp2p", "-replace=github.com/libp2p/go-libp2p=../extra/go-libp2p"},
+	}
+	for _, test := range tests {
+		if got := goReplace("github.com/libp2p/go-libp2p", test.dep, test.dir); got != test.want {
+			t.Errorf("expected %s, got %s", test.want, got)
+		}
+	}
+}
@@ -17,7 +17,8 @@ import (
 )
 
 var (
-	_ api.Builder = &DockerGenericBuilder{}
+	_ api.Builder          = &DockerGenericBuilder{}
+	_ api.ArtifactProducer = &DockerGenericBuilder{}
 )
 
 type DockerGenericBuilder struct {
@@ -26,11 +27,22 @@ type DockerGenericBuilder struct {
 
 type DockerGenericBuilderConfig struct {
 	// Custom base path where we find the test source
-	Path      string             `toml:"path" default:"./"`
+	Path string `toml:"path" default:"./"`
+
+	// Dockerfile is the path of the Dockerfile, relative to Path (default:
+	// "Dockerfile").
+	Dockerfile string `toml:"dockerfile"`
+
+	// BuildArgs are passed to the build, along with the ones of testground,
+	// which they override: PLAN_PATH, TESTGROUND_PLAN and
+	// TESTGROUND_BUILD_ID.
 	BuildArgs map[string]*string `toml:"build_args"` // ok if nil
+
+	DockerPlatformsConfig
 }
 
-// Build builds a testplan written in Go and outputs a Docker container.
+// Build builds the Dockerfile of a testplan, written in any language, and
+// outputs a Docker container.
 func (b *DockerGenericBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
 	cfg, ok := in.BuildConfig.(*DockerGenericBuilderConfig)
 	if !ok {
@@ -50,22 +62,17 @@ func (b *DockerGenericBuilder) Build(ctx context.Context, in *api.BuildInput, ow
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
 
-	planPath := cfg.Path
-	basePathForPlan := path.Join("/plan", planPath)
-
-	if cfg.BuildArgs == nil {
-		cfg.BuildArgs = make(map[string]*string)
-	}
-
-	if _, ok = cfg.BuildArgs["PLAN_PATH"]; !ok {
-		cfg.BuildArgs["PLAN_PATH"] = &cfg.Path
+	dockerfile := cfg.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
 	}
 
 	opts := types.ImageBuildOptions{
 		Tags:        []string{in.BuildID},
-		BuildArgs:   cfg.BuildArgs,
+		Labels:      artifactLabels(b.ID(), in.TestPlan),
+		BuildArgs:   genericBuildArgs(cfg, in),
 		NetworkMode: "host",
-		Dockerfile:  filepath.Join(basePathForPlan, "Dockerfile"),
+		Dockerfile:  filepath.Join(path.Join("/plan", cfg.Path), dockerfile),
 	}
 
 	imageOpts := docker.BuildImageOpts{
@@ -73,6 +80,11 @@ func (b *DockerGenericBuilder) Build(ctx context.Context, in *api.BuildInput, ow
 		BuildOpts: &opts,
 	}
 
+	pushed, err := cfg.setupPlatforms(ctx, cli, in, &imageOpts)
+	if err != nil {
+		return nil, err
+	}
+
 	buildStart := time.Now()
 
 	_, err = docker.BuildImage(ctx, ow, cli, &imageOpts)
@@ -82,6 +94,11 @@ func (b *DockerGenericBuilder) Build(ctx context.Context, in *api.BuildInput, ow
 
 	ow.Infow("build completed", "default_tag", fmt.Sprintf("%s:latest", in.BuildID), "took", time.Since(buildStart).Truncate(time.Second))
 
+	if pushed != "" {
+		ow.Infow("pushed multi-platform image", "image", pushed, "platforms", in.Platforms)
+		return &api.BuildOutput{ArtifactPath: pushed}, nil
+	}
+
 	imageID, err := docker.GetImageID(ctx, cli, in.BuildID)
 	if err != nil {
 		return nil, fmt.Errorf("couldnt get docker image id: %w", err)
@@ -101,13 +118,40 @@ func (b *DockerGenericBuilder) Build(ctx context.Context, in *api.BuildInput, ow
 		return out, err
 	}
 
+	if out.ArtifactPath, err = cfg.publish(ctx, ow, cli, in, imageID); err != nil {
+		return nil, err
+	}
+
 	return out, err
 }
 
+// genericBuildArgs returns the build args of the configuration, along with
+// the ones testground passes to every build unless they're configured.
+func genericBuildArgs(cfg *DockerGenericBuilderConfig, in *api.BuildInput) map[string]*string {
+	args := map[string]*string{
+		"PLAN_PATH":           &cfg.Path,
+		"TESTGROUND_PLAN":     &in.TestPlan,
+		"TESTGROUND_BUILD_ID": &in.BuildID,
+	}
+	for k, v := range cfg.BuildArgs {
+		args[k] = v
+	}
+	return args
+}
+
 func (*DockerGenericBuilder) ID() string {
 	return "docker:generic"
 }
 
+// Produces reports that the builder produces docker images.
+func (*DockerGenericBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{Kind: api.ArtifactDockerImage}
+}
+
+func (*DockerGenericBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	return dockerHealthcheck(ctx, fix)
+}
+
 func (*DockerGenericBuilder) ConfigType() reflect.Type {
 	return reflect.TypeOf(DockerGenericBuilderConfig{})
 }
@@ -115,3 +159,13 @@ func (*DockerGenericBuilder) ConfigType() reflect.Type {
 func (*DockerGenericBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
 	return fmt.Errorf("purge not implemented for docker:generic")
 }
+
+// ListArtifacts returns the images built by this builder.
+func (b *DockerGenericBuilder) ListArtifacts(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listDockerArtifacts(ctx, b.ID())
+}
+
+// RemoveArtifact removes an image built by this builder.
+func (b *DockerGenericBuilder) RemoveArtifact(ctx context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return removeDockerArtifact(ctx, artifact)
+}
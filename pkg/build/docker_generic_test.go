@@ -0,0 +1,29 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/testground/testground/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenericBuildArgs(t *testing.T) {
+	var (
+		image = "rust:1.56"
+		path  = "custom"
+		cfg   = &DockerGenericBuilderConfig{
+			Path:      "./",
+			BuildArgs: map[string]*string{"BUILD_IMAGE": &image, "PLAN_PATH": &path},
+		}
+		in = &api.BuildInput{BuildID: "b1", TestPlan: "network"}
+	)
+
+	args := genericBuildArgs(cfg, in)
+	require.Len(t, args, 4)
+	require.Equal(t, "custom", *args["PLAN_PATH"])
+	require.Equal(t, "network", *args["TESTGROUND_PLAN"])
+	require.Equal(t, "b1", *args["TESTGROUND_BUILD_ID"])
+	require.Equal(t, "rust:1.56", *args["BUILD_IMAGE"])
+	require.Len(t, cfg.BuildArgs, 2, "the configuration must be left untouched")
+}
@@ -32,8 +32,9 @@ const (
 )
 
 var (
-	_ api.Builder      = &DockerGoBuilder{}
-	_ api.Terminatable = &DockerGoBuilder{}
+	_ api.Builder          = &DockerGoBuilder{}
+	_ api.Terminatable     = &DockerGoBuilder{}
+	_ api.ArtifactProducer = &DockerGoBuilder{}
 
 	goDockerfileTmpl = template.Must(template.New("Dockerfile").Parse(GoDockerfileTemplate))
 )
@@ -84,7 +85,8 @@ type DockerGoBuilderConfig struct {
 	RuntimeImage string `toml:"runtime_image"`
 
 	// BuildBaseImage is the base build image that the test plan binary will be
-	// built from. Defaults to golang:1.16-buster
+	// built from. Defaults to golang:1.16-buster, or to golang:<go_version> if
+	// go_version is set.
 	BuildBaseImage string `toml:"build_base_image"`
 
 	// SkipRuntimeImage allows you to skip putting the build output in a
@@ -113,14 +115,55 @@ type DockerGoBuilderConfig struct {
 
 	// DockefileExtensions enables plans to inject custom Dockerfile directives.
 	DockerfileExtensions DockerfileExtensions `toml:"dockerfile_extensions"`
+
+	GoToolchainConfig
+	DockerPlatformsConfig
+}
+
+// Validate validates the toolchain settings of the configuration.
+func (c *DockerGoBuilderConfig) Validate() error {
+	if c.GoVersion != "" && c.BuildBaseImage != "" {
+		return fmt.Errorf("go_version and build_base_image are mutually exclusive")
+	}
+	if c.Cover && c.RuntimeImage == "scratch" {
+		return fmt.Errorf("coverage builds require a shell in the runtime image; scratch has none")
+	}
+	if c.Cover && !c.coverSupported() {
+		return fmt.Errorf("coverage builds require Go 1.20 or later; set go_version, or a golang:1.20 or later build_base_image")
+	}
+	return c.GoToolchainConfig.Validate()
+}
+
+// coverSupported returns whether the go of the build base image is known to
+// build with -cover: the version of an official golang image, as tagged, is
+// Go 1.20 or later.
+func (c *DockerGoBuilderConfig) coverSupported() bool {
+	v := c.GoVersion
+	if v == "" {
+		image := c.BuildBaseImage
+		if image == "" {
+			image = DefaultGoBuildBaseImage
+		}
+		if !strings.HasPrefix(image, "golang:") {
+			return false
+		}
+		v = strings.TrimPrefix(image, "golang:")
+	}
+	var minor int
+	if _, err := fmt.Sscanf(v, "1.%d", &minor); err != nil {
+		return false
+	}
+	return minor >= 20
 }
 
 type DockerfileTemplateVars struct {
 	WithSDK              bool
+	WithExtra            bool
 	RuntimeImage         string
 	DockerfileExtensions DockerfileExtensions
 	SkipRuntimeImage     bool
 	CgoEnabled           int
+	Cover                bool
 }
 
 // Build builds a testplan written in Go and outputs a Docker container.
@@ -159,16 +202,19 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 	}
 
 	cgoEnabled := 0
-	if cfg.EnableCGO {
+	if cfg.EnableCGO || cfg.Race {
+		// the race detector requires cgo.
 		cgoEnabled = 1
 	}
 
 	vars := &DockerfileTemplateVars{
 		WithSDK:              sdksrc != "",
+		WithExtra:            hasLocalDependencies(in.Dependencies),
 		RuntimeImage:         cfg.RuntimeImage,
 		DockerfileExtensions: cfg.DockerfileExtensions,
 		SkipRuntimeImage:     cfg.SkipRuntimeImage,
 		CgoEnabled:           cgoEnabled,
+		Cover:                cfg.Cover,
 	}
 
 	if err = goDockerfileTmpl.Execute(f, &vars); err != nil {
@@ -210,17 +256,26 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 	}
 
 	// fall back to default build base image, if one is not configured explicitly.
-	if cfg.BuildBaseImage == "" {
+	switch {
+	case cfg.BuildBaseImage != "":
+	case cfg.GoVersion != "":
+		cfg.BuildBaseImage = "golang:" + cfg.GoVersion
+	default:
 		cfg.BuildBaseImage = DefaultGoBuildBaseImage
 	}
 
-	// If we have version overrides, apply them.
+	// If we have version overrides, apply them. Local replacements are
+	// relative to the module of the plan, as the sources are laid out the
+	// same in the build container.
 	var replaces []string
 	for mod, ver := range in.Dependencies {
-		if ver.Target == "" {
-			ver.Target = mod
+		dir := ""
+		if ver.Dir != "" {
+			if dir, err = filepath.Rel(filepath.Join(plansrc, cfg.Path), ver.Dir); err != nil {
+				return nil, err
+			}
 		}
-		replaces = append(replaces, fmt.Sprintf("-replace=%s=%s@%s", mod, ver.Target, ver.Version))
+		replaces = append(replaces, goReplace(mod, ver, dir))
 	}
 
 	// Inject replace directives for the SDK modules.
@@ -243,11 +298,19 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 	}
 
 	// initial go build args.
+	ldflags := cfg.ldflags()
 	var args = map[string]*string{
 		"GO_PROXY":    &proxyURL,
 		"MODFILE":     &modfile,
 		"MODFILE_SUM": &modfileSum,
 		"PLAN_PATH":   &cfg.Path,
+		"LDFLAGS":     &ldflags,
+		"GCFLAGS":     &cfg.Gcflags,
+	}
+
+	if flags := cfg.modeFlags(); len(flags) > 0 {
+		s := strings.Join(flags, " ")
+		args["BUILD_MODE"] = &s
 	}
 
 	if cfg.ExecPkg != "" {
@@ -277,9 +340,9 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 
 	args["BUILD_BASE_IMAGE"] = &baseImage
 
-	// set BUILD_TAGS arg if the user has provided selectors.
-	if len(in.Selectors) > 0 {
-		s := "-tags " + strings.Join(in.Selectors, ",")
+	// set BUILD_TAGS arg if the user has provided build tags or selectors.
+	if tags := cfg.buildTags(in.Selectors); tags != "" {
+		s := "-tags " + tags
 		args["BUILD_TAGS"] = &s
 	}
 
@@ -287,6 +350,7 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 	// so the builder can make use of the goproxy container.
 	opts := types.ImageBuildOptions{
 		Tags:        []string{in.BuildID},
+		Labels:      artifactLabels(b.ID(), in.TestPlan),
 		BuildArgs:   args,
 		NetworkMode: "host",
 	}
@@ -301,6 +365,15 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 		BuildOpts: &opts,
 	}
 
+	pushed, err := cfg.setupPlatforms(ctx, cli, in, &imageOpts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.EnableGoBuildCache && len(imageOpts.Platforms) > 0 {
+		// the cache image is of the platform of the docker daemon.
+		return nil, fmt.Errorf("unable to use go build cache when building for %s", strings.Join(imageOpts.Platforms, ", "))
+	}
+
 	buildStart := time.Now()
 
 	buildOutput, err := docker.BuildImage(ctx, ow, cli, &imageOpts)
@@ -324,6 +397,12 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 		}
 	}
 
+	if pushed != "" {
+		// the image isn't in the docker daemon, so its dependencies can't be listed.
+		ow.Infow("pushed multi-platform image", "image", pushed, "platforms", in.Platforms)
+		return &api.BuildOutput{ArtifactPath: pushed}, nil
+	}
+
 	imageID, err := docker.GetImageID(ctx, cli, in.BuildID)
 	if err != nil {
 		return nil, fmt.Errorf("couldnt get docker image id: %w", err)
@@ -339,6 +418,7 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 	out := &api.BuildOutput{
 		ArtifactPath: imageID,
 		Dependencies: deps,
+		Toolchain:    cfg.BuildBaseImage,
 	}
 
 	// Testplan image tag
@@ -349,6 +429,10 @@ func (b *DockerGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc
 		return out, err
 	}
 
+	if out.ArtifactPath, err = cfg.publish(ctx, ow, cli, in, imageID); err != nil {
+		return nil, err
+	}
+
 	return out, nil
 }
 
@@ -386,6 +470,15 @@ func (*DockerGoBuilder) ID() string {
 	return "docker:go"
 }
 
+// Produces reports that the builder produces docker images.
+func (*DockerGoBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{Kind: api.ArtifactDockerImage}
+}
+
+func (*DockerGoBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	return dockerHealthcheck(ctx, fix)
+}
+
 func (*DockerGoBuilder) ConfigType() reflect.Type {
 	return reflect.TypeOf(DockerGoBuilderConfig{})
 }
@@ -562,6 +655,16 @@ func (b *DockerGoBuilder) Purge(ctx context.Context, testplan string, ow *rpc.Ou
 	return nil
 }
 
+// ListArtifacts returns the images built by this builder.
+func (b *DockerGoBuilder) ListArtifacts(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listDockerArtifacts(ctx, b.ID())
+}
+
+// RemoveArtifact removes an image built by this builder.
+func (b *DockerGoBuilder) RemoveArtifact(ctx context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return removeDockerArtifact(ctx, artifact)
+}
+
 const GoDockerfileTemplate = `
 # BUILD_BASE_IMAGE is the base image to use for the build. It contains a rolling
 # accumulation of Go build/package caches.
@@ -604,6 +707,14 @@ ARG GO_PROXY=direct
 # BUILD_TAGS is either nothing, or when expanded, it expands to "-tags <comma-separated build tags>"
 ARG BUILD_TAGS
 
+# LDFLAGS and GCFLAGS are the flags of the linker and of the compiler. Builds
+# are reproducible, with -trimpath and an empty build ID.
+ARG LDFLAGS
+ARG GCFLAGS
+
+# BUILD_MODE is either nothing, or -race and/or -cover.
+ARG BUILD_MODE
+
 # TESTPLAN_EXEC_PKG is the executable package within this test plan we want to build.
 ENV TESTPLAN_EXEC_PKG ${TESTPLAN_EXEC_PKG}
 
@@ -625,6 +736,11 @@ COPY /plan/${PLAN_PATH}/${MODFILE_SUM} ${PLAN_DIR}/go.sum
 COPY /sdk/go.mod /sdk/go.mod
 {{end}}
 
+{{if .WithExtra}}
+# The local replacements of dependencies are shipped with the extra sources.
+COPY /extra /extra
+{{end}}
+
 # Download deps.
 RUN echo "Using go proxy: ${GO_PROXY}" \
     && cd ${PLAN_DIR} \
@@ -647,7 +763,7 @@ RUN cp /tmp/go.mod /tmp/go.sum ${PLAN_DIR}/
 
 RUN cd ${PLAN_DIR} \
     && go env -w GOPROXY="${GO_PROXY}" \
-    && CGO_ENABLED=${CgoEnabled} GOOS=linux go build -o ${PLAN_DIR}/testplan.bin ${BUILD_TAGS} ${TESTPLAN_EXEC_PKG}
+    && CGO_ENABLED={{.CgoEnabled}} GOOS=linux go build -trimpath -o ${PLAN_DIR}/testplan.bin ${BUILD_MODE} ${BUILD_TAGS} -ldflags="${LDFLAGS}" -gcflags="${GCFLAGS}" ${TESTPLAN_EXEC_PKG}
 
 {{.DockerfileExtensions.PostBuild}}
 
@@ -693,5 +809,10 @@ RUN mv ${PLAN_DIR}/testplan.bin /testplan
 {{ end }}
 
 EXPOSE 6060
+{{ if .Cover }}
+# Coverage builds write their coverage data to the outputs of the instance.
+ENTRYPOINT [ "/bin/sh", "-c", "GOCOVERDIR=${TEST_OUTPUTS_PATH} exec /testplan" ]
+{{ else }}
 ENTRYPOINT [ "/testplan"]
+{{ end }}
 `
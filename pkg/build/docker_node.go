@@ -2,10 +2,14 @@ package build
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"text/template"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -20,15 +24,33 @@ const (
 )
 
 var (
-	_ api.Builder = &DockerNodeBuilder{}
+	_ api.Builder          = &DockerNodeBuilder{}
+	_ api.ArtifactProducer = &DockerNodeBuilder{}
+
+	nodeDockerfileTmpl = template.Must(template.New("Dockerfile").Parse(NodeDockerfileTemplate))
 )
 
+// DockerNodeBuilder (id: "docker:node") builds the test plan, written in
+// JavaScript or TypeScript, into a container with node.
+//
+// The dependencies of the plan are installed with npm or yarn in a layer of
+// their own, which is only rebuilt when package.json or the lockfile change,
+// and the plan is then built by the build script of package.json, if any.
 type DockerNodeBuilder struct{}
 
 func (d DockerNodeBuilder) ID() string {
 	return "docker:node"
 }
 
+// Produces reports that the builder produces docker images.
+func (d DockerNodeBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{Kind: api.ArtifactDockerImage}
+}
+
+func (d DockerNodeBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	return dockerHealthcheck(ctx, fix)
+}
+
 func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
 	cfg, ok := in.BuildConfig.(*DockerNodeBuilderConfig)
 	if !ok {
@@ -37,31 +59,51 @@ func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rp
 
 	cliopts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
 	basesrc := in.UnpackedSources.BaseDir
+	plansrc := in.UnpackedSources.PlanDir
 
 	cli, err := client.NewClientWithOpts(cliopts...)
 	if err != nil {
 		return nil, err
 	}
 
+	vars, err := nodeDockerfileVars(cfg, in)
+	if err != nil {
+		return nil, err
+	}
+	ow.Infow("building node plan", "package_manager", vars.PackageManager, "build_script", vars.BuildScript)
+
 	// Write the Dockerfile.
 	dockerfileDst := filepath.Join(basesrc, "Dockerfile")
-	err = ioutil.WriteFile(dockerfileDst, []byte(NodeDockerfileTemplate), 0644)
+	f, err := os.Create(dockerfileDst)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Dockerfile at %s: %w", dockerfileDst, err)
 	}
+	err = nodeDockerfileTmpl.Execute(f, vars)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Dockerfile template and/or write into file %s: %w", dockerfileDst, err)
+	}
+
+	// The dependencies are installed in the image; those of the sources would
+	// overwrite them.
+	if err := os.RemoveAll(filepath.Join(plansrc, "node_modules")); err != nil {
+		return nil, fmt.Errorf("cleanup failed; %w", err)
+	}
 
 	// fall back to default build base image, if one is not configured explicitly.
-	if cfg.BaseImage == "" {
-		cfg.BaseImage = DefaultNodeBuildBaseImage
+	baseImage := cfg.BaseImage
+	if baseImage == "" {
+		baseImage = DefaultNodeBuildBaseImage
 	}
 
 	// build args
 	var args = map[string]*string{
-		"BASE_IMAGE": &cfg.BaseImage,
+		"BASE_IMAGE": &baseImage,
 	}
 
 	opts := types.ImageBuildOptions{
 		Tags:        []string{in.BuildID},
+		Labels:      artifactLabels(d.ID(), in.TestPlan),
 		BuildArgs:   args,
 		NetworkMode: "host",
 	}
@@ -71,6 +113,11 @@ func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rp
 		BuildOpts: &opts,
 	}
 
+	pushed, err := cfg.setupPlatforms(ctx, cli, in, &imageOpts)
+	if err != nil {
+		return nil, err
+	}
+
 	buildStart := time.Now()
 
 	_, err = docker.BuildImage(ctx, ow, cli, &imageOpts)
@@ -80,6 +127,12 @@ func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rp
 
 	ow.Infow("build completed", "default_tag", fmt.Sprintf("%s:latest", in.BuildID), "took", time.Since(buildStart).Truncate(time.Second))
 
+	if pushed != "" {
+		// the image isn't in the docker daemon, so its dependencies can't be listed.
+		ow.Infow("pushed multi-platform image", "image", pushed, "platforms", in.Platforms)
+		return &api.BuildOutput{ArtifactPath: pushed}, nil
+	}
+
 	imageID, err := docker.GetImageID(ctx, cli, in.BuildID)
 	if err != nil {
 		return nil, fmt.Errorf("couldnt get docker image id: %w", err)
@@ -87,8 +140,14 @@ func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rp
 
 	ow.Infow("got docker image id", "image_id", imageID)
 
+	deps, err := parseDependenciesFromDocker(ctx, ow, cli, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list package dependencies; %w", err)
+	}
+
 	out := &api.BuildOutput{
 		ArtifactPath: imageID,
+		Dependencies: deps,
 	}
 
 	// Testplan image tag
@@ -99,13 +158,114 @@ func (d DockerNodeBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rp
 		return out, err
 	}
 
+	if out.ArtifactPath, err = cfg.publish(ctx, ow, cli, in, imageID); err != nil {
+		return nil, err
+	}
+
 	return out, err
 }
 
+// nodeDockerfileVars returns the variables of the Dockerfile of the plan: the
+// package manager that installs its dependencies, yarn if it has a yarn.lock
+// and npm otherwise, unless configured, whether the install is frozen to a
+// lockfile, and the script that builds it, e.g. with tsc; the build script
+// of package.json is run if there is one, unless configured otherwise.
+func nodeDockerfileVars(cfg *DockerNodeBuilderConfig, in *api.BuildInput) (*NodeDockerfileTemplateVars, error) {
+	plansrc := in.UnpackedSources.PlanDir
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	b, err := ioutil.ReadFile(filepath.Join(plansrc, "package.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package.json: %w", err)
+	}
+
+	exists := func(f string) bool {
+		_, err := os.Stat(filepath.Join(plansrc, f))
+		return err == nil
+	}
+
+	vars := &NodeDockerfileTemplateVars{
+		PackageManager: cfg.PackageManager,
+		BuildScript:    cfg.BuildScript,
+		WithSDK:        in.UnpackedSources.SDKDir != "",
+	}
+
+	switch vars.PackageManager {
+	case "":
+		vars.PackageManager = "npm"
+		if exists("yarn.lock") {
+			vars.PackageManager = "yarn"
+		}
+	case "npm", "yarn":
+	default:
+		return nil, fmt.Errorf("unsupported package manager %q; expected npm or yarn", cfg.PackageManager)
+	}
+
+	if vars.PackageManager == "yarn" {
+		vars.Frozen = exists("yarn.lock")
+	} else {
+		vars.Frozen = exists("package-lock.json") || exists("npm-shrinkwrap.json")
+	}
+
+	switch vars.BuildScript {
+	case "":
+		if _, ok := pkg.Scripts["build"]; ok {
+			vars.BuildScript = "build"
+		}
+	case "-":
+		vars.BuildScript = ""
+	default:
+		if _, ok := pkg.Scripts[vars.BuildScript]; !ok {
+			return nil, fmt.Errorf("package.json has no %s script", vars.BuildScript)
+		}
+	}
+
+	// If we have version overrides, apply them, e.g. libp2p=0.36.2 or
+	// libp2p=github:user/js-libp2p#fix. Local replacements are installed from
+	// the extra sources, at the same path in the build container.
+	for name, dep := range in.Dependencies {
+		var spec string
+		switch {
+		case dep.Dir != "":
+			rel, err := filepath.Rel(in.UnpackedSources.BaseDir, dep.Dir)
+			if err != nil {
+				return nil, err
+			}
+			spec = name + "@file:/" + filepath.ToSlash(rel)
+			vars.WithExtra = true
+		case dep.Target == "" || dep.Target == name:
+			spec = name + "@" + dep.Version
+		case dep.Version == "":
+			spec = name + "@" + dep.Target
+		default:
+			spec = name + "@npm:" + dep.Target + "@" + dep.Version
+		}
+		vars.Overrides = append(vars.Overrides, spec)
+	}
+	sort.Strings(vars.Overrides)
+
+	return vars, nil
+}
+
 func (d DockerNodeBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
 	return fmt.Errorf("purge not implemented for docker:node")
 }
 
+// ListArtifacts returns the images built by this builder.
+func (d DockerNodeBuilder) ListArtifacts(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listDockerArtifacts(ctx, d.ID())
+}
+
+// RemoveArtifact removes an image built by this builder.
+func (d DockerNodeBuilder) RemoveArtifact(ctx context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return removeDockerArtifact(ctx, artifact)
+}
+
 func (d DockerNodeBuilder) ConfigType() reflect.Type {
 	return reflect.TypeOf(DockerNodeBuilderConfig{})
 }
@@ -113,15 +273,88 @@ func (d DockerNodeBuilder) ConfigType() reflect.Type {
 type DockerNodeBuilderConfig struct {
 	Enabled   bool
 	BaseImage string `toml:"base_image"`
+
+	// PackageManager is the package manager installing the dependencies of
+	// the plan, npm or yarn; yarn if the plan has a yarn.lock, and npm
+	// otherwise, if empty.
+	PackageManager string `toml:"package_manager"`
+
+	// BuildScript is the script of package.json that builds the plan once its
+	// dependencies are installed, e.g. compiling TypeScript; "build" if
+	// package.json has one, if empty, and none if "-".
+	BuildScript string `toml:"build_script"`
+
+	DockerPlatformsConfig
+}
+
+type NodeDockerfileTemplateVars struct {
+	PackageManager string
+	Frozen         bool
+	BuildScript    string
+	WithSDK        bool
+	WithExtra      bool
+	Overrides      []string
 }
 
 const NodeDockerfileTemplate = `
 ARG BASE_IMAGE
 FROM ${BASE_IMAGE} AS builder
+
+# PLAN_DIR is the location containing the plan source inside the container.
 ENV PLAN_DIR /plan
+
+# SDK_DIR is the location containing the (optional) sdk source inside the container.
+ENV SDK_DIR /sdk
+
 WORKDIR /plan
+
+{{if .WithSDK}}
+COPY /sdk ${SDK_DIR}
+{{end}}
+
+{{if .WithExtra}}
+# The local replacements of dependencies are shipped with the extra sources.
+COPY /extra /extra
+{{end}}
+
+# Copy only the manifest and the lockfiles, and install the dependencies, in
+# order to leverage Docker caching.
+COPY /plan/package.json /plan/package-lock.json* /plan/npm-shrinkwrap.json* /plan/yarn.lock* ${PLAN_DIR}/
+
+{{if eq .PackageManager "yarn" -}}
+RUN yarn install{{if .Frozen}} --frozen-lockfile{{end}}
+{{- range .Overrides}} \
+    && yarn add {{.}}
+{{- end}}
+{{- if .WithSDK}} \
+    && yarn add file:${SDK_DIR}
+{{- end}}
+{{- else -}}
+RUN npm {{if .Frozen}}ci{{else}}install{{end}}
+{{- if .Overrides}} \
+    && npm install --no-save{{range .Overrides}} {{.}}{{end}}
+{{- end}}
+{{- if .WithSDK}} \
+    && npm install --no-save ${SDK_DIR}
+{{- end}}
+{{- end}}
+
+# Now copy the rest of the source, and build it.
 COPY . /
-RUN npm ci
+
+{{if .BuildScript}}
+RUN {{.PackageManager}} run {{.BuildScript}}
+{{end}}
+
+# Store package dependencies
+RUN node -e 'const fs = require("fs"), path = require("path"); \
+    const walk = (dir) => fs.readdirSync(dir).forEach((n) => { \
+      const f = path.join(dir, n); \
+      if (n.startsWith("@")) return walk(f); \
+      try { const p = JSON.parse(fs.readFileSync(path.join(f, "package.json"))); console.log(p.name + " " + p.version) } catch (e) {} \
+    }); \
+    if (fs.existsSync("node_modules")) walk("node_modules")' > /testground_dep_list
+
 EXPOSE 6060
 ENTRYPOINT [ "npm", "start"]
 `
@@ -0,0 +1,65 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/testground/testground/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeDockerfileVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("package.json", `{"scripts": {"start": "node dist/index.js", "build": "tsc"}}`)
+
+	in := &api.BuildInput{
+		UnpackedSources: &api.UnpackedSources{PlanDir: dir},
+		Dependencies:    map[string]api.DependencyTarget{"libp2p": {Target: "libp2p", Version: "0.36.2"}},
+	}
+
+	vars, err := nodeDockerfileVars(&DockerNodeBuilderConfig{}, in)
+	require.NoError(t, err)
+	require.Equal(t, &NodeDockerfileTemplateVars{
+		PackageManager: "npm",
+		BuildScript:    "build",
+		Overrides:      []string{"libp2p@0.36.2"},
+	}, vars)
+
+	write("yarn.lock", "")
+	vars, err = nodeDockerfileVars(&DockerNodeBuilderConfig{BuildScript: "-"}, in)
+	require.NoError(t, err)
+	require.Equal(t, "yarn", vars.PackageManager)
+	require.True(t, vars.Frozen)
+	require.Empty(t, vars.BuildScript)
+
+	_, err = nodeDockerfileVars(&DockerNodeBuilderConfig{BuildScript: "compile"}, in)
+	require.Error(t, err)
+	_, err = nodeDockerfileVars(&DockerNodeBuilderConfig{PackageManager: "pnpm"}, in)
+	require.Error(t, err)
+
+	local := &api.BuildInput{
+		UnpackedSources: &api.UnpackedSources{BaseDir: filepath.Dir(dir), PlanDir: dir},
+		Dependencies: map[string]api.DependencyTarget{
+			"libp2p": {Target: "/home/user/js-libp2p", Dir: filepath.Join(filepath.Dir(dir), "extra", "js-libp2p")},
+		},
+	}
+	localVars, err := nodeDockerfileVars(&DockerNodeBuilderConfig{}, local)
+	require.NoError(t, err)
+	require.True(t, localVars.WithExtra)
+	require.Equal(t, []string{"libp2p@file:/extra/js-libp2p"}, localVars.Overrides)
+
+	var b strings.Builder
+	require.NoError(t, nodeDockerfileTmpl.Execute(&b, vars))
+	require.Contains(t, b.String(), "RUN yarn install --frozen-lockfile \\\n    && yarn add libp2p@0.36.2\n")
+	require.NotContains(t, b.String(), "yarn run")
+}
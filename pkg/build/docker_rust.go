@@ -0,0 +1,330 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/docker"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+const (
+	DefaultRustBuildBaseImage = "rust:1.59-bullseye"
+	DefaultRustRuntimeImage   = "debian:bullseye-slim"
+)
+
+var (
+	_ api.Builder          = &DockerRustBuilder{}
+	_ api.ArtifactProducer = &DockerRustBuilder{}
+
+	rustDockerfileTmpl = template.Must(template.New("Dockerfile").Parse(RustDockerfileTemplate))
+)
+
+// DockerRustBuilder (id: "docker:rust") builds the test plan, written in
+// Rust, into a container with cargo.
+//
+// The dependencies of the plan are downloaded and compiled in a layer of
+// their own, which is only rebuilt when Cargo.toml or Cargo.lock change.
+// Images for other platforms are built with emulation; see
+// DockerPlatformsConfig.
+type DockerRustBuilder struct{}
+
+type DockerRustBuilderConfig struct {
+	Enabled bool
+
+	// Bin is the binary target to build; the only binary target of the
+	// package if empty.
+	Bin string `toml:"bin"`
+
+	// Profile is the cargo profile to build with; release if empty.
+	Profile string `toml:"profile"`
+
+	// Features are the features of the package to enable, next to the
+	// selectors of the build.
+	Features []string `toml:"features"`
+
+	BuildBaseImage string `toml:"build_base_image"`
+	RuntimeImage   string `toml:"runtime_image"`
+
+	DockerPlatformsConfig
+}
+
+type RustDockerfileTemplateVars struct {
+	WithSDK     bool
+	WithExtra   bool
+	CargoConfig bool
+	Pins        [][2]string
+}
+
+// Build builds a testplan written in Rust and outputs a Docker container.
+func (b *DockerRustBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
+	cfg, ok := in.BuildConfig.(*DockerRustBuilderConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected configuration type DockerRustBuilderConfig, was: %T", in.BuildConfig)
+	}
+
+	var (
+		basesrc = in.UnpackedSources.BaseDir
+		plansrc = in.UnpackedSources.PlanDir
+		sdksrc  = in.UnpackedSources.SDKDir
+	)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	bin, err := cargoBin(plansrc, cfg.Bin)
+	if err != nil {
+		return nil, err
+	}
+
+	// If we have version overrides, or an SDK to link against, apply them.
+	// Local replacements are relative to the plan, as the sources are laid
+	// out the same in the build container.
+	sdk := ""
+	if sdksrc != "" {
+		sdk = "/sdk"
+	}
+	deps, err := containerDependencies(plansrc, in.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+	patches, pins := cargoOverrides(deps, sdk)
+	if err := writeCargoPatches(plansrc, patches); err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(plansrc, ".cargo", "config.toml"))
+	cargoConfig := err == nil
+
+	// Write the Dockerfile.
+	dockerfileDst := filepath.Join(basesrc, "Dockerfile")
+	f, err := os.Create(dockerfileDst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Dockerfile at %s: %w", dockerfileDst, err)
+	}
+
+	vars := &RustDockerfileTemplateVars{
+		WithSDK:     sdksrc != "",
+		WithExtra:   hasLocalDependencies(in.Dependencies),
+		CargoConfig: cargoConfig,
+		Pins:        pins,
+	}
+	err = rustDockerfileTmpl.Execute(f, vars)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute Dockerfile template and/or write into file %s: %w", dockerfileDst, err)
+	}
+
+	// fall back to default images, if they're not configured explicitly.
+	if cfg.BuildBaseImage == "" {
+		cfg.BuildBaseImage = DefaultRustBuildBaseImage
+	}
+	if cfg.RuntimeImage == "" {
+		cfg.RuntimeImage = DefaultRustRuntimeImage
+	}
+
+	var (
+		profile     = strings.Join(cargoProfileArgs(cfg.Profile), " ")
+		profileDir  = cargoProfileDir(cfg.Profile)
+		featureArgs string
+	)
+	if features := append(append([]string(nil), cfg.Features...), in.Selectors...); len(features) > 0 {
+		featureArgs = "--features " + strings.Join(features, ",")
+	}
+
+	// build args
+	var args = map[string]*string{
+		"BUILD_BASE_IMAGE": &cfg.BuildBaseImage,
+		"RUNTIME_IMAGE":    &cfg.RuntimeImage,
+		"BIN":              &bin,
+		"PROFILE":          &profile,
+		"PROFILE_DIR":      &profileDir,
+		"FEATURES":         &featureArgs,
+	}
+
+	opts := types.ImageBuildOptions{
+		Tags:        []string{in.BuildID},
+		Labels:      artifactLabels(b.ID(), in.TestPlan),
+		BuildArgs:   args,
+		NetworkMode: "host",
+	}
+
+	imageOpts := docker.BuildImageOpts{
+		BuildCtx:  basesrc,
+		BuildOpts: &opts,
+	}
+
+	pushed, err := cfg.setupPlatforms(ctx, cli, in, &imageOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	buildStart := time.Now()
+
+	_, err = docker.BuildImage(ctx, ow, cli, &imageOpts)
+	if err != nil {
+		return nil, fmt.Errorf("docker build failed: %w", err)
+	}
+
+	ow.Infow("build completed", "default_tag", fmt.Sprintf("%s:latest", in.BuildID), "took", time.Since(buildStart).Truncate(time.Second))
+
+	if pushed != "" {
+		// the image isn't in the docker daemon, so its dependencies can't be listed.
+		ow.Infow("pushed multi-platform image", "image", pushed, "platforms", in.Platforms)
+		return &api.BuildOutput{ArtifactPath: pushed}, nil
+	}
+
+	imageID, err := docker.GetImageID(ctx, cli, in.BuildID)
+	if err != nil {
+		return nil, fmt.Errorf("couldnt get docker image id: %w", err)
+	}
+
+	ow.Infow("got docker image id", "image_id", imageID)
+
+	resolved, err := parseDependenciesFromDocker(ctx, ow, cli, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list crate dependencies; %w", err)
+	}
+
+	out := &api.BuildOutput{
+		ArtifactPath: imageID,
+		Dependencies: resolved,
+	}
+
+	// Testplan image tag
+	testplanImageTag := fmt.Sprintf("tg-plan-%s:%s", in.TestPlan, imageID)
+
+	ow.Infow("tagging image", "image_id", imageID, "tag", testplanImageTag)
+	if err = cli.ImageTag(ctx, out.ArtifactPath, testplanImageTag); err != nil {
+		return out, err
+	}
+
+	if out.ArtifactPath, err = cfg.publish(ctx, ow, cli, in, imageID); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (*DockerRustBuilder) ID() string {
+	return "docker:rust"
+}
+
+// Produces reports that the builder produces docker images.
+func (*DockerRustBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{Kind: api.ArtifactDockerImage}
+}
+
+func (*DockerRustBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	return dockerHealthcheck(ctx, fix)
+}
+
+func (*DockerRustBuilder) ConfigType() reflect.Type {
+	return reflect.TypeOf(DockerRustBuilderConfig{})
+}
+
+func (*DockerRustBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
+	return fmt.Errorf("purge not implemented for docker:rust")
+}
+
+// ListArtifacts returns the images built by this builder.
+func (b *DockerRustBuilder) ListArtifacts(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listDockerArtifacts(ctx, b.ID())
+}
+
+// RemoveArtifact removes an image built by this builder.
+func (*DockerRustBuilder) RemoveArtifact(ctx context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return removeDockerArtifact(ctx, artifact)
+}
+
+const RustDockerfileTemplate = `
+# BUILD_BASE_IMAGE is the image with the Rust toolchain to build with.
+ARG BUILD_BASE_IMAGE
+
+# RUNTIME_IMAGE is the image onto which to copy the resulting binary.
+ARG RUNTIME_IMAGE
+
+#:::
+#::: BUILD CONTAINER
+#:::
+FROM ${BUILD_BASE_IMAGE} AS builder
+
+# PLAN_DIR is the location containing the plan source inside the container.
+ENV PLAN_DIR /plan
+
+# SDK_DIR is the location containing the (optional) sdk source inside the container.
+ENV SDK_DIR /sdk
+
+# BIN is the binary target to build, PROFILE the arguments selecting the cargo
+# profile, PROFILE_DIR the directory it builds to, and FEATURES either nothing,
+# or "--features <comma-separated features>".
+ARG BIN
+ARG PROFILE="--release"
+ARG PROFILE_DIR="release"
+ARG FEATURES
+
+{{if .WithSDK}}
+COPY /sdk ${SDK_DIR}
+{{end}}
+
+{{if .WithExtra}}
+# The local replacements of dependencies are shipped with the extra sources.
+COPY /extra /extra
+{{end}}
+
+# Copy only the manifest and the lockfile, and build the dependencies against
+# stub sources, in order to leverage Docker caching.
+COPY /plan/Cargo.toml /plan/Cargo.lock* ${PLAN_DIR}/
+{{if .CargoConfig}}
+COPY /plan/.cargo/config.toml ${PLAN_DIR}/.cargo/config.toml
+{{end}}
+
+RUN cd ${PLAN_DIR} \
+    && mkdir -p src \
+    && echo "fn main() {}" > src/main.rs \
+    && touch src/lib.rs \
+{{- range .Pins}}
+    && cargo update -p {{index . 0}} --precise {{index . 1}} \
+{{- end}}
+    && (cargo build ${PROFILE} ${FEATURES} --bin ${BIN} || true) \
+    && rm -rf src
+
+# Now copy the rest of the source and run the build. The sources are touched so
+# that cargo doesn't take the stubs for them.
+RUN cp ${PLAN_DIR}/Cargo.lock /tmp/ 2>/dev/null || true
+COPY . /
+RUN cp /tmp/Cargo.lock ${PLAN_DIR}/ 2>/dev/null || true
+
+RUN cd ${PLAN_DIR} \
+    && find . -path ./target -prune -o -name '*.rs' -exec touch {} + \
+    && cargo build ${PROFILE} ${FEATURES} --bin ${BIN} \
+    && cp target/${PROFILE_DIR}/${BIN} /testplan
+
+# Store crate dependencies
+RUN cd ${PLAN_DIR} \
+    && awk '/^name = /{n=$3} /^version = /{if (n) print n, $3}' Cargo.lock | tr -d '"' > /testground_dep_list
+
+#:::
+#::: RUNTIME CONTAINER
+#:::
+
+## The 'AS runtime' token is used to parse Docker stdout to extract the build image ID to cache.
+FROM ${RUNTIME_IMAGE} AS runtime
+
+COPY --from=builder /testground_dep_list /
+COPY --from=builder /testplan /testplan
+
+EXPOSE 6060
+ENTRYPOINT [ "/testplan"]
+`
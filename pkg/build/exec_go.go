@@ -7,14 +7,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 
 	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/healthcheck"
 	"github.com/testground/testground/pkg/rpc"
 )
 
 var (
-	_ api.Builder = &ExecGoBuilder{}
+	_ api.Builder          = &ExecGoBuilder{}
+	_ api.ArtifactProducer = &ExecGoBuilder{}
 )
 
 // ExecGoBuilder (id: "exec:go") is a builder that compiles the test plan into
@@ -26,6 +29,15 @@ type ExecGoBuilderConfig struct {
 	ModulePath string `toml:"module_path"`
 	ExecPkg    string `toml:"exec_pkg"`
 	FreshGomod bool   `toml:"fresh_gomod"`
+
+	// EnableCGO sets CGO_ENABLED to 1 if true, and to 0 if false; the default
+	// of the system Go SDK applies if unset. Race builds always enable it.
+	EnableCGO *bool `toml:"enable_cgo"`
+
+	// GoVersion, if set, is downloaded and used by the go command as its
+	// GOTOOLCHAIN, which requires a system Go SDK of Go 1.21 or later.
+	// Gcflags default to "all=-N -l", for debuggers.
+	GoToolchainConfig
 }
 
 // Build builds a testplan written in Go and outputs an executable.
@@ -43,6 +55,10 @@ func (b *ExecGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.O
 		bin  = fmt.Sprintf("exec-go--%s-%s", in.TestPlan, id)
 		path = filepath.Join(in.EnvConfig.Dirs().Work(), bin)
 	)
+	if runtime.GOOS == "windows" {
+		// executables need their extension on windows.
+		path += ".exe"
+	}
 
 	if cfg.FreshGomod {
 		for _, f := range []string{"go.mod", "go.sum"} {
@@ -66,7 +82,7 @@ func (b *ExecGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.O
 	// If we have version overrides, apply them.
 	var replaces []string
 	for mod, ver := range in.Dependencies {
-		replaces = append(replaces, fmt.Sprintf("-replace=%s=%s@%s", mod, ver.Target, ver.Version))
+		replaces = append(replaces, goReplace(mod, ver, ver.Dir))
 	}
 
 	if sdksrc != "" {
@@ -85,17 +101,38 @@ func (b *ExecGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.O
 	}
 
 	// Calculate the arguments to go build.
-	// go build -o <output_path> [-tags <comma-separated tags>] <exec_pkg>
-	var args = []string{"build", "-gcflags='all=-N -l'", "-o", path}
-	if len(in.Selectors) > 0 {
-		args = append(args, "-tags")
-		args = append(args, strings.Join(in.Selectors, ","))
+	// go build -trimpath -gcflags=<gcflags> -ldflags=<ldflags> -o <output_path> [-tags <comma-separated tags>] <exec_pkg>
+	gcflags := cfg.Gcflags
+	if gcflags == "" {
+		gcflags = "all=-N -l"
+	}
+	var args = []string{"build", "-trimpath", "-gcflags=" + gcflags, "-ldflags=" + cfg.ldflags()}
+	args = append(args, cfg.modeFlags()...)
+	args = append(args, "-o", path)
+	if tags := cfg.buildTags(in.Selectors); tags != "" {
+		args = append(args, "-tags", tags)
 	}
 	args = append(args, cfg.ExecPkg)
 
+	env := os.Environ()
+	if cfg.GoVersion != "" {
+		env = append(env, "GOTOOLCHAIN=go"+cfg.GoVersion)
+	}
+	switch {
+	case cfg.Race:
+		// the race detector requires cgo.
+		env = append(env, "CGO_ENABLED=1")
+	case cfg.EnableCGO != nil:
+		cgo := "0"
+		if *cfg.EnableCGO {
+			cgo = "1"
+		}
+		env = append(env, "CGO_ENABLED="+cgo)
+	}
+
 	// Execute the build.
 	cmd := exec.CommandContext(ctx, "go", args...)
-	cmd.Dir = plansrc
+	cmd.Dir, cmd.Env = plansrc, env
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		ow.Errorf("go build failed: %s", string(out))
@@ -103,22 +140,56 @@ func (b *ExecGoBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.O
 	}
 
 	cmd = exec.CommandContext(ctx, "go", "list", "-m", "all")
-	cmd.Dir = plansrc
+	cmd.Dir, cmd.Env = plansrc, env
 	out, err = cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("unable to list module dependencies; %w", err)
 	}
 
-	return &api.BuildOutput{
+	res := &api.BuildOutput{
 		ArtifactPath: path,
 		Dependencies: parseDependencies(string(out)),
-	}, nil
+	}
+
+	cmd = exec.CommandContext(ctx, "go", "env", "GOVERSION")
+	cmd.Dir, cmd.Env = plansrc, env
+	if out, err := cmd.Output(); err == nil {
+		res.Toolchain = strings.TrimSpace(string(out))
+	}
+	return res, nil
+}
+
+// Validate validates the toolchain settings of the configuration.
+func (c *ExecGoBuilderConfig) Validate() error {
+	if c.Race && c.EnableCGO != nil && !*c.EnableCGO {
+		return fmt.Errorf("race builds require cgo; unset enable_cgo")
+	}
+	return c.GoToolchainConfig.Validate()
 }
 
 func (*ExecGoBuilder) ID() string {
 	return "exec:go"
 }
 
+// Produces reports that the builder produces executables for the platform
+// of the daemon, using the system Go SDK.
+func (*ExecGoBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{
+		Kind:      api.ArtifactExecutable,
+		Platforms: []string{runtime.GOOS + "/" + runtime.GOARCH},
+	}
+}
+
+// Healthcheck checks that the system Go SDK is available.
+func (*ExecGoBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	hh := &healthcheck.Helper{}
+	hh.Enlist("go-sdk",
+		healthcheck.CheckCommandStatus(ctx, "go", "version"),
+		healthcheck.RequiresManualFixing(),
+	)
+	return hh.RunChecks(ctx, fix)
+}
+
 func (*ExecGoBuilder) ConfigType() reflect.Type {
 	return reflect.TypeOf(ExecGoBuilderConfig{})
 }
@@ -126,3 +197,13 @@ func (*ExecGoBuilder) ConfigType() reflect.Type {
 func (*ExecGoBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
 	return fmt.Errorf("purge not implemented for exec:go")
 }
+
+// ListArtifacts returns the executables built by this builder.
+func (b *ExecGoBuilder) ListArtifacts(_ context.Context, engine api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listExecArtifacts(engine.EnvConfig().Dirs().Work(), "exec-go--", b.ID())
+}
+
+// RemoveArtifact removes an executable built by this builder.
+func (*ExecGoBuilder) RemoveArtifact(_ context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return os.Remove(artifact.ID)
+}
@@ -0,0 +1,225 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/healthcheck"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+var (
+	_ api.Builder          = &ExecRustBuilder{}
+	_ api.ArtifactProducer = &ExecRustBuilder{}
+)
+
+// ExecRustBuilder (id: "exec:rust") is a builder that compiles the test plan
+// into an executable using the system Rust toolchain (cargo). The resulting
+// artifact can be used with a containerless runner.
+//
+// Builds of a plan share a target directory in the work directory, so that the
+// dependencies are only compiled once, and the crates are downloaded to the registry
+// cache of cargo. The executable is built for the platform of the run, e.g.
+// linux/arm64, with the target of the platform installed through rustup.
+type ExecRustBuilder struct{}
+
+type ExecRustBuilderConfig struct {
+	// Bin is the binary target to build; the only binary target of the
+	// package if empty.
+	Bin string `toml:"bin"`
+
+	// Profile is the cargo profile to build with; release if empty.
+	Profile string `toml:"profile"`
+
+	// Features are the features of the package to enable, next to the
+	// selectors of the build.
+	Features []string `toml:"features"`
+
+	// Target is the target triple to build for, e.g.
+	// x86_64-unknown-linux-musl, rather than the one of the platform of the run.
+	Target string `toml:"target"`
+
+	// Linker is the linker of the target, when cross-compiling, e.g.
+	// aarch64-linux-gnu-gcc.
+	Linker string `toml:"linker"`
+}
+
+// Build builds a testplan written in Rust and outputs an executable.
+func (b *ExecRustBuilder) Build(ctx context.Context, in *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
+	cfg, ok := in.BuildConfig.(*ExecRustBuilderConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected configuration type ExecRustBuilderConfig, was: %T", in.BuildConfig)
+	}
+
+	var (
+		id      = in.BuildID
+		plansrc = in.UnpackedSources.PlanDir
+		sdksrc  = in.UnpackedSources.SDKDir
+		work    = in.EnvConfig.Dirs().Work()
+
+		targetDir = filepath.Join(work, "exec-rust-target", in.TestPlan)
+	)
+
+	target, err := execRustTarget(cfg, in.Platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	bin, err := cargoBin(plansrc, cfg.Bin)
+	if err != nil {
+		return nil, err
+	}
+
+	if sdksrc != "" {
+		if sdksrc, err = filepath.Abs(sdksrc); err != nil {
+			return nil, err
+		}
+	}
+
+	// If we have version overrides, or an SDK to link against, apply them.
+	patches, pins := cargoOverrides(in.Dependencies, sdksrc)
+	if err := writeCargoPatches(plansrc, patches); err != nil {
+		return nil, err
+	}
+
+	env := append(os.Environ(), "CARGO_TARGET_DIR="+targetDir)
+	if target != "" && cfg.Linker != "" {
+		env = append(env, fmt.Sprintf("CARGO_TARGET_%s_LINKER=%s", strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(target)), cfg.Linker))
+	}
+
+	for _, pin := range pins {
+		cmd := exec.CommandContext(ctx, "cargo", "update", "-p", pin[0], "--precise", pin[1])
+		cmd.Dir, cmd.Env = plansrc, env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("unable to pin %s to %s; %w; output: %s", pin[0], pin[1], err, string(out))
+		}
+	}
+
+	// Calculate the arguments to cargo build.
+	// cargo build <--release|--profile p> --bin <bin> [--target <triple>] [--features <comma-separated features>]
+	args := append([]string{"build"}, cargoProfileArgs(cfg.Profile)...)
+	args = append(args, "--bin", bin)
+	if target != "" {
+		args = append(args, "--target", target)
+	}
+	if features := append(append([]string(nil), cfg.Features...), in.Selectors...); len(features) > 0 {
+		args = append(args, "--features", strings.Join(features, ","))
+	}
+
+	// Execute the build.
+	cmd := exec.CommandContext(ctx, "cargo", args...)
+	cmd.Dir, cmd.Env = plansrc, env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		ow.Errorf("cargo build failed: %s", string(out))
+		return nil, fmt.Errorf("failed to run the build; %w", err)
+	}
+
+	built := filepath.Join(targetDir, target, cargoProfileDir(cfg.Profile), bin)
+	path := filepath.Join(work, fmt.Sprintf("exec-rust--%s-%s", in.TestPlan, id))
+	if strings.Contains(target, "windows") {
+		built, path = built+".exe", path+".exe"
+	}
+	if err := copyExecutable(built, path); err != nil {
+		return nil, fmt.Errorf("failed to copy the executable %s: %w", built, err)
+	}
+
+	lock, err := ioutil.ReadFile(filepath.Join(plansrc, "Cargo.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Cargo.lock; %w", err)
+	}
+	deps, err := parseCargoLock(string(lock))
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.BuildOutput{
+		ArtifactPath: path,
+		Dependencies: deps,
+	}, nil
+}
+
+// execRustTarget returns the target triple to build for: the configured one,
+// or else the one of the platform of the run, or else none, for the host.
+func execRustTarget(cfg *ExecRustBuilderConfig, platforms []string) (string, error) {
+	switch {
+	case cfg.Target != "":
+		return cfg.Target, nil
+	case len(platforms) == 0:
+		return "", nil
+	case len(platforms) > 1:
+		return "", fmt.Errorf("exec:rust builds executables for a single platform, not %s", strings.Join(platforms, ", "))
+	case platforms[0] == runtime.GOOS+"/"+runtime.GOARCH:
+		// build for the host, sharing the target directory of host builds.
+		return "", nil
+	default:
+		return rustTarget(platforms[0])
+	}
+}
+
+// copyExecutable copies the executable at src to dst, out of the shared target
+// directory, so that later builds don't overwrite it.
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (*ExecRustBuilder) ID() string {
+	return "exec:rust"
+}
+
+// Produces reports that the builder produces executables, for the platforms
+// of the runs, which aren't known in advance.
+func (*ExecRustBuilder) Produces() api.ArtifactSpec {
+	return api.ArtifactSpec{Kind: api.ArtifactExecutable}
+}
+
+// Healthcheck checks that the system Rust toolchain is available.
+func (*ExecRustBuilder) Healthcheck(ctx context.Context, _ api.Engine, _ *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	hh := &healthcheck.Helper{}
+	hh.Enlist("rust-toolchain",
+		healthcheck.CheckCommandStatus(ctx, "cargo", "--version"),
+		healthcheck.RequiresManualFixing(),
+	)
+	return hh.RunChecks(ctx, fix)
+}
+
+func (*ExecRustBuilder) ConfigType() reflect.Type {
+	return reflect.TypeOf(ExecRustBuilderConfig{})
+}
+
+func (*ExecRustBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
+	return fmt.Errorf("purge not implemented for exec:rust")
+}
+
+// ListArtifacts returns the executables built by this builder.
+func (b *ExecRustBuilder) ListArtifacts(_ context.Context, engine api.Engine, _ *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return listExecArtifacts(engine.EnvConfig().Dirs().Work(), "exec-rust--", b.ID())
+}
+
+// RemoveArtifact removes an executable built by this builder.
+func (*ExecRustBuilder) RemoveArtifact(_ context.Context, _ api.Engine, artifact *api.Artifact, _ *rpc.OutputWriter) error {
+	return os.Remove(artifact.ID)
+}
@@ -0,0 +1,76 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	goVersionRe  = regexp.MustCompile(`^1\.\d+(\.\d+)?((beta|rc)\d+)?$`)
+	goBuildTagRe = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+)
+
+// GoToolchainConfig configures the go toolchain of the go builders, e.g. for
+// instrumented builds, or to test a plan against a specific Go release.
+type GoToolchainConfig struct {
+	// GoVersion is the Go release to build with, e.g. 1.17.13; the one of the
+	// build base image, or of the system, if empty.
+	GoVersion string `toml:"go_version"`
+
+	// BuildTags are the build tags to build with, next to the selectors of
+	// the build.
+	BuildTags []string `toml:"build_tags"`
+
+	// Ldflags and Gcflags are the flags passed to the linker and the compiler,
+	// e.g. "-s -w -X main.version=1.0" and "all=-N -l", as with go build
+	// -ldflags and -gcflags.
+	Ldflags string `toml:"ldflags"`
+	Gcflags string `toml:"gcflags"`
+
+	// Race builds the plan with the race detector, which requires cgo.
+	Race bool `toml:"race"`
+
+	// Cover builds the plan with coverage instrumentation (Go 1.20 or later).
+	// The instances write their coverage data to their outputs, as
+	// $GOCOVERDIR, and `testground collect --dir` merges it into a coverage
+	// report of the run.
+	Cover bool `toml:"cover"`
+}
+
+// Validate validates the version and the build tags of the configuration.
+func (c *GoToolchainConfig) Validate() error {
+	if c.GoVersion != "" && !goVersionRe.MatchString(c.GoVersion) {
+		return fmt.Errorf("invalid go_version %q; expected a Go release, e.g. 1.17.13", c.GoVersion)
+	}
+	for _, t := range c.BuildTags {
+		if !goBuildTagRe.MatchString(t) {
+			return fmt.Errorf("invalid build tag %q", t)
+		}
+	}
+	return nil
+}
+
+// modeFlags returns the flags of go build for the race and coverage modes.
+func (c *GoToolchainConfig) modeFlags() []string {
+	var flags []string
+	if c.Race {
+		flags = append(flags, "-race")
+	}
+	if c.Cover {
+		flags = append(flags, "-cover")
+	}
+	return flags
+}
+
+// ldflags returns the flags of the linker: those of the configuration, after
+// an empty build ID, so that, with -trimpath, builds of the same inputs produce
+// the same binary.
+func (c *GoToolchainConfig) ldflags() string {
+	return strings.TrimSpace("-buildid= " + c.Ldflags)
+}
+
+// buildTags returns the build tags of the configuration and the selectors.
+func (c *GoToolchainConfig) buildTags(selectors []string) string {
+	return strings.Join(append(append([]string(nil), c.BuildTags...), selectors...), ",")
+}
@@ -0,0 +1,85 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/config"
+)
+
+func TestGoToolchainConfigValidate(t *testing.T) {
+	for _, v := range []string{"1.17", "1.17.13", "1.18rc1", "1.21beta2"} {
+		require.NoError(t, (&GoToolchainConfig{GoVersion: v}).Validate(), v)
+	}
+	for _, v := range []string{"go1.17", "latest", "1.17.13-buster"} {
+		require.Error(t, (&GoToolchainConfig{GoVersion: v}).Validate(), v)
+	}
+	require.Error(t, (&GoToolchainConfig{BuildTags: []string{"a b"}}).Validate())
+
+	cfg := &GoToolchainConfig{BuildTags: []string{"instrumented"}}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "instrumented,foo", cfg.buildTags([]string{"foo"}))
+	require.Empty(t, (&GoToolchainConfig{}).buildTags(nil))
+
+	require.Error(t, (&DockerGoBuilderConfig{
+		BuildBaseImage:    "golang:1.17-buster",
+		GoToolchainConfig: GoToolchainConfig{GoVersion: "1.17.13"},
+	}).Validate())
+}
+
+func TestExecGoBuilderConfigCGO(t *testing.T) {
+	var cc config.CoalescedConfig
+	v, err := cc.CoalesceIntoType(reflect.TypeOf(ExecGoBuilderConfig{}))
+	require.NoError(t, err)
+	require.Nil(t, v.(*ExecGoBuilderConfig).EnableCGO)
+
+	cc = cc.Append(map[string]interface{}{"enable_cgo": false, "ldflags": "-s -w"})
+	v, err = cc.CoalesceIntoType(reflect.TypeOf(ExecGoBuilderConfig{}))
+	require.NoError(t, err)
+	cfg := v.(*ExecGoBuilderConfig)
+	require.NotNil(t, cfg.EnableCGO)
+	require.False(t, *cfg.EnableCGO)
+	require.Equal(t, "-s -w", cfg.Ldflags)
+}
+
+func TestGoDockerfileTemplateCGO(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, goDockerfileTmpl.Execute(&b, &DockerfileTemplateVars{CgoEnabled: 1}))
+	require.Contains(t, b.String(), `CGO_ENABLED=1 GOOS=linux go build -trimpath -o ${PLAN_DIR}/testplan.bin ${BUILD_MODE} ${BUILD_TAGS} -ldflags="${LDFLAGS}" -gcflags="${GCFLAGS}"`)
+	require.Contains(t, b.String(), `ENTRYPOINT [ "/testplan"]`)
+
+	b.Reset()
+	require.NoError(t, goDockerfileTmpl.Execute(&b, &DockerfileTemplateVars{Cover: true}))
+	require.Contains(t, b.String(), `GOCOVERDIR=${TEST_OUTPUTS_PATH} exec /testplan`)
+}
+
+func TestGoBuildModes(t *testing.T) {
+	require.Empty(t, (&GoToolchainConfig{}).modeFlags())
+	require.Equal(t, "-buildid=", (&GoToolchainConfig{}).ldflags())
+	require.Equal(t, "-buildid= -s -w", (&GoToolchainConfig{Ldflags: "-s -w"}).ldflags())
+	require.Equal(t, []string{"-race", "-cover"}, (&GoToolchainConfig{Race: true, Cover: true}).modeFlags())
+
+	for _, cfg := range []*DockerGoBuilderConfig{
+		{},
+		{BuildBaseImage: "golang:1.19-buster"},
+		{BuildBaseImage: "example.com/go:1.21"},
+		{GoToolchainConfig: GoToolchainConfig{GoVersion: "1.17.13"}},
+	} {
+		cfg.Cover = true
+		require.Error(t, cfg.Validate(), "coverage builds require go 1.20: %+v", cfg)
+	}
+	for _, cfg := range []*DockerGoBuilderConfig{
+		{BuildBaseImage: "golang:1.21-bullseye"},
+		{GoToolchainConfig: GoToolchainConfig{GoVersion: "1.20.4"}},
+	} {
+		cfg.Cover = true
+		require.NoError(t, cfg.Validate())
+	}
+
+	disabled := false
+	require.Error(t, (&ExecGoBuilderConfig{EnableCGO: &disabled, GoToolchainConfig: GoToolchainConfig{Race: true}}).Validate())
+	require.NoError(t, (&ExecGoBuilderConfig{GoToolchainConfig: GoToolchainConfig{Race: true}}).Validate())
+}
@@ -0,0 +1,26 @@
+package build
+
+import (
+	"context"
+
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/healthcheck"
+)
+
+// dockerHealthcheck checks the precondition shared by all docker builders:
+// a reachable docker daemon.
+func dockerHealthcheck(ctx context.Context, fix bool) (*api.HealthcheckReport, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	hh := &healthcheck.Helper{}
+	hh.Require("docker-daemon",
+		healthcheck.CheckDockerDaemon(ctx, cli),
+		healthcheck.RequiresManualFixing(),
+	)
+	return hh.RunChecks(ctx, fix)
+}
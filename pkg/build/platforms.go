@@ -0,0 +1,112 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/docker"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// DockerPlatformsConfig configures how the docker builders build images for
+// platforms other than the one of the docker daemon, e.g. linux/arm64 images
+// for ARM clusters (see api.BuildInput#Platforms), where they build them, and
+// the registry they share them through.
+type DockerPlatformsConfig struct {
+	// BuildxBuilder is the buildx builder instance that builds the images,
+	// e.g. one with nodes of other architectures, or with QEMU emulation; the
+	// current one by default.
+	BuildxBuilder string `toml:"buildx_builder"`
+
+	// BuildkitHost is the address of a remote BuildKit instance that builds
+	// the images, rather than the docker daemon, e.g.
+	// tcp://buildkitd.example.com:1234. The images are loaded in the daemon
+	// once built; see Registry to share them.
+	BuildkitHost string `toml:"buildkit_host"`
+
+	// PushTo is the repository that images for several platforms are pushed
+	// to, as <push_to>:<plan>-<build id>, e.g. registry.example.com/testground.
+	// Such images can't be loaded in the docker daemon, so it's required to
+	// build them.
+	PushTo string `toml:"push_to"`
+
+	// Registry is the provider of the registry that every image is pushed to
+	// once built, so that team members and clusters can run it: aws, dockerhub
+	// or generic; see docker.RegistryAuth. The images are tagged with their
+	// ID, e.g. <repository>:1a2b3c4d5e6f, so that identical images are pushed
+	// once. Images are kept in the docker daemon if empty.
+	Registry string `toml:"registry"`
+}
+
+// setupPlatforms sets the build of the image up for the platforms of the
+// input, and for the remote BuildKit instance, if any. It returns the
+// reference of the image if it's pushed, rather than loaded in the docker
+// daemon.
+func (c *DockerPlatformsConfig) setupPlatforms(ctx context.Context, cli *client.Client, in *api.BuildInput, opts *docker.BuildImageOpts) (string, error) {
+	if c.BuildkitHost != "" && c.BuildxBuilder != "" {
+		return "", fmt.Errorf("buildkit_host and buildx_builder are mutually exclusive")
+	}
+	if len(in.Platforms) == 0 && c.BuildkitHost == "" {
+		return "", nil
+	}
+
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	native := v.Os + "/" + v.Arch
+
+	platforms := in.Platforms
+	switch {
+	case c.BuildkitHost != "":
+		if len(platforms) == 0 {
+			platforms = []string{native}
+		}
+		builder, err := docker.EnsureRemoteBuilder(ctx, cli, c.BuildkitHost)
+		if err != nil {
+			return "", err
+		}
+		opts.Platforms, opts.Builder = platforms, builder
+	case len(platforms) == 1 && platforms[0] == native:
+		// the daemon builds its own platform.
+		return "", nil
+	default:
+		opts.Platforms, opts.Builder = platforms, c.BuildxBuilder
+	}
+
+	if len(platforms) == 1 {
+		return "", nil
+	}
+
+	if c.PushTo == "" {
+		return "", fmt.Errorf("building an image for %s requires a repository to push it to; set push_to", strings.Join(platforms, ", "))
+	}
+	ref := fmt.Sprintf("%s:%s-%s", c.PushTo, in.TestPlan, in.BuildID)
+	opts.BuildOpts.Tags = []string{ref}
+	return ref, nil
+}
+
+// publish pushes the image built to the registry of the configuration, if
+// any, tagged with its ID. It returns the reference of the pushed image, or
+// else the ID of the image.
+func (c *DockerPlatformsConfig) publish(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client, in *api.BuildInput, imageID string) (string, error) {
+	if c.Registry == "" {
+		return imageID, nil
+	}
+
+	auth, repo, err := docker.RegistryAuth(in.EnvConfig, c.Registry, in.TestPlan)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the credentials of the registry: %w", err)
+	}
+
+	ref := repo + ":" + imageID
+	ow.Infow("pushing image", "image_id", imageID, "ref", ref)
+	if err := docker.PushImage(ctx, ow, cli, imageID, ref, auth); err != nil {
+		return "", fmt.Errorf("failed to push image %s: %w", ref, err)
+	}
+	return ref, nil
+}
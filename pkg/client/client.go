@@ -11,12 +11,16 @@ import (
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/logrusorgru/aurora"
 	"github.com/testground/testground/pkg/task"
@@ -28,6 +32,7 @@ import (
 
 	"github.com/mholt/archiver"
 	"github.com/mitchellh/mapstructure"
+	"nhooyr.io/websocket"
 )
 
 // Client is the API client that performs all operations
@@ -61,11 +66,21 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) Build(ctx context.Context, r *api.BuildRequest, plandir string, sdkdir string, extraSrcs []string) (io.ReadCloser, error) {
-	return c.runBuild(ctx, r, "/build", plandir, sdkdir, extraSrcs)
+	return c.runBuild(ctx, r, "/v1/builds", plandir, sdkdir, extraSrcs)
 }
 
 func (c *Client) Run(ctx context.Context, r *api.RunRequest, plandir string, sdkdir string, extraSrcs []string) (io.ReadCloser, error) {
-	return c.runBuild(ctx, r, "/run", plandir, sdkdir, extraSrcs)
+	return c.runBuild(ctx, r, "/v1/runs", plandir, sdkdir, extraSrcs)
+}
+
+// CreateSchedule sends a `schedules` request to the daemon, scheduling
+// recurring runs of the request. The sources are sent like the ones of a
+// `run` request.
+//
+// The response holds the schedule. See `ParseScheduleResponse()` for
+// specifics.
+func (c *Client) CreateSchedule(ctx context.Context, r *api.ScheduleRequest, plandir string, sdkdir string, extraSrcs []string) (io.ReadCloser, error) {
+	return c.runBuild(ctx, r, "/v1/schedules", plandir, sdkdir, extraSrcs)
 }
 
 // runBuild sends a multipart request to the daemon on a certain path.
@@ -79,8 +94,8 @@ func (c *Client) Run(ctx context.Context, r *api.RunRequest, plandir string, sdk
 // The Body in the response implements an io.ReadCloser and it's up to the
 // caller to close it.
 //
-// The response is a stream of `Msg` protocol messages. See
-// `ParseBuildResponse()` for specifics.
+// The response holds the ID of the queued task. See `ParseBuildResponse()`
+// for specifics.
 func (c *Client) runBuild(ctx context.Context, r interface{}, path, plandir, sdkdir string, extraSrcs []string) (io.ReadCloser, error) {
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(r)
@@ -246,6 +261,17 @@ func (c *Client) Terminate(ctx context.Context, r *api.TerminateRequest) (io.Rea
 	return c.request(ctx, "POST", "/terminate", bytes.NewReader(body.Bytes()))
 }
 
+// Cleanup sends a `cleanup` request to the daemon.
+func (c *Client) Cleanup(ctx context.Context, r *api.CleanupRequest) (io.ReadCloser, error) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.request(ctx, "POST", "/cleanup", bytes.NewReader(body.Bytes()))
+}
+
 // Healthcheck sends a `healthcheck` request to the daemon.
 func (c *Client) Healthcheck(ctx context.Context, r *api.HealthcheckRequest) (io.ReadCloser, error) {
 	var body bytes.Buffer
@@ -268,44 +294,153 @@ func (c *Client) BuildPurge(ctx context.Context, r *api.BuildPurgeRequest) (io.R
 	return c.request(ctx, "POST", "/build/purge", bytes.NewReader(body.Bytes()))
 }
 
-func (c *Client) Tasks(ctx context.Context, r *api.TasksRequest) (io.ReadCloser, error) {
+// BuildArtifacts sends a `build/artifacts` request to the daemon.
+func (c *Client) BuildArtifacts(ctx context.Context, r *api.BuildArtifactsRequest) (io.ReadCloser, error) {
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(r)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.request(ctx, "POST", "/tasks", bytes.NewReader(body.Bytes()))
+	return c.request(ctx, "POST", "/build/artifacts", bytes.NewReader(body.Bytes()))
 }
 
-func (c *Client) Status(ctx context.Context, r *api.StatusRequest) (io.ReadCloser, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(r)
-	if err != nil {
-		return nil, err
+// Tasks sends a `tasks` request to the daemon, listing the tasks that match
+// the filters.
+func (c *Client) Tasks(ctx context.Context, r *api.TasksRequest) (io.ReadCloser, error) {
+	q := url.Values{}
+	for _, s := range r.States {
+		q.Add("state", string(s))
+	}
+	for _, t := range r.Types {
+		q.Add("type", string(t))
+	}
+	if r.TestPlan != "" {
+		q.Set("plan", r.TestPlan)
+	}
+	if r.TestCase != "" {
+		q.Set("case", r.TestCase)
+	}
+	// tasks are filtered by creation time, between Before and After.
+	if r.Before != nil {
+		q.Set("since", r.Before.UTC().Format(time.RFC3339))
+	}
+	if r.After != nil {
+		q.Set("until", r.After.UTC().Format(time.RFC3339))
 	}
 
-	return c.request(ctx, "POST", "/status", bytes.NewReader(body.Bytes()))
+	return c.request(ctx, "GET", "/v1/tasks?"+q.Encode(), nil)
 }
 
-func (c *Client) Cancel(ctx context.Context, r *api.CancelRequest) (io.ReadCloser, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(r)
-	if err != nil {
-		return nil, err
+// Runs sends a `runs` request to the daemon, querying the run history.
+func (c *Client) Runs(ctx context.Context, r *api.RunsRequest) (io.ReadCloser, error) {
+	q := url.Values{}
+	if r.Type != "" {
+		q.Set("type", string(r.Type))
 	}
+	if r.TestPlan != "" {
+		q.Set("plan", r.TestPlan)
+	}
+	if r.TestCase != "" {
+		q.Set("case", r.TestCase)
+	}
+	if r.Since != nil {
+		q.Set("since", r.Since.UTC().Format(time.RFC3339))
+	}
+	if r.Schedule != "" {
+		q.Set("schedule", r.Schedule)
+	}
+	if r.Limit > 0 {
+		q.Set("limit", strconv.Itoa(r.Limit))
+	}
+
+	return c.request(ctx, "GET", "/v1/runs?"+q.Encode(), nil)
+}
+
+// Status sends a `status` request to the daemon, getting a task.
+func (c *Client) Status(ctx context.Context, r *api.StatusRequest) (io.ReadCloser, error) {
+	return c.request(ctx, "GET", "/v1/tasks/"+url.PathEscape(r.TaskID), nil)
+}
+
+// Cancel sends a `cancel` request to the daemon.
+func (c *Client) Cancel(ctx context.Context, r *api.CancelRequest) (io.ReadCloser, error) {
+	return c.request(ctx, "POST", "/v1/tasks/"+url.PathEscape(r.TaskID)+"/cancel", nil)
+}
+
+// DeadLetters lists the tasks of the user that were quarantined for crashing
+// the daemon.
+func (c *Client) DeadLetters(ctx context.Context) (io.ReadCloser, error) {
+	return c.request(ctx, "GET", "/v1/dead-letters", nil)
+}
+
+// RequeueDeadLetter queues a quarantined task again.
+func (c *Client) RequeueDeadLetter(ctx context.Context, id string) (io.ReadCloser, error) {
+	return c.request(ctx, "POST", "/v1/dead-letters/"+url.PathEscape(id)+"/requeue", nil)
+}
+
+// Schedules lists the schedules of recurring runs of the user.
+func (c *Client) Schedules(ctx context.Context) (io.ReadCloser, error) {
+	return c.request(ctx, "GET", "/v1/schedules", nil)
+}
 
-	return c.request(ctx, "POST", "/cancel", bytes.NewReader(body.Bytes()))
+// DeleteSchedule deletes a schedule of recurring runs.
+func (c *Client) DeleteSchedule(ctx context.Context, id string) (io.ReadCloser, error) {
+	return c.request(ctx, "DELETE", "/v1/schedules/"+url.PathEscape(id), nil)
 }
 
+// Logs streams the logs of a task from the daemon, over a WebSocket.
+//
+// The returned io.ReadCloser is a stream of api.LogsMessages, and it's up to
+// the caller to close it. See `ParseLogsResponse()` for specifics.
 func (c *Client) Logs(ctx context.Context, r *api.LogsRequest) (io.ReadCloser, error) {
-	var body bytes.Buffer
-	err := json.NewEncoder(&body).Encode(r)
+	q := url.Values{}
+	if r.Follow {
+		q.Set("follow", "true")
+	}
+	if r.CancelWithContext {
+		q.Set("cancel", "true")
+	}
+	if r.Instances {
+		q.Set("instances", "true")
+	}
+
+	header := http.Header{}
+	if token := strings.TrimSpace(c.cfg.Client.Token); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	path := "/v1/tasks/" + url.PathEscape(r.TaskID) + "/logs?" + q.Encode()
+	conn, resp, err := websocket.Dial(ctx, c.endpoint+path, &websocket.DialOptions{
+		HTTPClient: c.client,
+		HTTPHeader: header,
+	})
 	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 {
+			return nil, responseError(resp)
+		}
 		return nil, err
 	}
+	// lines of output are not split across messages.
+	conn.SetReadLimit(logsReadLimit)
 
-	return c.request(ctx, "POST", "/logs", bytes.NewReader(body.Bytes()))
+	return &logsStream{
+		Conn: websocket.NetConn(ctx, conn, websocket.MessageText),
+		ws:   conn,
+	}, nil
+}
+
+// logsReadLimit is the maximum size of a message of the logs of a task.
+const logsReadLimit = 64 << 20
+
+// logsStream is the stream of the messages of the logs of a task, read from
+// a WebSocket.
+type logsStream struct {
+	net.Conn
+	ws *websocket.Conn
+}
+
+func (s *logsStream) Close() error {
+	return s.ws.Close(websocket.StatusNormalClosure, "")
 }
 
 func parseGeneric(r io.ReadCloser, fnProgress, fnBinary, fnResult func(interface{}) error) error {
@@ -343,6 +478,10 @@ func parseGeneric(r io.ReadCloser, fnProgress, fnBinary, fnResult func(interface
 				return err
 			}
 
+		case rpc.ChunkTypeInstance:
+			// the output of the instances is only streamed to the clients
+			// that follow them.
+
 		default:
 			return errors.New("unknown message type")
 		}
@@ -394,46 +533,48 @@ func ParseCollectResponse(r io.ReadCloser, file io.Writer) (api.CollectResponse,
 	return resp, err
 }
 
-// ParseRunResponse parses a response from a `run` call
+// ParseRunResponse parses a response from a `run` call, returning the ID of
+// the queued task.
 func ParseRunResponse(r io.ReadCloser) (string, error) {
-	var resp string
-	err := parseGeneric(
+	var resp api.QueuedResponse
+	err := json.NewDecoder(r).Decode(&resp)
+	return resp.ID, err
+}
+
+// ParseBuildResponse parses a response from a `build` call, returning the ID
+// of the queued task.
+func ParseBuildResponse(r io.ReadCloser) (string, error) {
+	var resp api.QueuedResponse
+	err := json.NewDecoder(r).Decode(&resp)
+	return resp.ID, err
+}
+
+// ParseBuildPurgeResponse parses a response from 'build/purge' call.
+func ParseBuildPurgeResponse(r io.ReadCloser) error {
+	return parseGeneric(
 		r,
 		printProgress,
 		nil,
 		func(result interface{}) error {
-			var ok bool
-			resp, ok = result.(string)
-			if !ok {
-				return errors.New("result should be string")
-			}
 			return nil
 		},
 	)
-	return resp, err
 }
 
-// ParseBuildResponse parses a response from a `build` call
-func ParseBuildResponse(r io.ReadCloser) (string, error) {
-	var resp string
+// ParseBuildArtifactsResponse parses a response from a 'build/artifacts' call.
+func ParseBuildArtifactsResponse(r io.ReadCloser) (api.BuildArtifactsResponse, error) {
+	var resp api.BuildArtifactsResponse
 	err := parseGeneric(
 		r,
 		printProgress,
 		nil,
-		func(result interface{}) error {
-			var ok bool
-			resp, ok = result.(string)
-			if !ok {
-				return errors.New("result should be string")
-			}
-			return nil
-		},
+		parseMarshalAndUnmarshal(&resp),
 	)
 	return resp, err
 }
 
-// ParseBuildPurgeResponse parses a response from 'build/purge' call.
-func ParseBuildPurgeResponse(r io.ReadCloser) error {
+// ParseTerminateRequest parses a response from a 'terminate' call
+func ParseTerminateRequest(r io.ReadCloser) error {
 	return parseGeneric(
 		r,
 		printProgress,
@@ -444,16 +585,22 @@ func ParseBuildPurgeResponse(r io.ReadCloser) error {
 	)
 }
 
-// ParseTerminateRequest parses a response from a 'terminate' call
-func ParseTerminateRequest(r io.ReadCloser) error {
-	return parseGeneric(
+// ParseCleanupResponse parses a response from a 'cleanup' call.
+func ParseCleanupResponse(r io.ReadCloser) (api.CleanupResponse, error) {
+	var resp api.CleanupResponse
+	err := parseGeneric(
 		r,
 		printProgress,
 		nil,
-		func(result interface{}) error {
-			return nil
-		},
+		parseMarshalAndUnmarshal(&resp),
 	)
+	return resp, err
+}
+
+// ParseCancelResponse parses a response from a 'cancel' call
+func ParseCancelResponse(r io.ReadCloser) error {
+	var resp api.QueuedResponse
+	return json.NewDecoder(r).Decode(&resp)
 }
 
 // ParseHealthcheckResponse parses a response from a 'healthcheck' call
@@ -473,28 +620,103 @@ func ParseHealthcheckResponse(r io.ReadCloser) (api.HealthcheckResponse, error)
 // ParseTasksRequest parses a response from a 'task' call
 func ParseTasksRequest(r io.ReadCloser) ([]*task.Task, error) {
 	var resp []*task.Task
-	err := parseGeneric(
-		r,
-		printProgress,
-		nil,
-		parseMarshalAndUnmarshal(&resp),
-	)
+	err := json.NewDecoder(r).Decode(&resp)
+	return resp, err
+}
+
+// ParseTaskResponse parses a response from a call that returns a task
+func ParseTaskResponse(r io.ReadCloser) (*task.Task, error) {
+	var resp task.Task
+	err := json.NewDecoder(r).Decode(&resp)
+	return &resp, err
+}
+
+// ParseRunsResponse parses a response from a 'runs' call
+func ParseRunsResponse(r io.ReadCloser) ([]*task.Record, error) {
+	var resp []*task.Record
+	err := json.NewDecoder(r).Decode(&resp)
+	return resp, err
+}
+
+// ParseScheduleResponse parses a response from a call that returns a
+// schedule
+func ParseScheduleResponse(r io.ReadCloser) (*task.Schedule, error) {
+	var resp task.Schedule
+	err := json.NewDecoder(r).Decode(&resp)
+	return &resp, err
+}
+
+// ParseSchedulesResponse parses a response from a 'schedules' call
+func ParseSchedulesResponse(r io.ReadCloser) ([]*task.Schedule, error) {
+	var resp []*task.Schedule
+	err := json.NewDecoder(r).Decode(&resp)
 	return resp, err
 }
 
 // ParseStatusResponse parses a response from a 'status' call
 func ParseStatusResponse(r io.ReadCloser) (api.StatusResponse, error) {
 	var resp api.StatusResponse
-	err := parseGeneric(
-		r,
-		printProgress,
-		nil,
-		parseMarshalAndUnmarshal(&resp),
-	)
+	err := json.NewDecoder(r).Decode(&resp)
 	return resp, err
 }
 
-// ParseLogsRequest parses a response from a 'logs' call
+// ParseLogsResponse parses the logs streamed by a 'logs' call, writing the
+// output of the task to w, and returns the task once the logs are over.
+func ParseLogsResponse(w io.Writer, r io.ReadCloser) (api.LogsResponse, error) {
+	var once sync.Once
+
+	for dec := json.NewDecoder(r); ; {
+		var msg api.LogsMessage
+		if err := dec.Decode(&msg); err != nil {
+			return api.LogsResponse{}, err
+		}
+
+		switch msg.Type {
+		case api.LogsMessageLog:
+			once.Do(func() {
+				fmt.Println(aurora.Bold(aurora.Cyan("\n>>> Server output:\n")))
+			})
+			fmt.Fprint(w, msg.Data)
+
+		case api.LogsMessageState:
+			if msg.Task == nil {
+				continue
+			}
+			state := string(msg.Task.State().State)
+			if p := msg.Task.Progress; p != nil {
+				state += " (" + p.String() + ")"
+			}
+			fmt.Println(aurora.Faint(">>> " + state))
+
+		case api.LogsMessageInstance:
+			if msg.Instance == nil {
+				continue
+			}
+			if msg.Instance.Stream == "stderr" {
+				fmt.Fprintln(w, aurora.Red(msg.Instance.String()))
+			} else {
+				fmt.Fprintln(w, msg.Instance.String())
+			}
+
+		case api.LogsMessageError:
+			fmt.Println(aurora.Bold(aurora.BrightRed("\n>>> Error:\n")))
+			return api.LogsResponse{}, errors.New(msg.Error)
+
+		case api.LogsMessageEnd:
+			fmt.Println(aurora.Bold(aurora.BrightGreen("\n>>> Result:\n")))
+			if msg.Task == nil {
+				return api.LogsResponse{}, errors.New("logs ended without the task")
+			}
+			return *msg.Task, nil
+
+		default:
+			return api.LogsResponse{}, errors.New("unknown message type")
+		}
+	}
+}
+
+// ParseLogsRequest parses the logs of a task in the format of the RPC
+// endpoints, in which the daemon stores them
 func ParseLogsRequest(w io.Writer, r io.ReadCloser) (api.LogsResponse, error) {
 	var resp api.LogsResponse
 	err := parseGeneric(
@@ -539,7 +761,7 @@ func (c *Client) request(ctx context.Context, method string, path string, body i
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("unexpected status code received: %s", resp.Status)
+		return nil, responseError(resp)
 	}
 
 	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
@@ -548,3 +770,17 @@ func (c *Client) request(ctx context.Context, method string, path string, body i
 
 	return resp.Body, nil
 }
+
+// responseError returns the error of a failed request, as reported by the
+// daemon in the response, if it did.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	var body api.ErrorResponse
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, body.Error)
+		}
+	}
+	return fmt.Errorf("unexpected status code received: %s", resp.Status)
+}
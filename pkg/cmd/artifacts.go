@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+)
+
+// artifactFilterFlags are the flags selecting build artifacts, shared by the
+// `build artifacts` subcommands.
+var artifactFilterFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "builder",
+		Aliases: []string{"b"},
+		Usage:   "only select artifacts built by this builder; defaults to all builders",
+	},
+	&cli.StringFlag{
+		Name:    "plan",
+		Aliases: []string{"p"},
+		Usage:   "only select artifacts built from this test plan",
+	},
+	&cli.DurationFlag{
+		Name:  "older-than",
+		Usage: "only select artifacts built longer than `DURATION` ago, e.g. 72h",
+	},
+	&cli.StringFlag{
+		Name:  "larger-than",
+		Usage: "only select artifacts larger than `SIZE`, e.g. 500MB",
+	},
+}
+
+var buildArtifactsCommand = &cli.Command{
+	Name:    "artifacts",
+	Aliases: []string{"a"},
+	Usage:   "manage the artifacts (docker images, executables) produced by builders",
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:         "list",
+			Aliases:      []string{"ls"},
+			Usage:        "list build artifacts, newest first",
+			Action:       buildArtifactsListCmd,
+			BashComplete: completeFlagValues,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the artifacts as JSON",
+				},
+			}, artifactFilterFlags...),
+		},
+		&cli.Command{
+			Name:      "inspect",
+			Usage:     "print the details of a build artifact",
+			ArgsUsage: "[artifact]",
+			Action:    buildArtifactsInspectCmd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "builder",
+					Aliases: []string{"b"},
+					Usage:   "only look up artifacts built by this builder; defaults to all builders",
+				},
+				&cli.BoolFlag{
+					Name:  "provenance",
+					Usage: "print the provenance of the artifact, as JSON: the inputs of the build it was produced by",
+				},
+			},
+		},
+		&cli.Command{
+			Name:         "gc",
+			Usage:        "remove the selected build artifacts",
+			Action:       buildArtifactsGCCmd,
+			BashComplete: completeFlagValues,
+			Flags: append([]cli.Flag{
+				&cli.IntFlag{
+					Name:  "keep-last",
+					Usage: "keep the `N` most recent artifacts of every builder and test plan",
+				},
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "allow removing all artifacts when no other filter is set",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the artifacts that would be removed, without removing them",
+				},
+			}, artifactFilterFlags...),
+		},
+	},
+}
+
+func buildArtifactsListCmd(c *cli.Context) error {
+	filter, err := artifactFilter(c)
+	if err != nil {
+		return err
+	}
+
+	artifacts, err := buildArtifacts(c, filter, false)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(artifacts)
+	}
+
+	printArtifacts(artifacts)
+	return nil
+}
+
+func buildArtifactsInspectCmd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected one artifact as an argument")
+	}
+
+	artifacts, err := buildArtifacts(c, api.ArtifactFilter{ID: c.Args().First()}, false)
+	if err != nil {
+		return err
+	}
+
+	switch len(artifacts) {
+	case 0:
+		return fmt.Errorf("no such artifact: %s", c.Args().First())
+	case 1:
+	default:
+		return fmt.Errorf("ambiguous artifact %s; matches %d artifacts", c.Args().First(), len(artifacts))
+	}
+
+	a := artifacts[0]
+	if c.Bool("provenance") {
+		if a.Provenance == nil {
+			return fmt.Errorf("no provenance recorded for artifact %s", a.ID)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a.Provenance)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "id:\t%s\n", a.ID)
+	fmt.Fprintf(w, "builder:\t%s\n", a.Builder)
+	fmt.Fprintf(w, "plan:\t%s\n", a.Plan)
+	fmt.Fprintf(w, "created:\t%s (%s ago)\n", a.Created.Format(time.RFC3339), units.HumanDuration(time.Since(a.Created)))
+	fmt.Fprintf(w, "size:\t%s\n", units.HumanSize(float64(a.Size)))
+	for _, t := range a.Tags {
+		fmt.Fprintf(w, "tag:\t%s\n", t)
+	}
+	for _, k := range sortedStringKeys(a.Labels) {
+		fmt.Fprintf(w, "label:\t%s=%s\n", k, a.Labels[k])
+	}
+	for _, k := range sortedStringKeys(a.Overrides) {
+		fmt.Fprintf(w, "override:\t%s => %s\n", k, a.Overrides[k])
+	}
+	for _, k := range sortedStringKeys(a.Dependencies) {
+		fmt.Fprintf(w, "dependency:\t%s %s\n", k, a.Dependencies[k])
+	}
+	if p := a.Provenance; p != nil {
+		if p.Commit != "" {
+			fmt.Fprintf(w, "commit:\t%s\n", p.Commit)
+		}
+		fmt.Fprintf(w, "sources:\tsha256:%s\n", p.Sources)
+		if p.Toolchain != "" {
+			fmt.Fprintf(w, "toolchain:\t%s\n", p.Toolchain)
+		}
+	}
+	return nil
+}
+
+func buildArtifactsGCCmd(c *cli.Context) error {
+	filter, err := artifactFilter(c)
+	if err != nil {
+		return err
+	}
+	filter.KeepLast = c.Int("keep-last")
+	if filter.KeepLast < 0 {
+		return fmt.Errorf("--keep-last must not be negative")
+	}
+
+	if filter == (api.ArtifactFilter{}) && c.String("builder") == "" && !c.Bool("all") {
+		return fmt.Errorf("refusing to remove all artifacts; set a filter, or --all")
+	}
+
+	dryRun := c.Bool("dry-run")
+	artifacts, err := buildArtifacts(c, filter, !dryRun)
+	if err != nil {
+		return err
+	}
+
+	printArtifacts(artifacts)
+
+	var size int64
+	for _, a := range artifacts {
+		size += a.Size
+	}
+	if dryRun {
+		fmt.Printf("would remove %d artifacts, freeing %s\n", len(artifacts), units.HumanSize(float64(size)))
+	} else {
+		fmt.Printf("removed %d artifacts, freeing %s\n", len(artifacts), units.HumanSize(float64(size)))
+	}
+	return nil
+}
+
+// artifactFilter builds an artifact filter from the artifactFilterFlags.
+func artifactFilter(c *cli.Context) (api.ArtifactFilter, error) {
+	filter := api.ArtifactFilter{
+		Plan:      c.String("plan"),
+		OlderThan: c.Duration("older-than"),
+	}
+	if s := c.String("larger-than"); s != "" {
+		size, err := units.FromHumanSize(s)
+		if err != nil {
+			return filter, fmt.Errorf("invalid size %q: %w", s, err)
+		}
+		filter.LargerThan = size
+	}
+	return filter, nil
+}
+
+func buildArtifacts(c *cli.Context, filter api.ArtifactFilter, remove bool) ([]*api.Artifact, error) {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cl.BuildArtifacts(ctx, &api.BuildArtifactsRequest{
+		Builder: c.String("builder"),
+		Filter:  filter,
+		Remove:  remove,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	return client.ParseBuildArtifactsResponse(resp)
+}
+
+func printArtifacts(artifacts []*api.Artifact) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "ARTIFACT\tBUILDER\tPLAN\tCREATED\tSIZE")
+	for _, a := range artifacts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\n", a.ID, a.Builder, a.Plan,
+			units.HumanDuration(time.Since(a.Created)), units.HumanSize(float64(a.Size)))
+	}
+}
+
+// sortedStringKeys returns the keys of m, sorted.
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -27,10 +28,11 @@ var BuildCommand = cli.Command{
 	Usage: "request the daemon to build a test plan",
 	Subcommands: cli.Commands{
 		&cli.Command{
-			Name:    "composition",
-			Aliases: []string{"c"},
-			Usage:   "builds a composition.",
-			Action:  buildCompositionCmd,
+			Name:         "composition",
+			Aliases:      []string{"c"},
+			Usage:        "builds a composition.",
+			Action:       buildCompositionCmd,
+			BashComplete: completeFlagValues,
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:     "file",
@@ -51,28 +53,53 @@ var BuildCommand = cli.Command{
 					Name:  "wait",
 					Usage: "wait for the task to complete",
 				},
+				&cli.IntFlag{
+					Name:  "priority",
+					Usage: "scheduling priority of the task; tasks of higher priority are scheduled first, and the daemon may refuse priorities above its maximum",
+				},
+				&cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "rebuild the artifacts, rather than reusing those of previous builds of the same sources, dependencies and configuration",
+				},
+				&cli.BoolFlag{
+					Name:  "race",
+					Usage: "build with the race detector; go builders only",
+				},
+				&cli.BoolFlag{
+					Name:  "cover",
+					Usage: "build with coverage instrumentation, and have the instances write their coverage data to their outputs; go builders with Go 1.20 or later only",
+				},
+				insecureIncludesFlag,
+				&cli.StringSliceFlag{
+					Name:  "set",
+					Usage: "set a composition template value, available as {{.Values.<key>}}; overrides --values",
+				},
+				&cli.StringFlag{
+					Name:  "values",
+					Usage: "load composition template values from the TOML `FILE`",
+				},
 			},
 		},
 		&cli.Command{
-			Name:    "single",
-			Aliases: []string{"s"},
-			Usage:   "builds a single group, passing in all necessary input via CLI flags.",
-			Action:  buildSingleCmd,
+			Name:         "single",
+			Aliases:      []string{"s"},
+			Usage:        "builds a single group, passing in all necessary input via CLI flags.",
+			Action:       buildSingleCmd,
+			BashComplete: completeFlagValues,
 			Flags: cli.FlagsByName{
 				&cli.StringSliceFlag{
 					Name:  "build-cfg",
 					Usage: "set a build config parameter",
 				},
 				&cli.StringFlag{
-					Name:     "builder",
-					Aliases:  []string{"b"},
-					Usage:    "specifies the builder to use; values include: 'docker:go', 'exec:go'",
-					Required: true,
+					Name:    "builder",
+					Aliases: []string{"b"},
+					Usage:   "specifies the builder to use; values include: 'docker:go', 'exec:go'; defaults to the builder of the profile",
 				},
 				&cli.StringSliceFlag{
 					Name:    "dep",
 					Aliases: []string{"d"},
-					Usage:   "set a dependency mapping",
+					Usage:   "override a dependency: module=version, module=target@version, or module=../local/dir",
 				},
 				&cli.StringFlag{
 					Name:  "link-sdk",
@@ -84,6 +111,22 @@ var BuildCommand = cli.Command{
 					Usage:    "specifies the plan to run",
 					Required: true,
 				},
+				&cli.IntFlag{
+					Name:  "priority",
+					Usage: "scheduling priority of the task; tasks of higher priority are scheduled first, and the daemon may refuse priorities above its maximum",
+				},
+				&cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "rebuild the artifacts, rather than reusing those of previous builds of the same sources, dependencies and configuration",
+				},
+				&cli.BoolFlag{
+					Name:  "race",
+					Usage: "build with the race detector; go builders only",
+				},
+				&cli.BoolFlag{
+					Name:  "cover",
+					Usage: "build with coverage instrumentation, and have the instances write their coverage data to their outputs; go builders with Go 1.20 or later only",
+				},
 				&cli.BoolFlag{
 					Name:  "wait",
 					Usage: "Wait for the task to complete",
@@ -110,18 +153,19 @@ var BuildCommand = cli.Command{
 				},
 			},
 		},
+		buildArtifactsCommand,
 	},
 }
 
 func buildCompositionCmd(c *cli.Context) (err error) {
-	comp := new(api.Composition)
 	file := c.String("file")
 	if file == "" {
 		return fmt.Errorf("no composition file supplied")
 	}
 
-	if _, err = toml.DecodeFile(file, comp); err != nil {
-		return fmt.Errorf("failed to process composition file: %w", err)
+	comp, err := loadComposition(c, file)
+	if err != nil {
+		return err
 	}
 	if err = comp.ValidateForBuild(); err != nil {
 		return fmt.Errorf("invalid composition file: %w", err)
@@ -190,21 +234,13 @@ func doBuild(c *cli.Context, comp *api.Composition) error {
 
 	logging.S().Infof("test plan source at: %s", planDir)
 
-	comp, err = comp.PrepareForBuild(manifest)
-	if err != nil {
+	if err := applyBuildModes(c, comp); err != nil {
 		return err
 	}
 
-	req := &api.BuildRequest{
-		Composition: *comp,
-		Manifest:    *manifest,
-		CreatedBy: api.CreatedBy{
-			User: cfg.Client.User,
-		},
-	}
-
-	if wait {
-		req.Priority = 1
+	comp, err = comp.PrepareForBuild(manifest)
+	if err != nil {
+		return err
 	}
 
 	// if there are extra sources to include for this builder, contextualize
@@ -223,6 +259,26 @@ func doBuild(c *cli.Context, comp *api.Composition) error {
 		}
 	}
 
+	extra, err = shipLocalDependencies(comp, extra)
+	if err != nil {
+		return err
+	}
+
+	req := &api.BuildRequest{
+		Composition: *comp,
+		Manifest:    *manifest,
+		CreatedBy: api.CreatedBy{
+			User: cfg.Client.User,
+		},
+		SourceCommit: sourceCommit(planDir),
+	}
+
+	req.Priority = c.Int("priority")
+	req.NoCache = c.Bool("no-cache")
+	if wait && !c.IsSet("priority") {
+		req.Priority = 1
+	}
+
 	resp, err := cl.Build(ctx, req, planDir, sdkDir, extra)
 	if err != nil {
 		return err
@@ -254,7 +310,7 @@ func doBuild(c *cli.Context, comp *api.Composition) error {
 	}
 	defer r.Close()
 
-	tsk, err := client.ParseLogsRequest(os.Stdout, r)
+	tsk, err := client.ParseLogsResponse(os.Stdout, r)
 	if err != nil {
 		return err
 	}
@@ -309,3 +365,62 @@ func runBuildPurgeCmd(c *cli.Context) (err error) {
 	fmt.Printf("finished purging testplan %s for builder %s\n", plan, builder)
 	return nil
 }
+
+// applyBuildModes enables the build modes of --race and --cover in the build
+// configuration of the composition, and of its groups.
+func applyBuildModes(c *cli.Context, comp *api.Composition) error {
+	var modes []string
+	for _, m := range []string{"race", "cover"} {
+		if c.Bool(m) {
+			modes = append(modes, m)
+		}
+	}
+	if len(modes) == 0 {
+		return nil
+	}
+
+	enable := func(builder string, bcfg *map[string]interface{}) error {
+		if builder != "docker:go" && builder != "exec:go" {
+			return fmt.Errorf("--%s is only supported by the go builders, docker:go and exec:go; got: %q", modes[0], builder)
+		}
+		if *bcfg == nil {
+			*bcfg = make(map[string]interface{}, len(modes))
+		}
+		for _, m := range modes {
+			(*bcfg)[m] = true
+		}
+		return nil
+	}
+
+	// groups inherit the global build configuration, but may override it.
+	if comp.Global.Builder != "" {
+		if err := enable(comp.Global.Builder, &comp.Global.BuildConfig); err != nil {
+			return err
+		}
+	}
+	for _, grp := range comp.Groups {
+		builder := grp.Builder
+		if builder == "" {
+			builder = comp.Global.Builder
+		}
+		if err := enable(builder, &grp.BuildConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceCommit returns the git commit of the sources of the plan in dir,
+// suffixed with +dirty if they have uncommitted changes, for the provenance of
+// the artifacts; or nothing if they aren't in a git repository.
+func sourceCommit(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+	if out, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--", ".").Output(); err == nil && len(out) > 0 {
+		commit += "+dirty"
+	}
+	return commit
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+)
+
+// CleanupCommand is the specification of the `cleanup` command.
+var CleanupCommand = cli.Command{
+	Name:         "cleanup",
+	Usage:        "remove the containers, pods and networks left behind by runs",
+	Action:       cleanupCommand,
+	BashComplete: completeFlagValues,
+	ArgsUsage:    "[run_id...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "runner",
+			Usage: "only clean up with this runner; values include: 'local:docker', 'local:podman', 'cluster:k8s', 'cluster:nomad'; defaults to all runners",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "clean up after all the runs that are over, rather than the supplied ones",
+		},
+	},
+}
+
+func cleanupCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	var (
+		all    = c.Bool("all")
+		runIDs = c.Args().Slice()
+	)
+
+	switch {
+	case all && len(runIDs) > 0:
+		return errors.New("cannot accept run ids along with --all")
+	case !all && len(runIDs) == 0:
+		return errors.New("specify the runs to clean up after, or --all")
+	}
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.Cleanup(ctx, &api.CleanupRequest{
+		Runner: c.String("runner"),
+		RunIDs: runIDs,
+	})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cleaned, err := client.ParseCleanupResponse(r)
+	if err != nil {
+		return err
+	}
+
+	if len(cleaned) == 0 {
+		fmt.Println("nothing to clean up")
+		return nil
+	}
+	for _, id := range cleaned {
+		fmt.Printf("cleaned up after run %s\n", id)
+	}
+	return nil
+}
@@ -4,33 +4,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/client"
 	"github.com/testground/testground/pkg/logging"
 
+	"github.com/BurntSushi/toml"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/mitchellh/mapstructure"
 	"github.com/urfave/cli/v2"
 )
 
 // CollectCommand is the specification of the `collect` command.
 var CollectCommand = cli.Command{
-	Name:      "collect",
-	Usage:     "collect the output assets of the supplied run into a .tgz archive",
-	Action:    collectCommand,
-	ArgsUsage: "[run_id]",
+	Name:         "collect",
+	Usage:        "collect the output assets of the supplied run into a .tgz archive",
+	Action:       collectCommand,
+	BashComplete: completeTaskArgs,
+	ArgsUsage:    "[run_id]",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
-			Name:     "runner",
-			Aliases:  []string{"r"},
-			Usage:    "runner to use; values include: 'local:exec', 'local:docker', 'cluster:k8s'",
-			Required: true,
+			Name:    "runner",
+			Aliases: []string{"r"},
+			Usage:   "runner the run was executed with; deprecated, the daemon resolves it from the run id",
 		},
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
 			Usage:   "write the output archive to `FILENAME`",
 		},
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "extract the outputs into `DIR`, along with the composition and the daemon logs of the run, instead of writing an archive",
+		},
 	},
 }
 
@@ -57,9 +68,139 @@ func collectCommand(c *cli.Context) error {
 		return err
 	}
 
+	if dir := c.String("dir"); dir != "" {
+		if c.String("output") != "" {
+			return errors.New("--output and --dir are mutually exclusive")
+		}
+		return collectToDir(ctx, cl, runner, id, dir)
+	}
+
 	return collect(ctx, cl, runner, id, output)
 }
 
+// collectToDir collects the outputs of a run and extracts them into
+// <dir>/<run_id>, adding the composition of the run (composition.toml) and
+// the daemon logs of the task (run.out).
+func collectToDir(ctx context.Context, cl *client.Client, runner string, runid string, dir string) error {
+	tmp, err := ioutil.TempFile("", runid+"-*.tgz")
+	if err != nil {
+		return err
+	}
+	_ = tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := collect(ctx, cl, runner, runid, tmp.Name()); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmp.Name())
+	if os.IsNotExist(err) {
+		// the run does not exist; collect has already reported it.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := archive.Untar(f, dir, &archive.TarOptions{NoLchown: true}); err != nil {
+		return fmt.Errorf("failed to extract outputs: %w", err)
+	}
+
+	rundir := filepath.Join(dir, runid)
+	if err := os.MkdirAll(rundir, 0755); err != nil {
+		return err
+	}
+
+	// Write the daemon logs of the task.
+	logs, err := os.Create(filepath.Join(rundir, "run.out"))
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	r, err := cl.Logs(ctx, &api.LogsRequest{TaskID: runid})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tsk, err := client.ParseLogsResponse(logs, r)
+	if err != nil {
+		return fmt.Errorf("failed to fetch task logs: %w", err)
+	}
+
+	// Write the composition of the run.
+	var comp api.Composition
+	if err := mapstructure.Decode(tsk.Composition, &comp); err != nil {
+		return fmt.Errorf("failed to decode run composition: %w", err)
+	}
+
+	cf, err := os.Create(filepath.Join(rundir, "composition.toml"))
+	if err != nil {
+		return err
+	}
+	defer cf.Close()
+
+	if err := toml.NewEncoder(cf).Encode(comp); err != nil {
+		return fmt.Errorf("failed to encode run composition: %w", err)
+	}
+
+	if err := mergeCoverage(rundir); err != nil {
+		logging.S().Warnw("failed to merge the coverage data of the run", "error", err)
+	}
+
+	logging.S().Infof("extracted outputs into: %s", rundir)
+	return nil
+}
+
+// mergeCoverage merges the coverage data that the instances of coverage builds
+// wrote to their outputs into a coverage report of the run: the merged data,
+// in <rundir>/coverage, and a profile for go tool cover, in
+// <rundir>/coverage.out.
+func mergeCoverage(rundir string) error {
+	merged := filepath.Join(rundir, "coverage")
+
+	var dirs []string
+	err := filepath.Walk(rundir, func(path string, fi os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case fi.IsDir() && path == merged:
+			return filepath.SkipDir
+		case !fi.IsDir() && strings.HasPrefix(fi.Name(), "covmeta."):
+			if dir := filepath.Dir(path); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(dirs) == 0 {
+		return err
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("go is required to merge coverage data: %w", err)
+	}
+	if err := os.MkdirAll(merged, 0755); err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"tool", "covdata", "merge", "-i=" + strings.Join(dirs, ","), "-o=" + merged},
+		{"tool", "covdata", "textfmt", "-i=" + merged, "-o=" + filepath.Join(rundir, "coverage.out")},
+	} {
+		if out, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("go %s failed: %w: %s", strings.Join(args[:3], " "), err, out)
+		}
+	}
+
+	logging.S().Infow("merged coverage data", "instances", len(dirs), "profile", filepath.Join(rundir, "coverage.out"))
+	return nil
+}
+
 func collect(ctx context.Context, cl *client.Client, runner string, runid string, outputFile string) error {
 	req := &api.OutputsRequest{
 		Runner: runner,
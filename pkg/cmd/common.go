@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/urfave/cli/v2"
@@ -14,11 +20,13 @@ import (
 	"github.com/testground/testground/pkg/client"
 	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/conv"
+	"github.com/testground/testground/pkg/engine"
+	"github.com/testground/testground/pkg/logging"
 )
 
 func setupClient(c *cli.Context) (*client.Client, *config.EnvConfig, error) {
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return nil, nil, err
 	}
 	endpoint := c.String("endpoint")
@@ -31,6 +39,212 @@ func setupClient(c *cli.Context) (*client.Client, *config.EnvConfig, error) {
 	return cl, cfg, nil
 }
 
+// loadConfig loads the configuration from the file given with --config, or
+// from .env.toml, and applies the profile selected with --profile, if any.
+func loadConfig(c *cli.Context) (*config.EnvConfig, error) {
+	cfg := &config.EnvConfig{}
+	if err := cfg.LoadFrom(c.String("config")); err != nil {
+		return nil, err
+	}
+	if profile := c.String("profile"); profile != "" {
+		if err := cfg.ApplyProfile(profile); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// compositionData is the data composition files are rendered with, when
+// executed as templates.
+type compositionData struct {
+	// Env contains the environment variables of the client.
+	Env map[string]string
+
+	// Values contains the values loaded from the --values file, overridden
+	// by --set flags.
+	Values map[string]interface{}
+}
+
+// loadComposition renders the composition file as a template, and decodes
+// the result.
+func loadComposition(c *cli.Context, file string) (*api.Composition, error) {
+	fdata, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &compositionData{
+		Env:    map[string]string{},
+		Values: map[string]interface{}{},
+	}
+
+	// Build a map of environment variables
+	for _, v := range os.Environ() {
+		s := strings.SplitN(v, "=", 2)
+		data.Env[s[0]] = s[1]
+	}
+
+	if vfile := c.String("values"); vfile != "" {
+		if _, err := toml.DecodeFile(vfile, &data.Values); err != nil {
+			return nil, fmt.Errorf("failed to process values file: %w", err)
+		}
+	}
+
+	set, err := conv.ParseKeyValues(c.StringSlice("set"))
+	if err != nil {
+		return nil, fmt.Errorf("failed while parsing template values: %w", err)
+	}
+	for k, v := range set {
+		data.Values[k] = v
+	}
+
+	src, err := renderComposition(file, fdata, data)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, warnings, err := api.DecodeComposition(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process composition file %s: %w", file, err)
+	}
+	for _, w := range warnings {
+		logging.S().Warnf("composition file %s: %s", file, w)
+	}
+
+	// Resolve the included fragments, rendered with the same data; but for
+	// the environment of the client, which fragments fetched by URL don't get,
+	// for its secrets not to end up in compositions written by others.
+	remote := &compositionData{Values: data.Values}
+	includeWarnings, err := comp.ResolveIncludes(file, func(ref string) (string, error) {
+		fdata, err := fetchInclude(c.Context, ref, c.Bool("insecure-includes"))
+		if err != nil {
+			return "", err
+		}
+		if api.IsIncludeURL(ref) {
+			return renderComposition(ref, fdata, remote)
+		}
+		return renderComposition(ref, fdata, data)
+	})
+	for _, w := range includeWarnings {
+		logging.S().Warnf("composition file %s: %s", file, w)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to process composition file %s: %w", file, err)
+	}
+	if err = applyConfigDefaults(c, comp); err != nil {
+		return nil, err
+	}
+	return comp, nil
+}
+
+// renderComposition parses and runs the source of a composition file as a
+// template.
+func renderComposition(name string, src []byte, data *compositionData) (string, error) {
+	tpl, err := template.New(filepath.Base(name)).Parse(string(src))
+	if err != nil {
+		return "", err
+	}
+	buff := &bytes.Buffer{}
+	if err = tpl.Execute(buff, data); err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
+
+// insecureIncludesFlag lets a composition include fragments by plain http
+// URLs. It's a flag of all the commands that load compositions.
+var insecureIncludesFlag = &cli.BoolFlag{
+	Name:  "insecure-includes",
+	Usage: "allow the composition to include fragments by plain http URLs",
+}
+
+// includeFetchTimeout bounds the time it takes to fetch an included
+// composition fragment by URL.
+const includeFetchTimeout = 30 * time.Second
+
+// fetchInclude reads the composition fragment at ref, a file path or an
+// http(s) URL; plain http URLs are refused, unless insecure.
+func fetchInclude(ctx context.Context, ref string, insecure bool) ([]byte, error) {
+	if !api.IsIncludeURL(ref) {
+		return ioutil.ReadFile(ref)
+	}
+	if strings.HasPrefix(ref, "http://") && !insecure {
+		return nil, fmt.Errorf("refusing to fetch %s over plain http; use https, or --insecure-includes", ref)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, includeFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// applyConfigDefaults sets the runner and the builder of the composition to
+// the defaults of the client configuration, if the composition doesn't set
+// them.
+func applyConfigDefaults(c *cli.Context, comp *api.Composition) error {
+	if comp.Global.Runner != "" && comp.Global.Builder != "" {
+		return nil
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if comp.Global.Runner == "" {
+		comp.Global.Runner = cfg.Client.Runner
+	}
+	if comp.Global.Builder == "" {
+		comp.Global.Builder = cfg.Client.Builder
+	}
+	if comp.Global.Builder == "" && comp.Global.Runner != "" {
+		return selectBuilder(cfg, comp)
+	}
+	return nil
+}
+
+// selectBuilder sets the builder of the composition to the first builder of
+// the test plan that is compatible with its runner, if any group lacks a
+// builder. Runners served by plugins are unknown to the client; the daemon
+// then reports the missing builder.
+func selectBuilder(cfg *config.EnvConfig, comp *api.Composition) error {
+	missing := false
+	for _, g := range comp.Groups {
+		missing = missing || g.Builder == ""
+	}
+
+	reg := engine.DefaultRegistry()
+	runner, ok := reg.Runner(comp.Global.Runner)
+	if !missing || !ok {
+		return nil
+	}
+
+	_, manifest, err := resolveTestPlan(cfg, comp.Global.Plan)
+	if err != nil {
+		return fmt.Errorf("failed to resolve test plan: %w", err)
+	}
+
+	builder, err := engine.SelectBuilder(reg.Builders(), runner, manifest)
+	if err != nil {
+		return err
+	}
+	logging.S().Infof("no builder specified; using %s, which is compatible with runner %s", builder.ID(), runner.ID())
+	comp.Global.Builder = builder.ID()
+	return nil
+}
+
 // createSingletonComposition parses a single-style command line build/run, and
 // produces a synthetic composition to submit to the server.
 func createSingletonComposition(c *cli.Context) (*api.Composition, error) {
@@ -51,6 +265,7 @@ func createSingletonComposition(c *cli.Context) (*api.Composition, error) {
 		// Run struct
 		artifact   = c.String("use-build")
 		testparams = c.StringSlice("test-param")
+		sweeps     = c.StringSlice("sweep")
 	)
 
 	comp := &api.Composition{
@@ -96,6 +311,18 @@ func createSingletonComposition(c *cli.Context) (*api.Composition, error) {
 	}
 	comp.Groups[0].Run.TestParams = parameters
 
+	// Test parameter sweeps.
+	sweep, err := conv.ParseKeyValues(sweeps)
+	if err != nil {
+		return nil, fmt.Errorf("failed while parsing sweeps: %w", err)
+	}
+	if len(sweep) > 0 {
+		comp.Sweep = make(map[string][]string, len(sweep))
+		for k, v := range sweep {
+			comp.Sweep[k] = strings.Split(v, ",")
+		}
+	}
+
 	deps, err := conv.ParseKeyValues(dependencies)
 	if err != nil {
 		return nil, err
@@ -103,18 +330,17 @@ func createSingletonComposition(c *cli.Context) (*api.Composition, error) {
 	comp.Groups[0].Build.Dependencies = make([]api.Dependency, 0, len(dependencies))
 
 	for name, target := range deps {
-		parts := strings.Split(target, "@")
-		if (len(parts)) != 2 {
-			return nil, fmt.Errorf("invalid target-version: %s", target)
-		}
-
-		dep := api.Dependency{
-			Module:  name,
-			Target:  parts[0],
-			Version: parts[1],
-		}
+		comp.Groups[0].Build.Dependencies = append(comp.Groups[0].Build.Dependencies, api.ParseDependency(name, target))
+	}
 
-		comp.Groups[0].Build.Dependencies = append(comp.Groups[0].Build.Dependencies, dep)
+	if err = applyConfigDefaults(c, comp); err != nil {
+		return nil, err
+	}
+	switch {
+	case comp.Global.Builder == "":
+		return nil, errors.New("no builder supplied; set --builder, or a default builder in the client config")
+	case comp.Global.Runner == "" && strings.HasPrefix(c.Command.FullName(), "run"):
+		return nil, errors.New("no runner supplied; set --runner, or a default runner in the client config")
 	}
 
 	// Validate the composition before returning it.
@@ -149,14 +375,32 @@ func resolveTestPlan(cfg *config.EnvConfig, name string) (string, *api.TestPlanM
 		return "", nil, fmt.Errorf("failed to access plan manifest at %s: not a file", manifest)
 	}
 
-	plan := new(api.TestPlanManifest)
-	if _, err := toml.DecodeFile(manifest, plan); err != nil {
+	plan, err := loadManifest(manifest)
+	if err != nil {
 		return "", nil, fmt.Errorf("failed to parse manifest file at %s: %w", manifest, err)
 	}
 
 	return path, plan, nil
 }
 
+// loadManifest decodes the test plan manifest at the given path, and warns
+// about the problems found in version 1 manifests.
+func loadManifest(file string) (*api.TestPlanManifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, warnings, err := api.DecodeManifest(string(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		logging.S().Warnf("manifest file %s: %s", file, w)
+	}
+	return manifest, nil
+}
+
 // resolveSDK resolves the root directory of an SDK.
 func resolveSDK(cfg *config.EnvConfig, path string) (string, error) {
 	baseDir := cfg.Dirs().SDKs()
@@ -192,3 +436,53 @@ func isDirectory(path string) bool {
 	}
 	return true
 }
+
+// shipLocalDependencies adds the directories that replace dependencies of the
+// composition, e.g. ../go-libp2p, to the extra sources of the build, so that
+// the daemon receives them; relative directories are relative to the working
+// directory. The targets of the dependencies are made absolute, as the daemon
+// finds them in the extra sources by name.
+func shipLocalDependencies(comp *api.Composition, extra []string) ([]string, error) {
+	names := make(map[string]string, len(extra))
+	for _, dir := range extra {
+		names[filepath.Base(dir)] = dir
+	}
+
+	ship := func(deps api.Dependencies) error {
+		for i, dep := range deps {
+			if !dep.IsLocal() {
+				continue
+			}
+			dir, err := filepath.Abs(dep.Target)
+			if err != nil {
+				return err
+			}
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				return fmt.Errorf("replacement of %s is not a directory: %s", dep.Module, dep.Target)
+			}
+			deps[i].Target = dir
+
+			switch other, ok := names[filepath.Base(dir)]; {
+			case !ok:
+				names[filepath.Base(dir)] = dir
+				extra = append(extra, dir)
+				logging.S().Infow("shipping local replacement", "module", dep.Module, "dir", dir)
+			case other != dir:
+				return fmt.Errorf("can't ship both %s and %s, of the same name", other, dir)
+			}
+		}
+		return nil
+	}
+
+	if comp.Global.Build != nil {
+		if err := ship(comp.Global.Build.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range comp.Groups {
+		if err := ship(g.Build.Dependencies); err != nil {
+			return nil, err
+		}
+	}
+	return extra, nil
+}
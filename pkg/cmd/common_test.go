@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCompositionCommandsAllowInsecureIncludes(t *testing.T) {
+	for _, cmd := range []*cli.Command{
+		BuildCommand.Subcommands[0],
+		RunCommand.Subcommands[0],
+		ScheduleCommand.Subcommands[0],
+	} {
+		found := false
+		for _, f := range cmd.Flags {
+			for _, n := range f.Names() {
+				found = found || n == insecureIncludesFlag.Name
+			}
+		}
+		require.True(t, found, "%s should have the --%s flag", cmd.Name, insecureIncludesFlag.Name)
+	}
+}
+
+func TestLoadCompositionURLIncludes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[global]\ncase = \"{{ .Env.TESTGROUND_TEST_SECRET }}\"\n"))
+	}))
+	defer srv.Close()
+	require.NoError(t, os.Setenv("TESTGROUND_TEST_SECRET", "secret"))
+	defer os.Unsetenv("TESTGROUND_TEST_SECRET")
+
+	file := filepath.Join(t.TempDir(), "composition.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`include = ["`+srv.URL+`/base.toml"]
+
+[global]
+plan = "placebo"
+builder = "docker:go"
+runner = "local:docker"
+total_instances = 1
+
+[[groups]]
+id = "single"
+instances = { count = 1 }
+`), 0644))
+
+	load := func(args ...string) error {
+		set := flag.NewFlagSet("composition", flag.ContinueOnError)
+		for _, f := range RunCommand.Subcommands[0].Flags {
+			require.NoError(t, f.Apply(set))
+		}
+		require.NoError(t, set.Parse(args))
+		c := cli.NewContext(cli.NewApp(), set, nil)
+		c.Context = context.Background()
+
+		comp, err := loadComposition(c, file)
+		if err == nil {
+			require.NotContains(t, comp.Global.Case, "secret", "fragments fetched by URL should not see the environment")
+		}
+		return err
+	}
+
+	err := load()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "plain http", "plain http includes should be refused")
+	require.NoError(t, load("--insecure-includes"))
+}
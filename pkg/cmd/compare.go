@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/testground/testground/pkg/data"
+
+	"github.com/urfave/cli/v2"
+)
+
+var CompareCommand = cli.Command{
+	Name:         "compare",
+	Usage:        "compare two finished runs, flagging regressions",
+	ArgsUsage:    "[run-a] [run-b]",
+	Action:       compareCommand,
+	BashComplete: completeTaskArgs,
+	Flags: []cli.Flag{
+		&cli.Float64Flag{
+			Name:  "threshold",
+			Usage: "flag metrics that got worse by more than `PERCENT` as regressions",
+			Value: 10,
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "output `FORMAT`; values: text, json, markdown",
+			Value: "text",
+		},
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "compare the metrics of the runs collected into `DIR` with 'collect --dir'; runs missing from it are collected into it; without --dir, they're collected into a temporary directory",
+		},
+		&cli.StringSliceFlag{
+			Name:  "higher-is-better",
+			Usage: "`METRIC` that improves when it increases, by name or as <metric>/<measure>; other metrics improve when they decrease; can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "fail-on-regression",
+			Usage: "exit with a non-zero status code if any regression is found",
+		},
+	},
+}
+
+func compareCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	if c.NArg() != 2 {
+		return errors.New("expected two run ids")
+	}
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	threshold := c.Float64("threshold")
+
+	a, err := taskStatus(ctx, cl, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	b, err := taskStatus(ctx, cl, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	cmp, err := data.CompareRuns(a, b, threshold)
+	if err != nil {
+		return err
+	}
+
+	dir := c.String("dir")
+	if dir == "" {
+		if dir, err = ioutil.TempDir("", "compare"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	var outputs [2]*data.RunOutputs
+	for i, id := range []string{a.ID, b.ID} {
+		if !isDirectory(filepath.Join(dir, id)) {
+			if err := collectToDir(ctx, cl, "", id, dir); err != nil {
+				return fmt.Errorf("failed to collect the outputs of run %s: %w", id, err)
+			}
+		}
+		if outputs[i], err = data.ReadRunOutputs(dir, id); err != nil {
+			return fmt.Errorf("failed to read the outputs of run %s: %w", id, err)
+		}
+	}
+
+	if err := cmp.CompareMetrics(outputs[0], outputs[1], threshold, c.StringSlice("higher-is-better")); err != nil {
+		return err
+	}
+
+	switch output := c.String("output"); output {
+	case "text":
+		printComparison(os.Stdout, cmp)
+	case "markdown":
+		printComparisonMarkdown(os.Stdout, cmp)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cmp); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+
+	if regs := cmp.Regressions(); len(regs) > 0 && c.Bool("fail-on-regression") {
+		return cli.Exit(fmt.Sprintf("found %d regressions", len(regs)), 1)
+	}
+	return nil
+}
+
+func formatPercent(m *data.MetricDelta) string {
+	if m.Percent == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.2f%%", *m.Percent)
+}
+
+func printComparison(w io.Writer, cmp *data.Comparison) {
+	fmt.Fprintf(w, "A: %s (%s)\nB: %s (%s)\n\n", cmp.A, cmp.OutcomeA, cmp.B, cmp.OutcomeB)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tA\tB\tDELTA\tCHANGE\tREGRESSION")
+	for _, m := range cmp.Metrics {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%+.3f\t%s\t%t\n", m.Name, m.A, m.B, m.Delta, formatPercent(m), m.Regression)
+	}
+	tw.Flush()
+}
+
+func printComparisonMarkdown(w io.Writer, cmp *data.Comparison) {
+	fmt.Fprintf(w, "Comparison of run `%s` (%s) against `%s` (%s)\n\n", cmp.B, cmp.OutcomeB, cmp.A, cmp.OutcomeA)
+	fmt.Fprintln(w, "| Metric | A | B | Delta | Change | |")
+	fmt.Fprintln(w, "|---|---:|---:|---:|---:|---|")
+	for _, m := range cmp.Metrics {
+		flag := ""
+		if m.Regression {
+			flag = ":warning: regression"
+		}
+		fmt.Fprintf(w, "| %s | %.3f | %.3f | %+.3f | %s | %s |\n", m.Name, m.A, m.B, m.Delta, formatPercent(m), flag)
+	}
+}
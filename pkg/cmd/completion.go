@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/engine"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/mattn/go-zglob"
+	"github.com/urfave/cli/v2"
+)
+
+// CompletionCommand is the specification of the `completion` command.
+var CompletionCommand = cli.Command{
+	Name:      "completion",
+	Usage:     "print the shell completion script for bash, zsh or fish",
+	ArgsUsage: "[bash|zsh|fish]",
+	Description: "Prints a script that enables completion of commands, flags, plan names, test cases,\n" +
+		"builders, runners and task ids. Load it from your shell profile, for example:\n\n" +
+		"   source <(testground completion bash)",
+	Action: completionCommand,
+}
+
+var completionScripts = map[string]string{
+	"bash": `_testground_complete() {
+  local cur opts
+  COMPREPLY=()
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  if [[ "$cur" == "-"* ]]; then
+    opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} ${cur} --generate-bash-completion 2>/dev/null )
+  else
+    opts=$( ${COMP_WORDS[@]:0:$COMP_CWORD} --generate-bash-completion 2>/dev/null )
+  fi
+  COMPREPLY=( $(compgen -W "${opts}" -- ${cur}) )
+  return 0
+}
+
+complete -o bashdefault -o default -o nospace -F _testground_complete testground
+`,
+	"zsh": `#compdef testground
+
+_testground_complete() {
+  local -a opts
+  local cur
+  cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(_CLI_ZSH_AUTOCOMPLETE_HACK=1 ${words[@]:0:#words[@]-1} ${cur} --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(_CLI_ZSH_AUTOCOMPLETE_HACK=1 ${words[@]:0:#words[@]-1} --generate-bash-completion 2>/dev/null)}")
+  fi
+
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+}
+
+compdef _testground_complete testground
+`,
+	"fish": `function __testground_complete
+  set -l tokens (commandline -opc)
+  set -l cur (commandline -ct)
+  if string match -q -- '-*' $cur
+    set tokens $tokens $cur
+  end
+  command $tokens[1] $tokens[2..-1] --generate-bash-completion 2>/dev/null
+end
+
+complete -c testground -f -a '(__testground_complete)'
+`,
+}
+
+func completionCommand(c *cli.Context) error {
+	shell := c.Args().First()
+	if shell == "" {
+		shell = filepath.Base(os.Getenv("SHELL"))
+	}
+
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %q; supported: bash, zsh, fish", shell)
+	}
+
+	_, err := fmt.Fprint(os.Stdout, script)
+	return err
+}
+
+// completeFlagValues completes the value of the flag being typed when the set
+// of possible values is known: plan names, test cases and test params of the
+// selected plan, builders, runners and task ids. Otherwise, it completes
+// the flags of the command.
+func completeFlagValues(c *cli.Context) {
+	var (
+		flag   = completingFlag(c)
+		values []string
+	)
+
+	switch flag {
+	case "plan":
+		values = completePlans(c)
+	case "testcase":
+		values = completeTestCases(c, c.String("plan"))
+	case "test-param":
+		values = completeTestParams(c, c.String("plan"), c.String("testcase"))
+	case "builder":
+		values = completeBuilders(c, c.String("plan"))
+	case "runner":
+		values = completeRunners(c, c.String("plan"))
+	case "task":
+		values = completeTasks(c)
+	case "":
+		cli.DefaultCompleteWithFlags(c.Command)(c)
+		return
+	default:
+		// the flag takes a value we know nothing about; let the shell fall
+		// back to its default completion.
+		return
+	}
+
+	printCompletions(c, values)
+}
+
+// completeTaskArgs completes commands that take a task id as a positional
+// argument.
+func completeTaskArgs(c *cli.Context) {
+	if completingFlag(c) != "" {
+		completeFlagValues(c)
+		return
+	}
+	printCompletions(c, completeTasks(c))
+	cli.DefaultCompleteWithFlags(c.Command)(c)
+}
+
+func printCompletions(c *cli.Context, values []string) {
+	// the zsh script uses _describe, which takes colons as the start of a
+	// description; runner and builder ids contain them.
+	zsh := os.Getenv("_CLI_ZSH_AUTOCOMPLETE_HACK") == "1"
+	for _, v := range values {
+		if zsh {
+			v = strings.ReplaceAll(v, ":", "\\:")
+		}
+		fmt.Fprintln(c.App.Writer, v)
+	}
+}
+
+// completingFlag returns the canonical name of the flag whose value is being
+// completed, or an empty string if the last argument is not a flag that
+// takes a value.
+func completingFlag(c *cli.Context) string {
+	args := os.Args
+	if n := len(args); n > 0 && args[n-1] == "--generate-bash-completion" {
+		args = args[:n-1]
+	}
+	if len(args) < 2 || c.Command == nil {
+		return ""
+	}
+
+	last := args[len(args)-1]
+	if !strings.HasPrefix(last, "-") || strings.Contains(last, "=") {
+		return ""
+	}
+	last = strings.TrimLeft(last, "-")
+
+	for _, f := range c.Command.Flags {
+		for _, name := range f.Names() {
+			if name != last {
+				continue
+			}
+			if _, ok := f.(*cli.BoolFlag); ok {
+				return ""
+			}
+			return f.Names()[0]
+		}
+	}
+	return ""
+}
+
+func completePlans(c *cli.Context) []string {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil
+	}
+
+	manifests, err := zglob.GlobFollowSymlinks(filepath.Join(cfg.Dirs().Plans(), "**", "manifest.toml"))
+	if err != nil {
+		return nil
+	}
+
+	plans := make([]string, 0, len(manifests))
+	for _, file := range manifests {
+		plan, err := filepath.Rel(cfg.Dirs().Plans(), filepath.Dir(file))
+		if err != nil {
+			continue
+		}
+		plans = append(plans, filepath.ToSlash(plan))
+	}
+	sort.Strings(plans)
+	return plans
+}
+
+// completionManifest loads the manifest of the plan, with the configuration
+// selected by --config and --profile, or returns nil if it can't be loaded.
+func completionManifest(c *cli.Context, plan string) *api.TestPlanManifest {
+	if plan == "" {
+		return nil
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil
+	}
+	_, manifest, err := resolveTestPlan(cfg, plan)
+	if err != nil {
+		return nil
+	}
+	return manifest
+}
+
+func completeTestCases(c *cli.Context, plan string) []string {
+	manifest := completionManifest(c, plan)
+	if manifest == nil {
+		return nil
+	}
+	res := make([]string, 0, len(manifest.TestCases))
+	for _, tc := range manifest.TestCases {
+		res = append(res, tc.Name)
+	}
+	return res
+}
+
+func completeTestParams(c *cli.Context, plan, testcase string) []string {
+	manifest := completionManifest(c, plan)
+	if manifest == nil {
+		return nil
+	}
+	_, tc, ok := manifest.TestCaseByName(testcase)
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(tc.Parameters))
+	for name := range tc.Parameters {
+		res = append(res, name+"=")
+	}
+	sort.Strings(res)
+	return res
+}
+
+// completeBuilders returns the builders supported by the plan if it's known,
+// or all builders otherwise.
+func completeBuilders(c *cli.Context, plan string) []string {
+	if manifest := completionManifest(c, plan); manifest != nil {
+		return sortedKeys(manifest.Builders)
+	}
+	builders := engine.DefaultRegistry().Builders()
+	res := make([]string, 0, len(builders))
+	for _, b := range builders {
+		res = append(res, b.ID())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// completeRunners returns the runners supported by the plan if it's known,
+// or all runners otherwise.
+func completeRunners(c *cli.Context, plan string) []string {
+	if manifest := completionManifest(c, plan); manifest != nil {
+		return sortedKeys(manifest.Runners)
+	}
+	runners := engine.DefaultRegistry().Runners()
+	res := make([]string, 0, len(runners))
+	for _, r := range runners {
+		res = append(res, r.ID())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// completeTasks queries the daemon for the ids of the known tasks, most
+// recent first. It gives up quickly if the daemon is unreachable.
+func completeTasks(c *cli.Context) []string {
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	r, err := cl.Tasks(ctx, &api.TasksRequest{
+		Types:  []task.Type{task.TypeBuild, task.TypeRun},
+		States: []task.State{task.StateScheduled, task.StateProcessing, task.StateComplete},
+	})
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+
+	tsks, err := client.ParseTasksRequest(r)
+	if err != nil {
+		return nil
+	}
+
+	sort.Slice(tsks, func(i, j int) bool {
+		return tsks[i].Created().After(tsks[j].Created())
+	})
+
+	res := make([]string, 0, len(tsks))
+	for _, t := range tsks {
+		res = append(res, t.ID)
+	}
+	return res
+}
+
+func sortedKeys(m map[string]config.ConfigMap) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
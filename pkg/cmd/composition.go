@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/testground/testground/pkg/api"
+
+	"github.com/urfave/cli/v2"
+)
+
+var CompositionCommand = cli.Command{
+	Name:  "composition",
+	Usage: "manage composition and test plan manifest files",
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:      "migrate",
+			Usage:     "upgrade compositions and manifests to the current schema version",
+			ArgsUsage: "[file...]",
+			Description: fmt.Sprintf("Upgrades composition and test plan manifest files to schema version %d,\n"+
+				"preserving comments and template directives. Files named manifest.toml are\n"+
+				"migrated as manifests, every other file as a composition. The migrated files\n"+
+				"are printed, unless --write is set.", api.SchemaVersion),
+			Action: compositionMigrateCmd,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "write",
+					Aliases: []string{"w"},
+					Usage:   "write the migrated files in place, instead of printing them",
+				},
+			},
+		},
+	},
+}
+
+func compositionMigrateCmd(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("expected at least one file to migrate")
+	}
+
+	for _, file := range c.Args().Slice() {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		migrate := api.MigrateComposition
+		if filepath.Base(file) == "manifest.toml" {
+			migrate = api.MigrateManifest
+		}
+
+		res, notes, err := migrate(string(src))
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", file, err)
+		}
+		// notes go to stderr, so that the migrated file can be redirected.
+		if len(notes) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: already at schema version %d\n", file, api.SchemaVersion)
+			continue
+		}
+		for _, n := range notes {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, n)
+		}
+
+		if !c.Bool("write") {
+			fmt.Print(res)
+			continue
+		}
+
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, []byte(res), fi.Mode()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/daemon"
 	"github.com/testground/testground/pkg/logging"
 
@@ -23,8 +22,8 @@ func daemonCommand(c *cli.Context) error {
 	ctx, cancel := context.WithCancel(ProcessContext())
 	defer cancel()
 
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
 
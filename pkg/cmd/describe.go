@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/config"
 )
 
@@ -21,15 +23,83 @@ var DescribeCommand = cli.Command{
 			Usage:    "describe plan with name `NAME`",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "output `FORMAT`; values: text, json",
+			Value: "text",
+		},
 	},
-	Action: describeCommand,
+	Action:       describeCommand,
+	BashComplete: completeFlagValues,
+}
+
+// planDescription is the machine-readable description of a test plan, as
+// emitted by `describe --output json`.
+type planDescription struct {
+	Name      string                      `json:"name"`
+	Path      string                      `json:"path,omitempty"`
+	Builders  map[string]config.ConfigMap `json:"builders"`
+	Runners   map[string]config.ConfigMap `json:"runners"`
+	TestCases []testCaseDescription       `json:"testcases"`
+	Sources   map[string][]string         `json:"extra_sources,omitempty"`
+}
+
+type testCaseDescription struct {
+	Name       string                          `json:"name"`
+	Instances  instancesDescription            `json:"instances"`
+	Parameters map[string]parameterDescription `json:"params"`
+}
+
+type instancesDescription struct {
+	Minimum int `json:"min"`
+	Maximum int `json:"max"`
+}
+
+type parameterDescription struct {
+	Type        string      `json:"type"`
+	Description string      `json:"desc,omitempty"`
+	Unit        string      `json:"unit,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Allowed     []string    `json:"allowed,omitempty"`
+}
+
+func describePlan(manifest *api.TestPlanManifest) *planDescription {
+	d := &planDescription{
+		Name:      manifest.Name,
+		Builders:  manifest.Builders,
+		Runners:   manifest.Runners,
+		TestCases: make([]testCaseDescription, 0, len(manifest.TestCases)),
+		Sources:   manifest.ExtraSources,
+	}
+
+	for _, tc := range manifest.TestCases {
+		tcd := testCaseDescription{
+			Name:       tc.Name,
+			Instances:  instancesDescription{Minimum: tc.Instances.Minimum, Maximum: tc.Instances.Maximum},
+			Parameters: make(map[string]parameterDescription, len(tc.Parameters)),
+		}
+		for n, p := range tc.Parameters {
+			tcd.Parameters[n] = parameterDescription{
+				Type:        p.Type,
+				Description: p.Description,
+				Unit:        p.Unit,
+				Default:     p.Default,
+				Required:    p.Required,
+				Allowed:     p.Allowed,
+			}
+		}
+		d.TestCases = append(d.TestCases, tcd)
+	}
+
+	return d
 }
 
 func describeCommand(c *cli.Context) error {
 	plan := c.String("plan")
 
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
 
@@ -38,6 +108,16 @@ func describeCommand(c *cli.Context) error {
 		return err
 	}
 
+	switch output := c.String("output"); output {
+	case "text":
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(describePlan(manifest))
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+
 	cases := manifest.TestCases
 
 	manifest.Describe(os.Stdout)
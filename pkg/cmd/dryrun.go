@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/engine"
+
+	"github.com/urfave/cli/v2"
+)
+
+// dryRun resolves the composition the way the daemon would: it expands the
+// sweep and the suite, applies the defaults of the manifest, and validates the result
+// against the manifest and the runner. It then prints what would be built and
+// run, without submitting anything to the daemon.
+func dryRun(c *cli.Context, comp *api.Composition) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	_, manifest, err := resolveTestPlan(cfg, comp.Global.Plan)
+	if err != nil {
+		return fmt.Errorf("failed to resolve test plan: %w", err)
+	}
+
+	sweep, err := comp.ExpandSweep()
+	if err != nil {
+		return fmt.Errorf("invalid sweep: %w", err)
+	}
+
+	// every case of a suite is a run of its own.
+	var comps []*api.Composition
+	for _, cc := range sweep {
+		comps = append(comps, cc.ExpandSuite()...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	for i, cc := range comps {
+		// figure out which groups need to be built before preparing the
+		// composition, which fills in global defaults.
+		build := make([]bool, len(cc.Groups))
+		for j, grp := range cc.Groups {
+			build[j] = grp.Run.Artifact == "" || c.Bool("ignore-artifacts")
+		}
+
+		resolved, err := resolveForRun(cc, manifest)
+		if err != nil {
+			return err
+		}
+
+		if len(comps) > 1 {
+			fmt.Fprintf(w, "run %d of %d\n", i+1, len(comps))
+		}
+		printExecutionPlan(w, resolved, build)
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+// resolveForRun resolves the composition like the daemon does, with the
+// builders and runners built into testground. Those served by plugins are
+// only known to the daemon; the checks are skipped if the composition uses
+// any of them.
+func resolveForRun(comp *api.Composition, manifest *api.TestPlanManifest) (*api.Composition, error) {
+	reg := engine.DefaultRegistry()
+
+	unknown := ""
+	if _, ok := reg.Runner(comp.Global.Runner); !ok {
+		unknown = "runner " + comp.Global.Runner
+	}
+	for _, id := range comp.ListBuilders() {
+		if _, ok := reg.Builder(id); !ok && unknown == "" {
+			unknown = "builder " + id
+		}
+	}
+
+	if unknown != "" {
+		fmt.Fprintf(os.Stderr, "%s is not built in; skipping the builder and runner checks\n", unknown)
+		return engine.ResolveRun(comp, manifest, nil, nil)
+	}
+	return engine.ResolveRun(comp, manifest, reg.Builder, reg.Runner)
+}
+
+func printExecutionPlan(w io.Writer, comp *api.Composition, build []bool) {
+	builds := make(map[string]struct{})
+	for i, grp := range comp.Groups {
+		if build[i] {
+			builds[grp.BuildKey()] = struct{}{}
+		}
+	}
+
+	fmt.Fprintf(w, "plan:\t%s\n", comp.Global.Plan)
+	fmt.Fprintf(w, "case:\t%s\n", comp.Global.Case)
+	fmt.Fprintf(w, "runner:\t%s\n", comp.Global.Runner)
+	fmt.Fprintf(w, "run config:\t%s\n", formatMap(comp.Global.RunConfig))
+	fmt.Fprintf(w, "total instances:\t%d\n", comp.Global.TotalInstances)
+	if t := comp.RunTimeout(); t > 0 {
+		fmt.Fprintf(w, "run timeout:\t%s\n", t)
+	}
+	if r := comp.Global.Retries; r != nil {
+		fmt.Fprintf(w, "retries:\tattempts=%d on=%s\n", r.Attempts, strings.Join(r.On, ","))
+	}
+	fmt.Fprintf(w, "builds:\t%d\n", len(builds))
+
+	for i, grp := range comp.Groups {
+		fmt.Fprintf(w, "group:\t%s\n", grp.ID)
+		fmt.Fprintf(w, "  instances:\t%d\n", grp.CalculatedInstanceCount())
+		if a := grp.StartAfter; a != nil {
+			fmt.Fprintf(w, "  start after:\tgroup=%s state=%s delay=%s\n", a.Group, a.State, a.Delay)
+		}
+		if grp.Timeout != "" {
+			fmt.Fprintf(w, "  timeout:\t%s\n", grp.TimeoutDuration())
+		}
+		if build[i] {
+			fmt.Fprintf(w, "  build:\twith %s\n", grp.Builder)
+			fmt.Fprintf(w, "  build config:\t%s\n", formatMap(grp.BuildConfig))
+			if len(grp.Build.Selectors) > 0 {
+				fmt.Fprintf(w, "  selectors:\t%s\n", strings.Join(grp.Build.Selectors, ", "))
+			}
+			for _, d := range grp.Build.Dependencies {
+				fmt.Fprintf(w, "  dependency:\t%s => %s@%s\n", d.Module, d.Target, d.Version)
+			}
+		} else {
+			fmt.Fprintf(w, "  artifact:\t%s\n", grp.Run.Artifact)
+		}
+
+		fmt.Fprintf(w, "  run config:\t%s\n", formatMap(grp.RunConfig))
+		if r := grp.Resources; !r.IsZero() {
+			res := make(map[string]interface{})
+			for k, v := range map[string]string{"cpu": r.CPU, "memory": r.Memory, "disk": r.Disk} {
+				if v != "" {
+					res[k] = v
+				}
+			}
+			fmt.Fprintf(w, "  resources:\t%s\n", formatMap(res))
+		}
+
+		params := make(map[string]interface{}, len(grp.Run.TestParams))
+		for k, v := range grp.Run.TestParams {
+			params[k] = v
+		}
+		fmt.Fprintf(w, "  test params:\t%s\n", formatMap(params))
+	}
+}
+
+// formatMap prints the entries of a map sorted by key, or "none".
+func formatMap(m map[string]interface{}) string {
+	if len(m) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%v", k, m[k]))
+	}
+	return strings.Join(entries, " ")
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/urfave/cli/v2"
@@ -11,18 +12,23 @@ import (
 )
 
 var HealthcheckCommand = cli.Command{
-	Name:   "healthcheck",
-	Usage:  "validate/fix the preconditions for the runner to be able to operate properly",
-	Action: healthcheckCommand,
+	Name:         "healthcheck",
+	Usage:        "validate/fix the preconditions for the runner or builder to be able to operate properly",
+	ArgsUsage:    "[runner]",
+	Action:       healthcheckCommand,
+	BashComplete: completeFlagValues,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "fix",
 			Usage: "attempt to fix failing preconditions",
 		},
 		&cli.StringFlag{
-			Name:     "runner",
-			Usage:    "specifies the runner to use; values include: 'local:exec', 'local:docker', 'cluster:k8s'",
-			Required: true,
+			Name:  "runner",
+			Usage: "specifies the runner to check; can also be passed as the first argument; values include: 'local:exec', 'local:docker', 'local:podman', 'local:firecracker', 'cluster:k8s', 'cluster:nomad', 'cluster:ssh'",
+		},
+		&cli.StringFlag{
+			Name:  "builder",
+			Usage: "specifies the builder to check; values include: 'docker:go', 'exec:go', 'docker:generic', 'docker:node', 'exec:rust', 'docker:rust'",
 		},
 	},
 }
@@ -32,18 +38,31 @@ func healthcheckCommand(c *cli.Context) error {
 	defer cancel()
 
 	var (
-		runner = c.String("runner")
-		fix    = c.Bool("fix")
+		runner  = c.String("runner")
+		builder = c.String("builder")
+		fix     = c.Bool("fix")
 	)
 
+	if runner == "" {
+		runner = c.Args().First()
+	}
+
+	switch {
+	case runner != "" && builder != "":
+		return errors.New("cannot check a runner and a builder at the same time; please do one at a time")
+	case runner == "" && builder == "":
+		return errors.New("a runner or a builder to check must be supplied")
+	}
+
 	cl, _, err := setupClient(c)
 	if err != nil {
 		return err
 	}
 
 	r, err := cl.Healthcheck(ctx, &api.HealthcheckRequest{
-		Runner: runner,
-		Fix:    fix,
+		Runner:  runner,
+		Builder: builder,
+		Fix:     fix,
 	})
 	if err != nil {
 		return err
@@ -55,8 +74,19 @@ func healthcheckCommand(c *cli.Context) error {
 		return err
 	}
 
-	fmt.Printf("finished checking runner %s\n", runner)
+	if runner != "" {
+		fmt.Printf("finished checking runner %s\n", runner)
+	} else {
+		fmt.Printf("finished checking builder %s\n", builder)
+	}
 	fmt.Println(resp.String())
 
-	return nil
+	switch {
+	case resp.Healthy():
+		return nil
+	case fix:
+		return cli.Exit("some checks failed and could not be fixed", 1)
+	default:
+		return cli.Exit("some checks failed; run with --fix to attempt to fix them", 1)
+	}
 }
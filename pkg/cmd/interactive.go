@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// promptTestParams prompts for the value of every required test parameter
+// that is neither set in the composition nor defaulted in the manifest, and
+// sets it on the group that lacks it. Values are validated against the
+// manifest, and prompted for again if invalid. For a suite, it prompts for
+// the parameters of all its test cases.
+func promptTestParams(in io.Reader, out io.Writer, comp *api.Composition, manifest *api.TestPlanManifest) error {
+	cases := comp.Global.Cases
+	if len(cases) == 0 {
+		cases = []string{comp.Global.Case}
+	}
+
+	r := bufio.NewReader(in)
+	for _, name := range cases {
+		_, tcase, ok := manifest.TestCaseByName(name)
+		if !ok {
+			return fmt.Errorf("test case %s not found in plan %s", name, manifest.Name)
+		}
+		if err := promptCaseTestParams(r, out, comp, tcase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func promptCaseTestParams(r *bufio.Reader, out io.Writer, comp *api.Composition, tcase *api.TestCase) error {
+
+	names := make([]string, 0, len(tcase.Parameters))
+	for n, p := range tcase.Parameters {
+		if p.Required && p.Default == nil {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	var global map[string]string
+	if comp.Global.Run != nil {
+		global = comp.Global.Run.TestParams
+	}
+
+	for _, grp := range comp.Groups {
+		for _, n := range names {
+			if _, ok := grp.Run.TestParams[n]; ok {
+				continue
+			}
+			if _, ok := global[n]; ok {
+				continue
+			}
+			if _, ok := comp.Sweep[n]; ok {
+				continue
+			}
+
+			v, err := promptTestParam(r, out, grp.ID, n, tcase.Parameters[n])
+			if err != nil {
+				return err
+			}
+			if grp.Run.TestParams == nil {
+				grp.Run.TestParams = make(map[string]string)
+			}
+			grp.Run.TestParams[n] = v
+		}
+	}
+	return nil
+}
+
+func promptTestParam(r *bufio.Reader, out io.Writer, group, name string, p api.Parameter) (string, error) {
+	prompt := fmt.Sprintf("[group %s] %s (%s)", group, name, p.Type)
+	if p.Description != "" {
+		prompt += ": " + p.Description
+	}
+	if len(p.Allowed) > 0 {
+		prompt += fmt.Sprintf(" [allowed: %s]", strings.Join(p.Allowed, ", "))
+	}
+
+	for {
+		fmt.Fprintf(out, "%s\n> ", prompt)
+
+		line, err := r.ReadString('\n')
+		v := strings.TrimSpace(line)
+		switch {
+		case v != "":
+			if verr := p.Validate(v); verr != nil {
+				fmt.Fprintf(out, "invalid value: %s\n", verr)
+				if err != nil {
+					return "", fmt.Errorf("no valid value supplied for test parameter %s", name)
+				}
+				continue
+			}
+			return v, nil
+		case err == io.EOF:
+			return "", fmt.Errorf("no value supplied for required test parameter %s", name)
+		case err != nil:
+			return "", err
+		}
+	}
+}
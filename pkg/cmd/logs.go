@@ -10,9 +10,10 @@ import (
 )
 
 var LogsCommand = cli.Command{
-	Name:   "logs",
-	Usage:  "get the current status for a certain task",
-	Action: logsCommand,
+	Name:         "logs",
+	Usage:        "get the logs for a certain task",
+	Action:       logsCommand,
+	BashComplete: completeFlagValues,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:     "task",
@@ -46,7 +47,7 @@ func logsCommand(c *cli.Context) error {
 	}
 	defer r.Close()
 
-	tsk, err := client.ParseLogsRequest(os.Stdout, r)
+	tsk, err := client.ParseLogsResponse(os.Stdout, r)
 	if err != nil {
 		return err
 	}
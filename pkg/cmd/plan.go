@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,13 +9,11 @@ import (
 	"text/tabwriter"
 	"text/template"
 
-	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/logging"
 
 	ttmpl "github.com/testground/plan-templates/templates"
 
-	"github.com/BurntSushi/toml"
 	"github.com/go-git/go-git/v5"
 	gitcfg "github.com/go-git/go-git/v5/config"
 	"github.com/mattn/go-zglob"
@@ -96,7 +95,8 @@ var PlanCommand = cli.Command{
 					Required: true,
 				},
 			},
-			Action: rmCommand,
+			Action:       rmCommand,
+			BashComplete: completeFlagValues,
 		},
 		&cli.Command{
 			Name:   "list",
@@ -107,6 +107,11 @@ var PlanCommand = cli.Command{
 					Name:  "testcases",
 					Usage: "display testcases",
 				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "output `FORMAT`; values: text, json",
+					Value: "text",
+				},
 			},
 		},
 	},
@@ -120,8 +125,8 @@ type templateVars struct {
 }
 
 func createCommand(c *cli.Context) error {
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
 
@@ -133,6 +138,21 @@ func createCommand(c *cli.Context) error {
 	)
 
 	pdir := filepath.Join(cfg.Dirs().Plans(), planName)
+	if _, err := os.Stat(pdir); !os.IsNotExist(err) {
+		return fmt.Errorf("plan directory already exists: %s", pdir)
+	}
+
+	// Get file templates for the supplied target lang
+	assetPath := fmt.Sprintf("/%s-templates", targetLang)
+	var tset ttmpl.TemplateSet
+	if err := ttmpl.Fill(assetPath, &tset); err != nil {
+		return err
+	}
+
+	if tset == nil {
+		return fmt.Errorf("unknown language target %s", targetLang)
+	}
+
 	repo, err := git.PlainInit(pdir, false)
 	if err != nil {
 		return err
@@ -148,18 +168,6 @@ func createCommand(c *cli.Context) error {
 		}
 	}
 
-	// Get file templates for the supplied target lang
-	assetPath := fmt.Sprintf("/%s-templates", targetLang)
-	var tset ttmpl.TemplateSet
-	err = ttmpl.Fill(assetPath, &tset)
-	if err != nil {
-		return err
-	}
-
-	if tset == nil {
-		return fmt.Errorf("unknown language target %s", targetLang)
-	}
-
 	for _, ts := range tset {
 		tmpl, err := template.New(ts.Filename).Parse(ts.Template)
 		if err != nil {
@@ -180,8 +188,8 @@ func createCommand(c *cli.Context) error {
 }
 
 func importCommand(c *cli.Context) error {
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
 
@@ -226,12 +234,21 @@ func importCommand(c *cli.Context) error {
 		importer = symlinkPlan
 	}
 
-	err = importer(dstPath, from)
-	if err == nil {
-		fmt.Println("imported plans:")
-		_ = printPlans(cfg, dstPath, true)
+	if err = importer(dstPath, from); err != nil {
+		return err
+	}
+
+	manifests, err := zglob.GlobFollowSymlinks(filepath.Join(dstPath, "**", "manifest.toml"))
+	if err != nil {
+		return fmt.Errorf("failed to discover test plans under %s: %w", dstPath, err)
+	}
+	if len(manifests) == 0 {
+		logging.S().Warnw("no manifest.toml found in the imported directory; it will not be usable as a test plan", "path", dstPath)
+		return nil
 	}
-	return err
+
+	fmt.Println("imported plans:")
+	return printPlans(cfg, dstPath, true)
 }
 
 func symlinkPlan(dst, src string) error {
@@ -273,8 +290,8 @@ this is the error message I received:
 }
 
 func rmCommand(c *cli.Context) error {
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
 
@@ -293,12 +310,45 @@ func rmCommand(c *cli.Context) error {
 }
 
 func listCommand(c *cli.Context) error {
-	cfg := &config.EnvConfig{}
-	if err := cfg.Load(); err != nil {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
-	return printPlans(cfg, cfg.Dirs().Plans(), c.Bool("testcases"))
+	switch output := c.String("output"); output {
+	case "text":
+		return printPlans(cfg, cfg.Dirs().Plans(), c.Bool("testcases"))
+	case "json":
+		return printPlansJSON(cfg, cfg.Dirs().Plans())
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+
+}
+
+// printPlansJSON prints the full description of every plan found under
+// rootDir as a JSON array.
+func printPlansJSON(cfg *config.EnvConfig, rootDir string) error {
+	manifests, err := zglob.GlobFollowSymlinks(filepath.Join(rootDir, "**", "manifest.toml"))
+	if err != nil {
+		return fmt.Errorf("failed to discover test plans under %s: %w", cfg.Dirs().Plans(), err)
+	}
 
+	descs := make([]*planDescription, 0, len(manifests))
+	for _, file := range manifests {
+		manifest, err := loadManifest(file)
+		if err != nil {
+			return fmt.Errorf("failed to process manifest file at %s: %w", file, err)
+		}
+		d := describePlan(manifest)
+		if d.Path, err = filepath.Rel(cfg.Dirs().Plans(), filepath.Dir(file)); err != nil {
+			return fmt.Errorf("failed to relativize plan directory %s: %w", filepath.Dir(file), err)
+		}
+		descs = append(descs, d)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(descs)
 }
 
 func printPlans(cfg *config.EnvConfig, rootDir string, testcases bool) error {
@@ -318,8 +368,8 @@ func printPlans(cfg *config.EnvConfig, rootDir string, testcases bool) error {
 			return fmt.Errorf("failed to relativize plan directory %s: %w", dir, err)
 		}
 
-		var manifest api.TestPlanManifest
-		if _, err = toml.DecodeFile(file, &manifest); err != nil {
+		manifest, err := loadManifest(file)
+		if err != nil {
 			return fmt.Errorf("failed to process manifest file at %s: %w", file, err)
 		}
 
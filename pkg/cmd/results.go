@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/data"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/tmpl"
+
+	"github.com/gorilla/mux"
+	"github.com/urfave/cli/v2"
+)
+
+// ResultsCommand is the specification of the `results` command.
+var ResultsCommand = cli.Command{
+	Name:  "results",
+	Usage: "browse the outputs of runs collected with 'collect --dir'",
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:   "serve",
+			Usage:  "start a local web UI to browse runs, view instance logs, plot metrics and download assets",
+			Action: resultsServeCommand,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "the `DIR` outputs were collected into with 'collect --dir'",
+					Value: ".",
+				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "the `ADDRESS` to listen on",
+					Value: "localhost:8043",
+				},
+			},
+		},
+	},
+}
+
+func resultsServeCommand(c *cli.Context) error {
+	dir, err := filepath.Abs(c.String("dir"))
+	if err != nil {
+		return err
+	}
+	if !isDirectory(dir) {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+
+	content, err := tmpl.HtmlTemplates.ReadFile("results.html")
+	if err != nil {
+		return fmt.Errorf("cannot find template file: %w", err)
+	}
+	t, err := template.New("results.html").Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("cannot parse template file: %w", err)
+	}
+
+	rs := &resultsServer{dir: dir, tmpl: t}
+
+	r := mux.NewRouter().StrictSlash(true)
+	r.HandleFunc("/", rs.runsHandler).Methods("GET")
+	r.HandleFunc("/runs/{id}", rs.runHandler).Methods("GET")
+	r.HandleFunc("/runs/{id}/metrics", rs.metricsHandler).Methods("GET")
+	r.HandleFunc("/runs/{id}/files/{path:.+}", rs.fileHandler).Methods("GET")
+
+	logging.S().Infow("serving results", "dir", dir, "url", "http://"+c.String("listen"))
+
+	srv := &http.Server{Addr: c.String("listen"), Handler: r}
+	go func() {
+		<-ProcessContext().Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+type resultsServer struct {
+	dir  string
+	tmpl *template.Template
+}
+
+func (rs *resultsServer) render(w http.ResponseWriter, name string, data interface{}) {
+	if err := rs.tmpl.ExecuteTemplate(w, name, data); err != nil {
+		logging.S().Warnw("failed to render page", "page", name, "err", err)
+	}
+}
+
+func (rs *resultsServer) runOutputs(w http.ResponseWriter, r *http.Request) (*data.RunOutputs, bool) {
+	ro, err := data.ReadRunOutputs(rs.dir, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return ro, true
+}
+
+func (rs *resultsServer) runsHandler(w http.ResponseWriter, r *http.Request) {
+	runs, err := data.ListRunOutputs(rs.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rs.render(w, "runs", struct {
+		Dir  string
+		Runs []*data.RunOutputs
+	}{rs.dir, runs})
+}
+
+func (rs *resultsServer) runHandler(w http.ResponseWriter, r *http.Request) {
+	ro, ok := rs.runOutputs(w, r)
+	if !ok {
+		return
+	}
+
+	var composition string
+	if ro.HasComposition {
+		b, err := ioutil.ReadFile(filepath.Join(ro.Dir, "composition.toml"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		composition = string(b)
+	}
+
+	chaos, err := ro.ChaosTimeline()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rs.render(w, "run", struct {
+		Run         *data.RunOutputs
+		Composition string
+		Chaos       []*data.ChaosEntry
+	}{ro, composition, chaos})
+}
+
+func (rs *resultsServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	ro, ok := rs.runOutputs(w, r)
+	if !ok {
+		return
+	}
+
+	series, err := ro.MetricSeries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rs.render(w, "metrics", struct {
+		Run    *data.RunOutputs
+		Charts []*chart
+	}{ro, newCharts(series)})
+}
+
+func (rs *resultsServer) fileHandler(w http.ResponseWriter, r *http.Request) {
+	ro, ok := rs.runOutputs(w, r)
+	if !ok {
+		return
+	}
+
+	rel := filepath.FromSlash(mux.Vars(r)["path"])
+	path := filepath.Join(ro.Dir, rel)
+	if !strings.HasPrefix(path, ro.Dir+string(filepath.Separator)) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	} else if ext := filepath.Ext(path); ext == ".out" || ext == ".err" || ext == ".toml" || ext == ".log" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	http.ServeFile(w, r, path)
+}
+
+const (
+	chartWidth  = 720
+	chartHeight = 240
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// chart is a line chart of a measure of a metric, with a line per instance.
+type chart struct {
+	Title         string
+	Width, Height int
+	Min, Max      float64
+	Start, End    time.Time
+	Lines         []chartLine
+}
+
+type chartLine struct {
+	Label  string
+	Color  string
+	Points string
+}
+
+// newCharts returns a chart for every measure of every metric in the
+// series, which must be sorted by metric and measure.
+func newCharts(series []*data.MetricSeries) []*chart {
+	var (
+		res  []*chart
+		from int
+	)
+	for i := range series {
+		if i+1 < len(series) && series[i+1].Metric == series[i].Metric && series[i+1].Measure == series[i].Measure {
+			continue
+		}
+		res = append(res, newChart(series[from:i+1]))
+		from = i + 1
+	}
+	return res
+}
+
+func newChart(series []*data.MetricSeries) *chart {
+	c := &chart{
+		Title:  series[0].Metric + " (" + series[0].Measure + ")",
+		Width:  chartWidth,
+		Height: chartHeight,
+		Min:    math.Inf(1),
+		Max:    math.Inf(-1),
+	}
+
+	for _, s := range series {
+		for _, p := range s.Points {
+			c.Min, c.Max = math.Min(c.Min, p.Value), math.Max(c.Max, p.Value)
+			if c.Start.IsZero() || p.Time.Before(c.Start) {
+				c.Start = p.Time
+			}
+			if p.Time.After(c.End) {
+				c.End = p.Time
+			}
+		}
+	}
+
+	span, rng := c.End.Sub(c.Start).Seconds(), c.Max-c.Min
+	for i, s := range series {
+		var b strings.Builder
+		for _, p := range s.Points {
+			x, y := 0.0, float64(c.Height)/2
+			if span > 0 {
+				x = p.Time.Sub(c.Start).Seconds() / span * float64(c.Width)
+			}
+			if rng > 0 {
+				y = float64(c.Height) - (p.Value-c.Min)/rng*float64(c.Height)
+			}
+			fmt.Fprintf(&b, "%.1f,%.1f ", x, y)
+		}
+		c.Lines = append(c.Lines, chartLine{
+			Label:  s.Group + "/" + s.Instance,
+			Color:  chartColors[i%len(chartColors)],
+			Points: strings.TrimSpace(b.String()),
+		})
+	}
+	return c
+}
@@ -15,12 +15,20 @@ var RootCommands = cli.CommandsByName{
 	&SidecarCommand,
 	&DaemonCommand,
 	&CollectCommand,
+	&CleanupCommand,
 	&TerminateCommand,
 	&HealthcheckCommand,
 	&TasksCommand,
 	&StatusCommand,
 	&LogsCommand,
 	&VersionCommand,
+	&WaitCommand,
+	&CompareCommand,
+	&CompletionCommand,
+	&ResultsCommand,
+	&RunsCommand,
+	&ScheduleCommand,
+	&CompositionCommand,
 }
 
 func init() {
@@ -40,4 +48,13 @@ var RootFlags = []cli.Flag{
 		Name:  "endpoint",
 		Usage: "set the daemon endpoint `URI` (overrides .env.toml)",
 	},
+	&cli.StringFlag{
+		Name:  "config",
+		Usage: "load the configuration from `FILE` instead of $TESTGROUND_HOME/.env.toml",
+	},
+	&cli.StringFlag{
+		Name:    "profile",
+		Usage:   "use the client settings of the profile with `NAME`, defined in the [profiles] table of .env.toml",
+		EnvVars: []string{"TESTGROUND_PROFILE"},
+	},
 }
@@ -1,23 +1,23 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/data"
 	"github.com/testground/testground/pkg/logging"
 
 	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/go-multierror"
 	"github.com/urfave/cli/v2"
 )
 
@@ -27,10 +27,11 @@ var RunCommand = cli.Command{
 	Usage: "request the daemon to (build and) run a test case",
 	Subcommands: cli.Commands{
 		&cli.Command{
-			Name:    "composition",
-			Aliases: []string{"c"},
-			Usage:   "(build and) run a composition",
-			Action:  runCompositionCmd,
+			Name:         "composition",
+			Aliases:      []string{"c"},
+			Usage:        "(build and) run a composition",
+			Action:       runCompositionCmd,
+			BashComplete: completeFlagValues,
 			Flags: append(
 				BuildCommand.Subcommands[0].Flags, // inject all build single command flags.
 				&cli.BoolFlag{
@@ -47,6 +48,22 @@ var RunCommand = cli.Command{
 					Aliases: []string{"o"},
 					Usage:   "write the collection output archive to `FILENAME`",
 				},
+				&cli.BoolFlag{
+					Name:  "detach",
+					Usage: "return as soon as the run is queued; use 'testground wait' to wait for it",
+				},
+				&cli.BoolFlag{
+					Name:  "follow-instances",
+					Usage: "stream the output of the instances live, tagged with their group, index and line number, with the runners that support it; implies --wait",
+				},
+				&cli.BoolFlag{
+					Name:  "interactive",
+					Usage: "prompt for required test parameters that have not been set, instead of failing",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the resolved builds and runs, validated against the test plan manifest and runner, without submitting them",
+				},
 				&cli.StringFlag{
 					Name:  "metadata-repo",
 					Usage: "repo that triggered this run",
@@ -62,10 +79,11 @@ var RunCommand = cli.Command{
 			),
 		},
 		&cli.Command{
-			Name:    "single",
-			Aliases: []string{"s"},
-			Usage:   "(build and) run a single group",
-			Action:  runSingleCmd,
+			Name:         "single",
+			Aliases:      []string{"s"},
+			Usage:        "(build and) run a single group",
+			Action:       runSingleCmd,
+			BashComplete: completeFlagValues,
 			Flags: append(
 				BuildCommand.Subcommands[1].Flags, // inject all build single command flags.
 				&cli.BoolFlag{
@@ -77,6 +95,10 @@ var RunCommand = cli.Command{
 					Aliases: []string{"o"},
 					Usage:   "destination for the assets if --collect is set",
 				},
+				&cli.StringSliceFlag{
+					Name:  "sweep",
+					Usage: "run once per value of a test parameter, e.g. --sweep size=1,2,4; when repeated, runs the cartesian product",
+				},
 				&cli.UintFlag{
 					Name:        "instances",
 					Aliases:     []string{"i"},
@@ -85,10 +107,9 @@ var RunCommand = cli.Command{
 					DefaultText: "none",
 				},
 				&cli.StringFlag{
-					Name:     "runner",
-					Aliases:  []string{"r"},
-					Usage:    "runner to use; values include: 'local:exec', 'local:docker', 'cluster:k8s'",
-					Required: true,
+					Name:    "runner",
+					Aliases: []string{"r"},
+					Usage:   "runner to use; values include: 'local:exec', 'local:docker', 'local:podman', 'local:firecracker', 'cluster:k8s', 'cluster:nomad', 'cluster:ssh'; defaults to the runner of the profile",
 				},
 				&cli.StringSliceFlag{
 					Name:  "run-cfg",
@@ -110,6 +131,22 @@ var RunCommand = cli.Command{
 					Aliases: []string{"ub"},
 					Usage:   "build artifact to use (from a previous build)",
 				},
+				&cli.BoolFlag{
+					Name:  "detach",
+					Usage: "return as soon as the run is queued; use 'testground wait' to wait for it",
+				},
+				&cli.BoolFlag{
+					Name:  "follow-instances",
+					Usage: "stream the output of the instances live, tagged with their group, index and line number, with the runners that support it; implies --wait",
+				},
+				&cli.BoolFlag{
+					Name:  "interactive",
+					Usage: "prompt for required test parameters that have not been set, instead of failing",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the resolved builds and runs, validated against the test plan manifest and runner, without submitting them",
+				},
 				&cli.StringFlag{
 					Name:  "metadata-repo",
 					Usage: "repo that triggered this run",
@@ -131,50 +168,22 @@ var RunCommand = cli.Command{
 	},
 }
 
-type compositionData struct {
-	Env map[string]string
-}
-
 func runCompositionCmd(c *cli.Context) (err error) {
-	comp := new(api.Composition)
 	file := c.String("file")
 	if file == "" {
 		return fmt.Errorf("no composition file supplied")
 	}
 
-	fdata, err := ioutil.ReadFile(file)
+	comp, err := loadComposition(c, file)
 	if err != nil {
 		return err
 	}
 
-	data := &compositionData{Env: map[string]string{}}
-
-	// Build a map of environment variables
-	for _, v := range os.Environ() {
-		s := strings.SplitN(v, "=", 2)
-		data.Env[s[0]] = s[1]
-	}
-
-	// Parse and run the composition as a template
-	tpl, err := template.New("tpl").Parse(string(fdata))
-	if err != nil {
-		return err
-	}
-	buff := &bytes.Buffer{}
-	err = tpl.Execute(buff, data)
-	if err != nil {
-		return err
-	}
-
-	if _, err = toml.Decode(buff.String(), comp); err != nil {
-		return fmt.Errorf("failed to process composition file: %w", err)
-	}
-
 	if err = comp.ValidateForRun(); err != nil {
 		return fmt.Errorf("invalid composition file: %w", err)
 	}
 
-	err = run(c, comp)
+	err = runSweep(c, comp)
 	if err != nil {
 		return err
 	}
@@ -188,7 +197,63 @@ func runSingleCmd(c *cli.Context) (err error) {
 		return err
 	}
 	logging.S().Infof("created a synthetic composition file for this job; all instances will run under singleton group %q", comp.Groups[0].ID)
-	return run(c, comp)
+	return runSweep(c, comp)
+}
+
+// runSweep expands the sweep of the composition, if any, and runs every
+// resulting composition in turn. With --interactive, it first prompts for
+// missing required test parameters. With --dry-run, it prints what would be
+// built and run instead.
+func runSweep(c *cli.Context, comp *api.Composition) error {
+	if c.Bool("interactive") {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		_, manifest, err := resolveTestPlan(cfg, comp.Global.Plan)
+		if err != nil {
+			return fmt.Errorf("failed to resolve test plan: %w", err)
+		}
+		if err := promptTestParams(os.Stdin, os.Stdout, comp, manifest); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("dry-run") {
+		return dryRun(c, comp)
+	}
+
+	if len(comp.Sweep) == 0 {
+		return run(c, comp)
+	}
+
+	if c.String("collect-file") != "" {
+		return fmt.Errorf("--collect-file cannot be used with a sweep; outputs are collected into <run_id>.tgz")
+	}
+	if c.Bool("write-artifacts") {
+		return fmt.Errorf("--write-artifacts cannot be used with a sweep")
+	}
+
+	comps, err := comp.ExpandSweep()
+	if err != nil {
+		return fmt.Errorf("invalid sweep: %w", err)
+	}
+
+	logging.S().Infof("sweep expanded into %d runs", len(comps))
+
+	var merr *multierror.Error
+	for i, cc := range comps {
+		params := make(map[string]string, len(comp.Sweep))
+		for k := range comp.Sweep {
+			params[k] = cc.Groups[0].Run.TestParams[k]
+		}
+		logging.S().Infow("running sweep combination", "n", i+1, "of", len(comps), "params", params)
+
+		if err := run(c, cc); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
 }
 
 func run(c *cli.Context, comp *api.Composition) (err error) {
@@ -200,68 +265,28 @@ func run(c *cli.Context, comp *api.Composition) (err error) {
 	ctx, cancel := context.WithCancel(ProcessContext())
 	defer cancel()
 
-	// Resolve the test plan and its manifest.
-	planDir, manifest, err := resolveTestPlan(cfg, comp.Global.Plan)
-	if err != nil {
-		return fmt.Errorf("failed to resolve test plan: %w", err)
+	follow := c.Bool("follow-instances")
+	if follow && c.Bool("detach") {
+		return fmt.Errorf("--follow-instances and --detach are mutually exclusive")
 	}
-
-	// Check if the daemon needs to build the test plan.
-	ignore := c.Bool("ignore-artifacts")
-	var buildIdx []int
-	for i, grp := range comp.Groups {
-		if grp.Run.Artifact == "" || ignore {
-			buildIdx = append(buildIdx, i)
-		}
+	wait := c.Bool("wait") || follow
+	if wait && c.Bool("detach") {
+		return fmt.Errorf("--wait and --detach are mutually exclusive")
 	}
 
-	var (
-		sdkDir    string
-		extraSrcs []string
-		wait      = c.Bool("wait")
-	)
-
-	if len(buildIdx) > 0 {
-		// Resolve the linked SDK directory, if one has been supplied.
-		if sdk := c.String("link-sdk"); sdk != "" {
-			var err error
-			sdkDir, err = resolveSDK(cfg, sdk)
-			if err != nil {
-				return fmt.Errorf("failed to resolve linked SDK directory: %w", err)
-			}
-			logging.S().Infof("linking with sdk at: %s", sdkDir)
-		}
-		// if there are extra sources to include for this builder, contextualize
-		// them to the plan's dir.
-		builder := strings.Replace(comp.Global.Builder, ":", "_", -1)
-		extraSrcs = manifest.ExtraSources[builder]
-		for i, dir := range extraSrcs {
-			if !filepath.IsAbs(dir) {
-				// follow any symlinks in the plan dir.
-				evalPlanDir, err := filepath.EvalSymlinks(planDir)
-				if err != nil {
-					return fmt.Errorf("failed to follow symlinks in plan dir: %w", err)
-				}
-				extraSrcs[i] = filepath.Clean(filepath.Join(evalPlanDir, dir))
-			}
-		}
-	} else {
-		planDir = ""
+	if comp.IsSuite() && c.String("collect-file") != "" {
+		return fmt.Errorf("--collect-file cannot be used with a suite; outputs are collected into <run_id>-<n>.tgz")
 	}
 
-	req := &api.RunRequest{
-		BuildGroups: buildIdx,
-		Composition: *comp,
-		Manifest:    *manifest,
-		CreatedBy: api.CreatedBy{
-			User:   cfg.Client.User,
-			Repo:   c.String("metadata-repo"),
-			Branch: c.String("metadata-branch"),
-			Commit: c.String("metadata-commit"),
-		},
+	req, planDir, sdkDir, extraSrcs, err := prepareRun(c, cfg, comp)
+	if err != nil {
+		return err
 	}
 
-	if wait {
+	req.Priority = c.Int("priority")
+	req.NoCache = c.Bool("no-cache")
+	req.FollowInstances = follow
+	if wait && !c.IsSet("priority") {
 		req.Priority = 1
 	}
 
@@ -285,6 +310,7 @@ func run(c *cli.Context, comp *api.Composition) (err error) {
 	logging.S().Infof("run is queued with ID: %s", id)
 
 	if !wait {
+		logging.S().Infof("wait for the run to complete with: testground wait %s", id)
 		return nil
 	}
 
@@ -292,13 +318,14 @@ func run(c *cli.Context, comp *api.Composition) (err error) {
 		TaskID:            id,
 		Follow:            true,
 		CancelWithContext: true,
+		Instances:         follow,
 	})
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
-	tsk, err := client.ParseLogsRequest(os.Stdout, r)
+	tsk, err := client.ParseLogsResponse(os.Stdout, r)
 	if err != nil {
 		return err
 	}
@@ -326,12 +353,30 @@ func run(c *cli.Context, comp *api.Composition) (err error) {
 
 	logging.S().Infof("finished run with ID: %s", id)
 
+	result := data.DecodeRunnerResult(tsk.Result)
+	for _, a := range result.Attempts {
+		logging.S().Infow("attempt failed and was retried", "class", a.Class, "error", a.Error, "took", a.Ended.Sub(a.Started).Truncate(time.Second))
+	}
+	for _, co := range result.Cases {
+		logging.S().Infow("suite case finished", "case", co.Case, "run_id", co.RunID, "outcome", co.Outcome)
+	}
+
 	// if the `collect` flag is not set, we are done
 	collectOpt := c.Bool("collect")
 	if !collectOpt {
 		return data.IsTaskOutcomeInError(&tsk)
 	}
 
+	// the cases of a suite are collected one by one.
+	if len(result.Cases) > 0 {
+		for _, co := range result.Cases {
+			if err := collect(ctx, cl, comp.Global.Runner, co.RunID, co.RunID+".tgz"); err != nil {
+				return cli.Exit(err.Error(), 3)
+			}
+		}
+		return data.IsTaskOutcomeInError(&tsk)
+	}
+
 	collectFile := c.String("collect-file")
 	if collectFile == "" {
 		collectFile = fmt.Sprintf("%s.tgz", id)
@@ -345,3 +390,74 @@ func run(c *cli.Context, comp *api.Composition) (err error) {
 
 	return data.IsTaskOutcomeInError(&tsk)
 }
+
+// prepareRun resolves the test plan of the composition, and what the daemon
+// needs to build it, if any group needs a build, and returns the request of
+// the run, along with the directories to send with it.
+func prepareRun(c *cli.Context, cfg *config.EnvConfig, comp *api.Composition) (req *api.RunRequest, planDir, sdkDir string, extraSrcs []string, err error) {
+	// Resolve the test plan and its manifest.
+	planDir, manifest, err := resolveTestPlan(cfg, comp.Global.Plan)
+	if err != nil {
+		return nil, "", "", nil, fmt.Errorf("failed to resolve test plan: %w", err)
+	}
+
+	// Check if the daemon needs to build the test plan.
+	ignore := c.Bool("ignore-artifacts")
+	var buildIdx []int
+	for i, grp := range comp.Groups {
+		if grp.Run.Artifact == "" || ignore {
+			buildIdx = append(buildIdx, i)
+		}
+	}
+
+	if len(buildIdx) > 0 {
+		if err := applyBuildModes(c, comp); err != nil {
+			return nil, "", "", nil, err
+		}
+
+		// Resolve the linked SDK directory, if one has been supplied.
+		if sdk := c.String("link-sdk"); sdk != "" {
+			sdkDir, err = resolveSDK(cfg, sdk)
+			if err != nil {
+				return nil, "", "", nil, fmt.Errorf("failed to resolve linked SDK directory: %w", err)
+			}
+			logging.S().Infof("linking with sdk at: %s", sdkDir)
+		}
+		// if there are extra sources to include for this builder, contextualize
+		// them to the plan's dir.
+		builder := strings.Replace(comp.Global.Builder, ":", "_", -1)
+		extraSrcs = manifest.ExtraSources[builder]
+		for i, dir := range extraSrcs {
+			if !filepath.IsAbs(dir) {
+				// follow any symlinks in the plan dir.
+				evalPlanDir, err := filepath.EvalSymlinks(planDir)
+				if err != nil {
+					return nil, "", "", nil, fmt.Errorf("failed to follow symlinks in plan dir: %w", err)
+				}
+				extraSrcs[i] = filepath.Clean(filepath.Join(evalPlanDir, dir))
+			}
+		}
+		extraSrcs, err = shipLocalDependencies(comp, extraSrcs)
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+	} else {
+		planDir = ""
+	}
+
+	req = &api.RunRequest{
+		BuildGroups: buildIdx,
+		Composition: *comp,
+		Manifest:    *manifest,
+		CreatedBy: api.CreatedBy{
+			User:   cfg.Client.User,
+			Repo:   c.String("metadata-repo"),
+			Branch: c.String("metadata-branch"),
+			Commit: c.String("metadata-commit"),
+		},
+	}
+	if planDir != "" {
+		req.SourceCommit = sourceCommit(planDir)
+	}
+	return req, planDir, sdkDir, extraSrcs, nil
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/urfave/cli/v2"
+)
+
+// RunsCommand is the specification of the `runs` command.
+var RunsCommand = cli.Command{
+	Name:  "runs",
+	Usage: "query the history of the completed tasks, kept by the daemon",
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:         "list",
+			Usage:        "list the completed tasks, oldest first",
+			Action:       runsListCommand,
+			BashComplete: completeFlagValues,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "plan",
+					Aliases: []string{"p"},
+					Usage:   "only list tasks for the test plan with `NAME`",
+				},
+				&cli.StringFlag{
+					Name:    "testcase",
+					Aliases: []string{"t"},
+					Usage:   "only list tasks for the test case with `NAME`",
+				},
+				&cli.StringFlag{
+					Name:  "type",
+					Usage: "only list tasks of `TYPE`; values: build, run",
+				},
+				&cli.StringFlag{
+					Name:  "since",
+					Usage: "only list tasks that ended since `WHEN`; either a duration, e.g. 24h, or a date, e.g. 2021-06-01 or 2021-06-01T15:04:05Z",
+				},
+				&cli.StringFlag{
+					Name:  "schedule",
+					Usage: "only list tasks queued by the schedule with `ID`",
+				},
+				&cli.IntFlag{
+					Name:  "limit",
+					Usage: "only list the `N` most recent tasks; 0 lists them all",
+					Value: 20,
+				},
+			},
+		},
+	},
+}
+
+func runsListCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	req := &api.RunsRequest{
+		TestPlan: c.String("plan"),
+		TestCase: c.String("testcase"),
+		Schedule: c.String("schedule"),
+		Limit:    c.Int("limit"),
+	}
+
+	switch tp := task.Type(c.String("type")); tp {
+	case "", task.TypeBuild, task.TypeRun:
+		req.Type = tp
+	default:
+		return fmt.Errorf("unknown task type: %s", tp)
+	}
+
+	if v := c.String("since"); v != "" {
+		since, err := parseSince(v)
+		if err != nil {
+			return err
+		}
+		req.Since = &since
+	}
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.Runs(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	recs, err := client.ParseRunsResponse(r)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tENDED\tTEST PLAN\tTEST CASE\tTYPE\tRUNNER\tDURATION\tOUTCOME\tSUMMARY")
+
+	for _, rec := range recs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.ID, rec.Ended.String(), rec.Plan, rec.Case, rec.Type, rec.Runner, rec.Took(), rec.Outcome, rec.Summary)
+	}
+
+	return w.Flush()
+}
+
+// parseSince parses a duration before now, or a date.
+func parseSince(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q; expected a duration, e.g. 24h, or a date, e.g. 2021-06-01", v)
+}
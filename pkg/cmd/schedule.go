@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/cron"
+	"github.com/testground/testground/pkg/logging"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ScheduleCommand is the specification of the `schedule` command.
+var ScheduleCommand = cli.Command{
+	Name:  "schedule",
+	Usage: "manage the recurring runs the daemon queues on a schedule",
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:         "create",
+			Usage:        "schedule recurring runs of a composition, e.g. --cron \"0 2 * * *\" to run it every night at 2:00; occurrences missed while the daemon is down are skipped",
+			Action:       scheduleCreateCommand,
+			BashComplete: completeFlagValues,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "cron",
+					Usage:    "cron `EXPRESSION` of the schedule, in the time zone of the daemon: minute, hour, day of month, month and day of week, or a descriptor like @daily",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "file",
+					Aliases:  []string{"f"},
+					Usage:    "path to a `COMPOSITION`",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:    "ignore-artifacts",
+					Aliases: []string{"i"},
+					Usage:   "ignore any build artifacts present in the composition file, and build the test plan for every run",
+				},
+				&cli.StringFlag{
+					Name:  "link-sdk",
+					Usage: linkSdkUsage,
+				},
+				&cli.IntFlag{
+					Name:  "priority",
+					Usage: "scheduling priority of the runs; the daemon may refuse priorities above its maximum",
+				},
+				insecureIncludesFlag,
+				&cli.StringSliceFlag{
+					Name:  "set",
+					Usage: "set a composition template value, available as {{.Values.<key>}}; overrides --values",
+				},
+				&cli.StringFlag{
+					Name:  "values",
+					Usage: "load composition template values from the TOML `FILE`",
+				},
+				&cli.StringFlag{
+					Name:  "metadata-repo",
+					Usage: "repo of the scheduled runs",
+				},
+				&cli.StringFlag{
+					Name:  "metadata-branch",
+					Usage: "branch of the scheduled runs",
+				},
+				&cli.StringFlag{
+					Name:  "metadata-commit",
+					Usage: "commit of the scheduled runs",
+				},
+			},
+		},
+		&cli.Command{
+			Name:   "list",
+			Usage:  "list the schedules",
+			Action: scheduleListCommand,
+		},
+		&cli.Command{
+			Name:      "delete",
+			Usage:     "delete a schedule; the runs it queued already are left alone",
+			ArgsUsage: "[schedule_id]",
+			Action:    scheduleDeleteCommand,
+		},
+	},
+}
+
+func scheduleCreateCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	// check the expression before resolving the test plan; the daemon checks
+	// it again.
+	expr := c.String("cron")
+	if _, err := cron.Parse(expr); err != nil {
+		return err
+	}
+
+	comp, err := loadComposition(c, c.String("file"))
+	if err != nil {
+		return err
+	}
+	if err = comp.ValidateForRun(); err != nil {
+		return fmt.Errorf("invalid composition file: %w", err)
+	}
+	if len(comp.Sweep) > 0 {
+		return errors.New("compositions with a sweep cannot be scheduled; schedule each combination instead")
+	}
+
+	cl, cfg, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	req, planDir, sdkDir, extraSrcs, err := prepareRun(c, cfg, comp)
+	if err != nil {
+		return err
+	}
+	req.Priority = c.Int("priority")
+
+	r, err := cl.CreateSchedule(ctx, &api.ScheduleRequest{Cron: expr, RunRequest: *req}, planDir, sdkDir, extraSrcs)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sch, err := client.ParseScheduleResponse(r)
+	if err != nil {
+		return err
+	}
+
+	logging.S().Infof("created schedule with ID: %s", sch.ID)
+	logging.S().Infof("list the runs it queues with: testground runs list --schedule %s", sch.ID)
+	return nil
+}
+
+func scheduleListCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.Schedules(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	schs, err := client.ParseSchedulesResponse(r)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tCRON\tTEST PLAN\tTEST CASE\tUSER\tLAST\tLAST TASK")
+
+	for _, sch := range schs {
+		var last string
+		if !sch.Last.IsZero() {
+			last = sch.Last.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", sch.ID, sch.Cron, sch.Plan, sch.Case, sch.CreatedBy.User, last, sch.LastTask)
+	}
+
+	return w.Flush()
+}
+
+func scheduleDeleteCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("missing schedule id")
+	}
+
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.DeleteSchedule(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sch, err := client.ParseScheduleResponse(r)
+	if err != nil {
+		return err
+	}
+
+	logging.S().Infof("deleted schedule with ID: %s", sch.ID)
+	return nil
+}
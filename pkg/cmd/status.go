@@ -13,9 +13,10 @@ import (
 )
 
 var StatusCommand = cli.Command{
-	Name:   "status",
-	Usage:  "get the current status for a certain task",
-	Action: statusCommand,
+	Name:         "status",
+	Usage:        "get the current status for a certain task",
+	Action:       statusCommand,
+	BashComplete: completeFlagValues,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "extended",
@@ -86,6 +87,13 @@ func printTask(tsk task.Task) {
 	fmt.Printf("Created:\t%s\n", tsk.Created())
 	fmt.Printf("Type:\t\t%s\n", tsk.Type)
 	fmt.Printf("Status:\t\t%s\n", tsk.State().State)
+	if tsk.Progress != nil && !tsk.State().State.Done() {
+		fmt.Printf("Progress:\t%s\n", tsk.Progress)
+	}
 	fmt.Printf("Outcome:\t%s\n", outcomeStr)
 	fmt.Printf("Last update:\t%s\n", tsk.State().Created)
+	fmt.Println("States:")
+	for _, st := range tsk.States {
+		fmt.Printf("\t\t%-12s%s\n", st.State, st.Created)
+	}
 }
@@ -2,21 +2,69 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/logging"
 	"github.com/testground/testground/pkg/task"
+
 	"github.com/urfave/cli/v2"
-	"os"
-	"text/tabwriter"
 )
 
 var TasksCommand = cli.Command{
-	Name:   "tasks",
-	Usage:  "get a list of the existing tasks",
-	Action: tasksCommand,
-	Flags:  []cli.Flag{
-		// TODO(hac): add filters (type of task, date, state, etc)
+	Name:         "tasks",
+	Usage:        "get a list of the existing tasks",
+	Action:       tasksCommand,
+	BashComplete: completeFlagValues,
+	Subcommands: cli.Commands{
+		&cli.Command{
+			Name:  "dead-letter",
+			Usage: "manage the tasks quarantined for crashing the daemon too many times",
+			Subcommands: cli.Commands{
+				&cli.Command{
+					Name:   "list",
+					Usage:  "list the quarantined tasks, with the last panic they caused",
+					Action: deadLetterListCommand,
+				},
+				&cli.Command{
+					Name:      "requeue",
+					Usage:     "queue a quarantined task again, e.g. once the daemon is fixed",
+					ArgsUsage: "[task_id]",
+					Action:    deadLetterRequeueCommand,
+				},
+			},
+		},
+	},
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "state",
+			Usage: "only list tasks in `STATE`; values: scheduled, processing, complete",
+			Value: cli.NewStringSlice(string(task.StateScheduled), string(task.StateProcessing), string(task.StateComplete)),
+		},
+		&cli.StringSliceFlag{
+			Name:  "type",
+			Usage: "only list tasks of `TYPE`; values: build, run",
+			Value: cli.NewStringSlice(string(task.TypeBuild), string(task.TypeRun)),
+		},
+		&cli.BoolFlag{
+			Name:  "running",
+			Usage: "only list tasks that are currently being processed; shorthand for --state processing",
+		},
+		&cli.StringFlag{
+			Name:    "plan",
+			Aliases: []string{"p"},
+			Usage:   "only list tasks for the test plan with `NAME`",
+		},
+		&cli.StringFlag{
+			Name:    "testcase",
+			Aliases: []string{"t"},
+			Usage:   "only list tasks for the test case with `NAME`",
+		},
 	},
 }
 
@@ -24,16 +72,38 @@ func tasksCommand(c *cli.Context) error {
 	ctx, cancel := context.WithCancel(ProcessContext())
 	defer cancel()
 
+	req := &api.TasksRequest{
+		TestPlan: c.String("plan"),
+		TestCase: c.String("testcase"),
+	}
+
+	states := c.StringSlice("state")
+	if c.Bool("running") {
+		states = []string{string(task.StateProcessing)}
+	}
+	for _, s := range states {
+		switch st := task.State(s); st {
+		case task.StateScheduled, task.StateProcessing, task.StateComplete:
+			req.States = append(req.States, st)
+		default:
+			return fmt.Errorf("unknown task state: %s", s)
+		}
+	}
+
+	for _, t := range c.StringSlice("type") {
+		switch tp := task.Type(t); tp {
+		case task.TypeBuild, task.TypeRun:
+			req.Types = append(req.Types, tp)
+		default:
+			return fmt.Errorf("unknown task type: %s", t)
+		}
+	}
+
 	cl, _, err := setupClient(c)
 	if err != nil {
 		return err
 	}
 
-	req := &api.TasksRequest{
-		Types:  []task.Type{task.TypeBuild, task.TypeRun},
-		States: []task.State{task.StateScheduled, task.StateProcessing, task.StateComplete},
-	}
-
 	r, err := cl.Tasks(ctx, req)
 	if err != nil {
 		return err
@@ -50,10 +120,78 @@ func tasksCommand(c *cli.Context) error {
 	fmt.Fprintln(w, "ID\tDATE\tTEST PLAN\tTEST CASE\tDURATION\tSTATE\tTYPE")
 
 	for _, tsk := range tsks {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tsk.ID, tsk.Created().String(), tsk.Plan, tsk.Case, tsk.Took(), tsk.State().State, tsk.Type)
+		took := tsk.Took()
+		if tsk.State().State.Phase() == task.StateProcessing {
+			// report how long the task has been running for.
+			took = time.Since(tsk.State().Created).Truncate(time.Second)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tsk.ID, tsk.Created().String(), tsk.Plan, tsk.Case, took, tsk.State().State, tsk.Type)
 	}
 
 	w.Flush()
 
 	return err
 }
+
+func deadLetterListCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.DeadLetters(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tsks, err := client.ParseTasksRequest(r)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tDATE\tTEST PLAN\tTEST CASE\tTYPE\tCRASHES\tLAST PANIC")
+
+	for _, tsk := range tsks {
+		var last string
+		if n := len(tsk.Crashes); n > 0 {
+			last = tsk.Crashes[n-1].Panic
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", tsk.ID, tsk.Created().String(), tsk.Plan, tsk.Case, tsk.Type, len(tsk.Crashes), last)
+	}
+
+	return w.Flush()
+}
+
+func deadLetterRequeueCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("missing task id")
+	}
+
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cl.RequeueDeadLetter(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tsk, err := client.ParseTaskResponse(r)
+	if err != nil {
+		return err
+	}
+
+	logging.S().Infof("task %s is queued again", tsk.ID)
+	return nil
+}
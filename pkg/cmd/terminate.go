@@ -3,26 +3,36 @@ package cmd
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
 )
 
 var TerminateCommand = cli.Command{
-	Name:   "terminate",
-	Usage:  "terminate all jobs and supporting processes of a runner",
-	Action: terminateCommand,
+	Name:         "terminate",
+	Usage:        "cancel a task, or terminate all jobs and supporting processes of a runner or builder",
+	Action:       terminateCommand,
+	BashComplete: completeFlagValues,
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:  "runner",
-			Usage: "runner to terminate; values include: 'local:exec', 'local:docker', 'cluster:k8s'",
+			Usage: "runner to terminate; values include: 'local:exec', 'local:docker', 'local:podman', 'cluster:k8s', 'cluster:nomad'",
 		},
 		&cli.StringFlag{
 			Name:  "builder",
 			Usage: "builder to terminate; values include: 'docker:go', 'docker:generic', 'exec:go'",
 		},
+		&cli.StringFlag{
+			Name:    "task",
+			Aliases: []string{"t"},
+			Usage:   "id of a queued or running task to cancel",
+		},
 	},
 }
 
@@ -33,13 +43,21 @@ func terminateCommand(c *cli.Context) error {
 	var (
 		runner  = c.String("runner")
 		builder = c.String("builder")
+		id      = c.String("task")
 	)
 
-	if runner != "" && builder != "" {
-		return errors.New("cannot accept runner and builder at the same time; please do one at a time")
+	set := 0
+	for _, v := range []string{runner, builder, id} {
+		if v != "" {
+			set++
+		}
 	}
 
-	if runner == "" && builder == "" {
+	if set > 1 {
+		return errors.New("cannot accept more than one of task, runner and builder at the same time; please do one at a time")
+	}
+
+	if set == 0 {
 		return errors.New("specify something to terminate")
 	}
 
@@ -48,6 +66,10 @@ func terminateCommand(c *cli.Context) error {
 		return err
 	}
 
+	if id != "" {
+		return cancelTask(ctx, cl, id)
+	}
+
 	r, err := cl.Terminate(ctx, &api.TerminateRequest{
 		Runner:  runner,
 		Builder: builder,
@@ -59,3 +81,40 @@ func terminateCommand(c *cli.Context) error {
 
 	return client.ParseTerminateRequest(r)
 }
+
+// cancelTask cancels the task and waits for the daemon to wind it down, then
+// prints its final status.
+func cancelTask(ctx context.Context, cl *client.Client, id string) error {
+	r, err := cl.Cancel(ctx, &api.CancelRequest{TaskID: id})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := client.ParseCancelResponse(r); err != nil {
+		return err
+	}
+
+	logging.S().Infow("cancellation requested; waiting for the task to stop", "task_id", id)
+
+	for {
+		tsk, err := taskStatus(ctx, cl, id)
+		if err != nil {
+			return err
+		}
+
+		if tsk.State().State.Done() {
+			printTask(*tsk)
+			if tsk.Type == task.TypeRun {
+				fmt.Printf("\nPartial outputs can be collected with: testground collect %s\n", id)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted")
+		case <-time.After(time.Second):
+		}
+	}
+}
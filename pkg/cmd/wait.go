@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/client"
+	"github.com/testground/testground/pkg/data"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/urfave/cli/v2"
+)
+
+var WaitCommand = cli.Command{
+	Name:         "wait",
+	Usage:        "wait for a task to complete, and exit with a status code reflecting its outcome",
+	ArgsUsage:    "[task id]",
+	Action:       waitCommand,
+	BashComplete: completeTaskArgs,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "task",
+			Aliases: []string{"t"},
+			Usage:   "the task id; can also be passed as the first argument",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to poll the daemon for the task status",
+			Value: 5 * time.Second,
+		},
+	},
+}
+
+func waitCommand(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(ProcessContext())
+	defer cancel()
+
+	id := c.String("task")
+	if id == "" {
+		id = c.Args().First()
+	}
+	if id == "" {
+		return fmt.Errorf("no task id supplied")
+	}
+
+	cl, _, err := setupClient(c)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(c.Duration("interval"))
+	defer ticker.Stop()
+
+	for {
+		tsk, err := taskStatus(ctx, cl, id)
+		if err != nil {
+			return err
+		}
+
+		if tsk.State().State.Done() {
+			printTask(*tsk)
+			return waitExitCode(tsk)
+		}
+
+		logging.S().Debugw("task not complete yet", "task_id", id, "state", tsk.State().State)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted")
+		case <-ticker.C:
+		}
+	}
+}
+
+func taskStatus(ctx context.Context, cl *client.Client, id string) (*task.Task, error) {
+	r, err := cl.Status(ctx, &api.StatusRequest{TaskID: id})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	tsk, err := client.ParseStatusResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &tsk, nil
+}
+
+// waitExitCode maps the outcome of a finished task to an exit code: 0 on
+// success, 2 if the task was canceled, and 1 otherwise.
+func waitExitCode(tsk *task.Task) error {
+	if tsk.IsCanceled() {
+		return cli.Exit(fmt.Sprintf("task %s was canceled", tsk.ID), 2)
+	}
+
+	if tsk.Error != "" {
+		return cli.Exit(tsk.Error, 1)
+	}
+
+	if err := data.IsTaskOutcomeInError(tsk); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return nil
+}
@@ -17,14 +17,9 @@ func (c CoalescedConfig) Append(in map[string]interface{}) CoalescedConfig {
 func (c CoalescedConfig) CoalesceIntoType(typ reflect.Type) (interface{}, error) {
 	all := make(map[string]interface{})
 
-	// Copy all values into coalesced map.
+	// Merge all values into the coalesced map; later configs take precedence.
 	for _, cfg := range c {
-		if c == nil {
-			continue
-		}
-		for k, v := range cfg {
-			all[k] = v
-		}
+		all = Inherit(cfg, all)
 	}
 
 	// Serialize map into TOML, and then deserialize into the appropriate type.
@@ -37,3 +32,43 @@ func (c CoalescedConfig) CoalesceIntoType(typ reflect.Type) (interface{}, error)
 	_, err := toml.DecodeReader(buf, v)
 	return v, err
 }
+
+// Inherit returns a copy of cfg in which the settings that cfg does not set are
+// inherited from parent. Tables set in both are merged recursively, so that a
+// table in cfg only needs to carry the keys it overrides; any other value set
+// in cfg, including arrays, replaces the value in parent.
+//
+// Neither cfg nor parent are modified. If both are empty, cfg is returned.
+func Inherit(cfg, parent map[string]interface{}) map[string]interface{} {
+	if len(cfg) == 0 && len(parent) == 0 {
+		return cfg
+	}
+
+	res := make(map[string]interface{}, len(cfg)+len(parent))
+	for k, v := range parent {
+		res[k] = copyValue(v)
+	}
+	for k, v := range cfg {
+		child, ok := v.(map[string]interface{})
+		if p, pok := res[k].(map[string]interface{}); ok && pok {
+			res[k] = Inherit(child, p)
+			continue
+		}
+		res[k] = copyValue(v)
+	}
+	return res
+}
+
+// copyValue copies tables, so that the result of Inherit can be modified
+// without affecting its inputs.
+func copyValue(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[k] = copyValue(v)
+	}
+	return res
+}
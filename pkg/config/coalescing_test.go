@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInherit(t *testing.T) {
+	parent := map[string]interface{}{
+		"image":   "busybox",
+		"ulimits": []interface{}{"nofile=1024:1024"},
+		"ports":   map[string]interface{}{"http": "8080", "pprof": "6060"},
+	}
+	cfg := map[string]interface{}{
+		"ulimits": []interface{}{"nproc=64:64"},
+		"ports":   map[string]interface{}{"http": "9090"},
+	}
+
+	res := Inherit(cfg, parent)
+	require.Equal(t, map[string]interface{}{
+		"image":   "busybox",
+		"ulimits": []interface{}{"nproc=64:64"},
+		"ports":   map[string]interface{}{"http": "9090", "pprof": "6060"},
+	}, res)
+
+	// the inputs are left untouched.
+	res["ports"].(map[string]interface{})["pprof"] = "7070"
+	require.Equal(t, map[string]interface{}{"http": "8080", "pprof": "6060"}, parent["ports"])
+	require.Equal(t, map[string]interface{}{"http": "9090"}, cfg["ports"])
+
+	require.Nil(t, Inherit(nil, nil))
+	require.Equal(t, parent["image"], Inherit(nil, parent)["image"])
+}
+
+func TestCoalesceIntoTypeMergesTables(t *testing.T) {
+	type cfgType struct {
+		LogLevel string            `toml:"log_level"`
+		Ports    map[string]string `toml:"ports"`
+	}
+
+	var cfg CoalescedConfig
+	cfg = cfg.Append(map[string]interface{}{"log_level": "info", "ports": map[string]interface{}{"http": "8080"}})
+	cfg = cfg.Append(map[string]interface{}{"ports": map[string]interface{}{"pprof": "6060"}})
+
+	v, err := cfg.CoalesceIntoType(reflect.TypeOf(cfgType{}))
+	require.NoError(t, err)
+	require.Equal(t, &cfgType{
+		LogLevel: "info",
+		Ports:    map[string]string{"http": "8080", "pprof": "6060"},
+	}, v)
+}
@@ -1,40 +1,100 @@
 package config
 
+import "fmt"
+
 type ConfigMap map[string]interface{}
 
 // EnvConfig contains the environment configuration. It is populated by
 // coalescing values from these sources, in descending order of precedence:
 //
 //  1. environment variables.
-//  2. env.toml.
-//  3. default fallbacks.
+//  2. the selected profile, if any.
+//  3. env.toml.
+//  4. default fallbacks.
 type EnvConfig struct {
 	dirs Directories
 
 	AWS       AWSConfig            `toml:"aws"`
 	DockerHub DockerHubConfig      `toml:"dockerhub"`
+	Registry  RegistryConfig       `toml:"registry"`
 	Builders  map[string]ConfigMap `toml:"builders"`
 	Runners   map[string]ConfigMap `toml:"runners"`
 	Daemon    DaemonConfig         `toml:"daemon"`
 	Client    ClientConfig         `toml:"client"`
+
+	// Outputs is the object store that the outputs of runs are uploaded to,
+	// if any; they stay with the runners otherwise.
+	Outputs OutputsConfig `toml:"outputs"`
+
+	// Profiles are named client configurations, e.g. one per daemon the user
+	// works with, selected with the --profile flag.
+	Profiles map[string]ClientConfig `toml:"profiles"`
 }
 
 func (e EnvConfig) Dirs() Directories {
 	return e.dirs
 }
 
+// WithHome returns a copy of the configuration, rooted at the given home
+// directory. Plugins use it to rebuild the configuration of the daemon.
+func (e EnvConfig) WithHome(home string) EnvConfig {
+	e.dirs = Directories{home}
+	return e
+}
+
 type AWSConfig struct {
 	AccessKeyID     string `toml:"access_key_id"`
 	SecretAccessKey string `toml:"secret_access_key"`
 	Region          string `toml:"region"`
 }
 
+// OutputsConfig configures the object store that the engine uploads the
+// outputs of runs to, once they complete, and that they're collected from
+// afterwards. Any S3-compatible store can be used: AWS S3, MinIO, or GCS
+// through its XML API, with HMAC keys.
+type OutputsConfig struct {
+	// Backend is the kind of store; "s3" is the only one supported. Outputs
+	// aren't uploaded if empty.
+	Backend string `toml:"backend"`
+
+	// Bucket and Prefix are where the archives of the outputs are uploaded,
+	// as <prefix>/<run_id>.tgz; compositions can override them per run.
+	Bucket string `toml:"bucket"`
+	Prefix string `toml:"prefix"`
+
+	// Endpoint is the URL of the store, if not AWS S3, e.g.
+	// http://minio:9000 or https://storage.googleapis.com. PathStyle
+	// addresses buckets in the path of the URLs, as MinIO requires.
+	Endpoint  string `toml:"endpoint"`
+	PathStyle bool   `toml:"path_style"`
+
+	// Region and the credentials below default to those of the aws table,
+	// and the credentials then to the default chain of the AWS SDK.
+	Region          string `toml:"region"`
+	AccessKeyID     string `toml:"access_key_id"`
+	SecretAccessKey string `toml:"secret_access_key"`
+}
+
 type DockerHubConfig struct {
 	Repo        string `toml:"repo"`
 	Username    string `toml:"username"`
 	AccessToken string `toml:"access_token"`
 }
 
+// RegistryConfig is a docker registry other than ECR or DockerHub, e.g. a GCR
+// or Harbor one, which builders and runners push images to with the generic
+// provider.
+type RegistryConfig struct {
+	// Repo is the repository the images are pushed to, e.g.
+	// harbor.example.com/testground.
+	Repo string `toml:"repo"`
+
+	// Username and Password are the credentials of the registry, if any, e.g.
+	// _json_key and the key of a service account for GCR.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
 type DaemonConfig struct {
 	Listen                string          `toml:"listen"`
 	Scheduler             SchedulerConfig `toml:"scheduler"`
@@ -43,6 +103,99 @@ type DaemonConfig struct {
 	GithubRepoStatusToken string          `toml:"github_repo_status_token"`
 	RootURL               string          `toml:"root_url"`
 	InfluxDBEndpoint      string          `toml:"influxdb_endpoint"`
+
+	// Plugins are the paths of the plugin executables serving out-of-tree
+	// builders and runners; see package plugin.
+	Plugins []string `toml:"plugins"`
+
+	// Webhooks are notified of the state transitions of tasks.
+	Webhooks []WebhookConfig `toml:"webhooks"`
+
+	// Auth identifies the users of the daemon. Tokens, if any, are accepted
+	// too, but they authenticate anonymous clients with full access.
+	Auth AuthConfig `toml:"auth"`
+
+	// Janitor reaps what runs leave behind.
+	Janitor JanitorConfig `toml:"janitor"`
+}
+
+// JanitorConfig configures the janitor of the daemon, which periodically
+// removes the containers, pods, networks... left behind by the runs that are
+// over, e.g. because they or the daemon crashed, with the runners that
+// support it.
+type JanitorConfig struct {
+	// IntervalMin is how often the janitor makes its rounds, in minutes; 10 by
+	// default. A negative interval disables the janitor.
+	IntervalMin int `toml:"interval_min"`
+
+	// GraceMin is how long the janitor leaves the runs that are over alone, in
+	// minutes, e.g. for their containers to be inspected when they were kept;
+	// 60 by default. The leftovers of runs the daemon doesn't know of, e.g.
+	// from before the tasks were wiped, are reaped regardless.
+	GraceMin int `toml:"grace_min"`
+}
+
+// AuthConfig configures how the daemon authenticates its users: by static
+// tokens, or by the tokens of an OpenID Connect provider, or both. Users
+// only have access to their own tasks, unless they have the admin role.
+type AuthConfig struct {
+	Users []UserConfig `toml:"users"`
+	OIDC  OIDCConfig   `toml:"oidc"`
+}
+
+// Enabled returns whether users are authenticated.
+func (a AuthConfig) Enabled() bool {
+	return len(a.Users) > 0 || a.OIDC.Issuer != ""
+}
+
+// UserConfig is a user authenticated by a static token.
+type UserConfig struct {
+	Name  string `toml:"name"`
+	Token string `toml:"token"`
+
+	// Roles are the roles of the user. Values: admin, who has access to the
+	// tasks of every user, and can cancel them.
+	Roles []string `toml:"roles"`
+}
+
+// OIDCConfig configures the OpenID Connect provider that issues the tokens of
+// the users, as JWTs the daemon verifies against the keys of the provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the provider; its configuration is discovered at
+	// <issuer>/.well-known/openid-configuration.
+	Issuer string `toml:"issuer"`
+
+	// Audience is the audience tokens must be issued for; usually the client
+	// ID of testground at the provider. Required, since providers issue
+	// tokens for all their clients.
+	Audience string `toml:"audience"`
+
+	// UserClaim is the claim holding the name of the user; "email" by
+	// default.
+	UserClaim string `toml:"user_claim"`
+
+	// GroupsClaim is the claim holding the groups of the user; "groups" by
+	// default. Users in one of the AdminGroups have the admin role.
+	GroupsClaim string   `toml:"groups_claim"`
+	AdminGroups []string `toml:"admin_groups"`
+}
+
+// WebhookConfig is an endpoint that the daemon POSTs task events to.
+type WebhookConfig struct {
+	URL string `toml:"url"`
+
+	// Format is the format of the payload: "json" (the default) posts the
+	// events as they are; "slack" and "discord" post a message rendered
+	// from Template.
+	Format string `toml:"format"`
+
+	// Events are the events to notify; all of them if empty. Values:
+	// queued, started, finished, failed, canceled.
+	Events []string `toml:"events"`
+
+	// Template is a text/template of the message posted to slack and
+	// discord webhooks, executed with the event; a default is used if empty.
+	Template string `toml:"template"`
 }
 
 type SchedulerConfig struct {
@@ -50,10 +203,60 @@ type SchedulerConfig struct {
 	QueueSize      int    `toml:"queue_size"`
 	TaskRepoType   string `toml:"task_repo_type"`
 	TaskTimeoutMin int    `toml:"task_timeout_min"`
+
+	// MaxPriority is the highest priority that tasks can be scheduled with,
+	// unless the request bears one of the PriorityTokens.
+	MaxPriority    int      `toml:"max_priority"`
+	PriorityTokens []string `toml:"priority_tokens"`
+
+	// Weights are the scheduling weights of users, who get tasks of the
+	// same priority scheduled in proportion to them. Users without a weight
+	// have weight 1.
+	Weights map[string]int `toml:"weights"`
+
+	// Concurrency is the maximum number of tasks of every runner that are
+	// processed at the same time, e.g. 1 for cluster:k8s. Runners without a
+	// limit are only limited by the number of workers.
+	Concurrency map[string]int `toml:"concurrency"`
+
+	// MaxCrashes is how many times a task can crash a worker, by making it
+	// panic, before the task is quarantined as a dead letter rather than
+	// queued again; 3 by default.
+	MaxCrashes int `toml:"max_crashes"`
 }
 
 type ClientConfig struct {
 	Endpoint string `toml:"endpoint"`
 	Token    string `toml:"token"`
 	User     string `toml:"user"`
+
+	// Runner and Builder are used by single runs and builds, and by
+	// compositions, that don't specify one.
+	Runner  string `toml:"runner"`
+	Builder string `toml:"builder"`
+}
+
+// ApplyProfile overrides the client configuration with the values set in the
+// named profile.
+func (e *EnvConfig) ApplyProfile(name string) error {
+	p, ok := e.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&e.Client.Endpoint, p.Endpoint},
+		{&e.Client.Token, p.Token},
+		{&e.Client.User, p.User},
+		{&e.Client.Runner, p.Runner},
+		{&e.Client.Builder, p.Builder},
+	} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+	return nil
 }
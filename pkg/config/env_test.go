@@ -0,0 +1,56 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFromWithProfiles(t *testing.T) {
+	home, err := ioutil.TempDir("", "tghome")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+
+	prev, ok := os.LookupEnv(EnvTestgroundHomeDir)
+	require.NoError(t, os.Setenv(EnvTestgroundHomeDir, home))
+	defer func() {
+		if ok {
+			_ = os.Setenv(EnvTestgroundHomeDir, prev)
+		} else {
+			_ = os.Unsetenv(EnvTestgroundHomeDir)
+		}
+	}()
+
+	file := filepath.Join(home, "staging.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+[client]
+endpoint = "http://localhost:8042"
+user = "me"
+runner = "local:docker"
+
+[profiles.staging]
+endpoint = "https://staging.example.com"
+token = "secret"
+runner = "cluster:k8s"
+builder = "docker:go"
+`), 0644))
+
+	var cfg EnvConfig
+	require.NoError(t, cfg.LoadFrom(file))
+	require.Equal(t, "local:docker", cfg.Client.Runner)
+
+	require.NoError(t, cfg.ApplyProfile("staging"))
+	require.Equal(t, ClientConfig{
+		Endpoint: "https://staging.example.com",
+		Token:    "secret",
+		User:     "me",
+		Runner:   "cluster:k8s",
+		Builder:  "docker:go",
+	}, cfg.Client)
+
+	require.Error(t, cfg.ApplyProfile("production"))
+	require.Error(t, new(EnvConfig).LoadFrom(filepath.Join(home, "missing.toml")))
+}
@@ -27,9 +27,20 @@ const (
 	DefaultWorkers = 2
 
 	DefaultQueueSize = 100
+
+	// DefaultMaxPriority lets clients that wait for their tasks schedule
+	// them ahead of the others.
+	DefaultMaxPriority = 1
 )
 
+// Load loads the configuration from $TESTGROUND_HOME/.env.toml, if it exists.
 func (e *EnvConfig) Load() error {
+	return e.LoadFrom("")
+}
+
+// LoadFrom loads the configuration from the supplied file, which must exist,
+// instead of $TESTGROUND_HOME/.env.toml. An empty path behaves like Load.
+func (e *EnvConfig) LoadFrom(file string) error {
 	// apply fallbacks.
 	e.Daemon.Listen = DefaultListenAddr
 	e.Daemon.InfluxDBEndpoint = DefaultInfluxDBEndpoint
@@ -37,6 +48,7 @@ func (e *EnvConfig) Load() error {
 	e.Daemon.Scheduler.Workers = DefaultWorkers
 	e.Daemon.Scheduler.QueueSize = DefaultQueueSize
 	e.Daemon.Scheduler.TaskRepoType = DefaultTaskRepoType
+	e.Daemon.Scheduler.MaxPriority = DefaultMaxPriority
 
 	// calculate home directory; use env var, or fall back to $HOME/testground
 	// otherwise.
@@ -80,6 +92,14 @@ func (e *EnvConfig) Load() error {
 		}
 	}
 
+	if file != "" {
+		if _, err := toml.DecodeFile(file, e); err != nil {
+			return fmt.Errorf("failed to load config file %s: %w", file, err)
+		}
+		logging.S().Infof("config loaded from: %s", file)
+		return nil
+	}
+
 	// parse the .env.toml file, if it exists.
 	f := filepath.Join(e.dirs.Home(), ".env.toml")
 	if _, err := os.Stat(f); err == nil {
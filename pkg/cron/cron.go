@@ -0,0 +1,193 @@
+// Package cron parses cron expressions, and computes the times they match.
+//
+// Expressions have the five fields of crontab(5) — minute, hour, day of
+// month, month and day of week — each either `*`, a value, a range `a-b`, or
+// a list of them separated by commas, optionally stepped with `/n`. Months
+// and days of week may be named (jan, mon), and Sunday is either 0 or 7.
+// When both the day of month and the day of week are restricted, a day
+// matches if either matches. The descriptors @yearly, @annually, @monthly,
+// @weekly, @daily, @midnight and @hourly are supported too.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed cron expression.
+type Expression struct {
+	expr string
+
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar are whether the day of month and the day of week
+	// are unrestricted.
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    []string // names of the values, from min
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Expression, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	e := &Expression{expr: expr}
+	var err error
+	for i, f := range []struct {
+		field
+		dst *uint64
+	}{
+		{minuteField, &e.minute},
+		{hourField, &e.hour},
+		{domField, &e.dom},
+		{monthField, &e.month},
+		{dowField, &e.dow},
+	} {
+		if *f.dst, err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+
+	// Sunday is both 0 and 7.
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.domStar = strings.HasPrefix(fields[2], "*")
+	e.dowStar = strings.HasPrefix(fields[4], "*")
+	return e, nil
+}
+
+// parse returns the set of the values of the field, as a bitset.
+func (f field) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", f.name, part)
+			}
+			rng, step = part[:i], v
+		}
+
+		var lo, hi int
+		switch i := strings.Index(rng, "-"); {
+		case rng == "*":
+			lo, hi = f.min, f.max
+		case i >= 0:
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s %q", f.name, part)
+			}
+		default:
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			// a stepped value runs to the end of the field, like a-max/n.
+			if step > 1 {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q; expected a value between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the expression as it was parsed.
+func (e *Expression) String() string {
+	return e.expr
+}
+
+// maxSearch bounds the search of the next time matched by an expression, so
+// that expressions that never match, like February 30th, don't loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first time after t that the expression matches, in the
+// location of t, or the zero time if it matches none in the next five years.
+func (e *Expression) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(maxSearch)
+
+	for t.Before(end) {
+		switch {
+		case e.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !e.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case e.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case e.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (e *Expression) matchDay(t time.Time) bool {
+	var (
+		dom = e.dom&(1<<uint(t.Day())) != 0
+		dow = e.dow&(1<<uint(t.Weekday())) != 0
+	)
+	if e.domStar || e.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,62 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	// a Wednesday.
+	from := time.Date(2021, 3, 17, 10, 30, 0, 0, time.UTC)
+
+	for expr, next := range map[string]time.Time{
+		"* * * * *":       time.Date(2021, 3, 17, 10, 31, 0, 0, time.UTC),
+		"0 2 * * *":       time.Date(2021, 3, 18, 2, 0, 0, 0, time.UTC),
+		"@daily":          time.Date(2021, 3, 18, 0, 0, 0, 0, time.UTC),
+		"@hourly":         time.Date(2021, 3, 17, 11, 0, 0, 0, time.UTC),
+		"*/15 * * * *":    time.Date(2021, 3, 17, 10, 45, 0, 0, time.UTC),
+		"5/20 * * * *":    time.Date(2021, 3, 17, 10, 45, 0, 0, time.UTC),
+		"0 9-17/4 * * *":  time.Date(2021, 3, 17, 13, 0, 0, 0, time.UTC),
+		"0 0 * * sun":     time.Date(2021, 3, 21, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":       time.Date(2021, 3, 21, 0, 0, 0, 0, time.UTC),
+		"0 0 * * mon-fri": time.Date(2021, 3, 18, 0, 0, 0, 0, time.UTC),
+		"0 0 1 * *":       time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 1 jan *":     time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 feb *":    time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"30,45 10 * * *":  time.Date(2021, 3, 17, 10, 45, 0, 0, time.UTC),
+		// either the day of month or the day of week.
+		"0 0 20 * mon": time.Date(2021, 3, 20, 0, 0, 0, 0, time.UTC),
+	} {
+		e, err := Parse(expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, next, e.Next(from), expr)
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	e, err := Parse("0 0 30 feb *")
+	require.NoError(t, err)
+	require.True(t, e.Next(time.Now()).IsZero())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@never",
+	} {
+		_, err := Parse(expr)
+		require.Error(t, err, expr)
+	}
+}
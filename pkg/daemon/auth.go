@@ -0,0 +1,193 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/task"
+)
+
+// roleAdmin is the role of the users who have access to the tasks of every
+// user, and can cancel them.
+const roleAdmin = "admin"
+
+var (
+	errUnauthenticated = errors.New("not authenticated")
+	errForbidden       = errors.New("access denied")
+)
+
+// identity is who a request is authenticated as.
+type identity struct {
+	user  string // empty for anonymous clients
+	admin bool
+}
+
+// anonymous is the identity of the clients of a daemon that doesn't
+// authenticate users, or that authenticate with one of the tokens of the
+// daemon. They have access to every task, but can't create tasks on behalf of
+// anyone, nor bypass the maximum priority.
+var anonymous = identity{admin: true}
+
+// canAccess returns whether the identity has access to the tasks of owner.
+func (id identity) canAccess(owner string) bool {
+	return id.admin || id.user == owner
+}
+
+// scope is the user whose tasks the identity lists; all users if empty.
+func (id identity) scope() string {
+	if id.admin {
+		return ""
+	}
+	return id.user
+}
+
+type identityKey struct{}
+
+// requestIdentity returns the identity of an authenticated request.
+func requestIdentity(r *http.Request) identity {
+	if id, ok := r.Context().Value(identityKey{}).(identity); ok {
+		return id
+	}
+	return anonymous
+}
+
+// authenticator authenticates the requests to the daemon by their bearer
+// tokens: static tokens, or the tokens of an OpenID Connect provider.
+type authenticator struct {
+	tokens map[string]identity
+	oidc   *oidcVerifier
+}
+
+// newAuthenticator returns the authenticator of the daemon configuration, or
+// nil if requests aren't authenticated.
+func newAuthenticator(cfg config.DaemonConfig) (*authenticator, error) {
+	if len(cfg.Tokens) == 0 && !cfg.Auth.Enabled() {
+		return nil, nil
+	}
+
+	a := &authenticator{tokens: make(map[string]identity)}
+	for _, t := range cfg.Tokens {
+		a.tokens[strings.TrimSpace(t)] = anonymous
+	}
+	for _, u := range cfg.Auth.Users {
+		token := strings.TrimSpace(u.Token)
+		if u.Name == "" || token == "" {
+			return nil, fmt.Errorf("users must have a name and a token")
+		}
+		if _, ok := a.tokens[token]; ok {
+			return nil, fmt.Errorf("user %s shares a token with another user", u.Name)
+		}
+
+		id := identity{user: u.Name}
+		for _, role := range u.Roles {
+			switch role {
+			case roleAdmin:
+				id.admin = true
+			default:
+				return nil, fmt.Errorf("user %s has unknown role %q", u.Name, role)
+			}
+		}
+		a.tokens[token] = id
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		v, err := newOIDCVerifier(cfg.Auth.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		a.oidc = v
+	}
+	return a, nil
+}
+
+// authenticate returns the identity of the request.
+func (a *authenticator) authenticate(r *http.Request) (identity, error) {
+	if a == nil {
+		return anonymous, nil
+	}
+
+	token := bearerToken(r)
+	if token == "" {
+		return identity{}, errUnauthenticated
+	}
+	if id, ok := a.tokens[token]; ok {
+		return id, nil
+	}
+	if a.oidc != nil && strings.Count(token, ".") == 2 {
+		id, err := a.oidc.verify(r.Context(), token)
+		if err != nil {
+			return identity{}, fmt.Errorf("%w: %s", errUnauthenticated, err)
+		}
+		return id, nil
+	}
+	return identity{}, errUnauthenticated
+}
+
+// middleware authenticates every request, and attaches its identity to its
+// context.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// attribute attributes a task to the user of the request, if it's
+// authenticated as one, regardless of who the client claims created it.
+func attribute(r *http.Request, createdBy *api.CreatedBy) {
+	if id := requestIdentity(r); id.user != "" {
+		createdBy.User = id.user
+	}
+}
+
+// authorizeTask returns the task with the given ID, if the user of the
+// request has access to it.
+func authorizeTask(engine api.Engine, r *http.Request, id string) (*task.Task, error) {
+	tsk, err := engine.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if !requestIdentity(r).canAccess(tsk.Owner()) {
+		return nil, fmt.Errorf("%w: task %s belongs to another user", errForbidden, id)
+	}
+	return tsk, nil
+}
+
+// authorizeRun is like authorizeTask, for the ID of a run, which may be a case
+// of a suite.
+func authorizeRun(engine api.Engine, r *http.Request, runID string) error {
+	id, _ := api.SplitRunID(runID)
+	_, err := authorizeTask(engine, r, id)
+	return err
+}
+
+// authorizeSchedule returns the schedule with the given ID, if the user of
+// the request has access to it.
+func authorizeSchedule(engine api.Engine, r *http.Request, id string) (*task.Schedule, error) {
+	sch, err := engine.GetSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if !requestIdentity(r).canAccess(sch.CreatedBy.User) {
+		return nil, fmt.Errorf("%w: schedule %s belongs to another user", errForbidden, id)
+	}
+	return sch, nil
+}
+
+// authorizeAdmin checks that the user of the request has the admin role, to
+// act on the infrastructure shared by every user.
+func authorizeAdmin(r *http.Request) error {
+	if !requestIdentity(r).admin {
+		return fmt.Errorf("%w: only admins can do this", errForbidden)
+	}
+	return nil
+}
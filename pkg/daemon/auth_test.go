@@ -0,0 +1,201 @@
+package daemon
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testground/testground/pkg/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func authRequest(token string) *http.Request {
+	r := httptest.NewRequest("GET", "/v1/tasks", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAuthenticateTokens(t *testing.T) {
+	a, err := newAuthenticator(config.DaemonConfig{
+		Tokens: []string{"legacy"},
+		Auth: config.AuthConfig{Users: []config.UserConfig{
+			{Name: "alice", Token: "alice-token"},
+			{Name: "bob", Token: "bob-token", Roles: []string{"admin"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	id, err := a.authenticate(authRequest("alice-token"))
+	require.NoError(t, err)
+	require.Equal(t, identity{user: "alice"}, id)
+	require.True(t, id.canAccess("alice"))
+	require.False(t, id.canAccess("bob"))
+	require.Equal(t, "alice", id.scope())
+
+	id, err = a.authenticate(authRequest("bob-token"))
+	require.NoError(t, err)
+	require.Equal(t, identity{user: "bob", admin: true}, id)
+	require.True(t, id.canAccess("alice"))
+	require.Empty(t, id.scope())
+
+	id, err = a.authenticate(authRequest("legacy"))
+	require.NoError(t, err)
+	require.Equal(t, anonymous, id)
+
+	_, err = a.authenticate(authRequest("bogus"))
+	require.ErrorIs(t, err, errUnauthenticated)
+	_, err = a.authenticate(authRequest(""))
+	require.ErrorIs(t, err, errUnauthenticated)
+
+	// without auth, every client is anonymous.
+	a, err = newAuthenticator(config.DaemonConfig{})
+	require.NoError(t, err)
+	id, err = a.authenticate(authRequest(""))
+	require.NoError(t, err)
+	require.Equal(t, anonymous, id)
+}
+
+func TestAuthenticatorConfig(t *testing.T) {
+	for name, users := range map[string][]config.UserConfig{
+		"no token":     {{Name: "alice"}},
+		"no name":      {{Token: "t"}},
+		"shared token": {{Name: "alice", Token: "t"}, {Name: "bob", Token: "t"}},
+		"unknown role": {{Name: "alice", Token: "t", Roles: []string{"root"}}},
+	} {
+		_, err := newAuthenticator(config.DaemonConfig{Auth: config.AuthConfig{Users: users}})
+		require.Error(t, err, name)
+	}
+}
+
+// provider is a fake OpenID Connect provider, signing tokens with an RSA key
+// and an EC key.
+type provider struct {
+	*httptest.Server
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	p := &provider{rsa: rk, ec: ek}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rk.N.Bytes()), "e": b64(big.NewInt(int64(rk.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ek.X.FillBytes(make([]byte, 32))), "y": b64(ek.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *provider) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	alg := map[string]string{"rsa": "RS256", "ec": "ES256"}[kid]
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch kid {
+	case "rsa":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, p.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ec":
+		r, s, err := ecdsa.Sign(rand.Reader, p.ec, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthenticateOIDC(t *testing.T) {
+	p := newProvider(t)
+	a, err := newAuthenticator(config.DaemonConfig{Auth: config.AuthConfig{OIDC: config.OIDCConfig{
+		Issuer:      p.URL,
+		Audience:    "testground",
+		AdminGroups: []string{"infra"},
+	}}})
+	require.NoError(t, err)
+
+	claims := func(override map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    p.URL,
+			"aud":    []string{"testground", "other"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"email":  "alice@example.com",
+			"groups": []string{"dev"},
+		}
+		for k, v := range override {
+			c[k] = v
+		}
+		return c
+	}
+
+	id, err := a.authenticate(authRequest(p.token(t, "rsa", claims(nil))))
+	require.NoError(t, err)
+	require.Equal(t, identity{user: "alice@example.com"}, id)
+
+	id, err = a.authenticate(authRequest(p.token(t, "ec", claims(map[string]interface{}{"groups": []string{"dev", "infra"}}))))
+	require.NoError(t, err)
+	require.Equal(t, identity{user: "alice@example.com", admin: true}, id)
+
+	for name, c := range map[string]map[string]interface{}{
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"not yet valid":  {"nbf": time.Now().Add(time.Hour).Unix()},
+		"other audience": {"aud": "other"},
+		"other issuer":   {"iss": "https://evil.example.com"},
+		"no user":        {"email": ""},
+	} {
+		_, err := a.authenticate(authRequest(p.token(t, "rsa", claims(c))))
+		require.ErrorIs(t, err, errUnauthenticated, name)
+	}
+
+	// a token of the provider for another client is rejected, even if the
+	// audience isn't configured.
+	v := &oidcVerifier{cfg: config.OIDCConfig{Issuer: p.URL, UserClaim: "email"}, client: http.DefaultClient}
+	_, err = v.verify(context.Background(), p.token(t, "rsa", claims(map[string]interface{}{"aud": "other"})))
+	require.Error(t, err)
+
+	_, err = newAuthenticator(config.DaemonConfig{Auth: config.AuthConfig{OIDC: config.OIDCConfig{Issuer: p.URL}}})
+	require.Error(t, err, "an oidc provider without audience should be rejected")
+
+	// a token whose claims were tampered with.
+	tok := strings.Split(p.token(t, "rsa", claims(nil)), ".")
+	forged := strings.Split(p.token(t, "rsa", claims(map[string]interface{}{"email": "bob@example.com"})), ".")
+	_, err = a.authenticate(authRequest(tok[0] + "." + forged[1] + "." + tok[2]))
+	require.ErrorIs(t, err, errUnauthenticated)
+}
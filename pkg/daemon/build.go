@@ -47,6 +47,12 @@ func (d *Daemon) buildHandler(engine api.Engine) func(w http.ResponseWriter, r *
 			return
 		}
 
+		if err := authorizePriority(engine.EnvConfig().Daemon.Scheduler, r, request.Priority); err != nil {
+			tgw.WriteError("unauthorized request", "err", err)
+			return
+		}
+		attribute(r, &request.CreatedBy)
+
 		id, err := engine.QueueBuild(request, sources)
 		if err != nil {
 			tgw.WriteError(fmt.Sprintf("engine build error: %s", err))
@@ -74,6 +80,11 @@ func (d *Daemon) buildPurgeHandler(engine api.Engine) func(w http.ResponseWriter
 			return
 		}
 
+		if err := authorizeAdmin(r); err != nil {
+			tgw.WriteError("unauthorized request", "err", err)
+			return
+		}
+
 		err = engine.DoBuildPurge(r.Context(), req.Builder, req.Testplan, tgw)
 		if err != nil {
 			tgw.WriteError("build purge error", "err", err.Error())
@@ -84,6 +95,41 @@ func (d *Daemon) buildPurgeHandler(engine api.Engine) func(w http.ResponseWriter
 	}
 }
 
+func (d *Daemon) buildArtifactsHandler(engine api.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logging.S().With("req_id", r.Header.Get("X-Request-ID"))
+
+		log.Debugw("handle request", "command", "build/artifacts")
+		defer log.Debugw("request handled", "command", "build/artifacts")
+
+		tgw := rpc.NewOutputWriter(w, r)
+
+		var req api.BuildArtifactsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			tgw.WriteError("build artifacts json decode", "err", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// artifacts aren't owned by users; only admins can remove them.
+		if req.Remove {
+			if err := authorizeAdmin(r); err != nil {
+				tgw.WriteError("unauthorized request", "err", err)
+				return
+			}
+		}
+
+		artifacts, err := engine.DoBuildArtifacts(r.Context(), req.Builder, req.Filter, req.Remove, tgw)
+		if err != nil {
+			tgw.WriteError("build artifacts error", "err", err.Error())
+			return
+		}
+
+		tgw.WriteResult(artifacts)
+	}
+}
+
 func consumeRunBuildRequest(r *http.Request, body interface{}, dir string) (*api.UnpackedSources, error) {
 	var (
 		p   *multipart.Part
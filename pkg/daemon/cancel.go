@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+func (d *Daemon) cancelHandler(engine api.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logging.S().With("req_id", r.Header.Get("X-Request-ID"))
+
+		log.Debugw("handle request", "command", "cancel")
+		defer log.Debugw("request handled", "command", "cancel")
+
+		tgw := rpc.NewOutputWriter(w, r)
+
+		var req api.CancelRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			tgw.WriteError("cancel json decode", "err", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if _, err := authorizeTask(engine, r, req.TaskID); err != nil {
+			tgw.WriteError("cancel error", "task_id", req.TaskID, "err", err.Error())
+			return
+		}
+
+		if err := engine.Kill(req.TaskID); err != nil {
+			tgw.WriteError("cancel error", "task_id", req.TaskID, "err", err.Error())
+			return
+		}
+
+		tgw.WriteResult("canceled")
+	}
+}
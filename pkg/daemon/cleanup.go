@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+func (d *Daemon) cleanupHandler(engine api.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logging.S().With("req_id", r.Header.Get("X-Request-ID"))
+
+		log.Debugw("handle request", "command", "cleanup")
+		defer log.Debugw("request handled", "command", "cleanup")
+
+		tgw := rpc.NewOutputWriter(w, r)
+
+		var req api.CleanupRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			tgw.WriteError("cleanup json decode", "err", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// cleanups aren't limited to the runs of the user; only admins can
+		// ask for them.
+		if err := authorizeAdmin(r); err != nil {
+			tgw.WriteError("unauthorized request", "err", err)
+			return
+		}
+
+		cleaned, err := engine.DoCleanup(r.Context(), req.Runner, req.RunIDs, tgw)
+		if err != nil {
+			tgw.WriteError("cleanup error", "err", err.Error())
+			return
+		}
+
+		tgw.WriteResult(cleaned)
+	}
+}
@@ -28,9 +28,13 @@ type Daemon struct {
 //
 // * GET /list: sends a `list` request to the daemon. list all test plans and test cases.
 // * GET /describe: sends a `describe` request to the daemon. describes a test plan or test case.
+// * GET /runs: queries the history of the completed tasks.
 // * POST /build: sends a `build` request to the daemon. builds a test plan.
 // * POST /run: sends a `run` request to the daemon. (builds and) runs test case with name `<testplan>/<testcase>`.
 // A type-safe client for this server can be found in the `pkg/client` package.
+//
+// The version 1 of the API, served under /v1, is a JSON API described by the
+// OpenAPI document at /v1/openapi.json; see v1Routes.
 func New(cfg *config.EnvConfig) (srv *Daemon, err error) {
 	srv = new(Daemon)
 
@@ -46,28 +50,11 @@ func New(cfg *config.EnvConfig) (srv *Daemon, err error) {
 
 	r := mux.NewRouter().StrictSlash(true)
 
-	if len(cfg.Daemon.Tokens) > 0 {
-		tokens := map[string]struct{}{}
-		for _, t := range cfg.Daemon.Tokens {
-			tokens[strings.TrimSpace(t)] = struct{}{}
-		}
-
-		r.Use(func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
-				if len(splitToken) == 2 {
-					requestToken := strings.TrimSpace(splitToken[1])
-
-					if _, ok := tokens[requestToken]; ok {
-						next.ServeHTTP(w, r)
-						return
-					}
-				}
-
-				w.WriteHeader(403)
-			})
-		})
+	auth, err := newAuthenticator(cfg.Daemon)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth configuration: %w", err)
 	}
+	r.Use(auth.middleware)
 
 	// Set a unique request ID.
 	r.Use(func(next http.Handler) http.Handler {
@@ -85,6 +72,7 @@ func New(cfg *config.EnvConfig) (srv *Daemon, err error) {
 	r.HandleFunc("/kill", srv.killTaskHandler(engine)).Methods("GET")
 	r.HandleFunc("/delete", srv.deleteHandler(engine)).Methods("GET") // temporary endpoint until we build a proper ACL/admin endpoints within the daemon
 	r.HandleFunc("/tasks", srv.listTasksHandler(engine)).Methods("GET")
+	r.HandleFunc("/runs", srv.runsHandler(engine)).Methods("GET")
 	r.HandleFunc("/logs", srv.getLogsHandler(engine)).Methods("GET")
 	r.HandleFunc("/outputs", srv.getOutputsHandler(engine)).Methods("GET")
 	r.HandleFunc("/journal", srv.getJournalHandler(engine)).Methods("GET")
@@ -92,13 +80,18 @@ func New(cfg *config.EnvConfig) (srv *Daemon, err error) {
 
 	r.HandleFunc("/build", srv.buildHandler(engine)).Methods("POST")
 	r.HandleFunc("/build/purge", srv.buildPurgeHandler(engine)).Methods("POST")
+	r.HandleFunc("/build/artifacts", srv.buildArtifactsHandler(engine)).Methods("POST")
 	r.HandleFunc("/run", srv.runHandler(engine)).Methods("POST")
 	r.HandleFunc("/outputs", srv.outputsHandler(engine)).Methods("POST")
 	r.HandleFunc("/terminate", srv.terminateHandler(engine)).Methods("POST")
+	r.HandleFunc("/cleanup", srv.cleanupHandler(engine)).Methods("POST")
 	r.HandleFunc("/healthcheck", srv.healthcheckHandler(engine)).Methods("POST")
 	r.HandleFunc("/tasks", srv.tasksHandler(engine)).Methods("POST")
 	r.HandleFunc("/status", srv.statusHandler(engine)).Methods("POST")
 	r.HandleFunc("/logs", srv.logsHandler(engine)).Methods("POST")
+	r.HandleFunc("/cancel", srv.cancelHandler(engine)).Methods("POST")
+
+	srv.v1(r.PathPrefix("/v1").Subrouter(), engine)
 
 	srv.doneCh = make(chan struct{})
 	srv.server = &http.Server{
@@ -117,6 +110,37 @@ func New(cfg *config.EnvConfig) (srv *Daemon, err error) {
 	return srv, nil
 }
 
+// bearerToken returns the token of the Authorization header of the request,
+// if any.
+func bearerToken(r *http.Request) string {
+	splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
+	if len(splitToken) != 2 {
+		return ""
+	}
+	return strings.TrimSpace(splitToken[1])
+}
+
+// authorizePriority checks that the request may schedule a task with the
+// given priority: up to the maximum priority of the scheduler, or any
+// priority if it bears one of the priority tokens, or is authenticated as an
+// admin.
+func authorizePriority(cfg config.SchedulerConfig, r *http.Request, priority int) error {
+	if priority <= cfg.MaxPriority {
+		return nil
+	}
+	if id := requestIdentity(r); id.user != "" && id.admin {
+		return nil
+	}
+	if token := bearerToken(r); token != "" {
+		for _, t := range cfg.PriorityTokens {
+			if strings.TrimSpace(t) == token {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("not authorized to schedule tasks with priority %d; the maximum is %d", priority, cfg.MaxPriority)
+}
+
 // Serve starts the server and blocks until the server is closed, either
 // explicitly via Shutdown, or due to a fault condition. It propagates the
 // non-nil err return value from http.Serve.
@@ -33,7 +33,7 @@ func (d *Daemon) dashboardHandler(engine api.Engine) func(w http.ResponseWriter,
 			return
 		}
 
-		tsk, err := engine.GetTask(taskId)
+		tsk, err := authorizeTask(engine, r, taskId)
 		if err != nil {
 			fmt.Fprintf(w, "Cannot get task")
 			return
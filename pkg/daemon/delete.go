@@ -24,6 +24,11 @@ func (d *Daemon) deleteHandler(engine api.Engine) func(w http.ResponseWriter, r
 			return
 		}
 
+		if _, err := authorizeTask(engine, r, taskId); err != nil {
+			fmt.Fprintf(w, "cannot access tsk: %s", err)
+			return
+		}
+
 		err := engine.Kill(taskId)
 		if err != nil {
 			fmt.Fprintf(w, "cannot kill tsk")
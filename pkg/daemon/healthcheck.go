@@ -26,7 +26,24 @@ func (d *Daemon) healthcheckHandler(engine api.Engine) func(w http.ResponseWrite
 			return
 		}
 
-		out, err := engine.DoHealthcheck(r.Context(), req.Runner, req.Fix, tgw)
+		var (
+			ctype api.ComponentType
+			ref   string
+		)
+
+		switch {
+		case req.Builder != "" && req.Runner != "":
+			tgw.WriteError("cannot check a runner and a builder at the same time")
+			return
+		case req.Builder != "":
+			ctype = api.BuilderType
+			ref = req.Builder
+		default:
+			ctype = api.RunnerType
+			ref = req.Runner
+		}
+
+		out, err := engine.DoHealthcheck(r.Context(), ctype, ref, req.Fix, tgw)
 		if err != nil {
 			tgw.WriteError("healthcheck error", "err", err.Error())
 			return
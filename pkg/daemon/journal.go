@@ -24,7 +24,7 @@ func (d *Daemon) getJournalHandler(engine api.Engine) func(w http.ResponseWriter
 			return
 		}
 
-		tsk, err := engine.GetTask(taskId)
+		tsk, err := authorizeTask(engine, r, taskId)
 		if err != nil {
 			fmt.Fprintf(w, "cannot fetch tsk")
 			return
@@ -23,6 +23,11 @@ func (d *Daemon) killTaskHandler(engine api.Engine) func(w http.ResponseWriter,
 			return
 		}
 
+		if _, err := authorizeTask(engine, r, taskId); err != nil {
+			fmt.Fprintf(w, "cannot access tsk: %s", err)
+			return
+		}
+
 		err := engine.Kill(taskId)
 		if err != nil {
 			fmt.Fprintf(w, "cannot kill tsk")
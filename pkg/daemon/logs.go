@@ -26,6 +26,11 @@ func (d *Daemon) logsHandler(engine api.Engine) func(w http.ResponseWriter, r *h
 			return
 		}
 
+		if _, err := authorizeTask(engine, r, req.TaskID); err != nil {
+			tgw.WriteError("error while getting task", "err", err)
+			return
+		}
+
 		tsk, err := engine.Logs(r.Context(), req.TaskID, req.Follow, req.CancelWithContext, w)
 		if err != nil {
 			tgw.WriteError("error while getting task", "err", err)
@@ -51,6 +56,11 @@ func (d *Daemon) getLogsHandler(engine api.Engine) func(w http.ResponseWriter, r
 			return
 		}
 
+		if _, err := authorizeTask(engine, r, taskId); err != nil {
+			fmt.Fprintf(w, "cannot access task: %s", err)
+			return
+		}
+
 		path := filepath.Join(engine.EnvConfig().Dirs().Daemon(), taskId+".out")
 
 		file, err := os.Open(path)
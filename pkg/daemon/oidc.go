@@ -0,0 +1,360 @@
+package daemon
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the signatures of tokens
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/config"
+)
+
+const (
+	// oidcRefreshInterval is how often, at most, the keys of the provider
+	// are refreshed when a token is signed with an unknown key.
+	oidcRefreshInterval = time.Minute
+
+	// oidcLeeway is the clock skew tolerated when checking the validity
+	// period of tokens.
+	oidcLeeway = time.Minute
+)
+
+// oidcVerifier verifies the tokens issued by an OpenID Connect provider,
+// which are JWTs signed with the keys the provider publishes.
+type oidcVerifier struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	lk      sync.Mutex
+	jwksURI string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// newOIDCVerifier returns the verifier of the tokens of the provider. The
+// audience is required: the provider issues tokens for all its clients, which
+// must not be taken for the users of testground.
+func newOIDCVerifier(cfg config.OIDCConfig) (*oidcVerifier, error) {
+	if cfg.Audience == "" {
+		return nil, fmt.Errorf("the oidc provider %s requires an audience", cfg.Issuer)
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "email"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &oidcVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// verify checks the signature, the issuer, the audience and the validity
+// period of a token, and returns the identity of its subject.
+func (v *oidcVerifier) verify(ctx context.Context, token string) (identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return identity{}, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return identity{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return identity{}, fmt.Errorf("malformed token signature: %w", err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return identity{}, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return identity{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.cfg.Issuer {
+		return identity{}, fmt.Errorf("token issued by %q", iss)
+	}
+	if v.cfg.Audience == "" || !contains(stringsClaim(claims["aud"]), v.cfg.Audience) {
+		return identity{}, errors.New("token issued for another audience")
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcLeeway)) {
+		return identity{}, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return identity{}, errors.New("token not valid yet")
+	}
+
+	id := identity{}
+	if id.user, _ = claims[v.cfg.UserClaim].(string); id.user == "" {
+		return identity{}, fmt.Errorf("token has no %s claim", v.cfg.UserClaim)
+	}
+	for _, g := range stringsClaim(claims[v.cfg.GroupsClaim]) {
+		if contains(v.cfg.AdminGroups, g) {
+			id.admin = true
+		}
+	}
+	return id, nil
+}
+
+// key returns the key of the provider with the given ID. The keys are
+// fetched on first use, and refreshed when a key is unknown, since providers
+// rotate them.
+func (v *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.lk.Lock()
+	defer v.lk.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < oidcRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := v.fetchKeys(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch the keys of the provider: %w", err)
+	}
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchKeys fetches the keys of the provider, discovering where it publishes
+// them first.
+func (v *oidcVerifier) fetchKeys(ctx context.Context) error {
+	v.fetched = time.Now()
+
+	if v.jwksURI == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.get(ctx, v.cfg.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return err
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != v.cfg.Issuer {
+			return fmt.Errorf("provider claims to be issuer %q", discovery.Issuer)
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("provider doesn't publish its keys")
+		}
+		v.jwksURI = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.get(ctx, v.jwksURI, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped.
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *oidcVerifier) get(ctx context.Context, url string, res interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// jwk is a JSON Web Key, as published by providers (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifySignature verifies the signature of a token, signed with one of the
+// algorithms of RFC 7518 that use public keys.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	errInvalid := errors.New("invalid token signature")
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			if rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+				return errInvalid
+			}
+			return nil
+		case "PS":
+			if rsa.VerifyPSS(k, hash, digest, sig, nil) != nil {
+				return errInvalid
+			}
+			return nil
+		}
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" {
+			if len(sig) != 2*size {
+				return errInvalid
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(k, digest, r, s) {
+				return errInvalid
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("signing algorithm %q doesn't match the key", alg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// stringsClaim returns the values of a claim that is a string, or an array
+// of strings.
+func stringsClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -34,6 +34,11 @@ func (d *Daemon) outputsHandler(engine api.Engine) func(w http.ResponseWriter, r
 			tgw.WriteResult(result)
 		}()
 
+		if err := authorizeRun(engine, r, req.RunID); err != nil {
+			log.Warnw("collect outputs error", "err", err.Error())
+			return
+		}
+
 		err = engine.DoCollectOutputs(r.Context(), req.RunID, tgw)
 		if err != nil {
 			log.Warnw("collect outputs error", "err", err.Error())
@@ -57,6 +62,11 @@ func (d *Daemon) getOutputsHandler(engine api.Engine) func(w http.ResponseWriter
 			return
 		}
 
+		if err := authorizeRun(engine, r, runId); err != nil {
+			fmt.Fprintf(w, "cannot access run: %s", err)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/tar+gzip")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tgz\"", runId))
 
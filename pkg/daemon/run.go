@@ -41,6 +41,12 @@ func (d *Daemon) runHandler(engine api.Engine) func(w http.ResponseWriter, r *ht
 			return
 		}
 
+		if err := authorizePriority(engine.EnvConfig().Daemon.Scheduler, r, request.Priority); err != nil {
+			tgw.WriteError("unauthorized request", "err", err)
+			return
+		}
+		attribute(r, &request.CreatedBy)
+
 		id, err := engine.QueueRun(request, sources)
 		if err != nil {
 			tgw.WriteError(fmt.Sprintf("engine run error: %s", err))
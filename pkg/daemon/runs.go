@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+)
+
+// runsHandler queries the run history, e.g.
+// GET /runs?plan=network&case=ping-pong&since=2021-06-01T00:00:00Z&limit=20
+func (d *Daemon) runsHandler(engine api.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logging.S().With("req_id", r.Header.Get("X-Request-ID"))
+
+		log.Debugw("handle request", "command", "runs")
+		defer log.Debugw("request handled", "command", "runs")
+
+		tgw := rpc.NewOutputWriter(w, r)
+
+		req, err := parseRunsRequest(r)
+		if err != nil {
+			tgw.WriteError("bad request", "err", err.Error())
+			return
+		}
+		req.User = requestIdentity(r).scope()
+
+		recs, err := engine.Runs(req)
+		if err != nil {
+			tgw.WriteError("runs query", "err", err.Error())
+			return
+		}
+
+		tgw.WriteResult(recs)
+	}
+}
+
+func parseRunsRequest(r *http.Request) (api.RunsRequest, error) {
+	q := r.URL.Query()
+	req := api.RunsRequest{
+		Type:     task.Type(q.Get("type")),
+		TestPlan: q.Get("plan"),
+		TestCase: q.Get("case"),
+		Schedule: q.Get("schedule"),
+	}
+
+	switch req.Type {
+	case "", task.TypeBuild, task.TypeRun:
+	default:
+		return req, fmt.Errorf("unknown task type: %s", req.Type)
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return req, fmt.Errorf("invalid since: %w", err)
+		}
+		req.Since = &since
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid limit: %w", err)
+		}
+		req.Limit = limit
+	}
+	return req, nil
+}
@@ -20,7 +20,7 @@ func (d *Daemon) statusHandler(engine api.Engine) func(w http.ResponseWriter, r
 			return
 		}
 
-		tsk, err := engine.GetTask(req.TaskID)
+		tsk, err := authorizeTask(engine, r, req.TaskID)
 		if err != nil {
 			tgw.Warnw("could not fetch status", "task_id", req.TaskID, "err", err)
 			return
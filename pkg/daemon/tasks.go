@@ -19,8 +19,10 @@ import (
 
 const (
 	EmojiSuccess    string = "&#9989;"
+	EmojiExceptions string = "&#9888;&#65039;"
 	EmojiCanceled   string = "&#9898;"
 	EmojiFailure    string = "&#10060;"
+	EmojiTimeout    string = "&#9200;"
 	EmojiInProgress string = "&#9203;"
 	EmojiScheduled  string = "&#128338;"
 )
@@ -36,6 +38,7 @@ func (d *Daemon) tasksHandler(engine api.Engine) func(w http.ResponseWriter, r *
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		req.User = requestIdentity(r).scope()
 
 		tasks, err := engine.Tasks(req)
 		if err != nil {
@@ -61,6 +64,7 @@ func (d *Daemon) listTasksHandler(engine api.Engine) func(w http.ResponseWriter,
 			Types:  []task.Type{task.TypeBuild, task.TypeRun},
 			States: []task.State{task.StateScheduled, task.StateProcessing, task.StateComplete},
 			Before: &before,
+			User:   requestIdentity(r).scope(),
 		}
 
 		tasks, err := engine.Tasks(req)
@@ -123,23 +127,27 @@ func (d *Daemon) listTasksHandler(engine api.Engine) func(w http.ResponseWriter,
 				t.RenderCreatedBy(),
 			}
 
-			switch t.State().State {
-			case task.StateComplete:
+			switch st := t.State().State; {
+			case st == task.StateCanceled:
+				currentTask.Status = EmojiCanceled
+			case st.Phase() == task.StateComplete:
 				switch outcome {
 				case task.OutcomeSuccess:
 					currentTask.Status = EmojiSuccess
+				case task.OutcomeSuccessWithExceptions:
+					currentTask.Status = EmojiExceptions
 				case task.OutcomeFailure:
 					currentTask.Status = EmojiFailure
+				case task.OutcomeTimeout:
+					currentTask.Status = EmojiTimeout
 				default:
 					currentTask.Status = EmojiFailure
 				}
-			case task.StateCanceled:
-				currentTask.Status = EmojiCanceled
-			case task.StateProcessing:
+			case st.Phase() == task.StateProcessing:
 				currentTask.Status = EmojiInProgress
 				currentTask.Actions = fmt.Sprintf(`<a href=/kill?task_id=%s>kill</a><br/><a onclick="return confirm('Are you sure?');" href=/delete?task_id=%s>delete</a>`, t.ID, t.ID)
 				currentTask.Took = ""
-			case task.StateScheduled:
+			case st == task.StateScheduled:
 				currentTask.Status = EmojiScheduled
 				currentTask.Took = ""
 			}
@@ -26,6 +26,11 @@ func (d *Daemon) terminateHandler(engine api.Engine) func(w http.ResponseWriter,
 			return
 		}
 
+		if err := authorizeAdmin(r); err != nil {
+			tgw.WriteError("unauthorized request", "err", err)
+			return
+		}
+
 		var (
 			ctype api.ComponentType
 			ref   string
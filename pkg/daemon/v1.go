@@ -0,0 +1,561 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+
+	_ "embed"
+
+	"github.com/gorilla/mux"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// openAPI is the OpenAPI document of the version 1 of the API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// v1Route is a route of the version 1 of the API, relative to /v1.
+type v1Route struct {
+	method  string
+	path    string
+	handler func(*Daemon, api.Engine) http.HandlerFunc
+}
+
+// v1Routes are the routes of the version 1 of the API, a JSON API with the
+// usual HTTP semantics meant for third-party clients, unlike the RPC
+// endpoints of the testground client. Every route is documented in
+// openapi.json.
+var v1Routes = []v1Route{
+	{"POST", "/builds", (*Daemon).v1BuildsHandler},
+	{"POST", "/runs", (*Daemon).v1RunsHandler},
+	{"GET", "/runs", (*Daemon).v1HistoryHandler},
+	{"GET", "/tasks", (*Daemon).v1TasksHandler},
+	{"GET", "/tasks/{id}", (*Daemon).v1TaskHandler},
+	{"POST", "/tasks/{id}/cancel", (*Daemon).v1CancelHandler},
+	{"GET", "/tasks/{id}/logs", (*Daemon).v1LogsHandler},
+	{"GET", "/dead-letters", (*Daemon).v1DeadLettersHandler},
+	{"POST", "/dead-letters/{id}/requeue", (*Daemon).v1RequeueDeadLetterHandler},
+	{"POST", "/schedules", (*Daemon).v1CreateScheduleHandler},
+	{"GET", "/schedules", (*Daemon).v1SchedulesHandler},
+	{"GET", "/schedules/{id}", (*Daemon).v1ScheduleHandler},
+	{"DELETE", "/schedules/{id}", (*Daemon).v1DeleteScheduleHandler},
+	{"GET", "/openapi.json", (*Daemon).v1OpenAPIHandler},
+}
+
+// v1 attaches the routes of the version 1 of the API to r.
+func (d *Daemon) v1(r *mux.Router, engine api.Engine) {
+	for _, rt := range v1Routes {
+		r.HandleFunc(rt.path, rt.handler(d, engine)).Methods(rt.method)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.S().Warnw("failed to write response", "err", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, api.ErrorResponse{Error: err.Error()})
+}
+
+// taskStatus is the status of the responses of requests about a task, or a
+// schedule, that failed with err.
+func taskStatus(err error) int {
+	switch {
+	case errors.Is(err, task.ErrNotFound), errors.Is(err, task.ErrScheduleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (d *Daemon) v1BuildsHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request *api.BuildRequest
+		sources, err := consumeV1Request(engine, r, &request)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if sources == nil || sources.PlanDir == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("plan directory not present"))
+			return
+		}
+		if err := authorizePriority(engine.EnvConfig().Daemon.Scheduler, r, request.Priority); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		attribute(r, &request.CreatedBy)
+
+		id, err := engine.QueueBuild(request, sources)
+		writeQueued(w, id, err)
+	}
+}
+
+func (d *Daemon) v1RunsHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request *api.RunRequest
+		sources, err := consumeV1Request(engine, r, &request)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(request.BuildGroups) > 0 && sources == nil {
+			writeJSONError(w, http.StatusBadRequest, errors.New("plan dir required for build"))
+			return
+		}
+		if err := authorizePriority(engine.EnvConfig().Daemon.Scheduler, r, request.Priority); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		attribute(r, &request.CreatedBy)
+
+		id, err := engine.QueueRun(request, sources)
+		writeQueued(w, id, err)
+	}
+}
+
+// consumeV1Request unpacks a build or run request, which is a multipart
+// request like the ones of the RPC endpoints.
+func consumeV1Request(engine api.Engine, r *http.Request, body interface{}) (*api.UnpackedSources, error) {
+	dir := filepath.Join(engine.EnvConfig().Dirs().Work(), "requests", r.Header.Get("X-Request-ID"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory to unpack request: %w", err)
+	}
+	return consumeRunBuildRequest(r, body, dir)
+}
+
+func writeQueued(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, task.ErrQueueFull):
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeJSONError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, api.QueuedResponse{ID: id})
+	}
+}
+
+func (d *Daemon) v1HistoryHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseRunsRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.User = requestIdentity(r).scope()
+
+		recs, err := engine.Runs(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, recs)
+	}
+}
+
+func (d *Daemon) v1TasksHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseTasksRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.User = requestIdentity(r).scope()
+
+		tsks, err := engine.Tasks(req)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if tsks == nil {
+			tsks = []task.Task{}
+		}
+		writeJSON(w, http.StatusOK, tsks)
+	}
+}
+
+// parseTasksRequest parses the filters of a tasks request. States and types
+// can be repeated, and default to all.
+func parseTasksRequest(r *http.Request) (api.TasksRequest, error) {
+	q := r.URL.Query()
+	req := api.TasksRequest{
+		TestPlan: q.Get("plan"),
+		TestCase: q.Get("case"),
+	}
+
+	states := q["state"]
+	if len(states) == 0 {
+		states = []string{string(task.StateScheduled), string(task.StateProcessing), string(task.StateComplete)}
+	}
+	for _, s := range states {
+		switch st := task.State(s); st {
+		case task.StateScheduled, task.StateProcessing, task.StateComplete:
+			req.States = append(req.States, st)
+		default:
+			return req, fmt.Errorf("unknown task state: %s", s)
+		}
+	}
+
+	types := q["type"]
+	if len(types) == 0 {
+		types = []string{string(task.TypeBuild), string(task.TypeRun)}
+	}
+	for _, t := range types {
+		switch tp := task.Type(t); tp {
+		case task.TypeBuild, task.TypeRun:
+			req.Types = append(req.Types, tp)
+		default:
+			return req, fmt.Errorf("unknown task type: %s", t)
+		}
+	}
+
+	// tasks are filtered by creation time, between Before and After.
+	for param, dst := range map[string]**time.Time{"since": &req.Before, "until": &req.After} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s: %w", param, err)
+			}
+			*dst = &t
+		}
+	}
+	return req, nil
+}
+
+func (d *Daemon) v1TaskHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tsk, err := authorizeTask(engine, r, mux.Vars(r)["id"])
+		if err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tsk)
+	}
+}
+
+func (d *Daemon) v1CancelHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := authorizeTask(engine, r, id); err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		if err := engine.Kill(id); err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, api.QueuedResponse{ID: id})
+	}
+}
+
+func (d *Daemon) v1DeadLettersHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tsks, err := engine.DeadLetters(requestIdentity(r).scope())
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tsks)
+	}
+}
+
+func (d *Daemon) v1RequeueDeadLetterHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := authorizeTask(engine, r, id); err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		tsk, err := engine.RequeueDeadLetter(id)
+		switch {
+		case errors.Is(err, task.ErrQueueFull):
+			writeJSONError(w, http.StatusServiceUnavailable, err)
+		case err != nil:
+			writeJSONError(w, taskStatus(err), err)
+		default:
+			writeJSON(w, http.StatusAccepted, tsk)
+		}
+	}
+}
+
+func (d *Daemon) v1CreateScheduleHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request *api.ScheduleRequest
+		sources, err := consumeV1Request(engine, r, &request)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(request.BuildGroups) > 0 && sources == nil {
+			writeJSONError(w, http.StatusBadRequest, errors.New("plan dir required for build"))
+			return
+		}
+		if err := authorizePriority(engine.EnvConfig().Daemon.Scheduler, r, request.Priority); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		attribute(r, &request.CreatedBy)
+
+		sch, err := engine.CreateSchedule(request, sources)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sch)
+	}
+}
+
+func (d *Daemon) v1SchedulesHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schs, err := engine.Schedules(requestIdentity(r).scope())
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, schs)
+	}
+}
+
+func (d *Daemon) v1ScheduleHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sch, err := authorizeSchedule(engine, r, mux.Vars(r)["id"])
+		if err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sch)
+	}
+}
+
+func (d *Daemon) v1DeleteScheduleHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sch, err := authorizeSchedule(engine, r, mux.Vars(r)["id"])
+		if err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		if err := engine.DeleteSchedule(sch.ID); err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sch)
+	}
+}
+
+// v1LogsHandler serves the logs of a task. WebSocket requests get the logs
+// as LogsMessages, ending with the task once the logs are over, and
+// interleaved with the task whenever its state or progress changes; other
+// requests get the logs as text. With follow=true, the logs are streamed
+// until the task is done; with cancel=true, the task is canceled if the
+// client goes away before; with instances=true, the output of the instances
+// that the runner streams live is included.
+func (d *Daemon) v1LogsHandler(engine api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id        = mux.Vars(r)["id"]
+			follow    = r.URL.Query().Get("follow") == "true"
+			cancel    = r.URL.Query().Get("cancel") == "true"
+			instances = r.URL.Query().Get("instances") == "true"
+		)
+
+		if _, err := authorizeTask(engine, r, id); err != nil {
+			writeJSONError(w, taskStatus(err), err)
+			return
+		}
+
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			flusher, _ := w.(http.Flusher)
+			write := func(b []byte) error {
+				_, err := w.Write(b)
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return err
+			}
+			var instance func(*rpc.InstanceOutput) error
+			if instances {
+				instance = func(o *rpc.InstanceOutput) error {
+					return write([]byte(o.String() + "\n"))
+				}
+			}
+			_, err := streamLogs(r, engine, id, follow, cancel, write, instance)
+			if err != nil {
+				logging.S().Warnw("failed to stream logs", "task_id", id, "err", err)
+			}
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			logging.S().Warnw("failed to accept websocket", "task_id", id, "err", err)
+			return
+		}
+		defer conn.Close(websocket.StatusInternalError, "")
+
+		// the context is done when the client closes the connection.
+		ctx := conn.CloseRead(r.Context())
+
+		var lk sync.Mutex
+		write := func(msg *api.LogsMessage) error {
+			lk.Lock()
+			defer lk.Unlock()
+			return wsjson.Write(ctx, conn, msg)
+		}
+
+		var wg sync.WaitGroup
+		if follow {
+			sctx, stop := context.WithCancel(ctx)
+			defer wg.Wait()
+			defer stop()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				streamStates(sctx, engine, id, write)
+			}()
+		}
+
+		var instance func(*rpc.InstanceOutput) error
+		if instances {
+			instance = func(o *rpc.InstanceOutput) error {
+				return write(&api.LogsMessage{Type: api.LogsMessageInstance, Instance: o})
+			}
+		}
+		tsk, err := streamLogs(r.WithContext(ctx), engine, id, follow, cancel, func(b []byte) error {
+			return write(&api.LogsMessage{Type: api.LogsMessageLog, Data: string(b)})
+		}, instance)
+		if err != nil {
+			_ = wsjson.Write(ctx, conn, &api.LogsMessage{Type: api.LogsMessageError, Error: err.Error()})
+			return
+		}
+		if err := wsjson.Write(ctx, conn, &api.LogsMessage{Type: api.LogsMessageEnd, Task: tsk}); err != nil {
+			return
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
+
+// statesInterval is how often the state of a task is checked, while its logs
+// are followed.
+var statesInterval = time.Second
+
+// streamStates writes the task whenever its state or progress changes, until
+// the context is done.
+func streamStates(ctx context.Context, engine api.Engine, id string, write func(*api.LogsMessage) error) {
+	ticker := time.NewTicker(statesInterval)
+	defer ticker.Stop()
+
+	var (
+		last     task.State
+		progress task.Progress
+	)
+	for {
+		tsk, err := engine.GetTask(id)
+		if err != nil {
+			return
+		}
+
+		var p task.Progress
+		if tsk.Progress != nil {
+			p = *tsk.Progress
+		}
+		if st := tsk.State().State; st != last || p != progress {
+			last, progress = st, p
+			if ctx.Err() != nil {
+				return
+			}
+			if err := write(&api.LogsMessage{Type: api.LogsMessageState, Task: tsk}); err != nil {
+				return
+			}
+		}
+		if last.Done() {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamLogs calls fn with the output of the task, as the engine reads it,
+// until the logs are over, and returns the task. The output of the instances
+// goes to instance, unless it's nil.
+func streamLogs(r *http.Request, engine api.Engine, id string, follow, cancel bool, fn func([]byte) error, instance func(*rpc.InstanceOutput) error) (*task.Task, error) {
+	type result struct {
+		tsk *task.Task
+		err error
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan result, 1)
+	go func() {
+		tsk, err := engine.Logs(r.Context(), id, follow, cancel, pw)
+		_ = pw.CloseWithError(err)
+		done <- result{tsk, err}
+	}()
+
+	// the engine writes the output as progress chunks, and instance chunks.
+	dec := json.NewDecoder(pr)
+	var err error
+	for err == nil {
+		var chunk struct {
+			Type    rpc.ChunkType `json:"t"`
+			Payload []byte        `json:"p"`
+		}
+		if err = dec.Decode(&chunk); err != nil {
+			break
+		}
+		switch chunk.Type {
+		case rpc.ChunkTypeProgress:
+			err = fn(chunk.Payload)
+		case rpc.ChunkTypeInstance:
+			if instance == nil {
+				continue
+			}
+			var o rpc.InstanceOutput
+			if err = json.Unmarshal(chunk.Payload, &o); err == nil {
+				err = instance(&o)
+			}
+		}
+	}
+	// unblock the engine, if we stopped reading early.
+	_ = pr.CloseWithError(err)
+
+	res := <-done
+	if err != io.EOF && res.err == nil {
+		return nil, err
+	}
+	return res.tsk, res.err
+}
+
+func (d *Daemon) v1OpenAPIHandler(_ api.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPI)
+	}
+}
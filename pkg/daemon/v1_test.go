@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(openAPI, &doc))
+
+	var documented, served []string
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	for _, rt := range v1Routes {
+		served = append(served, rt.method+" "+rt.path)
+	}
+	sort.Strings(documented)
+	sort.Strings(served)
+	require.Equal(t, served, documented)
+}
+
+func TestParseTasksRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/tasks?state=processing&type=run&type=build&plan=network&since=2021-01-02T15:04:05Z", nil)
+	req, err := parseTasksRequest(r)
+	require.NoError(t, err)
+	require.Equal(t, []task.State{task.StateProcessing}, req.States)
+	require.Equal(t, []task.Type{task.TypeRun, task.TypeBuild}, req.Types)
+	require.Equal(t, "network", req.TestPlan)
+	require.NotNil(t, req.Before)
+	require.Equal(t, 2021, req.Before.Year())
+	require.Nil(t, req.After)
+
+	req, err = parseTasksRequest(httptest.NewRequest("GET", "/v1/tasks", nil))
+	require.NoError(t, err)
+	require.Len(t, req.States, 3)
+	require.Len(t, req.Types, 2)
+
+	_, err = parseTasksRequest(httptest.NewRequest("GET", "/v1/tasks?state=bogus", nil))
+	require.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+)
+
+// Comparison is the result of comparing two runs, a (the baseline) and b.
+type Comparison struct {
+	A        string         `json:"a"`
+	B        string         `json:"b"`
+	OutcomeA task.Outcome   `json:"outcome_a"`
+	OutcomeB task.Outcome   `json:"outcome_b"`
+	Metrics  []*MetricDelta `json:"metrics"`
+}
+
+// MetricDelta compares the value of a single metric across two runs.
+type MetricDelta struct {
+	Name string  `json:"name"`
+	A    float64 `json:"a"`
+	B    float64 `json:"b"`
+
+	// Delta is B - A.
+	Delta float64 `json:"delta"`
+
+	// Percent is the relative change from A to B, in percent. It is nil when
+	// A is zero.
+	Percent *float64 `json:"percent"`
+
+	// Regression is true if the metric moved in the wrong direction by more
+	// than the configured threshold.
+	Regression bool `json:"regression"`
+}
+
+// Regressions returns the metrics flagged as regressions.
+func (c *Comparison) Regressions() []*MetricDelta {
+	var res []*MetricDelta
+	for _, m := range c.Metrics {
+		if m.Regression {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// CompareRuns compares two finished run tasks: their run duration, and the
+// success ratio of every group. The metrics recorded by the instances are
+// compared by CompareMetrics. A metric is flagged as a regression when it
+// gets worse by more than threshold percent; the duration gets worse when it
+// increases, success ratios when they decrease.
+func CompareRuns(a, b *task.Task, threshold float64) (*Comparison, error) {
+	for _, t := range []*task.Task{a, b} {
+		if t.Type != task.TypeRun {
+			return nil, fmt.Errorf("task %s is not a run", t.ID)
+		}
+		if st := t.State().State; !st.Done() {
+			return nil, fmt.Errorf("task %s has not finished; state: %s", t.ID, st)
+		}
+	}
+
+	oa, err := DecodeTaskOutcome(a)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := DecodeTaskOutcome(b)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Comparison{A: a.ID, B: b.ID, OutcomeA: oa, OutcomeB: ob}
+
+	c.Metrics = append(c.Metrics, newMetricDelta("duration_seconds",
+		runDuration(a).Seconds(), runDuration(b).Seconds(), threshold, false))
+
+	ra, rb := DecodeRunnerResult(a.Result), DecodeRunnerResult(b.Result)
+
+	groups := make(map[string]struct{})
+	for g := range ra.Outcomes {
+		groups[g] = struct{}{}
+	}
+	for g := range rb.Outcomes {
+		groups[g] = struct{}{}
+	}
+	names := make([]string, 0, len(groups))
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+
+	ratio := func(outcomes map[string]*runner.GroupOutcome, g string) float64 {
+		o, ok := outcomes[g]
+		if !ok || o == nil || o.Total == 0 {
+			return 0
+		}
+		return float64(o.Ok) / float64(o.Total)
+	}
+
+	for _, g := range names {
+		c.Metrics = append(c.Metrics, newMetricDelta("success_ratio/"+g,
+			ratio(ra.Outcomes, g), ratio(rb.Outcomes, g), threshold, true))
+	}
+
+	return c, nil
+}
+
+func newMetricDelta(name string, a, b float64, threshold float64, higherIsBetter bool) *MetricDelta {
+	m := &MetricDelta{Name: name, A: a, B: b, Delta: b - a}
+	if a != 0 {
+		pct := (b - a) / math.Abs(a) * 100
+		m.Percent = &pct
+	}
+
+	worse := m.Delta > 0
+	if higherIsBetter {
+		worse = m.Delta < 0
+	}
+
+	if worse {
+		// a change from zero is always beyond the threshold.
+		m.Regression = m.Percent == nil || math.Abs(*m.Percent) > threshold
+	}
+	return m
+}
+
+// runDuration returns how long the task was processed for, excluding the time
+// it spent in the queue.
+func runDuration(t *task.Task) time.Duration {
+	for _, s := range t.States {
+		if s.State == task.StateProcessing {
+			return t.State().Created.Sub(s.Created)
+		}
+	}
+	return t.Took()
+}
+
+// CompareMetrics compares the metrics recorded by the instances of two runs,
+// and appends them to the comparison. Every numeric measure of a metric is
+// compared on its own, as <metric>/<measure>; the measure is left out of the
+// name when it's the value of a point or a gauge.
+//
+// The value of a measure in a run is the mean of its values across the
+// instances. An instance contributes the mean of its points, and the last
+// snapshot of any other kind of metric (counters, histograms, timers...),
+// which is cumulative.
+//
+// Metrics get worse when they increase, unless they're listed in
+// higherIsBetter, by name or by <metric>/<measure>. Metrics recorded by only
+// one of the runs are compared against zero.
+func (c *Comparison) CompareMetrics(a, b *RunOutputs, threshold float64, higherIsBetter []string) error {
+	va, err := runMetrics(a)
+	if err != nil {
+		return err
+	}
+	vb, err := runMetrics(b)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[metricKey]struct{}, len(va))
+	for k := range va {
+		keys[k] = struct{}{}
+	}
+	for k := range vb {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]metricKey, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].metric != sorted[j].metric {
+			return sorted[i].metric < sorted[j].metric
+		}
+		return sorted[i].measure < sorted[j].measure
+	})
+
+	higher := make(map[string]bool, len(higherIsBetter))
+	for _, n := range higherIsBetter {
+		higher[n] = true
+	}
+
+	for _, k := range sorted {
+		name := k.metric
+		if k.measure != "value" {
+			name += "/" + k.measure
+		}
+		c.Metrics = append(c.Metrics, newMetricDelta(name,
+			va[k], vb[k], threshold, higher[k.metric] || higher[name]))
+	}
+	return nil
+}
+
+type metricKey struct {
+	metric  string
+	measure string
+}
+
+// runMetrics returns the value of every measure of every metric recorded by
+// the instances of a run; see CompareMetrics.
+func runMetrics(ro *RunOutputs) (map[metricKey]float64, error) {
+	var (
+		sums   = make(map[metricKey]float64)
+		counts = make(map[metricKey]int)
+	)
+
+	for _, inst := range ro.Instances {
+		metrics, err := inst.Metrics(ro.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			values = make(map[metricKey]float64)
+			points = make(map[metricKey]int)
+		)
+		for _, m := range metrics {
+			for measure, v := range m.Measures {
+				f, ok := v.(float64)
+				if !ok {
+					continue
+				}
+				k := metricKey{m.Name, measure}
+				if m.Type != runtime.MetricPoint {
+					values[k] = f
+					continue
+				}
+				values[k] += f
+				points[k]++
+			}
+		}
+
+		for k, v := range values {
+			if n := points[k]; n > 0 {
+				v /= float64(n)
+			}
+			sums[k] += v
+			counts[k]++
+		}
+	}
+
+	for k, n := range counts {
+		sums[k] /= float64(n)
+	}
+	return sums, nil
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+)
+
+func finishedRun(id string, took time.Duration, outcomes map[string]*runner.GroupOutcome) *task.Task {
+	start := time.Now()
+	return &task.Task{
+		ID:   id,
+		Type: task.TypeRun,
+		States: []task.DatedState{
+			{State: task.StateScheduled, Created: start.Add(-time.Hour)},
+			{State: task.StateProcessing, Created: start},
+			{State: task.StateComplete, Created: start.Add(took)},
+		},
+		Result: &runner.Result{
+			Outcome:  task.OutcomeSuccess,
+			Outcomes: outcomes,
+		},
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	a := finishedRun("a", 100*time.Second, map[string]*runner.GroupOutcome{
+		"peers":  {Ok: 10, Total: 10},
+		"seeder": {Ok: 1, Total: 1},
+	})
+	b := finishedRun("b", 105*time.Second, map[string]*runner.GroupOutcome{
+		"peers":  {Ok: 8, Total: 10},
+		"seeder": {Ok: 1, Total: 1},
+	})
+
+	c, err := CompareRuns(a, b, 10)
+	require.NoError(t, err)
+	require.Len(t, c.Metrics, 3)
+
+	// the queueing time is not taken into account.
+	dur := c.Metrics[0]
+	assert.Equal(t, "duration_seconds", dur.Name)
+	assert.InDelta(t, 100, dur.A, 0.001)
+	assert.InDelta(t, 5, *dur.Percent, 0.001)
+	assert.False(t, dur.Regression)
+
+	peers := c.Metrics[1]
+	assert.Equal(t, "success_ratio/peers", peers.Name)
+	assert.InDelta(t, -20, *peers.Percent, 0.001)
+	assert.True(t, peers.Regression)
+
+	assert.False(t, c.Metrics[2].Regression)
+	assert.Len(t, c.Regressions(), 1)
+
+	// a group that only succeeded in the second run is not a regression.
+	b.Result.(*runner.Result).Outcomes["new"] = &runner.GroupOutcome{Ok: 1, Total: 1}
+	c, err = CompareRuns(a, b, 10)
+	require.NoError(t, err)
+	assert.Nil(t, c.Metrics[1].Percent)
+	assert.False(t, c.Metrics[1].Regression)
+}
+
+func TestCompareRunsRequiresFinishedRuns(t *testing.T) {
+	a := finishedRun("a", time.Second, nil)
+	b := finishedRun("b", time.Second, nil)
+	b.States = b.States[:2]
+
+	_, err := CompareRuns(a, b, 10)
+	assert.Error(t, err)
+
+	b = finishedRun("b", time.Second, nil)
+	b.Type = task.TypeBuild
+	_, err = CompareRuns(a, b, 10)
+	assert.Error(t, err)
+}
+
+func TestCompareMetrics(t *testing.T) {
+	a, err := ReadRunOutputs("testdata/compare", "c5s9h7lr8q7ce8fvi3ag")
+	require.NoError(t, err)
+	b, err := ReadRunOutputs("testdata/compare", "c5s9i2tr8q7ce8fvi3b0")
+	require.NoError(t, err)
+
+	c := &Comparison{A: a.ID, B: b.ID}
+	require.NoError(t, c.CompareMetrics(a, b, 10, []string{"peers-found"}))
+
+	metrics := make(map[string]*MetricDelta, len(c.Metrics))
+	for _, m := range c.Metrics {
+		metrics[m.Name] = m
+	}
+	// 2 points, 1 counter, 1 gauge, and the 12 measures of a timer.
+	require.Len(t, metrics, 16)
+	assert.Equal(t, "bootstrapped", c.Metrics[0].Name)
+
+	// points are averaged within an instance, then across instances.
+	ttc := metrics["time-to-connect"]
+	require.NotNil(t, ttc)
+	assert.InDelta(t, 120, ttc.A, 0.001)
+	assert.InDelta(t, 150, ttc.B, 0.001)
+	assert.InDelta(t, 30, ttc.Delta, 0.001)
+	assert.InDelta(t, 25, *ttc.Percent, 0.001)
+	assert.True(t, ttc.Regression)
+
+	// counters take the last snapshot of every instance.
+	bytes := metrics["bytes-received/count"]
+	require.NotNil(t, bytes)
+	assert.InDelta(t, 1500, bytes.A, 0.001)
+	assert.InDelta(t, 0, bytes.Delta, 0.001)
+	assert.False(t, bytes.Regression)
+
+	p95 := metrics["fetch/p95"]
+	require.NotNil(t, p95)
+	assert.InDelta(t, 90, p95.A, 0.001)
+	assert.InDelta(t, 75, p95.B, 0.001)
+	assert.False(t, p95.Regression)
+
+	peers := metrics["peers-found"]
+	require.NotNil(t, peers)
+	assert.InDelta(t, -20, *peers.Percent, 0.001)
+	assert.True(t, peers.Regression)
+
+	// a metric that's missing from the second run is compared against zero.
+	boot := metrics["bootstrapped"]
+	assert.InDelta(t, -1, boot.Delta, 0.001)
+	assert.False(t, boot.Regression)
+
+	assert.Len(t, c.Regressions(), 2)
+}
@@ -0,0 +1,312 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// RunOutputs describes the outputs of a run, as extracted into a directory
+// by `testground collect --dir`: <dir>/<run id>/<group id>/<instance>/...
+type RunOutputs struct {
+	ID  string
+	Dir string
+
+	// HasComposition and HasLogs report whether the composition of the run
+	// (composition.toml) and the daemon logs of the task (run.out) were
+	// collected alongside the outputs of the instances.
+	HasComposition bool
+	HasLogs        bool
+
+	Instances []*InstanceOutputs
+}
+
+// InstanceOutputs describes the outputs of a single instance of a run.
+type InstanceOutputs struct {
+	Group    string
+	Instance string
+
+	// Files are the paths of the files emitted by the instance, relative to
+	// the directory of the run, sorted.
+	Files []string
+
+	// Results is the path of the results file of the instance, relative to
+	// the directory of the run, if the instance emitted one.
+	Results string
+
+	// NetworkResults is the path of the network metrics of the instance,
+	// recorded by the sidecar, relative to the directory of the run, if any.
+	NetworkResults string
+
+	// Chaos is the path of the faults injected to the instance, recorded by
+	// the sidecar, relative to the directory of the run, if any.
+	Chaos string
+}
+
+// Metrics returns the metrics recorded for the instance, by itself and by the
+// sidecar, found in the directory of the run.
+func (inst *InstanceOutputs) Metrics(dir string) ([]*runtime.Metric, error) {
+	var res []*runtime.Metric
+	for _, file := range []string{inst.Results, inst.NetworkResults} {
+		if file == "" {
+			continue
+		}
+		metrics, err := ReadMetrics(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, metrics...)
+	}
+	return res, nil
+}
+
+// ListRunOutputs returns the outputs of all the runs found in dir, sorted by
+// run id.
+func ListRunOutputs(dir string) ([]*RunOutputs, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*RunOutputs
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		ro, err := ReadRunOutputs(dir, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ro)
+	}
+	return res, nil
+}
+
+// ReadRunOutputs returns the outputs of the run with the given id, found in
+// dir.
+func ReadRunOutputs(dir, id string) (*RunOutputs, error) {
+	if id == "" || id != filepath.Base(id) || id == ".." {
+		return nil, fmt.Errorf("invalid run id: %q", id)
+	}
+
+	ro := &RunOutputs{ID: id, Dir: filepath.Join(dir, id)}
+
+	groups, err := ioutil.ReadDir(ro.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if !g.IsDir() {
+			switch g.Name() {
+			case "composition.toml":
+				ro.HasComposition = true
+			case "run.out":
+				ro.HasLogs = true
+			}
+			continue
+		}
+
+		instances, err := ioutil.ReadDir(filepath.Join(ro.Dir, g.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, in := range instances {
+			if !in.IsDir() {
+				continue
+			}
+			inst := &InstanceOutputs{Group: g.Name(), Instance: in.Name()}
+
+			base := filepath.Join(ro.Dir, g.Name(), in.Name())
+			err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+				if err != nil || fi.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(ro.Dir, path)
+				if err != nil {
+					return err
+				}
+				rel = filepath.ToSlash(rel)
+				inst.Files = append(inst.Files, rel)
+				if filepath.Dir(path) == base {
+					switch fi.Name() {
+					case "results.out":
+						inst.Results = rel
+					case "network.out":
+						inst.NetworkResults = rel
+					case api.ChaosOutput:
+						inst.Chaos = rel
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(inst.Files)
+			ro.Instances = append(ro.Instances, inst)
+		}
+	}
+
+	sort.SliceStable(ro.Instances, func(i, j int) bool {
+		a, b := ro.Instances[i], ro.Instances[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return naturalLess(a.Instance, b.Instance)
+	})
+
+	return ro, nil
+}
+
+// ReadMetrics decodes the metrics recorded in a results file, as emitted by
+// the SDK: one JSON-encoded metric per line.
+func ReadMetrics(file string) ([]*runtime.Metric, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		res []*runtime.Metric
+		dec = json.NewDecoder(bufio.NewReader(f))
+	)
+	for dec.More() {
+		m := new(runtime.Metric)
+		if err := dec.Decode(m); err != nil {
+			return nil, fmt.Errorf("failed to decode metric from %s: %w", file, err)
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// ChaosEntry is a fault injected to an instance of a run.
+type ChaosEntry struct {
+	Group    string
+	Instance string
+	api.ChaosEvent
+}
+
+// ChaosTimeline reads the faults injected to all instances of the run, and
+// returns them sorted by time.
+func (ro *RunOutputs) ChaosTimeline() ([]*ChaosEntry, error) {
+	var res []*ChaosEntry
+	for _, inst := range ro.Instances {
+		if inst.Chaos == "" {
+			continue
+		}
+
+		file := filepath.Join(ro.Dir, filepath.FromSlash(inst.Chaos))
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for dec.More() {
+			e := &ChaosEntry{Group: inst.Group, Instance: inst.Instance}
+			if err := dec.Decode(&e.ChaosEvent); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to decode fault from %s: %w", file, err)
+			}
+			res = append(res, e)
+		}
+		f.Close()
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res, nil
+}
+
+// MetricSeries holds the values of one measure of a metric over time, as
+// recorded by a single instance.
+type MetricSeries struct {
+	Metric   string
+	Measure  string
+	Group    string
+	Instance string
+	Points   []MetricPoint
+}
+
+// MetricPoint is a single value of a MetricSeries.
+type MetricPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// MetricSeries reads the results files of all instances of the run, and
+// returns a series for every numeric measure of every metric they recorded,
+// sorted by metric, measure, group and instance.
+func (ro *RunOutputs) MetricSeries() ([]*MetricSeries, error) {
+	var res []*MetricSeries
+	for _, inst := range ro.Instances {
+		metrics, err := inst.Metrics(ro.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		series := make(map[[2]string]*MetricSeries)
+		for _, m := range metrics {
+			for measure, v := range m.Measures {
+				f, ok := v.(float64)
+				if !ok {
+					continue
+				}
+				key := [2]string{m.Name, measure}
+				s, ok := series[key]
+				if !ok {
+					s = &MetricSeries{Metric: m.Name, Measure: measure, Group: inst.Group, Instance: inst.Instance}
+					series[key] = s
+					res = append(res, s)
+				}
+				s.Points = append(s.Points, MetricPoint{Time: time.Unix(0, m.Timestamp), Value: f})
+			}
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		switch {
+		case a.Metric != b.Metric:
+			return a.Metric < b.Metric
+		case a.Measure != b.Measure:
+			return a.Measure < b.Measure
+		case a.Group != b.Group:
+			return a.Group < b.Group
+		default:
+			return naturalLess(a.Instance, b.Instance)
+		}
+	})
+	return res, nil
+}
+
+// naturalLess orders instance directories numerically when they are
+// numbers, so that 10 comes after 9.
+func naturalLess(a, b string) bool {
+	if len(a) != len(b) && isNumeric(a) && isNumeric(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+func writeOutputFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestReadRunOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	run := filepath.Join(dir, "c1")
+	writeOutputFile(t, filepath.Join(run, "composition.toml"), "")
+	writeOutputFile(t, filepath.Join(run, "run.out"), "logs\n")
+	writeOutputFile(t, filepath.Join(run, "peers", "10", "run.out"), "")
+	writeOutputFile(t, filepath.Join(run, "peers", "10", "network.out"),
+		`{"ts":1000000000,"type":"counter","name":"network.tx_bytes","measures":{"count":1500}}`+"\n")
+	writeOutputFile(t, filepath.Join(run, "peers", "9", "run.out"), "")
+	writeOutputFile(t, filepath.Join(run, "peers", "9", "results.out"),
+		`{"ts":1000000000,"type":"point","name":"latency","measures":{"value":3}}`+"\n"+
+			`{"ts":2000000000,"type":"point","name":"latency","measures":{"value":5}}`+"\n")
+	writeOutputFile(t, filepath.Join(run, "peers", "9", "assets", "dump.bin"), "")
+	writeOutputFile(t, filepath.Join(run, "bootstrap", "0", "run.out"), "")
+	writeOutputFile(t, filepath.Join(run, "bootstrap", "0", "chaos.out"),
+		`{"time":"2021-01-01T00:00:02Z","action":"restart","source":"plan"}`+"\n")
+	writeOutputFile(t, filepath.Join(run, "peers", "10", "chaos.out"),
+		`{"time":"2021-01-01T00:00:01Z","action":"pause","duration":1000000000,"source":"composition"}`+"\n")
+
+	runs, err := ListRunOutputs(dir)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+
+	ro := runs[0]
+	require.Equal(t, "c1", ro.ID)
+	require.True(t, ro.HasComposition)
+	require.True(t, ro.HasLogs)
+	require.Len(t, ro.Instances, 3)
+
+	require.Equal(t, "bootstrap", ro.Instances[0].Group)
+	require.Equal(t, "9", ro.Instances[1].Instance)
+	require.Equal(t, "10", ro.Instances[2].Instance)
+
+	require.Equal(t, []string{"peers/9/assets/dump.bin", "peers/9/results.out", "peers/9/run.out"}, ro.Instances[1].Files)
+	require.Equal(t, "peers/9/results.out", ro.Instances[1].Results)
+	require.Empty(t, ro.Instances[2].Results)
+	require.Equal(t, "peers/10/network.out", ro.Instances[2].NetworkResults)
+
+	series, err := ro.MetricSeries()
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	require.Equal(t, "network.tx_bytes", series[1].Metric)
+	require.Equal(t, "10", series[1].Instance)
+	require.Equal(t, 1500.0, series[1].Points[0].Value)
+	require.Equal(t, "latency", series[0].Metric)
+	require.Equal(t, "value", series[0].Measure)
+	require.Equal(t, "9", series[0].Instance)
+	require.Len(t, series[0].Points, 2)
+	require.Equal(t, 5.0, series[0].Points[1].Value)
+	require.Equal(t, int64(2), series[0].Points[1].Time.Unix())
+
+	require.Equal(t, "bootstrap/0/chaos.out", ro.Instances[0].Chaos)
+	chaos, err := ro.ChaosTimeline()
+	require.NoError(t, err)
+	require.Len(t, chaos, 2)
+	require.Equal(t, "10", chaos[0].Instance)
+	require.Equal(t, api.ChaosPause, chaos[0].Action)
+	require.Equal(t, time.Second, chaos[0].Duration)
+	require.Equal(t, "bootstrap", chaos[1].Group)
+	require.Equal(t, "plan", chaos[1].Source)
+
+	_, err = ReadRunOutputs(dir, "../c1")
+	require.Error(t, err)
+}
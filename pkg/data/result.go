@@ -7,6 +7,7 @@ package data
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/testground/testground/pkg/logging"
@@ -18,7 +19,14 @@ func DecodeRunnerResult(result interface{}) *runner.Result {
 	r := &runner.Result{
 		Outcome: task.OutcomeSuccess,
 	}
-	err := mapstructure.Decode(result, r)
+	// the timestamps of the attempts are carried as text.
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+		Result:     r,
+	})
+	if err == nil {
+		err = dec.Decode(result)
+	}
 	if err != nil {
 		logging.S().Errorw("error while decoding result", "err", err)
 	}
@@ -26,25 +34,33 @@ func DecodeRunnerResult(result interface{}) *runner.Result {
 }
 
 func DecodeTaskOutcome(t *task.Task) (task.Outcome, error) {
-	switch t.State().State {
-	case task.StateCanceled:
-		return task.OutcomeCanceled, nil
+	st := t.State().State
+	switch st.Phase() {
 	case task.StateProcessing:
 		return task.OutcomeUnknown, nil
 	case task.StateScheduled:
 		return task.OutcomeUnknown, nil
 	case task.StateComplete:
-		// continue
+		if st == task.StateCanceled {
+			return task.OutcomeCanceled, nil
+		}
 	default:
-		return "", fmt.Errorf("unexpected task state: %s", t.State().State)
+		return "", fmt.Errorf("unexpected task state: %s", st)
 	}
 
 	switch t.Type {
 	case task.TypeBuild:
 		// As of today a build that completed is successful. No need to check the result.
+		if st == task.StateFailed {
+			return task.OutcomeFailure, nil
+		}
 		return task.OutcomeSuccess, nil
 	case task.TypeRun:
-		return DecodeRunnerResult(t.Result).Outcome, nil
+		outcome := DecodeRunnerResult(t.Result).Outcome
+		if st == task.StateFailed && (outcome == task.OutcomeUnknown || outcome == task.OutcomeSuccess) {
+			return task.OutcomeFailure, nil
+		}
+		return outcome, nil
 	default:
 		return "", fmt.Errorf("unexpected task type: %s", t.Type)
 	}
@@ -57,7 +73,7 @@ func IsTaskOutcomeInError(t *task.Task) error {
 		return err
 	}
 
-	if outcome != task.OutcomeSuccess {
+	if !outcome.Passed() {
 		return fmt.Errorf("run outcome: %s", outcome)
 	}
 
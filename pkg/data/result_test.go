@@ -119,3 +119,69 @@ func TestDecodeTaskOutcomeWithLocalExecRunner(t *testing.T) {
 	assert.Equal(t, task.OutcomeSuccess, r)
 	assert.Nil(t, e)
 }
+
+func TestDecodeResultWithAttempts(t *testing.T) {
+	// results reach the client as decoded JSON.
+	result := map[string]interface{}{
+		"outcome": "success",
+		"attempts": []interface{}{
+			map[string]interface{}{
+				"started": "2021-06-01T10:00:00Z",
+				"ended":   "2021-06-01T10:02:30.5Z",
+				"class":   "infra",
+				"error":   "infrastructure failure: pod was evicted",
+			},
+		},
+	}
+	r := DecodeRunnerResult(result)
+	assert.Equal(t, task.OutcomeSuccess, r.Outcome)
+	assert.Len(t, r.Attempts, 1)
+	assert.Equal(t, "infra", r.Attempts[0].Class)
+	assert.Equal(t, 150500*time.Millisecond, r.Attempts[0].Ended.Sub(r.Attempts[0].Started))
+}
+
+func TestDecodeTaskOutcomeWithFailedState(t *testing.T) {
+	failed := []task.DatedState{
+		{
+			State:   task.StateFailed,
+			Created: time.Now(),
+		},
+	}
+
+	// Build that failed => failure outcome
+	r, e := DecodeTaskOutcome(&task.Task{Type: task.TypeBuild, States: failed})
+	assert.Equal(t, task.OutcomeFailure, r)
+	assert.Nil(t, e)
+
+	// Run that failed without an outcome => failure outcome
+	r, e = DecodeTaskOutcome(&task.Task{Type: task.TypeRun, States: failed})
+	assert.Equal(t, task.OutcomeFailure, r)
+	assert.Nil(t, e)
+
+	// Run that timed out => timeout outcome
+	r, e = DecodeTaskOutcome(&task.Task{
+		Type:   task.TypeRun,
+		States: failed,
+		Result: &runner.Result{
+			Outcome: task.OutcomeTimeout,
+		},
+	})
+	assert.Equal(t, task.OutcomeTimeout, r)
+	assert.Nil(t, e)
+}
+
+func TestDecodeTaskOutcomeWhileRunning(t *testing.T) {
+	tested := &task.Task{
+		Type: task.TypeRun,
+		States: []task.DatedState{
+			{
+				State:   task.StateRunning,
+				Created: time.Now(),
+			},
+		},
+	}
+
+	r, e := DecodeTaskOutcome(tested)
+	assert.Equal(t, task.OutcomeUnknown, r)
+	assert.Nil(t, e)
+}
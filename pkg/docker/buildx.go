@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// buildxImage builds an image for the platforms of the options with the
+// buildx plugin of the docker CLI, as the API can't build images for other
+// platforms without a buildkit session. The build runs against the daemon
+// of the client.
+func buildxImage(ctx context.Context, ow *rpc.OutputWriter, client *client.Client, opts *BuildImageOpts) (string, error) {
+	buildOpts := opts.BuildOpts
+	if buildOpts == nil {
+		buildOpts = defaultBuildOptsFor(opts.Name)
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", buildxArgs(opts.BuildCtx, buildOpts, opts.Platforms, opts.Builder)...)
+	cmd.Env = append(os.Environ(), "DOCKER_HOST="+client.DaemonHost())
+
+	var out bytes.Buffer
+	w := io.MultiWriter(&out, ow.StdoutWriter())
+	cmd.Stdout, cmd.Stderr = w, w
+
+	ow.Infow("building image with buildx", "platforms", strings.Join(opts.Platforms, ","))
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("docker buildx build failed: %w", err)
+	}
+	return out.String(), nil
+}
+
+// buildxArgs returns the arguments of the docker CLI that build the context
+// with the options for the platforms. An image for a single platform is
+// loaded in the daemon; an image for several is pushed.
+func buildxArgs(buildCtx string, opts *types.ImageBuildOptions, platforms []string, builder string) []string {
+	args := []string{"buildx", "build", "--progress", "plain", "--platform", strings.Join(platforms, ",")}
+	if builder != "" {
+		args = append(args, "--builder", builder)
+	}
+	if len(platforms) == 1 {
+		args = append(args, "--load")
+	} else {
+		args = append(args, "--push")
+	}
+
+	for _, t := range opts.Tags {
+		args = append(args, "--tag", t)
+	}
+	if opts.Dockerfile != "" {
+		args = append(args, "--file", filepath.Join(buildCtx, opts.Dockerfile))
+	}
+	if opts.NetworkMode != "" {
+		args = append(args, "--network", opts.NetworkMode)
+	}
+	if opts.PullParent {
+		args = append(args, "--pull")
+	}
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+
+	// sorted, so that the commands are reproducible.
+	for _, k := range sortedKeys(opts.Labels) {
+		args = append(args, "--label", k+"="+opts.Labels[k])
+	}
+	buildArgs := make(map[string]string, len(opts.BuildArgs))
+	for k, v := range opts.BuildArgs {
+		if v != nil {
+			buildArgs[k] = *v
+		}
+	}
+	for _, k := range sortedKeys(buildArgs) {
+		args = append(args, "--build-arg", k+"="+buildArgs[k])
+	}
+
+	return append(args, buildCtx)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnsureRemoteBuilder returns the buildx builder instance that builds with the
+// remote BuildKit instance at host, e.g. tcp://buildkitd.example.com:1234,
+// creating it with the remote driver if it doesn't exist yet.
+func EnsureRemoteBuilder(ctx context.Context, client *client.Client, host string) (string, error) {
+	sum := sha256.Sum256([]byte(host))
+	name := "testground-remote-" + hex.EncodeToString(sum[:4])
+	env := append(os.Environ(), "DOCKER_HOST="+client.DaemonHost())
+
+	inspect := exec.CommandContext(ctx, "docker", "buildx", "inspect", name)
+	inspect.Env = env
+	if err := inspect.Run(); err == nil {
+		return name, nil
+	}
+
+	create := exec.CommandContext(ctx, "docker", "buildx", "create", "--name", name, "--driver", "remote", host)
+	create.Env = env
+	if out, err := create.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to create a buildx builder for %s: %w; output: %s", host, err, string(out))
+	}
+	return name, nil
+}
@@ -0,0 +1,30 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildxArgs(t *testing.T) {
+	proxy := "direct"
+	opts := &types.ImageBuildOptions{
+		Tags:        []string{"abc"},
+		Labels:      map[string]string{"b": "2", "a": "1"},
+		BuildArgs:   map[string]*string{"GO_PROXY": &proxy, "UNSET": nil},
+		NetworkMode: "host",
+		Dockerfile:  "plan/Dockerfile",
+	}
+
+	args := buildxArgs("/src", opts, []string{"linux/arm64"}, "")
+	require.Equal(t, []string{
+		"buildx", "build", "--progress", "plain", "--platform", "linux/arm64", "--load",
+		"--tag", "abc", "--file", "/src/plan/Dockerfile", "--network", "host",
+		"--label", "a=1", "--label", "b=2", "--build-arg", "GO_PROXY=direct", "/src",
+	}, args)
+
+	// images for several platforms are pushed.
+	args = buildxArgs("/src", opts, []string{"linux/amd64", "linux/arm64"}, "multi")
+	require.Equal(t, []string{"buildx", "build", "--progress", "plain", "--platform", "linux/amd64,linux/arm64", "--builder", "multi", "--push"}, args[:9])
+}
@@ -17,6 +17,13 @@ type BuildImageOpts struct {
 	Name      string                   // required for EnsureImage
 	BuildCtx  string                   // required
 	BuildOpts *types.ImageBuildOptions // optional
+
+	// Platforms, if set, builds the image for the os/arch pairs with buildx,
+	// e.g. linux/arm64. An image for several platforms is a manifest list,
+	// which the docker daemon can't load, so it's pushed to its tags instead.
+	Platforms []string
+	// Builder is the buildx builder instance; the current one if empty.
+	Builder string
 }
 
 func defaultBuildOptsFor(name string) *types.ImageBuildOptions {
@@ -36,6 +43,10 @@ func defaultBuildOptsFor(name string) *types.ImageBuildOptions {
 // the Name, and the constructed options are sent to the docker client.
 // The build output is directed to stdout via PipeOutput, and also returned from this function.
 func BuildImage(ctx context.Context, ow *rpc.OutputWriter, client *client.Client, opts *BuildImageOpts) (string, error) {
+	if len(opts.Platforms) > 0 {
+		return buildxImage(ctx, ow, client, opts)
+	}
+
 	buildCtx, err := archive.TarWithOptions(opts.BuildCtx, &archive.TarOptions{
 		ExcludePatterns: []string{"plan/_*", "plan.zip"},
 	})
@@ -128,3 +139,24 @@ func GetImageID(ctx context.Context, cli *client.Client, defaultTag string) (str
 	}
 	return images[0].ID[7 : 7+12], nil
 }
+
+// PullImageIfMissing pulls the image `ref` for the platform, e.g. linux/arm64,
+// unless the daemon already has it. An empty platform pulls the one of the
+// daemon.
+func PullImageIfMissing(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client, ref string, platform string) error {
+	_, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return err
+	}
+
+	ow.Infow("pulling image", "image", ref, "platform", platform)
+	out, err := cli.ImagePull(ctx, ref, types.ImagePullOptions{Platform: platform})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	_, err = PipeOutput(out, ow.StdoutWriter())
+	return err
+}
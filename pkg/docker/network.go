@@ -2,6 +2,7 @@ package docker
 
 import (
 	"context"
+	"net"
 
 	"github.com/testground/testground/pkg/rpc"
 
@@ -11,10 +12,20 @@ import (
 	"github.com/docker/docker/client"
 )
 
+// NewBridgeNetwork creates a bridge network with the IPAM configurations; it's
+// dual-stack if one of them has an IPv6 subnet.
 func NewBridgeNetwork(ctx context.Context, cli *client.Client, name string, internal bool, labels map[string]string, config ...network.IPAMConfig) (id string, err error) {
+	var ipv6 bool
+	for _, c := range config {
+		if ip, _, err := net.ParseCIDR(c.Subnet); err == nil && ip.To4() == nil {
+			ipv6 = true
+		}
+	}
+
 	res, err := cli.NetworkCreate(ctx, name, types.NetworkCreate{
 		Driver:     "bridge",
 		Attachable: true,
+		EnableIPv6: ipv6,
 		Internal:   internal,
 		Labels:     labels,
 		IPAM: &network.IPAM{
@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"github.com/testground/testground/pkg/aws"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// RegistryAuth returns the encoded credentials of the registry of the
+// provider, and the repository that the images of the plan are pushed to:
+//
+//   - aws: an ECR repository per region and plan, which is created if needed.
+//   - dockerhub: <repo>/testground, with the credentials of the dockerhub table.
+//   - generic: the repository of the registry table, e.g. a GCR or Harbor one,
+//     with its credentials, if any.
+func RegistryAuth(env config.EnvConfig, provider, plan string) (auth string, repo string, err error) {
+	switch provider {
+	case "aws":
+		token, err := aws.ECR.GetAuthToken(env.AWS)
+		if err != nil {
+			return "", "", err
+		}
+		repo, err = aws.ECR.EnsureRepository(env.AWS, fmt.Sprintf("testground-%s-%s", env.AWS.Region, plan))
+		if err != nil {
+			return "", "", err
+		}
+		return aws.ECR.EncodeAuthToken(token), repo, nil
+
+	case "dockerhub":
+		auth, err := encodeAuth(types.AuthConfig{
+			Username: env.DockerHub.Username,
+			Password: env.DockerHub.AccessToken,
+		})
+		return auth, env.DockerHub.Repo + "/testground", err
+
+	case "generic":
+		if env.Registry.Repo == "" {
+			return "", "", fmt.Errorf("no repository to push to; set repo in the registry table of the configuration")
+		}
+		if env.Registry.Username == "" {
+			return "", env.Registry.Repo, nil
+		}
+		auth, err := encodeAuth(types.AuthConfig{
+			Username: env.Registry.Username,
+			Password: env.Registry.Password,
+		})
+		return auth, env.Registry.Repo, err
+
+	default:
+		return "", "", fmt.Errorf("unknown provider: %s", provider)
+	}
+}
+
+func encodeAuth(auth types.AuthConfig) (string, error) {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// PushImage tags the image as ref, and pushes it with the encoded credentials.
+func PushImage(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client, image, ref, auth string) error {
+	if err := cli.ImageTag(ctx, image, ref); err != nil {
+		return err
+	}
+
+	rc, err := cli.ImagePush(ctx, ref, types.ImagePushOptions{RegistryAuth: auth})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = PipeOutput(rc, ow.StdoutWriter())
+	return err
+}
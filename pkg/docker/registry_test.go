@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/config"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	var env config.EnvConfig
+	_, _, err := RegistryAuth(env, "generic", "dht")
+	require.Error(t, err)
+
+	// anonymous registries have no credentials.
+	env.Registry.Repo = "harbor.example.com/testground"
+	auth, repo, err := RegistryAuth(env, "generic", "dht")
+	require.NoError(t, err)
+	require.Empty(t, auth)
+	require.Equal(t, "harbor.example.com/testground", repo)
+
+	env.Registry.Username, env.Registry.Password = "robot$testground", "secret"
+	auth, _, err = RegistryAuth(env, "generic", "dht")
+	require.NoError(t, err)
+
+	b, err := base64.URLEncoding.DecodeString(auth)
+	require.NoError(t, err)
+	var decoded types.AuthConfig
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	require.Equal(t, "robot$testground", decoded.Username)
+	require.Equal(t, "secret", decoded.Password)
+
+	env.DockerHub.Repo = "user"
+	_, repo, err = RegistryAuth(env, "dockerhub", "dht")
+	require.NoError(t, err)
+	require.Equal(t, "user/testground", repo)
+
+	_, _, err = RegistryAuth(env, "quay", "dht")
+	require.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// artifactsDir is the directory of the work directory holding the metadata of
+// the artifacts built by the engine, one JSON file per artifact.
+const artifactsDir = "artifacts"
+
+func (e *Engine) artifactMetadataPath(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(e.envcfg.Dirs().Work(), artifactsDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// recordArtifact records the metadata of an artifact the engine built: the
+// dependency overrides of the build, the versions of the dependencies it
+// resolved, and its provenance, given the git commit and the hash of its
+// sources.
+func (e *Engine) recordArtifact(plan string, in *api.BuildInput, out *api.BuildOutput, commit, sources string) error {
+	a := &api.Artifact{
+		ID:           out.ArtifactPath,
+		Builder:      out.BuilderID,
+		Plan:         plan,
+		Created:      time.Now(),
+		Dependencies: out.Dependencies,
+	}
+	if len(in.Dependencies) > 0 {
+		a.Overrides = make(map[string]string, len(in.Dependencies))
+		for mod, dep := range in.Dependencies {
+			a.Overrides[mod] = formatOverride(dep)
+		}
+	}
+
+	a.Provenance = &api.Provenance{
+		Builder:      out.BuilderID,
+		Plan:         plan,
+		Commit:       commit,
+		Sources:      sources,
+		Toolchain:    out.Toolchain,
+		Selectors:    in.Selectors,
+		Platforms:    in.Platforms,
+		Config:       in.BuildConfig,
+		Overrides:    a.Overrides,
+		Dependencies: out.Dependencies,
+		Created:      a.Created,
+	}
+	if in.UnpackedSources != nil {
+		a.Provenance.Checksums = goSumChecksums(filepath.Join(in.UnpackedSources.PlanDir, "go.sum"), out.Dependencies)
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	path := e.artifactMetadataPath(a.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// loadArtifact complements a listed artifact with the metadata the engine
+// recorded when it built it, if any.
+func (e *Engine) loadArtifact(a *api.Artifact) {
+	b, err := ioutil.ReadFile(e.artifactMetadataPath(a.ID))
+	if err != nil {
+		return
+	}
+	var rec api.Artifact
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return
+	}
+	a.Overrides, a.Dependencies, a.Provenance = rec.Overrides, rec.Dependencies, rec.Provenance
+}
+
+// provenance returns the provenance the engine recorded for an artifact, if
+// any.
+func (e *Engine) provenance(id string) *api.Provenance {
+	a := &api.Artifact{ID: id}
+	e.loadArtifact(a)
+	return a.Provenance
+}
+
+// goSumChecksums returns the hashes that the go.sum file at path lists for the
+// resolved versions of the dependencies, by module@version.
+func goSumChecksums(path string, deps map[string]string) map[string]string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(deps) == 0 {
+		return nil
+	}
+	sums := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		// <module> <version> <hash>, or <module> <version>/go.mod <hash>.
+		f := strings.Fields(line)
+		if len(f) == 3 && deps[f[0]] == f[1] {
+			sums[f[0]+"@"+f[1]] = f[2]
+		}
+	}
+	return sums
+}
+
+// forgetArtifact removes the metadata recorded for a removed artifact.
+func (e *Engine) forgetArtifact(id string) {
+	_ = os.Remove(e.artifactMetadataPath(id))
+}
+
+// formatOverride formats a dependency override the way it's written in
+// compositions, e.g. ../go-libp2p, v0.18.0 or github.com/user/go-libp2p@v0.18.0.
+func formatOverride(dep api.DependencyTarget) string {
+	switch {
+	case dep.Dir != "" || dep.Version == "":
+		return dep.Target
+	case dep.Target == "":
+		return dep.Version
+	default:
+		return dep.Target + "@" + dep.Version
+	}
+}
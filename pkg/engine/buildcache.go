@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// buildCacheDir is the directory of the work directory holding the entries of
+// the build cache, one JSON file per build key.
+const buildCacheDir = "build-cache"
+
+// buildCacheEntry is the artifact of a build, cached under the hash of its
+// inputs.
+type buildCacheEntry struct {
+	Plan   string           `json:"plan"`
+	Output *api.BuildOutput `json:"output"`
+}
+
+// buildCacheKey returns the hash of the inputs of a build: the builder and its
+// configuration, e.g. the base image, the selectors, the dependency overrides
+// and the platforms of the build, and the contents of the plan, of the SDK and
+// of the extra sources. Builds with the same key produce the same artifact. It
+// also returns the hash of the sources alone, which the provenance of the
+// artifact records.
+func buildCacheKey(builder string, in *api.BuildInput) (key string, sources string, err error) {
+	sources, err = hashSources(in.UnpackedSources)
+	if err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+
+	deps := make([]string, 0, len(in.Dependencies))
+	for mod, dep := range in.Dependencies {
+		deps = append(deps, mod+"="+formatOverride(dep))
+	}
+	sort.Strings(deps)
+
+	selectors := append([]string(nil), in.Selectors...)
+	sort.Strings(selectors)
+
+	// the configuration is a struct, so its JSON encoding is deterministic.
+	inputs, err := json.Marshal(map[string]interface{}{
+		"builder":      builder,
+		"plan":         in.TestPlan,
+		"config":       in.BuildConfig,
+		"selectors":    selectors,
+		"dependencies": deps,
+		"platforms":    in.Platforms,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	h.Write(inputs)
+	h.Write([]byte(sources))
+	return hex.EncodeToString(h.Sum(nil)), sources, nil
+}
+
+// hashSources returns the hash of the contents of the plan, of the SDK and of
+// the extra sources of a build.
+func hashSources(src *api.UnpackedSources) (string, error) {
+	h := sha256.New()
+	for _, dir := range []string{src.PlanDir, src.SDKDir, src.ExtraDir} {
+		if dir == "" {
+			continue
+		}
+		if err := hashDir(h, dir); err != nil {
+			return "", fmt.Errorf("failed to hash the sources at %s: %w", dir, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashDir writes the paths, modes and contents of the files in dir to w, in
+// lexical order.
+func hashDir(w io.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %s\n", filepath.ToSlash(rel), fi.Mode())
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+func (e *Engine) buildCachePath(key string) string {
+	return filepath.Join(e.envcfg.Dirs().Work(), buildCacheDir, key+".json")
+}
+
+// cachedBuild returns the artifact of a previous build with the key, if the
+// builder still has it. Builders that can't list their artifacts aren't
+// cached, as their artifacts may be gone.
+func (e *Engine) cachedBuild(ctx context.Context, bm api.Builder, key string, ow *rpc.OutputWriter) *api.BuildOutput {
+	am, ok := bm.(api.ArtifactManager)
+	if !ok {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(e.buildCachePath(key))
+	if err != nil {
+		return nil
+	}
+	var entry buildCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || entry.Output == nil {
+		return nil
+	}
+
+	artifacts, err := am.ListArtifacts(ctx, e, ow)
+	if err != nil {
+		ow.Warnw("failed to list artifacts; ignoring the build cache", "builder", bm.ID(), "error", err)
+		return nil
+	}
+	for _, a := range artifacts {
+		if a.ID == entry.Output.ArtifactPath || stringInSlice(entry.Output.ArtifactPath, a.Tags) {
+			return entry.Output
+		}
+	}
+
+	// the artifact was removed.
+	_ = os.Remove(e.buildCachePath(key))
+	return nil
+}
+
+// cacheBuild caches the artifact of a build under its key.
+func (e *Engine) cacheBuild(key, plan string, out *api.BuildOutput) error {
+	b, err := json.Marshal(&buildCacheEntry{Plan: plan, Output: out})
+	if err != nil {
+		return err
+	}
+	path := e.buildCachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// purgeBuildCache removes the entries of the build cache of the builder and
+// the plan.
+func (e *Engine) purgeBuildCache(builder, plan string) error {
+	paths, err := filepath.Glob(filepath.Join(e.envcfg.Dirs().Work(), buildCacheDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry buildCacheEntry
+		if err := json.Unmarshal(b, &entry); err != nil || entry.Output == nil {
+			continue
+		}
+		if entry.Plan == plan && entry.Output.BuilderID == builder {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
+)
+
+// defaultMaxCrashes is how many times a task can crash a worker before it's
+// quarantined, unless configured otherwise.
+const defaultMaxCrashes = 3
+
+// crashed handles a task that made a worker panic. The task is queued again,
+// unless it crashed workers too many times already, in which case it's
+// quarantined as a dead letter: a task that crashes the daemon every time it
+// is processed would otherwise keep the workers busy forever.
+func (e *Engine) crashed(tsk *task.Task, r interface{}, stack []byte) {
+	e.deleteSignal(tsk.ID)
+
+	crash := task.Crash{
+		Time:  time.Now().UTC(),
+		Panic: fmt.Sprint(r),
+		Stack: string(stack),
+	}
+	tsk.Crashes = append(tsk.Crashes, crash)
+
+	log := logging.S().With("task_id", tsk.ID, "crashes", len(tsk.Crashes))
+	log.Errorw("task crashed the worker", "panic", crash.Panic, "stack", crash.Stack)
+
+	max := defaultMaxCrashes
+	if e.envcfg != nil && e.envcfg.Daemon.Scheduler.MaxCrashes > 0 {
+		max = e.envcfg.Daemon.Scheduler.MaxCrashes
+	}
+	if len(tsk.Crashes) < max {
+		states, progress := tsk.States, tsk.Progress
+		tsk.States = append(tsk.States, task.DatedState{
+			State:   task.StateScheduled,
+			Created: time.Now().UTC(),
+		})
+		tsk.Progress = nil
+		ev := e.event(api.EventQueued, tsk)
+		err := e.queue.Requeue(tsk)
+		if err == nil {
+			e.post(ev)
+			return
+		}
+		log.Errorw("could not queue crashed task again", "err", err)
+		tsk.States, tsk.Progress = states, progress
+	}
+
+	e.quarantine(tsk)
+}
+
+// quarantine moves a task that crashed to the dead letters, where it stays
+// until it's queued again with RequeueDeadLetter, and records it in the run
+// history, along with the last panic.
+func (e *Engine) quarantine(tsk *task.Task) {
+	last := tsk.Crashes[len(tsk.Crashes)-1]
+	tsk.Error = fmt.Sprintf("quarantined after crashing the daemon %d times: %s", len(tsk.Crashes), last.Panic)
+	tsk.States = append(tsk.States, task.DatedState{
+		State:   task.StateFailed,
+		Created: time.Now().UTC(),
+	})
+
+	if err := e.store.QuarantineTask(tsk); err != nil {
+		logging.S().Errorw("could not quarantine task", "task_id", tsk.ID, "err", err)
+		return
+	}
+	logging.S().Warnw("quarantined task", "task_id", tsk.ID, "crashes", len(tsk.Crashes))
+
+	rec := newRecord(tsk)
+	rec.Panic = last.Panic + "\n\n" + last.Stack
+	if err := e.store.Record(rec); err != nil {
+		logging.S().Errorw("could not record task in the run history", "task_id", tsk.ID, "err", err)
+	}
+	e.notify("", tsk)
+}
+
+// DeadLetters returns the tasks created by the user, or all of them if the
+// user is empty, that were quarantined.
+func (e *Engine) DeadLetters(user string) ([]*task.Task, error) {
+	tsks, err := e.store.DeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	res := tsks[:0]
+	for _, tsk := range tsks {
+		if user == "" || tsk.Owner() == user {
+			res = append(res, tsk)
+		}
+	}
+	return res, nil
+}
+
+// RequeueDeadLetter queues a task that was quarantined again, e.g. once the
+// daemon is fixed, and returns it. Its crashes are forgotten.
+func (e *Engine) RequeueDeadLetter(id string) (*task.Task, error) {
+	tsk, err := e.store.GetDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the storage doesn't know the types of the inputs of tasks, which the
+	// workers need.
+	b, err := json.Marshal(tsk)
+	if err != nil {
+		return nil, err
+	}
+	if tsk, err = UnmarshalTask(b); err != nil {
+		return nil, err
+	}
+
+	tsk.Error, tsk.Crashes, tsk.Progress = "", nil, nil
+	tsk.States = append(tsk.States, task.DatedState{
+		State:   task.StateScheduled,
+		Created: time.Now().UTC(),
+	})
+	ev := e.event(api.EventQueued, tsk)
+	if err := e.queue.Revive(tsk); err != nil {
+		return nil, err
+	}
+
+	e.post(ev)
+	return tsk, nil
+}
@@ -8,35 +8,18 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/rs/xid"
 	"github.com/testground/testground/pkg/api"
-	"github.com/testground/testground/pkg/build"
 	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/logging"
 	"github.com/testground/testground/pkg/rpc"
-	"github.com/testground/testground/pkg/runner"
 	"github.com/testground/testground/pkg/task"
 )
 
-// AllBuilders enumerates all builders known to the system.
-var AllBuilders = []api.Builder{
-	&build.DockerGoBuilder{},
-	&build.ExecGoBuilder{},
-	&build.DockerGenericBuilder{},
-	&build.DockerNodeBuilder{},
-}
-
-// AllRunners enumerates all runners known to the system.
-var AllRunners = []api.Runner{
-	&runner.LocalDockerRunner{},
-	&runner.LocalExecutableRunner{},
-	&runner.ClusterSwarmRunner{},
-	&runner.ClusterK8sRunner{},
-}
-
 // Engine is the central runtime object of the system. It knows about all test
 // plans, builders, and runners. It is supposed to be instantiated as a
 // singleton in all runtimes, whether the testground is run as a CLI tool, or as
@@ -60,6 +43,15 @@ type Engine struct {
 	// by closing a channel, the task is canceled
 	signals   map[string]chan int
 	signalsLk sync.RWMutex
+	// killed holds the tasks killed once a worker popped them, but before it
+	// added their signal; the worker cancels them when it does.
+	killed map[string]struct{}
+	// notifier posts the events of tasks to webhooks; nil if there are none.
+	notifier *notifier
+	// schedulesLk serializes the changes to the schedules of recurring runs,
+	// and rescheduled wakes the scheduler up when they change.
+	schedulesLk sync.Mutex
+	rescheduled chan struct{}
 }
 
 var _ api.Engine = (*Engine)(nil)
@@ -98,6 +90,13 @@ func NewEngine(cfg *EngineConfig) (*Engine, error) {
 	if err != nil {
 		return nil, err
 	}
+	queue.SetWeights(cfg.EnvConfig.Daemon.Scheduler.Weights)
+	queue.SetConcurrency(cfg.EnvConfig.Daemon.Scheduler.Concurrency)
+
+	notifier, err := newNotifier(cfg.EnvConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	e := &Engine{
 		builders: make(map[string]api.Builder, len(cfg.Builders)),
@@ -107,6 +106,10 @@ func NewEngine(cfg *EngineConfig) (*Engine, error) {
 		store:    store,
 		queue:    queue,
 		signals:  make(map[string]chan int),
+		killed:   make(map[string]struct{}),
+		notifier: notifier,
+
+		rescheduled: make(chan struct{}, 1),
 	}
 
 	for _, b := range cfg.Builders {
@@ -117,17 +120,34 @@ func NewEngine(cfg *EngineConfig) (*Engine, error) {
 		e.runners[r.ID()] = r
 	}
 
+	if notifier != nil {
+		go notifier.run()
+	}
+
 	for i := 0; i < cfg.EnvConfig.Daemon.Scheduler.Workers; i++ {
 		go e.worker(i)
 	}
 
+	go e.scheduler()
+
+	if interval, grace := e.janitorConfig(); interval > 0 {
+		go e.janitor(interval, grace)
+	}
+
 	return e, nil
 }
 
+// NewDefaultEngine returns an engine with the builders and runners built into
+// testground, and the ones served by the plugins of the configuration.
 func NewDefaultEngine(ecfg *config.EnvConfig) (*Engine, error) {
+	reg := DefaultRegistry()
+	if err := loadPlugins(reg, ecfg.Daemon.Plugins); err != nil {
+		return nil, err
+	}
+
 	cfg := &EngineConfig{
-		Builders:  AllBuilders,
-		Runners:   AllRunners,
+		Builders:  reg.Builders(),
+		Runners:   reg.Runners(),
 		EnvConfig: ecfg,
 	}
 
@@ -179,7 +199,7 @@ func (e *Engine) ListRunners() map[string]api.Runner {
 
 func (e *Engine) QueueBuild(request *api.BuildRequest, sources *api.UnpackedSources) (string, error) {
 	id := xid.New().String()
-	err := e.queue.Push(&task.Task{
+	tsk := &task.Task{
 		Version:  0,
 		Priority: request.Priority,
 		ID:       id,
@@ -195,36 +215,96 @@ func (e *Engine) QueueBuild(request *api.BuildRequest, sources *api.UnpackedSour
 			},
 		},
 		CreatedBy: task.CreatedBy(request.CreatedBy),
-	})
+	}
+	ev := e.event(api.EventQueued, tsk)
+	if err := e.queue.Push(tsk); err != nil {
+		return "", err
+	}
 
-	return id, err
+	e.post(ev)
+	return id, nil
 }
 
 func (e *Engine) QueueRun(request *api.RunRequest, sources *api.UnpackedSources) (string, error) {
-	var (
-		builders = request.Composition.ListBuilders()
-		runner   = request.Composition.Global.Runner
-	)
+	return e.queueRun(request, sources, "")
+}
+
+// checkRun returns an error if the run request can't be run; see CheckRun.
+func (e *Engine) checkRun(request *api.RunRequest) error {
+	return CheckRun(&request.Composition, &request.Manifest, e.BuilderByName, e.RunnerByName)
+}
 
+// CheckRun returns an error if the composition can't be run: if its runner or
+// builders are unknown, or incompatible, or if the runner can't provide what
+// the test cases require. builders and runners look builders and runners up
+// by ID, e.g. in the engine or in a registry.
+func CheckRun(comp *api.Composition, manifest *api.TestPlanManifest, builders func(string) (api.Builder, bool), runners func(string) (api.Runner, bool)) error {
 	// Get the runner.
-	run, ok := e.runners[runner]
+	run, ok := runners(comp.Global.Runner)
 	if !ok {
-		return "", fmt.Errorf("unknown runner: %s", runner)
+		return fmt.Errorf("unknown runner: %s", comp.Global.Runner)
 	}
 
 	// Check if builders and runner are compatible
-	for _, builder := range builders {
-		if !stringInSlice(builder, run.CompatibleBuilders()) {
-			return "", fmt.Errorf("runner %s is incompatible with builder %s", runner, builder)
+	for _, builder := range comp.ListBuilders() {
+		bm, ok := builders(builder)
+		if !ok {
+			return fmt.Errorf("unknown builder: %s", builder)
+		}
+		if err := CheckCompatible(bm, run); err != nil {
+			return err
+		}
+	}
+
+	// Refuse the run now if the runner can't provide what the test cases
+	// require, rather than once the builds are done.
+	return CheckRequirements(run, comp, manifest)
+}
+
+// PrepareRun prepares a composition for a run, and validates the result. The
+// composition must not be a suite.
+func PrepareRun(comp *api.Composition, manifest *api.TestPlanManifest) (*api.Composition, error) {
+	comp, err := comp.PrepareForRun(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := comp.ValidateForRun(); err != nil {
+		return nil, fmt.Errorf("invalid composition: %w", err)
+	}
+	return comp, nil
+}
+
+// ResolveRun resolves a composition the way a run of it is resolved: the
+// client prepares it for the build, the daemon checks it when it's queued
+// (see CheckRun), and prepares it for the run (see PrepareRun). The checks
+// are skipped if builders or runners are nil.
+func ResolveRun(comp *api.Composition, manifest *api.TestPlanManifest, builders func(string) (api.Builder, bool), runners func(string) (api.Runner, bool)) (*api.Composition, error) {
+	comp, err := comp.PrepareForBuild(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if builders != nil && runners != nil {
+		if err := CheckRun(comp, manifest, builders, runners); err != nil {
+			return nil, err
 		}
 	}
+	return PrepareRun(comp, manifest)
+}
+
+// queueRun queues a run, on behalf of the schedule with the given ID, if
+// any.
+func (e *Engine) queueRun(request *api.RunRequest, sources *api.UnpackedSources, schedule string) (string, error) {
+	if err := e.checkRun(request); err != nil {
+		return "", err
+	}
 
+	runner := request.Composition.Global.Runner
 	id := xid.New().String()
-	err := e.queue.Push(&task.Task{
+	tsk := &task.Task{
 		Version:     0,
 		Priority:    request.Priority,
 		Plan:        request.Composition.Global.Plan,
-		Case:        request.Composition.Global.Case,
+		Case:        taskCase(&request.Composition),
 		ID:          id,
 		Runner:      runner,
 		Type:        task.TypeRun,
@@ -240,17 +320,130 @@ func (e *Engine) QueueRun(request *api.RunRequest, sources *api.UnpackedSources)
 			},
 		},
 		CreatedBy: task.CreatedBy(request.CreatedBy),
-	})
+		Schedule:  schedule,
+	}
+	ev := e.event(api.EventQueued, tsk)
+	if err := e.queue.Push(tsk); err != nil {
+		return "", err
+	}
+
+	e.post(ev)
+	return id, nil
+}
+
+// CheckCompatible returns an error if the runner can't run the artifacts of
+// the builder. A runner that lists compatible builders must list the builder;
+// a runner that describes the artifacts it runs must accept the ones the
+// builder describes. A runner that does neither is compatible with no
+// builder.
+func CheckCompatible(builder api.Builder, runner api.Runner) error {
+	var (
+		listed = runner.CompatibleBuilders()
+		caps   api.Capabilities
+	)
+	if c, ok := runner.(api.Capable); ok {
+		caps = c.Capabilities()
+	}
+
+	if len(listed) > 0 && !stringInSlice(builder.ID(), listed) {
+		return fmt.Errorf("runner %s is incompatible with builder %s", runner.ID(), builder.ID())
+	}
+	if len(listed) == 0 && len(caps.Artifacts) == 0 {
+		return fmt.Errorf("runner %s is incompatible with builder %s: it declares no compatible builders or artifacts", runner.ID(), builder.ID())
+	}
+	if p, ok := builder.(api.ArtifactProducer); ok {
+		if err := caps.Accepts(p.Produces()); err != nil {
+			return fmt.Errorf("runner %s is incompatible with builder %s: %w", runner.ID(), builder.ID(), err)
+		}
+	}
+	return nil
+}
+
+// SelectBuilder returns the first of the builders supported by the test plan
+// that is compatible with the runner, preferring the builders the runner
+// lists, in order.
+func SelectBuilder(builders []api.Builder, runner api.Runner, manifest *api.TestPlanManifest) (api.Builder, error) {
+	candidates := make([]api.Builder, 0, len(builders))
+	for _, id := range runner.CompatibleBuilders() {
+		for _, b := range builders {
+			if b.ID() == id {
+				candidates = append(candidates, b)
+			}
+		}
+	}
+	candidates = append(candidates, builders...)
+
+	for _, b := range candidates {
+		if manifest.HasBuilder(b.ID()) && CheckCompatible(b, runner) == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the builders of test plan %s is compatible with runner %s", manifest.Name, runner.ID())
+}
+
+// CheckRequirements returns an error if the runner doesn't provide the
+// capabilities required by the test case of the composition, or by any of
+// the test cases of a suite, or by the resources and the network profiles of
+// its groups.
+func CheckRequirements(runner api.Runner, comp *api.Composition, manifest *api.TestPlanManifest) error {
+	cases := comp.Global.Cases
+	if len(cases) == 0 {
+		cases = []string{comp.Global.Case}
+	}
+
+	var req api.Requirements
+	for _, name := range cases {
+		if _, tc, ok := manifest.TestCaseByName(name); ok {
+			req = req.Merge(tc.Requires)
+		}
+	}
+	for _, g := range comp.Groups {
+		req.GPUs = req.GPUs || g.Resources.GPUs > 0
+		// chaos is injected by the sidecar, like the network is shaped.
+		req.TrafficShaping = req.TrafficShaping || g.Network != nil || len(g.Chaos) > 0
+	}
+
+	var caps api.Capabilities
+	if c, ok := runner.(api.Capable); ok {
+		caps = c.Capabilities()
+	}
+	if err := caps.Check(req); err != nil {
+		return fmt.Errorf("runner %s can't run test case %s: %w", runner.ID(), strings.Join(cases, ","), err)
+	}
+	return nil
+}
 
-	return id, err
+// taskCase returns the test case of a run task, or the comma-separated test
+// cases of a suite.
+func taskCase(comp *api.Composition) string {
+	if comp.IsSuite() {
+		return strings.Join(comp.Global.Cases, ",")
+	}
+	return comp.Global.Case
 }
 
 func (e *Engine) DoCollectOutputs(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
-	t, err := e.GetTask(runID)
+	taskID, n := api.SplitRunID(runID)
+
+	t, err := e.GetTask(taskID)
 	if err != nil {
 		return fmt.Errorf("could not get task %s: %s", runID, err.Error())
 	}
 
+	if in, ok := t.Input.(*RunInput); ok {
+		switch cases := len(in.Composition.Global.Cases); {
+		case cases > 0 && n == 0:
+			return fmt.Errorf("task %s is a suite; collect the outputs of its cases instead: %s-1 to %s-%d", runID, runID, runID, cases)
+		case n > cases:
+			return fmt.Errorf("no such run: %s", runID)
+		}
+	}
+
+	// Outputs uploaded to an object store are collected from there.
+	if stored, err := e.collectStoredOutputs(ctx, t, runID, ow); stored {
+		return err
+	}
+
 	runner := t.Runner
 	run, ok := e.runners[runner]
 	if !ok {
@@ -309,18 +502,26 @@ func (e *Engine) DoTerminate(ctx context.Context, ctype api.ComponentType, ref s
 	return nil
 }
 
-func (e *Engine) DoHealthcheck(ctx context.Context, runner string, fix bool, ow *rpc.OutputWriter) (*api.HealthcheckReport, error) {
-	run, ok := e.runners[runner]
+func (e *Engine) DoHealthcheck(ctx context.Context, ctype api.ComponentType, ref string, fix bool, ow *rpc.OutputWriter) (*api.HealthcheckReport, error) {
+	var component interface{}
+	var ok bool
+	switch ctype {
+	case api.RunnerType:
+		component, ok = e.runners[ref]
+	case api.BuilderType:
+		component, ok = e.builders[ref]
+	}
+
 	if !ok {
-		return nil, fmt.Errorf("unknown runner: %s", runner)
+		return nil, fmt.Errorf("unknown component: %s (type: %s)", ref, ctype)
 	}
 
-	hc, ok := run.(api.Healthchecker)
+	hc, ok := component.(api.Healthchecker)
 	if !ok {
-		return nil, fmt.Errorf("runner %s does not support healthchecks", runner)
+		return nil, fmt.Errorf("%s %s does not support healthchecks", ctype, ref)
 	}
 
-	ow.Infof("checking runner: %s", runner)
+	ow.Infof("checking %s: %s", ctype, ref)
 
 	return hc.Healthcheck(ctx, e, ow, fix)
 }
@@ -330,9 +531,67 @@ func (e *Engine) DoBuildPurge(ctx context.Context, builder, plan string, ow *rpc
 	if !ok {
 		return fmt.Errorf("unrecognized builder: %s", builder)
 	}
+	if err := e.purgeBuildCache(builder, plan); err != nil {
+		return fmt.Errorf("failed to purge the build cache: %w", err)
+	}
 	return bm.Purge(ctx, plan, ow)
 }
 
+// DoBuildArtifacts selects the artifacts of the specified builder, or of all
+// builders that manage their artifacts if builder is empty, and removes them
+// if remove is set. It returns the selected artifacts.
+func (e *Engine) DoBuildArtifacts(ctx context.Context, builder string, filter api.ArtifactFilter, remove bool, ow *rpc.OutputWriter) ([]*api.Artifact, error) {
+	managers := make(map[string]api.ArtifactManager)
+	if builder != "" {
+		bm, ok := e.builders[builder]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized builder: %s", builder)
+		}
+		am, ok := bm.(api.ArtifactManager)
+		if !ok {
+			return nil, fmt.Errorf("builder %s does not support artifact management", builder)
+		}
+		managers[builder] = am
+	} else {
+		for id, bm := range e.builders {
+			if am, ok := bm.(api.ArtifactManager); ok {
+				managers[id] = am
+			}
+		}
+	}
+
+	var all []*api.Artifact
+	for id, am := range managers {
+		artifacts, err := am.ListArtifacts(ctx, e, ow)
+		if err != nil {
+			if builder == "" {
+				// don't let a single unavailable builder, e.g. with no docker daemon, block the others.
+				ow.Warnw("failed to list artifacts; skipping builder", "builder", id, "error", err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to list artifacts of builder %s: %w", id, err)
+		}
+		for _, a := range artifacts {
+			e.loadArtifact(a)
+		}
+		all = append(all, artifacts...)
+	}
+
+	selected := filter.Select(all, time.Now())
+	if !remove {
+		return selected, nil
+	}
+
+	for _, a := range selected {
+		if err := managers[a.Builder].RemoveArtifact(ctx, e, a, ow); err != nil {
+			return nil, fmt.Errorf("failed to remove artifact %s: %w", a.ID, err)
+		}
+		e.forgetArtifact(a.ID)
+		ow.Infow("removed artifact", "builder", a.Builder, "plan", a.Plan, "artifact", a.ID)
+	}
+	return selected, nil
+}
+
 // EnvConfig returns the EnvConfig for this Engine.
 func (e *Engine) EnvConfig() config.EnvConfig {
 	return *e.envcfg
@@ -388,6 +647,10 @@ func (e *Engine) Tasks(filters api.TasksFilters) ([]task.Task, error) {
 				continue
 			}
 
+			if filters.User != "" && tsk.Owner() != filters.User {
+				continue
+			}
+
 			for _, tp := range filters.Types {
 				if tsk.Type == tp {
 					ires = append([]task.Task{*tsk}, ires...)
@@ -412,14 +675,52 @@ func (e *Engine) GetTask(id string) (*task.Task, error) {
 	return e.store.Get(id)
 }
 
-// Kill closes the signal channel for a given task, which signals to the runner to stop it
+// Kill cancels a task. A task that is being processed is signalled to stop;
+// its worker tears down the run and marks it as canceled. A task that is
+// still queued is removed from the queue and archived as canceled right away.
+// Killing a task that has already finished is a no-op.
 func (e *Engine) Kill(id string) error {
-	e.signalsLk.RLock()
-	if ch, ok := e.signals[id]; ok {
-		close(ch)
+	e.signalsLk.Lock()
+	if e.signalLocked(id) {
+		e.signalsLk.Unlock()
+		return nil
+	}
+
+	// the queue is checked under the lock of the signals, for a worker not
+	// to pop the task and add its signal in between.
+	tsk, err := e.queue.Remove(id)
+	if err == task.ErrNotFound {
+		// not queued nor signalled: either done, or popped by a worker that
+		// hasn't added its signal yet, which then cancels it.
+		var t *task.Task
+		if t, err = e.store.Get(id); err == nil && !t.State().State.Done() {
+			if e.killed == nil {
+				e.killed = make(map[string]struct{})
+			}
+			e.killed[id] = struct{}{}
+		}
+		e.signalsLk.Unlock()
+		return err
 	}
-	e.signalsLk.RUnlock()
+	e.signalsLk.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tsk.Error = context.Canceled.Error()
+	tsk.States = append(tsk.States, task.DatedState{
+		State:   task.StateCanceled,
+		Created: time.Now().UTC(),
+	})
 
+	if err := e.store.PersistScheduled(tsk); err != nil {
+		return err
+	}
+	if err := e.store.ArchiveScheduledTask(tsk); err != nil {
+		return err
+	}
+	e.record(tsk)
+	e.notify("", tsk)
 	return nil
 }
 
@@ -502,6 +803,10 @@ func (e *Engine) Logs(ctx context.Context, id string, follow bool, cancel bool,
 			e.signalsLk.RLock()
 			_, running := e.signals[id]
 			e.signalsLk.RUnlock()
+			if !running {
+				// tasks being retried are queued again.
+				running = e.isScheduled(id)
+			}
 			if !running {
 				time.Sleep(2 * time.Second)
 				close(stop)
@@ -522,11 +827,7 @@ Outer:
 		select {
 		case <-ctx.Done():
 			if cancel {
-				e.signalsLk.RLock()
-				if ch, ok := e.signals[id]; ok {
-					close(ch)
-				}
-				e.signalsLk.RUnlock()
+				e.signal(id)
 			}
 			break Outer
 		default:
@@ -539,6 +840,13 @@ Outer:
 				return nil, fmt.Errorf("error when decoding chunk, err: %w", err)
 			}
 
+			if chunk.Type == rpc.ChunkTypeInstance {
+				if err := relayInstanceOutput(ow, chunk.Payload); err != nil {
+					return nil, fmt.Errorf("error when relaying instance output, err: %w", err)
+				}
+				continue
+			}
+
 			m, err := base64.StdEncoding.DecodeString(chunk.Payload.(string))
 			if err != nil {
 				return nil, fmt.Errorf("error when base64 decoding string, err: %w", err)
@@ -554,6 +862,27 @@ Outer:
 	return e.GetTask(id)
 }
 
+// relayInstanceOutput writes the instance output of an instance chunk, with
+// the payload of the chunk as decoded.
+func relayInstanceOutput(ow *rpc.OutputWriter, payload interface{}) error {
+	s, _ := payload.(string)
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	var o rpc.InstanceOutput
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+	return ow.WriteInstanceOutput(&o)
+}
+
+// isScheduled returns whether the task with the given ID is queued.
+func (e *Engine) isScheduled(id string) bool {
+	tsk, err := e.GetTask(id)
+	return err == nil && tsk.State().State == task.StateScheduled
+}
+
 type tailReader struct {
 	io.ReadCloser
 	stop chan struct{}
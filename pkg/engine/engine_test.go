@@ -1,12 +1,21 @@
 package engine
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/rs/xid"
 	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/runner"
 	"github.com/testground/testground/pkg/task"
 )
 
@@ -96,3 +105,906 @@ func TestUnmarshalTaskBuild(t *testing.T) {
 		t.Errorf("Unmarshal Build task returned incorrect data")
 	}
 }
+
+func TestKillQueuedTask(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{
+		store:   store,
+		queue:   queue,
+		signals: make(map[string]chan int),
+	}
+
+	tsk := &task.Task{
+		ID:   "bt4brhjpc98qra498sg0",
+		Type: task.TypeRun,
+		Input: &RunInput{
+			RunRequest: &api.RunRequest{},
+		},
+		States: []task.DatedState{
+			{
+				State:   task.StateScheduled,
+				Created: time.Now().UTC(),
+			},
+		},
+	}
+	if err := queue.Push(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Kill(tsk.ID); err != nil {
+		t.Fatalf("failed to kill queued task: %s", err)
+	}
+
+	if _, err := queue.Pop(); err != task.ErrQueueEmpty {
+		t.Errorf("expected the killed task to be removed from the queue, got: %v", err)
+	}
+
+	got, err := e.GetTask(tsk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State().State != task.StateCanceled {
+		t.Errorf("expected task to be canceled, was: %s", got.State().State)
+	}
+
+	// killing a finished task is a no-op; killing an unknown one fails.
+	if err := e.Kill(tsk.ID); err != nil {
+		t.Errorf("expected killing a finished task to succeed, got: %s", err)
+	}
+	if err := e.Kill("bt4brhjpc98qra498sh0"); err == nil {
+		t.Errorf("expected killing an unknown task to fail")
+	}
+}
+
+func TestKillRunningTask(t *testing.T) {
+	ch := make(chan int)
+	e := &Engine{
+		signals: map[string]chan int{"bt4brhjpc98qra498sg0": ch},
+	}
+
+	if err := e.Kill("bt4brhjpc98qra498sg0"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ch:
+	default:
+		t.Errorf("expected the task signal channel to be closed")
+	}
+}
+
+func TestKillTwice(t *testing.T) {
+	ch := make(chan int)
+	e := &Engine{
+		signals: map[string]chan int{"bt4brhjpc98qra498sg0": ch},
+	}
+
+	// cancelling followers, and terminate, may signal the same task.
+	if !e.signal("bt4brhjpc98qra498sg0") {
+		t.Fatal("expected the task to be signalled")
+	}
+	if e.signal("bt4brhjpc98qra498sg0") {
+		t.Fatal("expected the task to be signalled once")
+	}
+
+	select {
+	case <-ch:
+	default:
+		t.Errorf("expected the task signal channel to be closed")
+	}
+}
+
+func TestKillPoppedTask(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{
+		store:   store,
+		queue:   queue,
+		signals: make(map[string]chan int),
+	}
+
+	tsk := &task.Task{
+		ID:   "bt4brhjpc98qra498sg0",
+		Type: task.TypeRun,
+		Input: &RunInput{
+			RunRequest: &api.RunRequest{},
+		},
+		States: []task.DatedState{
+			{
+				State:   task.StateScheduled,
+				Created: time.Now().UTC(),
+			},
+		},
+	}
+	if err := queue.Push(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	// a worker popped the task, but hasn't added its signal yet.
+	if _, err := queue.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Kill(tsk.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan int)
+	e.addSignal(tsk.ID, ch)
+
+	select {
+	case <-ch:
+	default:
+		t.Errorf("expected the task signal channel to be closed once added")
+	}
+
+	e.deleteSignal(tsk.ID)
+	if len(e.killed) != 0 {
+		t.Errorf("expected the kill to be forgotten, got: %v", e.killed)
+	}
+}
+
+func TestRunInputBuilt(t *testing.T) {
+	in := &RunInput{RunRequest: &api.RunRequest{
+		BuildGroups: []int{1},
+		Composition: api.Composition{Groups: []*api.Group{{ID: "a"}, {ID: "b"}}},
+	}}
+	if in.built() {
+		t.Fatal("expected the groups to build not to be built")
+	}
+
+	// the run was interrupted after its groups were built.
+	in.Composition.Groups[1].Run.Artifact = "image"
+	if !in.built() {
+		t.Fatal("expected the groups to build to be built")
+	}
+}
+
+func TestCheckTimeouts(t *testing.T) {
+	comp := &api.Composition{
+		Groups: api.Groups{
+			{ID: "a", Timeout: "1m"},
+			{ID: "b"},
+		},
+	}
+	result := func(ok int) *api.RunOutput {
+		return &api.RunOutput{RunID: "run", Result: &runner.Result{
+			Outcome: task.OutcomeFailure,
+			Outcomes: map[string]*runner.GroupOutcome{
+				"a": {Ok: ok, Total: 2},
+				"b": {Ok: 2, Total: 2},
+			},
+		}}
+	}
+
+	// the group completed before its timeout.
+	if _, timedOut := checkTimeouts("run", comp, result(2), nil, 2*time.Minute, false); timedOut {
+		t.Fatal("expected the run not to time out")
+	}
+
+	out, timedOut := checkTimeouts("run", comp, result(1), nil, 2*time.Minute, false)
+	if !timedOut {
+		t.Fatal("expected the run to time out")
+	}
+	res := out.Result.(*runner.Result)
+	if res.Outcome != task.OutcomeTimeout || !res.Outcomes["a"].TimedOut || res.Outcomes["b"].TimedOut {
+		t.Fatalf("expected group a and the run to be timed out; got %s", res)
+	}
+
+	// runs that time out without a result get one.
+	out, timedOut = checkTimeouts("run", comp, nil, context.DeadlineExceeded, time.Minute, true)
+	if !timedOut || out.RunID != "run" || out.Result.(*runner.Result).Outcome != task.OutcomeTimeout {
+		t.Fatalf("expected the run to time out; got %v", out)
+	}
+}
+
+func TestTolerateFailures(t *testing.T) {
+	comp := &api.Composition{
+		Global: api.Global{TotalInstances: 20, AllowFailures: "10%"},
+		Groups: api.Groups{{ID: "a"}},
+	}
+	result := func(ok int) *api.RunOutput {
+		return &api.RunOutput{RunID: "run", Result: &runner.Result{
+			Outcome:  task.OutcomeFailure,
+			Outcomes: map[string]*runner.GroupOutcome{"a": {Ok: ok, Total: 20}},
+		}}
+	}
+	failed := errors.New("2 nodes failed")
+
+	out, ok := tolerateFailures(comp, result(18), failed)
+	if !ok || out.Result.(*runner.Result).Outcome != task.OutcomeSuccessWithExceptions {
+		t.Fatalf("expected the run to pass with exceptions; got %v", out.Result)
+	}
+	if _, ok := tolerateFailures(comp, result(17), failed); ok {
+		t.Fatal("expected too many failures not to be tolerated")
+	}
+	if _, ok := tolerateFailures(comp, result(18), api.NewInfraError(failed)); ok {
+		t.Fatal("expected infrastructure failures not to be tolerated")
+	}
+	if _, ok := tolerateFailures(comp, result(18), context.Canceled); ok {
+		t.Fatal("expected canceled runs not to be tolerated")
+	}
+}
+
+func TestCollectStoredOutputs(t *testing.T) {
+	e := &Engine{envcfg: &config.EnvConfig{}}
+	ow := rpc.Discard()
+
+	// outputs that weren't uploaded are collected from the runner.
+	tsk := &task.Task{Type: task.TypeRun, Result: &runner.Result{Outcome: task.OutcomeSuccess}}
+	if stored, err := e.collectStoredOutputs(context.Background(), tsk, "run", ow); stored || err != nil {
+		t.Fatalf("expected the outputs to be collected from the runner; got %v, %v", stored, err)
+	}
+
+	// uploaded ones from the store, which has to be configured to do so.
+	tsk.Result = map[string]interface{}{
+		"outcome": "success",
+		"cases": []interface{}{
+			map[string]interface{}{"case": "a", "run_id": "run-1", "outcome": "success", "outputs": "s3://tg/run-1.tgz"},
+		},
+	}
+	if stored, _ := e.collectStoredOutputs(context.Background(), tsk, "run-2", ow); stored {
+		t.Fatal("expected the outputs of another case to be collected from the runner")
+	}
+	if stored, err := e.collectStoredOutputs(context.Background(), tsk, "run-1", ow); !stored || err == nil {
+		t.Fatalf("expected collecting without a store to fail; got %v, %v", stored, err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{store: store, queue: queue, signals: make(map[string]chan int)}
+
+	comp := api.Composition{
+		Global: api.Global{Retries: &api.Retries{Attempts: 2, On: []string{api.FailureInfra}}},
+		Groups: api.Groups{{ID: "a", Run: api.Run{Artifact: "image"}}},
+	}
+	tsk := &task.Task{
+		ID:   "bt4brhjpc98qra498sg0",
+		Type: task.TypeRun,
+		Input: &RunInput{
+			RunRequest: &api.RunRequest{Composition: comp, BuildGroups: []int{0}},
+		},
+		States: []task.DatedState{{State: task.StateScheduled, Created: time.Now().UTC()}},
+	}
+	if err := queue.Push(tsk); err != nil {
+		t.Fatal(err)
+	}
+	if tsk, err = queue.Pop(); err != nil {
+		t.Fatal(err)
+	}
+
+	infra := api.NewInfraError(errors.New("sync service unreachable"))
+	if e.retry(tsk, time.Now(), errors.New("test failed"), rpc.Discard()) {
+		t.Fatal("expected test failures not to be retried")
+	}
+	if !e.retry(tsk, time.Now(), infra, rpc.Discard()) {
+		t.Fatal("expected infrastructure failures to be retried")
+	}
+
+	got, err := queue.Pop()
+	if err != nil {
+		t.Fatalf("expected the task to be queued again; got %s", err)
+	}
+	if len(got.Attempts) != 1 || got.Attempts[0].Class != api.FailureInfra {
+		t.Fatalf("expected the failed attempt to be recorded; got %v", got.Attempts)
+	}
+	if in := got.Input.(*RunInput); len(in.BuildGroups) != 0 {
+		t.Fatalf("expected the artifacts to be reused; got build groups %v", in.BuildGroups)
+	}
+
+	// the second attempt is the last one.
+	if e.retry(got, time.Now(), infra, rpc.Discard()) {
+		t.Fatal("expected no more attempts")
+	}
+}
+
+func TestRuns(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{
+		store:   store,
+		queue:   queue,
+		signals: make(map[string]chan int),
+	}
+
+	created := time.Now().UTC().Add(-time.Minute)
+	comp := api.Composition{
+		Global: api.Global{Plan: "network", Case: "ping-pong", Runner: "local:docker"},
+		Groups: []*api.Group{{ID: "a", Run: api.Run{Artifact: "img:a"}}},
+	}
+	run := &task.Task{
+		ID:          "bt4brhjpc98qra498sg0",
+		Type:        task.TypeRun,
+		Plan:        "network",
+		Case:        "ping-pong",
+		Runner:      "local:docker",
+		Composition: comp,
+		CreatedBy:   task.CreatedBy{User: "alice"},
+		Input:       &RunInput{RunRequest: &api.RunRequest{Composition: comp}},
+		Result: &runner.Result{
+			Outcome:  task.OutcomeFailure,
+			Outcomes: map[string]*runner.GroupOutcome{"a": {Ok: 1, Total: 2}},
+		},
+		States: []task.DatedState{
+			{State: task.StateScheduled, Created: created},
+			{State: task.StateProcessing, Created: created.Add(10 * time.Second)},
+			{State: task.StateComplete, Created: created.Add(40 * time.Second)},
+		},
+	}
+	e.record(run)
+
+	// a build that is killed before it starts is recorded as canceled.
+	build := &task.Task{
+		ID:   "bt4brhjpc98qra498sh0",
+		Type: task.TypeBuild,
+		Input: &BuildInput{
+			BuildRequest: &api.BuildRequest{Composition: api.Composition{Global: api.Global{Plan: "network"}}},
+		},
+		States: []task.DatedState{{State: task.StateScheduled, Created: time.Now().UTC()}},
+	}
+	if err := queue.Push(build); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Kill(build.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := e.Runs(api.RunsFilters{TestPlan: "network"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+
+	rec := recs[0]
+	if rec.ID != run.ID || rec.Outcome != task.OutcomeFailure || rec.Summary != "a:1/2" {
+		t.Errorf("unexpected record of the run: %+v", rec)
+	}
+	if rec.Took() != 30*time.Second {
+		t.Errorf("expected the run to have taken 30s, took %s", rec.Took())
+	}
+	if !reflect.DeepEqual(rec.Artifacts, []string{"img:a"}) {
+		t.Errorf("expected the artifacts of the run to be recorded, got %v", rec.Artifacts)
+	}
+	if rec := recs[1]; rec.ID != build.ID || rec.Outcome != task.OutcomeCanceled || !rec.Started.IsZero() {
+		t.Errorf("unexpected record of the build: %+v", rec)
+	}
+
+	recs, err = e.Runs(api.RunsFilters{Type: task.TypeRun})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].ID != run.ID {
+		t.Errorf("expected only the run to be listed, got %v", recs)
+	}
+
+	recs, err = e.Runs(api.RunsFilters{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].ID != run.ID {
+		t.Errorf("expected only the run of alice to be listed, got %v", recs)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := DefaultRegistry()
+	exec, _ := reg.Runner("local:exec")
+	execGo, _ := reg.Builder("exec:go")
+	e := &Engine{
+		store:       store,
+		queue:       queue,
+		builders:    map[string]api.Builder{"exec:go": execGo},
+		runners:     map[string]api.Runner{"local:exec": exec},
+		signals:     make(map[string]chan int),
+		rescheduled: make(chan struct{}, 1),
+	}
+
+	comp := api.Composition{
+		Global: api.Global{Plan: "network", Case: "ping-pong", Builder: "exec:go", Runner: "local:exec"},
+		Groups: []*api.Group{{ID: "a", Run: api.Run{Artifact: "/bin/plan"}}},
+	}
+	request := &api.ScheduleRequest{
+		Cron:       "0 2 * * *",
+		RunRequest: api.RunRequest{Composition: comp, CreatedBy: api.CreatedBy{User: "alice"}},
+	}
+
+	if _, err := e.CreateSchedule(&api.ScheduleRequest{Cron: "0 25 * * *", RunRequest: request.RunRequest}, nil); err == nil {
+		t.Fatal("expected invalid cron expressions to be rejected")
+	}
+	sch, err := e.CreateSchedule(request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a schedule for an unknown runner still records its occurrences.
+	broken := *sch
+	broken.ID = "bt4brhjpc98qra498sh0"
+	broken.Request = json.RawMessage(`{"composition":{"global":{"plan":"network","case":"ping-pong","runner":"remote:gone"}}}`)
+	if err := store.PutSchedule(&broken); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is due right away.
+	now := time.Now()
+	until := now.Add(time.Hour)
+	scheduled := api.TasksFilters{Types: []task.Type{task.TypeRun}, States: []task.State{task.StateScheduled}, After: &until}
+	if next := e.runSchedules(now); next.IsZero() || next.Hour() != 2 || next.Minute() != 0 {
+		t.Fatalf("expected the schedules to be due next at 2:00, got %s", next)
+	}
+	if tsks, _ := e.Tasks(scheduled); len(tsks) != 0 {
+		t.Fatalf("expected no task to be queued, got %d", len(tsks))
+	}
+
+	// two days later, the missed occurrences are skipped.
+	later := now.Add(48 * time.Hour)
+	next := e.runSchedules(later)
+	got, err := e.GetSchedule(sch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastTask != "" || !got.Last.Equal(later.UTC()) {
+		t.Fatalf("expected the missed occurrences to be skipped, got %+v", got)
+	}
+	if tsks, _ := e.Tasks(scheduled); len(tsks) != 0 {
+		t.Fatalf("expected no task to be queued, got %d", len(tsks))
+	}
+
+	// the next occurrence queues a single run.
+	e.runSchedules(next)
+	e.runSchedules(next)
+
+	got, err = e.GetSchedule(sch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastTask == "" || !got.Last.Equal(next.UTC()) {
+		t.Fatalf("expected the occurrence to be recorded, got %+v", got)
+	}
+	tsk, err := e.GetTask(got.LastTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsk.Schedule != sch.ID || tsk.CreatedBy.User != "alice" {
+		t.Errorf("unexpected scheduled task: %+v", tsk)
+	}
+	if tsks, _ := e.Tasks(scheduled); len(tsks) != 1 {
+		t.Errorf("expected a single task to be queued, got %d", len(tsks))
+	}
+
+	recs, err := e.Runs(api.RunsFilters{Schedule: broken.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Outcome != task.OutcomeFailure {
+		t.Errorf("expected the failed occurrence to be recorded, got %v", recs)
+	}
+
+	if schs, _ := e.Schedules("bob"); len(schs) != 0 {
+		t.Errorf("expected bob to have no schedules, got %d", len(schs))
+	}
+	if err := e.DeleteSchedule(sch.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetSchedule(sch.ID); err != task.ErrScheduleNotFound {
+		t.Errorf("expected the schedule to be deleted, got %v", err)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := task.NewQueue(store, 10, UnmarshalTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{store: store, queue: queue, signals: make(map[string]chan int)}
+
+	comp := api.Composition{
+		Global: api.Global{Plan: "network", Case: "ping-pong", Runner: "local:docker"},
+		Groups: api.Groups{{ID: "a", Run: api.Run{Artifact: "image"}}},
+	}
+	tsk := &task.Task{
+		ID:        "bt4brhjpc98qra498sg0",
+		Type:      task.TypeRun,
+		Plan:      "network",
+		Case:      "ping-pong",
+		CreatedBy: task.CreatedBy{User: "alice"},
+		Input:     &RunInput{RunRequest: &api.RunRequest{Composition: comp}},
+		States:    []task.DatedState{{State: task.StateScheduled, Created: time.Now().UTC()}},
+	}
+	if err := queue.Push(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the task is queued again until it crashes the worker too many times.
+	for i := 1; i <= defaultMaxCrashes; i++ {
+		got, err := queue.Pop()
+		if err != nil {
+			t.Fatalf("expected the task to be queued after %d crashes; got %s", i-1, err)
+		}
+		e.crashed(got, "boom", []byte("goroutine 1 [running]:"))
+		queue.Done(got)
+	}
+	if _, err := queue.Pop(); err != task.ErrQueueEmpty {
+		t.Fatalf("expected the task to be quarantined; got %v", err)
+	}
+
+	dead, err := e.DeadLetters("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || len(dead[0].Crashes) != defaultMaxCrashes || dead[0].Crashes[0].Panic != "boom" {
+		t.Fatalf("unexpected dead letters: %v", dead)
+	}
+	if dead, _ := e.DeadLetters("bob"); len(dead) != 0 {
+		t.Errorf("expected bob to have no dead letters, got %d", len(dead))
+	}
+
+	recs, err := e.Runs(api.RunsFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Outcome != task.OutcomeFailure || recs[0].Panic == "" {
+		t.Fatalf("expected the quarantined task to be recorded with its panic, got %v", recs)
+	}
+
+	if _, err := e.RequeueDeadLetter(tsk.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := queue.Pop()
+	if err != nil {
+		t.Fatalf("expected the dead letter to be queued again; got %s", err)
+	}
+	if _, ok := got.Input.(*RunInput); !ok || len(got.Crashes) != 0 || got.Error != "" {
+		t.Errorf("unexpected requeued task: %+v", got)
+	}
+	if dead, _ := e.DeadLetters(""); len(dead) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(dead))
+	}
+}
+
+func TestProgress(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Engine{store: store}
+
+	tsk := &task.Task{
+		ID:     "bt4brhjpc98qra498sg0",
+		Type:   task.TypeRun,
+		States: []task.DatedState{{State: task.StateProcessing, Created: time.Now().UTC()}},
+	}
+	if err := store.PersistProcessing(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	prog := e.newProgress(tsk)
+	prog.stage(task.StateBuilding, 0, 40, "building")
+	prog.update(1, 2, "built 1 of 2 groups")
+	prog.stage(task.StateRunning, 40, 90, "running")
+	prog.update(1, 4, "ran 1 of 4 test cases")
+
+	got, err := store.Get(tsk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []task.State
+	for _, st := range got.States {
+		states = append(states, st.State)
+	}
+	if want := []task.State{task.StateProcessing, task.StateBuilding, task.StateRunning}; !reflect.DeepEqual(states, want) {
+		t.Fatalf("expected states %v; got %v", want, states)
+	}
+	if got.Progress == nil || got.Progress.Percent != 52 || got.Progress.Message != "ran 1 of 4 test cases" {
+		t.Fatalf("expected the progress to be persisted; got %+v", got.Progress)
+	}
+
+	// staying in the same state doesn't record it twice.
+	prog.stage(task.StateRunning, 40, 90, "running again")
+	if n := len(tsk.States); n != 3 {
+		t.Fatalf("expected 3 states; got %d", n)
+	}
+
+	// a nil progress tracks nothing.
+	var none *progress
+	none.stage(task.StateRunning, 0, 100, "")
+	none.update(1, 1, "")
+}
+
+type fakeCleaner struct {
+	leftovers []string
+	cleaned   []string
+}
+
+func (*fakeCleaner) ID() string                   { return "fake:cleaner" }
+func (*fakeCleaner) ConfigType() reflect.Type     { return reflect.TypeOf(struct{}{}) }
+func (*fakeCleaner) CompatibleBuilders() []string { return nil }
+
+func (*fakeCleaner) Run(context.Context, *api.RunInput, *rpc.OutputWriter) (*api.RunOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (*fakeCleaner) CollectOutputs(context.Context, *api.CollectionInput, *rpc.OutputWriter) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakeCleaner) Cleanup(_ context.Context, runID string, _ *rpc.OutputWriter) error {
+	f.cleaned = append(f.cleaned, runID)
+	return nil
+}
+
+func (f *fakeCleaner) Leftovers(context.Context) ([]string, error) {
+	return f.leftovers, nil
+}
+
+func TestCleanup(t *testing.T) {
+	store, err := task.NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		now = time.Now().UTC()
+		ids = make([]string, 5)
+	)
+	for i := range ids {
+		ids[i] = xid.New().String()
+	}
+	put := func(id string, st task.State, at time.Time) {
+		tsk := &task.Task{
+			ID:     id,
+			Type:   task.TypeRun,
+			States: []task.DatedState{{State: st, Created: at}},
+		}
+		if st == task.StateScheduled {
+			if err := store.PersistScheduled(tsk); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		if err := store.PersistProcessing(tsk); err != nil {
+			t.Fatal(err)
+		}
+		if st.Done() {
+			if err := store.ArchiveTask(tsk); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	put(ids[0], task.StateComplete, now.Add(-2*time.Hour)) // a suite, over for long.
+	put(ids[1], task.StateFailed, now.Add(-5*time.Minute))
+	put(ids[2], task.StateRunning, now)
+	put(ids[3], task.StateScheduled, now)
+
+	cleaner := &fakeCleaner{leftovers: []string{ids[0] + "-1", ids[0] + "-2", ids[1], ids[2], ids[3], ids[4]}}
+	e := &Engine{
+		store:   store,
+		signals: map[string]chan int{ids[2]: make(chan int)},
+		runners: map[string]api.Runner{
+			cleaner.ID(): cleaner,
+			"local:exec": &runner.LocalExecutableRunner{},
+		},
+	}
+
+	// the janitor leaves the runs that are recent, or in progress, alone; the
+	// last one is unknown to the daemon.
+	cleaned, err := e.cleanup(context.Background(), "", nil, time.Hour, rpc.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{ids[0] + "-1", ids[0] + "-2", ids[4]}; !reflect.DeepEqual(cleaned, want) {
+		t.Fatalf("expected the janitor to clean up after %v; got %v", want, cleaned)
+	}
+
+	cleaner.cleaned = nil
+	cleaned, err = e.DoCleanup(context.Background(), "", []string{ids[0], ids[1]}, rpc.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{ids[0] + "-1", ids[0] + "-2", ids[1]}; !reflect.DeepEqual(cleaned, want) || !reflect.DeepEqual(cleaner.cleaned, want) {
+		t.Fatalf("expected to clean up after %v; got %v", want, cleaned)
+	}
+
+	if _, err := e.DoCleanup(context.Background(), "", []string{ids[2]}, rpc.Discard()); err == nil {
+		t.Errorf("expected an error cleaning up after a run in progress")
+	}
+	if _, err := e.DoCleanup(context.Background(), "local:exec", nil, rpc.Discard()); err == nil {
+		t.Errorf("expected an error cleaning up with a runner that can't")
+	}
+}
+
+func TestRecordArtifact(t *testing.T) {
+	home, err := ioutil.TempDir("", "tghome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	envcfg := config.EnvConfig{}.WithHome(home)
+	e := &Engine{envcfg: &envcfg}
+
+	in := &api.BuildInput{Dependencies: map[string]api.DependencyTarget{
+		"github.com/libp2p/go-libp2p":      {Target: "github.com/user/go-libp2p", Version: "v0.18.1"},
+		"github.com/libp2p/go-libp2p-core": {Target: "/home/user/go-libp2p-core", Dir: "/tmp/extra/go-libp2p-core"},
+	}}
+	out := &api.BuildOutput{
+		BuilderID:    "docker:go",
+		ArtifactPath: "sha256:abcd",
+		Dependencies: map[string]string{"github.com/libp2p/go-libp2p": "v0.18.1"},
+	}
+	gosum := "github.com/libp2p/go-libp2p v0.18.0 h1:old=\n" +
+		"github.com/libp2p/go-libp2p v0.18.1 h1:new=\n" +
+		"github.com/libp2p/go-libp2p v0.18.1/go.mod h1:mod=\n"
+	if err := ioutil.WriteFile(filepath.Join(home, "go.sum"), []byte(gosum), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in.UnpackedSources = &api.UnpackedSources{PlanDir: home}
+	if err := e.recordArtifact("dht", in, out, "0123abcd+dirty", "cafe"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &api.Artifact{ID: "sha256:abcd", Builder: "docker:go"}
+	e.loadArtifact(a)
+	expected := map[string]string{
+		"github.com/libp2p/go-libp2p":      "github.com/user/go-libp2p@v0.18.1",
+		"github.com/libp2p/go-libp2p-core": "/home/user/go-libp2p-core",
+	}
+	if !reflect.DeepEqual(a.Overrides, expected) {
+		t.Errorf("expected overrides %v, got %v", expected, a.Overrides)
+	}
+	if !reflect.DeepEqual(a.Dependencies, out.Dependencies) {
+		t.Errorf("expected dependencies %v, got %v", out.Dependencies, a.Dependencies)
+	}
+
+	p := e.provenance("sha256:abcd")
+	if p == nil || p.Commit != "0123abcd+dirty" || p.Sources != "cafe" || p.Builder != "docker:go" {
+		t.Fatalf("unexpected provenance: %+v", p)
+	}
+	if sums := map[string]string{"github.com/libp2p/go-libp2p@v0.18.1": "h1:new="}; !reflect.DeepEqual(p.Checksums, sums) {
+		t.Errorf("expected checksums %v, got %v", sums, p.Checksums)
+	}
+
+	e.forgetArtifact(a.ID)
+	a = &api.Artifact{ID: "sha256:abcd"}
+	e.loadArtifact(a)
+	if a.Overrides != nil || a.Dependencies != nil {
+		t.Errorf("expected the metadata of the artifact to be removed, got %v", a)
+	}
+}
+
+type fakeArtifactBuilder struct {
+	artifacts []*api.Artifact
+}
+
+func (*fakeArtifactBuilder) ID() string               { return "fake:builder" }
+func (*fakeArtifactBuilder) ConfigType() reflect.Type { return reflect.TypeOf(struct{}{}) }
+
+func (*fakeArtifactBuilder) Build(context.Context, *api.BuildInput, *rpc.OutputWriter) (*api.BuildOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (*fakeArtifactBuilder) Purge(context.Context, string, *rpc.OutputWriter) error {
+	return nil
+}
+
+func (f *fakeArtifactBuilder) ListArtifacts(context.Context, api.Engine, *rpc.OutputWriter) ([]*api.Artifact, error) {
+	return f.artifacts, nil
+}
+
+func (*fakeArtifactBuilder) RemoveArtifact(context.Context, api.Engine, *api.Artifact, *rpc.OutputWriter) error {
+	return nil
+}
+
+func TestBuildCache(t *testing.T) {
+	home, err := ioutil.TempDir("", "tghome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	plan := filepath.Join(home, "plan")
+	if err := os.MkdirAll(plan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(plan, "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := &api.BuildInput{
+		TestPlan:        "network",
+		UnpackedSources: &api.UnpackedSources{PlanDir: plan},
+		Dependencies:    map[string]api.DependencyTarget{"github.com/libp2p/go-libp2p": {Version: "v0.18.0"}},
+	}
+	key, _, err := buildCacheKey("fake:builder", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key changes with the dependencies, and with the sources.
+	in.Dependencies["github.com/libp2p/go-libp2p"] = api.DependencyTarget{Version: "v0.18.1"}
+	if k, _, _ := buildCacheKey("fake:builder", in); k == key {
+		t.Error("expected the key to change with the dependencies")
+	}
+	in.Dependencies["github.com/libp2p/go-libp2p"] = api.DependencyTarget{Version: "v0.18.0"}
+	if k, _, _ := buildCacheKey("fake:builder", in); k != key {
+		t.Error("expected the key to be stable")
+	}
+	if err := ioutil.WriteFile(filepath.Join(plan, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if k, _, _ := buildCacheKey("fake:builder", in); k == key {
+		t.Error("expected the key to change with the sources")
+	}
+
+	envcfg := config.EnvConfig{}.WithHome(home)
+	e := &Engine{envcfg: &envcfg}
+	bm := &fakeArtifactBuilder{}
+	ow := rpc.Discard()
+
+	out := &api.BuildOutput{BuilderID: bm.ID(), ArtifactPath: "1a2b3c4d5e6f"}
+	if err := e.cacheBuild(key, "network", out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the artifact is only reused while the builder has it.
+	if res := e.cachedBuild(context.Background(), bm, key, ow); res != nil {
+		t.Errorf("expected a cache miss for a removed artifact, got %v", res)
+	}
+	if err := e.cacheBuild(key, "network", out); err != nil {
+		t.Fatal(err)
+	}
+	bm.artifacts = []*api.Artifact{{ID: "1a2b3c4d5e6f", Builder: bm.ID()}}
+	if res := e.cachedBuild(context.Background(), bm, key, ow); res == nil || res.ArtifactPath != out.ArtifactPath {
+		t.Errorf("expected a cache hit, got %v", res)
+	}
+
+	if err := e.purgeBuildCache(bm.ID(), "network"); err != nil {
+		t.Fatal(err)
+	}
+	if res := e.cachedBuild(context.Background(), bm, key, ow); res != nil {
+		t.Errorf("expected the cache to be purged, got %v", res)
+	}
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/data"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+)
+
+// Runs returns the records of the run history matching the filters.
+func (e *Engine) Runs(filters api.RunsFilters) ([]*task.Record, error) {
+	var since time.Time
+	if filters.Since != nil {
+		since = filters.Since.UTC()
+	}
+
+	recs, err := e.store.History(filters.TestPlan, filters.TestCase, since, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res := recs[:0]
+	for _, rec := range recs {
+		if filters.User != "" && rec.CreatedBy.User != filters.User {
+			continue
+		}
+		if filters.Schedule != "" && rec.Schedule != filters.Schedule {
+			continue
+		}
+		if filters.Type == "" || rec.Type == filters.Type {
+			res = append(res, rec)
+		}
+	}
+	if filters.Limit > 0 && len(res) > filters.Limit {
+		res = res[len(res)-filters.Limit:]
+	}
+	return res, nil
+}
+
+// record adds a task that completed to the run history.
+func (e *Engine) record(tsk *task.Task) {
+	if err := e.store.Record(newRecord(tsk)); err != nil {
+		logging.S().Errorw("could not record task in the run history", "task_id", tsk.ID, "err", err)
+	}
+}
+
+// newRecord summarizes a task that completed, for the run history.
+func newRecord(tsk *task.Task) *task.Record {
+	rec := &task.Record{
+		ID:          tsk.ID,
+		Type:        tsk.Type,
+		Plan:        tsk.Plan,
+		Case:        tsk.Case,
+		Runner:      tsk.Runner,
+		State:       tsk.State().State,
+		Outcome:     task.OutcomeUnknown,
+		Error:       tsk.Error,
+		Created:     tsk.Created(),
+		Ended:       tsk.State().Created,
+		CreatedBy:   tsk.CreatedBy,
+		Composition: tsk.Composition,
+		Schedule:    tsk.Schedule,
+	}
+	for _, st := range tsk.States {
+		if st.State == task.StateProcessing {
+			rec.Started = st.Created
+			break
+		}
+	}
+
+	switch input := tsk.Input.(type) {
+	case *RunInput:
+		for _, g := range input.Composition.Groups {
+			if g.Run.Artifact != "" {
+				rec.Artifacts = append(rec.Artifacts, g.Run.Artifact)
+			}
+		}
+	case *BuildInput:
+		rec.Plan = input.Composition.Global.Plan
+		rec.Composition = input.Composition
+	}
+
+	switch res := tsk.Result.(type) {
+	case *runner.Result:
+		rec.Outcome, rec.Summary = res.Outcome, res.StringOutcomes()
+	case []string:
+		rec.Artifacts = res
+	case nil:
+	default:
+		dec := data.DecodeRunnerResult(res)
+		rec.Outcome, rec.Summary = dec.Outcome, dec.StringOutcomes()
+	}
+
+	switch {
+	case rec.State == task.StateCanceled:
+		rec.Outcome = task.OutcomeCanceled
+	case tsk.Type == task.TypeBuild && tsk.Error == "":
+		rec.Outcome = task.OutcomeSuccess
+	case tsk.Error != "" && (rec.Outcome == task.OutcomeUnknown || rec.Outcome == task.OutcomeSuccess):
+		rec.Outcome = task.OutcomeFailure
+	}
+	return rec
+}
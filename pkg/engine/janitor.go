@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+)
+
+const (
+	// defaultJanitorInterval is how often the janitor makes its rounds, unless
+	// configured otherwise.
+	defaultJanitorInterval = 10 * time.Minute
+
+	// defaultJanitorGrace is how long the janitor leaves the runs that are
+	// over alone, unless configured otherwise.
+	defaultJanitorGrace = time.Hour
+)
+
+// janitorConfig returns how often the janitor makes its rounds, zero if it's
+// disabled, and how long it leaves the runs that are over alone.
+func (e *Engine) janitorConfig() (interval, grace time.Duration) {
+	interval, grace = defaultJanitorInterval, defaultJanitorGrace
+	if e.envcfg == nil {
+		return interval, grace
+	}
+
+	cfg := e.envcfg.Daemon.Janitor
+	switch {
+	case cfg.IntervalMin < 0:
+		interval = 0
+	case cfg.IntervalMin > 0:
+		interval = time.Duration(cfg.IntervalMin) * time.Minute
+	}
+	if cfg.GraceMin > 0 {
+		grace = time.Duration(cfg.GraceMin) * time.Minute
+	}
+	return interval, grace
+}
+
+// janitor removes the leftovers of the runs that have been over for the grace
+// period every interval, until the engine is closed. Runs leave containers,
+// pods or networks behind when they, or the daemon, crash.
+func (e *Engine) janitor(interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ow := rpc.NewStdoutWriter().With("component", "janitor")
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.ctx.Done():
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(e.ctx, interval)
+		if _, err := e.cleanup(ctx, "", nil, grace, ow); err != nil {
+			logging.S().Warnw("janitor failed to clean up after runs", "err", err)
+		}
+		cancel()
+	}
+}
+
+// DoCleanup removes the leftovers of the runs with the specified runner, or
+// with all the runners that support it if empty. The runs are those of the
+// given IDs, which may be the IDs of the tasks of suites, or all the runs
+// that are over if there are none. It returns the IDs of the runs it cleaned
+// up after.
+func (e *Engine) DoCleanup(ctx context.Context, runner string, runIDs []string, ow *rpc.OutputWriter) ([]string, error) {
+	for _, id := range runIDs {
+		if e.inProgress(id) {
+			return nil, fmt.Errorf("run %s is in progress; cancel its task first", id)
+		}
+	}
+	return e.cleanup(ctx, runner, runIDs, 0, ow)
+}
+
+// cleanup removes the leftovers of the runs with the runner, or with all the
+// runners that are Cleaners if empty, as DoCleanup does. When no runs are
+// given, only the runs that have been over for the grace period are cleaned
+// up after.
+func (e *Engine) cleanup(ctx context.Context, runner string, runIDs []string, grace time.Duration, ow *rpc.OutputWriter) ([]string, error) {
+	cleaners := make(map[string]api.Cleaner)
+	if runner != "" {
+		r, ok := e.RunnerByName(runner)
+		if !ok {
+			return nil, fmt.Errorf("unknown runner: %s", runner)
+		}
+		c, ok := r.(api.Cleaner)
+		if !ok {
+			return nil, fmt.Errorf("runner %s can't clean up after runs", runner)
+		}
+		cleaners[runner] = c
+	} else {
+		for id, r := range e.ListRunners() {
+			if c, ok := r.(api.Cleaner); ok {
+				cleaners[id] = c
+			}
+		}
+	}
+
+	var (
+		cleaned []string
+		merr    *multierror.Error
+		now     = time.Now()
+	)
+	for id, c := range cleaners {
+		leftovers, err := c.Leftovers(ctx)
+		if err != nil {
+			if runner != "" {
+				return nil, fmt.Errorf("failed to list the leftovers of runs: %w", err)
+			}
+			// the runner isn't set up, e.g. a cluster runner on a local daemon.
+			ow.Debugw("failed to list the leftovers of runs", "runner", id, "err", err)
+			continue
+		}
+
+		for _, runID := range leftovers {
+			if len(runIDs) > 0 && !matchesRun(runID, runIDs) {
+				continue
+			}
+			if len(runIDs) == 0 && !e.reapable(runID, now, grace) {
+				continue
+			}
+
+			ow.Infow("cleaning up after run", "runner", id, "run_id", runID)
+			if err := c.Cleanup(ctx, runID, ow); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("run %s on %s: %w", runID, id, err))
+				continue
+			}
+			cleaned = append(cleaned, runID)
+		}
+	}
+
+	sort.Strings(cleaned)
+	return cleaned, merr.ErrorOrNil()
+}
+
+// reapable returns whether the leftovers of the run can be removed unasked:
+// its task has been over for the grace period, or the daemon doesn't know of
+// it.
+func (e *Engine) reapable(runID string, now time.Time, grace time.Duration) bool {
+	if e.inProgress(runID) {
+		return false
+	}
+
+	id, _ := api.SplitRunID(runID)
+	tsk, err := e.store.Get(id)
+	if err == task.ErrNotFound {
+		return true
+	}
+	return err == nil && now.Sub(tsk.State().Created) >= grace
+}
+
+// inProgress returns whether the run, or the suite of runs, is queued or
+// being processed.
+func (e *Engine) inProgress(runID string) bool {
+	id, _ := api.SplitRunID(runID)
+
+	e.signalsLk.RLock()
+	_, running := e.signals[id]
+	e.signalsLk.RUnlock()
+	if running {
+		return true
+	}
+
+	tsk, err := e.store.Get(id)
+	return err == nil && !tsk.State().State.Done()
+}
+
+// matchesRun returns whether the run is one of the given runs, or a case of
+// one of the given suites.
+func matchesRun(runID string, runIDs []string) bool {
+	taskID, _ := api.SplitRunID(runID)
+	for _, id := range runIDs {
+		if runID == id || taskID == id {
+			return true
+		}
+	}
+	return false
+}
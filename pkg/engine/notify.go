@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
+)
+
+// notifyBacklog is the number of events that can wait to be posted before
+// new events are dropped.
+const notifyBacklog = 256
+
+var defaultTemplates = map[string]string{
+	"slack":   `{{emoji .}} {{if .Links.task}}<{{.Links.task}}|{{.TaskID}}>{{else}}{{.TaskID}}{{end}} *{{.Name}}* {{.Type}} {{.Event}}{{with .Summary}} ({{.}}){{end}}{{with .Took}} {{.}}{{end}}{{with .Error}} ; {{.}}{{end}}`,
+	"discord": `{{emoji .}} {{if .Links.task}}[{{.TaskID}}](<{{.Links.task}}>){{else}}{{.TaskID}}{{end}} **{{.Name}}** {{.Type}} {{.Event}}{{with .Summary}} ({{.}}){{end}}{{with .Took}} {{.}}{{end}}{{with .Error}} ; {{.}}{{end}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"emoji": func(ev *api.TaskEvent) string {
+		switch {
+		case ev.Event == api.EventQueued:
+			return "🕒"
+		case ev.Event == api.EventStarted:
+			return "⏳"
+		case ev.Event == api.EventFinished:
+			return "✅"
+		case ev.Event == api.EventCanceled:
+			return "⚪"
+		case ev.Outcome == task.OutcomeTimeout:
+			return "⏰"
+		default:
+			return "❌"
+		}
+	},
+}
+
+// notifier posts the events of tasks to webhooks, in order, off the workers.
+type notifier struct {
+	root   string
+	hooks  []*webhook
+	events chan *api.TaskEvent
+	client *http.Client
+}
+
+type webhook struct {
+	url    string
+	format string
+	events map[api.TaskEventType]bool
+	tmpl   *template.Template
+}
+
+// newNotifier returns a notifier posting to the webhooks of the
+// configuration, or nil if there are none.
+func newNotifier(cfg *config.EnvConfig) (*notifier, error) {
+	if len(cfg.Daemon.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	n := &notifier{
+		root:   strings.TrimSuffix(cfg.Daemon.RootURL, "/"),
+		events: make(chan *api.TaskEvent, notifyBacklog),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	for i, c := range cfg.Daemon.Webhooks {
+		h, err := newWebhook(c)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d: %w", i, err)
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+func newWebhook(c config.WebhookConfig) (*webhook, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("no url")
+	}
+
+	h := &webhook{url: c.URL, format: c.Format, events: make(map[api.TaskEventType]bool)}
+	switch h.format {
+	case "":
+		h.format = "json"
+	case "json":
+	case "slack", "discord":
+		text := c.Template
+		if text == "" {
+			text = defaultTemplates[h.format]
+		}
+		tmpl, err := template.New(h.format).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		h.tmpl = tmpl
+	default:
+		return nil, fmt.Errorf("unknown format %q; expected json, slack or discord", c.Format)
+	}
+
+	for _, ev := range c.Events {
+		known := false
+		for _, typ := range api.TaskEvents {
+			known = known || ev == string(typ)
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q", ev)
+		}
+		h.events[api.TaskEventType(ev)] = true
+	}
+	return h, nil
+}
+
+// run posts the events until the process exits.
+func (n *notifier) run() {
+	for ev := range n.events {
+		for _, h := range n.hooks {
+			if err := n.post(h, ev); err != nil {
+				logging.S().Warnw("could not notify webhook", "url", h.url, "event", ev.Event, "task_id", ev.TaskID, "err", err)
+			}
+		}
+	}
+}
+
+func (n *notifier) post(h *webhook, ev *api.TaskEvent) error {
+	if len(h.events) > 0 && !h.events[ev.Event] {
+		return nil
+	}
+
+	payload, err := h.payload(ev)
+	if err != nil {
+		return err
+	}
+
+	res, err := n.client.Post(h.url, "application/json; charset=UTF-8", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+func (h *webhook) payload(ev *api.TaskEvent) ([]byte, error) {
+	if h.tmpl == nil {
+		return json.Marshal(ev)
+	}
+
+	var text strings.Builder
+	if err := h.tmpl.Execute(&text, ev); err != nil {
+		return nil, fmt.Errorf("failed to render the message: %w", err)
+	}
+
+	key := "text"
+	if h.format == "discord" {
+		key = "content"
+	}
+	return json.Marshal(map[string]string{key: text.String()})
+}
+
+// event returns the event of the task, or, if typ is empty, the event of
+// the task being done: finished, failed or canceled.
+func (n *notifier) event(typ api.TaskEventType, tsk *task.Task) *api.TaskEvent {
+	rec := newRecord(tsk)
+	ev := &api.TaskEvent{
+		Event:     typ,
+		Time:      tsk.State().Created,
+		TaskID:    tsk.ID,
+		Type:      tsk.Type,
+		Name:      tsk.Name(),
+		Plan:      rec.Plan,
+		Case:      rec.Case,
+		Runner:    rec.Runner,
+		CreatedBy: tsk.CreatedBy,
+	}
+
+	if typ == "" {
+		switch rec.Outcome {
+		case task.OutcomeSuccess, task.OutcomeSuccessWithExceptions:
+			ev.Event = api.EventFinished
+		case task.OutcomeCanceled:
+			ev.Event = api.EventCanceled
+		default:
+			ev.Event = api.EventFailed
+		}
+		ev.Outcome, ev.Summary, ev.Error = rec.Outcome, rec.Summary, rec.Error
+		if took := rec.Took(); took > 0 {
+			ev.Took = took.String()
+		}
+	}
+
+	if n.root != "" {
+		ev.Links = map[string]string{
+			"task": n.root + "/dashboard?task_id=" + tsk.ID,
+			"logs": n.root + "/logs?task_id=" + tsk.ID,
+		}
+		if tsk.Type == task.TypeRun && ev.Outcome != "" {
+			ev.Links["outputs"] = n.root + "/outputs?run_id=" + tsk.ID
+		}
+	}
+	return ev
+}
+
+// notify queues the event of the task to be posted to the webhooks; see
+// notifier.event.
+func (e *Engine) notify(typ api.TaskEventType, tsk *task.Task) {
+	e.post(e.event(typ, tsk))
+}
+
+// event returns the event of the task, or nil if there are no webhooks.
+// Events are snapshots of tasks, so tasks that are about to be handed over
+// to other goroutines, e.g. by pushing them to the queue, should have their
+// events taken beforehand.
+func (e *Engine) event(typ api.TaskEventType, tsk *task.Task) *api.TaskEvent {
+	if e.notifier == nil {
+		return nil
+	}
+	return e.notifier.event(typ, tsk)
+}
+
+// post queues an event to be posted to the webhooks.
+func (e *Engine) post(ev *api.TaskEvent) {
+	if ev == nil {
+		return
+	}
+
+	select {
+	case e.notifier.events <- ev:
+	default:
+		logging.S().Warnw("too many task events waiting to be notified; dropping", "event", ev.Event, "task_id", ev.TaskID)
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+)
+
+func TestNotifier(t *testing.T) {
+	bodies := make(map[string][]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], string(b))
+	}))
+	defer srv.Close()
+
+	n, err := newNotifier(&config.EnvConfig{Daemon: config.DaemonConfig{
+		RootURL: "https://tg.example.com/",
+		Webhooks: []config.WebhookConfig{
+			{URL: srv.URL + "/ci", Events: []string{"finished", "failed"}},
+			{URL: srv.URL + "/slack", Format: "slack"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now().UTC().Add(-time.Minute)
+	tsk := &task.Task{
+		ID:     "bt4brhjpc98qra498sg0",
+		Type:   task.TypeRun,
+		Plan:   "network",
+		Case:   "ping-pong",
+		Runner: "local:docker",
+		Input:  &RunInput{RunRequest: &api.RunRequest{}},
+		States: []task.DatedState{{State: task.StateScheduled, Created: created}},
+	}
+	for _, h := range n.hooks {
+		if err := n.post(h, n.event(api.EventQueued, tsk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsk.States = append(tsk.States,
+		task.DatedState{State: task.StateProcessing, Created: created.Add(10 * time.Second)},
+		task.DatedState{State: task.StateComplete, Created: created.Add(40 * time.Second)},
+	)
+	tsk.Result = &runner.Result{
+		Outcome:  task.OutcomeFailure,
+		Outcomes: map[string]*runner.GroupOutcome{"a": {Ok: 1, Total: 2}},
+	}
+	for _, h := range n.hooks {
+		if err := n.post(h, n.event("", tsk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the json webhook is only notified of the end of the task.
+	if len(bodies["/ci"]) != 1 {
+		t.Fatalf("expected 1 event posted to the json webhook, got %d", len(bodies["/ci"]))
+	}
+	var ev api.TaskEvent
+	if err := json.Unmarshal([]byte(bodies["/ci"][0]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != api.EventFailed || ev.Outcome != task.OutcomeFailure || ev.Summary != "a:1/2" || ev.Took != "30s" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if ev.Links["outputs"] != "https://tg.example.com/outputs?run_id=bt4brhjpc98qra498sg0" {
+		t.Errorf("unexpected links: %v", ev.Links)
+	}
+
+	if len(bodies["/slack"]) != 2 {
+		t.Fatalf("expected 2 messages posted to slack, got %d", len(bodies["/slack"]))
+	}
+	var msg struct{ Text string }
+	if err := json.Unmarshal([]byte(bodies["/slack"][1]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	exp := "❌ <https://tg.example.com/dashboard?task_id=bt4brhjpc98qra498sg0|bt4brhjpc98qra498sg0> *network:ping-pong* run failed (a:1/2) 30s"
+	if msg.Text != exp {
+		t.Errorf("expected message %q, got %q", exp, msg.Text)
+	}
+	if !strings.Contains(bodies["/slack"][0], "run queued") {
+		t.Errorf("expected the first message to announce the task queued, got %s", bodies["/slack"][0])
+	}
+}
+
+func TestNotifierConfig(t *testing.T) {
+	for _, c := range []config.WebhookConfig{
+		{},
+		{URL: "http://localhost", Format: "teams"},
+		{URL: "http://localhost", Events: []string{"exploded"}},
+		{URL: "http://localhost", Format: "slack", Template: "{{.Name"},
+	} {
+		if _, err := newWebhook(c); err == nil {
+			t.Errorf("expected webhook %+v to be invalid", c)
+		}
+	}
+}
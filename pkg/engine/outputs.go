@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/data"
+	"github.com/testground/testground/pkg/outputs"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+)
+
+// uploadOutputs uploads the outputs of a run, as the runner collects them,
+// to the object store configured for it, if any, and records their URL in
+// the result of the run. The outputs stay with the runner if the upload
+// fails, and the run is unaffected.
+func (e *Engine) uploadOutputs(ctx context.Context, comp *api.Composition, run api.Runner, cfg interface{}, id string, out *api.RunOutput, ow *rpc.OutputWriter) {
+	if out == nil {
+		return
+	}
+	res, ok := out.Result.(*runner.Result)
+	if !ok {
+		return
+	}
+
+	store, err := outputs.NewStore(*e.envcfg, comp.Global.Outputs)
+	if err != nil {
+		ow.Warnw("could not upload the outputs of the run", "run_id", id, "error", err)
+		return
+	} else if store == nil {
+		return
+	}
+
+	in := &api.CollectionInput{
+		EnvConfig:    *e.envcfg,
+		RunID:        id,
+		RunnerID:     run.ID(),
+		RunnerConfig: cfg,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(run.CollectOutputs(ctx, in, ow.WithBinary(pw)))
+	}()
+
+	url, err := store.Put(ctx, id, pr)
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		ow.Warnw("could not upload the outputs of the run", "run_id", id, "error", err)
+		return
+	}
+
+	res.Outputs = url
+	ow.Infow("uploaded the outputs of the run", "run_id", id, "url", url)
+}
+
+// collectStoredOutputs writes the outputs of a run of the task to ow, from
+// the object store, if they were uploaded to one. It returns false if they
+// weren't, and are to be collected from the runner.
+func (e *Engine) collectStoredOutputs(ctx context.Context, t *task.Task, runID string, ow *rpc.OutputWriter) (bool, error) {
+	if t.Type != task.TypeRun || t.Result == nil {
+		return false, nil
+	}
+
+	res := data.DecodeRunnerResult(t.Result)
+	url := res.Outputs
+	for _, c := range res.Cases {
+		if c.RunID == runID {
+			url = c.Outputs
+		}
+	}
+	if url == "" {
+		return false, nil
+	}
+
+	store, err := outputs.NewStore(*e.envcfg, nil)
+	if err != nil {
+		return true, err
+	} else if store == nil {
+		return true, fmt.Errorf("the outputs of run %s were uploaded to %s, but no outputs store is configured", runID, url)
+	}
+
+	ow.Infow("collecting outputs from the object store", "run_id", runID, "url", url)
+	return true, store.Get(ctx, url, ow.BinaryWriter())
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
+)
+
+// progress tracks the state of a task being processed, and how far along it
+// is, persisting them so that clients can follow the task. Every state spans
+// a range of the progress of the whole task. A nil progress tracks nothing.
+type progress struct {
+	e   *Engine
+	tsk *task.Task
+
+	lk     sync.Mutex
+	lo, hi int // the range of the current state, in percents
+}
+
+func (e *Engine) newProgress(tsk *task.Task) *progress {
+	return &progress{e: e, tsk: tsk}
+}
+
+// stage moves the task to a state, which spans the progress between lo and
+// hi percents.
+func (p *progress) stage(state task.State, lo, hi int, message string) {
+	if p == nil {
+		return
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	now := time.Now().UTC()
+	if p.tsk.State().State != state {
+		p.tsk.States = append(p.tsk.States, task.DatedState{State: state, Created: now})
+	}
+	p.lo, p.hi = lo, hi
+	p.tsk.Progress = &task.Progress{Percent: lo, Message: message, Updated: now}
+	p.persist()
+}
+
+// update reports that done out of total steps of the current state are
+// done.
+func (p *progress) update(done, total int, message string) {
+	if p == nil || total <= 0 {
+		return
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	p.tsk.Progress = &task.Progress{
+		Percent: p.lo + (p.hi-p.lo)*done/total,
+		Message: message,
+		Updated: time.Now().UTC(),
+	}
+	p.persist()
+}
+
+func (p *progress) persist() {
+	if err := p.e.store.PersistProcessing(p.tsk); err != nil {
+		logging.S().Errorw("could not persist the progress of the task", "task_id", p.tsk.ID, "err", err)
+	}
+}
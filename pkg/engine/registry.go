@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/build"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/plugin"
+	"github.com/testground/testground/pkg/runner"
+)
+
+// Registry binds builders and runners to their IDs. The default registry
+// holds the builders and runners built into testground; the daemon adds the
+// ones served by the plugins it is configured with.
+type Registry struct {
+	lk       sync.RWMutex
+	builders []api.Builder
+	runners  []api.Runner
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry returns a registry holding the builders and runners built
+// into testground.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, b := range []api.Builder{
+		&build.DockerGoBuilder{},
+		&build.ExecGoBuilder{},
+		&build.DockerGenericBuilder{},
+		&build.DockerNodeBuilder{},
+		&build.ExecRustBuilder{},
+		&build.DockerRustBuilder{},
+	} {
+		_ = r.RegisterBuilder(b)
+	}
+	for _, rn := range []api.Runner{
+		&runner.LocalDockerRunner{},
+		&runner.LocalPodmanRunner{},
+		&runner.LocalExecutableRunner{},
+		&runner.LocalFirecrackerRunner{},
+		&runner.ClusterSwarmRunner{},
+		&runner.ClusterK8sRunner{},
+		&runner.ClusterNomadRunner{},
+		&runner.ClusterSSHRunner{},
+	} {
+		_ = r.RegisterRunner(rn)
+	}
+	return r
+}
+
+// RegisterBuilder adds a builder to the registry. It fails if a builder with
+// the same ID is already registered.
+func (r *Registry) RegisterBuilder(b api.Builder) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for _, o := range r.builders {
+		if o.ID() == b.ID() {
+			return fmt.Errorf("builder %s is already registered", b.ID())
+		}
+	}
+	r.builders = append(r.builders, b)
+	return nil
+}
+
+// RegisterRunner adds a runner to the registry. It fails if a runner with the
+// same ID is already registered.
+func (r *Registry) RegisterRunner(rn api.Runner) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for _, o := range r.runners {
+		if o.ID() == rn.ID() {
+			return fmt.Errorf("runner %s is already registered", rn.ID())
+		}
+	}
+	r.runners = append(r.runners, rn)
+	return nil
+}
+
+// Builder returns the builder with the given ID.
+func (r *Registry) Builder(id string) (api.Builder, bool) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	for _, b := range r.builders {
+		if b.ID() == id {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Runner returns the runner with the given ID.
+func (r *Registry) Runner(id string) (api.Runner, bool) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	for _, rn := range r.runners {
+		if rn.ID() == id {
+			return rn, true
+		}
+	}
+	return nil, false
+}
+
+// Builders returns the registered builders, in registration order.
+func (r *Registry) Builders() []api.Builder {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	return append([]api.Builder(nil), r.builders...)
+}
+
+// Runners returns the registered runners, in registration order.
+func (r *Registry) Runners() []api.Runner {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	return append([]api.Runner(nil), r.runners...)
+}
+
+// loadPlugins launches the plugins at the given paths, and registers the
+// builders and runners they serve. Plugins can't replace builtin builders or
+// runners, nor each other's.
+func loadPlugins(reg *Registry, paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Load(context.Background(), path)
+		if err != nil {
+			return err
+		}
+		if err := registerPlugin(reg, p); err != nil {
+			_ = p.Close()
+			return fmt.Errorf("failed to register plugin %s: %w", path, err)
+		}
+		logging.S().Infow("loaded plugin", "path", path, "builders", len(p.Builders()), "runners", len(p.Runners()))
+	}
+	return nil
+}
+
+func registerPlugin(reg *Registry, p *plugin.Plugin) error {
+	for _, b := range p.Builders() {
+		if err := reg.RegisterBuilder(b); err != nil {
+			return err
+		}
+	}
+	for _, r := range p.Runners() {
+		if err := reg.RegisterRunner(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
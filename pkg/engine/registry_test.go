@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/runner"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := DefaultRegistry()
+
+	if r, ok := reg.Runner("local:docker"); !ok || r.ID() != "local:docker" {
+		t.Fatalf("expected the local:docker runner to be registered")
+	}
+	if _, ok := reg.Builder("docker:go"); !ok {
+		t.Fatalf("expected the docker:go builder to be registered")
+	}
+	if _, ok := reg.Runner("cloud:proprietary"); ok {
+		t.Fatalf("expected no cloud:proprietary runner")
+	}
+
+	// builtin runners can't be replaced.
+	if err := reg.RegisterRunner(&runner.LocalDockerRunner{}); err == nil {
+		t.Fatalf("expected registering a duplicate runner to fail")
+	}
+
+	if n := len(reg.Runners()); n != 8 {
+		t.Fatalf("expected 8 runners; got %d", n)
+	}
+	if n := len(reg.Builders()); n != 6 {
+		t.Fatalf("expected 6 builders; got %d", n)
+	}
+	if n := len(NewRegistry().Runners()); n != 0 {
+		t.Fatalf("expected an empty registry; got %d runners", n)
+	}
+}
+
+func TestCheckCompatible(t *testing.T) {
+	reg := DefaultRegistry()
+	docker, _ := reg.Runner("local:docker")
+	exec, _ := reg.Runner("local:exec")
+	dockerGo, _ := reg.Builder("docker:go")
+	execGo, _ := reg.Builder("exec:go")
+
+	if err := CheckCompatible(dockerGo, docker); err != nil {
+		t.Fatalf("expected docker:go to be compatible with local:docker; got %s", err)
+	}
+	if err := CheckCompatible(execGo, exec); err != nil {
+		t.Fatalf("expected exec:go to be compatible with local:exec; got %s", err)
+	}
+	if err := CheckCompatible(execGo, docker); err == nil {
+		t.Fatalf("expected exec:go to be incompatible with local:docker")
+	}
+	if err := CheckCompatible(dockerGo, exec); err == nil {
+		t.Fatalf("expected docker:go to be incompatible with local:exec")
+	}
+
+	execRust, _ := reg.Builder("exec:rust")
+	dockerRust, _ := reg.Builder("docker:rust")
+	if err := CheckCompatible(execRust, exec); err != nil {
+		t.Fatalf("expected exec:rust to be compatible with local:exec; got %s", err)
+	}
+	if err := CheckCompatible(dockerRust, docker); err != nil {
+		t.Fatalf("expected docker:rust to be compatible with local:docker; got %s", err)
+	}
+	if err := CheckCompatible(dockerRust, exec); err == nil {
+		t.Fatalf("expected docker:rust to be incompatible with local:exec")
+	}
+}
+
+func TestSelectBuilder(t *testing.T) {
+	reg := DefaultRegistry()
+	manifest := &api.TestPlanManifest{
+		Name: "network",
+		Builders: map[string]config.ConfigMap{
+			"exec:go":   {},
+			"docker:go": {},
+		},
+	}
+
+	for runner, expected := range map[string]string{
+		"local:exec":   "exec:go",
+		"local:docker": "docker:go",
+		"cluster:k8s":  "docker:go",
+	} {
+		r, _ := reg.Runner(runner)
+		b, err := SelectBuilder(reg.Builders(), r, manifest)
+		if err != nil {
+			t.Fatalf("failed to select a builder for %s: %s", runner, err)
+		}
+		if b.ID() != expected {
+			t.Fatalf("expected %s to be selected for %s; got %s", expected, runner, b.ID())
+		}
+	}
+
+	manifest.Builders = map[string]config.ConfigMap{"docker:node": {}}
+	r, _ := reg.Runner("local:exec")
+	if _, err := SelectBuilder(reg.Builders(), r, manifest); err == nil {
+		t.Fatalf("expected no builder of the test plan to be compatible with local:exec")
+	}
+}
+
+func TestResolveRun(t *testing.T) {
+	reg := DefaultRegistry()
+	manifest := &api.TestPlanManifest{
+		Name:     "network",
+		Builders: map[string]config.ConfigMap{"exec:go": {}},
+		Runners:  map[string]config.ConfigMap{"local:exec": {}},
+		TestCases: []*api.TestCase{
+			{Name: "ping-pong", Instances: api.InstanceConstraints{Minimum: 1, Maximum: 10}},
+		},
+	}
+	comp := func(builder, runner string) *api.Composition {
+		return &api.Composition{
+			Global: api.Global{
+				Plan:           "plans/network",
+				Case:           "ping-pong",
+				TotalInstances: 2,
+				Builder:        builder,
+				Runner:         runner,
+			},
+			Groups: []*api.Group{{ID: "peers", Instances: api.Instances{Count: 2}}},
+		}
+	}
+
+	resolved, err := ResolveRun(comp("exec:go", "local:exec"), manifest, reg.Builder, reg.Runner)
+	if err != nil {
+		t.Fatalf("failed to resolve the run: %s", err)
+	}
+	if resolved.Global.Plan != "network" {
+		t.Fatalf("expected the plan to be resolved from the manifest; got %s", resolved.Global.Plan)
+	}
+
+	if _, err := ResolveRun(comp("docker:go", "local:exec"), manifest, reg.Builder, reg.Runner); err == nil {
+		t.Fatalf("expected docker:go to be refused by local:exec")
+	}
+	if _, err := ResolveRun(comp("exec:go", "cloud:proprietary"), manifest, reg.Builder, reg.Runner); err == nil {
+		t.Fatalf("expected an unknown runner to be refused")
+	}
+	// without lookups, only the composition is checked.
+	if _, err := ResolveRun(comp("exec:go", "cloud:proprietary"), manifest, nil, nil); err != nil {
+		t.Fatalf("expected the runner checks to be skipped; got %s", err)
+	}
+}
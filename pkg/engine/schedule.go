@@ -0,0 +1,259 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/cron"
+	"github.com/testground/testground/pkg/logging"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/otiai10/copy"
+	"github.com/rs/xid"
+)
+
+// CreateSchedule stores a schedule of recurring runs of the request, which
+// are queued whenever the cron expression of the request matches. The
+// sources of the request, if any, are kept for as long as the schedule
+// exists.
+func (e *Engine) CreateSchedule(request *api.ScheduleRequest, sources *api.UnpackedSources) (*task.Schedule, error) {
+	if _, err := cron.Parse(request.Cron); err != nil {
+		return nil, err
+	}
+	if err := e.checkRun(&request.RunRequest); err != nil {
+		return nil, err
+	}
+
+	req, err := json.Marshal(&request.RunRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	sch := &task.Schedule{
+		ID:        xid.New().String(),
+		Cron:      request.Cron,
+		Plan:      request.Composition.Global.Plan,
+		Case:      request.Composition.Global.Case,
+		Created:   time.Now().UTC(),
+		CreatedBy: task.CreatedBy(request.CreatedBy),
+		Request:   req,
+	}
+	if sources != nil {
+		sch.Sources = filepath.Join(e.envcfg.Dirs().Daemon(), "schedules", sch.ID)
+		if err := copy.Copy(sources.BaseDir, sch.Sources); err != nil {
+			return nil, fmt.Errorf("failed to keep the sources of the schedule: %w", err)
+		}
+	}
+
+	e.schedulesLk.Lock()
+	err = e.store.PutSchedule(sch)
+	e.schedulesLk.Unlock()
+	if err != nil {
+		_ = os.RemoveAll(sch.Sources)
+		return nil, err
+	}
+
+	e.reschedule()
+	return sch, nil
+}
+
+// Schedules returns the schedules created by the user, or all the schedules
+// if the user is empty.
+func (e *Engine) Schedules(user string) ([]*task.Schedule, error) {
+	schs, err := e.store.Schedules()
+	if err != nil {
+		return nil, err
+	}
+
+	res := schs[:0]
+	for _, sch := range schs {
+		if user == "" || sch.CreatedBy.User == user {
+			res = append(res, sch)
+		}
+	}
+	return res, nil
+}
+
+func (e *Engine) GetSchedule(id string) (*task.Schedule, error) {
+	return e.store.GetSchedule(id)
+}
+
+// DeleteSchedule removes a schedule, and its sources. The runs it queued
+// already are left alone.
+func (e *Engine) DeleteSchedule(id string) error {
+	e.schedulesLk.Lock()
+	defer e.schedulesLk.Unlock()
+
+	sch, err := e.store.GetSchedule(id)
+	if err != nil {
+		return err
+	}
+	if err := e.store.DeleteSchedule(id); err != nil {
+		return err
+	}
+	if sch.Sources != "" {
+		if err := os.RemoveAll(sch.Sources); err != nil {
+			logging.S().Warnw("failed to remove the sources of the schedule", "schedule", id, "err", err)
+		}
+	}
+
+	e.reschedule()
+	return nil
+}
+
+// reschedule wakes the scheduler up, to recompute when the next schedule
+// is due.
+func (e *Engine) reschedule() {
+	select {
+	case e.rescheduled <- struct{}{}:
+	default:
+	}
+}
+
+// scheduler queues the runs of the schedules when they're due, until the
+// engine is closed.
+func (e *Engine) scheduler() {
+	for {
+		var wait <-chan time.Time
+		if next := e.runSchedules(time.Now()); !next.IsZero() {
+			wait = time.After(time.Until(next))
+		}
+
+		select {
+		case <-wait:
+		case <-e.rescheduled:
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// scheduleGrace is how late the scheduler may queue the run of an occurrence
+// of a schedule. Occurrences later than that were missed, e.g. while the
+// daemon was down, and are skipped.
+const scheduleGrace = time.Minute
+
+// runSchedules queues the runs of the schedules that are due at now, and
+// returns when the next schedule is due, or the zero time if none is.
+//
+// Occurrences missed while the daemon was down are not made up: a schedule
+// whose last occurrence is more than scheduleGrace old queues no run, and
+// waits for its next occurrence.
+func (e *Engine) runSchedules(now time.Time) time.Time {
+	e.schedulesLk.Lock()
+	defer e.schedulesLk.Unlock()
+
+	schs, err := e.store.Schedules()
+	if err != nil {
+		logging.S().Errorw("failed to load the schedules", "err", err)
+		return now.Add(time.Minute)
+	}
+
+	var next time.Time
+	for _, sch := range schs {
+		expr, err := cron.Parse(sch.Cron)
+		if err != nil {
+			logging.S().Errorw("invalid schedule", "schedule", sch.ID, "err", err)
+			continue
+		}
+
+		from := sch.Created
+		if sch.Last.After(from) {
+			from = sch.Last
+		}
+		due := expr.Next(from.Local())
+		if due.IsZero() {
+			continue
+		}
+
+		if !due.After(now) {
+			for d := expr.Next(due); !d.IsZero() && !d.After(now); d = expr.Next(d) {
+				due = d
+			}
+			sch.Last = now.UTC()
+			if now.Sub(due) <= scheduleGrace {
+				sch.LastTask = e.runSchedule(sch)
+			} else {
+				logging.S().Warnw("skipping missed occurrence of the schedule", "schedule", sch.ID, "due", due)
+			}
+			if err := e.store.PutSchedule(sch); err != nil {
+				logging.S().Errorw("failed to update the schedule", "schedule", sch.ID, "err", err)
+			}
+			if due = expr.Next(now); due.IsZero() {
+				continue
+			}
+		}
+
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// runSchedule queues an occurrence of a schedule, and returns the ID of the
+// queued task. If the run can't be queued, the failure is recorded in the run
+// history instead.
+func (e *Engine) runSchedule(sch *task.Schedule) string {
+	log := logging.S().With("schedule", sch.ID, "plan", sch.Plan, "case", sch.Case)
+
+	id, err := e.queueSchedule(sch)
+	if err == nil {
+		log.Infow("queued scheduled run", "task_id", id)
+		return id
+	}
+
+	log.Errorw("failed to queue scheduled run", "err", err)
+	now := time.Now().UTC()
+	rec := &task.Record{
+		ID:        xid.New().String(),
+		Type:      task.TypeRun,
+		Plan:      sch.Plan,
+		Case:      sch.Case,
+		State:     task.StateFailed,
+		Outcome:   task.OutcomeFailure,
+		Error:     fmt.Sprintf("failed to queue scheduled run: %s", err),
+		Created:   now,
+		Ended:     now,
+		CreatedBy: sch.CreatedBy,
+		Schedule:  sch.ID,
+	}
+	if err := e.store.Record(rec); err != nil {
+		log.Errorw("could not record scheduled run in the run history", "err", err)
+	}
+	return ""
+}
+
+// queueSchedule queues the run of a schedule, built from a copy of its
+// sources, since builds modify them.
+func (e *Engine) queueSchedule(sch *task.Schedule) (string, error) {
+	var request api.RunRequest
+	if err := json.Unmarshal(sch.Request, &request); err != nil {
+		return "", fmt.Errorf("failed to decode the run request: %w", err)
+	}
+
+	var sources *api.UnpackedSources
+	if sch.Sources != "" {
+		dir := filepath.Join(e.envcfg.Dirs().Work(), "requests", fmt.Sprintf("%s-%d", sch.ID, time.Now().Unix()))
+		if err := copy.Copy(sch.Sources, dir); err != nil {
+			return "", fmt.Errorf("failed to copy the sources of the schedule: %w", err)
+		}
+
+		sources = &api.UnpackedSources{BaseDir: dir}
+		for kind, dst := range map[string]*string{
+			"plan":  &sources.PlanDir,
+			"sdk":   &sources.SDKDir,
+			"extra": &sources.ExtraDir,
+		} {
+			if fi, err := os.Stat(filepath.Join(dir, kind)); err == nil && fi.IsDir() {
+				*dst = filepath.Join(dir, kind)
+			}
+		}
+	}
+
+	return e.queueRun(&request, sources, sch.ID)
+}
@@ -7,12 +7,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
 	"github.com/logrusorgru/aurora"
 	"github.com/otiai10/copy"
+	"github.com/testground/sdk-go/network"
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/config"
 	"github.com/testground/testground/pkg/logging"
@@ -25,6 +29,21 @@ import (
 type RunInput struct {
 	*api.RunRequest
 	Sources *api.UnpackedSources
+
+	// Resume is set when a restart of the daemon interrupted the run, for
+	// the runner to resume it; see api.RunInput#Resume.
+	Resume bool `json:"-"`
+}
+
+// built returns whether the groups of the run to build have been built
+// already, by a run that was interrupted.
+func (ri *RunInput) built() bool {
+	for _, idx := range ri.BuildGroups {
+		if ri.Composition.Groups[idx].Run.Artifact == "" {
+			return false
+		}
+	}
+	return true
 }
 
 type BuildInput struct {
@@ -34,16 +53,42 @@ type BuildInput struct {
 
 func (e *Engine) addSignal(id string, ch chan int) {
 	e.signalsLk.Lock()
+	defer e.signalsLk.Unlock()
+
 	e.signals[id] = ch
-	e.signalsLk.Unlock()
+	// the task was killed before its signal was added.
+	if _, ok := e.killed[id]; ok {
+		delete(e.killed, id)
+		e.signalLocked(id)
+	}
 }
 
 func (e *Engine) deleteSignal(id string) {
 	e.signalsLk.Lock()
 	delete(e.signals, id)
+	delete(e.killed, id)
 	e.signalsLk.Unlock()
 }
 
+// signal closes the signal channel of a task being processed, which cancels
+// it, and deletes it, so that the channel is closed once, however many
+// clients cancel the task. It returns whether the task was being processed.
+func (e *Engine) signal(id string) bool {
+	e.signalsLk.Lock()
+	defer e.signalsLk.Unlock()
+	return e.signalLocked(id)
+}
+
+// signalLocked is signal, with the signals lock held for writing.
+func (e *Engine) signalLocked(id string) bool {
+	ch, ok := e.signals[id]
+	if ok {
+		close(ch)
+		delete(e.signals, id)
+	}
+	return ok
+}
+
 func (e *Engine) worker(n int) {
 	logging.S().Infow("supervisor worker started", "worker_id", n)
 
@@ -54,7 +99,7 @@ func (e *Engine) worker(n int) {
 
 	for {
 		tsk, err := e.queue.Pop()
-		if err == task.ErrQueueEmpty {
+		if err == task.ErrQueueEmpty || err == task.ErrQueueBlocked {
 			time.Sleep(time.Second)
 			continue
 		}
@@ -65,6 +110,13 @@ func (e *Engine) worker(n int) {
 		}
 
 		func() {
+			defer e.queue.Done(tsk)
+			defer func() {
+				if r := recover(); r != nil {
+					e.crashed(tsk, r, debug.Stack())
+				}
+			}()
+
 			ctx, cancel := context.WithTimeout(context.Background(), taskTimeout)
 			defer cancel()
 
@@ -81,15 +133,25 @@ func (e *Engine) worker(n int) {
 				}
 			}()
 
+			// tasks that a restart of the daemon interrupted are processed
+			// again; runs are resumed, if their runner can.
+			interrupted := tsk.Interrupted()
+			if interrupted {
+				logging.S().Warnw("processing task interrupted by a restart of the daemon", "task_id", tsk.ID, "state", tsk.State().State)
+			}
+
+			started := time.Now().UTC()
 			tsk.States = append(tsk.States, task.DatedState{
 				State:   task.StateProcessing,
-				Created: time.Now().UTC(),
+				Created: started,
 			})
 			err = e.store.PersistProcessing(tsk)
 			if err != nil {
 				logging.S().Errorw("could not persist task", "err", err)
 			}
 			logging.S().Infow("worker processing task", "worker_id", n, "task_id", tsk.ID)
+			prog := e.newProgress(tsk)
+			e.notify(api.EventStarted, tsk)
 			err = e.postStatusToGithub(tsk)
 			if err != nil {
 				logging.S().Errorw("could not post status to github", "err", err)
@@ -112,17 +174,23 @@ func (e *Engine) worker(n int) {
 			switch tsk.Type {
 			case task.TypeRun:
 				var res *api.RunOutput
-				res, errTask = e.doRun(ctx, tsk.ID, tsk.Input.(*RunInput), ow)
+				input := tsk.Input.(*RunInput)
+				input.Resume = interrupted
+				res, errTask = e.doRun(ctx, tsk.ID, input, prog, ow)
 				if errTask != nil {
 					logging.S().Errorw("doRun returned err", "err", errTask)
 				}
+				if ctx.Err() == nil {
+					prog.stage(task.StateCollecting, 90, 100, "collecting the result of the run")
+				}
 
 				if res != nil {
 					result = res.Result
 				}
 			case task.TypeBuild:
 				var res []*api.BuildOutput
-				res, errTask = e.doBuild(ctx, tsk.Input.(*BuildInput), ow)
+				prog.stage(task.StateBuilding, 0, 100, "building the test plan")
+				res, errTask = e.doBuild(ctx, tsk.Input.(*BuildInput), prog, ow)
 				if errTask != nil {
 					logging.S().Errorw("doBuild returned err", "err", errTask)
 				}
@@ -140,6 +208,16 @@ func (e *Engine) worker(n int) {
 				return
 			}
 
+			// Runs that failed for a reason their composition retries on are
+			// queued again, rather than completed.
+			if e.retry(tsk, started, errTask, ow) {
+				e.deleteSignal(tsk.ID)
+				return
+			}
+			if res, ok := result.(*runner.Result); ok && len(tsk.Attempts) > 0 {
+				res.Attempts = tsk.Attempts
+			}
+
 			newState := task.DatedState{
 				Created: time.Now().UTC(),
 				State:   task.StateComplete,
@@ -149,7 +227,11 @@ func (e *Engine) worker(n int) {
 
 				if errors.Is(errTask, context.Canceled) {
 					newState.State = task.StateCanceled
+				} else {
+					newState.State = task.StateFailed
 				}
+			} else {
+				tsk.Progress = &task.Progress{Percent: 100, Updated: newState.Created}
 			}
 
 			tsk.States = append(tsk.States, newState)
@@ -166,6 +248,8 @@ func (e *Engine) worker(n int) {
 				logging.S().Errorw("could not archive task", "err", err)
 				return
 			}
+			e.record(tsk)
+			e.notify("", tsk)
 
 			err = e.postStatusToSlack(tsk)
 			if err != nil {
@@ -182,6 +266,55 @@ func (e *Engine) worker(n int) {
 	}
 }
 
+// retry queues a run task that failed with err again, if the retries policy
+// of its composition says so, recording the failed attempt. It returns
+// whether the task was queued again.
+func (e *Engine) retry(tsk *task.Task, started time.Time, err error, ow *rpc.OutputWriter) bool {
+	input, ok := tsk.Input.(*RunInput)
+	if !ok {
+		return false
+	}
+	policy := input.Composition.Global.Retries
+	if !policy.Retry(len(tsk.Attempts)+1, err) {
+		return false
+	}
+
+	attempts, states := tsk.Attempts, tsk.States
+	tsk.Attempts = append(tsk.Attempts, task.Attempt{
+		Started: started,
+		Ended:   time.Now().UTC(),
+		Class:   api.FailureClass(err),
+		Error:   err.Error(),
+	})
+	tsk.States = append(tsk.States, task.DatedState{
+		State:   task.StateScheduled,
+		Created: time.Now().UTC(),
+	})
+	progress := tsk.Progress
+	tsk.Progress = nil
+
+	// the next attempt reuses the artifacts built by this one, if any.
+	buildGroups := input.BuildGroups
+	built := true
+	for _, idx := range buildGroups {
+		built = built && input.Composition.Groups[idx].Run.Artifact != ""
+	}
+	if built {
+		input.BuildGroups = nil
+	}
+
+	ev := e.event(api.EventQueued, tsk)
+	if err := e.queue.Requeue(tsk); err != nil {
+		logging.S().Errorw("could not queue task again", "task_id", tsk.ID, "err", err)
+		tsk.Attempts, tsk.States, tsk.Progress, input.BuildGroups = attempts, states, progress, buildGroups
+		return false
+	}
+
+	e.post(ev)
+	ow.Warnw("run failed; queued it again", "task_id", tsk.ID, "attempt", len(tsk.Attempts), "attempts", policy.Attempts, "class", api.FailureClass(err), "err", err)
+	return true
+}
+
 func (e *Engine) postStatusToGithub(tsk *task.Task) error {
 	if e.envcfg.Daemon.GithubRepoStatusToken == "" {
 		return nil
@@ -203,7 +336,7 @@ func (e *Engine) postStatusToGithub(tsk *task.Task) error {
 
 	var msg, state string
 
-	switch tsk.State().State {
+	switch tsk.State().State.Phase() {
 	case task.StateProcessing:
 		msg = "TaaS is running your plan"
 		state = "pending"
@@ -212,12 +345,18 @@ func (e *Engine) postStatusToGithub(tsk *task.Task) error {
 		case task.OutcomeSuccess:
 			msg = "Testplan run succeeded!"
 			state = "success"
+		case task.OutcomeSuccessWithExceptions:
+			msg = "Testplan run passed with exceptions!"
+			state = "success"
 		case task.OutcomeCanceled:
 			msg = "Testplan run was canceled!"
 			state = "failure"
 		case task.OutcomeFailure:
 			msg = "Testplan run failed!"
 			state = "failure"
+		case task.OutcomeTimeout:
+			msg = "Testplan run timed out!"
+			state = "failure"
 		case task.OutcomeUnknown:
 			return errors.New("can't post update to github: task outcome is unknown")
 		}
@@ -266,10 +405,14 @@ func (e *Engine) postStatusToSlack(tsk *task.Task) error {
 	switch result.Outcome {
 	case task.OutcomeSuccess:
 		payload = fmt.Sprintf(`{"text":"✅ <https://ci.testground.ipfs.team/tasks#taskID_%s|%s> *%s* run succeeded (%s) %s"}`, tsk.ID, tsk.ID, tsk.Name(), result, tsk.Took())
+	case task.OutcomeSuccessWithExceptions:
+		payload = fmt.Sprintf(`{"text":"⚠️ <https://ci.testground.ipfs.team/tasks#taskID_%s|%s> *%s* run passed with exceptions (%s) %s"}`, tsk.ID, tsk.ID, tsk.Name(), result, tsk.Took())
 	case task.OutcomeCanceled:
 		payload = fmt.Sprintf(`{"text":"⚪ <https://ci.testground.ipfs.team/tasks#taskID_%s|%s> *%s* run canceled %s ; %s"}`, tsk.ID, tsk.ID, tsk.Name(), tsk.Took(), tsk.Error)
 	case task.OutcomeFailure:
 		payload = fmt.Sprintf(`{"text":"❌ <https://ci.testground.ipfs.team/tasks#taskID_%s|%s> *%s* run failed (%s) %s ; %s"}`, tsk.ID, tsk.ID, tsk.Name(), result, tsk.Took(), tsk.Error)
+	case task.OutcomeTimeout:
+		payload = fmt.Sprintf(`{"text":"⏰ <https://ci.testground.ipfs.team/tasks#taskID_%s|%s> *%s* run timed out (%s) %s"}`, tsk.ID, tsk.ID, tsk.Name(), result, tsk.Took())
 	}
 
 	cl := &http.Client{Timeout: time.Second * 10}
@@ -288,7 +431,20 @@ func (e *Engine) postStatusToSlack(tsk *task.Task) error {
 	return nil
 }
 
-func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputWriter) ([]*api.BuildOutput, error) {
+// buildPlatforms returns the platforms the artifacts of the group are built
+// for: the platforms its run config sets, or else the global run config, or
+// else the env config of the runner. Run configs aren't inherited by the
+// groups until the composition is prepared for the run.
+func (e *Engine) buildPlatforms(comp *api.Composition, grp *api.Group) ([]string, error) {
+	for _, cfg := range []map[string]interface{}{grp.RunConfig, comp.Global.RunConfig, e.envcfg.Runners[comp.Global.Runner]} {
+		if v, ok := cfg["platform"]; ok {
+			return api.ParsePlatforms(v)
+		}
+	}
+	return nil, nil
+}
+
+func (e *Engine) doBuild(ctx context.Context, input *BuildInput, prog *progress, ow *rpc.OutputWriter) ([]*api.BuildOutput, error) {
 	sources := input.Sources
 	comp, err := input.Composition.PrepareForBuild(&input.Manifest)
 
@@ -324,9 +480,9 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 			ow.Info("performing healthcheck on builder")
 
 			if rep, err := hc.Healthcheck(ctx, e, ow, true); err != nil {
-				return nil, fmt.Errorf("healthcheck and fix errored: %w", err)
+				return nil, api.NewInfraError(fmt.Errorf("healthcheck and fix errored: %w", err))
 			} else if !rep.FixesSucceeded() {
-				return nil, fmt.Errorf("healthcheck fixes failed; aborting:\n%s", rep)
+				return nil, api.NewInfraError(fmt.Errorf("healthcheck fixes failed; aborting:\n%s", rep))
 			} else if !rep.ChecksSucceeded() {
 				ow.Warnf(aurora.Bold(aurora.Yellow("some healthchecks failed, but continuing")).String())
 			} else {
@@ -387,6 +543,7 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 	// done, mapping the build artifacts back to the original group positions in
 	// the response.
 	var cnt int
+	var built int32
 	for key, idxs := range uniq {
 		idxs := idxs
 		key := key // capture
@@ -414,10 +571,18 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 			deps := make(map[string]api.DependencyTarget, len(grp.Build.Dependencies))
 
 			for _, dep := range grp.Build.Dependencies {
-				deps[dep.Module] = api.DependencyTarget{
+				t := api.DependencyTarget{
 					Target:  dep.Target,
 					Version: dep.Version,
 				}
+				if dep.IsLocal() {
+					// local replacements are shipped with the extra sources.
+					if src.ExtraDir == "" {
+						return fmt.Errorf("the sources of %s, replacing %s, weren't shipped with the build", dep.Target, dep.Module)
+					}
+					t.Dir = filepath.Join(src.ExtraDir, filepath.Base(dep.Target))
+				}
+				deps[dep.Module] = t
 			}
 
 			// This var compiles all configurations to coalesce.
@@ -440,6 +605,20 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 				return fmt.Errorf("error while coalescing configuration values: %w", err)
 			}
 
+			// Configurations that can be invalid, e.g. with mutually exclusive
+			// settings, are validated once coalesced from the manifest, the
+			// environment and the composition.
+			if v, ok := obj.(interface{ Validate() error }); ok {
+				if err := v.Validate(); err != nil {
+					return fmt.Errorf("invalid configuration of builder %s: %w", builder, err)
+				}
+			}
+
+			platforms, err := e.buildPlatforms(comp, grp)
+			if err != nil {
+				return err
+			}
+
 			in := &api.BuildInput{
 				BuildID:         uuid.New().String()[24:],
 				EnvConfig:       *e.envcfg,
@@ -448,6 +627,26 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 				Dependencies:    deps,
 				BuildConfig:     obj,
 				UnpackedSources: src,
+				Platforms:       platforms,
+			}
+
+			// Reuse the artifact of a previous build of the same inputs, unless
+			// the request bypasses the cache. The key is calculated before the
+			// build, as builders may modify the sources.
+			cacheKey, sources, err := buildCacheKey(bm.ID(), in)
+			if err != nil {
+				return err
+			}
+			if !input.NoCache {
+				if res := e.cachedBuild(ctx, bm, cacheKey, ow); res != nil {
+					for _, idx := range uniq[key] {
+						ress[idx] = res
+					}
+					ow.Infow("build cache hit; reusing artifact", "plan", plan, "groups", grpids, "builder", builder, "artifact", res.ArtifactPath)
+					n := atomic.AddInt32(&built, 1)
+					prog.update(int(n), len(uniq), fmt.Sprintf("built %d of %d artifacts", n, len(uniq)))
+					return nil
+				}
 			}
 
 			res, err := bm.Build(ctx, in, ow)
@@ -457,6 +656,12 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 			}
 
 			res.BuilderID = bm.ID()
+			if err := e.recordArtifact(plan, in, res, input.SourceCommit, sources); err != nil {
+				ow.Warnw("failed to record the metadata of the artifact", "artifact", res.ArtifactPath, "error", err)
+			}
+			if err := e.cacheBuild(cacheKey, plan, res); err != nil {
+				ow.Warnw("failed to cache the artifact", "artifact", res.ArtifactPath, "error", err)
+			}
 
 			// no need for a mutex as the indices we access do not intersect
 			// across goroutines.
@@ -465,6 +670,8 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 			}
 
 			ow.Infow("build succeeded", "plan", plan, "groups", grpids, "builder", builder, "artifact", res.ArtifactPath)
+			n := atomic.AddInt32(&built, 1)
+			prog.update(int(n), len(uniq), fmt.Sprintf("built %d of %d artifacts", n, len(uniq)))
 			return nil
 		})
 	}
@@ -477,8 +684,16 @@ func (e *Engine) doBuild(ctx context.Context, input *BuildInput, ow *rpc.OutputW
 	return ress, nil
 }
 
-func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
-	if len(input.BuildGroups) > 0 {
+func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, prog *progress, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	// builds take up to 40% of the runs that need them.
+	var running int
+	if len(input.BuildGroups) > 0 && input.Resume && input.built() {
+		running = 40
+		ow.Infow("resuming run; groups built already", "groups", len(input.BuildGroups))
+	} else if len(input.BuildGroups) > 0 {
+		running = 40
+		prog.stage(task.StateBuilding, 0, running, fmt.Sprintf("building %d groups", len(input.BuildGroups)))
+
 		bcomp, err := input.Composition.PickGroups(input.BuildGroups...)
 		if err != nil {
 			return nil, err
@@ -486,11 +701,13 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 
 		bout, err := e.doBuild(ctx, &BuildInput{
 			BuildRequest: &api.BuildRequest{
-				Composition: bcomp,
-				Manifest:    input.Manifest,
+				Composition:  bcomp,
+				Manifest:     input.Manifest,
+				NoCache:      input.NoCache,
+				SourceCommit: input.SourceCommit,
 			},
 			Sources: input.Sources,
-		}, ow)
+		}, prog, ow)
 		if err != nil {
 			return nil, err
 		}
@@ -503,13 +720,113 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 		}
 	}
 
-	comp, err := input.Composition.PrepareForRun(&input.Manifest)
+	var (
+		out *api.RunOutput
+		err error
+	)
+	if input.Composition.IsSuite() {
+		out, err = e.doRunSuite(ctx, id, input, running, prog, ow)
+	} else {
+		prog.stage(task.StateRunning, running, 90, "running the test case")
+		out, err = e.doRunCase(ctx, id, &input.Composition, input, ow)
+	}
+	e.addProvenance(&input.Composition, out)
+	return out, err
+}
+
+// addProvenance adds the provenance of the artifacts of the groups of the
+// composition to the result of the run, so that it can be traced back to the
+// inputs of the builds.
+func (e *Engine) addProvenance(comp *api.Composition, out *api.RunOutput) {
+	if out == nil {
+		return
+	}
+	res, ok := out.Result.(*runner.Result)
+	if !ok {
+		return
+	}
+	for _, grp := range comp.Groups {
+		if grp.Run.Artifact == "" {
+			continue
+		}
+		if p := e.provenance(grp.Run.Artifact); p != nil {
+			if res.Provenance == nil {
+				res.Provenance = make(map[string]*api.Provenance)
+			}
+			res.Provenance[grp.ID] = p
+		}
+	}
+}
+
+// doRunSuite runs the test cases of a suite one after the other, as runs with
+// IDs <id>-1, <id>-2, etc., and aggregates their results. All cases share the
+// build artifacts, which have been populated in the composition by now. A case
+// failing doesn't prevent the next ones from running, unless the task is
+// canceled or times out.
+func (e *Engine) doRunSuite(ctx context.Context, id string, input *RunInput, running int, prog *progress, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	var (
+		comps  = input.Composition.ExpandSuite()
+		result = runner.NewSuiteResult()
+		out    = &api.RunOutput{RunID: id, Composition: input.Composition, Result: result}
+		merr   *multierror.Error
+	)
+
+	prog.stage(task.StateRunning, running, 90, fmt.Sprintf("running %d test cases", len(comps)))
+	for i, comp := range comps {
+		var (
+			runID = fmt.Sprintf("%s-%d", id, i+1)
+			tcase = comp.Global.Case
+		)
+
+		ow.Infow("running test case of suite", "run_id", runID, "case", tcase, "index", i+1, "total", len(comps))
+		cout, err := e.doRunCase(ctx, runID, comp, input, ow)
+
+		var res *runner.Result
+		if cout != nil {
+			res, _ = cout.Result.(*runner.Result)
+		}
+		if res == nil {
+			switch {
+			case err == nil:
+				// as for single runs, a run with no result is deemed successful.
+				res = &runner.Result{Outcome: task.OutcomeSuccess}
+			case errors.Is(err, context.Canceled):
+				res = &runner.Result{Outcome: task.OutcomeCanceled}
+			default:
+				res = &runner.Result{Outcome: task.OutcomeFailure}
+			}
+		}
+		result.AddCase(tcase, runID, res)
+		prog.update(i+1, len(comps), fmt.Sprintf("ran %d of %d test cases", i+1, len(comps)))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return out, err
+			}
+			merr = multierror.Append(merr, fmt.Errorf("case %s: %w", tcase, err))
+		}
+	}
+
+	ow.Infow("suite finished", "run_id", id, "outcome", result.Outcome, "cases", len(comps))
+	return out, merr.ErrorOrNil()
+}
+
+// doRunCase performs a run of a single test case. The composition must not be
+// a suite.
+func (e *Engine) doRunCase(ctx context.Context, id string, c *api.Composition, input *RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	comp, err := PrepareRun(c, &input.Manifest)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := comp.ValidateForRun(); err != nil {
-		return nil, err
+	// Warn about test params the manifest doesn't know about; they are
+	// usually typos that would otherwise only surface inside the test plan.
+	if _, tc, ok := input.Manifest.TestCaseByName(comp.Global.Case); ok {
+		for _, g := range comp.Groups {
+			if undeclared := tc.UndeclaredParams(g.Run.TestParams); len(undeclared) > 0 {
+				ow.Warnw("test params not declared in the manifest", "group", g.ID, "params", undeclared)
+			}
+		}
 	}
 
 	var (
@@ -526,9 +843,9 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 		ow.Info("performing healthcheck on runner")
 
 		if rep, err := hc.Healthcheck(ctx, e, ow, true); err != nil {
-			return nil, fmt.Errorf("healthcheck and fix errored: %w", err)
+			return nil, api.NewInfraError(fmt.Errorf("healthcheck and fix errored: %w", err))
 		} else if !rep.FixesSucceeded() {
-			return nil, fmt.Errorf("healthcheck fixes failed; aborting:\n%s", rep)
+			return nil, api.NewInfraError(fmt.Errorf("healthcheck fixes failed; aborting:\n%s", rep))
 		} else if !rep.ChecksSucceeded() {
 			ow.Warnf(aurora.Bold(aurora.Yellow("some healthchecks failed, but continuing")).String())
 		} else {
@@ -568,10 +885,74 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 		TotalInstances: int(comp.Global.TotalInstances),
 		Groups:         make([]*api.RunGroup, 0, len(comp.Groups)),
 		DisableMetrics: comp.Global.DisableMetrics,
+
+		FollowInstances: input.FollowInstances,
+		Stagger:         comp.Global.Stagger,
+		Resume:          input.Resume,
+	}
+
+	if _, tc, ok := input.Manifest.TestCaseByName(tcase); ok {
+		in.Requirements = tc.Requires
 	}
 
 	// Trigger a build for each group, and wait until all of them are done.
 	for _, grp := range comp.Groups {
+		// Same as above, with the group run config, which has inherited the
+		// global run config when preparing the composition.
+		var gcfg config.CoalescedConfig
+		gcfg = gcfg.Append(e.envcfg.Runners[trunner]).Append(grp.RunConfig)
+
+		gobj, err := gcfg.CoalesceIntoType(run.ConfigType())
+		if err != nil {
+			return nil, fmt.Errorf("error while coalescing configuration values of group %s: %w", grp.ID, err)
+		}
+
+		var (
+			shape    *network.LinkShape
+			timeline []api.TimelineStep
+			rules    []api.NetworkRule
+			nat      api.NATType
+			capture  *api.CaptureSpec
+			metrics  time.Duration
+			dns      *api.DNSSpec
+		)
+		if grp.Network != nil {
+			s, err := grp.Network.LinkShape()
+			if err != nil {
+				return nil, fmt.Errorf("invalid network profile of group %s: %w", grp.ID, err)
+			}
+			shape = &s
+			if timeline, err = grp.Network.Steps(); err != nil {
+				return nil, fmt.Errorf("invalid network timeline of group %s: %w", grp.ID, err)
+			}
+			if rules, err = grp.Network.Rules(); err != nil {
+				return nil, fmt.Errorf("invalid network links of group %s: %w", grp.ID, err)
+			}
+			nat = grp.Network.NAT
+			if grp.Network.Capture != nil {
+				if capture, err = grp.Network.Capture.Spec(); err != nil {
+					return nil, fmt.Errorf("invalid network capture of group %s: %w", grp.ID, err)
+				}
+			}
+			if metrics, err = grp.Network.MetricsInterval(); err != nil {
+				return nil, fmt.Errorf("invalid network profile of group %s: %w", grp.ID, err)
+			}
+			if grp.Network.DNS != nil {
+				if dns, err = grp.Network.DNS.Spec(); err != nil {
+					return nil, fmt.Errorf("invalid network dns of group %s: %w", grp.ID, err)
+				}
+			}
+		}
+
+		var chaos []api.ChaosAction
+		for i, c := range grp.Chaos {
+			a, err := c.Spec()
+			if err != nil {
+				return nil, fmt.Errorf("invalid chaos action %d of group %s: %w", i, grp.ID, err)
+			}
+			chaos = append(chaos, *a)
+		}
+
 		g := &api.RunGroup{
 			ID:           grp.ID,
 			Instances:    int(grp.CalculatedInstanceCount()),
@@ -579,13 +960,57 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 			Parameters:   grp.Run.TestParams,
 			Resources:    grp.Resources,
 			Profiles:     grp.Run.Profiles,
+			RunnerConfig: gobj,
+			StartAfter:   grp.StartAfter,
+			Timeout:      grp.TimeoutDuration(),
+			Network:      shape,
+
+			NetworkTimeline: timeline,
+			NetworkRules:    rules,
+			NAT:             nat,
+			Capture:         capture,
+			NetworkMetrics:  metrics,
+			DNS:             dns,
+			Chaos:           chaos,
 		}
 
 		in.Groups = append(in.Groups, g)
 	}
 
+	// Stop the run once it times out; runners tear it down when the context
+	// is done, like when the run is canceled.
+	rctx := ctx
+	if timeout := comp.RunTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	ow.Infow("starting run", "run_id", id, "plan", in.TestPlan, "case", in.TestCase, "runner", trunner, "instances", in.TotalInstances)
-	out, err := run.Run(ctx, &in, ow)
+	start := time.Now()
+	out, err := run.Run(rctx, &in, ow)
+
+	// Upload the outputs to the object store, if any, unless the task was
+	// canceled; they're collected from there afterwards.
+	if ctx.Err() == nil {
+		e.uploadOutputs(ctx, comp, run, obj, id, out, ow)
+	}
+
+	// Runs that time out complete with the timeout outcome, so that their
+	// partial outputs can be collected like those of any other run.
+	if out, timedOut := checkTimeouts(id, comp, out, err, time.Since(start), rctx.Err() == context.DeadlineExceeded && ctx.Err() == nil); timedOut {
+		ow.Warnw("run timed out", "run_id", id, "plan", plan, "case", tcase, "runner", trunner, "result", out.Result, "error", err)
+		out.Composition = input.Composition
+		return out, nil
+	}
+
+	// Runs in which no more instances failed than the composition allows
+	// pass with exceptions, although the runner failed them.
+	if out, ok := tolerateFailures(comp, out, err); ok {
+		ow.Warnw("run passed with exceptions", "run_id", id, "plan", plan, "case", tcase, "runner", trunner, "result", out.Result, "error", err)
+		out.Composition = input.Composition
+		return out, nil
+	}
 
 	if err == nil {
 		message := "run finished with outcome unknown"
@@ -607,6 +1032,56 @@ func (e *Engine) doRun(ctx context.Context, id string, input *RunInput, ow *rpc.
 	return out, err
 }
 
+// checkTimeouts returns whether a run timed out: if the run itself did, or
+// if instances of a group were still running when its timeout elapsed, as
+// far as the result tells. The result of the returned output is then marked
+// as timed out.
+func checkTimeouts(id string, comp *api.Composition, out *api.RunOutput, err error, took time.Duration, runTimedOut bool) (*api.RunOutput, bool) {
+	var res *runner.Result
+	if out != nil {
+		res, _ = out.Result.(*runner.Result)
+	}
+	if res == nil {
+		res = &runner.Result{Outcomes: make(map[string]*runner.GroupOutcome)}
+	}
+
+	var groups []string
+	for _, grp := range comp.Groups {
+		if d := grp.TimeoutDuration(); !runTimedOut && (d == 0 || took < d) {
+			continue
+		}
+		// groups the runner reports no outcome for didn't complete if the
+		// run failed.
+		if o, ok := res.Outcomes[grp.ID]; (ok && o.Ok < o.Total) || (!ok && err != nil) {
+			groups = append(groups, grp.ID)
+		}
+	}
+	if !runTimedOut && len(groups) == 0 {
+		return out, false
+	}
+
+	if out == nil {
+		out = &api.RunOutput{RunID: id}
+	}
+	res.TimeOut(groups...)
+	out.Result = res
+	return out, true
+}
+
+// tolerateFailures returns whether the run that failed with err passed with
+// exceptions, as far as the result tells, and marks its result as such. Runs
+// that failed because of the infrastructure, or were canceled, don't.
+func tolerateFailures(comp *api.Composition, out *api.RunOutput, err error) (*api.RunOutput, bool) {
+	if out == nil || api.FailureClass(err) == api.FailureInfra || errors.Is(err, context.Canceled) {
+		return out, false
+	}
+	res, ok := out.Result.(*runner.Result)
+	if !ok {
+		return out, false
+	}
+	return out, res.TolerateFailures(comp.AllowedFailures())
+}
+
 func clean(name string) string {
 	forbiddenChar := "/"
 
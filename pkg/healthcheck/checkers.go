@@ -8,6 +8,7 @@ import (
 	"os/exec"
 
 	"github.com/testground/testground/pkg/docker"
+	"github.com/testground/testground/pkg/nomad"
 	"github.com/testground/testground/pkg/rpc"
 
 	"github.com/docker/docker/client"
@@ -27,6 +28,30 @@ func CheckContainerStarted(ctx context.Context, ow *rpc.OutputWriter, cli *clien
 	}
 }
 
+// CheckDockerDaemon returns a Checker that succeeds if the docker daemon is
+// reachable, and fails otherwise.
+func CheckDockerDaemon(ctx context.Context, cli *client.Client) Checker {
+	return func() (bool, string, error) {
+		ping, err := cli.Ping(ctx)
+		if err != nil {
+			return false, fmt.Sprintf("docker daemon not reachable at %s: %s", cli.DaemonHost(), err), nil
+		}
+		return true, fmt.Sprintf("docker daemon reachable at %s; api version: %s", cli.DaemonHost(), ping.APIVersion), nil
+	}
+}
+
+// CheckK8sAPI returns a Checker that succeeds if the kubernetes API server of
+// the current context is reachable, and fails otherwise.
+func CheckK8sAPI(client *kubernetes.Clientset) Checker {
+	return func() (bool, string, error) {
+		v, err := client.Discovery().ServerVersion()
+		if err != nil {
+			return false, fmt.Sprintf("kubernetes api not reachable; is the kubectl context valid? %s", err), nil
+		}
+		return true, fmt.Sprintf("kubernetes api reachable; server version: %s", v.GitVersion), nil
+	}
+}
+
 // CheckNetwork returns a Checker that succeeds if the specified network exists,
 // and fails otherwise.
 func CheckNetwork(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client, networkID string) Checker {
@@ -118,6 +143,33 @@ func CheckK8sPods(ctx context.Context, client *kubernetes.Clientset, label strin
 	}
 }
 
+// CheckNomadAPI returns a Checker that succeeds if the Nomad API is
+// reachable, and fails otherwise.
+func CheckNomadAPI(ctx context.Context, client *nomad.Client) Checker {
+	return func() (bool, string, error) {
+		leader, err := client.Leader(ctx)
+		if err != nil {
+			return false, fmt.Sprintf("nomad api not reachable at %s: %s", client.Address, err), nil
+		}
+		return true, fmt.Sprintf("nomad api reachable; leader: %s", leader), nil
+	}
+}
+
+// CheckNomadJob returns a Checker that succeeds if the Nomad job is running,
+// and fails otherwise.
+func CheckNomadJob(ctx context.Context, client *nomad.Client, id string) Checker {
+	return func() (bool, string, error) {
+		job, err := client.Job(ctx, id)
+		if err != nil {
+			return false, fmt.Sprintf("failed to get job %s: %s", id, err), nil
+		}
+		if job.Status != "running" {
+			return false, fmt.Sprintf("job %s is %s", id, job.Status), nil
+		}
+		return true, fmt.Sprintf("job %s is running", id), nil
+	}
+}
+
 // CheckRedisPort returns a checker which verifies if the default port of redis (6379) is already binded
 // on localhost. If it is, it fails. If not, it succeeds.
 func CheckRedisPort(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client) Checker {
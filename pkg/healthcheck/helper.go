@@ -24,9 +24,10 @@ type Checker func() (ok bool, msg string, err error)
 type Fixer func() (msg string, err error)
 
 type item struct {
-	Name    string
-	Checker Checker
-	Fixer   Fixer
+	Name     string
+	Checker  Checker
+	Fixer    Fixer
+	Required bool
 }
 
 // Helper is a utility that facilitates the execution of healthchecks.
@@ -42,6 +43,10 @@ type item struct {
 // For each item, the Checker runs first. If it results in a "failed" status,
 // and an associated Fixer is registered, we run the Fixer, if and only if
 // "fix" mode is requested when calling RunChecks.
+//
+// Healthchecks registered via Require() are preconditions for all the ones
+// that follow: if they fail and are not fixed, the remaining healthchecks are
+// omitted.
 type Helper struct {
 	sync.Mutex
 
@@ -56,7 +61,17 @@ func (h *Helper) Enlist(name string, c Checker, f Fixer) {
 	h.Lock()
 	defer h.Unlock()
 
-	h.items = append(h.items, &item{name, c, f})
+	h.items = append(h.items, &item{Name: name, Checker: c, Fixer: f})
+}
+
+// Require registers a healthcheck like Enlist, but marks it as a
+// precondition for all the healthchecks enlisted after it, e.g. the docker
+// daemon being reachable.
+func (h *Helper) Require(name string, c Checker, f Fixer) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.items = append(h.items, &item{Name: name, Checker: c, Fixer: f, Required: true})
 }
 
 // RunChecks runs the checks and returns an api.HealthcheckReport, or a non-nil
@@ -71,9 +86,23 @@ func (h *Helper) RunChecks(_ context.Context, fix bool) (*api.HealthcheckReport,
 	}
 
 	h.report = new(api.HealthcheckReport)
+
+	// unmet is the name of the first required healthcheck that failed.
+	var unmet string
 	for _, li := range h.items {
 		check := api.HealthcheckItem{Name: li.Name}
 
+		if unmet != "" {
+			check.Status = api.HealthcheckStatusOmitted
+			check.Message = fmt.Sprintf("depends on %s", unmet)
+			h.report.Checks = append(h.report.Checks, check)
+
+			if fix && li.Fixer != nil {
+				h.report.Fixes = append(h.report.Fixes, api.HealthcheckItem{Name: li.Name, Status: api.HealthcheckStatusOmitted})
+			}
+			continue
+		}
+
 		// Check succeeds.
 		ok, msg, err := li.Checker()
 		switch {
@@ -82,6 +111,10 @@ func (h *Helper) RunChecks(_ context.Context, fix bool) (*api.HealthcheckReport,
 			check.Message = fmt.Sprintf("%s; error: %s", msg, err)
 			h.report.Checks = append(h.report.Checks, check)
 
+			if li.Required {
+				unmet = li.Name
+			}
+
 			if fix && li.Fixer != nil {
 				h.report.Fixes = append(h.report.Fixes, api.HealthcheckItem{Name: li.Name, Status: api.HealthcheckStatusOmitted})
 			}
@@ -101,6 +134,12 @@ func (h *Helper) RunChecks(_ context.Context, fix bool) (*api.HealthcheckReport,
 			check.Message = msg
 			h.report.Checks = append(h.report.Checks, check)
 
+			if li.Fixer == nil || !fix {
+				if li.Required {
+					unmet = li.Name
+				}
+			}
+
 			if li.Fixer == nil {
 				// no fixer; move on to next check.
 				continue
@@ -117,6 +156,9 @@ func (h *Helper) RunChecks(_ context.Context, fix bool) (*api.HealthcheckReport,
 			msg, err := li.Fixer()
 			if err != nil {
 				f = api.HealthcheckItem{Name: li.Name, Status: api.HealthcheckStatusFailed, Message: msg}
+				if li.Required {
+					unmet = li.Name
+				}
 			} else {
 				f = api.HealthcheckItem{Name: li.Name, Status: api.HealthcheckStatusOK, Message: msg}
 			}
@@ -0,0 +1,52 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+func TestRequiredCheckOmitsDependents(t *testing.T) {
+	failing := func() (bool, string, error) { return false, "down", nil }
+	passing := func() (bool, string, error) { return true, "", nil }
+
+	hh := &Helper{}
+	hh.Require("daemon", failing, nil)
+	hh.Enlist("network", passing, CreateDirectory("/nonexistent"))
+
+	report, err := hh.RunChecks(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, api.HealthcheckStatusFailed, report.Checks[0].Status)
+	require.Equal(t, api.HealthcheckStatusOmitted, report.Checks[1].Status)
+	require.Equal(t, []api.HealthcheckItem{{Name: "network", Status: api.HealthcheckStatusOmitted}}, report.Fixes)
+	require.False(t, report.ChecksSucceeded())
+}
+
+func TestFixedRequiredCheckRunsDependents(t *testing.T) {
+	fixed := false
+	check := func() (bool, string, error) { return fixed, "", nil }
+	fixer := func() (string, error) { fixed = true; return "fixed", nil }
+
+	hh := &Helper{}
+	hh.Require("daemon", check, fixer)
+	hh.Enlist("network", check, nil)
+
+	report, err := hh.RunChecks(context.Background(), true)
+	require.NoError(t, err)
+	require.Equal(t, api.HealthcheckStatusOK, report.Checks[1].Status)
+	require.True(t, report.FixesSucceeded())
+
+	hh = &Helper{}
+	hh.Require("daemon", func() (bool, string, error) { return false, "", errors.New("boom") }, nil)
+	hh.Enlist("network", check, nil)
+
+	report, err = hh.RunChecks(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, api.HealthcheckStatusAborted, report.Checks[0].Status)
+	require.Equal(t, api.HealthcheckStatusOmitted, report.Checks[1].Status)
+}
@@ -0,0 +1,375 @@
+// Package nomad is a client of the HTTP API of HashiCorp Nomad, covering what
+// the cluster:nomad runner needs: registering batch jobs, following their
+// allocations, and reading their logs.
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultAddress is the address of the Nomad API, unless NOMAD_ADDR is set.
+const DefaultAddress = "http://127.0.0.1:4646"
+
+// Client is a client of the Nomad API. Region and Namespace, if set, apply
+// to every request.
+type Client struct {
+	Address   string
+	Token     string
+	Region    string
+	Namespace string
+
+	HTTP *http.Client
+}
+
+// NewClient returns a client of the Nomad API at the address, with the ACL
+// token. They default to the NOMAD_ADDR and NOMAD_TOKEN environment
+// variables, like the nomad CLI.
+func NewClient(address, token string) *Client {
+	if address == "" {
+		address = os.Getenv("NOMAD_ADDR")
+	}
+	if address == "" {
+		address = DefaultAddress
+	}
+	if token == "" {
+		token = os.Getenv("NOMAD_TOKEN")
+	}
+	return &Client{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Job is a Nomad job. Only the fields the runner sets are declared.
+type Job struct {
+	ID          string            `json:"ID"`
+	Name        string            `json:"Name"`
+	Type        string            `json:"Type"`
+	Region      string            `json:"Region,omitempty"`
+	Namespace   string            `json:"Namespace,omitempty"`
+	Datacenters []string          `json:"Datacenters"`
+	Constraints []*Constraint     `json:"Constraints,omitempty"`
+	TaskGroups  []*TaskGroup      `json:"TaskGroups"`
+	Meta        map[string]string `json:"Meta,omitempty"`
+	Status      string            `json:"Status,omitempty"`
+}
+
+// Constraint restricts the nodes a job, or a task group, is placed on.
+type Constraint struct {
+	LTarget string `json:"LTarget"`
+	RTarget string `json:"RTarget"`
+	Operand string `json:"Operand"`
+}
+
+// TaskGroup is a group of tasks placed together, Count times.
+type TaskGroup struct {
+	Name             string                    `json:"Name"`
+	Count            int                       `json:"Count"`
+	Constraints      []*Constraint             `json:"Constraints,omitempty"`
+	RestartPolicy    *RestartPolicy            `json:"RestartPolicy,omitempty"`
+	ReschedulePolicy *ReschedulePolicy         `json:"ReschedulePolicy,omitempty"`
+	Volumes          map[string]*VolumeRequest `json:"Volumes,omitempty"`
+	Tasks            []*Task                   `json:"Tasks"`
+	Meta             map[string]string         `json:"Meta,omitempty"`
+}
+
+// RestartPolicy is how tasks are restarted on the node they're placed on.
+type RestartPolicy struct {
+	Attempts int    `json:"Attempts"`
+	Mode     string `json:"Mode"`
+}
+
+// ReschedulePolicy is how failed allocations are placed again.
+type ReschedulePolicy struct {
+	Attempts  int  `json:"Attempts"`
+	Unlimited bool `json:"Unlimited"`
+}
+
+// VolumeRequest requests a volume for the tasks of a group.
+type VolumeRequest struct {
+	Name     string `json:"Name"`
+	Type     string `json:"Type"`
+	Source   string `json:"Source"`
+	ReadOnly bool   `json:"ReadOnly"`
+}
+
+// Task is a task of a group, run by a driver.
+type Task struct {
+	Name         string                 `json:"Name"`
+	Driver       string                 `json:"Driver"`
+	Config       map[string]interface{} `json:"Config"`
+	Env          map[string]string      `json:"Env,omitempty"`
+	Resources    *Resources             `json:"Resources,omitempty"`
+	VolumeMounts []*VolumeMount         `json:"VolumeMounts,omitempty"`
+}
+
+// Resources are the resources reserved for a task.
+type Resources struct {
+	CPU      int `json:"CPU"`      // MHz
+	MemoryMB int `json:"MemoryMB"` // MB
+}
+
+// VolumeMount mounts a volume of the group in a task.
+type VolumeMount struct {
+	Volume      string `json:"Volume"`
+	Destination string `json:"Destination"`
+	ReadOnly    bool   `json:"ReadOnly"`
+}
+
+// Statuses of allocations on the clients.
+const (
+	AllocPending  = "pending"
+	AllocRunning  = "running"
+	AllocComplete = "complete"
+	AllocFailed   = "failed"
+	AllocLost     = "lost"
+)
+
+// Allocation is an instance of a task group, placed on a node.
+type Allocation struct {
+	ID           string                `json:"ID"`
+	Name         string                `json:"Name"` // <job>.<group>[<index>]
+	JobID        string                `json:"JobID"`
+	TaskGroup    string                `json:"TaskGroup"`
+	NodeID       string                `json:"NodeID"`
+	ClientStatus string                `json:"ClientStatus"`
+	TaskStates   map[string]*TaskState `json:"TaskStates"`
+}
+
+// Terminal returns whether the allocation is done.
+func (a *Allocation) Terminal() bool {
+	switch a.ClientStatus {
+	case AllocComplete, AllocFailed, AllocLost:
+		return true
+	}
+	return false
+}
+
+// Index returns the index of the allocation in its task group, or -1.
+func (a *Allocation) Index() int {
+	var idx int
+	i := strings.LastIndex(a.Name, "[")
+	if i < 0 {
+		return -1
+	}
+	if _, err := fmt.Sscanf(a.Name[i:], "[%d]", &idx); err != nil {
+		return -1
+	}
+	return idx
+}
+
+// TaskState is the state of a task of an allocation.
+type TaskState struct {
+	State  string       `json:"State"`
+	Failed bool         `json:"Failed"`
+	Events []*TaskEvent `json:"Events"`
+}
+
+// TaskEvent is an event of a task, like its image failing to download.
+type TaskEvent struct {
+	Type           string `json:"Type"`
+	DisplayMessage string `json:"DisplayMessage"`
+}
+
+// Evaluation is a decision of the scheduler about a job.
+type Evaluation struct {
+	ID                string                       `json:"ID"`
+	Status            string                       `json:"Status"`
+	StatusDescription string                       `json:"StatusDescription"`
+	BlockedEval       string                       `json:"BlockedEval"`
+	FailedTGAllocs    map[string]*AllocationMetric `json:"FailedTGAllocs"`
+}
+
+// AllocationMetric is why allocations of a task group couldn't be placed.
+type AllocationMetric struct {
+	NodesEvaluated     int            `json:"NodesEvaluated"`
+	NodesFiltered      int            `json:"NodesFiltered"`
+	NodesExhausted     int            `json:"NodesExhausted"`
+	ConstraintFiltered map[string]int `json:"ConstraintFiltered"`
+	DimensionExhausted map[string]int `json:"DimensionExhausted"`
+}
+
+func (m *AllocationMetric) String() string {
+	s := fmt.Sprintf("%d nodes evaluated, %d filtered, %d exhausted", m.NodesEvaluated, m.NodesFiltered, m.NodesExhausted)
+	for dim, n := range m.DimensionExhausted {
+		s += fmt.Sprintf("; %s exhausted on %d nodes", dim, n)
+	}
+	for c, n := range m.ConstraintFiltered {
+		s += fmt.Sprintf("; %d nodes filtered by %s", n, c)
+	}
+	return s
+}
+
+// JobListStub is a job, as listed.
+type JobListStub struct {
+	ID     string            `json:"ID"`
+	Name   string            `json:"Name"`
+	Type   string            `json:"Type"`
+	Status string            `json:"Status"`
+	Meta   map[string]string `json:"Meta"`
+}
+
+// NodeListStub is a node, as listed.
+type NodeListStub struct {
+	ID         string `json:"ID"`
+	Name       string `json:"Name"`
+	Datacenter string `json:"Datacenter"`
+	NodeClass  string `json:"NodeClass"`
+	Status     string `json:"Status"`
+}
+
+// Leader returns the address of the leader of the cluster, which checks
+// that the API is reachable.
+func (c *Client) Leader(ctx context.Context) (string, error) {
+	var leader string
+	err := c.do(ctx, http.MethodGet, "/v1/status/leader", nil, nil, &leader)
+	return leader, err
+}
+
+// RegisterJob registers a job, or updates it.
+func (c *Client) RegisterJob(ctx context.Context, job *Job) error {
+	return c.do(ctx, http.MethodPut, "/v1/jobs", nil, map[string]*Job{"Job": job}, nil)
+}
+
+// DeregisterJob stops a job, and purges it if purge is set.
+func (c *Client) DeregisterJob(ctx context.Context, id string, purge bool) error {
+	q := url.Values{}
+	if purge {
+		q.Set("purge", "true")
+	}
+	return c.do(ctx, http.MethodDelete, "/v1/job/"+url.PathEscape(id), q, nil, nil)
+}
+
+// Job returns the job with the ID.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(id), nil, nil, &job)
+	return &job, err
+}
+
+// Jobs returns the jobs whose IDs start with the prefix.
+func (c *Client) Jobs(ctx context.Context, prefix string) ([]*JobListStub, error) {
+	q := url.Values{}
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	var jobs []*JobListStub
+	err := c.do(ctx, http.MethodGet, "/v1/jobs", q, nil, &jobs)
+	return jobs, err
+}
+
+// Allocations returns the allocations of the job.
+func (c *Client) Allocations(ctx context.Context, jobID string) ([]*Allocation, error) {
+	var allocs []*Allocation
+	err := c.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/allocations", nil, nil, &allocs)
+	return allocs, err
+}
+
+// Evaluations returns the evaluations of the job.
+func (c *Client) Evaluations(ctx context.Context, jobID string) ([]*Evaluation, error) {
+	var evals []*Evaluation
+	err := c.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(jobID)+"/evaluations", nil, nil, &evals)
+	return evals, err
+}
+
+// Nodes returns the client nodes of the cluster.
+func (c *Client) Nodes(ctx context.Context) ([]*NodeListStub, error) {
+	var nodes []*NodeListStub
+	err := c.do(ctx, http.MethodGet, "/v1/nodes", nil, nil, &nodes)
+	return nodes, err
+}
+
+// Logs returns the logs of a task of an allocation, from the start; typ is
+// stdout or stderr.
+func (c *Client) Logs(ctx context.Context, allocID, task, typ string) (io.ReadCloser, error) {
+	q := url.Values{
+		"task":   {task},
+		"type":   {typ},
+		"origin": {"start"},
+		"plain":  {"true"},
+	}
+	resp, err := c.request(ctx, http.MethodGet, "/v1/client/fs/logs/"+url.PathEscape(allocID), q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	resp, err := c.request(ctx, method, path, q, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func (c *Client) request(ctx context.Context, method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+	if c.Region != "" {
+		q.Set("region", c.Region)
+	}
+	if c.Namespace != "" {
+		q.Set("namespace", c.Namespace)
+	}
+
+	u := c.Address + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Nomad-Token", c.Token)
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("nomad: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
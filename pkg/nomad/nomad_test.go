@@ -0,0 +1,78 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterJob(t *testing.T) {
+	var got struct{ Job *Job }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		require.Equal(t, "/v1/jobs", r.URL.Path)
+		require.Equal(t, "secret", r.Header.Get("X-Nomad-Token"))
+		require.Equal(t, "eu", r.URL.Query().Get("region"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		_, _ = w.Write([]byte(`{"EvalID":"1"}`))
+	}))
+	defer srv.Close()
+
+	cl := NewClient(srv.URL, "secret")
+	cl.Region = "eu"
+	err := cl.RegisterJob(context.Background(), &Job{ID: "tg-run-a", Type: "batch"})
+	require.NoError(t, err)
+	require.Equal(t, "tg-run-a", got.Job.ID)
+}
+
+func TestAllocations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/job/tg-run-a/allocations", r.URL.Path)
+		_, _ = w.Write([]byte(`[
+			{"ID":"1","Name":"tg-run-a.a[0]","ClientStatus":"complete"},
+			{"ID":"2","Name":"tg-run-a.a[12]","ClientStatus":"running"}
+		]`))
+	}))
+	defer srv.Close()
+
+	allocs, err := NewClient(srv.URL, "").Allocations(context.Background(), "tg-run-a")
+	require.NoError(t, err)
+	require.Len(t, allocs, 2)
+	require.True(t, allocs[0].Terminal())
+	require.False(t, allocs[1].Terminal())
+	require.Equal(t, 0, allocs[0].Index())
+	require.Equal(t, 12, allocs[1].Index())
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("Permission denied\n"))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "").Job(context.Background(), "tg-run-a")
+	require.EqualError(t, err, "nomad: GET /v1/job/tg-run-a: 403 Forbidden: Permission denied")
+}
+
+func TestLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/client/fs/logs/1", r.URL.Path)
+		require.Equal(t, "plan", r.URL.Query().Get("task"))
+		require.Equal(t, "stderr", r.URL.Query().Get("type"))
+		_, _ = w.Write([]byte("hello\n"))
+	}))
+	defer srv.Close()
+
+	rc, err := NewClient(srv.URL, "").Logs(context.Background(), "1", "plan", "stderr")
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(b))
+}
@@ -0,0 +1,94 @@
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/testground/testground/pkg/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Store is a Store backed by an S3-compatible object store.
+type s3Store struct {
+	bucket string
+	prefix string
+	sess   *session.Session
+}
+
+func newS3Store(cfg config.OutputsConfig) (*s3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("outputs: no bucket configured")
+	}
+
+	c := aws.NewConfig().WithS3ForcePathStyle(cfg.PathStyle)
+	if cfg.Endpoint != "" {
+		c = c.WithEndpoint(cfg.Endpoint)
+		if cfg.Region == "" {
+			// stores other than AWS S3 ignore the region, but the SDK
+			// requires one.
+			cfg.Region = "us-east-1"
+		}
+	}
+	if cfg.Region != "" {
+		c = c.WithRegion(cfg.Region)
+	}
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
+		c = c.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(c)
+	if err != nil {
+		return nil, fmt.Errorf("outputs: %w", err)
+	}
+	return &s3Store{bucket: cfg.Bucket, prefix: cfg.Prefix, sess: sess}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, runID string, r io.Reader) (string, error) {
+	key := Key(s.prefix, runID)
+	_, err := s3manager.NewUploader(s.sess).UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String("application/gzip"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload outputs to bucket %s: %w", s.bucket, err)
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, url string, w io.Writer) error {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return err
+	}
+
+	out, err := s3.New(s.sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download outputs from %s: %w", url, err)
+	}
+	defer out.Body.Close()
+
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+// parseS3URL splits an s3://<bucket>/<key> URL.
+func parseS3URL(url string) (bucket, key string, err error) {
+	p := strings.TrimPrefix(url, "s3://")
+	i := strings.Index(p, "/")
+	if p == url || i <= 0 || i == len(p)-1 {
+		return "", "", fmt.Errorf("invalid outputs URL %q; expected s3://<bucket>/<key>", url)
+	}
+	return p[:i], p[i+1:], nil
+}
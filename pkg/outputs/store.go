@@ -0,0 +1,64 @@
+// Package outputs uploads the archives of the outputs of runs to an object
+// store, and downloads them back when they're collected, so that they
+// outlive the disks of the daemon and the nodes of the clusters the runs ran
+// on.
+package outputs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+)
+
+// BackendS3 is the backend of S3-compatible stores.
+const BackendS3 = "s3"
+
+// Store is an object store that the archives of the outputs of runs are
+// uploaded to.
+type Store interface {
+	// Put uploads the archive of the outputs of a run, read from r, and
+	// returns its URL.
+	Put(ctx context.Context, runID string, r io.Reader) (string, error)
+
+	// Get downloads the archive at the URL returned by Put into w.
+	Get(ctx context.Context, url string, w io.Writer) error
+}
+
+// NewStore returns the store configured in the environment, with the location
+// of the outputs overridden by loc, if not nil. It returns nil if no store is
+// configured.
+func NewStore(env config.EnvConfig, loc *api.OutputsLocation) (Store, error) {
+	cfg := env.Outputs
+	if loc != nil {
+		if loc.Bucket != "" {
+			cfg.Bucket = loc.Bucket
+		}
+		if loc.Prefix != "" {
+			cfg.Prefix = loc.Prefix
+		}
+	}
+
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case BackendS3:
+		if cfg.Region == "" {
+			cfg.Region = env.AWS.Region
+		}
+		if cfg.AccessKeyID == "" && cfg.SecretAccessKey == "" {
+			cfg.AccessKeyID, cfg.SecretAccessKey = env.AWS.AccessKeyID, env.AWS.SecretAccessKey
+		}
+		return newS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported outputs backend %q; supported: %s", cfg.Backend, BackendS3)
+	}
+}
+
+// Key returns the key of the archive of the outputs of a run.
+func Key(prefix, runID string) string {
+	return path.Join(prefix, runID+".tgz")
+}
@@ -0,0 +1,52 @@
+package outputs
+
+import (
+	"testing"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	// outputs stay with the runners by default.
+	s, err := NewStore(config.EnvConfig{}, &api.OutputsLocation{Bucket: "tg"})
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	env := config.EnvConfig{
+		AWS:     config.AWSConfig{Region: "eu-west-1"},
+		Outputs: config.OutputsConfig{Backend: BackendS3, Bucket: "tg", Prefix: "runs"},
+	}
+	s, err = NewStore(env, nil)
+	require.NoError(t, err)
+	require.Equal(t, "tg", s.(*s3Store).bucket)
+	require.Equal(t, "eu-west-1", *s.(*s3Store).sess.Config.Region)
+
+	// compositions override the location.
+	s, err = NewStore(env, &api.OutputsLocation{Bucket: "other", Prefix: "ci"})
+	require.NoError(t, err)
+	require.Equal(t, "other", s.(*s3Store).bucket)
+	require.Equal(t, "ci", s.(*s3Store).prefix)
+
+	_, err = NewStore(config.EnvConfig{Outputs: config.OutputsConfig{Backend: BackendS3}}, nil)
+	require.Error(t, err)
+	_, err = NewStore(config.EnvConfig{Outputs: config.OutputsConfig{Backend: "ftp", Bucket: "tg"}}, nil)
+	require.Error(t, err)
+}
+
+func TestKeys(t *testing.T) {
+	require.Equal(t, "c1a2b3.tgz", Key("", "c1a2b3"))
+	require.Equal(t, "ci/nightly/c1a2b3-2.tgz", Key("ci/nightly/", "c1a2b3-2"))
+
+	bucket, key, err := parseS3URL("s3://tg/ci/c1a2b3.tgz")
+	require.NoError(t, err)
+	require.Equal(t, "tg", bucket)
+	require.Equal(t, "ci/c1a2b3.tgz", key)
+
+	for _, url := range []string{"tg/c1a2b3.tgz", "s3://tg", "s3://tg/", "s3:///c1a2b3.tgz"} {
+		_, _, err = parseS3URL(url)
+		require.Error(t, err, url)
+	}
+}
@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/logging"
+)
+
+// Plugin is a running plugin, launched by Load.
+type Plugin struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	client *netrpc.Client
+	exited chan struct{}
+	desc   Description
+}
+
+// Load launches the plugin at path, with the given arguments, and asks it to
+// describe what it serves. The plugin keeps running until Close is called,
+// or the calling process exits.
+func Load(ctx context.Context, path string, args ...string) (*Plugin, error) {
+	p := &Plugin{
+		name:   filepath.Base(path),
+		cmd:    exec.Command(path, args...),
+		exited: make(chan struct{}),
+	}
+	p.cmd.Env = append(os.Environ(), EnvMagicCookie+"="+MagicCookie)
+	p.cmd.Stderr = &logWriter{name: p.name}
+
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p.stdin = stdin
+
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+
+	// the first line of the handshake format announces the socket; the
+	// plugin output is relayed to the logs otherwise.
+	handshake := make(chan string, 1)
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		s, done := bufio.NewScanner(stdout), false
+		for s.Scan() {
+			if line := s.Text(); !done && strings.Count(line, "|") == 2 {
+				handshake <- line
+				done = true
+			} else {
+				logging.S().Infow(line, "plugin", p.name)
+			}
+		}
+	}()
+	go func() {
+		// Wait closes stdout, so it waits for all of it to be read first.
+		<-scanned
+		err := p.cmd.Wait()
+		logging.S().Infow("plugin exited", "plugin", p.name, "err", err)
+		close(p.exited)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	var line string
+	select {
+	case line = <-handshake:
+	case <-p.exited:
+		return nil, fmt.Errorf("plugin %s exited before the handshake", path)
+	case <-ctx.Done():
+		p.kill()
+		return nil, fmt.Errorf("plugin %s didn't complete the handshake: %w", path, ctx.Err())
+	}
+
+	if err := p.connect(line); err != nil {
+		p.kill()
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	if err := p.client.Call(serviceName+".Describe", &Empty{}, &p.desc); err != nil {
+		p.kill()
+		return nil, fmt.Errorf("plugin %s failed to describe itself: %w", path, err)
+	}
+	return p, nil
+}
+
+// connect connects to the socket announced in the handshake line.
+func (p *Plugin) connect(line string) error {
+	parts := strings.SplitN(line, "|", 3)
+	if v, err := strconv.Atoi(parts[0]); err != nil || v != ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %q; expected %d", parts[0], ProtocolVersion)
+	}
+	if parts[1] != "unix" {
+		return fmt.Errorf("unsupported network %q", parts[1])
+	}
+	conn, err := net.Dial("unix", parts[2])
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	p.client = jsonrpc.NewClient(conn)
+	return nil
+}
+
+// Builders returns proxies to the builders served by the plugin.
+func (p *Plugin) Builders() []api.Builder {
+	res := make([]api.Builder, 0, len(p.desc.Builders))
+	for _, info := range p.desc.Builders {
+		res = append(res, &remoteBuilder{p: p, info: info})
+	}
+	return res
+}
+
+// Runners returns proxies to the runners served by the plugin. Proxies to
+// runners that can be terminated implement api.Terminatable, and those to
+// runners that can clean up after runs implement api.Cleaner.
+func (p *Plugin) Runners() []api.Runner {
+	res := make([]api.Runner, 0, len(p.desc.Runners))
+	for _, info := range p.desc.Runners {
+		r := &remoteRunner{p: p, info: info}
+		switch {
+		case info.Terminatable && info.Cleaner:
+			res = append(res, &terminatableCleanerRunner{&terminatableRunner{r}})
+		case info.Terminatable:
+			res = append(res, &terminatableRunner{r})
+		case info.Cleaner:
+			res = append(res, &cleanerRunner{r})
+		default:
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Close stops the plugin, killing it if it doesn't exit in time.
+func (p *Plugin) Close() error {
+	if p.client != nil {
+		_ = p.client.Close()
+	}
+	_ = p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-time.After(5 * time.Second):
+		p.kill()
+	}
+	return nil
+}
+
+func (p *Plugin) kill() {
+	_ = p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// logWriter relays the stderr of a plugin to the logs.
+type logWriter struct{ name string }
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		logging.S().Warnw(line, "plugin", w.name)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/runner"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/stretchr/testify/require"
+)
+
+// envHelper makes the test binary serve the fake builder and runner below,
+// when it is launched as a plugin by the tests.
+const envHelper = "TESTGROUND_PLUGIN_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(envHelper) != "" {
+		if err := Serve([]api.Builder{&fakeBuilder{}}, []api.Runner{&fakeRunner{}}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type fakeConfig struct {
+	Image string `toml:"image"`
+	Count int    `toml:"count"`
+}
+
+type fakeBuilder struct{}
+
+func (*fakeBuilder) ID() string               { return "fake:builder" }
+func (*fakeBuilder) ConfigType() reflect.Type { return reflect.TypeOf(fakeConfig{}) }
+
+func (*fakeBuilder) Build(_ context.Context, in *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
+	cfg := in.BuildConfig.(*fakeConfig)
+	ow.Infow("building", "plan", in.TestPlan)
+	return &api.BuildOutput{
+		BuilderID:    "fake:builder",
+		ArtifactPath: fmt.Sprintf("%s:%d@%s", cfg.Image, cfg.Count, in.EnvConfig.Dirs().Home()),
+	}, nil
+}
+
+func (*fakeBuilder) Purge(context.Context, string, *rpc.OutputWriter) error {
+	return fmt.Errorf("nothing to purge")
+}
+
+type fakeRunner struct{}
+
+func (*fakeRunner) ID() string                   { return "fake:runner" }
+func (*fakeRunner) ConfigType() reflect.Type     { return reflect.TypeOf(fakeConfig{}) }
+func (*fakeRunner) CompatibleBuilders() []string { return []string{"fake:builder"} }
+
+func (*fakeRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{TrafficShaping: true}
+}
+
+func (*fakeRunner) Run(ctx context.Context, in *api.RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	if in.TestCase == "hang" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	res := &runner.Result{Outcome: task.OutcomeSuccess, Outcomes: map[string]*runner.GroupOutcome{}}
+	for _, g := range in.Groups {
+		cfg := g.RunnerConfig.(*fakeConfig)
+		res.Outcomes[g.ID] = &runner.GroupOutcome{Ok: cfg.Count, Total: g.Instances}
+	}
+	return &api.RunOutput{RunID: in.RunID, Result: res}, nil
+}
+
+func (*fakeRunner) CollectOutputs(_ context.Context, _ *api.CollectionInput, ow *rpc.OutputWriter) error {
+	_, err := ow.BinaryWriter().Write([]byte("outputs"))
+	return err
+}
+
+func (*fakeRunner) Cleanup(_ context.Context, runID string, ow *rpc.OutputWriter) error {
+	ow.Infow("cleaning up", "run_id", runID)
+	return nil
+}
+
+func (*fakeRunner) Leftovers(context.Context) ([]string, error) {
+	return []string{"run1", "run2"}, nil
+}
+
+func loadHelper(t *testing.T) *Plugin {
+	t.Helper()
+
+	os.Setenv(envHelper, "1")
+	defer os.Unsetenv(envHelper)
+
+	p, err := Load(context.Background(), os.Args[0])
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestPlugin(t *testing.T) {
+	p := loadHelper(t)
+
+	builders, runners := p.Builders(), p.Runners()
+	require.Len(t, builders, 1)
+	require.Len(t, runners, 1)
+	require.Equal(t, "fake:builder", builders[0].ID())
+	require.Equal(t, "fake:runner", runners[0].ID())
+	require.Equal(t, []string{"fake:builder"}, runners[0].CompatibleBuilders())
+	require.Equal(t, api.Capabilities{TrafficShaping: true}, runners[0].(api.Capable).Capabilities())
+	_, ok := runners[0].(api.Terminatable)
+	require.False(t, ok)
+	cl, ok := runners[0].(api.Cleaner)
+	require.True(t, ok)
+
+	env := config.EnvConfig{}.WithHome("/tmp/tghome")
+
+	// configurations reach the plugin with their types.
+	var (
+		cfg = map[string]interface{}{"image": "alpine", "count": 3}
+		buf = new(bytes.Buffer)
+		ow  = rpc.NewFileOutputWriter(buf)
+	)
+	out, err := builders[0].Build(context.Background(), &api.BuildInput{
+		TestPlan:    "network",
+		EnvConfig:   env,
+		BuildConfig: &cfg,
+	}, ow)
+	require.NoError(t, err)
+	require.Equal(t, "alpine:3@/tmp/tghome", out.ArtifactPath)
+	require.Contains(t, chunks(t, buf, rpc.ChunkTypeProgress), "building")
+
+	err = builders[0].Purge(context.Background(), "network", rpc.Discard())
+	require.EqualError(t, err, "nothing to purge")
+
+	rout, err := runners[0].Run(context.Background(), &api.RunInput{
+		RunID:     "run1",
+		EnvConfig: env,
+		Groups: []*api.RunGroup{
+			{ID: "a", Instances: 2, RunnerConfig: &map[string]interface{}{"count": 2}},
+			{ID: "b", Instances: 2, RunnerConfig: &map[string]interface{}{"count": 1}},
+		},
+	}, rpc.Discard())
+	require.NoError(t, err)
+	require.Equal(t, "run1", rout.RunID)
+	res := rout.Result.(*runner.Result)
+	require.Equal(t, task.OutcomeSuccess, res.Outcome)
+	require.Equal(t, 2, res.Outcomes["a"].Ok)
+	require.Equal(t, 1, res.Outcomes["b"].Ok)
+
+	buf.Reset()
+	err = runners[0].CollectOutputs(context.Background(), &api.CollectionInput{RunID: "run1"}, ow)
+	require.NoError(t, err)
+	require.Equal(t, "outputs", chunks(t, buf, rpc.ChunkTypeBinary))
+
+	ids, err := cl.Leftovers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"run1", "run2"}, ids)
+
+	buf.Reset()
+	require.NoError(t, cl.Cleanup(context.Background(), "run1", ow))
+	require.Contains(t, chunks(t, buf, rpc.ChunkTypeProgress), "cleaning up")
+}
+
+// chunks returns the concatenated payloads of the chunks of the given type
+// written to buf by an rpc.OutputWriter.
+func chunks(t *testing.T, buf *bytes.Buffer, typ rpc.ChunkType) string {
+	t.Helper()
+
+	var res strings.Builder
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var chunk struct {
+			Type    rpc.ChunkType `json:"t"`
+			Payload []byte        `json:"p"`
+		}
+		require.NoError(t, dec.Decode(&chunk))
+		if chunk.Type == typ {
+			res.Write(chunk.Payload)
+		}
+	}
+	return res.String()
+}
+
+func TestPluginCancel(t *testing.T) {
+	p := loadHelper(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := p.Runners()[0].Run(ctx, &api.RunInput{TestCase: "hang"}, rpc.Discard())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServeRefusesWithoutCookie(t *testing.T) {
+	require.Error(t, Serve(nil, nil))
+}
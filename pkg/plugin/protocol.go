@@ -0,0 +1,170 @@
+// Package plugin lets builders and runners live out of tree, in plugins: the
+// daemon launches each plugin as a child process, in the style of
+// hashicorp/go-plugin, and talks to it over JSON-RPC on a unix socket.
+//
+// A plugin is a program whose main function calls Serve with the builders
+// and runners it provides. They implement the same api.Builder and
+// api.Runner interfaces as the builtin ones; the daemon registers proxies
+// to them, next to the builtin builders and runners.
+//
+// The handshake goes as follows. The daemon runs the plugin with the
+// TESTGROUND_PLUGIN environment variable set to MagicCookie. The plugin
+// listens on a unix socket, and prints a single line to stdout:
+//
+//	<protocol version>|unix|<socket path>
+//
+// The daemon then connects, and asks the plugin to describe the builders and
+// runners it serves. The plugin exits when its stdin is closed, so that it
+// doesn't outlive the daemon.
+package plugin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+const (
+	// EnvMagicCookie is the environment variable the daemon sets to
+	// MagicCookie when it launches a plugin.
+	EnvMagicCookie = "TESTGROUND_PLUGIN"
+
+	// MagicCookie tells a plugin that it has been launched by the daemon,
+	// rather than by a user.
+	MagicCookie = "7f3b0c2e5a9d4e18b6a1c8f0d2e4b6a8"
+
+	// ProtocolVersion is the version of the protocol between the daemon and
+	// its plugins. A plugin announcing another version is refused.
+	ProtocolVersion = 1
+
+	// handshakeTimeout bounds the time a plugin can take to announce its
+	// socket.
+	handshakeTimeout = 10 * time.Second
+
+	// serviceName is the name of the RPC service plugins register.
+	serviceName = "Plugin"
+)
+
+// Description describes the builders and runners a plugin serves.
+type Description struct {
+	Builders []BuilderInfo
+	Runners  []RunnerInfo
+}
+
+// BuilderInfo describes a builder served by a plugin.
+type BuilderInfo struct {
+	ID       string
+	Produces api.ArtifactSpec
+}
+
+// RunnerInfo describes a runner served by a plugin, including the optional
+// interfaces it implements.
+type RunnerInfo struct {
+	ID                 string
+	CompatibleBuilders []string
+	Capabilities       api.Capabilities
+	Terminatable       bool
+	Cleaner            bool
+}
+
+// Empty is the argument or reply of the calls that don't have one.
+type Empty struct{}
+
+// Env carries the env configuration of the daemon, as TOML, along with its
+// home directory.
+type Env struct {
+	Home   string
+	Config string
+}
+
+// BuildArgs are the arguments of a build. Configurations are carried as
+// TOML, so that they reach the plugin with the types they were decoded into.
+type BuildArgs struct {
+	Call    string
+	Builder string
+	Env     Env
+	Config  string
+	Input   *api.BuildInput
+}
+
+// BuildReply is the result of a build.
+type BuildReply struct {
+	Output *api.BuildOutput
+}
+
+// PurgeArgs are the arguments of a purge.
+type PurgeArgs struct {
+	Call     string
+	Builder  string
+	TestPlan string
+}
+
+// RunArgs are the arguments of a run; GroupConfigs are the runner
+// configurations of the groups, in order.
+type RunArgs struct {
+	Call         string
+	Runner       string
+	Env          Env
+	Config       string
+	GroupConfigs []string
+	Input        *api.RunInput
+}
+
+// RunReply is the result of a run. The result is carried as JSON, and
+// decoded into a runner.Result by the daemon.
+type RunReply struct {
+	Output *api.RunOutput
+	Result json.RawMessage
+}
+
+// CollectArgs are the arguments of the collection of the outputs of a run.
+type CollectArgs struct {
+	Call   string
+	Runner string
+	Env    Env
+	Config string
+	Input  *api.CollectionInput
+}
+
+// TerminateArgs are the arguments of the termination of all the jobs of a
+// runner.
+type TerminateArgs struct {
+	Call   string
+	Runner string
+}
+
+// CleanupArgs are the arguments of the removal of the leftovers of a run.
+type CleanupArgs struct {
+	Call   string
+	Runner string
+	RunID  string
+}
+
+// LeftoversArgs ask for the runs that left something behind.
+type LeftoversArgs struct {
+	Call   string
+	Runner string
+}
+
+// LeftoversReply are the IDs of the runs that left something behind.
+type LeftoversReply struct {
+	RunIDs []string
+}
+
+// OutputArgs ask for the next output of a call.
+type OutputArgs struct {
+	Call string
+}
+
+// OutputReply carries output of a call, in the chunk format of rpc.Chunk.
+// EOF is set once the call is done, and all its output has been sent.
+type OutputReply struct {
+	Data []byte
+	EOF  bool
+}
+
+// CancelArgs cancel the context of a call.
+type CancelArgs struct {
+	Call string
+}
@@ -0,0 +1,297 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/runner"
+
+	"github.com/rs/xid"
+)
+
+var (
+	_ api.Builder          = (*remoteBuilder)(nil)
+	_ api.ArtifactProducer = (*remoteBuilder)(nil)
+	_ api.Runner           = (*remoteRunner)(nil)
+	_ api.Capable          = (*remoteRunner)(nil)
+	_ api.Terminatable     = (*terminatableRunner)(nil)
+	_ api.Cleaner          = (*cleanerRunner)(nil)
+	_ api.Terminatable     = (*terminatableCleanerRunner)(nil)
+	_ api.Cleaner          = (*terminatableCleanerRunner)(nil)
+)
+
+// remoteConfigType is the configuration type of the builders and runners of
+// plugins, on the side of the daemon. Plugins decode configurations into
+// their own types.
+var remoteConfigType = reflect.TypeOf(map[string]interface{}{})
+
+// remoteBuilder is a proxy to a builder served by a plugin.
+type remoteBuilder struct {
+	p    *Plugin
+	info BuilderInfo
+}
+
+func (b *remoteBuilder) ID() string {
+	return b.info.ID
+}
+
+func (b *remoteBuilder) ConfigType() reflect.Type {
+	return remoteConfigType
+}
+
+func (b *remoteBuilder) Produces() api.ArtifactSpec {
+	return b.info.Produces
+}
+
+func (b *remoteBuilder) Build(ctx context.Context, input *api.BuildInput, ow *rpc.OutputWriter) (*api.BuildOutput, error) {
+	env, err := encodeEnv(input.EnvConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := encodeConfig(input.BuildConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	in := *input
+	in.EnvConfig, in.BuildConfig = config.EnvConfig{}, nil
+
+	args := &BuildArgs{Call: xid.New().String(), Builder: b.info.ID, Env: env, Config: cfg, Input: &in}
+	var reply BuildReply
+	if err := b.p.call(ctx, ow, args.Call, "Build", args, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Output, nil
+}
+
+func (b *remoteBuilder) Purge(ctx context.Context, testplan string, ow *rpc.OutputWriter) error {
+	args := &PurgeArgs{Call: xid.New().String(), Builder: b.info.ID, TestPlan: testplan}
+	return b.p.call(ctx, ow, args.Call, "Purge", args, &Empty{})
+}
+
+// remoteRunner is a proxy to a runner served by a plugin.
+type remoteRunner struct {
+	p    *Plugin
+	info RunnerInfo
+}
+
+func (r *remoteRunner) ID() string {
+	return r.info.ID
+}
+
+func (r *remoteRunner) ConfigType() reflect.Type {
+	return remoteConfigType
+}
+
+func (r *remoteRunner) CompatibleBuilders() []string {
+	return r.info.CompatibleBuilders
+}
+
+func (r *remoteRunner) Capabilities() api.Capabilities {
+	return r.info.Capabilities
+}
+
+func (r *remoteRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	env, err := encodeEnv(input.EnvConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := encodeConfig(input.RunnerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	in := *input
+	in.EnvConfig, in.RunnerConfig = config.EnvConfig{}, nil
+	in.Groups = make([]*api.RunGroup, 0, len(input.Groups))
+	gcfgs := make([]string, 0, len(input.Groups))
+	for _, g := range input.Groups {
+		gcfg, err := encodeConfig(g.RunnerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.ID, err)
+		}
+		gcfgs = append(gcfgs, gcfg)
+
+		g := *g
+		g.RunnerConfig = nil
+		in.Groups = append(in.Groups, &g)
+	}
+
+	args := &RunArgs{Call: xid.New().String(), Runner: r.info.ID, Env: env, Config: cfg, GroupConfigs: gcfgs, Input: &in}
+	var reply RunReply
+	err = r.p.call(ctx, ow, args.Call, "Run", args, &reply)
+
+	out := reply.Output
+	if out != nil && len(reply.Result) > 0 {
+		res := new(runner.Result)
+		if jerr := json.Unmarshal(reply.Result, res); jerr != nil {
+			return out, fmt.Errorf("failed to decode the result of the run: %w", jerr)
+		}
+		out.Result = res
+	}
+	return out, err
+}
+
+func (r *remoteRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
+	env, err := encodeEnv(input.EnvConfig)
+	if err != nil {
+		return err
+	}
+	cfg, err := encodeConfig(input.RunnerConfig)
+	if err != nil {
+		return err
+	}
+
+	in := *input
+	in.EnvConfig, in.RunnerConfig = config.EnvConfig{}, nil
+
+	args := &CollectArgs{Call: xid.New().String(), Runner: r.info.ID, Env: env, Config: cfg, Input: &in}
+	return r.p.call(ctx, ow, args.Call, "CollectOutputs", args, &Empty{})
+}
+
+// terminatableRunner is a proxy to a runner served by a plugin that can be
+// terminated.
+type terminatableRunner struct {
+	*remoteRunner
+}
+
+func (r *terminatableRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
+	args := &TerminateArgs{Call: xid.New().String(), Runner: r.info.ID}
+	return r.p.call(ctx, ow, args.Call, "TerminateAll", args, &Empty{})
+}
+
+// cleanerRunner is a proxy to a runner served by a plugin that can clean up
+// after runs.
+type cleanerRunner struct {
+	*remoteRunner
+}
+
+func (r *cleanerRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	return r.cleanup(ctx, runID, ow)
+}
+
+func (r *cleanerRunner) Leftovers(ctx context.Context) ([]string, error) {
+	return r.leftovers(ctx)
+}
+
+// terminatableCleanerRunner is a proxy to a runner served by a plugin that can
+// be terminated, and clean up after runs.
+type terminatableCleanerRunner struct {
+	*terminatableRunner
+}
+
+func (r *terminatableCleanerRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	return r.cleanup(ctx, runID, ow)
+}
+
+func (r *terminatableCleanerRunner) Leftovers(ctx context.Context) ([]string, error) {
+	return r.leftovers(ctx)
+}
+
+func (r *remoteRunner) cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	args := &CleanupArgs{Call: xid.New().String(), Runner: r.info.ID, RunID: runID}
+	return r.p.call(ctx, ow, args.Call, "Cleanup", args, &Empty{})
+}
+
+func (r *remoteRunner) leftovers(ctx context.Context) ([]string, error) {
+	args := &LeftoversArgs{Call: xid.New().String(), Runner: r.info.ID}
+	var reply LeftoversReply
+	err := r.p.call(ctx, rpc.Discard(), args.Call, "Leftovers", args, &reply)
+	return reply.RunIDs, err
+}
+
+// call performs a call to the plugin, relaying its output to ow until it
+// returns. The call is cancelled if ctx is done.
+func (p *Plugin) call(ctx context.Context, ow *rpc.OutputWriter, id, method string, args, reply interface{}) error {
+	relayed := make(chan error, 1)
+	go func() {
+		relayed <- p.relay(id, ow)
+	}()
+
+	c := p.client.Go(serviceName+"."+method, args, reply, nil)
+	select {
+	case <-c.Done:
+	case <-ctx.Done():
+		_ = p.client.Call(serviceName+".Cancel", &CancelArgs{Call: id}, &Empty{})
+		<-c.Done
+	}
+
+	if err := <-relayed; err != nil {
+		ow.Warnw("failed to relay plugin output", "plugin", p.name, "err", err)
+	}
+	if c.Error != nil && ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", c.Error, ctx.Err())
+	}
+	return decodeError(c.Error)
+}
+
+// decodeError restores the class of the errors of the plugin, which reach
+// the daemon as text.
+func decodeError(err error) error {
+	if err == nil || !strings.HasPrefix(err.Error(), api.InfraErrorPrefix) {
+		return err
+	}
+	return api.NewInfraError(errors.New(strings.TrimPrefix(err.Error(), api.InfraErrorPrefix)))
+}
+
+// relay pulls the output of a call, and writes it to ow, until the call is
+// done.
+func (p *Plugin) relay(id string, ow *rpc.OutputWriter) error {
+	pr, pw := io.Pipe()
+	decoded := make(chan error, 1)
+	go func() {
+		decoded <- relayChunks(pr, ow)
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	for {
+		var reply OutputReply
+		if err := p.client.Call(serviceName+".Output", &OutputArgs{Call: id}, &reply); err != nil {
+			_ = pw.CloseWithError(err)
+			<-decoded
+			return err
+		}
+		if len(reply.Data) > 0 {
+			if _, err := pw.Write(reply.Data); err != nil {
+				return err
+			}
+		}
+		if reply.EOF {
+			_ = pw.Close()
+			return <-decoded
+		}
+	}
+}
+
+// relayChunks decodes the chunks written by an rpc.OutputWriter, and writes
+// them to ow.
+func relayChunks(r io.Reader, ow *rpc.OutputWriter) error {
+	dec := json.NewDecoder(r)
+	for {
+		var chunk struct {
+			Type    rpc.ChunkType `json:"t"`
+			Payload []byte        `json:"p"`
+		}
+		switch err := dec.Decode(&chunk); {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		switch chunk.Type {
+		case rpc.ChunkTypeProgress:
+			_, _ = ow.WriteProgress(chunk.Payload)
+		case rpc.ChunkTypeBinary:
+			_, _ = ow.WriteBinary(chunk.Payload)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Serve serves the builders and runners to the daemon that launched this
+// process. It returns when the daemon closes the plugin's stdin, or if
+// serving fails. It refuses to serve if the process wasn't launched by the
+// daemon.
+func Serve(builders []api.Builder, runners []api.Runner) error {
+	if os.Getenv(EnvMagicCookie) != MagicCookie {
+		return fmt.Errorf("this program is a testground plugin; it is meant to be launched by the testground daemon, configured in the plugins of the [daemon] section of .env.toml")
+	}
+
+	s := &server{
+		builders: make(map[string]api.Builder, len(builders)),
+		runners:  make(map[string]api.Runner, len(runners)),
+		calls:    make(map[string]*call),
+	}
+	for _, b := range builders {
+		s.builders[b.ID()] = b
+	}
+	for _, r := range runners {
+		s.runners[r.ID()] = r
+	}
+
+	dir, err := ioutil.TempDir("", "testground-plugin")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugin.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	srv := netrpc.NewServer()
+	if err := srv.RegisterName(serviceName, s); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d|unix|%s\n", ProtocolVersion, path)
+
+	// the daemon holds our stdin open for as long as it needs us.
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, os.Stdin)
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// server is the RPC service of a plugin. Its exported methods are the calls
+// of the protocol.
+type server struct {
+	builders map[string]api.Builder
+	runners  map[string]api.Runner
+
+	lk    sync.Mutex
+	calls map[string]*call
+}
+
+// call is an ongoing call of the daemon, whose output the daemon pulls with
+// Output.
+type call struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	out    *outputBuffer
+	ow     *rpc.OutputWriter
+}
+
+// call returns the call with the given ID, creating it if the daemon hasn't
+// referred to it yet.
+func (s *server) call(id string) *call {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	c, ok := s.calls[id]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		out := newOutputBuffer()
+		c = &call{ctx: ctx, cancel: cancel, out: out, ow: rpc.NewFileOutputWriter(out)}
+		s.calls[id] = c
+	}
+	return c
+}
+
+// end marks the call as done, once its output has been sent.
+func (c *call) end() {
+	c.cancel()
+	c.out.Close()
+}
+
+func (s *server) Describe(_ *Empty, reply *Description) error {
+	for _, b := range s.builders {
+		info := BuilderInfo{ID: b.ID()}
+		if p, ok := b.(api.ArtifactProducer); ok {
+			info.Produces = p.Produces()
+		}
+		reply.Builders = append(reply.Builders, info)
+	}
+	for _, r := range s.runners {
+		info := RunnerInfo{ID: r.ID(), CompatibleBuilders: r.CompatibleBuilders()}
+		if c, ok := r.(api.Capable); ok {
+			info.Capabilities = c.Capabilities()
+		}
+		_, info.Terminatable = r.(api.Terminatable)
+		_, info.Cleaner = r.(api.Cleaner)
+		reply.Runners = append(reply.Runners, info)
+	}
+	return nil
+}
+
+func (s *server) Build(args *BuildArgs, reply *BuildReply) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	b, ok := s.builders[args.Builder]
+	if !ok {
+		return fmt.Errorf("unknown builder: %s", args.Builder)
+	}
+
+	in := args.Input
+	env, err := decodeEnv(args.Env)
+	if err != nil {
+		return err
+	}
+	in.EnvConfig = env
+	if in.BuildConfig, err = decodeConfig(args.Config, b.ConfigType()); err != nil {
+		return err
+	}
+
+	reply.Output, err = b.Build(c.ctx, in, c.ow)
+	return err
+}
+
+func (s *server) Purge(args *PurgeArgs, _ *Empty) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	b, ok := s.builders[args.Builder]
+	if !ok {
+		return fmt.Errorf("unknown builder: %s", args.Builder)
+	}
+	return b.Purge(c.ctx, args.TestPlan, c.ow)
+}
+
+func (s *server) Run(args *RunArgs, reply *RunReply) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	r, ok := s.runners[args.Runner]
+	if !ok {
+		return fmt.Errorf("unknown runner: %s", args.Runner)
+	}
+
+	in := args.Input
+	env, err := decodeEnv(args.Env)
+	if err != nil {
+		return err
+	}
+	in.EnvConfig = env
+	if in.RunnerConfig, err = decodeConfig(args.Config, r.ConfigType()); err != nil {
+		return err
+	}
+	if len(args.GroupConfigs) != len(in.Groups) {
+		return fmt.Errorf("got %d group configurations for %d groups", len(args.GroupConfigs), len(in.Groups))
+	}
+	for i, g := range in.Groups {
+		if g.RunnerConfig, err = decodeConfig(args.GroupConfigs[i], r.ConfigType()); err != nil {
+			return err
+		}
+	}
+
+	out, err := r.Run(c.ctx, in, c.ow)
+	if out != nil {
+		if out.Result != nil {
+			if reply.Result, err = json.Marshal(out.Result); err != nil {
+				return err
+			}
+			out.Result = nil
+		}
+		reply.Output = out
+	}
+	return err
+}
+
+func (s *server) CollectOutputs(args *CollectArgs, _ *Empty) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	r, ok := s.runners[args.Runner]
+	if !ok {
+		return fmt.Errorf("unknown runner: %s", args.Runner)
+	}
+
+	in := args.Input
+	env, err := decodeEnv(args.Env)
+	if err != nil {
+		return err
+	}
+	in.EnvConfig = env
+	if in.RunnerConfig, err = decodeConfig(args.Config, r.ConfigType()); err != nil {
+		return err
+	}
+	return r.CollectOutputs(c.ctx, in, c.ow)
+}
+
+func (s *server) TerminateAll(args *TerminateArgs, _ *Empty) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	t, ok := s.runners[args.Runner].(api.Terminatable)
+	if !ok {
+		return fmt.Errorf("runner %s can't be terminated", args.Runner)
+	}
+	return t.TerminateAll(c.ctx, c.ow)
+}
+
+func (s *server) Cleanup(args *CleanupArgs, _ *Empty) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	cl, ok := s.runners[args.Runner].(api.Cleaner)
+	if !ok {
+		return fmt.Errorf("runner %s can't clean up after runs", args.Runner)
+	}
+	return cl.Cleanup(c.ctx, args.RunID, c.ow)
+}
+
+func (s *server) Leftovers(args *LeftoversArgs, reply *LeftoversReply) error {
+	c := s.call(args.Call)
+	defer c.end()
+
+	cl, ok := s.runners[args.Runner].(api.Cleaner)
+	if !ok {
+		return fmt.Errorf("runner %s can't clean up after runs", args.Runner)
+	}
+	ids, err := cl.Leftovers(c.ctx)
+	reply.RunIDs = ids
+	return err
+}
+
+// Output blocks until there's output of the call to send, or the call is
+// done.
+func (s *server) Output(args *OutputArgs, reply *OutputReply) error {
+	c := s.call(args.Call)
+	reply.Data, reply.EOF = c.out.Next()
+	if reply.EOF {
+		s.lk.Lock()
+		delete(s.calls, args.Call)
+		s.lk.Unlock()
+	}
+	return nil
+}
+
+func (s *server) Cancel(args *CancelArgs, _ *Empty) error {
+	s.call(args.Call).cancel()
+	return nil
+}
+
+// outputBuffer buffers the output of a call until the daemon pulls it.
+type outputBuffer struct {
+	lk     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newOutputBuffer() *outputBuffer {
+	b := &outputBuffer{}
+	b.cond = sync.NewCond(&b.lk)
+	return b
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	defer b.cond.Broadcast()
+	return b.buf.Write(p)
+}
+
+// Next blocks until there's buffered output, and returns it, or until the
+// buffer is closed, and drained.
+func (b *outputBuffer) Next() ([]byte, bool) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() > 0 {
+		data := append([]byte(nil), b.buf.Bytes()...)
+		b.buf.Reset()
+		return data, false
+	}
+	return nil, true
+}
+
+func (b *outputBuffer) Close() {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// encodeEnv carries the env configuration over the wire.
+func encodeEnv(env config.EnvConfig) (Env, error) {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(env); err != nil {
+		return Env{}, fmt.Errorf("failed to encode env configuration: %w", err)
+	}
+	return Env{Home: env.Dirs().Home(), Config: buf.String()}, nil
+}
+
+func decodeEnv(e Env) (config.EnvConfig, error) {
+	var env config.EnvConfig
+	if _, err := toml.Decode(e.Config, &env); err != nil {
+		return env, fmt.Errorf("failed to decode env configuration: %w", err)
+	}
+	return env.WithHome(e.Home), nil
+}
+
+// encodeConfig carries a builder or runner configuration over the wire.
+func encodeConfig(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(v); err != nil {
+		return "", fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// decodeConfig decodes a configuration into a new value of typ, like
+// config.CoalescedConfig.CoalesceIntoType does.
+func decodeConfig(s string, typ reflect.Type) (interface{}, error) {
+	v := reflect.New(typ).Interface()
+	if _, err := toml.Decode(s, v); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	return v, nil
+}
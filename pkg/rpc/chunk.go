@@ -1,5 +1,7 @@
 package rpc
 
+import "fmt"
+
 // ChunkType defines the type of a chunk.
 type ChunkType rune
 
@@ -8,6 +10,7 @@ const (
 	ChunkTypeBinary   ChunkType = 'b'
 	ChunkTypeResult   ChunkType = 'r'
 	ChunkTypeError    ChunkType = 'e'
+	ChunkTypeInstance ChunkType = 'i'
 )
 
 // Chunk is a response chunk sent from the Testground daemon to the Testground
@@ -22,3 +25,22 @@ type Chunk struct {
 type Error struct {
 	Msg string `json:"m"`
 }
+
+// InstanceOutput is a line of output of an instance of a test plan, which the
+// runners stream live in an instance chunk, when asked to follow the
+// instances. The payload of the chunk is the JSON of the InstanceOutput.
+type InstanceOutput struct {
+	GroupID  string `json:"g"`
+	Instance int    `json:"i"`
+	// Seq is the sequence number of the line in the output of the instance,
+	// over both its streams.
+	Seq    uint64 `json:"s"`
+	Stream string `json:"o"` // stdout or stderr
+	Line   string `json:"l"`
+}
+
+// String returns the line, tagged with the instance and its sequence number,
+// e.g. miner[003] #42 | line.
+func (o *InstanceOutput) String() string {
+	return fmt.Sprintf("%s[%03d] #%d | %s", o.GroupID, o.Instance, o.Seq, o.Line)
+}
@@ -1,6 +1,7 @@
 package rpc_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"reflect"
@@ -124,3 +125,21 @@ func TestWriters(t *testing.T) {
 		testBody(t, &test, res.Body)
 	}
 }
+
+// binary writes of a writer derived WithBinary go to its writer.
+func TestWithBinary(t *testing.T) {
+	rec, ow := rpctest.NewRecordedOutputWriter(t.Name())
+
+	var buf bytes.Buffer
+	bow := ow.WithBinary(&buf)
+	_, _ = bow.BinaryWriter().Write([]byte("test"))
+	_, _ = bow.WriteBinary([]byte("ing"))
+	ow.Flush()
+
+	if buf.String() != "testing" {
+		t.Fatalf("expected binary output to be written through; got %q", buf.String())
+	}
+	if body := rec.Body.String(); strings.Contains(body, `"t":"b"`) {
+		t.Fatalf("expected no binary chunks to be sent; got %s", body)
+	}
+}
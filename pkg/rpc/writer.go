@@ -24,6 +24,9 @@ type OutputWriter struct {
 	bw *binaryWriter
 
 	out io.Writer
+
+	// binary, if set, receives the binary output instead of the client.
+	binary io.Writer
 }
 
 func NewStdoutWriter() *OutputWriter {
@@ -205,11 +208,29 @@ func (ow *OutputWriter) With(args ...interface{}) *OutputWriter {
 	}
 }
 
+// WithBinary returns a new OutputWriter that logs like this one, but writes
+// its binary output to w rather than to the client, e.g. to upload the
+// outputs of a run that a runner collects.
+func (ow *OutputWriter) WithBinary(w io.Writer) *OutputWriter {
+	nw := &OutputWriter{
+		SugaredLogger: ow.SugaredLogger,
+		out:           ow.out,
+		pw:            ow.pw,
+		binary:        w,
+	}
+	nw.bw = &binaryWriter{nw}
+	return nw
+}
+
 func (ow *OutputWriter) WriteProgress(b []byte) (n int, err error) {
 	return ow.pw.Write(b)
 }
 
 func (ow *OutputWriter) WriteBinary(b []byte) (n int, err error) {
+	if ow.binary != nil {
+		return ow.binary.Write(b)
+	}
+
 	msg := Chunk{Type: ChunkTypeBinary, Payload: b}
 	json, err := json.Marshal(msg)
 	if err != nil {
@@ -229,6 +250,30 @@ func (ow *OutputWriter) WriteBinary(b []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteInstanceOutput sends a line of output of an instance to the client, as
+// an instance chunk.
+func (ow *OutputWriter) WriteInstanceOutput(o *InstanceOutput) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	json, err := json.Marshal(Chunk{Type: ChunkTypeInstance, Payload: b})
+	if err != nil {
+		return err
+	}
+	// written like progress chunks, which writers derived With others share.
+	pw := ow.pw
+	if pw.newline {
+		json = append(json, '\n')
+	}
+
+	pw.ow.Lock()
+	defer pw.ow.Unlock()
+
+	_, err = pw.out.Write(json)
+	return err
+}
+
 func (ow *OutputWriter) WriteResult(res interface{}) {
 	msg := Chunk{Type: ChunkTypeResult, Payload: res}
 	json, err := json.Marshal(msg)
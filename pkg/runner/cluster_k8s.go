@@ -4,11 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -22,20 +21,17 @@ import (
 
 	"github.com/testground/sdk-go/ptypes"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/testground/sdk-go/runtime"
 	ss "github.com/testground/sdk-go/sync"
 	"github.com/testground/testground/pkg/api"
-	"github.com/testground/testground/pkg/aws"
 	"github.com/testground/testground/pkg/conv"
 	"github.com/testground/testground/pkg/healthcheck"
-	"github.com/testground/testground/pkg/logging"
 	"github.com/testground/testground/pkg/rpc"
 	"github.com/testground/testground/pkg/task"
 	"golang.org/x/sync/errgroup"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -51,6 +47,8 @@ var (
 	_             api.Runner        = (*ClusterK8sRunner)(nil)
 	_             api.Terminatable  = (*ClusterK8sRunner)(nil)
 	_             api.Healthchecker = (*ClusterK8sRunner)(nil)
+	_             api.Capable       = (*ClusterK8sRunner)(nil)
+	_             api.Cleaner       = (*ClusterK8sRunner)(nil)
 	mu                              = sync.Mutex{}
 	errSyncClient                   = errors.New("failed to start sync client")
 )
@@ -61,10 +59,11 @@ const (
 	// as well as to copy archives from it, since it has EFS attached to it
 	collectOutputsPodName = "collect-outputs"
 
-	// number of CPUs allocated to each Sidecar. should be same as what is set in sidecar.yaml
-	sidecarCPUs = 0.2
+	// defaultGPUResource is the resource of the GPUs of the NVIDIA device
+	// plugin.
+	defaultGPUResource = "nvidia.com/gpu"
 
-	// utilisation is how many CPUs from the remainder shall we allocate to Testground
+	// utilisation is how much of the free capacity of the nodes shall we allocate to Testground
 	// note that there are other services running on the Kubernetes cluster such as
 	// api proxy, node_exporter, dummy, etc.
 	utilisation = 0.85
@@ -111,6 +110,17 @@ type ClusterK8sRunnerConfig struct {
 	// Whether Kubernetes cluster has an autoscaler running
 	AutoscalerEnabled bool `toml:"autoscaler_enabled"`
 
+	// AutoscalerMaxNodes is how many nodes the autoscaler can grow the
+	// cluster to, if known; runs that wouldn't fit even then fail fast.
+	AutoscalerMaxNodes int `toml:"autoscaler_max_nodes"`
+
+	// PlaceholderPriorityClass, with the autoscaler enabled, makes the runner
+	// create placeholder pods of this priority class for the capacity runs
+	// lack, before pushing their images, for the autoscaler to add nodes in
+	// the meantime. It must be a class of lower priority than the pods of
+	// the runs, e.g. of value -1, for them to preempt the placeholders.
+	PlaceholderPriorityClass string `toml:"placeholder_priority_class"`
+
 	// Resources requested for each testplan pod from the Kubernetes cluster
 	TestplanPodMemory string `toml:"testplan_pod_memory"`
 	TestplanPodCPU    string `toml:"testplan_pod_cpu"`
@@ -124,6 +134,53 @@ type ClusterK8sRunnerConfig struct {
 	RunTimeoutMin int `toml:"run_timeout_min"`
 
 	Sysctls []string `toml:"sysctls"`
+
+	// Placement is how the pods of the test plan are placed on the nodes:
+	// spread evenly, for isolation, or packed on as few nodes as possible,
+	// for density. Kubernetes places them as it sees fit by default.
+	Placement string `toml:"placement"`
+
+	// PlacementTopologyKey is the node label that pods are spread over, or
+	// packed in, e.g. topology.kubernetes.io/zone; the nodes by default.
+	PlacementTopologyKey string `toml:"placement_topology_key"`
+
+	// MaxSkew is how many more pods of the run a domain may have than
+	// another with the spread placement; 1 by default.
+	MaxSkew int `toml:"max_skew"`
+
+	// StrictPlacement leaves pods pending rather than skewing the spread.
+	StrictPlacement bool `toml:"strict_placement"`
+
+	// NodeSelector restricts the pods of the test plan to nodes with these
+	// labels, besides the testground.node.role.plan label.
+	NodeSelector map[string]string `toml:"node_selector"`
+
+	// Platform restricts the pods of the test plan to nodes of the platform,
+	// e.g. linux/arm64, with the kubernetes.io/os and kubernetes.io/arch
+	// labels. The pods of images built for several platforms are placed on
+	// nodes of any of them.
+	Platform string `toml:"platform"`
+
+	// Tolerations let the pods of the test plan be placed on tainted nodes.
+	Tolerations []K8sToleration `toml:"tolerations"`
+
+	// TopologySpread spreads the pods of the run over further topologies.
+	TopologySpread []K8sTopologySpread `toml:"topology_spread"`
+
+	// GPUResource is the extended resource that the GPUs of the nodes are
+	// advertised as, by their device plugin; nvidia.com/gpu by default.
+	GPUResource string `toml:"gpu_resource"`
+
+	// RunSync gives each run its own redis and sync service, on an infra
+	// node, rather than the shared ones, and removes them once it's done, so
+	// that concurrent runs don't share a keyspace.
+	RunSync bool `toml:"run_sync"`
+
+	// IPv6 makes the data network dual-stack, giving it the IPv6 subnet of
+	// TEST_SUBNET_V6 next to the IPv4 one. The sidecar assigns the pods the
+	// addresses of the subnet that end with their IPv4 ones, as the CNI
+	// plugin only assigns IPv4 addresses.
+	IPv6 bool `toml:"ipv6"`
 }
 
 // ClusterK8sRunner is a runner that creates a Docker service to launch as
@@ -153,10 +210,25 @@ func (r *Result) StringOutcomes() string {
 type GroupOutcome struct {
 	Ok    int `json:"ok"`
 	Total int `json:"total"`
+
+	// TimedOut is set when instances of the group were still running when
+	// the group or the run timed out.
+	TimedOut bool `json:"timed_out,omitempty" mapstructure:"timed_out"`
+
+	// Failed lists the instances of the group that the runner saw fail, by
+	// index, as far as it can tell.
+	Failed []int `json:"failed,omitempty"`
 }
 
 func (g *GroupOutcome) String() string {
-	return fmt.Sprintf("%d/%d", g.Ok, g.Total)
+	s := fmt.Sprintf("%d/%d", g.Ok, g.Total)
+	if g.TimedOut {
+		s += " (timed out)"
+	}
+	if len(g.Failed) > 0 {
+		s += fmt.Sprintf(" (failed: %s)", strings.Trim(fmt.Sprint(g.Failed), "[]"))
+	}
+	return s
 }
 
 type KubernetesConfig struct {
@@ -181,7 +253,11 @@ func defaultKubernetesConfig() KubernetesConfig {
 
 func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (runoutput *api.RunOutput, runerr error) {
 	if err := c.initPool(); err != nil {
-		return nil, fmt.Errorf("could not init pool: %w", err)
+		err = fmt.Errorf("could not init pool: %w", err)
+		if errors.Is(err, errSyncClient) {
+			err = api.NewInfraError(err)
+		}
+		return nil, err
 	}
 
 	result := newResult()
@@ -200,15 +276,6 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 
 	cfg := *input.RunnerConfig.(*ClusterK8sRunnerConfig)
 
-	// if `provider` is set, we have to push to a docker registry
-	if cfg.Provider != "" {
-		err := c.pushImagesToDockerRegistry(ctx, ow, input)
-		if err != nil {
-			runerr = fmt.Errorf("failed to push images to %s; err: %w", cfg.Provider, err)
-			return
-		}
-	}
-
 	defaultCPU, err := resource.ParseQuantity(cfg.TestplanPodCPU)
 	if err != nil {
 		runerr = fmt.Errorf("couldn't parse default test plan pod CPU request; make sure you have specified `testplan_pod_cpu` in .env.toml; err: %w", err)
@@ -221,6 +288,72 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 		return
 	}
 
+	// Runs are checkpointed, for a restart of the daemon not to orphan them;
+	// a run that was interrupted is resumed from its checkpoint, if it has
+	// one, and started over otherwise.
+	ckpt := newK8sCheckpointer(filepath.Join(input.EnvConfig.Dirs().Daemon(), "checkpoints"), input.RunID)
+	var resumed *k8sCheckpoint
+	if input.Resume {
+		if resumed, err = ckpt.load(); err != nil {
+			ow.Warnw("couldn't load the checkpoint of the run; starting it over", "err", err)
+			resumed = nil
+		}
+		if resumed != nil {
+			ow.Infow("resuming run from its checkpoint", "pods", len(resumed.Pods), "checkpointed", resumed.Updated)
+		} else {
+			c.deleteRunPods(ow, input.RunID)
+		}
+	}
+	defer func() {
+		if err := ckpt.remove(); err != nil {
+			ow.Warnw("couldn't remove the checkpoint of the run", "err", err)
+		}
+	}()
+
+	// The pods of a resumed run are in the cluster already.
+	if resumed == nil {
+		cleanup, err := c.ensureCapacity(ctx, ow, input, &cfg, defaultCPU, defaultMemory)
+		if err != nil {
+			runerr = err
+			return
+		}
+		defer cleanup()
+	}
+
+	// if `provider` is set, we have to push to a docker registry
+	if cfg.Provider != "" {
+		err := pushImages(ctx, ow, c.imagesLRU, input, cfg.Provider)
+		if err != nil {
+			runerr = fmt.Errorf("failed to push images to %s; err: %w", cfg.Provider, err)
+			return
+		}
+	}
+
+	// The run may be given its own sync service; resumed runs keep theirs.
+	syncClient := c.syncClient
+	syncHost, redisHost := "testground-sync-service", "testground-infra-redis-headless"
+	var runSyncHost string
+	if cfg.RunSync {
+		if !cfg.KeepService {
+			defer c.deleteRunSync(ow, input.RunID)
+		}
+		host := runSyncName(input.RunID)
+		if resumed == nil || resumed.SyncHost == "" {
+			if host, err = c.createRunSync(ctx, ow, input.RunID); err != nil {
+				runerr = api.NewInfraError(err)
+				return
+			}
+		}
+		rc, err := dialRunSync(ctx, host, "")
+		if err != nil {
+			runerr = api.NewInfraError(err)
+			return
+		}
+		defer rc.Close()
+		syncClient = rc
+		syncHost, redisHost, runSyncHost = host, host, host
+	}
+
 	template := runtime.RunParams{
 		TestPlan:           input.TestPlan,
 		TestCase:           input.TestCase,
@@ -232,34 +365,44 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 		TestStartTime:      time.Now(),
 	}
 
-	// currently weave is not releaasing IP addresses upon container deletion - we get errors back when trying to
-	// use an already used IP address, even if the container has been removed
-	// this functionality should be refactored asap, when we understand how weave releases IPs (or why it doesn't release
-	// them when a container is removed/ and as soon as we decide how to manage `networks in-use` so that there are no
-	// collisions in concurrent testplan runs
-	subnet, err := nextK8sSubnet()
-	if err != nil {
-		runerr = err
-		return
-	}
-
-	template.TestSubnet = &ptypes.IPNet{IPNet: *subnet}
+	if resumed != nil {
+		template = resumed.Template
+	} else {
+		// currently weave is not releaasing IP addresses upon container deletion - we get errors back when trying to
+		// use an already used IP address, even if the container has been removed
+		// this functionality should be refactored asap, when we understand how weave releases IPs (or why it doesn't release
+		// them when a container is removed/ and as soon as we decide how to manage `networks in-use` so that there are no
+		// collisions in concurrent testplan runs
+		subnet, err := nextK8sSubnet()
+		if err != nil {
+			runerr = err
+			return
+		}
 
-	enoughResources, err := c.checkClusterResources(ow, input.Groups, defaultMemory, defaultCPU)
-	if err != nil {
-		runerr = fmt.Errorf("couldn't check cluster resources: %v", err)
-		return
+		template.TestSubnet = &ptypes.IPNet{IPNet: *subnet}
 	}
 
-	if !enoughResources {
-		if cfg.AutoscalerEnabled {
-			ow.Warnw("too many test instances requested, will have to wait for cluster autoscaler to kick in.")
-		} else {
-			runerr = errors.New("too many test instances requested, resize cluster if you need more capacity.")
+	var subnetV6 *net.IPNet
+	if cfg.IPv6 {
+		if subnetV6, _, err = dataNetworkV6(&template.TestSubnet.IPNet); err != nil {
+			runerr = err
 			return
 		}
 	}
 
+	cp := &k8sCheckpoint{RunID: input.RunID, Template: template, SyncHost: runSyncHost}
+	if resumed != nil {
+		cp.Pods = resumed.Pods
+	}
+	if err := ckpt.start(cp); err != nil {
+		ow.Warnw("couldn't checkpoint the run; it won't be resumed if the daemon restarts", "err", err)
+	}
+	ckptctx, stopCheckpoints := context.WithCancel(ctx)
+	go ckpt.run(ckptctx, func(err error) {
+		ow.Warnw("couldn't checkpoint the run", "err", err)
+	})
+	defer stopCheckpoints()
+
 	jobName := fmt.Sprintf("tg-%s", input.TestPlan)
 
 	ow.Infow("deploying testground testplan run on k8s", "job-name", jobName)
@@ -270,7 +413,7 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 		ctxContainers, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		outcomesDoneCh, err := c.collectOutcomes(ctxContainers, result, &template)
+		outcomesDoneCh, err := c.collectOutcomes(ctxContainers, syncClient, result, &template)
 		if err != nil {
 			ow.Errorw("could not start collecting outcomes", "err", err)
 		}
@@ -287,7 +430,11 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 
 	sem := make(chan struct{}, 30) // limit the number of concurrent k8s api calls
 
-	for _, g := range input.Groups {
+	// groups may have to wait for other groups before starting; create the
+	// pods of the groups they wait for first.
+	starter := newGroupStarter(syncClient, &template, input.Groups, input.Stagger)
+
+	for _, g := range startOrder(input.Groups) {
 		runenv := template
 		runenv.TestGroupID = g.ID
 		runenv.TestGroupInstanceCount = g.Instances
@@ -299,13 +446,32 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 		}
 
 		env := conv.ToEnvVar(runenv.ToEnvVars())
-		env = append(env, v1.EnvVar{Name: "REDIS_HOST", Value: "testground-infra-redis-headless"})
-		env = append(env, v1.EnvVar{Name: "SYNC_SERVICE_HOST", Value: "testground-sync-service"})
+		env = append(env, conv.ToEnvVar(g.NetworkProfileEnv())...)
+		env = append(env, conv.ToEnvVar(g.ChaosEnv())...)
+		env = append(env, conv.ToEnvVar(subnetV6Env(subnetV6))...)
+		env = append(env, v1.EnvVar{Name: "REDIS_HOST", Value: redisHost})
+		env = append(env, v1.EnvVar{Name: "SYNC_SERVICE_HOST", Value: syncHost})
 		env = append(env, v1.EnvVar{Name: "INFLUXDB_URL", Value: "http://influxdb:8086"})
 
-		// Set the log level if provided in cfg.
-		if cfg.LogLevel != "" {
-			env = append(env, v1.EnvVar{Name: "LOG_LEVEL", Value: cfg.LogLevel})
+		// Set the log level if provided in cfg; it can be overridden per group.
+		logLevel := cfg.LogLevel
+		if gcfg, ok := g.RunnerConfig.(*ClusterK8sRunnerConfig); ok && gcfg.LogLevel != "" {
+			logLevel = gcfg.LogLevel
+		}
+
+		// the group config inherits the run config, so groups can be placed
+		// differently.
+		pcfg := &cfg
+		if gcfg, ok := g.RunnerConfig.(*ClusterK8sRunnerConfig); ok {
+			pcfg = gcfg
+		}
+		placement, perr := newPodPlacement(pcfg, input.RunID)
+		if perr != nil {
+			runerr = fmt.Errorf("invalid placement of group %s: %w", g.ID, perr)
+			return
+		}
+		if logLevel != "" {
+			env = append(env, v1.EnvVar{Name: "LOG_LEVEL", Value: logLevel})
 		}
 
 		env = append(env, v1.EnvVar{Name: "POD_IP", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "status.podIP"}}})
@@ -316,24 +482,10 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 			env = append(env, v1.EnvVar{Name: name, Value: value})
 		}
 
-		podCPU := defaultCPU
-		if g.Resources.CPU != "" {
-			var err error
-			podCPU, err = resource.ParseQuantity(g.Resources.CPU)
-			if err != nil {
-				runerr = err
-				return
-			}
-		}
-
-		podMemory := defaultMemory
-		if g.Resources.Memory != "" {
-			var err error
-			podMemory, err = resource.ParseQuantity(g.Resources.Memory)
-			if err != nil {
-				runerr = err
-				return
-			}
+		podCPU, podMemory, err := groupPodResources(g, defaultCPU, defaultMemory, input.Requirements)
+		if err != nil {
+			runerr = err
+			return
 		}
 
 		for i := 0; i < g.Instances; i++ {
@@ -350,7 +502,8 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 				client := c.pool.Acquire()
 				defer c.pool.Release(client)
 				ow.Debugw("deleting pod", "pod", podName)
-				err = client.CoreV1().Pods(c.config.Namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+				// the run context is done if the run timed out; clean up regardless.
+				err = client.CoreV1().Pods(c.config.Namespace).Delete(context.Background(), podName, metav1.DeleteOptions{})
 				if err != nil {
 					ow.Errorw("couldn't remove pod", "pod", podName, "err", err)
 				}
@@ -367,14 +520,36 @@ func (c *ClusterK8sRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 					Value: fmt.Sprintf("/outputs/%s/%s/%d", input.RunID, g.ID, i),
 				})
 
-				return c.createTestplanPod(ctx, podName, input, runenv, currentEnv, g, i, podMemory, podCPU)
+				if ckpt.has(podName) {
+					// created before the run was interrupted.
+					starter.Started(g.ID)
+					return nil
+				}
+				if err := starter.Wait(ctx, ow, g.ID); err != nil {
+					return err
+				}
+				if err := starter.Admit(ctx, ow); err != nil {
+					return err
+				}
+
+				err := c.createTestplanPod(ctx, podName, input, runenv, currentEnv, g, i, podMemory, podCPU, placement)
+				if resumed != nil && k8serrors.IsAlreadyExists(err) {
+					// created since the run was last checkpointed.
+					err = nil
+				}
+				if err == nil {
+					ckpt.created(podName)
+					starter.Started(g.ID)
+				}
+				return err
 			})
 		}
 	}
 
-	// we want to fetch logs even in an event of error
+	// we want to fetch logs even in an event of error, unless they were
+	// followed already.
 	defer func() {
-		if input.TotalInstances <= 200 {
+		if input.TotalInstances <= maxFollowedPods && !input.FollowInstances {
 			var gg errgroup.Group
 
 			for _, g := range input.Groups {
@@ -437,17 +612,25 @@ func (c *ClusterK8sRunner) Healthcheck(ctx context.Context, engine api.Engine, o
 	client := c.pool.Acquire()
 	defer c.pool.Release(client)
 
+	hh := &healthcheck.Helper{}
+
+	hh.Require("kubernetes api",
+		healthcheck.CheckK8sAPI(client),
+		healthcheck.RequiresManualFixing(),
+	)
+
 	// How many plan worker nodes are there?
 	res, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
 		LabelSelector: "testground.node.role.plan=true",
 	})
 	if err != nil {
-		return nil, err
+		hh.Enlist("plan nodes", func() (bool, string, error) {
+			return false, "failed to list plan nodes", err
+		}, nil)
+		return hh.RunChecks(ctx, fix)
 	}
 	planNodes := res.Items
 
-	hh := &healthcheck.Helper{}
-
 	hh.Enlist("efs pod",
 		healthcheck.CheckK8sPods(ctx, client, "app=efs-provisioner", c.config.Namespace, 1),
 		healthcheck.NotImplemented(),
@@ -487,7 +670,19 @@ func (*ClusterK8sRunner) ConfigType() reflect.Type {
 }
 
 func (*ClusterK8sRunner) CompatibleBuilders() []string {
-	return []string{"docker:go", "docker:generic"}
+	return []string{"docker:go", "docker:node", "docker:generic", "docker:rust"}
+}
+
+// Capabilities reports that the runner runs docker images, that the sidecar
+// shapes the traffic of the pods, and that pods run in privileged mode, and
+// with GPUs, when required.
+func (*ClusterK8sRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		TrafficShaping: true,
+		Privileged:     true,
+		GPUs:           true,
+		Artifacts:      []api.ArtifactKind{api.ArtifactDockerImage},
+	}
 }
 
 func (c *ClusterK8sRunner) Enabled() bool {
@@ -514,7 +709,7 @@ func (c *ClusterK8sRunner) initPool() error {
 		return err
 	}
 
-	c.syncClient, err = ss.NewGenericClient(context.Background(), logging.S())
+	c.syncClient, err = newDaemonSyncClient(context.Background())
 	if err != nil {
 		return fmt.Errorf("%w: %s", errSyncClient, err)
 	}
@@ -691,6 +886,48 @@ func (c *ClusterK8sRunner) getPodLogs(ow *rpc.OutputWriter, podName string) (str
 	return buf.String(), nil
 }
 
+// podInstance returns the group of a pod of the test plan, and the index of
+// its instance in the group, which ends its name.
+func podInstance(pod *v1.Pod) (group string, idx int, ok bool) {
+	idx, err := strconv.Atoi(pod.Name[strings.LastIndex(pod.Name, "-")+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return pod.Labels["testground.groupid"], idx, true
+}
+
+// maxFollowedPods is the largest run whose pods' logs are streamed live when
+// following the instances, or else fetched after the run.
+const maxFollowedPods = 200
+
+// followPodLogs streams the logs of a pod of the test plan live, as the
+// output of its instance, until the pod is done. Kubernetes merges the
+// streams of the pod, so they're all stdout.
+func (c *ClusterK8sRunner) followPodLogs(ctx context.Context, ow *rpc.OutputWriter, pod *v1.Pod) {
+	group, instance, ok := podInstance(pod)
+	if !ok {
+		ow.Warnw("unexpected name of testplan pod; not following it", "pod", pod.Name)
+		return
+	}
+
+	// the stream outlives the client, which other calls need.
+	client := c.pool.Acquire()
+	req := client.CoreV1().Pods(c.config.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{Follow: true})
+	stream, err := req.Stream(ctx)
+	c.pool.Release(client)
+	if err != nil {
+		ow.Warnw("failed to follow pod logs", "pod", pod.Name, "err", err)
+		return
+	}
+	defer stream.Close()
+
+	w := newInstanceFollower(ow, group, instance).writer(streamStdout)
+	defer w.Close()
+	if _, err := io.Copy(w, stream); err != nil && ctx.Err() == nil {
+		ow.Warnw("failed to follow pod logs", "pod", pod.Name, "err", err)
+	}
+}
+
 func (c *ClusterK8sRunner) watchRunPods(ctx context.Context, ow *rpc.OutputWriter, input *api.RunInput, result *Result, rp *runtime.RunParams) error {
 	client := c.pool.Acquire()
 	defer c.pool.Release(client)
@@ -733,6 +970,15 @@ func (c *ClusterK8sRunner) watchRunPods(ctx context.Context, ow *rpc.OutputWrite
 	podsByState := make(map[string]*v1.PodList)
 	var countersMu sync.Mutex
 
+	// the logs of the pods are followed once they start, if the run follows
+	// the instances and isn't too large to.
+	follow := input.FollowInstances
+	if follow && input.TotalInstances > maxFollowedPods {
+		ow.Warnw("too many instances to follow; their logs are available after the run", "instances", input.TotalInstances, "max", maxFollowedPods)
+		follow = false
+	}
+	followed := make(map[string]struct{})
+
 	start := time.Now()
 	allRunningStage := false
 	for {
@@ -783,8 +1029,30 @@ func (c *ClusterK8sRunner) watchRunPods(ctx context.Context, ow *rpc.OutputWrite
 		}
 		wg.Wait()
 
+		if follow {
+			for _, state := range []string{"Running", "Succeeded", "Failed"} {
+				list := podsByState[state]
+				if list == nil {
+					continue
+				}
+				for i := range list.Items {
+					pod := &list.Items[i]
+					if _, ok := followed[pod.Name]; !ok {
+						followed[pod.Name] = struct{}{}
+						go c.followPodLogs(ctx, ow, pod)
+					}
+				}
+			}
+		}
+
 		ow.Debugw("testplan pods state", "running_for", time.Since(start).Truncate(time.Second), "succeeded", counters["Succeeded"], "running", counters["Running"], "pending", counters["Pending"], "failed", counters["Failed"], "unknown", counters["Unknown"])
 
+		// pods that can't pull their image, or that are evicted, fail the
+		// run because of the cluster rather than the test plan.
+		if err := podsInfraFailure(podsByState["Pending"], podsByState["Failed"]); err != nil {
+			return err
+		}
+
 		if counters["Failed"] > 0 {
 			for _, p := range podsByState["Failed"].Items {
 				if !strings.Contains(p.ObjectMeta.Name, input.RunID) {
@@ -811,12 +1079,39 @@ func (c *ClusterK8sRunner) watchRunPods(ctx context.Context, ow *rpc.OutputWrite
 
 		if (counters["Succeeded"] + counters["Failed"]) == input.TotalInstances {
 			ow.Warnw("all testplan instances in `Succeeded` or `Failed` state", "took", time.Since(start).Truncate(time.Second))
+			for i := range podsByState["Failed"].Items {
+				if group, idx, ok := podInstance(&podsByState["Failed"].Items[i]); ok {
+					result.InstanceFailed(group, idx)
+				}
+			}
 			return nil
 		}
 	}
 }
 
-func (c *ClusterK8sRunner) createTestplanPod(ctx context.Context, podName string, input *api.RunInput, runenv runtime.RunParams, env []v1.EnvVar, g *api.RunGroup, i int, podResourceMemory resource.Quantity, podResourceCPU resource.Quantity) error {
+// podsInfraFailure returns an infrastructure error if any of the pending pods
+// can't pull its image, or any of the failed pods was evicted.
+func podsInfraFailure(pending, failed *v1.PodList) error {
+	if pending != nil {
+		for _, p := range pending.Items {
+			for _, st := range p.Status.ContainerStatuses {
+				if w := st.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff" || w.Reason == "InvalidImageName") {
+					return api.NewInfraError(fmt.Errorf("pod %s can't pull image %s: %s", p.Name, st.Image, w.Message))
+				}
+			}
+		}
+	}
+	if failed != nil {
+		for _, p := range failed.Items {
+			if p.Status.Reason == "Evicted" {
+				return api.NewInfraError(fmt.Errorf("pod %s was evicted: %s", p.Name, p.Status.Message))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ClusterK8sRunner) createTestplanPod(ctx context.Context, podName string, input *api.RunInput, runenv runtime.RunParams, env []v1.EnvVar, g *api.RunGroup, i int, podResourceMemory resource.Quantity, podResourceCPU resource.Quantity, placement *podPlacement) error {
 	client := c.pool.Acquire()
 	defer c.pool.Release(client)
 
@@ -829,6 +1124,38 @@ func (c *ClusterK8sRunner) createTestplanPod(ctx context.Context, podName string
 		sysctls = append(sysctls, v1.Sysctl{Name: sysctl[0], Value: sysctl[1]})
 	}
 
+	resources := v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceMemory: podResourceMemory,
+			v1.ResourceCPU:    podResourceCPU,
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: podResourceMemory,
+		},
+	}
+
+	// Extended resources, like GPUs, are only limited; they're requested as
+	// much.
+	if n := groupGPUs(g, input.Requirements); n > 0 {
+		resources.Limits[v1.ResourceName(withDefault(cfg.GPUResource, defaultGPUResource))] = *resource.NewQuantity(int64(n), resource.DecimalSI)
+	}
+
+	// Request and limit the local ephemeral storage of the pod, if requested.
+	if g.Resources.Disk != "" {
+		disk, err := resource.ParseQuantity(g.Resources.Disk)
+		if err != nil {
+			return err
+		}
+		resources.Requests[v1.ResourceEphemeralStorage] = disk
+		resources.Limits[v1.ResourceEphemeralStorage] = disk
+	}
+
+	// Kubernetes stops the pods of a group with a timeout once it elapses.
+	var deadline *int64
+	if g.Timeout > 0 {
+		deadline = int64Ptr(int64(math.Ceil(g.Timeout.Seconds())))
+	}
+
 	var ports []v1.ContainerPort
 	cnt := 0
 	for _, p := range cfg.ExposedPorts {
@@ -870,7 +1197,8 @@ func (c *ClusterK8sRunner) createTestplanPod(ctx context.Context, podName string
 			SecurityContext: &v1.PodSecurityContext{
 				Sysctls: sysctls,
 			},
-			RestartPolicy: v1.RestartPolicyNever,
+			ActiveDeadlineSeconds: deadline,
+			RestartPolicy:         v1.RestartPolicyNever,
 			InitContainers: []v1.Container{
 				{
 					Name:            "wait-for-sidecar",
@@ -923,20 +1251,15 @@ func (c *ClusterK8sRunner) createTestplanPod(ctx context.Context, podName string
 							MountPropagation: &mountPropagationMode,
 						},
 					},
-					Resources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{
-							v1.ResourceMemory: podResourceMemory,
-							v1.ResourceCPU:    podResourceCPU,
-						},
-						Limits: v1.ResourceList{
-							v1.ResourceMemory: podResourceMemory,
-						},
+					Resources: resources,
+					SecurityContext: &v1.SecurityContext{
+						Privileged: &input.Requirements.Privileged,
 					},
 				},
 			},
-			NodeSelector: map[string]string{"testground.node.role.plan": "true"},
 		},
 	}
+	placement.apply(&podRequest.Spec)
 
 	_, err := client.CoreV1().Pods(c.config.Namespace).Create(ctx, podRequest, metav1.CreateOptions{})
 	return err
@@ -953,58 +1276,27 @@ func (fw FakeWriterAt) WriteAt(p []byte, offset int64) (n int, err error) {
 	return fw.w.Write(p)
 }
 
-// checkClusterResources returns whether we can fit the input groups in the current cluster
-func (c *ClusterK8sRunner) checkClusterResources(ow *rpc.OutputWriter, groups []*api.RunGroup, fallbackMemory resource.Quantity, fallbackCPU resource.Quantity) (bool, error) {
-	neededCPUs := 0.0
-
-	defaultPodCPU, err := strconv.ParseFloat(fallbackCPU.AsDec().String(), 64)
-	if err != nil {
-		return false, err
-	}
-
-	client := c.pool.Acquire()
-	defer c.pool.Release(client)
-
-	res, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "testground.node.role.plan=true",
-	})
-	if err != nil {
-		return false, err
-	}
-
-	nodes := len(res.Items)
-
-	// all worker nodes are the same, so just take allocatable CPU from the first
-	item := res.Items[0].Status.Allocatable["cpu"]
-	nodeCPUs, _ := item.AsInt64()
-
-	totalCPUs := nodes * int(nodeCPUs)
-	availableCPUs := float64(totalCPUs) - float64(nodes)*sidecarCPUs
-
-	for _, g := range groups {
-		var podCPU float64
-		if g.Resources.CPU != "" {
-			cpu, err := resource.ParseQuantity(g.Resources.CPU)
-			if err != nil {
-				return false, err
-			}
-			podCPU, err = strconv.ParseFloat(cpu.AsDec().String(), 64)
-			if err != nil {
-				return false, err
-			}
-		} else {
-			podCPU = defaultPodCPU
+// groupPodResources returns the CPU and the memory that the pods of the group
+// request: those of the group, or else the defaults, with the memory raised
+// to the minimum the test case requires.
+func groupPodResources(g *api.RunGroup, defaultCPU, defaultMemory resource.Quantity, reqs api.Requirements) (cpu, memory resource.Quantity, err error) {
+	cpu, memory = defaultCPU, defaultMemory
+	if g.Resources.CPU != "" {
+		if cpu, err = resource.ParseQuantity(g.Resources.CPU); err != nil {
+			return cpu, memory, err
 		}
-
-		neededCPUs += podCPU * float64(g.Instances)
 	}
 
-	if (availableCPUs * utilisation) > neededCPUs {
-		return true, nil
+	if g.Resources.Memory != "" {
+		memory, err = resource.ParseQuantity(g.Resources.Memory)
+	} else if m := reqs.MinMemory; m != "" {
+		// the default must not fall short of what the test case requires.
+		var min resource.Quantity
+		if min, err = resource.ParseQuantity(m); err == nil && min.Cmp(memory) > 0 {
+			memory = min
+		}
 	}
-
-	ow.Warnw("not enough resources on cluster", "available_cpus", availableCPUs, "needed_cpus", neededCPUs, "utilisation", utilisation)
-	return false, nil
+	return cpu, memory, err
 }
 
 // TerminateAll terminates all pods for with the label testground.purpose: plan
@@ -1025,70 +1317,91 @@ func (c *ClusterK8sRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWrite
 		ow.Errorw("could not terminate all pods", "err", err)
 		return err
 	}
+
+	// the sync services of runs that were given their own.
+	syncOpts := metav1.ListOptions{
+		LabelSelector: "testground.purpose=sync",
+	}
+	svcs, err := client.CoreV1().Services("default").List(ctx, syncOpts)
+	if err != nil {
+		ow.Errorw("could not list the sync services of runs", "err", err)
+		return err
+	}
+	for _, svc := range svcs.Items {
+		if err := client.CoreV1().Services("default").Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil {
+			ow.Errorw("could not terminate the sync service of a run", "service", svc.Name, "err", err)
+			return err
+		}
+	}
+	err = client.CoreV1().Pods("default").DeleteCollection(ctx, metav1.DeleteOptions{}, syncOpts)
+	if err != nil {
+		ow.Errorw("could not terminate all sync service pods", "err", err)
+		return err
+	}
 	return nil
 }
 
-func (c *ClusterK8sRunner) pushImagesToDockerRegistry(ctx context.Context, ow *rpc.OutputWriter, in *api.RunInput) error {
-	cfg := *in.RunnerConfig.(*ClusterK8sRunnerConfig)
+// runLabels are the labels that tie pods and services to their run: the pods
+// of the instances, the sync service of the run if it was given its own, and
+// the placeholder pods reserving room for the run.
+var runLabels = []string{"testground.run_id", "testground.sync_for", "testground.placeholder_for"}
 
-	// Create a docker client.
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return fmt.Errorf("failed to create docker client: %w", err)
+// Cleanup removes the pods and the services of the run.
+func (c *ClusterK8sRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	if err := c.initPool(); err != nil {
+		return fmt.Errorf("could not init pool: %w", err)
 	}
 
-	start := time.Now()
-	ow.Info("pushing images")
-	defer func() { ow.Infow("pushing of images finished", "took", time.Since(start).Truncate(time.Second)) }()
-
-	var ipo types.ImagePushOptions // Auth params for Docker client
-	var uri string                 // URI of Docker registry to push images to
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
 
-	switch cfg.Provider {
-	case "aws":
-		// Setup docker registry authentication
-		auth, err := aws.ECR.GetAuthToken(in.EnvConfig.AWS)
+	for _, label := range runLabels {
+		opts := metav1.ListOptions{LabelSelector: label + "=" + runID}
+		svcs, err := client.CoreV1().Services(c.config.Namespace).List(ctx, opts)
 		if err != nil {
-			return err
+			return fmt.Errorf("could not list the services of the run: %w", err)
 		}
-		ow.Infow("acquired ECR authentication token")
-
-		ipo = types.ImagePushOptions{
-			RegistryAuth: aws.ECR.EncodeAuthToken(auth),
+		for _, svc := range svcs.Items {
+			ow.Infow("removing service", "service", svc.Name)
+			if err := client.CoreV1().Services(c.config.Namespace).Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("could not remove service %s: %w", svc.Name, err)
+			}
+		}
+		if err := client.CoreV1().Pods(c.config.Namespace).DeleteCollection(ctx, metav1.DeleteOptions{}, opts); err != nil {
+			return fmt.Errorf("could not remove the pods of the run: %w", err)
 		}
+	}
+	return nil
+}
 
-		// Setup docker registry repository
-		repo := fmt.Sprintf("testground-%s-%s", in.EnvConfig.AWS.Region, in.TestPlan)
-		uri, err = aws.ECR.EnsureRepository(in.EnvConfig.AWS, repo)
+// Leftovers returns the IDs of the runs that left pods or services behind.
+func (c *ClusterK8sRunner) Leftovers(ctx context.Context) ([]string, error) {
+	if err := c.initPool(); err != nil {
+		return nil, fmt.Errorf("could not init pool: %w", err)
+	}
+
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	var runs []string
+	for _, label := range runLabels {
+		opts := metav1.ListOptions{LabelSelector: label}
+		pods, err := client.CoreV1().Pods(c.config.Namespace).List(ctx, opts)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not list pods: %w", err)
 		}
-		ow.Infow("ensured ECR repository exists", "name", repo)
-
-	case "dockerhub":
-		// Setup docker registry authentication
-		auth := types.AuthConfig{
-			Username: in.EnvConfig.DockerHub.Username,
-			Password: in.EnvConfig.DockerHub.AccessToken,
+		for _, pod := range pods.Items {
+			runs = append(runs, pod.Labels[label])
 		}
-		authBytes, err := json.Marshal(auth)
+		svcs, err := client.CoreV1().Services(c.config.Namespace).List(ctx, opts)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not list services: %w", err)
 		}
-		authBase64 := base64.URLEncoding.EncodeToString(authBytes)
-
-		ipo = types.ImagePushOptions{
-			RegistryAuth: authBase64,
+		for _, svc := range svcs.Items {
+			runs = append(runs, svc.Labels[label])
 		}
-
-		// Setup docker registry repository
-		uri = in.EnvConfig.DockerHub.Repo + "/testground"
-
-	default:
-		return fmt.Errorf("unknown provider: %s", cfg.Provider)
 	}
-
-	return c.pushToDockerRegistry(ctx, ow, cli, in, ipo, uri)
+	return uniqueRunIDs(runs), nil
 }
 
 func (c *ClusterK8sRunner) createCollectOutputsPod(ctx context.Context, input *api.CollectionInput) error {
@@ -1205,8 +1518,8 @@ func (c *ClusterK8sRunner) GetClusterCapacity() (int64, int64, error) {
 	return allocatableCPUs, allocatableMemory, nil
 }
 
-func (c *ClusterK8sRunner) collectOutcomes(ctx context.Context, result *Result, tpl *runtime.RunParams) (chan bool, error) {
-	eventsCh, err := c.syncClient.SubscribeEvents(ctx, tpl)
+func (c *ClusterK8sRunner) collectOutcomes(ctx context.Context, client *ss.DefaultClient, result *Result, tpl *runtime.RunParams) (chan bool, error) {
+	eventsCh, err := client.SubscribeEvents(ctx, tpl)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,368 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// placeholderImage is the image of the placeholder pods, which do nothing
+// but hold capacity.
+const placeholderImage = "k8s.gcr.io/pause:3.2"
+
+// k8sDemand is the capacity the pods of a run request, in millicores and
+// bytes.
+type k8sDemand struct {
+	Pods   int
+	CPU    int64
+	Memory int64
+
+	// MaxPodCPU and MaxPodMemory are the requests of the largest pod.
+	MaxPodCPU    int64
+	MaxPodMemory int64
+
+	// GPUs are requested by the pods that need them; MaxPodGPUs by the one
+	// that needs the most.
+	GPUs       int64
+	MaxPodGPUs int64
+}
+
+// k8sCapacity is the capacity of the schedulable nodes of the cluster that
+// test plan pods are placed on, in millicores and bytes.
+type k8sCapacity struct {
+	Nodes int
+
+	// FreeCPU and FreeMemory are what the nodes can allocate, net of the
+	// requests of the pods running on them already, e.g. the sidecars.
+	FreeCPU    int64
+	FreeMemory int64
+
+	// NodeCPU and NodeMemory are what the largest node can allocate.
+	NodeCPU    int64
+	NodeMemory int64
+
+	// FreeGPUs and NodeGPUs are likewise for GPUs, which are only ever
+	// allocated whole.
+	FreeGPUs int64
+	NodeGPUs int64
+}
+
+// capacityReport compares the capacity a run requests to that of the
+// cluster. Only a share of the free capacity, utilisation, is counted on,
+// for the services running on the nodes without requests.
+type capacityReport struct {
+	demand   k8sDemand
+	capacity k8sCapacity
+}
+
+// fits returns whether the run fits in the cluster as it is.
+func (r *capacityReport) fits() bool {
+	return r.demand.CPU <= usable(r.capacity.FreeCPU) && r.demand.Memory <= usable(r.capacity.FreeMemory) && r.demand.GPUs <= r.capacity.FreeGPUs
+}
+
+// podsFit returns whether the largest pod of the run fits on a node, as far
+// as the nodes of the cluster tell.
+func (r *capacityReport) podsFit() bool {
+	if r.capacity.Nodes == 0 {
+		return true
+	}
+	return r.demand.MaxPodCPU <= r.capacity.NodeCPU && r.demand.MaxPodMemory <= r.capacity.NodeMemory && r.demand.MaxPodGPUs <= r.capacity.NodeGPUs
+}
+
+// missingNodes returns how many nodes like the largest one the cluster lacks
+// for the run, or -1 if it has no nodes to tell.
+func (r *capacityReport) missingNodes() int {
+	if r.capacity.NodeCPU == 0 || r.capacity.NodeMemory == 0 {
+		return -1
+	}
+	cpu := shortfall(r.demand.CPU, r.capacity.FreeCPU, r.capacity.NodeCPU)
+	mem := shortfall(r.demand.Memory, r.capacity.FreeMemory, r.capacity.NodeMemory)
+	if cpu > mem {
+		return cpu
+	}
+	if r.demand.GPUs > r.capacity.FreeGPUs && r.capacity.NodeGPUs > 0 {
+		if gpus := int(math.Ceil(float64(r.demand.GPUs-r.capacity.FreeGPUs) / float64(r.capacity.NodeGPUs))); gpus > mem {
+			return gpus
+		}
+	}
+	return mem
+}
+
+// missingPods returns how many pods, of the size of the largest pod of the
+// run, the cluster lacks the capacity for.
+func (r *capacityReport) missingPods() int {
+	cpu := shortfall(r.demand.CPU, r.capacity.FreeCPU, r.demand.MaxPodCPU)
+	mem := shortfall(r.demand.Memory, r.capacity.FreeMemory, r.demand.MaxPodMemory)
+	n := cpu
+	if mem > n {
+		n = mem
+	}
+	if n > r.demand.Pods {
+		n = r.demand.Pods
+	}
+	return n
+}
+
+func (r *capacityReport) String() string {
+	s := fmt.Sprintf("requested: %s CPUs and %s of memory for %d pods; free: %s CPUs and %s of memory on %d nodes",
+		cpus(r.demand.CPU), bytesOf(r.demand.Memory), r.demand.Pods,
+		cpus(usable(r.capacity.FreeCPU)), bytesOf(usable(r.capacity.FreeMemory)), r.capacity.Nodes)
+	if n := r.missingNodes(); n > 0 {
+		s += fmt.Sprintf("; missing: about %d more nodes", n)
+	}
+	if r.demand.GPUs > 0 {
+		s += fmt.Sprintf("; gpus requested: %d, free: %d", r.demand.GPUs, r.capacity.FreeGPUs)
+	}
+	if !r.podsFit() {
+		s += fmt.Sprintf("; pods of %s CPUs, %s of memory and %d gpus are larger than any node", cpus(r.demand.MaxPodCPU), bytesOf(r.demand.MaxPodMemory), r.demand.MaxPodGPUs)
+	}
+	return s
+}
+
+// usable is the share of free capacity that's counted on.
+func usable(free int64) int64 {
+	return int64(float64(free) * utilisation)
+}
+
+// shortfall returns how many units of capacity of the given size are
+// missing to satisfy demand out of free.
+func shortfall(demand, free, unit int64) int {
+	missing := demand - usable(free)
+	if missing <= 0 || unit <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(missing) / float64(usable(unit))))
+}
+
+func cpus(millis int64) string {
+	return fmt.Sprintf("%.2f", float64(millis)/1000)
+}
+
+func bytesOf(b int64) string {
+	return resource.NewQuantity(b, resource.BinarySI).String()
+}
+
+// computeDemand returns the capacity the pods of the groups request, with the
+// resources of their pods as groupPodResources returns them.
+func computeDemand(groups []*api.RunGroup, defaultCPU, defaultMemory resource.Quantity, reqs api.Requirements) (k8sDemand, error) {
+	var d k8sDemand
+	for _, g := range groups {
+		cpu, mem, err := groupPodResources(g, defaultCPU, defaultMemory, reqs)
+		if err != nil {
+			return d, fmt.Errorf("invalid resources of group %s: %w", g.ID, err)
+		}
+		d.Pods += g.Instances
+		d.CPU += cpu.MilliValue() * int64(g.Instances)
+		d.Memory += mem.Value() * int64(g.Instances)
+		if v := cpu.MilliValue(); v > d.MaxPodCPU {
+			d.MaxPodCPU = v
+		}
+		if v := mem.Value(); v > d.MaxPodMemory {
+			d.MaxPodMemory = v
+		}
+		gpus := int64(groupGPUs(g, reqs))
+		d.GPUs += gpus * int64(g.Instances)
+		if gpus > d.MaxPodGPUs {
+			d.MaxPodGPUs = gpus
+		}
+	}
+	return d, nil
+}
+
+// computeCapacity returns the capacity of the schedulable nodes, net of the
+// requests of the pods, which are the ones that aren't done; GPUs are the
+// given extended resource.
+func computeCapacity(nodes []v1.Node, pods []v1.Pod, gpu v1.ResourceName) k8sCapacity {
+	var c k8sCapacity
+	free := make(map[string][3]int64, len(nodes))
+	for _, n := range nodes {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		a := n.Status.Allocatable
+		cpu, mem, gpus := a.Cpu().MilliValue(), a.Memory().Value(), quantity(a, gpu)
+		free[n.Name] = [3]int64{cpu, mem, gpus}
+		if cpu > c.NodeCPU {
+			c.NodeCPU = cpu
+		}
+		if mem > c.NodeMemory {
+			c.NodeMemory = mem
+		}
+		if gpus > c.NodeGPUs {
+			c.NodeGPUs = gpus
+		}
+	}
+	for _, p := range pods {
+		f, ok := free[p.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		for _, ct := range p.Spec.Containers {
+			f[0] -= ct.Resources.Requests.Cpu().MilliValue()
+			f[1] -= ct.Resources.Requests.Memory().Value()
+			// extended resources are requested as much as limited.
+			f[2] -= quantity(ct.Resources.Limits, gpu)
+		}
+		free[p.Spec.NodeName] = f
+	}
+
+	c.Nodes = len(free)
+	for _, f := range free {
+		if f[0] > 0 {
+			c.FreeCPU += f[0]
+		}
+		if f[1] > 0 {
+			c.FreeMemory += f[1]
+		}
+		if f[2] > 0 {
+			c.FreeGPUs += f[2]
+		}
+	}
+	return c
+}
+
+// quantity returns the quantity of the resource in the list, or 0.
+func quantity(l v1.ResourceList, name v1.ResourceName) int64 {
+	q, ok := l[name]
+	if !ok {
+		return 0
+	}
+	return q.Value()
+}
+
+// checkCapacity compares the capacity the run requests to that of the nodes
+// of the cluster that test plan pods are placed on, with GPUs advertised as
+// the gpu resource.
+func (c *ClusterK8sRunner) checkCapacity(ctx context.Context, input *api.RunInput, defaultCPU, defaultMemory resource.Quantity, gpu string) (*capacityReport, error) {
+	demand, err := computeDemand(input.Groups, defaultCPU, defaultMemory, input.Requirements)
+	if err != nil {
+		return nil, err
+	}
+
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: "testground.node.role.plan=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the pods of all namespaces take capacity of the nodes.
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &capacityReport{demand: demand, capacity: computeCapacity(nodes.Items, pods.Items, v1.ResourceName(gpu))}, nil
+}
+
+// ensureCapacity checks that the run fits in the cluster before it's
+// prepared, so that runs that can't fail fast, and that the autoscaler can
+// add the nodes that runs lack in the meantime, with placeholder pods if
+// configured. The returned cleanup deletes the placeholders that are left.
+func (c *ClusterK8sRunner) ensureCapacity(ctx context.Context, ow *rpc.OutputWriter, input *api.RunInput, cfg *ClusterK8sRunnerConfig, defaultCPU, defaultMemory resource.Quantity) (cleanup func(), err error) {
+	cleanup = func() {}
+
+	report, err := c.checkCapacity(ctx, input, defaultCPU, defaultMemory, withDefault(cfg.GPUResource, defaultGPUResource))
+	if err != nil {
+		return cleanup, fmt.Errorf("couldn't check cluster resources: %w", err)
+	}
+
+	switch {
+	case !report.podsFit():
+		return cleanup, fmt.Errorf("pods of the run are larger than the nodes of the cluster; %s", report)
+	case report.fits():
+		ow.Infow("cluster capacity: ok", "capacity", report.String())
+	case !cfg.AutoscalerEnabled:
+		return cleanup, fmt.Errorf("not enough capacity in the cluster for the run; resize it if you need more capacity; %s", report)
+	case cfg.AutoscalerMaxNodes > 0 && report.missingNodes() >= 0 && report.capacity.Nodes+report.missingNodes() > cfg.AutoscalerMaxNodes:
+		return cleanup, fmt.Errorf("not enough capacity in the cluster for the run, even with the autoscaler adding nodes up to %d; %s", cfg.AutoscalerMaxNodes, report)
+	default:
+		ow.Warnw("not enough capacity in the cluster for the run; will have to wait for the cluster autoscaler to kick in", "capacity", report.String())
+		if cfg.PlaceholderPriorityClass != "" {
+			placement, err := newPodPlacement(cfg, input.RunID)
+			if err != nil {
+				return cleanup, err
+			}
+			cleanup = func() { c.deletePlaceholderPods(ow, input.RunID) }
+			if err := c.createPlaceholderPods(ctx, ow, input.RunID, report, cfg.PlaceholderPriorityClass, placement); err != nil {
+				return cleanup, err
+			}
+		}
+	}
+	return cleanup, nil
+}
+
+// createPlaceholderPods creates as many pods as the cluster lacks the
+// capacity for, the size of the largest pod of the run, of the given
+// priority class, which make the cluster autoscaler add the nodes the run
+// lacks while the run is being prepared. The pods of the run,
+// of higher priority, preempt them once created.
+func (c *ClusterK8sRunner) createPlaceholderPods(ctx context.Context, ow *rpc.OutputWriter, runID string, report *capacityReport, priorityClass string, placement *podPlacement) error {
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	n := report.missingPods()
+	ow.Infow("creating placeholder pods for the autoscaler", "pods", n, "priority_class", priorityClass)
+
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    *resource.NewMilliQuantity(report.demand.MaxPodCPU, resource.DecimalSI),
+		v1.ResourceMemory: *resource.NewQuantity(report.demand.MaxPodMemory, resource.BinarySI),
+	}
+	for i := 0; i < n; i++ {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("tg-placeholder-%s-%d", runID, i),
+				// not labeled with the run ID, not to count in the
+				// placement of the pods of the run.
+				Labels: map[string]string{
+					"testground.placeholder_for": runID,
+					"testground.purpose":         "placeholder",
+				},
+			},
+			Spec: v1.PodSpec{
+				NodeSelector:                  placement.nodeSelector,
+				Tolerations:                   placement.tolerations,
+				PriorityClassName:             priorityClass,
+				RestartPolicy:                 v1.RestartPolicyNever,
+				TerminationGracePeriodSeconds: int64Ptr(0),
+				Containers: []v1.Container{{
+					Name:            "placeholder",
+					Image:           placeholderImage,
+					ImagePullPolicy: v1.PullIfNotPresent,
+					Resources:       v1.ResourceRequirements{Requests: resources},
+				}},
+			},
+		}
+
+		if _, err := client.CoreV1().Pods(c.config.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create placeholder pod: %w", err)
+		}
+	}
+	return nil
+}
+
+// deletePlaceholderPods deletes the placeholder pods of the run that the
+// pods of the run haven't preempted.
+func (c *ClusterK8sRunner) deletePlaceholderPods(ow *rpc.OutputWriter, runID string) {
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	err := client.CoreV1().Pods(c.config.Namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: "testground.purpose=placeholder,testground.placeholder_for=" + runID,
+	})
+	if err != nil {
+		ow.Warnw("couldn't remove placeholder pods", "err", err)
+	}
+}
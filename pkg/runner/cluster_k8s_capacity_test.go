@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/testground/testground/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapacityReport(t *testing.T) {
+	node := func(name, cpu, mem string, unschedulable bool) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+			Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(mem),
+			}},
+		}
+	}
+	pod := func(node, cpu, mem string) v1.Pod {
+		return v1.Pod{Spec: v1.PodSpec{NodeName: node, Containers: []v1.Container{{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(mem),
+			}},
+		}}}}
+	}
+
+	// the requests of running pods, like the sidecars, take capacity;
+	// cordoned nodes have none.
+	capacity := computeCapacity(
+		[]v1.Node{node("a", "4", "8Gi", false), node("b", "4", "8Gi", false), node("c", "8", "16Gi", true)},
+		[]v1.Pod{pod("a", "200m", "1Gi"), pod("b", "200m", "1Gi"), pod("c", "1", "1Gi"), pod("", "1", "1Gi")},
+		defaultGPUResource,
+	)
+	require.Equal(t, k8sCapacity{Nodes: 2, FreeCPU: 7600, FreeMemory: 14 << 30, NodeCPU: 4000, NodeMemory: 8 << 30}, capacity)
+
+	groups := []*api.RunGroup{
+		{ID: "small", Instances: 10},
+		{ID: "large", Instances: 2, Resources: api.Resources{CPU: "1", Memory: "2Gi"}},
+	}
+	demand, err := computeDemand(groups, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{})
+	require.NoError(t, err)
+	require.Equal(t, k8sDemand{Pods: 12, CPU: 7000, Memory: 6656 << 20, MaxPodCPU: 1000, MaxPodMemory: 2 << 30}, demand)
+
+	// 7 CPUs don't fit in 85% of 7.6.
+	r := &capacityReport{demand: demand, capacity: capacity}
+	require.False(t, r.fits())
+	require.True(t, r.podsFit())
+	require.Equal(t, 1, r.missingNodes())
+	require.Equal(t, 1, r.missingPods())
+	require.Contains(t, r.String(), "missing: about 1 more nodes")
+
+	groups[0].Instances = 4
+	demand, err = computeDemand(groups, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{})
+	require.NoError(t, err)
+	r = &capacityReport{demand: demand, capacity: capacity}
+	require.True(t, r.fits())
+	require.Equal(t, 0, r.missingNodes())
+	require.Equal(t, 0, r.missingPods())
+
+	// pods larger than any node never fit.
+	groups[1].Resources.Memory = "12Gi"
+	demand, err = computeDemand(groups, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{})
+	require.NoError(t, err)
+	r = &capacityReport{demand: demand, capacity: capacity}
+	require.False(t, r.podsFit())
+
+	// the memory of groups defaults to the minimum the test case requires.
+	demand, err = computeDemand([]*api.RunGroup{{ID: "a", Instances: 2}}, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{MinMemory: "1Gi"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2<<30, demand.Memory)
+
+	_, err = computeDemand([]*api.RunGroup{{ID: "a", Instances: 1, Resources: api.Resources{CPU: "lots"}}}, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{})
+	require.Error(t, err)
+
+	// runs on clusters without nodes can't tell how many they lack.
+	r = &capacityReport{demand: demand}
+	require.False(t, r.fits())
+	require.True(t, r.podsFit())
+	require.Equal(t, -1, r.missingNodes())
+
+	// pods requesting gpus need nodes that have them free.
+	gpuNode := node("g", "8", "16Gi", false)
+	gpuNode.Status.Allocatable[defaultGPUResource] = resource.MustParse("2")
+	gpuPod := pod("g", "1", "1Gi")
+	gpuPod.Spec.Containers[0].Resources.Limits = v1.ResourceList{defaultGPUResource: resource.MustParse("1")}
+	capacity = computeCapacity([]v1.Node{node("a", "4", "8Gi", false), gpuNode}, []v1.Pod{gpuPod}, defaultGPUResource)
+	require.EqualValues(t, 1, capacity.FreeGPUs)
+	require.EqualValues(t, 2, capacity.NodeGPUs)
+
+	demand, err = computeDemand([]*api.RunGroup{{ID: "a", Instances: 2, Resources: api.Resources{GPUs: 1}}}, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, demand.GPUs)
+	r = &capacityReport{demand: demand, capacity: capacity}
+	require.False(t, r.fits())
+	require.True(t, r.podsFit())
+	require.Equal(t, 1, r.missingNodes())
+
+	// test cases that require gpus get one per instance by default.
+	demand, err = computeDemand([]*api.RunGroup{{ID: "a", Instances: 1}}, resource.MustParse("500m"), resource.MustParse("256Mi"), api.Requirements{GPUs: true})
+	require.NoError(t, err)
+	r = &capacityReport{demand: demand, capacity: capacity}
+	require.True(t, r.fits())
+
+	r.demand.MaxPodGPUs = 4
+	require.False(t, r.podsFit())
+}
@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/testground/sdk-go/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// k8sCheckpointInterval is how often the checkpoint of a run is written, while
+// its pods are created.
+const k8sCheckpointInterval = 2 * time.Second
+
+// k8sCheckpoint is the state of a run of the k8s runner that is persisted
+// while the run is in progress, so that a restart of the daemon resumes the
+// run, rather than orphaning its pods. The instances that completed are told
+// by the phase of their pods, and by the events that the sync service
+// replays.
+type k8sCheckpoint struct {
+	RunID    string            `json:"run_id"`
+	Template runtime.RunParams `json:"template"`
+
+	// SyncHost is the sync service the run was given, if any.
+	SyncHost string `json:"sync_host,omitempty"`
+
+	// Pods are the pods of the run that were created.
+	Pods map[string]bool `json:"pods"`
+
+	Updated time.Time `json:"updated"`
+}
+
+// k8sCheckpointer writes the checkpoint of a run to a file of the daemon
+// directory.
+type k8sCheckpointer struct {
+	path string
+
+	lk    sync.Mutex
+	cp    *k8sCheckpoint
+	dirty bool
+}
+
+func newK8sCheckpointer(dir, runID string) *k8sCheckpointer {
+	return &k8sCheckpointer{path: filepath.Join(dir, runID+".json")}
+}
+
+// load reads the checkpoint of an interrupted run; nil if it has none.
+func (c *k8sCheckpointer) load() (*k8sCheckpoint, error) {
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp k8sCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	if cp.Pods == nil {
+		cp.Pods = make(map[string]bool)
+	}
+	return &cp, nil
+}
+
+// start writes the first checkpoint of the run, which the checkpointer then
+// keeps up to date.
+func (c *k8sCheckpointer) start(cp *k8sCheckpoint) error {
+	if cp.Pods == nil {
+		cp.Pods = make(map[string]bool)
+	}
+
+	c.lk.Lock()
+	c.cp, c.dirty = cp, true
+	c.lk.Unlock()
+	return c.flush()
+}
+
+// created records that the pod was created.
+func (c *k8sCheckpointer) created(pod string) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.cp.Pods[pod] = true
+	c.dirty = true
+}
+
+// has returns whether the pod was created, before the run was interrupted.
+func (c *k8sCheckpointer) has(pod string) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.cp != nil && c.cp.Pods[pod]
+}
+
+// flush writes the checkpoint, if it changed since it was last written.
+func (c *k8sCheckpointer) flush() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.cp == nil || !c.dirty {
+		return nil
+	}
+	c.cp.Updated = time.Now().UTC()
+	b, err := json.Marshal(c.cp)
+	if err != nil {
+		return err
+	}
+
+	// written to a temporary file first, not to be left half written.
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// run flushes the checkpoint periodically, until ctx is done.
+func (c *k8sCheckpointer) run(ctx context.Context, onErr func(error)) {
+	t := time.NewTicker(k8sCheckpointInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.flush(); err != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// remove removes the checkpoint, once the run is done.
+func (c *k8sCheckpointer) remove() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.cp = nil
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteRunPods deletes the pods that an interrupted run, which had no
+// checkpoint, may have left, for the run to start over.
+func (c *ClusterK8sRunner) deleteRunPods(ow *rpc.OutputWriter, runID string) {
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	err := client.CoreV1().Pods(c.config.Namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: "testground.purpose=plan,testground.run_id=" + runID,
+	})
+	if err != nil {
+		ow.Warnw("couldn't remove the pods of the interrupted run", "err", err)
+	}
+}
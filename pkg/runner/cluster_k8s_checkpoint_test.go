@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestK8sCheckpointer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := newK8sCheckpointer(dir, "run")
+
+	// runs that weren't checkpointed have no checkpoint.
+	cp, err := c.load()
+	require.NoError(t, err)
+	require.Nil(t, cp)
+	require.False(t, c.has("tg-plan-run-a-0"))
+
+	_, subnet, _ := net.ParseCIDR("16.0.0.0/16")
+	start := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, c.start(&k8sCheckpoint{
+		RunID: "run",
+		Template: runtime.RunParams{
+			TestPlan:      "plan",
+			TestRun:       "run",
+			TestSubnet:    &ptypes.IPNet{IPNet: *subnet},
+			TestStartTime: start,
+		},
+		SyncHost: "tg-sync-run",
+	}))
+	c.created("tg-plan-run-a-0")
+	require.True(t, c.has("tg-plan-run-a-0"))
+	require.NoError(t, c.flush())
+
+	// the daemon restarts.
+	cp, err = newK8sCheckpointer(dir, "run").load()
+	require.NoError(t, err)
+	require.NotNil(t, cp)
+	require.Equal(t, "tg-sync-run", cp.SyncHost)
+	require.Equal(t, map[string]bool{"tg-plan-run-a-0": true}, cp.Pods)
+	require.Equal(t, "16.0.0.0/16", cp.Template.TestSubnet.String())
+	require.True(t, start.Equal(cp.Template.TestStartTime))
+
+	require.NoError(t, c.remove())
+	cp, err = c.load()
+	require.NoError(t, err)
+	require.Nil(t, cp)
+	require.NoError(t, c.remove())
+}
@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Placement strategies of the pods of the test plan.
+const (
+	// PlacementDefault lets Kubernetes place the pods as it sees fit.
+	PlacementDefault = ""
+	// PlacementSpread spreads the pods of a run evenly over the nodes, for
+	// isolation.
+	PlacementSpread = "spread"
+	// PlacementPack packs the pods of a run on as few nodes as possible, for
+	// density.
+	PlacementPack = "pack"
+)
+
+// defaultPlacementTopologyKey is the node label that pods are spread over,
+// or packed in, unless configured otherwise: the nodes themselves.
+const defaultPlacementTopologyKey = "kubernetes.io/hostname"
+
+// K8sToleration lets the pods of the test plan be scheduled on nodes with a
+// matching taint. See the Kubernetes documentation of tolerations.
+type K8sToleration struct {
+	Key      string `toml:"key"`
+	Operator string `toml:"operator"` // Equal (default) or Exists
+	Value    string `toml:"value"`
+	Effect   string `toml:"effect"` // NoSchedule, PreferNoSchedule or NoExecute; all of them if empty
+}
+
+// K8sTopologySpread spreads the pods of a run over the domains of a node
+// label, e.g. topology.kubernetes.io/zone, in addition to the placement
+// strategy. See the Kubernetes documentation of topology spread constraints.
+type K8sTopologySpread struct {
+	TopologyKey string `toml:"topology_key"`
+	MaxSkew     int    `toml:"max_skew"` // 1 by default
+	Strict      bool   `toml:"strict"`   // leave pods pending rather than skew the spread
+}
+
+// podPlacement is where the pods of a group may be, and should be, placed.
+type podPlacement struct {
+	nodeSelector map[string]string
+	affinity     *v1.Affinity
+	spread       []v1.TopologySpreadConstraint
+	tolerations  []v1.Toleration
+}
+
+// newPodPlacement returns the placement of the pods of the run with the
+// configuration.
+func newPodPlacement(cfg *ClusterK8sRunnerConfig, runID string) (*podPlacement, error) {
+	p := &podPlacement{
+		nodeSelector: map[string]string{"testground.node.role.plan": "true"},
+	}
+	for k, v := range cfg.NodeSelector {
+		p.nodeSelector[k] = v
+	}
+
+	platform, err := api.RunPlatform(cfg.Platform)
+	if err != nil {
+		return nil, err
+	}
+	if platform != "" {
+		parts := strings.Split(platform, "/")
+		p.nodeSelector["kubernetes.io/os"] = parts[0]
+		p.nodeSelector["kubernetes.io/arch"] = parts[1]
+	}
+
+	topologyKey := cfg.PlacementTopologyKey
+	if topologyKey == "" {
+		topologyKey = defaultPlacementTopologyKey
+	}
+	run := &metav1.LabelSelector{MatchLabels: map[string]string{"testground.run_id": runID}}
+
+	switch cfg.Placement {
+	case PlacementDefault:
+	case PlacementSpread:
+		p.spread = append(p.spread, topologySpread(topologyKey, cfg.MaxSkew, cfg.StrictPlacement, run))
+	case PlacementPack:
+		// pods are attracted by the pods of the run already placed, which
+		// must be a preference: requiring it would place every pod in the
+		// same domain, no matter how large the run is.
+		p.affinity = &v1.Affinity{
+			PodAffinity: &v1.PodAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+					Weight: 100,
+					PodAffinityTerm: v1.PodAffinityTerm{
+						LabelSelector: run,
+						TopologyKey:   topologyKey,
+					},
+				}},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unknown placement %q; expected %q or %q", cfg.Placement, PlacementSpread, PlacementPack)
+	}
+
+	for _, s := range cfg.TopologySpread {
+		if s.TopologyKey == "" {
+			return nil, errors.New("topology spread constraint without a topology key")
+		}
+		p.spread = append(p.spread, topologySpread(s.TopologyKey, s.MaxSkew, s.Strict, run))
+	}
+
+	for _, t := range cfg.Tolerations {
+		tol := v1.Toleration{
+			Key:      t.Key,
+			Operator: v1.TolerationOperator(t.Operator),
+			Value:    t.Value,
+			Effect:   v1.TaintEffect(t.Effect),
+		}
+		switch tol.Operator {
+		case "":
+			tol.Operator = v1.TolerationOpEqual
+		case v1.TolerationOpEqual, v1.TolerationOpExists:
+		default:
+			return nil, fmt.Errorf("invalid operator of toleration %q: %q", t.Key, t.Operator)
+		}
+		switch tol.Effect {
+		case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid effect of toleration %q: %q", t.Key, t.Effect)
+		}
+		p.tolerations = append(p.tolerations, tol)
+	}
+
+	return p, nil
+}
+
+func topologySpread(key string, maxSkew int, strict bool, selector *metav1.LabelSelector) v1.TopologySpreadConstraint {
+	if maxSkew <= 0 {
+		maxSkew = 1
+	}
+	when := v1.ScheduleAnyway
+	if strict {
+		when = v1.DoNotSchedule
+	}
+	return v1.TopologySpreadConstraint{
+		MaxSkew:           int32(maxSkew),
+		TopologyKey:       key,
+		WhenUnsatisfiable: when,
+		LabelSelector:     selector,
+	}
+}
+
+// apply sets the placement of the pod.
+func (p *podPlacement) apply(spec *v1.PodSpec) {
+	spec.NodeSelector = p.nodeSelector
+	spec.Affinity = p.affinity
+	spec.TopologySpreadConstraints = p.spread
+	spec.Tolerations = p.tolerations
+}
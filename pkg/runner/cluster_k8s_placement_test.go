@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPodPlacement(t *testing.T) {
+	// Kubernetes places the pods by default.
+	p, err := newPodPlacement(&ClusterK8sRunnerConfig{}, "run")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"testground.node.role.plan": "true"}, p.nodeSelector)
+	require.Nil(t, p.affinity)
+	require.Empty(t, p.spread)
+
+	p, err = newPodPlacement(&ClusterK8sRunnerConfig{
+		Placement:       PlacementSpread,
+		MaxSkew:         2,
+		StrictPlacement: true,
+		NodeSelector:    map[string]string{"pool": "large"},
+		Tolerations:     []K8sToleration{{Key: "dedicated", Value: "testground", Effect: "NoSchedule"}},
+		TopologySpread:  []K8sTopologySpread{{TopologyKey: "topology.kubernetes.io/zone"}},
+	}, "run")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"testground.node.role.plan": "true", "pool": "large"}, p.nodeSelector)
+	require.Len(t, p.spread, 2)
+	require.Equal(t, defaultPlacementTopologyKey, p.spread[0].TopologyKey)
+	require.EqualValues(t, 2, p.spread[0].MaxSkew)
+	require.Equal(t, v1.DoNotSchedule, p.spread[0].WhenUnsatisfiable)
+	require.Equal(t, "run", p.spread[0].LabelSelector.MatchLabels["testground.run_id"])
+	require.EqualValues(t, 1, p.spread[1].MaxSkew)
+	require.Equal(t, v1.ScheduleAnyway, p.spread[1].WhenUnsatisfiable)
+	require.Equal(t, []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "testground", Effect: v1.TaintEffectNoSchedule}}, p.tolerations)
+
+	p, err = newPodPlacement(&ClusterK8sRunnerConfig{Placement: PlacementPack, PlacementTopologyKey: "topology.kubernetes.io/zone"}, "run")
+	require.NoError(t, err)
+	require.NotNil(t, p.affinity)
+	terms := p.affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	require.Len(t, terms, 1)
+	require.Equal(t, "topology.kubernetes.io/zone", terms[0].PodAffinityTerm.TopologyKey)
+
+	var spec v1.PodSpec
+	p.apply(&spec)
+	require.Equal(t, p.affinity, spec.Affinity)
+	require.Equal(t, p.nodeSelector, spec.NodeSelector)
+
+	// pods of images of one platform are placed on nodes of it.
+	p, err = newPodPlacement(&ClusterK8sRunnerConfig{Platform: "linux/arm64"}, "run")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"testground.node.role.plan": "true", "kubernetes.io/os": "linux", "kubernetes.io/arch": "arm64"}, p.nodeSelector)
+	p, err = newPodPlacement(&ClusterK8sRunnerConfig{Platform: "linux/amd64,linux/arm64"}, "run")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"testground.node.role.plan": "true"}, p.nodeSelector)
+
+	_, err = newPodPlacement(&ClusterK8sRunnerConfig{Platform: "arm64"}, "run")
+	require.Error(t, err)
+	_, err = newPodPlacement(&ClusterK8sRunnerConfig{Placement: "random"}, "run")
+	require.Error(t, err)
+	_, err = newPodPlacement(&ClusterK8sRunnerConfig{Tolerations: []K8sToleration{{Key: "a", Operator: "In"}}}, "run")
+	require.Error(t, err)
+	_, err = newPodPlacement(&ClusterK8sRunnerConfig{TopologySpread: []K8sTopologySpread{{MaxSkew: 1}}}, "run")
+	require.Error(t, err)
+}
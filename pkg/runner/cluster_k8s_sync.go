@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/testground/testground/pkg/rpc"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// runSyncName is the name of the pod, and of the service, of the sync
+// service of a run that was given its own.
+func runSyncName(runID string) string {
+	return "tg-sync-" + runID
+}
+
+// createRunSync creates the sync service of a run: a pod of redis and the
+// sync service on an infra node, behind a service of the same name, which
+// both the daemon and the instances reach it by. It's created before the pods
+// of the run, and waited for.
+func (c *ClusterK8sRunner) createRunSync(ctx context.Context, ow *rpc.OutputWriter, runID string) (host string, err error) {
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	name := runSyncName(runID)
+	ow.Infow("creating the sync service of the run", "name", name)
+
+	// not labeled with the run ID, not to count as a pod of the run.
+	labels := map[string]string{
+		"testground.sync_for": runID,
+		"testground.purpose":  "sync",
+	}
+	port, _ := strconv.Atoi(runSyncPort)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: v1.PodSpec{
+			NodeSelector:                  map[string]string{"testground.node.role.infra": "true"},
+			RestartPolicy:                 v1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: int64Ptr(0),
+			Containers: []v1.Container{{
+				Name:            "redis",
+				Image:           runSyncRedisImage,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Args:            runSyncRedisArgs,
+				Ports:           []v1.ContainerPort{{Name: "redis", ContainerPort: 6379}},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceMemory: resource.MustParse("64Mi"),
+						v1.ResourceCPU:    resource.MustParse("100m"),
+					},
+				},
+			}, {
+				Name:            "sync-service",
+				Image:           runSyncImage,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Command:         []string{"/service"},
+				Env:             []v1.EnvVar{{Name: "REDIS_HOST", Value: "localhost"}},
+				Ports:           []v1.ContainerPort{{Name: "sync", ContainerPort: int32(port)}},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceMemory: resource.MustParse("26Mi"),
+						v1.ResourceCPU:    resource.MustParse("20m"),
+					},
+				},
+			}},
+		},
+	}
+	if _, err := client.CoreV1().Pods(c.config.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create the sync service pod of the run: %w", err)
+	}
+
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: v1.ServiceSpec{
+			Selector: labels,
+			Ports: []v1.ServicePort{
+				{Name: "sync", Port: int32(port), TargetPort: intstr.FromInt(port)},
+				{Name: "redis", Port: 6379, TargetPort: intstr.FromInt(6379)},
+			},
+		},
+	}
+	if _, err := client.CoreV1().Services(c.config.Namespace).Create(ctx, svc, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create the sync service of the run: %w", err)
+	}
+
+	if err := c.waitForPod(ctx, name, string(v1.PodRunning)); err != nil {
+		return "", fmt.Errorf("sync service pod of the run didn't start: %w", err)
+	}
+	return name, nil
+}
+
+// deleteRunSync deletes the sync service of a run, and its pod.
+func (c *ClusterK8sRunner) deleteRunSync(ow *rpc.OutputWriter, runID string) {
+	client := c.pool.Acquire()
+	defer c.pool.Release(client)
+
+	name := runSyncName(runID)
+	if err := client.CoreV1().Services(c.config.Namespace).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+		ow.Warnw("couldn't remove the sync service of the run", "service", name, "err", err)
+	}
+	if err := client.CoreV1().Pods(c.config.Namespace).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+		ow.Warnw("couldn't remove the sync service pod of the run", "pod", name, "err", err)
+	}
+}
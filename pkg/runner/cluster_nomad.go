@@ -0,0 +1,739 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/healthcheck"
+	"github.com/testground/testground/pkg/nomad"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+	"golang.org/x/sync/errgroup"
+
+	lru "github.com/hashicorp/golang-lru"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// nomadSubnetIdx picks the data network of the next run.
+var nomadSubnetIdx uint64 = 0
+
+var (
+	_ api.Runner        = (*ClusterNomadRunner)(nil)
+	_ api.Terminatable  = (*ClusterNomadRunner)(nil)
+	_ api.Healthchecker = (*ClusterNomadRunner)(nil)
+	_ api.Capable       = (*ClusterNomadRunner)(nil)
+	_ api.Cleaner       = (*ClusterNomadRunner)(nil)
+)
+
+const (
+	// nomadJobPrefix prefixes the IDs of the jobs of the test plans, one per
+	// group of a run.
+	nomadJobPrefix = "tg-"
+
+	// nomadOutputsVolume is the name of the outputs volume in the task groups.
+	nomadOutputsVolume = "outputs"
+
+	// nomadMHzPerCore converts the CPUs of the resources of groups into the
+	// MHz that Nomad reserves.
+	nomadMHzPerCore = 1000
+)
+
+// ClusterNomadRunnerConfig is the configuration object of this runner. Boolean
+// values are expressed in a way that zero value (false) is the default setting.
+type ClusterNomadRunnerConfig struct {
+	// LogLevel sets the log level in the test containers (default: not set).
+	LogLevel string `toml:"log_level"`
+
+	// Address is the address of the Nomad API; NOMAD_ADDR, or
+	// http://127.0.0.1:4646, by default.
+	Address string `toml:"address"`
+
+	// Token is the ACL token of the Nomad API; NOMAD_TOKEN by default.
+	Token string `toml:"token"`
+
+	// Region and Namespace of the jobs; those of the agent by default.
+	Region    string `toml:"region"`
+	Namespace string `toml:"namespace"`
+
+	// Datacenters the jobs may be placed in (default: dc1).
+	Datacenters []string `toml:"datacenters"`
+
+	// NodeClass, if set, restricts the instances to the nodes of the class.
+	NodeClass string `toml:"node_class"`
+
+	// Provider is the registry the images are pushed to, so that the nodes
+	// can pull them: aws, dockerhub or generic (see docker.RegistryAuth).
+	// Images are used as they are if not set.
+	Provider string `toml:"provider"`
+
+	// Resources reserved for each instance, unless the group sets them.
+	TestplanCPU    int `toml:"testplan_cpu"`    // MHz (default: 100)
+	TestplanMemory int `toml:"testplan_memory"` // MB (default: 100)
+
+	// OutputsVolume is the Nomad host volume the instances write their
+	// outputs to, mounted at /outputs; OutputsPath is where the daemon reads
+	// the same volume from, e.g. a network file system. Outputs are lost,
+	// and can't be collected, if either isn't set.
+	OutputsVolume string `toml:"outputs_volume"`
+	OutputsPath   string `toml:"outputs_path"`
+
+	// Hosts of the services the instances reach, as resolved on the nodes.
+	SyncServiceHost string `toml:"sync_service_host"` // default: testground-sync-service.service.consul
+	RedisHost       string `toml:"redis_host"`        // default: testground-redis.service.consul
+	InfluxDBURL     string `toml:"influxdb_url"`      // default: http://influxdb.service.consul:8086
+
+	// Sidecar makes the instances wait for the testground sidecar, run as the
+	// testground-sidecar system job with the docker reactor on every node,
+	// which initialises their network.
+	Sidecar bool `toml:"sidecar"`
+
+	ExposedPorts ExposedPorts `toml:"exposed_ports"`
+
+	// KeepJobs keeps the jobs of the run once it's over, instead of purging
+	// them.
+	KeepJobs bool `toml:"keep_jobs"`
+}
+
+// ClusterNomadRunner is a runner that registers a batch job in a Nomad
+// cluster for every group of a run, with as many allocations as the group
+// has instances.
+type ClusterNomadRunner struct {
+	lk         sync.Mutex
+	syncClient *ss.DefaultClient
+	imagesLRU  *lru.Cache
+}
+
+func (c *ClusterNomadRunner) client(cfg *ClusterNomadRunnerConfig) *nomad.Client {
+	cl := nomad.NewClient(cfg.Address, cfg.Token)
+	cl.Region, cl.Namespace = cfg.Region, cfg.Namespace
+	return cl
+}
+
+func (c *ClusterNomadRunner) init() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.imagesLRU == nil {
+		c.imagesLRU, _ = lru.New(256)
+	}
+	if c.syncClient != nil {
+		return nil
+	}
+
+	var err error
+	c.syncClient, err = newDaemonSyncClient(context.Background())
+	if err != nil {
+		return fmt.Errorf("%w: %s", errSyncClient, err)
+	}
+	return nil
+}
+
+func (c *ClusterNomadRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (runoutput *api.RunOutput, runerr error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	result := newResult()
+	runoutput = &api.RunOutput{
+		RunID:  input.RunID,
+		Result: result,
+	}
+
+	defer func() {
+		if ctx.Err() == context.Canceled {
+			result.Outcome = task.OutcomeCanceled
+		}
+	}()
+
+	var (
+		cfg = *input.RunnerConfig.(*ClusterNomadRunnerConfig)
+		cl  = c.client(&cfg)
+	)
+	ow = ow.With("runner", "cluster:nomad", "run_id", input.RunID)
+
+	if cfg.Provider != "" {
+		if err := pushImages(ctx, ow, c.imagesLRU, input, cfg.Provider); err != nil {
+			return nil, fmt.Errorf("failed to push images to %s; err: %w", cfg.Provider, err)
+		}
+	}
+	if cfg.OutputsVolume == "" {
+		ow.Warn("no outputs_volume configured; the outputs of the instances will be lost")
+	}
+
+	template := runtime.RunParams{
+		TestPlan:           input.TestPlan,
+		TestCase:           input.TestCase,
+		TestRun:            input.RunID,
+		TestInstanceCount:  input.TotalInstances,
+		TestDisableMetrics: input.DisableMetrics,
+		TestSidecar:        cfg.Sidecar,
+		TestStartTime:      time.Now(),
+	}
+
+	// the sidecar sets the data network of the instances up in the subnet.
+	subnet, _, err := nextDataNetwork(int(atomic.AddUint64(&nomadSubnetIdx, 1) % 4096))
+	if err != nil {
+		return runoutput, err
+	}
+	template.TestSubnet = &ptypes.IPNet{IPNet: *subnet}
+
+	jobs := make(map[string]*nomad.Job, len(input.Groups))
+	for _, g := range input.Groups {
+		result.Outcomes[g.ID] = &GroupOutcome{Total: g.Instances}
+
+		job, err := nomadJob(&cfg, input, template, g)
+		if err != nil {
+			return runoutput, fmt.Errorf("invalid job of group %s: %w", g.ID, err)
+		}
+		jobs[g.ID] = job
+	}
+
+	// the jobs are purged once the run is over, even if it timed out.
+	defer func() {
+		if cfg.KeepJobs {
+			ow.Info("skipping removing the jobs due to user request")
+			return
+		}
+		for _, job := range jobs {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := cl.DeregisterJob(ctx, job.ID, true); err != nil {
+				ow.Errorw("couldn't remove job", "job", job.ID, "err", err)
+			}
+			cancel()
+		}
+	}()
+
+	octx, cancelOutcomes := context.WithCancel(ctx)
+	defer cancelOutcomes()
+
+	outcomesDoneCh, err := c.collectOutcomes(octx, result, &template)
+	if err != nil {
+		return runoutput, err
+	}
+
+	// the jobs of a group are stopped once its timeout elapses.
+	gctxs, cancelGroups := groupContexts(ctx, ow, input.Groups, func(g *api.RunGroup) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := cl.DeregisterJob(ctx, jobs[g.ID].ID, false); err != nil {
+			ow.Errorw("couldn't stop job of group", "group", g.ID, "err", err)
+		}
+	})
+	defer cancelGroups()
+
+	// groups may have to wait for other groups before starting; register the
+	// jobs of the groups they wait for first.
+	starter := newGroupStarter(c.syncClient, &template, input.Groups, input.Stagger)
+	if input.Stagger != nil {
+		// nomad places the allocations of a job all at once.
+		ow.Warnw("cluster:nomad doesn't stagger the start of instances; the instances of every group start together", "stagger", input.Stagger)
+	}
+
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, g := range startOrder(input.Groups) {
+		g := g
+		eg.Go(func() error {
+			if err := starter.Wait(ectx, ow, g.ID); err != nil {
+				return err
+			}
+
+			job := jobs[g.ID]
+			ow.Infow("registering job", "group", g.ID, "job", job.ID, "image", g.ArtifactPath, "instances", g.Instances)
+			if err := cl.RegisterJob(ectx, job); err != nil {
+				return api.NewInfraError(fmt.Errorf("failed to register job of group %s: %w", g.ID, err))
+			}
+			for i := 0; i < g.Instances; i++ {
+				starter.Started(g.ID)
+			}
+
+			timedOut, err := c.watchJob(gctxs[g.ID], ow, cl, job, g, result)
+			if timedOut {
+				result.TimeOut(g.ID)
+				return nil
+			}
+			return err
+		})
+	}
+
+	runerr = eg.Wait()
+
+	// fetch the logs, even if the run failed.
+	if input.TotalInstances <= 200 {
+		for _, g := range input.Groups {
+			c.printLogs(ow, cl, jobs[g.ID], g)
+		}
+	}
+
+	cancelOutcomes()
+	<-outcomesDoneCh
+
+	for _, o := range result.Outcomes {
+		if o.TimedOut {
+			result.Outcome = task.OutcomeTimeout
+		}
+	}
+	return runoutput, runerr
+}
+
+// nomadJob returns the job of a group of the run.
+func nomadJob(cfg *ClusterNomadRunnerConfig, input *api.RunInput, template runtime.RunParams, g *api.RunGroup) (*nomad.Job, error) {
+	runenv := template
+	runenv.TestGroupID = g.ID
+	runenv.TestGroupInstanceCount = g.Instances
+	runenv.TestInstanceParams = g.Parameters
+	runenv.TestCaptureProfiles = g.Profiles
+	// every instance writes its outputs in its own directory, which Nomad
+	// interpolates with the index of its allocation.
+	runenv.TestOutputsPath = path.Join("/outputs", input.TestPlan, input.RunID, g.ID, "${NOMAD_ALLOC_INDEX}")
+
+	env := runenv.ToEnvVars()
+	env["SYNC_SERVICE_HOST"] = withDefault(cfg.SyncServiceHost, "testground-sync-service.service.consul")
+	env["REDIS_HOST"] = withDefault(cfg.RedisHost, "testground-redis.service.consul")
+	env["INFLUXDB_URL"] = withDefault(cfg.InfluxDBURL, "http://influxdb.service.consul:8086")
+	env["HOST_IP"] = "${attr.unique.network.ip-address}"
+
+	// Set the log level if provided in cfg; it can be overridden per group.
+	logLevel := cfg.LogLevel
+	if gcfg, ok := g.RunnerConfig.(*ClusterNomadRunnerConfig); ok && gcfg.LogLevel != "" {
+		logLevel = gcfg.LogLevel
+	}
+	if logLevel != "" {
+		env["LOG_LEVEL"] = logLevel
+	}
+
+	// Inject exposed ports.
+	for name, value := range cfg.ExposedPorts.ToEnvVars() {
+		env[name] = value
+	}
+
+	res, err := nomadResources(cfg, input, g)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		"testground.plan":     input.TestPlan,
+		"testground.testcase": input.TestCase,
+		"testground.run_id":   input.RunID,
+		"testground.groupid":  g.ID,
+		"testground.purpose":  "plan",
+	}
+	docker := map[string]interface{}{
+		"image":      g.ArtifactPath,
+		"labels":     labels,
+		"privileged": input.Requirements.Privileged,
+	}
+	if len(cfg.ExposedPorts) > 0 {
+		var ports []string
+		for name := range cfg.ExposedPorts {
+			ports = append(ports, name)
+		}
+		sort.Strings(ports)
+		docker["ports"] = ports
+	}
+
+	tsk := &nomad.Task{
+		Name:      "plan",
+		Driver:    "docker",
+		Config:    docker,
+		Env:       env,
+		Resources: res,
+	}
+
+	tg := &nomad.TaskGroup{
+		Name:  g.ID,
+		Count: g.Instances,
+		// instances that fail are part of the outcome of the run; they're
+		// neither restarted nor placed again.
+		RestartPolicy:    &nomad.RestartPolicy{Attempts: 0, Mode: "fail"},
+		ReschedulePolicy: &nomad.ReschedulePolicy{Attempts: 0, Unlimited: false},
+		Tasks:            []*nomad.Task{tsk},
+		Meta:             map[string]string{"testground.groupid": g.ID},
+	}
+	if cfg.OutputsVolume != "" {
+		tg.Volumes = map[string]*nomad.VolumeRequest{
+			nomadOutputsVolume: {Name: nomadOutputsVolume, Type: "host", Source: cfg.OutputsVolume},
+		}
+		tsk.VolumeMounts = []*nomad.VolumeMount{{Volume: nomadOutputsVolume, Destination: "/outputs"}}
+	}
+
+	datacenters := cfg.Datacenters
+	if len(datacenters) == 0 {
+		datacenters = []string{"dc1"}
+	}
+
+	job := &nomad.Job{
+		ID:          nomadJobID(input.RunID, g.ID),
+		Name:        fmt.Sprintf("%s%s-%s-%s", nomadJobPrefix, input.TestPlan, input.TestCase, g.ID),
+		Type:        "batch",
+		Region:      cfg.Region,
+		Namespace:   cfg.Namespace,
+		Datacenters: datacenters,
+		TaskGroups:  []*nomad.TaskGroup{tg},
+		Meta: map[string]string{
+			"testground.plan":     input.TestPlan,
+			"testground.testcase": input.TestCase,
+			"testground.run_id":   input.RunID,
+			"testground.purpose":  "plan",
+		},
+	}
+	if cfg.NodeClass != "" {
+		job.Constraints = []*nomad.Constraint{{LTarget: "${node.class}", RTarget: cfg.NodeClass, Operand: "="}}
+	}
+	return job, nil
+}
+
+func nomadJobID(runID, group string) string {
+	return nomadJobPrefix + runID + "-" + group
+}
+
+// nomadResources returns the resources reserved for each instance of the
+// group.
+func nomadResources(cfg *ClusterNomadRunnerConfig, input *api.RunInput, g *api.RunGroup) (*nomad.Resources, error) {
+	res := &nomad.Resources{
+		CPU:      cfg.TestplanCPU,
+		MemoryMB: cfg.TestplanMemory,
+	}
+	if res.CPU <= 0 {
+		res.CPU = 100
+	}
+	if res.MemoryMB <= 0 {
+		res.MemoryMB = 100
+	}
+
+	if g.Resources.CPU != "" {
+		q, err := resource.ParseQuantity(g.Resources.CPU)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu: %w", err)
+		}
+		res.CPU = int(q.MilliValue() * nomadMHzPerCore / 1000)
+	}
+
+	mem := g.Resources.Memory
+	if mem == "" && input.Requirements.MinMemory != "" {
+		// the default must not fall short of what the test case requires.
+		q, err := resource.ParseQuantity(input.Requirements.MinMemory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum memory: %w", err)
+		}
+		if mb := int(q.Value() >> 20); mb > res.MemoryMB {
+			res.MemoryMB = mb
+		}
+	}
+	if mem != "" {
+		q, err := resource.ParseQuantity(mem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory: %w", err)
+		}
+		res.MemoryMB = int(q.Value() >> 20)
+	}
+	return res, nil
+}
+
+// watchJob follows the allocations of the job of a group until they're all
+// done, and returns whether the group timed out before. Allocations that are
+// lost, or whose image can't be pulled, fail the run because of the cluster
+// rather than the test plan.
+func (c *ClusterNomadRunner) watchJob(ctx context.Context, ow *rpc.OutputWriter, cl *nomad.Client, job *nomad.Job, g *api.RunGroup, result *Result) (timedOut bool, err error) {
+	start := time.Now()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	var (
+		placementLogged bool
+		allRunning      bool
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err() == context.DeadlineExceeded, ctx.Err()
+		case <-ticker.C:
+		}
+
+		allocs, err := cl.Allocations(ctx, job.ID)
+		if err != nil {
+			ow.Warnw("nomad allocations list error", "job", job.ID, "err", err)
+			continue
+		}
+
+		counters := make(map[string]int)
+		for _, a := range allocs {
+			counters[a.ClientStatus]++
+
+			if a.ClientStatus == nomad.AllocLost {
+				return false, api.NewInfraError(fmt.Errorf("allocation %s of group %s was lost", a.Name, g.ID))
+			}
+			for _, st := range a.TaskStates {
+				for _, ev := range st.Events {
+					if ev.Type == "Driver Failure" {
+						return false, api.NewInfraError(fmt.Errorf("allocation %s of group %s can't start: %s", a.Name, g.ID, ev.DisplayMessage))
+					}
+				}
+				if st.Failed && a.ClientStatus == nomad.AllocFailed {
+					status := fmt.Sprintf("allocation status <failed> obj<%s> node<%s>", a.Name, a.NodeID)
+					if n := len(st.Events); n > 0 {
+						status += fmt.Sprintf(" event<%s>", st.Events[n-1].DisplayMessage)
+					}
+					result.Journal.PodsStatuses[status] = struct{}{}
+				}
+			}
+		}
+
+		ow.Debugw("testplan allocations state", "group", g.ID, "running_for", time.Since(start).Truncate(time.Second), "complete", counters[nomad.AllocComplete], "running", counters[nomad.AllocRunning], "pending", counters[nomad.AllocPending], "failed", counters[nomad.AllocFailed])
+
+		// instances that can't be placed wait for capacity; say why.
+		if len(allocs) < g.Instances && !placementLogged {
+			if evals, err := cl.Evaluations(ctx, job.ID); err == nil {
+				for _, ev := range evals {
+					for tg, m := range ev.FailedTGAllocs {
+						event := fmt.Sprintf("group<%s> could not place all instances: %s", tg, m)
+						ow.Warnw("testplan received event", "event", event)
+						result.Journal.Events[ev.ID] = event
+						placementLogged = true
+					}
+				}
+			}
+		}
+
+		if counters[nomad.AllocRunning] == g.Instances && !allRunning {
+			allRunning = true
+			ow.Infow("all instances of group in `running` state", "group", g.ID, "took", time.Since(start).Truncate(time.Second))
+		}
+
+		if done := counters[nomad.AllocComplete] + counters[nomad.AllocFailed]; done >= g.Instances {
+			ow.Infow("all instances of group done", "group", g.ID, "complete", counters[nomad.AllocComplete], "failed", counters[nomad.AllocFailed], "took", time.Since(start).Truncate(time.Second))
+			for _, a := range allocs {
+				if a.ClientStatus == nomad.AllocFailed && a.Index() >= 0 {
+					result.InstanceFailed(g.ID, a.Index())
+				}
+			}
+			return false, nil
+		}
+	}
+}
+
+// printLogs writes the logs of the instances of the group.
+func (c *ClusterNomadRunner) printLogs(ow *rpc.OutputWriter, cl *nomad.Client, job *nomad.Job, g *api.RunGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	allocs, err := cl.Allocations(ctx, job.ID)
+	if err != nil {
+		ow.Errorw("error while fetching logs", "group", g.ID, "err", err)
+		return
+	}
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].Index() < allocs[j].Index() })
+
+	for _, a := range allocs {
+		for _, typ := range []string{"stdout", "stderr"} {
+			rc, err := cl.Logs(ctx, a.ID, "plan", typ)
+			if err != nil {
+				ow.Debugw("could not fetch logs", "allocation", a.Name, "err", err)
+				continue
+			}
+			logs, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				ow.Errorw("error while fetching logs", "allocation", a.Name, "err", err)
+				continue
+			}
+			_, _ = ow.WriteProgress(logs)
+		}
+	}
+}
+
+func (c *ClusterNomadRunner) collectOutcomes(ctx context.Context, result *Result, tpl *runtime.RunParams) (chan bool, error) {
+	eventsCh, err := c.syncClient.SubscribeEvents(ctx, tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan bool)
+
+	go func() {
+		running := true
+		for running {
+			select {
+			case <-ctx.Done():
+				running = false
+			case e := <-eventsCh:
+				// for now we emit only outcome OK events, so no need for more checks
+				if e.SuccessEvent != nil {
+					se := e.SuccessEvent
+					o := result.Outcomes[se.TestGroupID]
+					o.Ok = o.Ok + 1
+				}
+			}
+		}
+
+		result.Outcome = task.OutcomeSuccess
+		if len(result.Outcomes) == 0 {
+			result.Outcome = task.OutcomeFailure
+		}
+
+		for g := range result.Outcomes {
+			if result.Outcomes[g].Total != result.Outcomes[g].Ok {
+				result.Outcome = task.OutcomeFailure
+				break
+			}
+		}
+
+		done <- true
+	}()
+
+	return done, nil
+}
+
+// CollectOutputs archives the outputs of the run, from the outputs volume
+// as the daemon sees it.
+func (c *ClusterNomadRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
+	cfg, ok := input.RunnerConfig.(*ClusterNomadRunnerConfig)
+	if !ok || cfg.OutputsPath == "" {
+		return errors.New("no outputs_path configured; the outputs of the run can't be collected")
+	}
+	return gzipRunOutputs(ctx, cfg.OutputsPath, input, ow)
+}
+
+func (*ClusterNomadRunner) ID() string {
+	return "cluster:nomad"
+}
+
+func (c *ClusterNomadRunner) Healthcheck(ctx context.Context, engine api.Engine, ow *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	var cfg ClusterNomadRunnerConfig
+	coalesced := config.CoalescedConfig{engine.EnvConfig().Runners[c.ID()]}
+	if obj, err := coalesced.CoalesceIntoType(c.ConfigType()); err == nil {
+		cfg = *obj.(*ClusterNomadRunnerConfig)
+	}
+	cl := c.client(&cfg)
+
+	hh := &healthcheck.Helper{}
+
+	hh.Require("nomad api",
+		healthcheck.CheckNomadAPI(ctx, cl),
+		healthcheck.RequiresManualFixing(),
+	)
+
+	hh.Enlist("sync service job",
+		healthcheck.CheckNomadJob(ctx, cl, "testground-sync-service"),
+		healthcheck.NotImplemented(),
+	)
+
+	hh.Enlist("redis job",
+		healthcheck.CheckNomadJob(ctx, cl, "testground-redis"),
+		healthcheck.NotImplemented(),
+	)
+
+	if cfg.Sidecar {
+		hh.Enlist("sidecar job",
+			healthcheck.CheckNomadJob(ctx, cl, "testground-sidecar"),
+			healthcheck.NotImplemented(),
+		)
+	}
+
+	if cfg.OutputsPath != "" {
+		hh.Enlist("outputs path",
+			healthcheck.CheckDirectoryExists(cfg.OutputsPath),
+			healthcheck.RequiresManualFixing(),
+		)
+	}
+
+	return hh.RunChecks(ctx, fix)
+}
+
+// TerminateAll stops and purges the jobs of all the test plans.
+func (c *ClusterNomadRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
+	cl := c.client(&ClusterNomadRunnerConfig{})
+
+	jobs, err := cl.Jobs(ctx, nomadJobPrefix)
+	if err != nil {
+		ow.Errorw("could not list jobs", "err", err)
+		return err
+	}
+
+	var failed int
+	for _, job := range jobs {
+		if job.Meta["testground.purpose"] != "plan" {
+			continue
+		}
+		ow.Infow("removing job", "job", job.ID)
+		if err := cl.DeregisterJob(ctx, job.ID, true); err != nil {
+			ow.Errorw("could not remove job", "job", job.ID, "err", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not remove %d jobs", failed)
+	}
+	return nil
+}
+
+// Cleanup purges the jobs of the run.
+func (c *ClusterNomadRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	cl := c.client(&ClusterNomadRunnerConfig{})
+
+	jobs, err := cl.Jobs(ctx, nomadJobPrefix+runID+"-")
+	if err != nil {
+		return fmt.Errorf("could not list jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if job.Meta["testground.run_id"] != runID {
+			continue
+		}
+		ow.Infow("removing job", "job", job.ID)
+		if err := cl.DeregisterJob(ctx, job.ID, true); err != nil {
+			return fmt.Errorf("could not remove job %s: %w", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// Leftovers returns the IDs of the runs that left jobs behind.
+func (c *ClusterNomadRunner) Leftovers(ctx context.Context) ([]string, error) {
+	jobs, err := c.client(&ClusterNomadRunnerConfig{}).Jobs(ctx, nomadJobPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list jobs: %w", err)
+	}
+
+	runs := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		runs = append(runs, job.Meta["testground.run_id"])
+	}
+	return uniqueRunIDs(runs), nil
+}
+
+func (*ClusterNomadRunner) ConfigType() reflect.Type {
+	return reflect.TypeOf(ClusterNomadRunnerConfig{})
+}
+
+func (*ClusterNomadRunner) CompatibleBuilders() []string {
+	return []string{"docker:go", "docker:node", "docker:generic", "docker:rust"}
+}
+
+// Capabilities reports that the runner runs docker images, in privileged
+// mode when required.
+func (*ClusterNomadRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		Privileged: true,
+		Artifacts:  []api.ArtifactKind{api.ArtifactDockerImage},
+	}
+}
+
+func withDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
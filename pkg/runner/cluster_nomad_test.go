@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/testground/pkg/api"
+)
+
+func TestNomadJob(t *testing.T) {
+	cfg := &ClusterNomadRunnerConfig{
+		OutputsVolume: "outputs",
+		NodeClass:     "plan",
+		LogLevel:      "info",
+	}
+	input := &api.RunInput{
+		RunID:    "run",
+		TestPlan: "plan",
+		TestCase: "case",
+	}
+	g := &api.RunGroup{
+		ID:           "a",
+		Instances:    3,
+		ArtifactPath: "image",
+		Resources:    api.Resources{CPU: "500m", Memory: "1Gi"},
+		RunnerConfig: &ClusterNomadRunnerConfig{LogLevel: "debug"},
+	}
+
+	_, subnet, _ := net.ParseCIDR("16.0.0.0/16")
+	tpl := runtime.RunParams{TestRun: "run", TestSubnet: &ptypes.IPNet{IPNet: *subnet}}
+
+	job, err := nomadJob(cfg, input, tpl, g)
+	require.NoError(t, err)
+	require.Equal(t, "tg-run-a", job.ID)
+	require.Equal(t, "batch", job.Type)
+	require.Equal(t, []string{"dc1"}, job.Datacenters)
+	require.Len(t, job.Constraints, 1)
+
+	require.Len(t, job.TaskGroups, 1)
+	tg := job.TaskGroups[0]
+	require.Equal(t, 3, tg.Count)
+	require.Equal(t, 0, tg.RestartPolicy.Attempts)
+	require.Contains(t, tg.Volumes, nomadOutputsVolume)
+
+	tsk := tg.Tasks[0]
+	require.Equal(t, 500, tsk.Resources.CPU)
+	require.Equal(t, 1024, tsk.Resources.MemoryMB)
+	require.Equal(t, "debug", tsk.Env["LOG_LEVEL"])
+	require.Equal(t, "/outputs/plan/run/a/${NOMAD_ALLOC_INDEX}", tsk.Env["TEST_OUTPUTS_PATH"])
+	require.Equal(t, "a", tsk.Env["TEST_GROUP_ID"])
+
+	// invalid resources are rejected.
+	g.Resources.CPU = "lots"
+	_, err = nomadJob(cfg, input, tpl, g)
+	require.Error(t, err)
+}
@@ -0,0 +1,723 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/healthcheck"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+var (
+	_ api.Runner        = (*ClusterSSHRunner)(nil)
+	_ api.Healthchecker = (*ClusterSSHRunner)(nil)
+	_ api.Capable       = (*ClusterSSHRunner)(nil)
+)
+
+// SSHHost is a host of the inventory of the cluster:ssh runner. The user,
+// identity file and work directory default to those of the runner.
+type SSHHost struct {
+	// Name of the host in the logs; the address by default.
+	Name string `toml:"name"`
+	// Address of the SSH server, as host[:port].
+	Address      string `toml:"address"`
+	User         string `toml:"user"`
+	IdentityFile string `toml:"identity_file"`
+	// Workdir is where the artifacts and outputs of the runs are kept on the
+	// host, in a directory per run.
+	Workdir string `toml:"workdir"`
+	// Capacity is the maximum number of instances the host runs at once;
+	// unlimited if zero.
+	Capacity int `toml:"capacity"`
+	// Tags describe the host, e.g. its hardware, for groups to select it;
+	// see ClusterSSHRunnerConfig#HostTags.
+	Tags []string `toml:"tags"`
+}
+
+// ClusterSSHRunnerConfig is the configuration object of this runner. Boolean
+// values are expressed in a way that zero value (false) is the default setting.
+type ClusterSSHRunnerConfig struct {
+	// Hosts is the inventory of hosts the instances run on, along with the
+	// hosts of the Inventory file, if any, which holds [[hosts]] tables.
+	Hosts     []SSHHost `toml:"hosts"`
+	Inventory string    `toml:"inventory"`
+
+	// Defaults of the hosts: the current user, ~/.ssh/id_rsa (or the keys of
+	// the SSH agent) and /tmp/testground.
+	User         string `toml:"user"`
+	IdentityFile string `toml:"identity_file"`
+	Workdir      string `toml:"workdir"`
+
+	// KnownHosts verifies the keys of the hosts (default: ~/.ssh/known_hosts).
+	// InsecureIgnoreHostKey skips the verification.
+	KnownHosts            string `toml:"known_hosts"`
+	InsecureIgnoreHostKey bool   `toml:"insecure_ignore_host_key"`
+
+	// HostTags restricts the instances to the hosts with all the tags. It
+	// can be set per group.
+	HostTags []string `toml:"host_tags"`
+
+	// Addresses of the services, as the hosts reach them; the sync service
+	// must be reachable by the daemon at the same address.
+	SyncServiceHost string `toml:"sync_service_host"`
+	RedisHost       string `toml:"redis_host"` // default: the sync service host
+	InfluxDBURL     string `toml:"influxdb_url"`
+
+	// Subnet is the network the hosts talk to each other over, which the
+	// instances find their data network address in (default: any).
+	Subnet string `toml:"subnet"`
+
+	// LogLevel sets the log level of the instances (default: not set). It
+	// can be set per group.
+	LogLevel string `toml:"log_level"`
+
+	// KeepFiles leaves the artifacts and the outputs of the runs on the
+	// hosts, once the outputs are fetched.
+	KeepFiles bool `toml:"keep_files"`
+}
+
+// ClusterSSHRunner is a runner that runs executables on an inventory of
+// hosts over SSH, e.g. bare-metal machines. It copies the artifacts of the
+// groups to the hosts, launches the instances with their environment, tails
+// their output, and fetches their outputs back into the outputs directory of
+// the daemon once they're done.
+type ClusterSSHRunner struct{}
+
+// sshInstance is an instance of a group running on a host.
+type sshInstance struct {
+	group *api.RunGroup
+	index int
+	host  *sshConn
+	dir   string // remote
+}
+
+// sshConn is a connection to a host of the inventory.
+type sshConn struct {
+	*ssh.Client
+	host *SSHHost
+}
+
+func (r *ClusterSSHRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	cfg := *input.RunnerConfig.(*ClusterSSHRunnerConfig)
+	ow = ow.With("runner", r.ID(), "run_id", input.RunID)
+
+	hosts, err := cfg.inventory()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SyncServiceHost == "" {
+		return nil, errors.New("no sync_service_host configured; the hosts must reach the sync service")
+	}
+
+	assigned, err := assignSSHHosts(hosts, input.Groups, func(g *api.RunGroup) []string {
+		if gcfg, ok := g.RunnerConfig.(*ClusterSSHRunnerConfig); ok {
+			return gcfg.HostTags
+		}
+		return cfg.HostTags
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subnet := &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+	if cfg.Subnet != "" {
+		if _, subnet, err = net.ParseCIDR(cfg.Subnet); err != nil {
+			return nil, fmt.Errorf("invalid subnet: %w", err)
+		}
+	}
+
+	template := runtime.RunParams{
+		TestPlan:           input.TestPlan,
+		TestCase:           input.TestCase,
+		TestRun:            input.RunID,
+		TestInstanceCount:  input.TotalInstances,
+		TestDisableMetrics: input.DisableMetrics,
+		TestSidecar:        false,
+		TestSubnet:         &ptypes.IPNet{IPNet: *subnet},
+	}
+
+	// connect to the hosts the instances run on.
+	conns, err := cfg.dial(ctx, hosts, assigned)
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	if err != nil {
+		return nil, api.NewInfraError(err)
+	}
+
+	// copy the artifacts of the groups to the hosts they run on.
+	bins := make(map[string]string) // host/group -> remote path
+	for _, g := range input.Groups {
+		for _, h := range uniqueInts(assigned[g.ID]) {
+			c := conns[h]
+			bin := path.Join(c.runDir(input.RunID), "bin", g.ID)
+			ow.Infow("copying artifact", "group", g.ID, "host", c.host.Name)
+			if err := c.upload(g.ArtifactPath, bin); err != nil {
+				return nil, api.NewInfraError(fmt.Errorf("failed to copy the artifact of group %s to %s: %w", g.ID, c.host.Name, err))
+			}
+			bins[c.host.Name+"/"+g.ID] = bin
+		}
+	}
+
+	// the outputs are fetched, and the files removed, even if the run failed.
+	odir := filepath.Join(input.EnvConfig.Dirs().Outputs(), "cluster_ssh", input.TestPlan, input.RunID)
+	defer func() {
+		for _, c := range conns {
+			if err := c.download(path.Join(c.runDir(input.RunID), "outputs"), odir); err != nil {
+				ow.Errorw("failed to fetch outputs", "host", c.host.Name, "err", err)
+			}
+			if cfg.KeepFiles {
+				continue
+			}
+			if err := c.run("rm -rf " + shellQuote(c.runDir(input.RunID))); err != nil {
+				ow.Warnw("failed to remove the files of the run", "host", c.host.Name, "err", err)
+			}
+		}
+	}()
+
+	// groups may have to wait for other groups, or for a state, before
+	// starting.
+	var client ss.Client
+	if waitsOnState(input) {
+		c, err := newSyncClient(ctx, cfg.SyncServiceHost, "")
+		if err != nil {
+			return nil, api.NewInfraError(fmt.Errorf("failed to connect to the sync service: %w", err))
+		}
+		defer c.Close()
+		client = c
+	}
+	starter := newGroupStarter(client, &template, input.Groups, input.Stagger)
+
+	var (
+		lk        sync.Mutex
+		wg        sync.WaitGroup
+		instances []*sshInstance
+		pretty    = NewPrettyPrinter(ow)
+	)
+
+	kill := func(match func(*sshInstance) bool) {
+		lk.Lock()
+		defer lk.Unlock()
+		for _, in := range instances {
+			if match(in) {
+				_ = in.host.run(fmt.Sprintf("kill -KILL $(cat %s) 2>/dev/null", shellQuote(path.Join(in.dir, "pid"))))
+			}
+		}
+	}
+	defer kill(func(*sshInstance) bool { return ctx.Err() != nil })
+
+	// the instances of a group are killed once its timeout elapses.
+	gctxs, cancelGroups := groupContexts(ctx, ow, input.Groups, func(g *api.RunGroup) {
+		kill(func(in *sshInstance) bool { return in.group == g })
+	})
+	defer cancelGroups()
+
+	start := func(g *api.RunGroup, i int) {
+		c := conns[assigned[g.ID][i]]
+		tag := fmt.Sprintf("%s[%03d] (%s)", g.ID, i, c.host.Name)
+		in := &sshInstance{
+			group: g,
+			index: i,
+			host:  c,
+			dir:   path.Join(c.runDir(input.RunID), "instances", g.ID, strconv.Itoa(i)),
+		}
+
+		runenv := template
+		runenv.TestGroupID = g.ID
+		runenv.TestGroupInstanceCount = g.Instances
+		runenv.TestInstanceParams = g.Parameters
+		runenv.TestOutputsPath = path.Join(c.runDir(input.RunID), "outputs", g.ID, strconv.Itoa(i))
+		runenv.TestTempPath = path.Join(in.dir, "tmp")
+		runenv.TestStartTime = time.Now()
+		runenv.TestCaptureProfiles = g.Profiles
+
+		cmd := sshCommand(&cfg, g, runenv, bins[c.host.Name+"/"+g.ID], in.dir)
+
+		sess, err := c.NewSession()
+		if err != nil {
+			pretty.FailStart(tag, err)
+			return
+		}
+		stdout, _ := sess.StdoutPipe()
+		stderr, _ := sess.StderrPipe()
+
+		ow.Infow("starting test case instance", "plan", input.TestPlan, "group", g.ID, "number", i, "host", c.host.Name)
+		if err := sess.Start(cmd); err != nil {
+			pretty.FailStart(tag, err)
+			_ = sess.Close()
+			return
+		}
+
+		lk.Lock()
+		instances = append(instances, in)
+		lk.Unlock()
+
+		// instance tag in output: << group[zero_padded_i] (host) >>, e.g. << miner[003] (bench-1) >>
+		pretty.Manage(tag, ioutil.NopCloser(stdout), ioutil.NopCloser(stderr))
+
+		go func() {
+			_ = sess.Wait()
+			_ = sess.Close()
+		}()
+	}
+
+	// spawn starts the instances of a group. Instances that fail to start
+	// count as started too, so that the groups waiting on this one don't
+	// hang; the run fails regardless.
+	spawn := func(g *api.RunGroup) {
+		for i := 0; i < g.Instances; i++ {
+			if err := starter.Admit(ctx, ow); err != nil {
+				pretty.FailStart(fmt.Sprintf("%s[%03d]", g.ID, i), err)
+			} else if gctxs[g.ID].Err() == nil {
+				start(g, i)
+			}
+			starter.Started(g.ID)
+		}
+	}
+
+	for _, g := range input.Groups {
+		if g.StartAfter == nil {
+			spawn(g)
+			continue
+		}
+
+		// spawn the groups that have to wait in the background, so that
+		// they don't hold back the groups they wait for.
+		wg.Add(1)
+		go func(g *api.RunGroup) {
+			defer wg.Done()
+			if err := starter.Wait(gctxs[g.ID], ow, g.ID); err != nil {
+				for i := 0; i < g.Instances; i++ {
+					pretty.FailStart(fmt.Sprintf("%s[%03d]", g.ID, i), err)
+				}
+				return
+			}
+			spawn(g)
+		}(g)
+	}
+	wg.Wait()
+
+	select {
+	case err = <-pretty.Wait():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &api.RunOutput{RunID: input.RunID}, nil
+}
+
+// sshCommand returns the shell command that runs an instance in its
+// directory. It records the PID of the instance, so that it can be killed.
+func sshCommand(cfg *ClusterSSHRunnerConfig, g *api.RunGroup, runenv runtime.RunParams, bin, dir string) string {
+	env := runenv.ToEnvVars()
+	// coverage builds write their coverage data to the outputs.
+	env["GOCOVERDIR"] = runenv.TestOutputsPath
+	env["SYNC_SERVICE_HOST"] = cfg.SyncServiceHost
+	// NOTE: we export REDIS_HOST for compatibility with older sdk versions.
+	env["REDIS_HOST"] = withDefault(cfg.RedisHost, cfg.SyncServiceHost)
+	if cfg.InfluxDBURL != "" {
+		env["INFLUXDB_URL"] = cfg.InfluxDBURL
+	}
+
+	logLevel := cfg.LogLevel
+	if gcfg, ok := g.RunnerConfig.(*ClusterSSHRunnerConfig); ok && gcfg.LogLevel != "" {
+		logLevel = gcfg.LogLevel
+	}
+	if logLevel != "" {
+		env["LOG_LEVEL"] = logLevel
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "mkdir -p %s %s %s && cd %s && echo $$ > pid && exec env", shellQuote(runenv.TestOutputsPath), shellQuote(runenv.TestTempPath), shellQuote(dir), shellQuote(dir))
+	for _, k := range keys {
+		b.WriteString(" " + shellQuote(k+"="+env[k]))
+	}
+	b.WriteString(" " + shellQuote(bin))
+	return b.String()
+}
+
+// assignSSHHosts assigns the instances of the groups to the hosts with the
+// tags of the group, spreading them evenly within their capacity, and
+// returns the indices of the hosts of the instances of each group.
+func assignSSHHosts(hosts []SSHHost, groups []*api.RunGroup, tags func(*api.RunGroup) []string) (map[string][]int, error) {
+	used := make([]int, len(hosts))
+	res := make(map[string][]int, len(groups))
+
+	for _, g := range groups {
+		var eligible []int
+		for i := range hosts {
+			if hasTags(hosts[i].Tags, tags(g)) {
+				eligible = append(eligible, i)
+			}
+		}
+		if len(eligible) == 0 {
+			return nil, fmt.Errorf("no host with the tags %v for group %s", tags(g), g.ID)
+		}
+
+		for n := 0; n < g.Instances; n++ {
+			// the least used host with room left.
+			best := -1
+			for _, i := range eligible {
+				if hosts[i].Capacity > 0 && used[i] >= hosts[i].Capacity {
+					continue
+				}
+				if best < 0 || used[i] < used[best] {
+					best = i
+				}
+			}
+			if best < 0 {
+				return nil, fmt.Errorf("not enough capacity for the %d instances of group %s on the hosts with the tags %v", g.Instances, g.ID, tags(g))
+			}
+			used[best]++
+			res[g.ID] = append(res[g.ID], best)
+		}
+	}
+	return res, nil
+}
+
+func hasTags(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			found = found || h == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueInts(in []int) []int {
+	seen := make(map[int]struct{}, len(in))
+	var res []int
+	for _, i := range in {
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// inventory returns the hosts of the configuration, with the defaults set.
+func (cfg *ClusterSSHRunnerConfig) inventory() ([]SSHHost, error) {
+	hosts := append([]SSHHost(nil), cfg.Hosts...)
+	if cfg.Inventory != "" {
+		var inv struct {
+			Hosts []SSHHost `toml:"hosts"`
+		}
+		if _, err := toml.DecodeFile(cfg.Inventory, &inv); err != nil {
+			return nil, fmt.Errorf("failed to read the inventory: %w", err)
+		}
+		hosts = append(hosts, inv.Hosts...)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts in the inventory")
+	}
+
+	names := make(map[string]struct{}, len(hosts))
+	for i := range hosts {
+		h := &hosts[i]
+		if h.Address == "" {
+			return nil, fmt.Errorf("host %d of the inventory has no address", i)
+		}
+		if _, _, err := net.SplitHostPort(h.Address); err != nil {
+			h.Address = net.JoinHostPort(h.Address, "22")
+		}
+		if h.Name == "" {
+			h.Name = h.Address
+		}
+		if _, ok := names[h.Name]; ok {
+			return nil, fmt.Errorf("duplicate host in the inventory: %s", h.Name)
+		}
+		names[h.Name] = struct{}{}
+
+		h.User = withDefault(h.User, withDefault(cfg.User, os.Getenv("USER")))
+		h.IdentityFile = withDefault(h.IdentityFile, cfg.IdentityFile)
+		h.Workdir = withDefault(h.Workdir, withDefault(cfg.Workdir, "/tmp/testground"))
+	}
+	return hosts, nil
+}
+
+// clientConfig returns the SSH configuration of a host.
+func (cfg *ClusterSSHRunnerConfig) clientConfig(h *SSHHost) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+
+	identity := expandHome(withDefault(h.IdentityFile, "~/.ssh/id_rsa"))
+	if key, err := ioutil.ReadFile(identity); err == nil {
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity file %s: %w", identity, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if h.IdentityFile != "" {
+		return nil, err
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no identity file or SSH agent to authenticate to %s with", h.Name)
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !cfg.InsecureIgnoreHostKey {
+		cb, err := knownhosts.New(expandHome(withDefault(cfg.KnownHosts, "~/.ssh/known_hosts")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the known hosts: %w", err)
+		}
+		hostKey = cb
+	}
+
+	return &ssh.ClientConfig{
+		User:            h.User,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         10 * time.Second,
+	}, nil
+}
+
+// dial connects to the hosts the instances were assigned to.
+func (cfg *ClusterSSHRunnerConfig) dial(ctx context.Context, hosts []SSHHost, assigned map[string][]int) (map[int]*sshConn, error) {
+	conns := make(map[int]*sshConn)
+	for _, idx := range assigned {
+		for _, i := range idx {
+			if _, ok := conns[i]; ok {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return conns, err
+			}
+			c, err := cfg.connect(&hosts[i])
+			if err != nil {
+				return conns, err
+			}
+			conns[i] = c
+		}
+	}
+	return conns, nil
+}
+
+func (cfg *ClusterSSHRunnerConfig) connect(h *SSHHost) (*sshConn, error) {
+	ccfg, err := cfg.clientConfig(h)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := ssh.Dial("tcp", h.Address, ccfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", h.Name, err)
+	}
+	return &sshConn{Client: cl, host: h}, nil
+}
+
+func (c *sshConn) runDir(runID string) string {
+	return path.Join(c.host.Workdir, runID)
+}
+
+// run runs a shell command on the host, and returns its error output along
+// with the error, if it fails.
+func (c *sshConn) run(cmd string) error {
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	var stderr bytes.Buffer
+	sess.Stderr = &stderr
+	if err := sess.Run(cmd); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// upload copies an executable to the host.
+func (c *sshConn) upload(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	sess.Stdin = f
+	dst = shellQuote(dst)
+	return sess.Run(fmt.Sprintf("mkdir -p \"$(dirname %s)\" && cat > %s && chmod +x %s", dst, dst, dst))
+}
+
+// download copies a directory of the host, if it exists, into a local one.
+func (c *sshConn) download(src, dst string) error {
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	src = shellQuote(src)
+	if err := sess.Start(fmt.Sprintf("test ! -d %s || tar -C %s -cf - .", src, src)); err != nil {
+		return err
+	}
+	if err := untar(stdout, dst); err != nil {
+		return err
+	}
+	return sess.Wait()
+}
+
+// untar extracts a tar archive into a directory.
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if p != dir && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			_ = f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// expandHome expands a leading ~ of a path to the home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		return filepath.Join(homeDir(), p[1:])
+	}
+	return p
+}
+
+// shellQuote quotes a string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (r *ClusterSSHRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
+	return gzipRunOutputs(ctx, filepath.Join(input.EnvConfig.Dirs().Outputs(), "cluster_ssh"), input, ow)
+}
+
+// Healthcheck checks that the hosts of the inventory can be reached.
+func (r *ClusterSSHRunner) Healthcheck(ctx context.Context, engine api.Engine, ow *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	var cfg ClusterSSHRunnerConfig
+	coalesced := config.CoalescedConfig{engine.EnvConfig().Runners[r.ID()]}
+	if obj, err := coalesced.CoalesceIntoType(r.ConfigType()); err == nil {
+		cfg = *obj.(*ClusterSSHRunnerConfig)
+	}
+
+	hh := &healthcheck.Helper{}
+
+	hosts, err := cfg.inventory()
+	if err != nil {
+		hh.Enlist("inventory", func() (bool, string, error) {
+			return false, err.Error(), nil
+		}, healthcheck.RequiresManualFixing())
+		return hh.RunChecks(ctx, fix)
+	}
+
+	for i := range hosts {
+		h := &hosts[i]
+		hh.Enlist("host "+h.Name, func() (bool, string, error) {
+			c, err := cfg.connect(h)
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			defer c.Close()
+			if err := c.run("true"); err != nil {
+				return false, err.Error(), nil
+			}
+			return true, fmt.Sprintf("%s reachable as %s", h.Address, h.User), nil
+		}, healthcheck.RequiresManualFixing())
+	}
+
+	return hh.RunChecks(ctx, fix)
+}
+
+func (*ClusterSSHRunner) ID() string {
+	return "cluster:ssh"
+}
+
+func (*ClusterSSHRunner) ConfigType() reflect.Type {
+	return reflect.TypeOf(ClusterSSHRunnerConfig{})
+}
+
+func (*ClusterSSHRunner) CompatibleBuilders() []string {
+	return []string{"exec:go", "exec:rust"}
+}
+
+// Capabilities reports that the runner runs executables. The hosts may run
+// on any platform, which the test plans must be built for.
+func (*ClusterSSHRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		Artifacts: []api.ArtifactKind{api.ArtifactExecutable},
+	}
+}
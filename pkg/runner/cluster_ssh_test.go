@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/testground/pkg/api"
+)
+
+func TestAssignSSHHosts(t *testing.T) {
+	hosts := []SSHHost{
+		{Name: "a", Capacity: 2},
+		{Name: "b", Tags: []string{"arm64"}},
+		{Name: "c", Tags: []string{"arm64", "gpu"}, Capacity: 1},
+	}
+	groups := []*api.RunGroup{
+		{ID: "any", Instances: 3},
+		{ID: "arm", Instances: 3},
+	}
+	tags := map[string][]string{"arm": {"arm64"}}
+	byTags := func(g *api.RunGroup) []string { return tags[g.ID] }
+
+	res, err := assignSSHHosts(hosts, groups, byTags)
+	require.NoError(t, err)
+	// the instances are spread evenly.
+	require.Equal(t, []int{0, 1, 2}, res["any"])
+	// c is full, so the instances of arm go to b.
+	require.Equal(t, []int{1, 1, 1}, res["arm"])
+
+	// there is no room left for a third instance on the gpu hosts.
+	tags["arm"] = []string{"gpu"}
+	groups[1].Instances = 2
+	_, err = assignSSHHosts(hosts, groups, byTags)
+	require.Error(t, err)
+
+	tags["arm"] = []string{"x86"}
+	_, err = assignSSHHosts(hosts, groups, byTags)
+	require.Error(t, err)
+}
+
+func TestSSHInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	inv := filepath.Join(dir, "hosts.toml")
+	require.NoError(t, ioutil.WriteFile(inv, []byte("[[hosts]]\naddress = \"b:2222\"\nuser = \"root\"\n"), 0644))
+
+	cfg := &ClusterSSHRunnerConfig{
+		Hosts:     []SSHHost{{Address: "a"}},
+		Inventory: inv,
+		User:      "tg",
+	}
+	hosts, err := cfg.inventory()
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	require.Equal(t, "a:22", hosts[0].Name)
+	require.Equal(t, "tg", hosts[0].User)
+	require.Equal(t, "/tmp/testground", hosts[0].Workdir)
+	require.Equal(t, "b:2222", hosts[1].Address)
+	require.Equal(t, "root", hosts[1].User)
+
+	cfg.Hosts = append(cfg.Hosts, SSHHost{Address: "a:22"})
+	_, err = cfg.inventory()
+	require.Error(t, err)
+
+	_, err = (&ClusterSSHRunnerConfig{}).inventory()
+	require.Error(t, err)
+}
+
+func TestSSHCommand(t *testing.T) {
+	cfg := &ClusterSSHRunnerConfig{SyncServiceHost: "10.0.0.1", LogLevel: "info"}
+	g := &api.RunGroup{ID: "a", RunnerConfig: &ClusterSSHRunnerConfig{LogLevel: "debug"}}
+	runenv := runtime.RunParams{
+		TestSubnet:      &ptypes.IPNet{IPNet: *localSubnet},
+		TestOutputsPath: "/tmp/testground/run/outputs/a/0",
+		TestTempPath:    "/tmp/testground/run/instances/a/0/tmp",
+		TestInstanceParams: map[string]string{
+			"msg": "it's",
+		},
+	}
+
+	cmd := sshCommand(cfg, g, runenv, "/tmp/testground/run/bin/a", "/tmp/testground/run/instances/a/0")
+	require.True(t, strings.HasPrefix(cmd, "mkdir -p '/tmp/testground/run/outputs/a/0' "))
+	require.Contains(t, cmd, "echo $$ > pid && exec env ")
+	require.Contains(t, cmd, "'LOG_LEVEL=debug'")
+	require.Contains(t, cmd, "'REDIS_HOST=10.0.0.1'")
+	require.Contains(t, cmd, `'TEST_INSTANCE_PARAMS=msg=it'\''s'`)
+	require.True(t, strings.HasSuffix(cmd, " '/tmp/testground/run/bin/a'"))
+}
+
+func TestUntar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./a/0/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./a/0/out.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 2}))
+	_, _ = tw.Write([]byte("ok"))
+	require.NoError(t, tw.Close())
+
+	dir, err := ioutil.TempDir("", "untar")
+	require.NoError(t, err)
+	require.NoError(t, untar(&buf, dir))
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a", "0", "out.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(b))
+
+	// archives can't write outside of the directory.
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, tw.Close())
+	require.Error(t, untar(&buf, dir))
+}
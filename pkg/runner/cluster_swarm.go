@@ -27,7 +27,8 @@ import (
 )
 
 var (
-	_ api.Runner = &ClusterSwarmRunner{}
+	_ api.Runner  = &ClusterSwarmRunner{}
+	_ api.Capable = &ClusterSwarmRunner{}
 )
 
 // ClusterSwarmRunnerConfig is the configuration object of this runner. Boolean
@@ -201,6 +202,7 @@ func (*ClusterSwarmRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc
 
 		// Serialize the runenv into env variables to pass to docker.
 		env := conv.ToOptionsSlice(runenv.ToEnvVars())
+		env = append(env, conv.ToOptionsSlice(g.NetworkProfileEnv())...)
 
 		// Set the log level if provided in cfg.
 		if cfg.LogLevel != "" {
@@ -388,6 +390,11 @@ func (*ClusterSwarmRunner) CompatibleBuilders() []string {
 	return []string{"docker:go"}
 }
 
+// Capabilities reports that the runner runs docker images.
+func (*ClusterSwarmRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{Artifacts: []api.ArtifactKind{api.ArtifactDockerImage}}
+}
+
 func retry(attempts int, sleep time.Duration, f func() error) (err error) {
 	for i := 0; ; i++ {
 		err = f()
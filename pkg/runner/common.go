@@ -10,6 +10,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/rpc"
@@ -37,6 +38,43 @@ func nextDataNetwork(lenNetworks int) (*net.IPNet, string, error) {
 	return subnet, gw, err
 }
 
+// dataNetworkV6 returns the IPv6 subnet, and its gateway, of a dual-stack data
+// network with the IPv4 subnet of nextDataNetwork, a.b.0.0/16: the unique local
+// subnet fd00:a:b::/64.
+func dataNetworkV6(subnet *net.IPNet) (*net.IPNet, string, error) {
+	ip := subnet.IP.To4()
+	if ip == nil {
+		return nil, "", fmt.Errorf("not an IPv4 subnet: %s", subnet)
+	}
+	sn := fmt.Sprintf("fd00:%d:%d::/64", ip[0], ip[1])
+	gw := fmt.Sprintf("fd00:%d:%d::1", ip[0], ip[1])
+
+	_, subnet6, err := net.ParseCIDR(sn)
+	return subnet6, gw, err
+}
+
+// subnetV6Env returns the variable of the environment of the instances with
+// the IPv6 subnet of the data network, if it's dual-stack.
+func subnetV6Env(subnet *net.IPNet) map[string]string {
+	if subnet == nil {
+		return nil
+	}
+	return map[string]string{api.SDKEnvTestSubnetV6: subnet.String()}
+}
+
+// uniqueRunIDs returns the run IDs sorted, without duplicates nor empty ones,
+// as listed from the labels of the resources of runs.
+func uniqueRunIDs(ids []string) []string {
+	sort.Strings(ids)
+	unique := ids[:0]
+	for i, id := range ids {
+		if id != "" && (i == 0 || id != ids[i-1]) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
 func gzipRunOutputs(ctx context.Context, basedir string, input *api.CollectionInput, ow *rpc.OutputWriter) error {
 	pattern := filepath.Join(basedir, "*", input.RunID)
 
@@ -113,9 +151,18 @@ func gzipRunOutputs(ctx context.Context, basedir string, input *api.CollectionIn
 	return nil
 }
 
+// groupGPUs returns the number of GPUs of each instance of the group: those
+// its resources request, or one if the test case requires GPUs.
+func groupGPUs(g *api.RunGroup, reqs api.Requirements) int {
+	if g.Resources.GPUs == 0 && reqs.GPUs {
+		return 1
+	}
+	return g.Resources.GPUs
+}
+
 func reviewResources(group *api.RunGroup, ow *rpc.OutputWriter) {
 	log := ow.With("group_id", group.ID)
-	if group.Resources.CPU != "" || group.Resources.Memory != "" {
+	if !group.Resources.IsZero() {
 		log.Warnw("group has resources set. note that resources requirement and limits are ignored on the this runner.")
 	}
 }
@@ -1,6 +1,9 @@
 package runner
 
 import (
+	"sort"
+
+	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/task"
 )
 
@@ -8,6 +11,30 @@ type Result struct {
 	Outcome  task.Outcome             `json:"outcome"`
 	Outcomes map[string]*GroupOutcome `json:"outcomes"`
 	Journal  *Journal                 `json:"journal"`
+
+	// Cases holds the outcome of every test case, in order, when the run is a
+	// suite. The group outcomes of a suite are then keyed by <case>/<group>.
+	Cases []*CaseOutcome `json:"cases,omitempty"`
+
+	// Attempts are the previous attempts of the run, that failed and were
+	// retried according to the retries policy of the composition.
+	Attempts []task.Attempt `json:"attempts,omitempty"`
+
+	// Outputs is the URL of the archive of the outputs of the run, when the
+	// engine uploaded them to an object store.
+	Outputs string `json:"outputs,omitempty"`
+
+	// Provenance is the provenance of the artifact of every group, by group
+	// ID, as far as the engine recorded it.
+	Provenance map[string]*api.Provenance `json:"provenance,omitempty"`
+}
+
+// CaseOutcome is the outcome of a test case of a suite.
+type CaseOutcome struct {
+	Case    string       `json:"case"`
+	RunID   string       `json:"run_id" mapstructure:"run_id"`
+	Outcome task.Outcome `json:"outcome"`
+	Outputs string       `json:"outputs,omitempty"`
 }
 
 func newResult() *Result {
@@ -20,3 +47,83 @@ func newResult() *Result {
 		},
 	}
 }
+
+// NewSuiteResult returns an empty result, into which the results of the test
+// cases of a suite are aggregated with AddCase.
+func NewSuiteResult() *Result {
+	return newResult()
+}
+
+// AddCase aggregates the result of a test case of a suite, run as runID; res
+// may be nil if the runner failed to produce a result. The outcome of the
+// suite is the outcome of its first case that didn't pass, if any, or else
+// of its first case that passed with exceptions, if any.
+func (r *Result) AddCase(tcase, runID string, res *Result) {
+	outcome, outputs := task.OutcomeUnknown, ""
+	if res != nil {
+		outcome, outputs = res.Outcome, res.Outputs
+		for g, o := range res.Outcomes {
+			r.Outcomes[tcase+"/"+g] = o
+		}
+		if res.Journal != nil {
+			for k, v := range res.Journal.Events {
+				r.Journal.Events[k] = v
+			}
+			for k, v := range res.Journal.PodsStatuses {
+				r.Journal.PodsStatuses[k] = v
+			}
+		}
+	}
+
+	r.Cases = append(r.Cases, &CaseOutcome{Case: tcase, RunID: runID, Outcome: outcome, Outputs: outputs})
+	if len(r.Cases) == 1 || r.Outcome == task.OutcomeSuccess || (r.Outcome.Passed() && !outcome.Passed()) {
+		r.Outcome = outcome
+	}
+}
+
+// InstanceFailed records that an instance of the group, by index, failed.
+func (r *Result) InstanceFailed(group string, idx int) {
+	o, ok := r.Outcomes[group]
+	if !ok {
+		return
+	}
+	i := sort.SearchInts(o.Failed, idx)
+	if i < len(o.Failed) && o.Failed[i] == idx {
+		return
+	}
+	o.Failed = append(o.Failed, 0)
+	copy(o.Failed[i+1:], o.Failed[i:])
+	o.Failed[i] = idx
+}
+
+// TolerateFailures marks the failed run as successful with exceptions, if no
+// more than allowed instances failed to succeed, and none of the groups
+// timed out. It returns whether it did.
+func (r *Result) TolerateFailures(allowed int) bool {
+	if r.Outcome != task.OutcomeFailure || allowed <= 0 || len(r.Outcomes) == 0 {
+		return false
+	}
+	failed := 0
+	for _, o := range r.Outcomes {
+		if o.TimedOut {
+			return false
+		}
+		failed += o.Total - o.Ok
+	}
+	if failed > allowed {
+		return false
+	}
+	r.Outcome = task.OutcomeSuccessWithExceptions
+	return true
+}
+
+// TimeOut marks the result of a run that timed out, along with the given
+// groups, whose instances were stopped before they all succeeded.
+func (r *Result) TimeOut(groups ...string) {
+	r.Outcome = task.OutcomeTimeout
+	for _, g := range groups {
+		if o, ok := r.Outcomes[g]; ok {
+			o.TimedOut = true
+		}
+	}
+}
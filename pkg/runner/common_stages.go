@@ -0,0 +1,301 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
+)
+
+// groupStarter holds back the start of the groups of a run until the
+// conditions of their StartAfter are met, and that of their instances until
+// their batch is due, if the run is staggered. Runners call Wait before
+// starting the instances of a group, Admit before starting each instance,
+// and Started once they've started it.
+type groupStarter struct {
+	client  ss.Client
+	rp      *runtime.RunParams
+	groups  map[string]*api.RunGroup
+	stagger *api.Stagger
+
+	lk       sync.Mutex
+	pending  map[string]int
+	started  map[string]chan struct{}
+	waits    map[string]*groupWait
+	admitted int
+	batches  map[int]*groupWait
+}
+
+// groupWait memoizes the wait of a group, or of a batch, so that its
+// instances wait once.
+type groupWait struct {
+	once sync.Once
+	err  error
+}
+
+// newGroupStarter returns a groupStarter for the groups of a run, started in
+// the batches of stagger, if not nil; client is only used when a group, or
+// the batches, wait on a state, and may be nil otherwise.
+func newGroupStarter(client ss.Client, rp *runtime.RunParams, groups []*api.RunGroup, stagger *api.Stagger) *groupStarter {
+	s := &groupStarter{
+		client:  client,
+		rp:      rp,
+		groups:  make(map[string]*api.RunGroup, len(groups)),
+		stagger: stagger,
+		pending: make(map[string]int, len(groups)),
+		started: make(map[string]chan struct{}, len(groups)),
+		waits:   make(map[string]*groupWait, len(groups)),
+		batches: make(map[int]*groupWait),
+	}
+	for _, g := range groups {
+		s.groups[g.ID] = g
+		s.pending[g.ID] = g.Instances
+		s.started[g.ID] = make(chan struct{})
+		s.waits[g.ID] = &groupWait{}
+		if g.Instances == 0 {
+			close(s.started[g.ID])
+		}
+	}
+	return s
+}
+
+// waitsOnState returns whether any of the groups, or the batches of the
+// run, wait on a sync service state before starting, and thus need a sync
+// client.
+func waitsOnState(input *api.RunInput) bool {
+	if input.Stagger != nil && input.Stagger.State != "" {
+		return true
+	}
+	for _, g := range input.Groups {
+		if g.StartAfter != nil && g.StartAfter.State != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait blocks until the group with the given ID can start, or the context
+// is done. It is safe to call it for every instance of the group; they all
+// share the outcome of the first call.
+func (s *groupStarter) Wait(ctx context.Context, ow *rpc.OutputWriter, id string) error {
+	g := s.groups[id]
+	if g == nil || g.StartAfter == nil {
+		return nil
+	}
+
+	w := s.waits[id]
+	w.once.Do(func() {
+		w.err = s.wait(ctx, ow, g)
+	})
+	return w.err
+}
+
+func (s *groupStarter) wait(ctx context.Context, ow *rpc.OutputWriter, g *api.RunGroup) error {
+	id, after := g.ID, g.StartAfter
+	if after.Group != "" {
+		ow.Infow("waiting for group to start", "group", id, "start_after", after.Group)
+		select {
+		case <-s.started[after.Group]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if after.State != "" {
+		target := after.Target
+		if target == 0 {
+			target = s.groups[after.Group].Instances
+		}
+		if s.client == nil {
+			return fmt.Errorf("group %s waits on state %s, but no sync client is available", id, after.State)
+		}
+
+		ow.Infow("waiting for state", "group", id, "state", after.State, "target", target)
+		b, err := s.client.Barrier(ss.WithRunParams(ctx, s.rp), ss.State(after.State), target)
+		if err != nil {
+			return fmt.Errorf("failed to wait for state %s: %w", after.State, err)
+		}
+		select {
+		case err := <-b.C:
+			if err != nil {
+				return fmt.Errorf("failed to wait for state %s: %w", after.State, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if d := after.DelayDuration(); d > 0 {
+		ow.Infow("delaying group start", "group", id, "delay", d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Admit blocks until the batch of the next instance to start is due, or the
+// context is done. Instances fall in batches in the order they're admitted;
+// those of the first batch start right away, and those of every next one
+// once the interval has elapsed since the previous batch was due, and the
+// instances of the previous batches have signalled the state, if any.
+func (s *groupStarter) Admit(ctx context.Context, ow *rpc.OutputWriter) error {
+	if s.stagger == nil {
+		return nil
+	}
+
+	s.lk.Lock()
+	b := s.admitted / s.stagger.Batch
+	s.admitted++
+	s.lk.Unlock()
+
+	return s.batch(ctx, ow, b)
+}
+
+// batch waits until the batch b is due, along with the batches before it.
+func (s *groupStarter) batch(ctx context.Context, ow *rpc.OutputWriter, b int) error {
+	if b == 0 {
+		return nil
+	}
+
+	s.lk.Lock()
+	w, ok := s.batches[b]
+	if !ok {
+		w = &groupWait{}
+		s.batches[b] = w
+	}
+	s.lk.Unlock()
+
+	w.once.Do(func() {
+		if w.err = s.batch(ctx, ow, b-1); w.err != nil {
+			return
+		}
+
+		if d := s.stagger.IntervalDuration(); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				w.err = ctx.Err()
+				return
+			}
+		}
+
+		if state := s.stagger.State; state != "" {
+			if s.client == nil {
+				w.err = fmt.Errorf("the batches wait on state %s, but no sync client is available", state)
+				return
+			}
+			target := b * s.stagger.Batch
+			ow.Infow("waiting for state before starting the next batch", "batch", b, "state", state, "target", target)
+			bar, err := s.client.Barrier(ss.WithRunParams(ctx, s.rp), ss.State(state), target)
+			if err == nil {
+				select {
+				case err = <-bar.C:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			}
+			if err != nil {
+				w.err = fmt.Errorf("failed to wait for state %s: %w", state, err)
+				return
+			}
+		}
+
+		ow.Infow("starting batch of instances", "batch", b, "from", b*s.stagger.Batch)
+	})
+	return w.err
+}
+
+// Started records that an instance of the group with the given ID has
+// started. The group has started once all its instances have.
+func (s *groupStarter) Started(id string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if n, ok := s.pending[id]; ok && n > 0 {
+		if s.pending[id] = n - 1; n == 1 {
+			close(s.started[id])
+		}
+	}
+}
+
+// startOrder returns the groups ordered such that every group comes after
+// the group it starts after, preserving the composition order otherwise.
+// Runners starting groups one after the other use it to avoid waiting on a
+// group they haven't started yet. Groups are expected to have been validated
+// against cycles.
+func startOrder(groups []*api.RunGroup) []*api.RunGroup {
+	var (
+		ordered = make([]*api.RunGroup, 0, len(groups))
+		placed  = make(map[string]bool, len(groups))
+	)
+	for len(ordered) < len(groups) {
+		n := len(ordered)
+		for _, g := range groups {
+			if placed[g.ID] {
+				continue
+			}
+			if g.StartAfter == nil || g.StartAfter.Group == "" || placed[g.StartAfter.Group] {
+				ordered = append(ordered, g)
+				placed[g.ID] = true
+			}
+		}
+		if len(ordered) == n {
+			// a cycle; keep the remaining groups in composition order.
+			for _, g := range groups {
+				if !placed[g.ID] {
+					ordered = append(ordered, g)
+					placed[g.ID] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// groupContexts derives a context from ctx for every group, which is done
+// once the timeout of the group, if any, elapses. Runners start and wait for
+// the instances of a group with its context; onTimeout, if not nil, is
+// called for every group that times out, to stop its instances. The returned
+// function releases the contexts.
+func groupContexts(ctx context.Context, ow *rpc.OutputWriter, groups []*api.RunGroup, onTimeout func(g *api.RunGroup)) (map[string]context.Context, context.CancelFunc) {
+	var (
+		ctxs    = make(map[string]context.Context, len(groups))
+		cancels = make([]context.CancelFunc, 0, len(groups))
+	)
+	for _, g := range groups {
+		ctxs[g.ID] = ctx
+		if g.Timeout == 0 {
+			continue
+		}
+
+		gctx, cancel := context.WithTimeout(ctx, g.Timeout)
+		ctxs[g.ID] = gctx
+		cancels = append(cancels, cancel)
+
+		go func(g *api.RunGroup) {
+			<-gctx.Done()
+			if ctx.Err() != nil || gctx.Err() != context.DeadlineExceeded {
+				return // the run is over.
+			}
+			ow.Warnw("group timed out; stopping its instances", "group", g.ID, "timeout", g.Timeout)
+			if onTimeout != nil {
+				onTimeout(g)
+			}
+		}(g)
+	}
+	return ctxs, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/testground/testground/pkg/logging"
+
+	ss "github.com/testground/sdk-go/sync"
+)
+
+const (
+	// runSyncImage and runSyncRedisImage are the images of the sync service,
+	// and of its redis, of the runs that are given their own.
+	runSyncImage      = "iptestground/sync-service:latest"
+	runSyncRedisImage = "library/redis"
+
+	// runSyncPort is the port of the sync service.
+	runSyncPort = "5050"
+
+	// runSyncTimeout is how long runners wait for the sync service of a run
+	// to accept connections.
+	runSyncTimeout = 30 * time.Second
+)
+
+// runSyncRedisArgs are the arguments of the redis of the sync service of a
+// run; a throwaway store, like the shared one.
+var runSyncRedisArgs = []string{"--save", "", "--appendonly", "no", "--maxclients", "120000", "--stop-writes-on-bgsave-error", "no"}
+
+// syncEnvLk serializes the connections of sync clients, since the sdk reads
+// the address of the sync service from the environment of the daemon.
+var syncEnvLk sync.Mutex
+
+// newSyncClient connects a generic sync client to the sync service at
+// host:port, restoring the environment afterwards.
+func newSyncClient(ctx context.Context, host, port string) (*ss.DefaultClient, error) {
+	syncEnvLk.Lock()
+	defer syncEnvLk.Unlock()
+
+	for k, v := range map[string]string{ss.EnvServiceHost: host, ss.EnvServicePort: withDefault(port, runSyncPort)} {
+		prev, ok := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			return nil, err
+		}
+		if ok {
+			defer os.Setenv(k, prev)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	return ss.NewGenericClient(ctx, logging.S())
+}
+
+// newDaemonSyncClient connects a generic sync client to the sync service that
+// the environment of the daemon points to, while no other client is connecting
+// to that of a run.
+func newDaemonSyncClient(ctx context.Context) (*ss.DefaultClient, error) {
+	syncEnvLk.Lock()
+	defer syncEnvLk.Unlock()
+
+	return ss.NewGenericClient(ctx, logging.S())
+}
+
+// dialRunSync connects a generic sync client to the sync service that a run
+// was given, retrying until it accepts connections. The client outlives ctx;
+// callers close it.
+func dialRunSync(ctx context.Context, host, port string) (*ss.DefaultClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, runSyncTimeout)
+	defer cancel()
+
+	for {
+		c, err := newSyncClient(context.Background(), host, port)
+		if err == nil {
+			return c, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("sync service of the run at %s:%s didn't come up: %w", host, port, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
@@ -1,7 +1,19 @@
 package runner
 
 import (
+	"context"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+	"github.com/testground/testground/pkg/task"
+
+	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestNextDataNetwork(t *testing.T) {
@@ -33,3 +45,232 @@ func TestNextDataNetwork(t *testing.T) {
 		}
 	}
 }
+
+func TestDataNetworkV6(t *testing.T) {
+	for lenNetworks, want := range map[int][2]string{
+		0:    {"fd00:16::/64", "fd00:16:0::1"},
+		257:  {"fd00:17:1::/64", "fd00:17:1::1"},
+		4095: {"fd00:31:255::/64", "fd00:31:255::1"},
+	} {
+		subnet, _, err := nextDataNetwork(lenNetworks)
+		require.NoError(t, err)
+		subnet6, gateway6, err := dataNetworkV6(subnet)
+		require.NoError(t, err)
+		require.Equal(t, want[0], subnet6.String())
+		require.Equal(t, want[1], gateway6)
+		require.Equal(t, map[string]string{"TEST_SUBNET_V6": want[0]}, subnetV6Env(subnet6))
+	}
+	require.Empty(t, subnetV6Env(nil))
+}
+
+func TestUniqueRunIDs(t *testing.T) {
+	ids := uniqueRunIDs([]string{"c2", "", "c1", "c2-1", "c2", "c1"})
+	require.Equal(t, []string{"c1", "c2", "c2-1"}, ids)
+	require.Empty(t, uniqueRunIDs(nil))
+}
+
+func TestSuiteResultAddCase(t *testing.T) {
+	r := NewSuiteResult()
+
+	ok := newResult()
+	ok.Outcome = task.OutcomeSuccess
+	ok.Outcomes["single"] = &GroupOutcome{Ok: 1, Total: 1}
+	r.AddCase("first", "run-1", ok)
+	require.Equal(t, task.OutcomeSuccess, r.Outcome)
+
+	failed := newResult()
+	failed.Outcome = task.OutcomeFailure
+	failed.Outcomes["single"] = &GroupOutcome{Ok: 0, Total: 1}
+	r.AddCase("second", "run-2", failed)
+	r.AddCase("third", "run-3", ok)
+
+	require.Equal(t, task.OutcomeFailure, r.Outcome)
+	require.Len(t, r.Cases, 3)
+	require.Equal(t, "run-2", r.Cases[1].RunID)
+	require.Equal(t, task.OutcomeFailure, r.Cases[1].Outcome)
+	require.Equal(t, 1, r.Outcomes["first/single"].Ok)
+	require.Equal(t, 0, r.Outcomes["second/single"].Ok)
+}
+
+func TestDockerResources(t *testing.T) {
+	res, storageOpt, err := dockerResources(api.Resources{})
+	require.NoError(t, err)
+	require.Zero(t, res.NanoCPUs)
+	require.Zero(t, res.Memory)
+	require.Nil(t, storageOpt)
+
+	res, storageOpt, err = dockerResources(api.Resources{CPU: "500m", Memory: "1Gi", Disk: "10G"})
+	require.NoError(t, err)
+	require.EqualValues(t, 500000000, res.NanoCPUs)
+	require.EqualValues(t, 1<<30, res.Memory)
+	require.Equal(t, map[string]string{"size": "10000000000"}, storageOpt)
+	require.Empty(t, res.DeviceRequests)
+
+	res, _, err = dockerResources(api.Resources{GPUs: 2})
+	require.NoError(t, err)
+	require.Len(t, res.DeviceRequests, 1)
+	require.Equal(t, 2, res.DeviceRequests[0].Count)
+	require.Equal(t, [][]string{{"gpu"}}, res.DeviceRequests[0].Capabilities)
+}
+
+func TestGroupStarter(t *testing.T) {
+	groups := []*api.RunGroup{
+		{ID: "clients", Instances: 2, StartAfter: &api.StartAfter{Group: "bootstrap", Delay: "10ms"}},
+		{ID: "bootstrap", Instances: 1},
+		{ID: "late", Instances: 1, StartAfter: &api.StartAfter{Group: "clients"}},
+	}
+
+	var order []string
+	for _, g := range startOrder(groups) {
+		order = append(order, g.ID)
+	}
+	require.Equal(t, []string{"bootstrap", "clients", "late"}, order)
+
+	s := newGroupStarter(nil, &runtime.RunParams{}, groups, nil)
+	ow := rpc.Discard()
+	ctx := context.Background()
+
+	require.NoError(t, s.Wait(ctx, ow, "bootstrap"))
+
+	waited := make(chan error, 1)
+	go func() { waited <- s.Wait(ctx, ow, "clients") }()
+
+	select {
+	case <-waited:
+		t.Fatal("clients started before bootstrap")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Started("bootstrap")
+	require.NoError(t, <-waited)
+
+	// late waits for both clients.
+	s.Started("clients")
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.Error(t, s.Wait(ctx, ow, "late"))
+}
+
+func TestGroupStarterStagger(t *testing.T) {
+	groups := []*api.RunGroup{{ID: "a", Instances: 5}}
+	s := newGroupStarter(nil, &runtime.RunParams{}, groups, &api.Stagger{Batch: 2, Interval: "40ms"})
+	ow := rpc.Discard()
+	ctx := context.Background()
+
+	// instances start two at a time, 40ms apart.
+	start := time.Now()
+	var took []time.Duration
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Admit(ctx, ow))
+		took = append(took, time.Since(start))
+	}
+	require.Less(t, int64(took[1]), int64(40*time.Millisecond))
+	require.GreaterOrEqual(t, int64(took[2]), int64(40*time.Millisecond))
+	require.Less(t, int64(took[3]), int64(80*time.Millisecond))
+	require.GreaterOrEqual(t, int64(took[4]), int64(80*time.Millisecond))
+
+	// batches that wait on a state need a sync client.
+	s = newGroupStarter(nil, &runtime.RunParams{}, groups, &api.Stagger{Batch: 1, State: "ready"})
+	require.NoError(t, s.Admit(ctx, ow))
+	require.Error(t, s.Admit(ctx, ow))
+
+	// runs that aren't staggered start right away.
+	s = newGroupStarter(nil, &runtime.RunParams{}, groups, nil)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Admit(ctx, ow))
+	}
+}
+
+func TestGroupContexts(t *testing.T) {
+	groups := []*api.RunGroup{
+		{ID: "a", Instances: 1, Timeout: 10 * time.Millisecond},
+		{ID: "b", Instances: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timedOut := make(chan string, len(groups))
+	ctxs, release := groupContexts(ctx, rpc.Discard(), groups, func(g *api.RunGroup) {
+		timedOut <- g.ID
+	})
+	defer release()
+
+	require.Equal(t, "a", <-timedOut)
+	require.ErrorIs(t, ctxs["a"].Err(), context.DeadlineExceeded)
+	require.NoError(t, ctxs["b"].Err())
+
+	// contexts are done once the run is, without timing out.
+	cancel()
+	require.Error(t, ctxs["b"].Err())
+	select {
+	case id := <-timedOut:
+		t.Fatalf("group %s timed out after the run was over", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestResultTimeOut(t *testing.T) {
+	res := newResult()
+	res.Outcomes["a"] = &GroupOutcome{Ok: 1, Total: 2}
+	res.Outcomes["b"] = &GroupOutcome{Ok: 2, Total: 2}
+
+	res.TimeOut("a")
+	require.Equal(t, task.OutcomeTimeout, res.Outcome)
+	require.True(t, res.Outcomes["a"].TimedOut)
+	require.False(t, res.Outcomes["b"].TimedOut)
+	require.Equal(t, "1/2 (timed out)", res.Outcomes["a"].String())
+}
+
+func TestResultTolerateFailures(t *testing.T) {
+	res := newResult()
+	res.Outcome = task.OutcomeFailure
+	res.Outcomes["a"] = &GroupOutcome{Ok: 8, Total: 10}
+	res.Outcomes["b"] = &GroupOutcome{Ok: 9, Total: 10}
+	res.InstanceFailed("a", 7)
+	res.InstanceFailed("a", 2)
+	res.InstanceFailed("a", 7)
+	res.InstanceFailed("b", 4)
+	res.InstanceFailed("unknown", 0)
+	require.Equal(t, []int{2, 7}, res.Outcomes["a"].Failed)
+	require.Equal(t, "8/10 (failed: 2 7)", res.Outcomes["a"].String())
+
+	require.False(t, res.TolerateFailures(0))
+	require.False(t, res.TolerateFailures(2))
+	require.Equal(t, task.OutcomeFailure, res.Outcome)
+	require.True(t, res.TolerateFailures(3))
+	require.Equal(t, task.OutcomeSuccessWithExceptions, res.Outcome)
+	require.True(t, res.Outcome.Passed())
+
+	// groups that timed out aren't tolerated.
+	res.Outcome = task.OutcomeFailure
+	res.Outcomes["b"].TimedOut = true
+	require.False(t, res.TolerateFailures(3))
+
+	// a suite passes with exceptions, unless a case fails.
+	r := NewSuiteResult()
+	ok := newResult()
+	ok.Outcome = task.OutcomeSuccess
+	exc := newResult()
+	exc.Outcome = task.OutcomeSuccessWithExceptions
+	r.AddCase("first", "run-1", ok)
+	r.AddCase("second", "run-2", exc)
+	r.AddCase("third", "run-3", ok)
+	require.Equal(t, task.OutcomeSuccessWithExceptions, r.Outcome)
+	failed := newResult()
+	failed.Outcome = task.OutcomeFailure
+	r.AddCase("fourth", "run-4", failed)
+	require.Equal(t, task.OutcomeFailure, r.Outcome)
+}
+
+func TestNewSyncClientRestoresEnv(t *testing.T) {
+	require.NoError(t, os.Setenv(ss.EnvServiceHost, "testground-sync-service"))
+	defer os.Unsetenv(ss.EnvServiceHost)
+
+	// nothing listens on the port; the environment is restored nonetheless.
+	_, err := newSyncClient(context.Background(), "127.0.0.1", "1")
+	require.Error(t, err)
+	require.Equal(t, "testground-sync-service", os.Getenv(ss.EnvServiceHost))
+	_, ok := os.LookupEnv(ss.EnvServicePort)
+	require.False(t, ok)
+}
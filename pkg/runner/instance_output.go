@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// Streams of the output of instances.
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// instanceFollower streams the output of an instance live to the client,
+// line by line, when the run follows the instances; see
+// api.RunInput#FollowInstances.
+type instanceFollower struct {
+	ow       *rpc.OutputWriter
+	groupID  string
+	instance int
+
+	// seq numbers the lines over the streams of the instance; guarded by
+	// atomic.
+	seq uint64
+}
+
+func newInstanceFollower(ow *rpc.OutputWriter, groupID string, instance int) *instanceFollower {
+	return &instanceFollower{ow: ow, groupID: groupID, instance: instance}
+}
+
+// writer returns a writer of a stream of the instance, which sends the lines
+// written to it. The last line, if unterminated, is sent once it's closed.
+func (f *instanceFollower) writer(stream string) io.WriteCloser {
+	return &lineWriter{f: f, stream: stream}
+}
+
+// lineWriter sends the lines written to it; it's not safe for concurrent
+// use, like the streams it's written by.
+type lineWriter struct {
+	f      *instanceFollower
+	stream string
+	buf    []byte
+}
+
+var _ io.WriteCloser = (*lineWriter)(nil)
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	lines := w.buf
+	for {
+		i := bytes.IndexByte(lines, '\n')
+		if i < 0 {
+			break
+		}
+		w.send(lines[:i])
+		lines = lines[i+1:]
+	}
+	// keep the unterminated line.
+	w.buf = append(w.buf[:0], lines...)
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.send(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *lineWriter) send(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	_ = w.f.ow.WriteInstanceOutput(&rpc.InstanceOutput{
+		GroupID:  w.f.groupID,
+		Instance: w.f.instance,
+		Seq:      atomic.AddUint64(&w.f.seq, 1),
+		Stream:   w.stream,
+		Line:     string(line),
+	})
+}
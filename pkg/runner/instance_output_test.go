@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceFollower(t *testing.T) {
+	var buf bytes.Buffer
+	f := newInstanceFollower(rpc.NewFileOutputWriter(&buf), "miner", 3)
+
+	stdout, stderr := f.writer(streamStdout), f.writer(streamStderr)
+	_, _ = stdout.Write([]byte("hello\nwor"))
+	_, _ = stderr.Write([]byte("oops\r\n"))
+	_, _ = stdout.Write([]byte("ld\n"))
+	_, _ = stdout.Write([]byte("bye"))
+	require.NoError(t, stdout.Close())
+	require.NoError(t, stderr.Close())
+
+	var outs []rpc.InstanceOutput
+	for dec := json.NewDecoder(&buf); ; {
+		var chunk struct {
+			Type    rpc.ChunkType `json:"t"`
+			Payload []byte        `json:"p"`
+		}
+		err := dec.Decode(&chunk)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, rpc.ChunkTypeInstance, chunk.Type)
+
+		var o rpc.InstanceOutput
+		require.NoError(t, json.Unmarshal(chunk.Payload, &o))
+		outs = append(outs, o)
+	}
+
+	require.Equal(t, []rpc.InstanceOutput{
+		{GroupID: "miner", Instance: 3, Seq: 1, Stream: streamStdout, Line: "hello"},
+		{GroupID: "miner", Instance: 3, Seq: 2, Stream: streamStderr, Line: "oops"},
+		{GroupID: "miner", Instance: 3, Seq: 3, Stream: streamStdout, Line: "world"},
+		{GroupID: "miner", Instance: 3, Seq: 4, Stream: streamStdout, Line: "bye"},
+	}, outs)
+	require.Equal(t, "miner[003] #4 | bye", outs[3].String())
+}
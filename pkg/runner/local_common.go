@@ -15,7 +15,49 @@ import (
 	"github.com/docker/go-connections/nat"
 )
 
-func localCommonHealthcheck(ctx context.Context, hh *healthcheck.Helper, cli *client.Client, ow *rpc.OutputWriter, controlNetworkID string, workdir string) {
+// containerEngine is an engine serving the Docker API, which the local runners
+// run their containers with.
+type containerEngine struct {
+	// runner is the ID of the runner driving the engine.
+	runner string
+	// daemon names the healthcheck of the engine.
+	daemon string
+	// cli is the command line tool of the engine, for hints.
+	cli string
+	// outputs is the directory of the outputs of the runner, in the outputs
+	// directory of testground.
+	outputs string
+	// maxFiles is the nofile ulimit of the infrastructure containers, which
+	// inherit the limit of the engine if it's zero.
+	maxFiles int64
+	// defaults is the configuration that runs are merged with.
+	defaults LocalDockerRunnerConfig
+	// connect returns a client of the engine.
+	connect func() (*client.Client, error)
+}
+
+// dockerEngine is the docker daemon, as configured by the environment.
+var dockerEngine = &containerEngine{
+	runner:   "local:docker",
+	daemon:   "docker-daemon",
+	cli:      "docker",
+	outputs:  "local_docker",
+	maxFiles: InfraMaxFilesUlimit,
+	defaults: defaultConfig,
+	connect: func() (*client.Client, error) {
+		return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	},
+}
+
+// ulimits returns the ulimits of the infrastructure containers.
+func (e *containerEngine) ulimits() []*units.Ulimit {
+	if e.maxFiles == 0 {
+		return nil
+	}
+	return []*units.Ulimit{{Name: "nofile", Hard: e.maxFiles, Soft: e.maxFiles}}
+}
+
+func localCommonHealthcheck(ctx context.Context, hh *healthcheck.Helper, cli *client.Client, ow *rpc.OutputWriter, ce *containerEngine, controlNetworkID string, workdir string) {
 	hh.Enlist("local-outputs-dir",
 		healthcheck.CheckDirectoryExists(workdir),
 		healthcheck.CreateDirectory(workdir),
@@ -59,9 +101,7 @@ func localCommonHealthcheck(ctx context.Context, hh *healthcheck.Helper, cli *cl
 				PortBindings: exposed,
 				NetworkMode:  container.NetworkMode(controlNetworkID),
 				Resources: container.Resources{
-					Ulimits: []*units.Ulimit{
-						{Name: "nofile", Hard: InfraMaxFilesUlimit, Soft: InfraMaxFilesUlimit},
-					},
+					Ulimits: ce.ulimits(),
 				},
 				Sysctls: map[string]string{
 					"net.core.somaxconn": "150000",
@@ -89,9 +129,7 @@ func localCommonHealthcheck(ctx context.Context, hh *healthcheck.Helper, cli *cl
 				PortBindings: exposed,
 				NetworkMode:  container.NetworkMode(controlNetworkID),
 				Resources: container.Resources{
-					Ulimits: []*units.Ulimit{
-						{Name: "nofile", Hard: InfraMaxFilesUlimit, Soft: InfraMaxFilesUlimit},
-					},
+					Ulimits: ce.ulimits(),
 				},
 				Sysctls: map[string]string{
 					"net.core.somaxconn": "150000",
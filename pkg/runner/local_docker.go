@@ -14,10 +14,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/testground/testground/pkg/logging"
-
 	"github.com/docker/go-connections/nat"
-	"github.com/docker/go-units"
 	"github.com/testground/sdk-go/ptypes"
 
 	"github.com/testground/sdk-go/runtime"
@@ -39,6 +36,7 @@ import (
 
 	"github.com/imdario/mergo"
 	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/api/resource"
 
 	ss "github.com/testground/sdk-go/sync"
 )
@@ -49,6 +47,8 @@ var (
 	_ api.Runner        = (*LocalDockerRunner)(nil)
 	_ api.Healthchecker = (*LocalDockerRunner)(nil)
 	_ api.Terminatable  = (*LocalDockerRunner)(nil)
+	_ api.Capable       = (*LocalDockerRunner)(nil)
+	_ api.Cleaner       = (*LocalDockerRunner)(nil)
 )
 
 // LocalDockerRunnerConfig is the configuration object of this runner. Boolean
@@ -62,7 +62,7 @@ type LocalDockerRunnerConfig struct {
 	// Unstarted creates the containers without starting them (default: false).
 	Unstarted bool `toml:"no_start"`
 	// Background avoids tailing the output of containers, and displaying it as
-	// log messages (default: false).
+	// log messages, unless the run follows the instances (default: false).
 	Background bool `toml:"background"`
 	// Ulimits that should be applied on this run, in Docker format.
 	// See
@@ -71,6 +71,22 @@ type LocalDockerRunnerConfig struct {
 	Ulimits []string `toml:"ulimits"`
 
 	ExposedPorts ExposedPorts `toml:"exposed_ports"`
+
+	// Platform is the platform of the images pulled for the groups, when they
+	// were built for several platforms and pushed to a registry, e.g.
+	// linux/arm64; see api.RunPlatform (default: the platform of the docker
+	// daemon).
+	Platform string `toml:"platform"`
+
+	// RunSync gives each run its own redis and sync service, rather than the
+	// shared ones, and removes them once it's done, so that concurrent runs
+	// don't share a keyspace (default: false).
+	RunSync bool `toml:"run_sync"`
+
+	// IPv6 makes the data network dual-stack, giving it the IPv6 subnet of
+	// TEST_SUBNET_V6 next to the IPv4 one, to shape and test IPv6 traffic
+	// too (default: false).
+	IPv6 bool `toml:"ipv6"`
 }
 
 // defaultConfig is the default configuration. Incoming configurations will be
@@ -95,6 +111,9 @@ var defaultConfig = LocalDockerRunnerConfig{
 type LocalDockerRunner struct {
 	lk sync.RWMutex
 
+	// engine runs the containers; docker, unless set.
+	engine *containerEngine
+
 	controlNetworkID string
 	outputsDir       string
 
@@ -105,19 +124,27 @@ func (r *LocalDockerRunner) Healthcheck(ctx context.Context, engine api.Engine,
 	r.lk.Lock()
 	defer r.lk.Unlock()
 
+	ce := r.containers()
+
 	// Create a docker client.
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := ce.connect()
 	if err != nil {
 		return nil, err
 	}
 
-	r.outputsDir = filepath.Join(engine.EnvConfig().Dirs().Outputs(), "local_docker")
+	r.outputsDir = filepath.Join(engine.EnvConfig().Dirs().Outputs(), ce.outputs)
 	r.controlNetworkID = "testground-control"
 
 	hh := &healthcheck.Helper{}
 
+	// everything else depends on the docker daemon.
+	hh.Require(ce.daemon,
+		healthcheck.CheckDockerDaemon(ctx, cli),
+		healthcheck.RequiresManualFixing(),
+	)
+
 	// enlist healthchecks which are common between local:docker and local:exec
-	localCommonHealthcheck(ctx, hh, cli, ow, r.controlNetworkID, r.outputsDir)
+	localCommonHealthcheck(ctx, hh, cli, ow, ce, r.controlNetworkID, r.outputsDir)
 
 	dockerSock := "/var/run/docker.sock"
 	if host := cli.DaemonHost(); strings.HasPrefix(host, "unix://") {
@@ -153,9 +180,7 @@ func (r *LocalDockerRunner) Healthcheck(ctx context.Context, engine api.Engine,
 				Target: "/var/run/docker.sock",
 			}},
 			Resources: container.Resources{
-				Ulimits: []*units.Ulimit{
-					{Name: "nofile", Hard: InfraMaxFilesUlimit, Soft: InfraMaxFilesUlimit},
-				},
+				Ulimits: ce.ulimits(),
 			},
 			RestartPolicy: container.RestartPolicy{
 				Name: "unless-stopped",
@@ -182,21 +207,13 @@ func (r *LocalDockerRunner) setupSyncClient() error {
 		return nil
 	}
 
-	err := os.Setenv(ss.EnvServiceHost, "127.0.0.1")
-	if err != nil {
-		return err
-	}
-
-	r.syncClient, err = ss.NewGenericClient(context.Background(), logging.S())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	var err error
+	r.syncClient, err = newSyncClient(context.Background(), "127.0.0.1", "")
+	return err
 }
 
-func (r *LocalDockerRunner) collectOutcomes(ctx context.Context, result *Result, tpl *runtime.RunParams) (chan bool, error) {
-	eventsCh, err := r.syncClient.SubscribeEvents(ctx, tpl)
+func (r *LocalDockerRunner) collectOutcomes(ctx context.Context, client *ss.DefaultClient, result *Result, tpl *runtime.RunParams) (chan bool, error) {
+	eventsCh, err := client.SubscribeEvents(ctx, tpl)
 	if err != nil {
 		return nil, err
 	}
@@ -238,11 +255,13 @@ func (r *LocalDockerRunner) collectOutcomes(ctx context.Context, result *Result,
 }
 
 func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (runoutput *api.RunOutput, err error) {
-	log := ow.With("runner", "local:docker", "run_id", input.RunID)
+	ce := r.containers()
+	log := ow.With("runner", ce.runner, "run_id", input.RunID)
 
 	err = r.setupSyncClient()
 	if err != nil {
 		log.Error(err)
+		err = api.NewInfraError(fmt.Errorf("failed to connect to the sync service: %w", err))
 		return
 	}
 
@@ -264,11 +283,15 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 	}()
 
 	// Create a docker client.
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := ce.connect()
 	if err != nil {
 		return
 	}
 
+	if err = checkGPUSupport(ctx, cli, input); err != nil {
+		return
+	}
+
 	// Build a template runenv.
 	template := runtime.RunParams{
 		TestPlan:           input.TestPlan,
@@ -282,19 +305,38 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 		TestStartTime:      time.Now(),
 	}
 
+	// Merge the incoming configuration with the default configuration.
+	cfg := ce.defaults
+	if err = mergo.Merge(&cfg, input.RunnerConfig, mergo.WithOverride); err != nil {
+		err = fmt.Errorf("error while merging configurations: %w", err)
+		return
+	}
+
 	// Create a data network.
-	dataNetworkID, subnet, err := newDataNetwork(ctx, cli, ow, &template, "default")
+	dataNetworkID, subnet, subnetV6, err := newDataNetwork(ctx, cli, ow, &template, "default", cfg.IPv6)
 	if err != nil {
 		return
 	}
 
 	template.TestSubnet = &ptypes.IPNet{IPNet: *subnet}
 
-	// Merge the incoming configuration with the default configuration.
-	cfg := defaultConfig
-	if err = mergo.Merge(&cfg, input.RunnerConfig, mergo.WithOverride); err != nil {
-		err = fmt.Errorf("error while merging configurations: %w", err)
-		return
+	// The run may be given its own sync service.
+	syncClient := r.syncClient
+	syncEnv := []string{"REDIS_HOST=testground-redis"}
+	if cfg.RunSync {
+		var rs *runSync
+		if rs, err = startRunSync(ctx, ow, cli, ce, input.RunID, "testground-control"); err != nil {
+			err = api.NewInfraError(err)
+			return
+		}
+		defer rs.stop(cli, ow)
+
+		if syncClient, err = dialRunSync(ctx, "127.0.0.1", rs.port); err != nil {
+			err = api.NewInfraError(err)
+			return
+		}
+		defer syncClient.Close()
+		syncEnv = rs.env()
 	}
 
 	ports := make(nat.PortSet)
@@ -323,19 +365,53 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 			Total: g.Instances,
 		}
 
-		reviewResources(g, ow)
+		// Limit the resources of the containers of this group, if requested.
+		var (
+			resources  container.Resources
+			storageOpt map[string]string
+		)
+		gres := g.Resources
+		gres.GPUs = groupGPUs(g, input.Requirements)
+		resources, storageOpt, err = dockerResources(gres)
+		if err != nil {
+			err = fmt.Errorf("invalid resources for group %s: %w", g.ID, err)
+			break
+		}
+
+		// The log level and ulimits can be overridden per group.
+		gcfg := cfg
+		if g.RunnerConfig != nil {
+			gcfg = ce.defaults
+			if err = mergo.Merge(&gcfg, g.RunnerConfig, mergo.WithOverride); err != nil {
+				err = fmt.Errorf("error while merging configurations of group %s: %w", g.ID, err)
+				break
+			}
+		}
+
+		// Images built for several platforms are in a registry.
+		var platform string
+		if platform, err = api.RunPlatform(gcfg.Platform); err != nil {
+			break
+		}
+		if err = docker.PullImageIfMissing(ctx, ow, cli, g.ArtifactPath, platform); err != nil {
+			err = fmt.Errorf("failed to get the image of group %s: %w", g.ID, err)
+			break
+		}
 
 		// Serialize the runenv into env variables to pass to docker.
 		env := conv.ToOptionsSlice(runenv.ToEnvVars())
 		env = append(env, "INFLUXDB_URL=http://testground-influxdb:8086")
-		env = append(env, "REDIS_HOST=testground-redis")
+		env = append(env, syncEnv...)
 
 		// Inject exposed ports.
 		env = append(env, conv.ToOptionsSlice(cfg.ExposedPorts.ToEnvVars())...)
+		env = append(env, conv.ToOptionsSlice(g.NetworkProfileEnv())...)
+		env = append(env, conv.ToOptionsSlice(g.ChaosEnv())...)
+		env = append(env, conv.ToOptionsSlice(subnetV6Env(subnetV6))...)
 
 		// Set the log level if provided in cfg.
-		if cfg.LogLevel != "" {
-			env = append(env, "LOG_LEVEL="+cfg.LogLevel)
+		if gcfg.LogLevel != "" {
+			env = append(env, "LOG_LEVEL="+gcfg.LogLevel)
 		}
 
 		// Start as many containers as group instances.
@@ -377,6 +453,9 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 			hcfg := &container.HostConfig{
 				NetworkMode:     container.NetworkMode("testground-control"),
 				PublishAllPorts: true,
+				Resources:       resources,
+				StorageOpt:      storageOpt,
+				Privileged:      input.Requirements.Privileged,
 				Mounts: []mount.Mount{{
 					Type:   mount.TypeBind,
 					Source: odir,
@@ -388,10 +467,10 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 				}},
 			}
 
-			if len(cfg.Ulimits) > 0 {
-				ulimits, err := conv.ToUlimits(cfg.Ulimits)
+			if len(gcfg.Ulimits) > 0 {
+				ulimits, err := conv.ToUlimits(gcfg.Ulimits)
 				if err == nil {
-					hcfg.Resources = container.Resources{Ulimits: ulimits}
+					hcfg.Resources.Ulimits = ulimits
 				} else {
 					ow.Warnf("invalid ulimit will be ignored %v", err)
 				}
@@ -449,7 +528,7 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 	defer cancel()
 
 	// collect the outcomes in parallel while the process runs.
-	outcomesDoneCh, err := r.collectOutcomes(ctxContainers, result, &template)
+	outcomesDoneCh, err := r.collectOutcomes(ctxContainers, syncClient, result, &template)
 	if err != nil {
 		log.Error(err)
 		return
@@ -457,10 +536,35 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 
 	log.Infow("starting containers", "count", len(containers))
 
+	// groups may have to wait for other groups before starting.
+	starter := newGroupStarter(syncClient, &template, input.Groups, input.Stagger)
+
+	// the containers of a group are killed once its timeout elapses.
+	grpctxs, cancelGroups := groupContexts(ctxContainers, ow, input.Groups, func(grp *api.RunGroup) {
+		for _, c := range containers {
+			if c.groupID == grp.ID {
+				_ = cli.ContainerKill(context.Background(), c.containerID, "KILL")
+			}
+		}
+	})
+	defer cancelGroups()
+
 	g, gctx := errgroup.WithContext(ctxContainers)
 	for _, c := range containers {
 		c := c
 		f := func() error {
+			if err := starter.Wait(gctx, ow, c.groupID); err != nil {
+				return err
+			}
+			if err := starter.Admit(gctx, ow); err != nil {
+				return err
+			}
+			if grpctxs[c.groupID].Err() != nil {
+				// the group timed out before this container could start.
+				starter.Started(c.groupID)
+				return nil
+			}
+
 			ratelimit <- struct{}{}
 			defer func() { <-ratelimit }()
 
@@ -469,6 +573,7 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 			err := cli.ContainerStart(ctx, c.containerID, types.ContainerStartOptions{})
 			if err == nil {
 				log.Debugw("started container", "id", c.containerID, "group", c.groupID, "group_index", c.groupIdx)
+				starter.Started(c.groupID)
 				select {
 				case <-gctx.Done():
 				default:
@@ -493,7 +598,8 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 		}
 	}()
 
-	if !cfg.Background {
+	// the containers are tailed to follow the instances, even in background.
+	if !cfg.Background || input.FollowInstances {
 		pretty := NewPrettyPrinter(ow)
 
 		// This goroutine tails the sidecar container logs and appends them to the pretty printer.
@@ -546,11 +652,19 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 
 					rstdout, wstdout := io.Pipe()
 					rstderr, wstderr := io.Pipe()
-					go func() {
-						_, err := stdcopy.StdCopy(wstdout, wstderr, stream)
+					go func(tc testContainer) {
+						var stdout, stderr io.Writer = wstdout, wstderr
+						if input.FollowInstances {
+							f := newInstanceFollower(ow, tc.groupID, tc.groupIdx)
+							fstdout, fstderr := f.writer(streamStdout), f.writer(streamStderr)
+							defer fstdout.Close()
+							defer fstderr.Close()
+							stdout, stderr = io.MultiWriter(wstdout, fstdout), io.MultiWriter(wstderr, fstderr)
+						}
+						_, err := stdcopy.StdCopy(stdout, stderr, stream)
 						_ = wstdout.CloseWithError(err)
 						_ = wstderr.CloseWithError(err)
-					}()
+					}(tc)
 
 					// instance tag in output: << group[zero_padded_i] >> (container_id[0:6]), e.g. << miner[003] (a1b2c3) >>
 					tag := fmt.Sprintf("%s[%03d] (%s)", tc.groupID, tc.groupIdx, tc.containerID[0:6])
@@ -586,10 +700,22 @@ func (r *LocalDockerRunner) Run(ctx context.Context, input *api.RunInput, ow *rp
 
 	cancel()
 	<-outcomesDoneCh
+
+	// the instances that didn't exit cleanly failed.
+	if result.Outcome == task.OutcomeFailure {
+		for _, c := range containers {
+			ci, err := cli.ContainerInspect(context.Background(), c.containerID)
+			if err == nil && ci.State != nil && (ci.State.Running || ci.State.ExitCode != 0) {
+				result.InstanceFailed(c.groupID, c.groupIdx)
+			}
+		}
+	}
 	return
 }
 
-func newDataNetwork(ctx context.Context, cli *client.Client, rw *rpc.OutputWriter, env *runtime.RunParams, name string) (id string, subnet *net.IPNet, err error) {
+// newDataNetwork creates a data network for the run, with an IPv4 subnet, and
+// an IPv6 one if it's dual-stack.
+func newDataNetwork(ctx context.Context, cli *client.Client, rw *rpc.OutputWriter, env *runtime.RunParams, name string, ipv6 bool) (id string, subnet, subnetV6 *net.IPNet, err error) {
 	// Find a free network.
 	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
 		Filters: filters.NewArgs(
@@ -600,12 +726,27 @@ func newDataNetwork(ctx context.Context, cli *client.Client, rw *rpc.OutputWrite
 		),
 	})
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	subnet, gateway, err := nextDataNetwork(len(networks))
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
+	}
+
+	ipam := []network.IPAMConfig{{
+		Subnet:  subnet.String(),
+		Gateway: gateway,
+	}}
+	if ipv6 {
+		var gateway6 string
+		if subnetV6, gateway6, err = dataNetworkV6(subnet); err != nil {
+			return "", nil, nil, err
+		}
+		ipam = append(ipam, network.IPAMConfig{
+			Subnet:  subnetV6.String(),
+			Gateway: gateway6,
+		})
 	}
 
 	id, err = docker.NewBridgeNetwork(
@@ -619,12 +760,9 @@ func newDataNetwork(ctx context.Context, cli *client.Client, rw *rpc.OutputWrite
 			"testground.run_id":   env.TestRun,
 			"testground.name":     name,
 		},
-		network.IPAMConfig{
-			Subnet:  subnet.String(),
-			Gateway: gateway,
-		},
+		ipam...,
 	)
-	return id, subnet, err
+	return id, subnet, subnetV6, err
 }
 
 func (r *LocalDockerRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
@@ -643,7 +781,7 @@ func attachContainerToNetwork(ctx context.Context, cli *client.Client, container
 	return cli.NetworkConnect(ctx, networkID, containerID, nil)
 }
 
-//nolint this function is unused, but it may come in handy.
+// nolint this function is unused, but it may come in handy.
 func detachContainerFromNetwork(ctx context.Context, cli *client.Client, containerID string, networkID string) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -659,16 +797,29 @@ func (*LocalDockerRunner) ConfigType() reflect.Type {
 }
 
 func (*LocalDockerRunner) CompatibleBuilders() []string {
-	return []string{"docker:go", "docker:node", "docker:generic"}
+	return []string{"docker:go", "docker:node", "docker:generic", "docker:rust"}
+}
+
+// Capabilities reports that the runner runs docker images, that the sidecar
+// shapes the traffic of the containers, and that containers run in
+// privileged mode, and with GPUs, when required.
+func (*LocalDockerRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		TrafficShaping: true,
+		Privileged:     true,
+		GPUs:           true,
+		Artifacts:      []api.ArtifactKind{api.ArtifactDockerImage},
+	}
 }
 
 // This method deletes the testground containers.
 // It does *not* delete any downloaded images or networks.
 // I'll leave a friendly message for how to do a more complete cleanup.
-func (*LocalDockerRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
-	ow.Info("terminate local:docker requested")
+func (r *LocalDockerRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
+	ce := r.containers()
+	ow.Infof("terminate %s requested", ce.runner)
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	cli, err := ce.connect()
 	if err != nil {
 		return err
 	}
@@ -686,6 +837,7 @@ func (*LocalDockerRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter
 	infraOpts.Filters.Add("name", "testground-influxdb")
 	infraOpts.Filters.Add("name", "testground-redis")
 	infraOpts.Filters.Add("name", "testground-sidecar")
+	infraOpts.Filters.Add("name", "testground-sync-service")
 
 	// Build query for testground plans that are still running.
 	planOpts := types.ContainerListOptions{}
@@ -714,6 +866,134 @@ func (*LocalDockerRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter
 		return fmt.Errorf("failed to list testground containers: %w", err)
 	}
 
-	ow.Info("to delete networks and images, you may want to run `docker system prune`")
+	ow.Infof("to delete networks and images, you may want to run `%s system prune`", ce.cli)
+	return nil
+}
+
+// Cleanup removes the containers and the networks of the run: those of its
+// instances, and the sync service of the run if it was given its own.
+func (r *LocalDockerRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	cli, err := r.containers().connect()
+	if err != nil {
+		return err
+	}
+
+	run := filters.NewArgs(filters.Arg("label", "testground.run_id="+runID))
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: run})
+	if err != nil {
+		return fmt.Errorf("failed to list the containers of the run: %w", err)
+	}
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	if err := docker.DeleteContainers(cli, ow, ids); err != nil {
+		return fmt.Errorf("failed to delete the containers of the run: %w", err)
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: run})
+	if err != nil {
+		return fmt.Errorf("failed to list the networks of the run: %w", err)
+	}
+	for _, n := range networks {
+		ow.Infow("removing network", "network", n.Name)
+		if err := cli.NetworkRemove(ctx, n.ID); err != nil {
+			return fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+		}
+	}
+	return nil
+}
+
+// Leftovers returns the IDs of the runs that left containers or networks
+// behind.
+func (r *LocalDockerRunner) Leftovers(ctx context.Context) ([]string, error) {
+	cli, err := r.containers().connect()
+	if err != nil {
+		return nil, err
+	}
+
+	labelled := filters.NewArgs(filters.Arg("label", "testground.run_id"))
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: labelled})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: labelled})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	runs := make([]string, 0, len(containers)+len(networks))
+	for _, c := range containers {
+		runs = append(runs, c.Labels["testground.run_id"])
+	}
+	for _, n := range networks {
+		runs = append(runs, n.Labels["testground.run_id"])
+	}
+	return uniqueRunIDs(runs), nil
+}
+
+// containers returns the engine that runs the containers.
+func (r *LocalDockerRunner) containers() *containerEngine {
+	if r.engine != nil {
+		return r.engine
+	}
+	return dockerEngine
+}
+
+// dockerResources translates the resources of a group into the limits of its
+// containers. The disk size is set as a storage option, which is only
+// supported by some storage drivers, e.g. overlay2 on xfs with pquota.
+func dockerResources(r api.Resources) (container.Resources, map[string]string, error) {
+	var (
+		res        container.Resources
+		storageOpt map[string]string
+	)
+	if r.CPU != "" {
+		q, err := resource.ParseQuantity(r.CPU)
+		if err != nil {
+			return res, nil, err
+		}
+		res.NanoCPUs = q.MilliValue() * 1e6
+	}
+	if r.Memory != "" {
+		q, err := resource.ParseQuantity(r.Memory)
+		if err != nil {
+			return res, nil, err
+		}
+		res.Memory = q.Value()
+	}
+	if r.Disk != "" {
+		q, err := resource.ParseQuantity(r.Disk)
+		if err != nil {
+			return res, nil, err
+		}
+		storageOpt = map[string]string{"size": strconv.FormatInt(q.Value(), 10)}
+	}
+	if r.GPUs > 0 {
+		// like docker run --gpus <n>.
+		res.DeviceRequests = []container.DeviceRequest{{Count: r.GPUs, Capabilities: [][]string{{"gpu"}}}}
+	}
+	return res, storageOpt, nil
+}
+
+// checkGPUSupport returns an error if groups request GPUs, but the docker
+// host can't provide them, lacking the runtime of the NVIDIA Container
+// Toolkit.
+func checkGPUSupport(ctx context.Context, cli *client.Client, input *api.RunInput) error {
+	var gpus bool
+	for _, g := range input.Groups {
+		gpus = gpus || groupGPUs(g, input.Requirements) > 0
+	}
+	if !gpus {
+		return nil
+	}
+
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the info of the docker host: %w", err)
+	}
+	if _, ok := info.Runtimes["nvidia"]; !ok {
+		return fmt.Errorf("groups request gpus, but the docker host has no gpu support; install the NVIDIA Container Toolkit")
+	}
 	return nil
 }
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/testground/testground/pkg/docker"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// runSync is the redis and sync service containers of a run that was given
+// its own, on the control network. They're named after the infrastructure
+// containers they stand in for, so that terminating these also terminates
+// them.
+type runSync struct {
+	redis   string // name of the redis container.
+	service string // name of the sync service container.
+	port    string // port of the sync service on the host.
+	ids     []string
+}
+
+// startRunSync starts the redis and sync service containers of a run, the
+// sync service publishing its port on a free port of the host, for the
+// runner to connect to.
+func startRunSync(ctx context.Context, ow *rpc.OutputWriter, cli *client.Client, ce *containerEngine, runID string, controlNetworkID string) (rs *runSync, err error) {
+	rs = &runSync{
+		redis:   "testground-redis-" + runID,
+		service: "testground-sync-service-" + runID,
+	}
+	defer func() {
+		if err != nil {
+			rs.stop(cli, ow)
+		}
+	}()
+
+	labels := map[string]string{
+		"testground.purpose": "sync",
+		"testground.run_id":  runID,
+	}
+
+	ci, _, err := docker.EnsureContainerStarted(ctx, ow, cli, &docker.EnsureContainerOpts{
+		ContainerName: rs.redis,
+		ContainerConfig: &container.Config{
+			Image:  runSyncRedisImage,
+			Cmd:    runSyncRedisArgs,
+			Labels: labels,
+		},
+		HostConfig: &container.HostConfig{
+			NetworkMode: container.NetworkMode(controlNetworkID),
+			Resources: container.Resources{
+				Ulimits: ce.ulimits(),
+			},
+			Sysctls: map[string]string{
+				"net.core.somaxconn": "150000",
+			},
+		},
+		ImageStrategy: docker.ImageStrategyPull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the redis of the run: %w", err)
+	}
+	rs.ids = append(rs.ids, ci.ID)
+
+	port := nat.Port(runSyncPort + "/tcp")
+	ci, _, err = docker.EnsureContainerStarted(ctx, ow, cli, &docker.EnsureContainerOpts{
+		ContainerName: rs.service,
+		ContainerConfig: &container.Config{
+			Image:        runSyncImage,
+			Entrypoint:   []string{"/service"},
+			Env:          []string{"REDIS_HOST=" + rs.redis},
+			ExposedPorts: nat.PortSet{port: struct{}{}},
+			Labels:       labels,
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{port: []nat.PortBinding{{HostIP: "127.0.0.1"}}},
+			NetworkMode:  container.NetworkMode(controlNetworkID),
+			Resources: container.Resources{
+				Ulimits: ce.ulimits(),
+			},
+		},
+		ImageStrategy: docker.ImageStrategyPull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the sync service of the run: %w", err)
+	}
+	rs.ids = append(rs.ids, ci.ID)
+
+	if ci.NetworkSettings == nil || len(ci.NetworkSettings.Ports[port]) == 0 {
+		return nil, fmt.Errorf("sync service of the run didn't publish port %s", port)
+	}
+	rs.port = ci.NetworkSettings.Ports[port][0].HostPort
+	return rs, nil
+}
+
+// env returns the environment that points the instances of the run at its
+// sync service.
+func (rs *runSync) env() []string {
+	// NOTE: we export REDIS_HOST for compatibility with older sdk versions.
+	return []string{"SYNC_SERVICE_HOST=" + rs.service, "REDIS_HOST=" + rs.redis}
+}
+
+// stop removes the containers of the run's sync service.
+func (rs *runSync) stop(cli *client.Client, ow *rpc.OutputWriter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, id := range rs.ids {
+		if err := cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true}); err != nil {
+			ow.Warnw("failed to remove the sync service of the run", "container", id, "err", err)
+		}
+	}
+}
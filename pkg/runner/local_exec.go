@@ -10,11 +10,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	goruntime "runtime"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
 
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/conv"
@@ -31,9 +33,29 @@ var (
 	_, localSubnet, _ = net.ParseCIDR("127.1.0.1/16")
 )
 
+// hostEnv are the variables of the environment of the daemon that instances
+// inherit, next to PATH, by OS: windows programs, e.g. Go ones using the
+// network, fail without SYSTEMROOT.
+var hostEnv = map[string][]string{
+	"darwin":  {"HOME", "TMPDIR"},
+	"windows": {"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "LOCALAPPDATA", "APPDATA"},
+}
+
+// execSubnet returns the subnet of the instances, which they may bind to.
+// linux and windows route all of 127.0.0.0/8 to the loopback interface, while
+// macOS only configures 127.0.0.1 on it, so instances share that address
+// there.
+func execSubnet() *net.IPNet {
+	if goruntime.GOOS == "darwin" {
+		return &net.IPNet{IP: net.IPv4(127, 0, 0, 1).To4(), Mask: net.CIDRMask(32, 32)}
+	}
+	return localSubnet
+}
+
 var (
 	_ api.Runner        = (*LocalExecutableRunner)(nil)
 	_ api.Healthchecker = (*LocalExecutableRunner)(nil)
+	_ api.Capable       = (*LocalExecutableRunner)(nil)
 )
 
 type LocalExecutableRunner struct {
@@ -43,7 +65,26 @@ type LocalExecutableRunner struct {
 }
 
 // LocalExecutableRunnerCfg is the configuration struct for this runner.
-type LocalExecutableRunnerCfg struct{}
+// Isolating instances is only supported on linux, and requires the daemon to
+// have CAP_NET_ADMIN for network namespaces, and write access to the cgroup
+// parent, e.g. one delegated by systemd, for cgroups.
+type LocalExecutableRunnerCfg struct {
+	// Cgroups runs each instance in a cgroup v2 of its own, limited to the CPU
+	// and memory resources of its group, or else to Resources (default:
+	// false).
+	Cgroups bool `toml:"cgroups"`
+	// CgroupParent is the cgroup under which the cgroups of the runs are
+	// created (default: /sys/fs/cgroup/testground).
+	CgroupParent string `toml:"cgroup_parent"`
+	// Resources are the limits of the instances of groups that set none;
+	// only CPU and memory apply (default: unlimited).
+	Resources api.Resources `toml:"resources"`
+
+	// Netns runs each instance in a network namespace of its own, with an
+	// address of 127.1.0.0/16 on a bridge of the run, reaching the
+	// infrastructure of the daemon on localhost (default: false).
+	Netns bool `toml:"netns"`
+}
 
 func (r *LocalExecutableRunner) Healthcheck(ctx context.Context, engine api.Engine, ow *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
 	r.lk.Lock()
@@ -58,13 +99,19 @@ func (r *LocalExecutableRunner) Healthcheck(ctx context.Context, engine api.Engi
 	r.outputsDir = filepath.Join(engine.EnvConfig().Dirs().Outputs(), "local_exec")
 	hh := &healthcheck.Helper{}
 
+	// everything else depends on the docker daemon.
+	hh.Require("docker-daemon",
+		healthcheck.CheckDockerDaemon(ctx, cli),
+		healthcheck.RequiresManualFixing(),
+	)
+
 	hh.Enlist("redis-port",
 		healthcheck.CheckRedisPort(ctx, ow, cli),
 		healthcheck.RequiresManualFixing(),
 	)
 
 	// setup infra which is common between local:docker and local:exec
-	localCommonHealthcheck(ctx, hh, cli, ow, "testground-control", r.outputsDir)
+	localCommonHealthcheck(ctx, hh, cli, ow, dockerEngine, "testground-control", r.outputsDir)
 
 	// RunChecks will fill the report and return any errors.
 	return hh.RunChecks(ctx, fix)
@@ -86,15 +133,28 @@ func (r *LocalExecutableRunner) Run(ctx context.Context, input *api.RunInput, ow
 		TestInstanceCount:  input.TotalInstances,
 		TestDisableMetrics: input.DisableMetrics,
 		TestSidecar:        false,
-		TestSubnet:         &ptypes.IPNet{IPNet: *localSubnet},
+		TestSubnet:         &ptypes.IPNet{IPNet: *execSubnet()},
+	}
+
+	var cfg LocalExecutableRunnerCfg
+	if c, ok := input.RunnerConfig.(*LocalExecutableRunnerCfg); ok && c != nil {
+		cfg = *c
 	}
 
+	// instances may be isolated in cgroups and network namespaces, which are
+	// removed once they exited.
+	iso, err := newExecIsolation(&cfg, input.RunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to isolate the instances: %w", err)
+	}
+	defer iso.close(ow)
+
 	// Spawn as many instances as the input parameters require.
 	pretty := NewPrettyPrinter(ow)
 	commands := make([]*exec.Cmd, 0, input.TotalInstances)
 	defer func() {
 		for _, cmd := range commands {
-			_ = cmd.Process.Kill()
+			iso.kill(cmd)
 		}
 		for _, cmd := range commands {
 			_ = cmd.Wait()
@@ -102,67 +162,137 @@ func (r *LocalExecutableRunner) Run(ctx context.Context, input *api.RunInput, ow
 		_ = pretty.Wait()
 	}()
 
+	// groups may have to wait for other groups, or for a state, before
+	// starting.
+	var client ss.Client
+	if waitsOnState(input) {
+		c, err := newSyncClient(ctx, "127.0.0.1", "")
+		if err != nil {
+			return nil, api.NewInfraError(fmt.Errorf("failed to connect to the sync service: %w", err))
+		}
+		defer c.Close()
+		client = c
+	}
+	starter := newGroupStarter(client, &template, input.Groups, input.Stagger)
+
+	// the instances of a group are killed once its timeout elapses.
+	gctxs, cancelGroups := groupContexts(ctx, ow, input.Groups, nil)
+	defer cancelGroups()
+
 	var (
+		lk      sync.Mutex
+		wg      sync.WaitGroup
 		total   int
 		tmpdirs []string
 	)
-	for _, g := range input.Groups {
-		reviewResources(g, ow)
+	start := func(g *api.RunGroup, i int) {
+		lk.Lock()
+		total++
+		number := total
+		lk.Unlock()
+
+		tag := fmt.Sprintf("%s[%03d]", g.ID, i)
+
+		odir := filepath.Join(r.outputsDir, input.TestPlan, input.RunID, g.ID, strconv.Itoa(i))
+		if err := os.MkdirAll(odir, 0777); err != nil {
+			err = fmt.Errorf("failed to create outputs dir %s: %w", odir, err)
+			pretty.FailStart(tag, err)
+			return
+		}
 
-		for i := 0; i < g.Instances; i++ {
-			total++
-			tag := fmt.Sprintf("%s[%03d]", g.ID, i)
-
-			odir := filepath.Join(r.outputsDir, input.TestPlan, input.RunID, g.ID, strconv.Itoa(i))
-			if err := os.MkdirAll(odir, 0777); err != nil {
-				err = fmt.Errorf("failed to create outputs dir %s: %w", odir, err)
-				pretty.FailStart(tag, err)
-				continue
-			}
+		tmpdir, err := ioutil.TempDir("", "testground")
+		if err != nil {
+			err = fmt.Errorf("failed to create temp dir: %s: %w", tmpdir, err)
+			pretty.FailStart(tag, err)
+			return
+		}
 
-			tmpdir, err := ioutil.TempDir("", "testground")
-			if err != nil {
-				err = fmt.Errorf("failed to create temp dir: %s: %w", tmpdir, err)
-				pretty.FailStart(tag, err)
-				continue
+		lk.Lock()
+		tmpdirs = append(tmpdirs, tmpdir)
+		lk.Unlock()
+
+		runenv := template
+		runenv.TestGroupID = g.ID
+		runenv.TestGroupInstanceCount = g.Instances
+		runenv.TestInstanceParams = g.Parameters
+		runenv.TestOutputsPath = odir
+		runenv.TestTempPath = tmpdir
+		runenv.TestStartTime = time.Now()
+		runenv.TestCaptureProfiles = g.Profiles
+
+		env := conv.ToOptionsSlice(runenv.ToEnvVars())
+		env = append(env, "INFLUXDB_URL=http://localhost:8086")
+		// NOTE: we export REDIS_HOST for compatibility with older sdk versions.
+		env = append(env, "REDIS_HOST=localhost")
+		env = append(env, "SYNC_SERVICE_HOST=localhost")
+		env = append(env, "PATH="+os.Getenv("PATH"))
+		for _, k := range hostEnv[goruntime.GOOS] {
+			if v, ok := os.LookupEnv(k); ok {
+				env = append(env, k+"="+v)
 			}
+		}
+		// coverage builds write their coverage data to the outputs.
+		env = append(env, "GOCOVERDIR="+odir)
+
+		ow.Infow("starting test case instance", "plan", input.TestPlan, "group", g.ID, "number", i, "total", number)
+
+		cmd := exec.CommandContext(gctxs[g.ID], g.ArtifactPath)
+		stdout, _ := cmd.StdoutPipe()
+		stderr, _ := cmd.StderrPipe()
+		cmd.Env = env
+
+		if err := iso.start(cmd, g, i, number); err != nil {
+			pretty.FailStart(tag, err)
+			return
+		}
+
+		lk.Lock()
+		commands = append(commands, cmd)
+		lk.Unlock()
+
+		// instance tag in output: << group[zero_padded_i] >>, e.g. << miner[003] >>
+		pretty.Manage(tag, stdout, stderr)
+	}
 
-			tmpdirs = append(tmpdirs, tmpdir)
-
-			runenv := template
-			runenv.TestGroupID = g.ID
-			runenv.TestGroupInstanceCount = g.Instances
-			runenv.TestInstanceParams = g.Parameters
-			runenv.TestOutputsPath = odir
-			runenv.TestTempPath = tmpdir
-			runenv.TestStartTime = time.Now()
-			runenv.TestCaptureProfiles = g.Profiles
-
-			env := conv.ToOptionsSlice(runenv.ToEnvVars())
-			env = append(env, "INFLUXDB_URL=http://localhost:8086")
-			// NOTE: we export REDIS_HOST for compatibility with older sdk versions.
-			env = append(env, "REDIS_HOST=localhost")
-			env = append(env, "SYNC_SERVICE_HOST=localhost")
-			env = append(env, "PATH="+os.Getenv("PATH"))
-
-			ow.Infow("starting test case instance", "plan", input.TestPlan, "group", g.ID, "number", i, "total", total)
-
-			cmd := exec.CommandContext(ctx, g.ArtifactPath)
-			stdout, _ := cmd.StdoutPipe()
-			stderr, _ := cmd.StderrPipe()
-			cmd.Env = env
-
-			if err := cmd.Start(); err != nil {
-				pretty.FailStart(tag, err)
-				continue
+	// spawn starts the instances of a group. Instances that fail to start
+	// count as started too, so that the groups waiting on this one don't
+	// hang; the run fails regardless.
+	spawn := func(g *api.RunGroup) {
+		for i := 0; i < g.Instances; i++ {
+			if err := starter.Admit(ctx, ow); err != nil {
+				pretty.FailStart(fmt.Sprintf("%s[%03d]", g.ID, i), err)
+			} else {
+				start(g, i)
 			}
+			starter.Started(g.ID)
+		}
+	}
 
-			commands = append(commands, cmd)
+	for _, g := range input.Groups {
+		if !cfg.Cgroups {
+			reviewResources(g, ow)
+		}
 
-			// instance tag in output: << group[zero_padded_i] >>, e.g. << miner[003] >>
-			pretty.Manage(tag, stdout, stderr)
+		if g.StartAfter == nil {
+			spawn(g)
+			continue
 		}
+
+		// spawn the groups that have to wait in the background, so that
+		// they don't hold back the groups they wait for.
+		wg.Add(1)
+		go func(g *api.RunGroup) {
+			defer wg.Done()
+			if err := starter.Wait(gctxs[g.ID], ow, g.ID); err != nil {
+				for i := 0; i < g.Instances; i++ {
+					pretty.FailStart(fmt.Sprintf("%s[%03d]", g.ID, i), err)
+				}
+				return
+			}
+			spawn(g)
+		}(g)
 	}
+	wg.Wait()
 
 	if err := <-pretty.Wait(); err != nil {
 		return nil, err
@@ -193,7 +323,16 @@ func (*LocalExecutableRunner) ConfigType() reflect.Type {
 }
 
 func (*LocalExecutableRunner) CompatibleBuilders() []string {
-	return []string{"exec:go"}
+	return []string{"exec:go", "exec:rust"}
+}
+
+// Capabilities reports that the runner runs executables on the platform of
+// the daemon.
+func (*LocalExecutableRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		Artifacts: []api.ArtifactKind{api.ArtifactExecutable},
+		Platforms: []string{goruntime.GOOS + "/" + goruntime.GOARCH},
+	}
 }
 
 func (*LocalExecutableRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultCgroupParent is the cgroup under which the local:exec runner
+	// creates the cgroups of its runs.
+	defaultCgroupParent = "/sys/fs/cgroup/testground"
+
+	// cgroupCPUPeriod is the period of the CPU quota of instances, in
+	// microseconds; cgroupCPUMinQuota its smallest quota.
+	cgroupCPUPeriod   = 100000
+	cgroupCPUMinQuota = 1000
+)
+
+// execProxyPorts are the ports of the infrastructure that instances in
+// network namespaces of their own reach on localhost: the sync service, redis
+// for older sdk versions, and influxdb.
+var execProxyPorts = []string{runSyncPort, "6379", "8086"}
+
+// cgroupLimits returns the cpu.max and memory.max of the cgroup of an instance
+// with resources r; "max" when they're not set.
+func cgroupLimits(r api.Resources) (cpu string, memory string, err error) {
+	cpu, memory = "max", "max"
+	if r.CPU != "" {
+		q, err := resource.ParseQuantity(r.CPU)
+		if err != nil {
+			return "", "", err
+		}
+		quota := q.MilliValue() * cgroupCPUPeriod / 1000
+		if quota < cgroupCPUMinQuota {
+			quota = cgroupCPUMinQuota
+		}
+		cpu = fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)
+	}
+	if r.Memory != "" {
+		q, err := resource.ParseQuantity(r.Memory)
+		if err != nil {
+			return "", "", err
+		}
+		memory = strconv.FormatInt(q.Value(), 10)
+	}
+	return cpu, memory, nil
+}
+
+// cgroupEvent returns the count of an event of a cgroup events file, e.g.
+// oom_kill of memory.events; zero if it's missing.
+func cgroupEvent(events []byte, key string) int {
+	s := bufio.NewScanner(bytes.NewReader(events))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) == 2 && f[0] == key {
+			n, _ := strconv.Atoi(f[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// execInstanceIP returns the address of the nth instance of a run, counting
+// from 1, in localSubnet.
+func execInstanceIP(n int) (net.IP, error) {
+	return instanceIP(localSubnet, n)
+}
+
+// instanceIP returns the address of the nth instance of a run, counting from
+// 1, in an IPv4 subnet. The first address after the one of the subnet is
+// left out, like the gateways of the data networks of the other runners.
+func instanceIP(subnet *net.IPNet, n int) (net.IP, error) {
+	ones, bits := subnet.Mask.Size()
+	if n < 1 || n > 1<<(bits-ones)-3 {
+		return nil, fmt.Errorf("no address for instance %d in %s", n, subnet)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP.To4())+uint32(n)+1)
+	return ip, nil
+}
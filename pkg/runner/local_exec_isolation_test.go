@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	cpu, memory, err := cgroupLimits(api.Resources{})
+	require.NoError(t, err)
+	require.Equal(t, "max", cpu)
+	require.Equal(t, "max", memory)
+
+	cpu, memory, err = cgroupLimits(api.Resources{CPU: "1500m", Memory: "512Mi"})
+	require.NoError(t, err)
+	require.Equal(t, "150000 100000", cpu)
+	require.Equal(t, "536870912", memory)
+
+	// quotas are no smaller than the kernel allows.
+	cpu, _, err = cgroupLimits(api.Resources{CPU: "1m"})
+	require.NoError(t, err)
+	require.Equal(t, "1000 100000", cpu)
+
+	_, _, err = cgroupLimits(api.Resources{Memory: "lots"})
+	require.Error(t, err)
+}
+
+func TestCgroupEvent(t *testing.T) {
+	events := []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+	require.Equal(t, 1, cgroupEvent(events, "oom_kill"))
+	require.Equal(t, 3, cgroupEvent(events, "max"))
+	require.Zero(t, cgroupEvent(events, "oom_group_kill"))
+}
+
+func TestExecInstanceIP(t *testing.T) {
+	ip, err := execInstanceIP(1)
+	require.NoError(t, err)
+	require.Equal(t, "127.1.0.2", ip.String())
+
+	ip, err = execInstanceIP(300)
+	require.NoError(t, err)
+	require.Equal(t, "127.1.1.45", ip.String())
+	require.True(t, localSubnet.Contains(ip))
+
+	ip, err = execInstanceIP(65533)
+	require.NoError(t, err)
+	require.Equal(t, "127.1.255.254", ip.String())
+
+	_, err = execInstanceIP(65534)
+	require.Error(t, err)
+	_, err = execInstanceIP(0)
+	require.Error(t, err)
+}
@@ -0,0 +1,399 @@
+//+build linux
+
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// execIsolation isolates the instances of a run of the local:exec runner: in
+// cgroups v2 of their own, limited to the resources of their groups, and in
+// network namespaces of their own, with addresses of localSubnet on a bridge
+// of the run. The bridge lives in a namespace of the run too, so that the
+// network of the host is left untouched; instances reach the infrastructure
+// on localhost through proxies that listen in their namespaces.
+type execIsolation struct {
+	cfg *LocalExecutableRunnerCfg
+
+	cgroup string // cgroup of the run; empty without cgroups.
+
+	bridgeNs netns.NsHandle // namespace of the bridge; None without netns.
+	bridge   int            // index of the bridge in bridgeNs.
+
+	lk        sync.Mutex
+	cgroups   []string
+	nss       []netns.NsHandle
+	listeners []net.Listener
+}
+
+// newExecIsolation sets up the cgroup and the bridge of a run, as configured;
+// nil if its instances aren't isolated.
+func newExecIsolation(cfg *LocalExecutableRunnerCfg, runID string) (iso *execIsolation, err error) {
+	if !cfg.Cgroups && !cfg.Netns {
+		return nil, nil
+	}
+
+	iso = &execIsolation{cfg: cfg, bridgeNs: netns.None()}
+	defer func() {
+		if err != nil {
+			iso.close(nil)
+		}
+	}()
+
+	if cfg.Cgroups {
+		parent := withDefault(cfg.CgroupParent, defaultCgroupParent)
+		if err := createCgroup(parent, true); err != nil {
+			return nil, err
+		}
+		if err := createCgroup(filepath.Join(parent, runID), true); err != nil {
+			return nil, err
+		}
+		iso.cgroup = filepath.Join(parent, runID)
+	}
+
+	if cfg.Netns {
+		if iso.bridgeNs, err = newNetns(); err != nil {
+			return nil, fmt.Errorf("failed to create the network namespace of the run: %w", err)
+		}
+		h, err := netlink.NewHandleAt(iso.bridgeNs)
+		if err != nil {
+			return nil, err
+		}
+		defer h.Delete()
+
+		br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+		if err := h.LinkAdd(br); err != nil {
+			return nil, fmt.Errorf("failed to create the bridge of the run: %w", err)
+		}
+		link, err := h.LinkByName(br.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.LinkSetUp(link); err != nil {
+			return nil, err
+		}
+		iso.bridge = link.Attrs().Index
+	}
+	return iso, nil
+}
+
+// start starts the process of the ith instance of a group, the nth of the
+// run, in its cgroup and network namespace.
+func (iso *execIsolation) start(cmd *exec.Cmd, g *api.RunGroup, i, n int) error {
+	if iso == nil {
+		return cmd.Start()
+	}
+
+	var cgroup string
+	if iso.cgroup != "" {
+		cgroup = filepath.Join(iso.cgroup, fmt.Sprintf("%s-%d", g.ID, i))
+		if err := iso.createInstanceCgroup(cgroup, g); err != nil {
+			return err
+		}
+	}
+
+	if iso.cfg.Netns {
+		ns, err := iso.createInstanceNetns(n)
+		if err != nil {
+			return fmt.Errorf("failed to create the network namespace of the instance: %w", err)
+		}
+		// the child is forked from the thread, in the namespace.
+		if err := inNetns(ns, cmd.Start); err != nil {
+			return err
+		}
+	} else if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if cgroup == "" {
+		return nil
+	}
+	// the process is moved into its cgroup once started, since it can't be
+	// started in it.
+	if err := writeCgroupFile(cgroup, "cgroup.procs", strconv.Itoa(cmd.Process.Pid)); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+// createInstanceCgroup creates the cgroup of an instance, limited to the
+// resources of its group, or else to the default ones.
+func (iso *execIsolation) createInstanceCgroup(cgroup string, g *api.RunGroup) error {
+	cpu, memory, err := cgroupLimits(api.Resources{
+		CPU:    withDefault(g.Resources.CPU, iso.cfg.Resources.CPU),
+		Memory: withDefault(g.Resources.Memory, iso.cfg.Resources.Memory),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid resources: %w", err)
+	}
+	if err := createCgroup(cgroup, false); err != nil {
+		return err
+	}
+
+	iso.lk.Lock()
+	iso.cgroups = append(iso.cgroups, cgroup)
+	iso.lk.Unlock()
+
+	if err := writeCgroupFile(cgroup, "cpu.max", cpu); err != nil {
+		return err
+	}
+	return writeCgroupFile(cgroup, "memory.max", memory)
+}
+
+// createInstanceNetns creates the network namespace of the nth instance of the
+// run, attached to the bridge of the run.
+func (iso *execIsolation) createInstanceNetns(n int) (netns.NsHandle, error) {
+	ip, err := execInstanceIP(n)
+	if err != nil {
+		return netns.None(), err
+	}
+
+	ns, err := newNetns()
+	if err != nil {
+		return netns.None(), err
+	}
+	iso.lk.Lock()
+	iso.nss = append(iso.nss, ns)
+	iso.lk.Unlock()
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return ns, err
+	}
+	defer h.Delete()
+
+	bh, err := netlink.NewHandleAt(iso.bridgeNs)
+	if err != nil {
+		return ns, err
+	}
+	defer bh.Delete()
+
+	// the loopback keeps 127.0.0.1 only, for the rest of 127.0.0.0/8 to be
+	// routed to the bridge.
+	lo, err := h.LinkByName("lo")
+	if err != nil {
+		return ns, err
+	}
+	if err := h.LinkSetUp(lo); err != nil {
+		return ns, err
+	}
+	if err := h.AddrDel(lo, &netlink.Addr{IPNet: &net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)}}); err != nil {
+		return ns, err
+	}
+	if err := h.AddrAdd(lo, &netlink.Addr{IPNet: &net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(32, 32)}}); err != nil {
+		return ns, err
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: fmt.Sprintf("v%d", n), MasterIndex: iso.bridge},
+		PeerName:  fmt.Sprintf("p%d", n),
+	}
+	if err := bh.LinkAdd(veth); err != nil {
+		return ns, err
+	}
+	if err := bh.LinkSetUp(veth); err != nil {
+		return ns, err
+	}
+	peer, err := bh.LinkByName(veth.PeerName)
+	if err != nil {
+		return ns, err
+	}
+	if err := bh.LinkSetNsFd(peer, int(ns)); err != nil {
+		return ns, err
+	}
+	if peer, err = h.LinkByName(veth.PeerName); err != nil {
+		return ns, err
+	}
+	if err := h.LinkSetName(peer, "eth0"); err != nil {
+		return ns, err
+	}
+	if err := h.AddrAdd(peer, &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: localSubnet.Mask}}); err != nil {
+		return ns, err
+	}
+	if err := h.LinkSetUp(peer); err != nil {
+		return ns, err
+	}
+
+	// sysctls and sockets belong to the namespace of the thread that
+	// writes or opens them.
+	return ns, inNetns(ns, func() error {
+		if err := ioutil.WriteFile("/proc/sys/net/ipv4/conf/all/route_localnet", []byte("1"), 0644); err != nil {
+			return err
+		}
+		for _, port := range execProxyPorts {
+			l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+			if err != nil {
+				return err
+			}
+			iso.lk.Lock()
+			iso.listeners = append(iso.listeners, l)
+			iso.lk.Unlock()
+			go proxyLocal(l, port)
+		}
+		return nil
+	})
+}
+
+// kill kills an instance; the processes it left behind are killed with the
+// cgroup of the run, if any, on close.
+func (*execIsolation) kill(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
+
+// close kills the processes left in the cgroup of the run, warning about the
+// instances that ran out of memory, and removes the cgroups and the network
+// namespaces of the run. It's called once the instances exited.
+func (iso *execIsolation) close(ow *rpc.OutputWriter) {
+	if iso == nil {
+		return
+	}
+
+	iso.lk.Lock()
+	defer iso.lk.Unlock()
+
+	for _, l := range iso.listeners {
+		_ = l.Close()
+	}
+	for _, ns := range iso.nss {
+		_ = ns.Close()
+	}
+	if iso.bridgeNs.IsOpen() {
+		_ = iso.bridgeNs.Close()
+	}
+
+	if iso.cgroup == "" {
+		return
+	}
+	// cgroup.kill is only available on linux 5.14 or later.
+	_ = writeCgroupFile(iso.cgroup, "cgroup.kill", "1")
+	for _, cgroup := range iso.cgroups {
+		events, err := ioutil.ReadFile(filepath.Join(cgroup, "memory.events"))
+		if err == nil && ow != nil && cgroupEvent(events, "oom_kill") > 0 {
+			ow.Warnw("instance was killed for exceeding its memory limit", "instance", filepath.Base(cgroup))
+		}
+	}
+	for _, cgroup := range append(iso.cgroups, iso.cgroup) {
+		if err := removeCgroup(cgroup); err != nil && ow != nil {
+			ow.Warnw("failed to remove cgroup", "cgroup", cgroup, "err", err)
+		}
+	}
+}
+
+// createCgroup creates a cgroup, if it doesn't exist, enabling the cpu and
+// memory controllers for its children if it has any.
+func createCgroup(path string, parent bool) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", path, err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%s is not a cgroup v2; is the unified hierarchy mounted at /sys/fs/cgroup?", path)
+	}
+	if !parent {
+		return nil
+	}
+	return writeCgroupFile(path, "cgroup.subtree_control", "+cpu +memory")
+}
+
+func writeCgroupFile(cgroup, file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(cgroup, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s of cgroup %s; is it delegated to the daemon, with the cpu and memory controllers? %w", file, cgroup, err)
+	}
+	return nil
+}
+
+// removeCgroup removes a cgroup, waiting for its processes to be gone.
+func removeCgroup(path string) (err error) {
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+// proxyLocal proxies the connections accepted by l, in the network namespace
+// of an instance, to the port on the localhost of the daemon.
+func proxyLocal(l net.Listener, port string) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			u, err := net.Dial("tcp", net.JoinHostPort("localhost", port))
+			if err != nil {
+				return
+			}
+			defer u.Close()
+			go func() {
+				_, _ = io.Copy(u, c)
+				_ = u.Close()
+			}()
+			_, _ = io.Copy(c, u)
+		}()
+	}
+}
+
+// newNetns creates a network namespace.
+func newNetns() (ns netns.NsHandle, err error) {
+	err = withThreadNetns(func() (err error) {
+		ns, err = netns.New()
+		return err
+	}, func() error { return nil })
+	return ns, err
+}
+
+// inNetns calls fn on a thread in the network namespace ns.
+func inNetns(ns netns.NsHandle, fn func() error) error {
+	return withThreadNetns(func() error { return netns.Set(ns) }, fn)
+}
+
+// withThreadNetns calls fn on a locked thread, once enter switched its
+// network namespace, and switches it back. A thread that can't be switched
+// back is discarded, rather than returned to the scheduler.
+func withThreadNetns(enter func() error, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		goruntime.LockOSThread()
+
+		origin, err := netns.Get()
+		if err != nil {
+			goruntime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer origin.Close()
+
+		if err := enter(); err != nil {
+			goruntime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		err = fn()
+		if netns.Set(origin) == nil {
+			goruntime.UnlockOSThread()
+		}
+		errCh <- err
+	}()
+	return <-errCh
+}
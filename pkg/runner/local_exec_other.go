@@ -0,0 +1,36 @@
+//+build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+)
+
+// execIsolation is only available on linux, where instances are isolated in
+// cgroups and network namespaces of their own. Elsewhere, e.g. on macOS and
+// windows, every instance runs in a process group of its own, so that the
+// processes it spawns are killed with it.
+type execIsolation struct{}
+
+func newExecIsolation(cfg *LocalExecutableRunnerCfg, _ string) (*execIsolation, error) {
+	if cfg.Cgroups || cfg.Netns {
+		return nil, fmt.Errorf("cgroups and network namespaces are only supported on linux")
+	}
+	return nil, nil
+}
+
+func (*execIsolation) start(cmd *exec.Cmd, _ *api.RunGroup, _, _ int) error {
+	cmd.SysProcAttr = instanceProcAttr()
+	return cmd.Start()
+}
+
+// kill kills the process group of an instance.
+func (*execIsolation) kill(cmd *exec.Cmd) {
+	killProcessGroup(cmd)
+}
+
+func (*execIsolation) close(*rpc.OutputWriter) {}
@@ -0,0 +1,20 @@
+//+build !linux,!windows
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// instanceProcAttr starts an instance in a process group of its own.
+func instanceProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills an instance along with the processes it spawned.
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		_ = cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,661 @@
+package runner
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	goruntime "runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	ss "github.com/testground/sdk-go/sync"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/config"
+	"github.com/testground/testground/pkg/healthcheck"
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/docker/docker/client"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultVMSubnet is the data network of the microVMs of a run.
+	defaultVMSubnet = "10.80.0.0/16"
+
+	// vmPlanDir is where the init of a microVM extracts the plan drive, and
+	// vmOutputsDir where the test plan writes its outputs, on a tmpfs.
+	vmPlanDir    = "/run/testground"
+	vmOutputsDir = vmPlanDir + "/outputs"
+
+	// vmMaxVCPUs is the largest number of vCPUs firecracker runs a microVM
+	// with.
+	vmMaxVCPUs = 32
+)
+
+var (
+	_ api.Runner        = (*LocalFirecrackerRunner)(nil)
+	_ api.Healthchecker = (*LocalFirecrackerRunner)(nil)
+	_ api.Capable       = (*LocalFirecrackerRunner)(nil)
+)
+
+// LocalFirecrackerRunner runs every instance in a firecracker microVM of its
+// own, with its own kernel, booted from a root filesystem shared read-only by
+// all microVMs, and attached by a tap device to a bridge of the run. Test
+// plans can change the settings of the kernel, e.g. the TCP congestion
+// control or the buffer sizes, without affecting each other or the host.
+//
+// The runner requires KVM, the firecracker binary, and CAP_NET_ADMIN to
+// create the network of the run; it's only supported on linux.
+type LocalFirecrackerRunner struct {
+	lk sync.RWMutex
+
+	outputsDir string
+}
+
+// LocalFirecrackerRunnerCfg is the configuration struct for this runner. The
+// kernel, root filesystem, boot args and sysctls may be set per group.
+//
+// The root filesystem must run local_firecracker_init.sh, installed as
+// /sbin/testground-init, as its init. The init extracts the plan drive, a tar
+// archive holding the test plan, its environment and its sysctls, into
+// /run/testground; runs the test plan with its output on the serial console;
+// and writes its outputs, along with its exit status, as a tar archive onto
+// the outputs drive before it powers the microVM off. It requires a shell,
+// mount, tar and sysctl, e.g. from busybox. The test plans must be statically
+// linked, or linked against the libraries of the root filesystem.
+type LocalFirecrackerRunnerCfg struct {
+	// Firecracker is the path of the firecracker binary (default:
+	// firecracker, in the PATH).
+	Firecracker string `toml:"firecracker"`
+	// KernelImage is the uncompressed linux kernel (vmlinux) the microVMs
+	// boot.
+	KernelImage string `toml:"kernel_image"`
+	// RootfsImage is the root filesystem image of the microVMs.
+	RootfsImage string `toml:"rootfs_image"`
+	// BootArgs are appended to the command line of the kernel, e.g. to set
+	// kernel parameters.
+	BootArgs string `toml:"boot_args"`
+	// Sysctls are applied in the microVMs before the test plan starts, e.g.
+	// "net.ipv4.tcp_congestion_control" = "bbr".
+	Sysctls map[string]string `toml:"sysctls"`
+	// Resources are those of the instances of groups that set none; the CPUs
+	// are rounded up to vCPUs (default: 1 vCPU and 512Mi).
+	Resources api.Resources `toml:"resources"`
+	// OutputsSize is the size of the outputs drive of an instance, which caps
+	// its outputs (default: 256Mi).
+	OutputsSize string `toml:"outputs_size"`
+	// Subnet is the data network of the microVMs, on a bridge in a network
+	// namespace of the run; the microVMs reach the infrastructure of the
+	// daemon at its first address (default: 10.80.0.0/16).
+	Subnet string `toml:"subnet"`
+}
+
+// Healthcheck checks that KVM and the firecracker binary are available, along
+// with the infrastructure shared with local:exec.
+func (r *LocalFirecrackerRunner) Healthcheck(ctx context.Context, engine api.Engine, ow *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	var cfg LocalFirecrackerRunnerCfg
+	coalesced := config.CoalescedConfig{engine.EnvConfig().Runners[r.ID()]}
+	if obj, err := coalesced.CoalesceIntoType(r.ConfigType()); err == nil {
+		cfg = *obj.(*LocalFirecrackerRunnerCfg)
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	r.outputsDir = filepath.Join(engine.EnvConfig().Dirs().Outputs(), "local_firecracker")
+	hh := &healthcheck.Helper{}
+
+	hh.Enlist("kvm", checkFileAccess("/dev/kvm", "KVM"), healthcheck.RequiresManualFixing())
+	hh.Enlist("firecracker",
+		healthcheck.CheckCommandStatus(ctx, withDefault(cfg.Firecracker, "firecracker"), "--version"),
+		healthcheck.RequiresManualFixing(),
+	)
+	hh.Enlist("kernel-image", checkFileAccess(cfg.KernelImage, "kernel_image"), healthcheck.RequiresManualFixing())
+	hh.Enlist("rootfs-image", checkFileAccess(cfg.RootfsImage, "rootfs_image"), healthcheck.RequiresManualFixing())
+
+	// everything else depends on the docker daemon.
+	hh.Require("docker-daemon",
+		healthcheck.CheckDockerDaemon(ctx, cli),
+		healthcheck.RequiresManualFixing(),
+	)
+
+	// setup infra which is common between local:docker and local:exec
+	localCommonHealthcheck(ctx, hh, cli, ow, dockerEngine, "testground-control", r.outputsDir)
+
+	return hh.RunChecks(ctx, fix)
+}
+
+// checkFileAccess checks that a file can be opened for reading and writing,
+// or just for reading if it's not a device.
+func checkFileAccess(path, what string) healthcheck.Checker {
+	return func() (bool, string, error) {
+		if path == "" {
+			return false, fmt.Sprintf("%s not configured", what), nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err.Error(), nil
+		}
+		flag := os.O_RDONLY
+		if fi.Mode()&os.ModeDevice != 0 {
+			flag = os.O_RDWR
+		}
+		f, err := os.OpenFile(path, flag, 0)
+		if err != nil {
+			return false, err.Error(), nil
+		}
+		_ = f.Close()
+		return true, fmt.Sprintf("%s is accessible", path), nil
+	}
+}
+
+func (r *LocalFirecrackerRunner) Close() error {
+	return nil
+}
+
+func (r *LocalFirecrackerRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	var cfg LocalFirecrackerRunnerCfg
+	if c, ok := input.RunnerConfig.(*LocalFirecrackerRunnerCfg); ok && c != nil {
+		cfg = *c
+	}
+
+	_, subnet, err := net.ParseCIDR(withDefault(cfg.Subnet, defaultVMSubnet))
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid subnet %q: must be an IPv4 subnet", cfg.Subnet)
+	}
+	outputsSize, err := resource.ParseQuantity(withDefault(cfg.OutputsSize, "256Mi"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid outputs_size: %w", err)
+	}
+
+	// the microVMs are attached to a bridge of the run, where they reach the
+	// infrastructure at the gateway.
+	vmnet, err := newVMNetwork(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the network of the run: %w", err)
+	}
+	defer vmnet.close()
+	gw := vmnet.gateway.String()
+
+	template := runtime.RunParams{
+		TestPlan:           input.TestPlan,
+		TestCase:           input.TestCase,
+		TestRun:            input.RunID,
+		TestInstanceCount:  input.TotalInstances,
+		TestDisableMetrics: input.DisableMetrics,
+		TestSidecar:        false,
+		TestSubnet:         &ptypes.IPNet{IPNet: *subnet},
+	}
+
+	pretty := NewPrettyPrinter(ow)
+
+	var (
+		lk       sync.Mutex
+		wg       sync.WaitGroup
+		vms      sync.WaitGroup
+		commands []*exec.Cmd
+		total    int
+		workdirs []string
+	)
+	defer func() {
+		lk.Lock()
+		for _, cmd := range commands {
+			_ = cmd.Process.Kill()
+		}
+		lk.Unlock()
+		vms.Wait()
+		_ = pretty.Wait()
+		for _, dir := range workdirs {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	// groups may have to wait for other groups, or for a state, before
+	// starting.
+	var client ss.Client
+	if waitsOnState(input) {
+		c, err := newSyncClient(ctx, "127.0.0.1", "")
+		if err != nil {
+			return nil, api.NewInfraError(fmt.Errorf("failed to connect to the sync service: %w", err))
+		}
+		defer c.Close()
+		client = c
+	}
+	starter := newGroupStarter(client, &template, input.Groups, input.Stagger)
+
+	// the instances of a group are killed once its timeout elapses.
+	gctxs, cancelGroups := groupContexts(ctx, ow, input.Groups, nil)
+	defer cancelGroups()
+
+	start := func(g *api.RunGroup, i int) error {
+		gcfg := cfg
+		if c, ok := g.RunnerConfig.(*LocalFirecrackerRunnerCfg); ok && c != nil {
+			gcfg = *c
+		}
+
+		lk.Lock()
+		total++
+		number := total
+		lk.Unlock()
+
+		ip, err := instanceIP(subnet, number)
+		if err != nil {
+			return err
+		}
+
+		odir := filepath.Join(r.outputsDir, input.TestPlan, input.RunID, g.ID, strconv.Itoa(i))
+		if err := os.MkdirAll(odir, 0777); err != nil {
+			return fmt.Errorf("failed to create outputs dir %s: %w", odir, err)
+		}
+
+		workdir, err := ioutil.TempDir("", "testground-vm")
+		if err != nil {
+			return fmt.Errorf("failed to create the work dir of the microVM: %w", err)
+		}
+		lk.Lock()
+		workdirs = append(workdirs, workdir)
+		lk.Unlock()
+
+		runenv := template
+		runenv.TestGroupID = g.ID
+		runenv.TestGroupInstanceCount = g.Instances
+		runenv.TestInstanceParams = g.Parameters
+		runenv.TestOutputsPath = vmOutputsDir
+		runenv.TestTempPath = "/tmp"
+		runenv.TestStartTime = time.Now()
+		runenv.TestCaptureProfiles = g.Profiles
+
+		env := runenv.ToEnvVars()
+		env["INFLUXDB_URL"] = "http://" + net.JoinHostPort(gw, "8086")
+		// NOTE: we export REDIS_HOST for compatibility with older sdk versions.
+		env["REDIS_HOST"] = gw
+		env["SYNC_SERVICE_HOST"] = gw
+		// coverage builds write their coverage data to the outputs.
+		env["GOCOVERDIR"] = vmOutputsDir
+
+		var (
+			plan    = filepath.Join(workdir, "plan.tar")
+			outputs = filepath.Join(workdir, "outputs.img")
+			cfgFile = filepath.Join(workdir, "vm.json")
+			logFile = filepath.Join(workdir, "firecracker.log")
+		)
+		if err := writePlanDrive(plan, g.ArtifactPath, env, gcfg.Sysctls); err != nil {
+			return fmt.Errorf("failed to write the plan drive: %w", err)
+		}
+		// the outputs drive is sparse, and only takes the space of the
+		// outputs.
+		if err := createSparseFile(outputs, outputsSize.Value()); err != nil {
+			return fmt.Errorf("failed to create the outputs drive: %w", err)
+		}
+		if err := createSparseFile(logFile, 0); err != nil {
+			return err
+		}
+
+		vcpus, mem, err := vmMachine(api.Resources{
+			CPU:    withDefault(g.Resources.CPU, cfg.Resources.CPU),
+			Memory: withDefault(g.Resources.Memory, cfg.Resources.Memory),
+		})
+		if err != nil {
+			return fmt.Errorf("invalid resources: %w", err)
+		}
+
+		vm := &vmConfig{
+			BootSource: vmBootSource{
+				KernelImagePath: gcfg.KernelImage,
+				BootArgs:        vmBootArgs(ip, vmnet.gateway, subnet.Mask, gcfg.BootArgs),
+			},
+			Drives: []vmDrive{
+				{DriveID: "rootfs", PathOnHost: gcfg.RootfsImage, IsRootDevice: true, IsReadOnly: true},
+				{DriveID: "plan", PathOnHost: plan, IsReadOnly: true},
+				{DriveID: "outputs", PathOnHost: outputs},
+			},
+			MachineConfig: vmMachineConfig{VCPUCount: vcpus, MemSizeMib: mem},
+			NetworkInterfaces: []vmNetworkInterface{
+				{IfaceID: "eth0", GuestMAC: vmMAC(number), HostDevName: vmnet.tapName(number)},
+			},
+		}
+		if err := writeJSONFile(cfgFile, vm); err != nil {
+			return err
+		}
+		if err := vmnet.addTap(number); err != nil {
+			return fmt.Errorf("failed to create the tap device of the microVM: %w", err)
+		}
+
+		ow.Infow("starting test case instance", "plan", input.TestPlan, "group", g.ID, "number", i, "total", number, "ip", ip)
+
+		// the serial console of the microVM is the standard output of
+		// firecracker, which logs to a file.
+		cmd := exec.CommandContext(gctxs[g.ID], withDefault(cfg.Firecracker, "firecracker"),
+			"--no-api", "--config-file", cfgFile, "--id", fmt.Sprintf("%s-%d", input.RunID, number),
+			"--log-path", logFile, "--level", "Warning")
+		stdout, stdoutw := io.Pipe()
+		stderr, stderrw := io.Pipe()
+		cmd.Stdout, cmd.Stderr = stdoutw, stderrw
+
+		if err := vmnet.start(cmd); err != nil {
+			return err
+		}
+
+		lk.Lock()
+		commands = append(commands, cmd)
+		lk.Unlock()
+
+		tag := fmt.Sprintf("%s[%03d]", g.ID, i)
+		pretty.Manage(tag, stdout, stderr)
+
+		// the outputs are extracted from the outputs drive once the microVM
+		// powered off.
+		vms.Add(1)
+		go func() {
+			defer vms.Done()
+			err := cmd.Wait()
+			_ = stdoutw.Close()
+			_ = stderrw.Close()
+			if err != nil {
+				if log, _ := ioutil.ReadFile(logFile); len(log) > 0 {
+					ow.Warnw("firecracker exited with an error", "instance", tag, "err", err, "log", string(log))
+				}
+			}
+			if err := extractOutputs(outputs, odir); err != nil {
+				ow.Warnw("failed to extract the outputs of the instance", "instance", tag, "err", err)
+			}
+		}()
+		return nil
+	}
+
+	// spawn starts the instances of a group. Instances that fail to start
+	// count as started too, so that the groups waiting on this one don't
+	// hang; the run fails regardless.
+	spawn := func(g *api.RunGroup) {
+		for i := 0; i < g.Instances; i++ {
+			tag := fmt.Sprintf("%s[%03d]", g.ID, i)
+			if err := starter.Admit(ctx, ow); err != nil {
+				pretty.FailStart(tag, err)
+			} else if err := start(g, i); err != nil {
+				pretty.FailStart(tag, err)
+			}
+			starter.Started(g.ID)
+		}
+	}
+
+	for _, g := range input.Groups {
+		if g.StartAfter == nil {
+			spawn(g)
+			continue
+		}
+
+		// spawn the groups that have to wait in the background, so that
+		// they don't hold back the groups they wait for.
+		wg.Add(1)
+		go func(g *api.RunGroup) {
+			defer wg.Done()
+			if err := starter.Wait(gctxs[g.ID], ow, g.ID); err != nil {
+				for i := 0; i < g.Instances; i++ {
+					pretty.FailStart(fmt.Sprintf("%s[%03d]", g.ID, i), err)
+				}
+				return
+			}
+			spawn(g)
+		}(g)
+	}
+	wg.Wait()
+
+	if err := <-pretty.Wait(); err != nil {
+		return nil, err
+	}
+	vms.Wait()
+
+	return &api.RunOutput{RunID: input.RunID}, nil
+}
+
+// vmConfig is the configuration file of a firecracker microVM.
+type vmConfig struct {
+	BootSource        vmBootSource         `json:"boot-source"`
+	Drives            []vmDrive            `json:"drives"`
+	MachineConfig     vmMachineConfig      `json:"machine-config"`
+	NetworkInterfaces []vmNetworkInterface `json:"network-interfaces"`
+}
+
+type vmBootSource struct {
+	KernelImagePath string `json:"kernel_image_path"`
+	BootArgs        string `json:"boot_args"`
+}
+
+type vmDrive struct {
+	DriveID      string `json:"drive_id"`
+	PathOnHost   string `json:"path_on_host"`
+	IsRootDevice bool   `json:"is_root_device"`
+	IsReadOnly   bool   `json:"is_read_only"`
+}
+
+type vmMachineConfig struct {
+	VCPUCount  int   `json:"vcpu_count"`
+	MemSizeMib int64 `json:"mem_size_mib"`
+}
+
+type vmNetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	GuestMAC    string `json:"guest_mac"`
+	HostDevName string `json:"host_dev_name"`
+}
+
+// vmBootArgs returns the command line of the kernel of a microVM: with its
+// output on the serial console, powering off on reboot, booting the root
+// filesystem read-only into the init of testground, and configuring eth0
+// with the address of the instance.
+func vmBootArgs(ip, gateway net.IP, mask net.IPMask, extra string) string {
+	args := []string{
+		"console=ttyS0", "reboot=k", "panic=1", "pci=off", "quiet",
+		"root=/dev/vda", "ro", "init=/sbin/testground-init",
+		fmt.Sprintf("ip=%s::%s:%s::eth0:off", ip, gateway, net.IP(mask)),
+	}
+	if extra = strings.TrimSpace(extra); extra != "" {
+		args = append(args, extra)
+	}
+	return strings.Join(args, " ")
+}
+
+// vmMachine returns the vCPUs and the memory, in MiB, of a microVM with
+// resources r: 1 vCPU and 512MiB when they're not set.
+func vmMachine(r api.Resources) (vcpus int, memMib int64, err error) {
+	vcpus, memMib = 1, 512
+	if r.CPU != "" {
+		q, err := resource.ParseQuantity(r.CPU)
+		if err != nil {
+			return 0, 0, err
+		}
+		vcpus = int((q.MilliValue() + 999) / 1000)
+		if vcpus < 1 {
+			vcpus = 1
+		}
+		if vcpus > vmMaxVCPUs {
+			return 0, 0, fmt.Errorf("%s CPUs exceed the %d vCPUs of a microVM", r.CPU, vmMaxVCPUs)
+		}
+	}
+	if r.Memory != "" {
+		q, err := resource.ParseQuantity(r.Memory)
+		if err != nil {
+			return 0, 0, err
+		}
+		if memMib = q.Value() >> 20; memMib < 1 {
+			return 0, 0, fmt.Errorf("memory %s is less than 1Mi", r.Memory)
+		}
+	}
+	return vcpus, memMib, nil
+}
+
+// vmMAC returns the MAC address of the microVM of the nth instance of a run,
+// a locally administered one.
+func vmMAC(n int) string {
+	return fmt.Sprintf("02:fc:00:%02x:%02x:%02x", byte(n>>16), byte(n>>8), byte(n))
+}
+
+// writePlanDrive writes the plan drive of a microVM: a tar archive holding the
+// test plan, as testplan; its environment, as env, to be sourced by a shell;
+// and its sysctls, as sysctl.conf.
+func writePlanDrive(path string, artifact string, env map[string]string, sysctls map[string]string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+
+	bin, err := os.Open(artifact)
+	if err != nil {
+		return err
+	}
+	defer bin.Close()
+	fi, err := bin.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "testplan", Mode: 0755, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, bin); err != nil {
+		return err
+	}
+
+	for name, content := range map[string]string{
+		"env":         formatEnvFile(env),
+		"sysctl.conf": formatSysctls(sysctls),
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// formatEnvFile formats environment variables as shell assignments, sorted.
+func formatEnvFile(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, shellQuote(env[k]))
+	}
+	return b.String()
+}
+
+// formatSysctls formats sysctls in the format of sysctl.conf, sorted.
+func formatSysctls(sysctls map[string]string) string {
+	keys := make([]string, 0, len(sysctls))
+	for k := range sysctls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, sysctls[k])
+	}
+	return b.String()
+}
+
+// createSparseFile creates a file of the given size, which doesn't take any
+// space until it's written to.
+func createSparseFile(path string, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// extractOutputs extracts the tar archive the init of a microVM wrote onto its
+// outputs drive. A drive left blank, by a microVM that was killed, holds an
+// empty archive.
+func extractOutputs(drive, dir string) error {
+	f, err := os.Open(drive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return untar(f, dir)
+}
+
+func (r *LocalFirecrackerRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
+	r.lk.RLock()
+	dir := r.outputsDir
+	r.lk.RUnlock()
+
+	return gzipRunOutputs(ctx, dir, input, ow)
+}
+
+func (*LocalFirecrackerRunner) ID() string {
+	return "local:firecracker"
+}
+
+func (*LocalFirecrackerRunner) ConfigType() reflect.Type {
+	return reflect.TypeOf(LocalFirecrackerRunnerCfg{})
+}
+
+func (*LocalFirecrackerRunner) CompatibleBuilders() []string {
+	return []string{"exec:go", "exec:rust"}
+}
+
+// Capabilities reports that the runner runs linux executables of the
+// architecture of the daemon, as root of their own kernel.
+func (*LocalFirecrackerRunner) Capabilities() api.Capabilities {
+	return api.Capabilities{
+		Privileged: true,
+		Artifacts:  []api.ArtifactKind{api.ArtifactExecutable},
+		Platforms:  []string{"linux/" + goruntime.GOARCH},
+	}
+}
+
+// TerminateAll is a no-op: the microVMs are killed along with the runs they
+// belong to, and the infrastructure is shared with local:exec, which
+// terminates it.
+func (*LocalFirecrackerRunner) TerminateAll(_ context.Context, ow *rpc.OutputWriter) error {
+	ow.Info("terminate local:firecracker requested; terminate local:exec to stop the infrastructure")
+	return nil
+}
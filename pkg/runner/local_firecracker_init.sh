@@ -0,0 +1,34 @@
+#!/bin/sh
+# testground-init is the init of the microVMs of the local:firecracker runner;
+# install it as /sbin/testground-init in their root filesystem. It requires a
+# shell, mount, tar and sysctl, e.g. from busybox.
+#
+# The plan drive (/dev/vdb) is a tar archive holding the test plan, its
+# environment and its sysctls. The outputs of the test plan are written as a
+# tar archive onto the outputs drive (/dev/vdc), before the microVM powers off.
+
+mount -t proc proc /proc
+mount -t sysfs sysfs /sys
+mount -t devtmpfs devtmpfs /dev 2>/dev/null
+mount -t tmpfs tmpfs /run
+mount -t tmpfs tmpfs /tmp
+
+mkdir -p /run/testground
+tar -xf /dev/vdb -C /run/testground
+
+ip link set lo up 2>/dev/null
+if [ -s /run/testground/sysctl.conf ]; then
+	sysctl -q -p /run/testground/sysctl.conf
+fi
+
+set -a
+. /run/testground/env
+set +a
+
+mkdir -p "$TEST_OUTPUTS_PATH"
+/run/testground/testplan </dev/null >/dev/console 2>&1
+echo $? >"$TEST_OUTPUTS_PATH/exit_status"
+
+tar -cf /dev/vdc -C "$TEST_OUTPUTS_PATH" .
+sync
+reboot -f
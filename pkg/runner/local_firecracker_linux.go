@@ -0,0 +1,117 @@
+//+build linux
+
+package runner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os/exec"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// vmNetwork is the network of a run of the local:firecracker runner: a bridge
+// with the first address of the subnet, in a network namespace of the run,
+// where firecracker attaches the microVMs with tap devices. Proxies listen on
+// the gateway for the ports of the infrastructure of the daemon, which the
+// microVMs reach there.
+type vmNetwork struct {
+	gateway net.IP
+
+	ns        netns.NsHandle
+	bridge    int
+	listeners []net.Listener
+}
+
+// newVMNetwork creates the network of a run in the given subnet.
+func newVMNetwork(subnet *net.IPNet) (vn *vmNetwork, err error) {
+	gateway := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(gateway, binary.BigEndian.Uint32(subnet.IP.To4())+1)
+
+	vn = &vmNetwork{gateway: gateway}
+	if vn.ns, err = newNetns(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			vn.close()
+		}
+	}()
+
+	h, err := netlink.NewHandleAt(vn.ns)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Delete()
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br0"}}
+	if err := h.LinkAdd(br); err != nil {
+		return nil, fmt.Errorf("failed to create the bridge of the run: %w", err)
+	}
+	link, err := h.LinkByName(br.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: gateway, Mask: subnet.Mask}}); err != nil {
+		return nil, err
+	}
+	if err := h.LinkSetUp(link); err != nil {
+		return nil, err
+	}
+	vn.bridge = link.Attrs().Index
+
+	// sockets belong to the namespace of the thread that opens them.
+	return vn, inNetns(vn.ns, func() error {
+		for _, port := range execProxyPorts {
+			l, err := net.Listen("tcp", net.JoinHostPort(gateway.String(), port))
+			if err != nil {
+				return err
+			}
+			vn.listeners = append(vn.listeners, l)
+			go proxyLocal(l, port)
+		}
+		return nil
+	})
+}
+
+// tapName returns the name of the tap device of the microVM of the nth
+// instance of the run.
+func (vn *vmNetwork) tapName(n int) string {
+	return fmt.Sprintf("tap%d", n)
+}
+
+// addTap creates the tap device of the microVM of the nth instance, attached
+// to the bridge. The device persists until the namespace is removed.
+func (vn *vmNetwork) addTap(n int) error {
+	return inNetns(vn.ns, func() error {
+		// the device is created in the namespace of the thread, as firecracker
+		// opens it: without packet information, with virtio-net headers.
+		tap := &netlink.Tuntap{
+			LinkAttrs: netlink.LinkAttrs{Name: vn.tapName(n), MasterIndex: vn.bridge},
+			Mode:      netlink.TUNTAP_MODE_TAP,
+			Flags:     netlink.TUNTAP_NO_PI | netlink.TUNTAP_VNET_HDR,
+		}
+		if err := netlink.LinkAdd(tap); err != nil {
+			return err
+		}
+		return netlink.LinkSetUp(tap)
+	})
+}
+
+// start starts firecracker in the namespace of the network.
+func (vn *vmNetwork) start(cmd *exec.Cmd) error {
+	return inNetns(vn.ns, cmd.Start)
+}
+
+// close closes the proxies and the namespace, which removes the bridge and the
+// tap devices once the microVMs are gone.
+func (vn *vmNetwork) close() {
+	for _, l := range vn.listeners {
+		_ = l.Close()
+	}
+	if vn.ns.IsOpen() {
+		_ = vn.ns.Close()
+	}
+}
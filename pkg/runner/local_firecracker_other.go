@@ -0,0 +1,27 @@
+//+build !linux
+
+package runner
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+)
+
+// vmNetwork is the network of a run of the local:firecracker runner, which is
+// only supported on linux.
+type vmNetwork struct {
+	gateway net.IP
+}
+
+func newVMNetwork(_ *net.IPNet) (*vmNetwork, error) {
+	return nil, errors.New("the local:firecracker runner is only supported on linux")
+}
+
+func (*vmNetwork) tapName(_ int) string { return "" }
+
+func (*vmNetwork) addTap(_ int) error { return nil }
+
+func (*vmNetwork) start(cmd *exec.Cmd) error { return cmd.Start() }
+
+func (*vmNetwork) close() {}
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+func TestVMBootArgs(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.80.0.0/16")
+	ip, err := instanceIP(subnet, 3)
+	require.NoError(t, err)
+
+	args := vmBootArgs(ip, net.ParseIP("10.80.0.1"), subnet.Mask, " mitigations=off ")
+	require.Contains(t, args, "init=/sbin/testground-init")
+	require.Contains(t, args, "ip=10.80.0.4::10.80.0.1:255.255.0.0::eth0:off")
+	require.Regexp(t, ` mitigations=off$`, args)
+}
+
+func TestVMMachine(t *testing.T) {
+	vcpus, mem, err := vmMachine(api.Resources{})
+	require.NoError(t, err)
+	require.Equal(t, 1, vcpus)
+	require.EqualValues(t, 512, mem)
+
+	// CPUs are rounded up to vCPUs.
+	vcpus, mem, err = vmMachine(api.Resources{CPU: "1500m", Memory: "2Gi"})
+	require.NoError(t, err)
+	require.Equal(t, 2, vcpus)
+	require.EqualValues(t, 2048, mem)
+
+	_, _, err = vmMachine(api.Resources{CPU: "64"})
+	require.Error(t, err)
+	_, _, err = vmMachine(api.Resources{Memory: "512Ki"})
+	require.Error(t, err)
+}
+
+func TestWritePlanDrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan-drive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	artifact := filepath.Join(dir, "plan")
+	require.NoError(t, ioutil.WriteFile(artifact, []byte("#!/bin/sh\n"), 0755))
+
+	drive := filepath.Join(dir, "plan.tar")
+	err = writePlanDrive(drive, artifact,
+		map[string]string{"TEST_CASE": "ping", "TEST_INSTANCE_PARAMS": "msg=it's"},
+		map[string]string{"net.core.rmem_max": "4194304"})
+	require.NoError(t, err)
+
+	out := filepath.Join(dir, "out")
+	require.NoError(t, extractOutputs(drive, out))
+
+	env, err := ioutil.ReadFile(filepath.Join(out, "env"))
+	require.NoError(t, err)
+	require.Equal(t, "TEST_CASE='ping'\nTEST_INSTANCE_PARAMS='msg=it'\\''s'\n", string(env))
+
+	sysctls, err := ioutil.ReadFile(filepath.Join(out, "sysctl.conf"))
+	require.NoError(t, err)
+	require.Equal(t, "net.core.rmem_max = 4194304\n", string(sysctls))
+
+	fi, err := os.Stat(filepath.Join(out, "testplan"))
+	require.NoError(t, err)
+	require.EqualValues(t, 0755, fi.Mode().Perm())
+}
+
+func TestExtractOutputsOfBlankDrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputs-drive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a microVM killed before it wrote its outputs leaves the drive blank.
+	drive := filepath.Join(dir, "outputs.img")
+	require.NoError(t, createSparseFile(drive, 1<<20))
+	require.NoError(t, extractOutputs(drive, filepath.Join(dir, "out")))
+
+	// archives can't write outside of the outputs dir.
+	f, err := os.Create(drive)
+	require.NoError(t, err)
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0644, Typeflag: tar.TypeReg}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+	require.Error(t, extractOutputs(drive, filepath.Join(dir, "out")))
+}
+
+func TestVMMAC(t *testing.T) {
+	require.Equal(t, "02:fc:00:00:01:2c", vmMAC(300))
+	_, err := net.ParseMAC(vmMAC(65533))
+	require.NoError(t, err)
+}
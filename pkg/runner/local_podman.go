@@ -0,0 +1,120 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/testground/testground/pkg/api"
+	"github.com/testground/testground/pkg/rpc"
+
+	"github.com/docker/docker/client"
+)
+
+var (
+	_ api.Runner        = (*LocalPodmanRunner)(nil)
+	_ api.Healthchecker = (*LocalPodmanRunner)(nil)
+	_ api.Terminatable  = (*LocalPodmanRunner)(nil)
+	_ api.Capable       = (*LocalPodmanRunner)(nil)
+	_ api.Cleaner       = (*LocalPodmanRunner)(nil)
+)
+
+// podmanEngine is podman, through the Docker-compatible API of its service,
+// e.g. started with `systemctl --user start podman.socket`.
+//
+// Rootless containers can't raise their limits above those of the user, so
+// they inherit them unless the ulimits are configured.
+var podmanEngine = &containerEngine{
+	runner:   "local:podman",
+	daemon:   "podman-service",
+	cli:      "podman",
+	outputs:  "local_podman",
+	defaults: LocalDockerRunnerConfig{},
+	connect: func() (*client.Client, error) {
+		return client.NewClientWithOpts(client.WithHost(podmanHost()), client.WithAPIVersionNegotiation())
+	},
+}
+
+// podmanHost returns the address of the podman service: CONTAINER_HOST, like
+// the podman remote client, or else the socket of the user's service, or of
+// the system one for root.
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if os.Geteuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+	return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+}
+
+// LocalPodmanRunner is a runner that stands up podman containers, with the
+// same semantics as local:docker: the control and data networks, the
+// sidecar, and the redis and sync service containers, managed by podman
+// instead of docker. The sidecar manages the containers through the podman
+// socket, which it sees as the docker one.
+//
+// Images are built by the docker builders, which must push them to podman:
+// point DOCKER_HOST to the podman socket.
+type LocalPodmanRunner struct {
+	once   sync.Once
+	docker LocalDockerRunner
+}
+
+func (r *LocalPodmanRunner) runner() *LocalDockerRunner {
+	r.once.Do(func() {
+		r.docker.engine = podmanEngine
+	})
+	return &r.docker
+}
+
+func (r *LocalPodmanRunner) Healthcheck(ctx context.Context, engine api.Engine, ow *rpc.OutputWriter, fix bool) (*api.HealthcheckReport, error) {
+	return r.runner().Healthcheck(ctx, engine, ow, fix)
+}
+
+func (r *LocalPodmanRunner) Run(ctx context.Context, input *api.RunInput, ow *rpc.OutputWriter) (*api.RunOutput, error) {
+	return r.runner().Run(ctx, input, ow)
+}
+
+func (r *LocalPodmanRunner) CollectOutputs(ctx context.Context, input *api.CollectionInput, ow *rpc.OutputWriter) error {
+	return r.runner().CollectOutputs(ctx, input, ow)
+}
+
+func (r *LocalPodmanRunner) TerminateAll(ctx context.Context, ow *rpc.OutputWriter) error {
+	return r.runner().TerminateAll(ctx, ow)
+}
+
+func (r *LocalPodmanRunner) Cleanup(ctx context.Context, runID string, ow *rpc.OutputWriter) error {
+	return r.runner().Cleanup(ctx, runID, ow)
+}
+
+func (r *LocalPodmanRunner) Leftovers(ctx context.Context) ([]string, error) {
+	return r.runner().Leftovers(ctx)
+}
+
+func (*LocalPodmanRunner) ID() string {
+	return "local:podman"
+}
+
+func (*LocalPodmanRunner) ConfigType() reflect.Type {
+	return reflect.TypeOf(LocalDockerRunnerConfig{})
+}
+
+func (r *LocalPodmanRunner) CompatibleBuilders() []string {
+	return r.runner().CompatibleBuilders()
+}
+
+// Capabilities are those of local:docker, but GPUs, which podman doesn't
+// provide through the docker API.
+func (r *LocalPodmanRunner) Capabilities() api.Capabilities {
+	caps := r.runner().Capabilities()
+	caps.GPUs = false
+	return caps
+}
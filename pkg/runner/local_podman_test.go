@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, prev)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestPodmanHost(t *testing.T) {
+	setenv(t, "CONTAINER_HOST", "")
+	setenv(t, "XDG_RUNTIME_DIR", "/run/user/1000")
+	if os.Geteuid() == 0 {
+		require.Equal(t, "unix:///run/podman/podman.sock", podmanHost())
+	} else {
+		require.Equal(t, "unix:///run/user/1000/podman/podman.sock", podmanHost())
+	}
+
+	setenv(t, "CONTAINER_HOST", "tcp://podman:8080")
+	require.Equal(t, "tcp://podman:8080", podmanHost())
+}
+
+func TestPodmanRunner(t *testing.T) {
+	r := &LocalPodmanRunner{}
+	require.Equal(t, "local:podman", r.ID())
+	require.Same(t, podmanEngine, r.runner().containers())
+	require.Same(t, dockerEngine, (&LocalDockerRunner{}).containers())
+	require.Equal(t, (&LocalDockerRunner{}).CompatibleBuilders(), r.CompatibleBuilders())
+}
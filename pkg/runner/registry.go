@@ -2,6 +2,9 @@ package runner
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/docker"
@@ -9,34 +12,56 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	lru "github.com/hashicorp/golang-lru"
 )
 
-func (c *ClusterK8sRunner) pushToDockerRegistry(ctx context.Context, ow *rpc.OutputWriter, client *client.Client, in *api.RunInput, ipo types.ImagePushOptions, uri string) error {
+// pushImages pushes the images of the groups of the run to the registry of
+// the provider, aws, dockerhub or generic, so that the nodes of a cluster can pull
+// them, and replaces the artifact paths of the groups by the pushed images.
+// Images already pushed are remembered in the cache.
+func pushImages(ctx context.Context, ow *rpc.OutputWriter, images *lru.Cache, in *api.RunInput, provider string) error {
+	// Create a docker client.
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+
+	start := time.Now()
+	ow.Info("pushing images")
+	defer func() { ow.Infow("pushing of images finished", "took", time.Since(start).Truncate(time.Second)) }()
+
+	// Setup docker registry authentication, and the repository to push to.
+	auth, uri, err := docker.RegistryAuth(in.EnvConfig, provider, in.TestPlan)
+	if err != nil {
+		return err
+	}
+	ow.Infow("acquired registry credentials", "provider", provider, "repository", uri)
+
+	return pushToDockerRegistry(ctx, ow, cli, images, in, types.ImagePushOptions{RegistryAuth: auth}, uri)
+}
+
+func pushToDockerRegistry(ctx context.Context, ow *rpc.OutputWriter, client *client.Client, images *lru.Cache, in *api.RunInput, ipo types.ImagePushOptions, uri string) error {
 	for _, g := range in.Groups {
+		if strings.Contains(g.ArtifactPath, "/") {
+			// multi-platform images are pushed by the builders.
+			ow.Infow("image already in a registry", "group_id", g.ID, "image", g.ArtifactPath)
+			continue
+		}
+
 		tag := uri + ":" + g.ArtifactPath
 
-		if _, ok := c.imagesLRU.Get(tag); ok {
+		if _, ok := images.Get(tag); ok {
 			ow.Infow("image already pushed and tagged", "group_id", g.ID, "tag", tag)
 			g.ArtifactPath = tag
 			continue
 		}
 
-		ow.Infow("tagging image", "group_id", g.ID, "tag", tag)
-		if err := client.ImageTag(ctx, g.ArtifactPath, tag); err != nil {
-			return err
-		}
-
 		ow.Infow("pushing image for group", "group_id", g.ID, "tag", tag)
-		rc, err := client.ImagePush(ctx, tag, ipo)
-		if err != nil {
-			return err
-		}
-
-		if _, err := docker.PipeOutput(rc, ow.StdoutWriter()); err != nil {
+		if err := docker.PushImage(ctx, ow, client, g.ArtifactPath, tag, ipo.RegistryAuth); err != nil {
 			return err
 		}
 
-		c.imagesLRU.Add(tag, struct{}{})
+		images.Add(tag, struct{}{})
 
 		// replace the artifact path by the pushed image.
 		g.ArtifactPath = tag
@@ -0,0 +1,122 @@
+package sidecar
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// packetSource is a source of the packets of a link.
+type packetSource interface {
+	// ReadPacket returns the next packet, truncated to the snaplen of the
+	// source, its length and the time it was captured at, or no packet if
+	// none came in for a while.
+	ReadPacket() (data []byte, origLen int, ts time.Time, err error)
+	Close() error
+}
+
+// packetCapturer is implemented by the networks that the sidecar can capture
+// the packets of.
+type packetCapturer interface {
+	openCapture(network string, snaplen int) (packetSource, error)
+}
+
+// capture is a capture of the packets of an instance in progress.
+type capture struct {
+	done    chan struct{}
+	stopped chan error
+}
+
+// startCapture starts capturing the packets of the data network of the
+// instance, to the writer, until stopped.
+func startCapture(instance *Instance, spec *api.CaptureSpec, w *pcapWriter) (*capture, error) {
+	pc, ok := instance.Network.(packetCapturer)
+	if !ok {
+		return nil, fmt.Errorf("packet capture not supported by this sidecar")
+	}
+	src, err := pc.openCapture(defaultDataNetwork, spec.Snaplen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the packet capture: %w", err)
+	}
+
+	c := &capture{done: make(chan struct{}), stopped: make(chan error, 1)}
+	go func() {
+		defer src.Close()
+		for {
+			select {
+			case <-c.done:
+				c.stopped <- w.Close()
+				return
+			default:
+			}
+
+			data, n, ts, err := src.ReadPacket()
+			if err == nil && data != nil {
+				err = w.WritePacket(ts, data, n)
+			}
+			if err != nil {
+				w.Close()
+				c.stopped <- err
+				<-c.done
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// stop stops the capture, and returns the error it failed with, if any.
+func (c *capture) stop() error {
+	close(c.done)
+	return <-c.stopped
+}
+
+// captures are the captures of the packets of an instance: the one of its
+// group, and those that the test case requests, one at a time, all written to
+// the outputs of the instance.
+type captures struct {
+	instance *Instance
+	spec     *api.CaptureSpec
+	w        *pcapWriter
+	cur      *capture
+}
+
+func newCaptures(instance *Instance) *captures {
+	spec := instance.Capture
+	if spec == nil {
+		spec = &api.DefaultCaptureSpec
+	}
+	return &captures{
+		instance: instance,
+		spec:     spec,
+		w:        newPcapWriter(instance.OutputsDir, spec.MaxFileSize, spec.MaxFiles, spec.Snaplen),
+	}
+}
+
+// start stops the capture in progress, if any, and starts one lasting d, or
+// until the end of the run if 0; the returned channel fires once it's over.
+func (c *captures) start(d time.Duration) (<-chan time.Time, error) {
+	c.stop()
+	cur, err := startCapture(c.instance, c.spec, c.w)
+	if err != nil {
+		return nil, err
+	}
+	c.cur = cur
+	c.instance.S().Infow("capturing packets", "instance", c.instance.Hostname, "dir", c.instance.OutputsDir, "duration", d)
+	if d == 0 {
+		return nil, nil
+	}
+	return time.After(d), nil
+}
+
+// stop stops the capture in progress, if any.
+func (c *captures) stop() {
+	if c.cur == nil {
+		return
+	}
+	if err := c.cur.stop(); err != nil {
+		c.instance.S().Warnw("packet capture failed", "instance", c.instance.Hostname, "err", err)
+	}
+	c.cur = nil
+}
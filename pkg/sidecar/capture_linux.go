@@ -0,0 +1,106 @@
+//+build linux
+
+package sidecar
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// packetSocket is a packet socket bound to a link of the network namespace of
+// an instance.
+type packetSocket struct {
+	fd  int
+	buf []byte
+}
+
+var _ packetSource = (*packetSocket)(nil)
+
+func htons(i uint16) uint16 {
+	return i<<8 | i>>8
+}
+
+// openPacketSocket opens a packet socket capturing the packets of the link
+// with the index, in the network namespace at netnsPath.
+func openPacketSocket(netnsPath string, ifindex, snaplen int) (*packetSocket, error) {
+	// The socket is created in the network namespace of the thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer orig.Close()
+
+	target, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the net namespace: %w", err)
+	}
+	defer target.Close()
+
+	if err := netns.Set(target); err != nil {
+		return nil, err
+	}
+	defer func() { _ = netns.Set(orig) }()
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	// Reads time out, for captures to be stopped.
+	tv := unix.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &packetSocket{fd: fd, buf: make([]byte, snaplen)}, nil
+}
+
+func (s *packetSocket) ReadPacket() ([]byte, int, time.Time, error) {
+	// with MSG_TRUNC, the length of the packet is returned, rather than the
+	// length read.
+	n, _, err := unix.Recvfrom(s.fd, s.buf, unix.MSG_TRUNC)
+	switch err {
+	case nil:
+	case unix.EAGAIN, unix.EINTR:
+		return nil, 0, time.Time{}, nil
+	default:
+		return nil, 0, time.Time{}, err
+	}
+
+	data := s.buf
+	if n < len(data) {
+		data = data[:n]
+	}
+	return data, n, time.Now(), nil
+}
+
+func (s *packetSocket) Close() error {
+	return unix.Close(s.fd)
+}
+
+func (dn *DockerNetwork) openCapture(network string, snaplen int) (packetSource, error) {
+	link, ok := dn.activeLinks[network]
+	if !ok {
+		return nil, fmt.Errorf("not connected to network %s", network)
+	}
+	return openPacketSocket(dn.netnsPath, link.Attrs().Index, snaplen)
+}
+
+func (n *K8sNetwork) openCapture(network string, snaplen int) (packetSource, error) {
+	link, ok := n.activeLinks[network]
+	if !ok {
+		return nil, fmt.Errorf("not connected to network %s", network)
+	}
+	return openPacketSocket(n.netnsPath, link.Attrs().Index, snaplen)
+}
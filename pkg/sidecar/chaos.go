@@ -0,0 +1,276 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"github.com/testground/sdk-go/sync"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// containerOp is an operation on the container of an instance.
+type containerOp string
+
+const (
+	opKill    = containerOp("kill")
+	opPause   = containerOp("pause")
+	opUnpause = containerOp("unpause")
+	opRestart = containerOp("restart")
+)
+
+// containerController is implemented by the networks of the instances whose
+// containers the sidecar can kill, pause and restart.
+type containerController interface {
+	controlContainer(ctx context.Context, op containerOp) error
+}
+
+// chaosFillFile is the prefix of the files of the disk fills, in the
+// filesystem of the instance.
+const chaosFillFile = "/tmp/testground-chaos-fill-"
+
+// chaos injects faults to an instance, and records them to its outputs.
+type chaos struct {
+	instance *Instance
+
+	lk    gosync.Mutex
+	f     *os.File
+	fills map[string]bool // the files of the disk fills in progress.
+	n     int             // the number of disk fills.
+	skews int             // the number of clock skews signalled.
+}
+
+func newChaos(instance *Instance) *chaos {
+	return &chaos{instance: instance, fills: make(map[string]bool)}
+}
+
+// schedule injects a fault at its time, if the instance is one of the
+// instances of its group that it targets, as counted on state.
+func (c *chaos) schedule(ctx context.Context, a *api.ChaosAction, state, source string) error {
+	seq, err := c.instance.Client.SignalEntry(ctx, sync.State(state))
+	if err != nil {
+		return fmt.Errorf("failed to signal chaos state %s: %w", state, err)
+	}
+	if seq > int64(a.Instances(c.instance.RunEnv.TestGroupInstanceCount)) {
+		return nil
+	}
+
+	go func() {
+		t := time.NewTimer(a.At)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		c.inject(ctx, a, source)
+	}()
+	return nil
+}
+
+// inject injects a fault now, and records it.
+func (c *chaos) inject(ctx context.Context, a *api.ChaosAction, source string) {
+	c.instance.S().Infow("injecting chaos", "instance", c.instance.Hostname, "action", a.Action, "source", source)
+	ev := &api.ChaosEvent{
+		Time:     time.Now(),
+		Action:   a.Action,
+		Duration: a.Duration,
+		Skew:     a.Skew,
+		Size:     a.Size,
+		Source:   source,
+	}
+
+	switch a.Action {
+	case api.ChaosKill, api.ChaosRestart:
+		// the outputs of the instance go away with it, so the fault is
+		// recorded first.
+		c.record(ev)
+		if err := c.execute(ctx, a); err != nil {
+			c.instance.S().Warnw("failed to inject chaos", "instance", c.instance.Hostname, "action", a.Action, "err", err)
+		}
+	case api.ChaosClockSignal:
+		// the instance applies the skew itself, if it does, so the fault is
+		// recorded once it acknowledges it.
+		n, err := c.signalClock(ctx, a)
+		if err != nil {
+			c.instance.S().Warnw("failed to inject chaos", "instance", c.instance.Hostname, "action", a.Action, "err", err)
+			ev.Error = err.Error()
+			c.record(ev)
+			return
+		}
+		go func() {
+			state := sync.State(api.ChaosClockAckState + c.instance.Hostname)
+			b, err := c.instance.Client.Barrier(ctx, state, n)
+			if err == nil {
+				err = <-b.C
+			}
+			if err != nil {
+				c.instance.S().Debugw("clock skew not acknowledged", "instance", c.instance.Hostname, "err", err)
+				return
+			}
+			ev.Time = time.Now()
+			c.record(ev)
+		}()
+	default:
+		if err := c.execute(ctx, a); err != nil {
+			c.instance.S().Warnw("failed to inject chaos", "instance", c.instance.Hostname, "action", a.Action, "err", err)
+			ev.Error = err.Error()
+		}
+		c.record(ev)
+	}
+}
+
+func (c *chaos) execute(ctx context.Context, a *api.ChaosAction) error {
+	switch a.Action {
+	case api.ChaosKill:
+		return c.control(ctx, opKill)
+
+	case api.ChaosRestart:
+		return c.control(ctx, opRestart)
+
+	case api.ChaosPause:
+		if err := c.control(ctx, opPause); err != nil {
+			return err
+		}
+		// the instance is unpaused even if the sidecar stops managing it.
+		time.AfterFunc(a.Duration, func() {
+			if err := c.control(context.Background(), opUnpause); err != nil {
+				c.instance.S().Warnw("failed to unpause instance", "instance", c.instance.Hostname, "err", err)
+			}
+		})
+		return nil
+
+	case api.ChaosDiskFill:
+		path, err := c.fill(a.Size)
+		if err != nil {
+			return err
+		}
+		if a.Duration > 0 {
+			time.AfterFunc(a.Duration, func() { c.unfill(path) })
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown chaos action %s", a.Action)
+	}
+}
+
+// signalClock publishes the skew of the clock of the instance, and returns the
+// number of skews signalled so far, which the instance acknowledges.
+func (c *chaos) signalClock(ctx context.Context, a *api.ChaosAction) (int, error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	topic := sync.NewTopic(api.ChaosClockTopic+c.instance.Hostname, api.ClockSkew{})
+	if _, err := c.instance.Client.Publish(ctx, topic, &api.ClockSkew{Skew: a.Skew}); err != nil {
+		return 0, fmt.Errorf("failed to publish the clock skew: %w", err)
+	}
+	c.skews++
+	if a.Duration > 0 {
+		time.AfterFunc(a.Duration, func() {
+			_, _ = c.instance.Client.Publish(ctx, topic, &api.ClockSkew{})
+		})
+	}
+	return c.skews, nil
+}
+
+func (c *chaos) control(ctx context.Context, op containerOp) error {
+	cc, ok := c.instance.Network.(containerController)
+	if !ok {
+		return fmt.Errorf("%s not supported by this sidecar", op)
+	}
+	return cc.controlContainer(ctx, op)
+}
+
+// fill writes a file of size bytes to the filesystem of the instance, or as
+// much as fits, and returns its path.
+func (c *chaos) fill(size int64) (string, error) {
+	if c.instance.RootDir == "" {
+		return "", fmt.Errorf("disk fill not supported by this sidecar")
+	}
+
+	c.lk.Lock()
+	path := filepath.Join(c.instance.RootDir, chaosFillFile+strconv.Itoa(c.n))
+	c.fills[path] = true
+	c.n++
+	c.lk.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 1<<20)
+	for n := int64(0); n < size; n += int64(len(buf)) {
+		if size-n < int64(len(buf)) {
+			buf = buf[:size-n]
+		}
+		if _, err := f.Write(buf); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				// the disk is full.
+				break
+			}
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+func (c *chaos) unfill(path string) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if !c.fills[path] {
+		return
+	}
+	delete(c.fills, path)
+	if err := os.Remove(path); err != nil {
+		c.instance.S().Warnw("failed to remove disk fill", "instance", c.instance.Hostname, "err", err)
+	}
+}
+
+// record appends an event to the ChaosOutput of the instance.
+func (c *chaos) record(ev *api.ChaosEvent) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.f == nil {
+		f, err := os.OpenFile(filepath.Join(c.instance.OutputsDir, api.ChaosOutput), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			c.instance.S().Warnw("failed to record chaos", "instance", c.instance.Hostname, "err", err)
+			return
+		}
+		c.f = f
+	}
+	if err := json.NewEncoder(c.f).Encode(ev); err != nil {
+		c.instance.S().Warnw("failed to record chaos", "instance", c.instance.Hostname, "err", err)
+	}
+}
+
+// Close removes the disk fills left, and closes the record of the faults.
+func (c *chaos) Close() error {
+	c.lk.Lock()
+	fills := make([]string, 0, len(c.fills))
+	for path := range c.fills {
+		fills = append(fills, path)
+	}
+	c.lk.Unlock()
+	for _, path := range fills {
+		c.unfill(path)
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	return c.f.Close()
+}
@@ -0,0 +1,35 @@
+//+build linux
+
+package sidecar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/testground/testground/pkg/docker"
+)
+
+// controlDockerContainer operates the container of an instance through the
+// docker daemon.
+func controlDockerContainer(ctx context.Context, c *docker.ContainerRef, op containerOp) error {
+	switch op {
+	case opKill:
+		return c.Manager.ContainerKill(ctx, c.ID, "SIGKILL")
+	case opPause:
+		return c.Manager.ContainerPause(ctx, c.ID)
+	case opUnpause:
+		return c.Manager.ContainerUnpause(ctx, c.ID)
+	case opRestart:
+		return c.Manager.ContainerRestart(ctx, c.ID, nil)
+	default:
+		return fmt.Errorf("unknown container operation %s", op)
+	}
+}
+
+func (dn *DockerNetwork) controlContainer(ctx context.Context, op containerOp) error {
+	return controlDockerContainer(ctx, dn.container, op)
+}
+
+func (n *K8sNetwork) controlContainer(ctx context.Context, op containerOp) error {
+	return controlDockerContainer(ctx, n.container, op)
+}
@@ -0,0 +1,251 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/sync"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// dnsListener is implemented by the networks that the sidecar can serve the
+// DNS view of the data network on, in the network namespace of the instance.
+type dnsListener interface {
+	listenDNS() (net.PacketConn, error)
+}
+
+// dnsServer serves the DNS view of the data network to an instance: the names
+// of the instances of the run, resolved to their addresses, but for the names
+// that fail.
+type dnsServer struct {
+	domain string
+
+	lk       gosync.Mutex
+	records  map[string][]net.IP       // by name, fully qualified.
+	groups   map[string][]*peerAddress // the instances, by group.
+	failures map[string]api.DNSFailure // by name, fully qualified.
+}
+
+func newDNSServer(spec *api.DNSSpec) *dnsServer {
+	s := &dnsServer{
+		domain:  spec.Domain,
+		records: make(map[string][]net.IP),
+		groups:  make(map[string][]*peerAddress),
+	}
+	s.setFailures(spec.Failures)
+	return s
+}
+
+// add adds the peer to the instances of its group, and names them again: the
+// instances are numbered in the order of their addresses, which doesn't depend
+// on when their sidecars publish them, and lets an instance find its own name.
+func (s *dnsServer) add(p *peerAddress) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	group := strings.ToLower(p.Group)
+	peers := append(s.groups[group], p)
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peerKey(peers[i]), peerKey(peers[j])) < 0
+	})
+	s.groups[group] = peers
+
+	name := group + "." + s.domain + "."
+	delete(s.records, name)
+	for n, p := range peers {
+		instance := group + "-" + strconv.Itoa(n+1) + "." + s.domain + "."
+		delete(s.records, instance)
+		for _, ip := range []*ptypes.IPNet{p.IPv4, p.IPv6} {
+			if ip == nil {
+				continue
+			}
+			s.records[instance] = append(s.records[instance], ip.IP)
+			s.records[name] = append(s.records[name], ip.IP)
+		}
+	}
+}
+
+// peerKey returns the key that the instances of a group are ordered by: their
+// IPv4 address, or their IPv6 one.
+func peerKey(p *peerAddress) []byte {
+	if p.IPv4 != nil {
+		return p.IPv4.IP.To16()
+	}
+	if p.IPv6 != nil {
+		return p.IPv6.IP.To16()
+	}
+	return nil
+}
+
+// setFailures replaces the failures of the names.
+func (s *dnsServer) setFailures(failures []api.DNSFailure) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.failures = make(map[string]api.DNSFailure, len(failures))
+	for _, f := range failures {
+		s.failures[s.qualify(f.Name)] = f
+	}
+}
+
+// qualify returns the fully qualified name of a name relative to the domain,
+// unless it ends with it.
+func (s *dnsServer) qualify(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != s.domain && !strings.HasSuffix(name, "."+s.domain) {
+		name += "." + s.domain
+	}
+	return name + "."
+}
+
+// answer returns the answer to a query, and the time to delay it by; no answer
+// if the query is malformed, or times out.
+func (s *dnsServer) answer(query []byte) (answer []byte, delay time.Duration) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, 0
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, 0
+	}
+
+	name := strings.ToLower(q.Name.String())
+	hdr := dnsmessage.Header{
+		ID:               h.ID,
+		Response:         true,
+		OpCode:           h.OpCode,
+		Authoritative:    true,
+		RecursionDesired: h.RecursionDesired,
+		RCode:            dnsmessage.RCodeSuccess,
+	}
+
+	s.lk.Lock()
+	f, failed := s.failures[name]
+	ips := s.records[name]
+	s.lk.Unlock()
+
+	switch {
+	case h.OpCode != 0:
+		hdr.RCode = dnsmessage.RCodeNotImplemented
+	case failed && f.Error == api.DNSTimeout:
+		return nil, f.Delay
+	case failed && f.Error == api.DNSNXDomain:
+		hdr.RCode = dnsmessage.RCodeNameError
+	case failed && f.Error == api.DNSServFail:
+		hdr.RCode = dnsmessage.RCodeServerFailure
+	case name != s.domain+"." && !strings.HasSuffix(name, "."+s.domain+"."):
+		hdr.RCode = dnsmessage.RCodeRefused
+	case len(ips) == 0 && name != s.domain+".":
+		hdr.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), hdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0
+	}
+	if err := b.Question(q); err != nil {
+		return nil, 0
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, 0
+	}
+	if hdr.RCode == dnsmessage.RCodeSuccess {
+		// the answers aren't cached, for the failures to apply at once.
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET}
+		for _, ip := range ips {
+			switch ip4 := ip.To4(); {
+			case ip4 != nil && q.Type == dnsmessage.TypeA:
+				var r dnsmessage.AResource
+				copy(r.A[:], ip4)
+				err = b.AResource(rh, r)
+			case ip4 == nil && q.Type == dnsmessage.TypeAAAA:
+				var r dnsmessage.AAAAResource
+				copy(r.AAAA[:], ip.To16())
+				err = b.AAAAResource(rh, r)
+			}
+			if err != nil {
+				return nil, 0
+			}
+		}
+	}
+	answer, err = b.Finish()
+	if err != nil {
+		return nil, 0
+	}
+	return answer, f.Delay
+}
+
+// serve answers the queries of the instance on conn, until the context is
+// done.
+func (s *dnsServer) serve(ctx context.Context, conn net.PacketConn) {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		answer, delay := s.answer(buf[:n])
+		if answer == nil {
+			continue
+		}
+		if delay == 0 {
+			_, _ = conn.WriteTo(answer, addr)
+			continue
+		}
+		time.AfterFunc(delay, func() {
+			_, _ = conn.WriteTo(answer, addr)
+		})
+	}
+}
+
+// startDNS serves the DNS view of the data network to the instance, once it
+// received the addresses of all the instances, until the context is done.
+func startDNS(ctx context.Context, instance *Instance) (*dnsServer, error) {
+	dl, ok := instance.Network.(dnsListener)
+	if !ok {
+		return nil, fmt.Errorf("dns not supported by this sidecar")
+	}
+
+	s := newDNSServer(instance.DNS)
+	total := instance.RunEnv.TestInstanceCount
+	peers := make(chan *peerAddress, total)
+	sub, err := instance.Client.Subscribe(ctx, sync.NewTopic(peerAddressesTopic, peerAddress{}), peers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to the addresses of the instances: %w", err)
+	}
+	for i := 0; i < total; i++ {
+		select {
+		case p := <-peers:
+			s.add(p)
+		case err := <-sub.Done():
+			return nil, fmt.Errorf("failed to receive the addresses of the instances: %w", err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	conn, err := dl.listenDNS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve dns: %w", err)
+	}
+	go s.serve(ctx, conn)
+	return s, nil
+}
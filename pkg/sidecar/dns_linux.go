@@ -0,0 +1,48 @@
+//+build linux
+
+package sidecar
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netns"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// listenDNSIn listens for the DNS queries of an instance on api.DNSAddress, in
+// the network namespace at netnsPath.
+func listenDNSIn(netnsPath string) (net.PacketConn, error) {
+	// The socket is created in the network namespace of the thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer orig.Close()
+
+	target, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup the net namespace: %w", err)
+	}
+	defer target.Close()
+
+	if err := netns.Set(target); err != nil {
+		return nil, err
+	}
+	defer func() { _ = netns.Set(orig) }()
+
+	return net.ListenPacket("udp", api.DNSAddress)
+}
+
+func (dn *DockerNetwork) listenDNS() (net.PacketConn, error) {
+	return listenDNSIn(dn.netnsPath)
+}
+
+func (n *K8sNetwork) listenDNS() (net.PacketConn, error) {
+	return listenDNSIn(n.netnsPath)
+}
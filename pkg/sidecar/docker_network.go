@@ -25,6 +25,7 @@ type DockerNetwork struct {
 	availableLinks  map[string]string      // name -> id
 	externalRouting map[string]*route      // id -> routes
 	nl              *netlink.Handle
+	netnsPath       string
 }
 
 func (dn *DockerNetwork) Close() error {
@@ -48,6 +49,21 @@ func (dn *DockerNetwork) ListActive() []string {
 	return networks
 }
 
+func (dn *DockerNetwork) Addresses(network string) (ipv4, ipv6 *net.IPNet) {
+	if link, ok := dn.activeLinks[network]; ok {
+		return link.IPv4, link.IPv6
+	}
+	return nil, nil
+}
+
+func (dn *DockerNetwork) readStats(network string) (*linkStats, error) {
+	link, ok := dn.activeLinks[network]
+	if !ok {
+		return nil, fmt.Errorf("not connected to network %s", network)
+	}
+	return readLinkStats(dn.nl, dn.netnsPath, link.Attrs().Index)
+}
+
 func (dn *DockerNetwork) ConfigureNetwork(ctx context.Context, cfg *sdknw.Config) error {
 	netId, available := dn.availableLinks[cfg.Network]
 	if !available {
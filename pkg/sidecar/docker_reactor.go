@@ -34,7 +34,7 @@ import (
 var PublicAddr = net.ParseIP("1.1.1.1")
 
 type DockerReactor struct {
-	client sync.Client
+	clients *runSyncClients
 	gosync.Mutex
 	servicesRoutes []net.IP
 	manager        *docker.Manager
@@ -55,7 +55,7 @@ func NewDockerReactor() (Reactor, error) {
 	cache, _ := lru.New(32)
 
 	r := &DockerReactor{
-		client:      client,
+		clients:     newRunSyncClients(client),
 		manager:     docker,
 		runidsCache: cache,
 	}
@@ -120,7 +120,7 @@ func (d *DockerReactor) Handle(globalctx context.Context, handler InstanceHandle
 func (d *DockerReactor) Close() error {
 	var err *multierror.Error
 	err = multierror.Append(err, d.manager.Close())
-	err = multierror.Append(err, d.client.Close())
+	err = multierror.Append(err, d.clients.Close())
 	return err.ErrorOrNil()
 }
 
@@ -155,7 +155,43 @@ func (d *DockerReactor) handleContainer(ctx context.Context, container *docker.C
 	// Resolve allowed services, so that we update network routes
 	d.ResolveServices(params.TestRun)
 
-	// Remove the TestOutputsPath. We can't store anything from the sidecar.
+	// Runs that were given their own sync service are signalled on it, and
+	// routed to it.
+	d.Lock()
+	syncHost := d.clients.runHost(info.Config.Env)
+	client, err := d.clients.client(syncHost)
+	servicesRoutes := append([]net.IP(nil), d.servicesRoutes...)
+	d.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the sync service %s: %w", syncHost, err)
+	}
+	if syncHost != "" {
+		ip, err := net.ResolveIPAddr("ip4", syncHost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve the sync service %s: %w", syncHost, err)
+		}
+		servicesRoutes = append(servicesRoutes, ip.IP)
+	}
+
+	// Groups may declare the shape of their network in the composition.
+	gn, err := networkProfile(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+	subnet6, err := subnetV6(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+	chaos, err := chaosActions(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the TestOutputsPath. We can't store anything from the sidecar,
+	// but packet captures, which go to the outputs of the instance, as seen
+	// through its root.
+	rootDir := fmt.Sprintf("/proc/%d/root", info.State.Pid)
+	outputsDir := rootDir + params.TestOutputsPath
 	params.TestOutputsPath = ""
 	runenv := runtime.NewRunEnv(*params)
 
@@ -202,10 +238,11 @@ func (d *DockerReactor) handleContainer(ctx context.Context, container *docker.C
 		availableLinks:  make(map[string]string, len(networks)),
 		externalRouting: map[string]*route{},
 		nl:              netlinkHandle,
+		netnsPath:       fmt.Sprintf("/proc/%d/ns/net", info.State.Pid),
 	}
 
 	// Retrieve control routes.
-	controlRoutes, err := getControlRoutes(d.servicesRoutes, container.ID, netlinkHandle)
+	controlRoutes, err := getControlRoutes(servicesRoutes, container.ID, netlinkHandle)
 	if err != nil {
 		return nil, err
 	}
@@ -261,7 +298,21 @@ func (d *DockerReactor) handleContainer(ctx context.Context, container *docker.C
 		}
 	}
 
-	return NewInstance(d.client, runenv, info.Config.Hostname, network)
+	if gn.NAT != "" {
+		if err := setupNAT(network.netnsPath, gn.NAT, &runenv.TestSubnet.IPNet, subnet6); err != nil {
+			return nil, err
+		}
+	}
+
+	inst, err = NewInstance(client, runenv, info.Config.Hostname, network)
+	if err != nil {
+		return nil, err
+	}
+	inst.groupNetwork = *gn
+	inst.OutputsDir = outputsDir
+	inst.RootDir = rootDir
+	inst.Chaos = chaos
+	return inst, nil
 }
 
 func getNetworkHandlers(pid int) (netns.NsHandle, *netlink.Handle, error) {
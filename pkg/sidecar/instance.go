@@ -2,12 +2,18 @@ package sidecar
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/testground/sdk-go/network"
 	"github.com/testground/sdk-go/runtime"
 	"github.com/testground/sdk-go/sync"
 
+	"github.com/testground/testground/pkg/api"
 	"github.com/testground/testground/pkg/logging"
 
 	"github.com/hashicorp/go-multierror"
@@ -29,6 +35,34 @@ type Instance struct {
 	Client   sync.Client
 	RunEnv   *runtime.RunEnv
 	Network  Network
+
+	// OutputsDir is the directory of the outputs of the instance, as seen by
+	// the sidecar, that packet captures are written to.
+	OutputsDir string
+
+	// RootDir is the root of the filesystem of the instance, as seen by the
+	// sidecar, that disk fills are written to.
+	RootDir string
+
+	// Chaos are the faults that its group injects to the instance, if any.
+	Chaos []api.ChaosAction
+
+	groupNetwork
+}
+
+// groupNetwork is the data network of an instance that its group declares in
+// the composition, if any: Profile is its shape, Timeline the steps of its
+// network timeline, Rules the rules of its links, NAT the NAT it's behind,
+// Capture the capture of its packets, Metrics the interval of its network
+// metrics, and DNS its DNS view; see api.NetworkProfile.
+type groupNetwork struct {
+	Profile  *network.LinkShape
+	Timeline []api.TimelineStep
+	Rules    []api.NetworkRule
+	NAT      api.NATType
+	Capture  *api.CaptureSpec
+	Metrics  time.Duration
+	DNS      *api.DNSSpec
 }
 
 // Network is a test instance's network, as seen by the sidecar.
@@ -39,6 +73,10 @@ type Network interface {
 
 	ConfigureNetwork(ctx context.Context, cfg *network.Config) error
 	ListActive() []string
+
+	// Addresses returns the addresses of the instance on the network, if it's
+	// connected to it.
+	Addresses(network string) (ipv4, ipv6 *net.IPNet)
 }
 
 // NewInstance constructs a new test instance handle.
@@ -52,6 +90,82 @@ func NewInstance(client sync.Client, runenv *runtime.RunEnv, hostname string, ne
 	}, nil
 }
 
+// networkProfile returns the network of an instance that its group declares,
+// from its environment.
+func networkProfile(env []string) (*groupNetwork, error) {
+	var gn groupNetwork
+	for _, kv := range env {
+		switch {
+		case strings.HasPrefix(kv, api.EnvNetworkProfile+"="):
+			gn.Profile = new(network.LinkShape)
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvNetworkProfile+"=")), gn.Profile); err != nil {
+				return nil, fmt.Errorf("failed to decode the network profile: %w", err)
+			}
+		case strings.HasPrefix(kv, api.EnvNetworkTimeline+"="):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvNetworkTimeline+"=")), &gn.Timeline); err != nil {
+				return nil, fmt.Errorf("failed to decode the network timeline: %w", err)
+			}
+		case strings.HasPrefix(kv, api.EnvNetworkRules+"="):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvNetworkRules+"=")), &gn.Rules); err != nil {
+				return nil, fmt.Errorf("failed to decode the network rules: %w", err)
+			}
+		case strings.HasPrefix(kv, api.EnvNetworkNAT+"="):
+			gn.NAT = api.NATType(strings.TrimPrefix(kv, api.EnvNetworkNAT+"="))
+			if err := gn.NAT.Validate(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(kv, api.EnvNetworkCapture+"="):
+			gn.Capture = new(api.CaptureSpec)
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvNetworkCapture+"=")), gn.Capture); err != nil {
+				return nil, fmt.Errorf("failed to decode the network capture: %w", err)
+			}
+		case strings.HasPrefix(kv, api.EnvNetworkMetrics+"="):
+			d, err := time.ParseDuration(strings.TrimPrefix(kv, api.EnvNetworkMetrics+"="))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the network metrics interval: %w", err)
+			}
+			gn.Metrics = d
+		case strings.HasPrefix(kv, api.EnvNetworkDNS+"="):
+			gn.DNS = new(api.DNSSpec)
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvNetworkDNS+"=")), gn.DNS); err != nil {
+				return nil, fmt.Errorf("failed to decode the network dns: %w", err)
+			}
+		}
+	}
+	return &gn, nil
+}
+
+// chaosActions returns the faults that the group of an instance injects to
+// it, from its environment.
+func chaosActions(env []string) ([]api.ChaosAction, error) {
+	var actions []api.ChaosAction
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, api.EnvChaos+"=") {
+			continue
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(kv, api.EnvChaos+"=")), &actions); err != nil {
+			return nil, fmt.Errorf("failed to decode the chaos: %w", err)
+		}
+	}
+	return actions, nil
+}
+
+// subnetV6 returns the IPv6 subnet of the data network of an instance, from
+// its environment, if the data network is dual-stack.
+func subnetV6(env []string) (*net.IPNet, error) {
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, api.SDKEnvTestSubnetV6+"=") {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(strings.TrimPrefix(kv, api.SDKEnvTestSubnetV6+"="))
+		if err != nil || subnet.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 subnet of the data network %q", kv)
+		}
+		return subnet, nil
+	}
+	return nil, nil
+}
+
 // Close closes the instance. It should not be used after closing.
 func (inst *Instance) Close() error {
 	var err *multierror.Error
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/testground/sdk-go/network"
+	"github.com/testground/sdk-go/ptypes"
 	"github.com/testground/testground/pkg/docker"
 	"github.com/testground/testground/pkg/logging"
 
@@ -32,6 +33,7 @@ type K8sNetwork struct {
 	nl              *netlink.Handle
 	cninet          *libcni.CNIConfig
 	subnet          string
+	subnetV6        *net.IPNet // if the data network is dual-stack
 	netnsPath       string
 }
 
@@ -78,8 +80,8 @@ func (n *K8sNetwork) ConfigureNetwork(ctx context.Context, cfg *network.Config)
 	if !online {
 		// No, we're not.
 		// Connect.
-		if cfg.IPv6 != nil {
-			return errors.New("ipv6 not supported")
+		if cfg.IPv6 != nil && n.subnetV6 == nil {
+			return errors.New("ipv6 not supported; the data network isn't dual-stack")
 		}
 
 		var (
@@ -158,6 +160,20 @@ func (n *K8sNetwork) ConfigureNetwork(ctx context.Context, cfg *network.Config)
 			netconf:     netconf,
 		}
 
+		// The CNI plugin only assigns IPv4 addresses; the IPv6 one is the
+		// address of the subnet that ends with the IPv4 one, unless set.
+		if n.subnetV6 != nil {
+			ipv6 := cfg.IPv6
+			if ipv6 == nil {
+				ipv6 = &ptypes.IPNet{IPNet: ipv6Of(n.subnetV6, link.IPv4.IP)}
+			}
+			addr := &net.IPNet{IP: ipv6.IP, Mask: n.subnetV6.Mask}
+			if err := handle.AddrAdd(addr); err != nil {
+				return fmt.Errorf("failed to add the ipv6 address %s: %w", addr, err)
+			}
+			link.IPv6 = addr
+		}
+
 		logging.S().Debugw("successfully adding an active link", "ipv4", link.IPv4, "container", n.container.ID)
 
 		n.activeLinks[cfg.Network] = link
@@ -175,6 +191,30 @@ func (n *K8sNetwork) ConfigureNetwork(ctx context.Context, cfg *network.Config)
 	return nil
 }
 
+// ipv6Of returns the address of the IPv6 subnet that ends with the IPv4 address,
+// e.g. fd00:16:0::1000:2 for 16.0.0.2.
+func ipv6Of(subnet *net.IPNet, ipv4 net.IP) net.IPNet {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, subnet.IP.To16())
+	copy(ip[net.IPv6len-net.IPv4len:], ipv4.To4())
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+func (n *K8sNetwork) Addresses(network string) (ipv4, ipv6 *net.IPNet) {
+	if link, ok := n.activeLinks[network]; ok {
+		return link.IPv4, link.IPv6
+	}
+	return nil, nil
+}
+
+func (n *K8sNetwork) readStats(network string) (*linkStats, error) {
+	link, ok := n.activeLinks[network]
+	if !ok {
+		return nil, fmt.Errorf("not connected to network %s", network)
+	}
+	return readLinkStats(n.nl, n.netnsPath, link.Attrs().Index)
+}
+
 func (n *K8sNetwork) ListActive() []string {
 	networks := make([]string, 0, len(n.activeLinks))
 	for name := range n.activeLinks {
@@ -43,7 +43,7 @@ var (
 type K8sReactor struct {
 	gosync.Mutex
 
-	client          sync.Client
+	clients         *runSyncClients
 	manager         *docker.Manager
 	allowedServices []AllowedService
 	runidsCache     *lru.Cache
@@ -63,7 +63,7 @@ func NewK8sReactor() (Reactor, error) {
 	cache, _ := lru.New(32)
 
 	r := &K8sReactor{
-		client:      client,
+		clients:     newRunSyncClients(client),
 		manager:     docker,
 		runidsCache: cache,
 	}
@@ -135,7 +135,7 @@ func (d *K8sReactor) Handle(ctx context.Context, handler InstanceHandler) error
 func (d *K8sReactor) Close() error {
 	var err *multierror.Error
 	err = multierror.Append(err, d.manager.Close())
-	err = multierror.Append(err, d.client.Close())
+	err = multierror.Append(err, d.clients.Close())
 	return err.ErrorOrNil()
 }
 
@@ -168,12 +168,48 @@ func (d *K8sReactor) manageContainer(ctx context.Context, container *docker.Cont
 	// Resolve allowed services, so that we update network routes
 	d.ResolveServices(params.TestRun)
 
+	// Runs that were given their own sync service are signalled on it, and
+	// routed to it.
+	d.Lock()
+	syncHost := d.clients.runHost(info.Config.Env)
+	client, err := d.clients.client(syncHost)
+	allowedServices := append([]AllowedService(nil), d.allowedServices...)
+	d.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the sync service %s: %w", syncHost, err)
+	}
+	if syncHost != "" {
+		ip, err := net.ResolveIPAddr("ip4", syncHost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve the sync service %s: %w", syncHost, err)
+		}
+		allowedServices = append(allowedServices, AllowedService{"run-sync-service", ip.IP})
+	}
+
 	err = waitForPodRunningPhase(ctx, podName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Remove the TestOutputsPath. We can't store anything from the sidecar.
+	// Groups may declare the shape of their network in the composition.
+	gn, err := networkProfile(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+	subnet6, err := subnetV6(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+	chaos, err := chaosActions(info.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the TestOutputsPath. We can't store anything from the sidecar,
+	// but packet captures, which go to the outputs of the instance, as seen
+	// through its root.
+	rootDir := fmt.Sprintf("/proc/%d/root", info.State.Pid)
+	outputsDir := rootDir + params.TestOutputsPath
 	params.TestOutputsPath = ""
 	runenv := runtime.NewRunEnv(*params)
 
@@ -208,6 +244,7 @@ func (d *K8sReactor) manageContainer(ctx context.Context, container *docker.Cont
 		cninet:          cninet,
 		container:       container,
 		subnet:          runenv.TestSubnet.String(),
+		subnetV6:        subnet6,
 		nl:              netlinkHandle,
 		activeLinks:     make(map[string]*k8sLink),
 		externalRouting: map[string]*route{},
@@ -223,7 +260,7 @@ func (d *K8sReactor) manageContainer(ctx context.Context, container *docker.Cont
 
 	var servicesIPs []net.IP
 
-	for _, s := range d.allowedServices {
+	for _, s := range allowedServices {
 		// Get the routes to redis, influxdb, etc... We need to keep these.
 		r, err := getServiceRoute(netlinkHandle, s.IP)
 		if err != nil {
@@ -341,7 +378,21 @@ func (d *K8sReactor) manageContainer(ctx context.Context, container *docker.Cont
 		}
 	}
 
-	return NewInstance(d.client, runenv, info.Config.Hostname, network)
+	if gn.NAT != "" {
+		if err := setupNAT(network.netnsPath, gn.NAT, &runenv.TestSubnet.IPNet, subnet6); err != nil {
+			return nil, err
+		}
+	}
+
+	inst, err = NewInstance(client, runenv, info.Config.Hostname, network)
+	if err != nil {
+		return nil, err
+	}
+	inst.groupNetwork = *gn
+	inst.OutputsDir = outputsDir
+	inst.RootDir = rootDir
+	inst.Chaos = chaos
+	return inst, nil
 }
 
 func waitForPodRunningPhase(ctx context.Context, podName string) error {
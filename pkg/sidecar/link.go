@@ -3,6 +3,7 @@
 package sidecar
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"net"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 
 	"github.com/testground/sdk-go/network"
 )
@@ -30,8 +32,9 @@ var (
 //          |
 //     [Netem Qdisc]                - latency, jitter, etc. (per-packet attributes)
 //
-// At the moment, only one queue is supported. When support for multiple subnets
-// is added, additional classes/queues will be added per-subnet.
+// Queue 0 carries the traffic shaped by the default link shape. Every subnet
+// with a link rule of its own gets another queue, which a u32 filter on the
+// destination address of the packets maps its traffic to.
 //
 // NetlinkLink also supports setting the network device up/down and changing the
 // IP address.
@@ -41,6 +44,15 @@ var (
 type NetlinkLink struct {
 	netlink.Link
 	handle *netlink.Handle
+
+	rules map[string]*linkRule // subnet -> queue
+	next  uint16
+}
+
+// linkRule is the queue of a subnet, and the filter mapping its traffic to it.
+type linkRule struct {
+	idx    uint16
+	filter *netlink.U32
 }
 
 // NewNetlinkLink constructs a new netlink link handle.
@@ -57,7 +69,12 @@ func NewNetlinkLink(handle *netlink.Handle, link netlink.Link) (*NetlinkLink, er
 		return nil, fmt.Errorf("failed to set root qdisc: %w", err)
 	}
 
-	l := &NetlinkLink{Link: link, handle: handle}
+	l := &NetlinkLink{
+		Link:   link,
+		handle: handle,
+		rules:  make(map[string]*linkRule),
+		next:   1,
+	}
 
 	if err := l.init(0); err != nil {
 		return nil, err
@@ -75,12 +92,11 @@ func handlesForIndex(idx uint16) (htb, netem uint32) {
 	return netlink.MakeHandle(1, id), netlink.MakeHandle(id, 0)
 }
 
-// Initialize the class with index `idx`. For now, we only init a single
-// (default) class. In the future, we'll create one per network. On _each_
-// interface.
+// Initialize the class with index `idx`: the default class, or the one of a
+// subnet with a link rule.
 //
-// We can then specify egress propreties per-network by mapping traffic to each
-// of these classes using filters.
+// We then specify egress propreties per-subnet by mapping traffic to each of
+// these classes using filters.
 func (l *NetlinkLink) init(idx uint16) error {
 	htbHandle, netemHandle := handlesForIndex(idx)
 	htbAttrs := netlink.ClassAttrs{
@@ -153,20 +169,23 @@ func toMicroseconds(t time.Duration) uint32 {
 // Shape applies the link "shape" to the link, setting the bandwidth, latency,
 // jitter, etc.
 func (l *NetlinkLink) Shape(shape network.LinkShape) error {
+	return l.shape(0, shape)
+}
+
+// shape applies the link shape to the class with index `idx`.
+func (l *NetlinkLink) shape(idx uint16, shape network.LinkShape) error {
 	rate := shape.Bandwidth
 	if rate == 0 {
 		rate = math.MaxUint64
 	}
 
-	// TODO: eventually, we'll have a queue per-subnet to allow shaping per-subnet.
-
-	if err := l.setHtb(0, netlink.HtbClassAttrs{
+	if err := l.setHtb(idx, netlink.HtbClassAttrs{
 		Rate: rate,
 	}); err != nil {
 		return err
 	}
 
-	if err := l.setNetem(0, netlink.NetemQdiscAttrs{
+	if err := l.setNetem(idx, netlink.NetemQdiscAttrs{
 		Jitter:        toMicroseconds(shape.Jitter),
 		Latency:       toMicroseconds(shape.Latency),
 		Loss:          shape.Loss,
@@ -182,38 +201,160 @@ func (l *NetlinkLink) Shape(shape network.LinkShape) error {
 	return nil
 }
 
-// TODO(cory) actually process the shape per network.
-// For now, this simply adds a route based on the Filter
+// AddRules shapes the egress traffic to the subnets of the rules, each in a
+// class of its own, and sets up routes dropping or rejecting it, as per the
+// filters of the rules. The classes of the subnets that earlier rules shaped,
+// but these don't, are removed, so that their traffic is shaped by the default
+// link shape again.
 func (l *NetlinkLink) AddRules(rules []network.LinkRule) error {
+	seen := make(map[string]bool, len(rules))
 	for _, rule := range rules {
-		dropRoute := nl.FR_ACT_BLACKHOLE
-		rejectRoute := nl.FR_ACT_PROHIBIT
-		r := netlink.Route{
-			Dst: &rule.Subnet.IPNet,
+		subnet := rule.Subnet.IPNet
+		seen[subnet.String()] = true
+
+		if err := l.shapeSubnet(&subnet, rule.LinkShape); err != nil {
+			return fmt.Errorf("failed to shape the traffic to %s: %w", subnet.String(), err)
 		}
-		switch rule.Filter {
-		// delete drop and reject routes, if they exist.
-		case network.Accept:
-			r.Type = dropRoute
-			_ = l.handle.RouteDel(&r)
-			r.Type = rejectRoute
-			_ = l.handle.RouteDel(&r)
-			continue
+		if err := l.routeSubnet(&subnet, rule.Filter); err != nil {
+			return err
+		}
+	}
 
-		// Setup a reject route.
-		case network.Reject:
-			r.Type = rejectRoute
+	for subnet, r := range l.rules {
+		if seen[subnet] {
+			continue
+		}
+		if err := l.removeRule(r); err != nil {
+			return fmt.Errorf("failed to remove the shaping of the traffic to %s: %w", subnet, err)
+		}
+		delete(l.rules, subnet)
+	}
+	return nil
+}
 
-		// setup a blackhole route.
-		case network.Drop:
-			r.Type = dropRoute
+// shapeSubnet applies the link shape to the class of the subnet, creating it,
+// and the filter mapping the traffic to the subnet to it, if needed.
+func (l *NetlinkLink) shapeSubnet(subnet *net.IPNet, shape network.LinkShape) error {
+	r, ok := l.rules[subnet.String()]
+	if !ok {
+		if l.next == math.MaxUint16-1 {
+			return fmt.Errorf("too many link rules")
 		}
-		err := l.handle.RouteReplace(&r)
-		if err != nil {
+		r = &linkRule{idx: l.next}
+		if err := l.init(r.idx); err != nil {
 			return err
 		}
+
+		htbHandle, _ := handlesForIndex(r.idx)
+		proto, prio, keys := u32Match(subnet)
+		r.filter = &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: l.Attrs().Index,
+				Parent:    rootHandle,
+				Priority:  prio,
+				Protocol:  proto,
+			},
+			ClassId: htbHandle,
+			Sel: &netlink.TcU32Sel{
+				Flags: netlink.TC_U32_TERMINAL,
+				Keys:  keys,
+			},
+		}
+		if err := l.handle.FilterAdd(r.filter); err != nil {
+			return fmt.Errorf("failed to add u32 filter: %w", err)
+		}
+
+		l.rules[subnet.String()] = r
+		l.next++
 	}
-	return nil
+	return l.shape(r.idx, shape)
+}
+
+// removeRule removes the filter and the class of a subnet.
+func (l *NetlinkLink) removeRule(r *linkRule) error {
+	if err := l.handle.FilterDel(r.filter); err != nil {
+		return err
+	}
+	htbHandle, _ := handlesForIndex(r.idx)
+	return l.handle.ClassDel(netlink.NewHtbClass(
+		netlink.ClassAttrs{
+			LinkIndex: l.Attrs().Index,
+			Parent:    rootHandle,
+			Handle:    htbHandle,
+		},
+		netlink.HtbClassAttrs{},
+	))
+}
+
+// u32Match returns the protocol, the priority and the keys of a u32 filter
+// matching the packets to the subnet, by their destination address: at offset
+// 16 of IPv4 headers, and 24 of IPv6 ones.
+//
+// The filters of the most specific subnets have the highest priority, so that
+// the rules of peers take precedence over those of their subnets; IPv6 ones
+// come after IPv4 ones, as a priority only holds filters of one protocol.
+func u32Match(subnet *net.IPNet) (proto, prio uint16, keys []netlink.TcU32Key) {
+	ip, mask, off := subnet.IP.To4(), subnet.Mask, int32(16)
+	proto = unix.ETH_P_IP
+	if ip == nil {
+		ip, off = subnet.IP.To16(), 24
+		proto = unix.ETH_P_IPV6
+	}
+
+	ones, bits := mask.Size()
+	prio = uint16(1 + bits - ones)
+	if proto == unix.ETH_P_IPV6 {
+		prio += 64
+	}
+
+	if len(mask) > len(ip) {
+		mask = mask[len(mask)-len(ip):]
+	}
+
+	for i := 0; i < len(ip); i += 4 {
+		m := binary.BigEndian.Uint32(mask[i:])
+		if m == 0 {
+			break
+		}
+		keys = append(keys, netlink.TcU32Key{
+			Mask: m,
+			Val:  binary.BigEndian.Uint32(ip[i:]) & m,
+			Off:  off + int32(i),
+		})
+	}
+	if len(keys) == 0 {
+		// a catch-all subnet.
+		keys = append(keys, netlink.TcU32Key{Off: off})
+	}
+	return proto, prio, keys
+}
+
+// routeSubnet sets up a route dropping or rejecting the traffic to the subnet,
+// or deletes it, as per the filter.
+func (l *NetlinkLink) routeSubnet(subnet *net.IPNet, filter network.FilterAction) error {
+	dropRoute := nl.FR_ACT_BLACKHOLE
+	rejectRoute := nl.FR_ACT_PROHIBIT
+	r := netlink.Route{
+		Dst: subnet,
+	}
+	switch filter {
+	// delete drop and reject routes, if they exist.
+	case network.Accept:
+		r.Type = dropRoute
+		_ = l.handle.RouteDel(&r)
+		r.Type = rejectRoute
+		_ = l.handle.RouteDel(&r)
+		return nil
+
+	// Setup a reject route.
+	case network.Reject:
+		r.Type = rejectRoute
+
+	// setup a blackhole route.
+	case network.Drop:
+		r.Type = dropRoute
+	}
+	return l.handle.RouteReplace(&r)
 }
 
 // NOTE: None of the following methods are currently used. They exist for future
@@ -0,0 +1,86 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// linkStats are the counters of the traffic of an instance on a network.
+type linkStats struct {
+	TxBytes, RxBytes     uint64
+	TxPackets, RxPackets uint64
+	TxDropped, RxDropped uint64
+
+	// Retransmits are the TCP segments that the instance retransmitted, on
+	// any network.
+	Retransmits uint64
+}
+
+// statsReader is implemented by the networks that the sidecar can read the
+// counters of the traffic of.
+type statsReader interface {
+	readStats(network string) (*linkStats, error)
+}
+
+// netMetrics records the counters of the traffic of an instance on the data
+// network to its outputs, as counters like those the SDKs record.
+type netMetrics struct {
+	instance *Instance
+	f        *os.File
+}
+
+// record records the counters of the traffic of the instance, at ts.
+func (m *netMetrics) record(ts time.Time) error {
+	sr, ok := m.instance.Network.(statsReader)
+	if !ok {
+		return fmt.Errorf("network metrics not supported by this sidecar")
+	}
+	st, err := sr.readStats(defaultDataNetwork)
+	if err != nil {
+		return fmt.Errorf("failed to read the network metrics: %w", err)
+	}
+
+	if m.f == nil {
+		if m.f, err = os.OpenFile(filepath.Join(m.instance.OutputsDir, api.NetworkMetricsOutput), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(m.f)
+	for _, c := range []struct {
+		name  string
+		value uint64
+	}{
+		{"network.tx_bytes", st.TxBytes},
+		{"network.rx_bytes", st.RxBytes},
+		{"network.tx_packets", st.TxPackets},
+		{"network.rx_packets", st.RxPackets},
+		{"network.tx_dropped", st.TxDropped},
+		{"network.rx_dropped", st.RxDropped},
+		{"network.tcp_retransmits", st.Retransmits},
+	} {
+		err := enc.Encode(&runtime.Metric{
+			Timestamp: ts.UnixNano(),
+			Type:      runtime.MetricCounter,
+			Name:      c.name,
+			Measures:  map[string]interface{}{"count": c.value},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *netMetrics) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
@@ -0,0 +1,75 @@
+//+build linux
+
+package sidecar
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// readLinkStats reads the counters of the link with the index, and those of
+// the TCP stack of the network namespace at netnsPath.
+func readLinkStats(nl *netlink.Handle, netnsPath string, index int) (*linkStats, error) {
+	link, err := nl.LinkByIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the link: %w", err)
+	}
+	st := link.Attrs().Statistics
+	if st == nil {
+		return nil, fmt.Errorf("no statistics for link %s", link.Attrs().Name)
+	}
+
+	// /proc/<pid>/ns/net -> /proc/<pid>/net/snmp
+	retrans, err := readRetransmits(filepath.Join(filepath.Dir(filepath.Dir(netnsPath)), "net", "snmp"))
+	if err != nil {
+		return nil, err
+	}
+	return &linkStats{
+		TxBytes:     st.TxBytes,
+		RxBytes:     st.RxBytes,
+		TxPackets:   st.TxPackets,
+		RxPackets:   st.RxPackets,
+		TxDropped:   st.TxDropped,
+		RxDropped:   st.RxDropped,
+		Retransmits: retrans,
+	}, nil
+}
+
+// readRetransmits reads the RetransSegs counter of the Tcp lines of an snmp
+// file of procfs: a line of names, then a line of values.
+func readRetransmits(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "Tcp:" {
+			continue
+		}
+		if names == nil {
+			names = fields
+			continue
+		}
+		for i, name := range names {
+			if name == "RetransSegs" && i < len(fields) {
+				return strconv.ParseUint(fields[i], 10, 64)
+			}
+		}
+		break
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no tcp retransmits in %s", path)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"net"
 	"os"
 	"strconv"
 	gosync "sync"
@@ -12,6 +13,8 @@ import (
 	"github.com/testground/sdk-go/network"
 	"github.com/testground/sdk-go/runtime"
 	"github.com/testground/sdk-go/sync"
+
+	"github.com/testground/testground/pkg/api"
 )
 
 func init() {
@@ -60,6 +63,15 @@ type MockReactor struct {
 	Network   *MockNetwork
 	Client    sync.Client
 	Hostname  string
+	Profile   *network.LinkShape
+	Timeline  []api.TimelineStep
+	Rules     []api.NetworkRule
+	Capture   *api.CaptureSpec
+	Metrics   time.Duration
+	DNS       *api.DNSSpec
+	Chaos     []api.ChaosAction
+	Outputs   string
+	Root      string
 }
 
 func (*MockReactor) Close() error { return nil }
@@ -69,6 +81,10 @@ func (r *MockReactor) Handle(ctx context.Context, handler InstanceHandler) error
 	if err != nil {
 		return err
 	}
+	inst.groupNetwork = groupNetwork{Profile: r.Profile, Timeline: r.Timeline, Rules: r.Rules, Capture: r.Capture, Metrics: r.Metrics, DNS: r.DNS}
+	inst.OutputsDir = r.Outputs
+	inst.RootDir = r.Root
+	inst.Chaos = r.Chaos
 	return handler(ctx, inst)
 }
 
@@ -91,6 +107,11 @@ type MockNetwork struct {
 	Configured []*network.Config          // A list of all the configurations we've seen
 	Closed     bool
 	L          gosync.Locker
+	IPv4       *net.IPNet  // The address of the instance on the default network
+	Packets    chan []byte // The packets captured on the default network
+	Stats      *linkStats  // The counters of the traffic on the default network
+	DNSAddr    net.Addr    // The address the DNS view is served on
+	Ops        []string    // The operations on the container of the instance
 }
 
 func (m *MockNetwork) Close() error {
@@ -109,6 +130,63 @@ func (m *MockNetwork) ConfigureNetwork(ctx context.Context, cfg *network.Config)
 	return nil
 }
 
+func (m *MockNetwork) Addresses(network string) (ipv4, ipv6 *net.IPNet) {
+	if network != "default" {
+		return nil, nil
+	}
+	return m.IPv4, nil
+}
+
+func (m *MockNetwork) readStats(network string) (*linkStats, error) {
+	m.L.Lock()
+	defer m.L.Unlock()
+	if m.Stats == nil {
+		return nil, errors.New("mock network has no stats")
+	}
+	st := *m.Stats
+	return &st, nil
+}
+
+func (m *MockNetwork) listenDNS() (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	m.L.Lock()
+	m.DNSAddr = conn.LocalAddr()
+	m.L.Unlock()
+	return conn, nil
+}
+
+func (m *MockNetwork) controlContainer(ctx context.Context, op containerOp) error {
+	m.L.Lock()
+	defer m.L.Unlock()
+	m.Ops = append(m.Ops, string(op))
+	return nil
+}
+
+func (m *MockNetwork) openCapture(network string, snaplen int) (packetSource, error) {
+	if m.Packets == nil {
+		return nil, errors.New("mock network has no packets")
+	}
+	return &mockPacketSource{packets: m.Packets}, nil
+}
+
+type mockPacketSource struct {
+	packets chan []byte
+}
+
+func (s *mockPacketSource) ReadPacket() ([]byte, int, time.Time, error) {
+	select {
+	case p := <-s.packets:
+		return p, len(p), time.Now(), nil
+	case <-time.After(10 * time.Millisecond):
+		return nil, 0, time.Time{}, nil
+	}
+}
+
+func (*mockPacketSource) Close() error { return nil }
+
 func (m *MockNetwork) ListActive() []string {
 	var active []string
 	for k := range m.Active {
@@ -0,0 +1,69 @@
+package sidecar
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/testground/testground/pkg/api"
+)
+
+// natChain is the chain of the filter table of the network namespace of an
+// instance that filters the traffic from the data network, for NAT emulation.
+const natChain = "TG-NAT"
+
+// natRules returns the arguments of the iptables commands that emulate the NAT
+// type for the traffic of the data network, the subnet of which is given.
+//
+// The filtering is done on the inbound traffic from the subnet: the replies of
+// the connections, or flows, of the instance are accepted, and so is the
+// traffic from the addresses the instance sent traffic to, if restricted, which
+// the recent match records, or everything, if full cone. Symmetric NATs remap
+// the source ports of the instance for every flow.
+func natRules(nat api.NATType, subnet *net.IPNet) [][]string {
+	sn := subnet.String()
+	rules := [][]string{
+		{"-N", natChain},
+		{"-A", "INPUT", "-s", sn, "-j", natChain},
+		{"-A", natChain, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"},
+	}
+
+	switch nat {
+	case api.NATFullCone:
+		return append(rules, []string{"-A", natChain, "-j", "ACCEPT"})
+	case api.NATRestricted:
+		rules = append(rules,
+			[]string{"-A", "OUTPUT", "-d", sn, "-m", "recent", "--name", natChain, "--rdest", "--set"},
+			[]string{"-A", natChain, "-m", "recent", "--name", natChain, "--rsource", "--rcheck", "-j", "ACCEPT"},
+		)
+	case api.NATSymmetric:
+		rules = append(rules,
+			[]string{"-t", "nat", "-A", "POSTROUTING", "-d", sn, "-p", "udp", "-j", "MASQUERADE", "--random-fully"},
+			[]string{"-t", "nat", "-A", "POSTROUTING", "-d", sn, "-p", "tcp", "-j", "MASQUERADE", "--random-fully"},
+		)
+	}
+	return append(rules, []string{"-A", natChain, "-j", "DROP"})
+}
+
+// setupNAT emulates the NAT type in the network namespace at netnsPath, for
+// the traffic of the subnets of the data network, with iptables, or ip6tables
+// for the IPv6 subnet.
+func setupNAT(netnsPath string, nat api.NATType, subnets ...*net.IPNet) error {
+	for _, subnet := range subnets {
+		if subnet == nil {
+			continue
+		}
+		bin := "iptables"
+		if subnet.IP.To4() == nil {
+			bin = "ip6tables"
+		}
+		for _, rule := range natRules(nat, subnet) {
+			args := append([]string{"--net=" + netnsPath, "--", bin}, rule...)
+			if out, err := exec.Command("nsenter", args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to set up the %s nat with %s %s: %w: %s", nat, bin, strings.Join(rule, " "), err, out)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package sidecar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapLinkEthernet = 1
+)
+
+// pcapWriter writes packets to pcap files in a directory, as capture-<n>.pcap,
+// starting a new file once one reaches its maximum size, and removing the
+// oldest ones beyond the maximum number of files.
+type pcapWriter struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	snaplen  int
+
+	f    *os.File
+	size int64
+	n    int
+}
+
+func newPcapWriter(dir string, maxSize int64, maxFiles, snaplen int) *pcapWriter {
+	return &pcapWriter{dir: dir, maxSize: maxSize, maxFiles: maxFiles, snaplen: snaplen}
+}
+
+func (w *pcapWriter) path(n int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("capture-%d.pcap", n))
+}
+
+// WritePacket writes a packet, of length origLen, captured at ts.
+func (w *pcapWriter) WritePacket(ts time.Time, data []byte, origLen int) error {
+	if len(data) > w.snaplen {
+		data = data[:w.snaplen]
+	}
+	if w.f != nil && w.size+16+int64(len(data)) > w.maxSize {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(origLen))
+	if _, err := w.f.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.f.Write(data); err != nil {
+		return err
+	}
+	w.size += 16 + int64(len(data))
+	return nil
+}
+
+// open opens the next file, and removes the one that has to go.
+func (w *pcapWriter) open() error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(w.path(w.n))
+	if err != nil {
+		return fmt.Errorf("failed to create pcap file: %w", err)
+	}
+
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(w.snaplen))
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkEthernet)
+	if _, err := f.Write(hdr[:]); err != nil {
+		f.Close()
+		return err
+	}
+
+	if old := w.n - w.maxFiles; old >= 0 {
+		_ = os.Remove(w.path(old))
+	}
+	w.f, w.size = f, int64(len(hdr))
+	w.n++
+	return nil
+}
+
+// Close closes the current file; the next packet is written to a new one.
+func (w *pcapWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
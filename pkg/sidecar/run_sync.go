@@ -0,0 +1,78 @@
+//+build linux
+
+package sidecar
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/testground/sdk-go/sync"
+	"github.com/testground/testground/pkg/logging"
+)
+
+// runSyncClients holds the clients of the sync services of the runs that were
+// given their own, by host, so that the instances of these runs are signalled
+// on theirs, rather than on the shared one of the sidecar. Reactors hold
+// their lock while they call it, since connecting sets the environment that
+// they resolve their services from.
+type runSyncClients struct {
+	host    string // host of the shared sync service.
+	shared  sync.Client
+	clients *lru.Cache
+}
+
+func newRunSyncClients(shared sync.Client) *runSyncClients {
+	clients, _ := lru.NewWithEvict(32, func(_, c interface{}) {
+		_ = c.(sync.Client).Close()
+	})
+	return &runSyncClients{host: os.Getenv(EnvSyncServiceHost), shared: shared, clients: clients}
+}
+
+// runHost returns the host of the sync service of an instance, from its
+// environment, if its run was given its own; empty otherwise.
+func (c *runSyncClients) runHost(env []string) string {
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, EnvSyncServiceHost+"=") {
+			continue
+		}
+		if host := strings.TrimPrefix(kv, EnvSyncServiceHost+"="); host != c.host {
+			return host
+		}
+	}
+	return ""
+}
+
+// client returns the client of the sync service at host, connecting to it
+// the first time; the shared client if host is empty.
+func (c *runSyncClients) client(host string) (sync.Client, error) {
+	if host == "" {
+		return c.shared, nil
+	}
+
+	if cl, ok := c.clients.Get(host); ok {
+		return cl.(sync.Client), nil
+	}
+
+	// the sdk reads the address of the sync service from the environment.
+	prev := os.Getenv(sync.EnvServiceHost)
+	if err := os.Setenv(sync.EnvServiceHost, host); err != nil {
+		return nil, err
+	}
+	cl, err := sync.NewGenericClient(context.Background(), logging.S())
+	_ = os.Setenv(sync.EnvServiceHost, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.S().Infow("connected to the sync service of a run", "host", host)
+	c.clients.Add(host, cl)
+	return cl, nil
+}
+
+func (c *runSyncClients) Close() error {
+	c.clients.Purge()
+	return c.shared.Close()
+}
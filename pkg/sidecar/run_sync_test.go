@@ -0,0 +1,28 @@
+//+build linux
+
+package sidecar
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/testground/sdk-go/sync"
+)
+
+func TestRunSyncClients(t *testing.T) {
+	assert.NoError(t, os.Setenv(EnvSyncServiceHost, "testground-sync-service"))
+	defer os.Unsetenv(EnvSyncServiceHost)
+
+	shared := sync.NewInmemClient()
+	c := newRunSyncClients(shared)
+
+	// instances of runs without their own sync service use the shared one.
+	assert.Equal(t, "", c.runHost([]string{"TEST_RUN=a"}))
+	assert.Equal(t, "", c.runHost([]string{"TEST_RUN=a", "SYNC_SERVICE_HOST=testground-sync-service"}))
+	assert.Equal(t, "tg-sync-a", c.runHost([]string{"TEST_RUN=a", "SYNC_SERVICE_HOST=tg-sync-a"}))
+
+	cl, err := c.client("")
+	assert.NoError(t, err)
+	assert.Equal(t, sync.Client(shared), cl)
+}
@@ -3,15 +3,33 @@ package sidecar
 import (
 	"context"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/testground/sdk-go/network"
+	"github.com/testground/sdk-go/ptypes"
 	"github.com/testground/sdk-go/sync"
+
+	"github.com/testground/testground/pkg/api"
 )
 
 const (
 	defaultDataNetwork = "default"
+
+	// peerAddressesTopic is the topic that the sidecars publish the addresses
+	// of their instances to.
+	peerAddressesTopic = "network-addresses"
 )
 
+// peerAddress is the address of an instance on the data network, which the
+// sidecars exchange to shape the traffic to the groups of the rules of their
+// links.
+type peerAddress struct {
+	Group string        `json:"group"`
+	IPv4  *ptypes.IPNet `json:"ipv4,omitempty"`
+	IPv6  *ptypes.IPNet `json:"ipv6,omitempty"`
+}
+
 func handler(ctx context.Context, instance *Instance) error {
 	instance.S().Debugw("managing instance", "instance", instance.Hostname)
 
@@ -22,11 +40,17 @@ func handler(ctx context.Context, instance *Instance) error {
 		}
 	}()
 
-	// Network configuration loop.
-	err := instance.Network.ConfigureNetwork(ctx, &network.Config{
+	// Network configuration loop. The network starts with the profile of the
+	// group of the instance, if any.
+	cfg := &network.Config{
 		Network: defaultDataNetwork,
 		Enable:  true,
-	})
+	}
+	if instance.Profile != nil {
+		instance.S().Infow("applying network profile", "instance", instance.Hostname, "profile", instance.Profile)
+		cfg.Default = *instance.Profile
+	}
+	err := instance.Network.ConfigureNetwork(ctx, cfg)
 
 	if err != nil {
 		return err
@@ -34,6 +58,31 @@ func handler(ctx context.Context, instance *Instance) error {
 
 	ctx = sync.WithRunParams(ctx, &instance.RunEnv.RunParams)
 
+	// The links of the group, if any, are shaped before the instance is told
+	// that the network is initialized too. They hold over the network changes
+	// that don't set rules of their own.
+	rules, err := linkRules(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		instance.S().Infow("applying network links", "instance", instance.Hostname, "rules", len(rules))
+		cfg.Rules = rules
+		if err := instance.Network.ConfigureNetwork(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	// The DNS view of the data network, if any, is served before the instance
+	// is told that the network is initialized too.
+	var dns *dnsServer
+	if instance.DNS != nil {
+		if dns, err = startDNS(ctx, instance); err != nil {
+			return err
+		}
+		instance.S().Infow("serving dns", "instance", instance.Hostname, "domain", instance.DNS.Domain)
+	}
+
 	// Wait for all the sidecars to enter the "network-initialized" state.
 	instance.S().Infof("waiting for all networks to be ready")
 
@@ -45,6 +94,40 @@ func handler(ctx context.Context, instance *Instance) error {
 
 	instance.S().Infof("all networks ready")
 
+	// The faults of the group, if any, are injected at their times from now;
+	// the test case may inject some of its own.
+	ch := newChaos(instance)
+	defer ch.Close()
+	for i := range instance.Chaos {
+		state := fmt.Sprintf("chaos-%d-%s", i, instance.RunEnv.TestGroupID)
+		if err := ch.schedule(ctx, &instance.Chaos[i], state, "composition"); err != nil {
+			return err
+		}
+	}
+
+	xtopic := sync.NewTopic(api.ChaosTopic, api.ChaosAction{})
+	chaosActions := make(chan *api.ChaosAction, 16)
+	if _, err := instance.Client.Subscribe(ctx, xtopic, chaosActions); err != nil {
+		return fmt.Errorf("failed to subscribe to chaos actions: %s", err)
+	}
+	var planActions int
+
+	// apply applies a network change, and signals its callback state, if any.
+	apply := func(cfg *network.Config) error {
+		instance.S().Infow("applying network change", "network", cfg)
+		if err := instance.Network.ConfigureNetwork(ctx, cfg); err != nil {
+			return fmt.Errorf("failed to update network %s: %w", cfg.Network, err)
+		}
+
+		if cfg.CallbackState != "" {
+			_, err := instance.Client.SignalEntry(ctx, cfg.CallbackState)
+			if err != nil {
+				return fmt.Errorf("failed to signal network state change %s: %w", cfg.CallbackState, err)
+			}
+		}
+		return nil
+	}
+
 	// Now let the test case tell us how to configure the network.
 	topic := sync.NewTopic("network:"+instance.Hostname, network.Config{})
 	networkChanges := make(chan *network.Config, 16)
@@ -52,6 +135,69 @@ func handler(ctx context.Context, instance *Instance) error {
 		return fmt.Errorf("failed to subscribe to network changes: %s", err)
 	}
 
+	// The network timeline of the group, if any, starts now that all the
+	// networks are ready; the test case may supply one of its own, which
+	// replaces it.
+	ttopic := sync.NewTopic(api.NetworkTimelineTopic+instance.Hostname, api.NetworkTimeline{})
+	timelines := make(chan *api.NetworkTimeline, 4)
+	if _, err := instance.Client.Subscribe(ctx, ttopic, timelines); err != nil {
+		return fmt.Errorf("failed to subscribe to network timelines: %s", err)
+	}
+
+	steps := make(chan api.TimelineStep)
+	stopTimeline := runTimeline(ctx, instance.Timeline, steps)
+	defer func() { stopTimeline() }()
+
+	// The packets of the instance are captured as its group declares, if it's
+	// one of the instances to capture, and as the test case requests.
+	ctopic := sync.NewTopic(api.NetworkCaptureTopic+instance.Hostname, api.CaptureRequest{})
+	captureRequests := make(chan *api.CaptureRequest, 4)
+	if _, err := instance.Client.Subscribe(ctx, ctopic, captureRequests); err != nil {
+		return fmt.Errorf("failed to subscribe to capture requests: %s", err)
+	}
+
+	pcaps := newCaptures(instance)
+	defer pcaps.stop()
+
+	var captureStart, captureStop <-chan time.Time
+	if spec := instance.Capture; spec != nil {
+		capturing := spec.Instances == 0
+		if !capturing {
+			seq, err := instance.Client.SignalEntry(ctx, sync.State("network-capture-"+instance.RunEnv.TestGroupID))
+			if err != nil {
+				return fmt.Errorf("failed to signal network capture: %w", err)
+			}
+			capturing = seq <= int64(spec.Instances)
+		}
+		if capturing {
+			captureStart = time.After(spec.Start)
+		}
+	}
+
+	// The test case may inject failures in the DNS view of its own.
+	dtopic := sync.NewTopic(api.NetworkDNSTopic+instance.Hostname, api.DNSFailures{})
+	dnsFailures := make(chan *api.DNSFailures, 4)
+	if dns != nil {
+		if _, err := instance.Client.Subscribe(ctx, dtopic, dnsFailures); err != nil {
+			return fmt.Errorf("failed to subscribe to dns failures: %s", err)
+		}
+	}
+
+	// The counters of the traffic of the instance are recorded at the interval
+	// of its group, if any.
+	var metricsTick <-chan time.Time
+	metrics := &netMetrics{instance: instance}
+	defer metrics.Close()
+	if _, ok := instance.Network.(statsReader); !ok && instance.Metrics > 0 {
+		instance.S().Warnw("network metrics not supported by this sidecar", "instance", instance.Hostname)
+	} else if instance.Metrics > 0 {
+		ticker := time.NewTicker(instance.Metrics)
+		defer ticker.Stop()
+		metricsTick = ticker.C
+	}
+
+	// every step of the timeline reshapes the last network configuration.
+	last := cfg
 	for {
 		select {
 		case <-ctx.Done():
@@ -66,18 +212,201 @@ func handler(ctx context.Context, instance *Instance) error {
 				instance.S().Debugw("networkChanges channel closed", "instance", instance.Hostname)
 				return nil
 			}
+			if cfg.Network == defaultDataNetwork && len(cfg.Rules) == 0 && len(rules) > 0 {
+				cfg.Rules = rules
+			}
+			if err := apply(cfg); err != nil {
+				return err
+			}
+			if cfg.Network == defaultDataNetwork {
+				last = cfg
+			}
+
+		case tl, ok := <-timelines:
+			if !ok {
+				return nil
+			}
+			instance.S().Infow("executing network timeline of the test case", "instance", instance.Hostname, "steps", len(tl.Steps))
+			stopTimeline()
+			stopTimeline = runTimeline(ctx, tl.Steps, steps)
+
+		case <-captureStart:
+			captureStart = nil
+			stop, err := pcaps.start(instance.Capture.Duration)
+			if err != nil {
+				instance.S().Warnw("failed to capture packets", "instance", instance.Hostname, "err", err)
+			}
+			captureStop = stop
+
+		case ts := <-metricsTick:
+			if err := metrics.record(ts); err != nil {
+				instance.S().Warnw("failed to record network metrics", "instance", instance.Hostname, "err", err)
+			}
 
-			instance.S().Infow("applying network change", "network", cfg)
-			if err := instance.Network.ConfigureNetwork(ctx, cfg); err != nil {
-				return fmt.Errorf("failed to update network %s: %w", cfg.Network, err)
+		case <-captureStop:
+			captureStop = nil
+			pcaps.stop()
+
+		case req, ok := <-captureRequests:
+			if !ok {
+				return nil
+			}
+			captureStart = nil
+			stop, err := pcaps.start(req.Duration)
+			if err != nil {
+				instance.S().Warnw("failed to capture packets", "instance", instance.Hostname, "err", err)
+			}
+			captureStop = stop
+			if req.State != "" {
+				if _, err := instance.Client.SignalEntry(ctx, sync.State(req.State)); err != nil {
+					return fmt.Errorf("failed to signal capture state %s: %w", req.State, err)
+				}
 			}
 
-			if cfg.CallbackState != "" {
-				_, err := instance.Client.SignalEntry(ctx, cfg.CallbackState)
-				if err != nil {
-					return fmt.Errorf("failed to signal network state change %s: %w", cfg.CallbackState, err)
+		case req, ok := <-dnsFailures:
+			if !ok {
+				return nil
+			}
+			instance.S().Infow("injecting dns failures", "instance", instance.Hostname, "failures", len(req.Failures))
+			dns.setFailures(req.Failures)
+			if req.State != "" {
+				if _, err := instance.Client.SignalEntry(ctx, sync.State(req.State)); err != nil {
+					return fmt.Errorf("failed to signal dns state %s: %w", req.State, err)
 				}
 			}
+
+		case a, ok := <-chaosActions:
+			if !ok {
+				return nil
+			}
+			// all the sidecars count the actions of the test case alike.
+			planActions++
+			if a.Group != "" && a.Group != instance.RunEnv.TestGroupID {
+				continue
+			}
+			if err := a.Validate(); err != nil {
+				instance.S().Warnw("invalid chaos action", "instance", instance.Hostname, "err", err)
+				continue
+			}
+			state := fmt.Sprintf("chaos-plan-%d-%s", planActions, instance.RunEnv.TestGroupID)
+			if err := ch.schedule(ctx, a, state, "plan"); err != nil {
+				return err
+			}
+
+		case step := <-steps:
+			instance.S().Infow("applying network timeline step", "instance", instance.Hostname, "at", step.At, "state", step.State)
+			next := *last
+			next.Default, next.CallbackState = step.Shape, sync.State(step.State)
+			if err := apply(&next); err != nil {
+				return err
+			}
+			last = &next
 		}
 	}
 }
+
+// linkRules publishes the addresses of the instance for the sidecars of the
+// other instances, and returns the rules of the links of its group, resolving
+// the groups of the rules to the addresses of their instances.
+func linkRules(ctx context.Context, instance *Instance) ([]network.LinkRule, error) {
+	topic := sync.NewTopic(peerAddressesTopic, peerAddress{})
+	self := &peerAddress{Group: instance.RunEnv.TestGroupID}
+	if ipv4, ipv6 := instance.Network.Addresses(defaultDataNetwork); ipv4 != nil || ipv6 != nil {
+		self.IPv4, self.IPv6 = hostSubnet(ipv4), hostSubnet(ipv6)
+	}
+	if _, err := instance.Client.Publish(ctx, topic, self); err != nil {
+		return nil, fmt.Errorf("failed to publish the addresses of the instance: %w", err)
+	}
+
+	var (
+		rules  []network.LinkRule
+		groups = make(map[string]network.LinkShape)
+	)
+	for _, r := range instance.Rules {
+		if r.Subnet != nil {
+			rules = append(rules, network.LinkRule{LinkShape: r.Shape, Subnet: *r.Subnet})
+		} else {
+			groups[r.Group] = r.Shape
+		}
+	}
+	if len(groups) == 0 {
+		return rules, nil
+	}
+
+	// Wait for the addresses of all the instances.
+	total := instance.RunEnv.TestInstanceCount
+	peers := make(chan *peerAddress, total)
+	sub, err := instance.Client.Subscribe(ctx, topic, peers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to the addresses of the instances: %w", err)
+	}
+	for i := 0; i < total; i++ {
+		var p *peerAddress
+		select {
+		case p = <-peers:
+		case err := <-sub.Done():
+			return nil, fmt.Errorf("failed to receive the addresses of the instances: %w", err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		shape, ok := groups[p.Group]
+		if !ok {
+			continue
+		}
+		for _, ip := range []*ptypes.IPNet{p.IPv4, p.IPv6} {
+			if ip == nil || ip.IP.Equal(ipOf(self.IPv4)) || ip.IP.Equal(ipOf(self.IPv6)) {
+				continue
+			}
+			rules = append(rules, network.LinkRule{LinkShape: shape, Subnet: *ip})
+		}
+	}
+	return rules, nil
+}
+
+// hostSubnet returns the subnet of the single address of ip.
+func hostSubnet(ip *net.IPNet) *ptypes.IPNet {
+	if ip == nil {
+		return nil
+	}
+	bits := 8 * net.IPv6len
+	if ip.IP.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	return &ptypes.IPNet{IPNet: net.IPNet{IP: ip.IP, Mask: net.CIDRMask(bits, bits)}}
+}
+
+func ipOf(ip *ptypes.IPNet) net.IP {
+	if ip == nil {
+		return nil
+	}
+	return ip.IP
+}
+
+// runTimeline sends the steps of a network timeline to out, at their times
+// from now, until stop is called.
+func runTimeline(ctx context.Context, steps []api.TimelineStep, out chan<- api.TimelineStep) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	if len(steps) == 0 {
+		return cancel
+	}
+
+	start := time.Now()
+	go func() {
+		for _, step := range steps {
+			t := time.NewTimer(time.Until(start.Add(step.At)))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- step:
+			}
+		}
+	}()
+	return cancel
+}
@@ -1,14 +1,27 @@
 package sidecar
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/testground/sdk-go/network"
+	"github.com/testground/sdk-go/ptypes"
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/sdk-go/sync"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/testground/testground/pkg/api"
 )
 
 func init() {
@@ -36,6 +49,440 @@ func TestNetworkInitialize(t *testing.T) {
 	assert.Len(t, r.Network.Configured, 1, "the network should be configured once for init")
 }
 
+// Configures the default network with the profile of the group
+func TestNetworkInitializeProfile(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r := reactor.(*MockReactor)
+
+	gn, err := networkProfile([]string{"TEST_PLAN=foo", `TESTGROUND_NETWORK_PROFILE={"latency":100000000,"bandwidth":1250000,"loss":0.5}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Profile = gn.Profile
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+	assert.Len(t, r.Network.Configured, 1, "the network should be configured once for init")
+	assert.Equal(t, network.LinkShape{Latency: 100 * time.Millisecond, Bandwidth: 1250000, Loss: 0.5}, r.Network.Configured[0].Default)
+
+	none, err := networkProfile([]string{"TEST_PLAN=foo"})
+	assert.NoError(t, err)
+	assert.Nil(t, none.Profile)
+}
+
+// Executes the network timeline of the group, and then the one of the plan
+func TestNetworkTimeline(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	ctx := sync.WithRunParams(context.Background(), r.RunParams)
+
+	r.Timeline = []api.TimelineStep{
+		{At: 0, Shape: network.LinkShape{Latency: 10 * time.Millisecond}, State: "degraded"},
+		{At: 50 * time.Millisecond, Shape: network.LinkShape{Latency: 20 * time.Millisecond}, State: "recovered"},
+	}
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+	<-r.Client.MustBarrier(ctx, "recovered", 1).C
+
+	r.Network.L.Lock()
+	assert.Len(t, r.Network.Configured, 3, "the network should be configured for init and for every step")
+	assert.Equal(t, 10*time.Millisecond, r.Network.Configured[1].Default.Latency)
+	assert.Equal(t, 20*time.Millisecond, r.Network.Configured[2].Default.Latency)
+	assert.True(t, r.Network.Configured[2].Enable, "the steps should reshape the last configuration")
+	r.Network.L.Unlock()
+
+	topic := sync.NewTopic(api.NetworkTimelineTopic+r.Hostname, api.NetworkTimeline{})
+	r.Client.MustPublish(ctx, topic, &api.NetworkTimeline{Steps: []api.TimelineStep{
+		{At: 10 * time.Millisecond, Shape: network.LinkShape{Loss: 50}, State: "lossy"},
+	}})
+	<-r.Client.MustBarrier(ctx, "lossy", 1).C
+
+	r.Network.L.Lock()
+	defer r.Network.L.Unlock()
+	assert.Len(t, r.Network.Configured, 4)
+	assert.Equal(t, network.LinkShape{Loss: 50}, r.Network.Configured[3].Default)
+}
+
+// Shapes the traffic to the instances of another group, and to a subnet
+func TestNetworkLinks(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	r.RunEnv.TestInstanceCount = 2
+	ctx := sync.WithRunParams(context.Background(), r.RunParams)
+
+	_, subnet, _ := net.ParseCIDR("10.1.0.0/16")
+	r.Network.IPv4 = &net.IPNet{IP: net.ParseIP("16.0.0.2"), Mask: net.CIDRMask(16, 32)}
+	r.Rules = []api.NetworkRule{
+		{Group: r.RunEnv.TestGroupID, Shape: network.LinkShape{Latency: 10 * time.Millisecond}},
+		{Group: "remote", Shape: network.LinkShape{Latency: 200 * time.Millisecond}},
+		{Subnet: &ptypes.IPNet{IPNet: *subnet}, Shape: network.LinkShape{Loss: 1}},
+	}
+
+	// act like the sidecar of an instance of the remote group.
+	remote := &peerAddress{Group: "remote", IPv4: hostSubnet(&net.IPNet{IP: net.ParseIP("16.0.1.3"), Mask: net.CIDRMask(16, 32)})}
+	r.Client.MustPublish(ctx, sync.NewTopic(peerAddressesTopic, peerAddress{}), remote)
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	r.Client.MustSignalAndWait(ctx, "network-initialized", 2)
+
+	r.Network.L.Lock()
+	defer r.Network.L.Unlock()
+	assert.Len(t, r.Network.Configured, 2, "the network should be configured for init and for the links")
+	rules := r.Network.Configured[1].Rules
+	assert.Len(t, rules, 2, "the traffic to the instance itself should not be shaped")
+	assert.Equal(t, "10.1.0.0/16", rules[0].Subnet.String())
+	assert.Equal(t, float32(1), rules[0].Loss)
+	assert.Equal(t, "16.0.1.3/32", rules[1].Subnet.String())
+	assert.Equal(t, 200*time.Millisecond, rules[1].Latency)
+}
+
+// Reads the IPv6 subnet of dual-stack data networks
+func TestSubnetV6(t *testing.T) {
+	subnet, err := subnetV6([]string{"TEST_SUBNET=16.0.0.0/16", "TEST_SUBNET_V6=fd00:16::/64"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00:16::/64", subnet.String())
+
+	none, err := subnetV6([]string{"TEST_SUBNET=16.0.0.0/16"})
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = subnetV6([]string{"TEST_SUBNET_V6=16.0.0.0/16"})
+	assert.Error(t, err)
+}
+
+// Emulates the NAT types with iptables
+func TestNATRules(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("16.0.0.0/16")
+
+	full := natRules(api.NATFullCone, subnet)
+	assert.Equal(t, []string{"-A", "INPUT", "-s", "16.0.0.0/16", "-j", natChain}, full[1])
+	assert.Equal(t, []string{"-A", natChain, "-j", "ACCEPT"}, full[len(full)-1])
+
+	restricted := natRules(api.NATRestricted, subnet)
+	assert.Contains(t, restricted, []string{"-A", "OUTPUT", "-d", "16.0.0.0/16", "-m", "recent", "--name", natChain, "--rdest", "--set"})
+
+	symmetric := natRules(api.NATSymmetric, subnet)
+	assert.Contains(t, symmetric, []string{"-t", "nat", "-A", "POSTROUTING", "-d", "16.0.0.0/16", "-p", "udp", "-j", "MASQUERADE", "--random-fully"})
+
+	// all but full cone NATs drop the traffic they don't accept.
+	for _, rules := range [][][]string{restricted, natRules(api.NATPortRestricted, subnet), symmetric} {
+		assert.Equal(t, []string{"-A", natChain, "-j", "DROP"}, rules[len(rules)-1])
+		assert.NotContains(t, rules, []string{"-A", natChain, "-j", "ACCEPT"})
+	}
+
+	gn, err := networkProfile([]string{"TESTGROUND_NETWORK_NAT=symmetric"})
+	assert.NoError(t, err)
+	assert.Equal(t, api.NATSymmetric, gn.NAT)
+	_, err = networkProfile([]string{"TESTGROUND_NETWORK_NAT=carrier-grade"})
+	assert.Error(t, err)
+}
+
+// Rotates the pcap files of a capture, and keeps the last ones
+func TestPcapWriter(t *testing.T) {
+	dir := t.TempDir()
+	// room for the header and two packets of 8 bytes per file.
+	w := newPcapWriter(dir, 24+2*(16+8), 2, 8)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, w.WritePacket(time.Unix(1, 2000), make([]byte, 10), 10))
+	}
+	assert.NoError(t, w.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pcap"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "capture-1.pcap"), filepath.Join(dir, "capture-2.pcap")}, files)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "capture-1.pcap"))
+	assert.NoError(t, err)
+	assert.Len(t, b, 24+2*(16+8), "packets should be truncated to the snaplen")
+	assert.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(b[0:]))
+	assert.Equal(t, uint32(8), binary.LittleEndian.Uint32(b[24+8:]), "captured length")
+	assert.Equal(t, uint32(10), binary.LittleEndian.Uint32(b[24+12:]), "original length")
+}
+
+// Captures the packets of an instance on request of the plan
+func TestNetworkCapture(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	ctx := sync.WithRunParams(context.Background(), r.RunParams)
+
+	r.Outputs = t.TempDir()
+	r.Network.Packets = make(chan []byte, 1)
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+
+	topic := sync.NewTopic(api.NetworkCaptureTopic+r.Hostname, api.CaptureRequest{})
+	r.Client.MustPublish(ctx, topic, &api.CaptureRequest{State: "capturing"})
+	<-r.Client.MustBarrier(ctx, "capturing", 1).C
+
+	r.Network.Packets <- []byte("packet")
+	assert.Eventually(t, func() bool {
+		fi, err := os.Stat(filepath.Join(r.Outputs, "capture-0.pcap"))
+		return err == nil && fi.Size() == 24+16+6
+	}, 5*time.Second, 10*time.Millisecond, "the packet should be written to the outputs of the instance")
+}
+
+// Records the counters of the traffic of an instance to its outputs
+func TestNetworkMetrics(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	ctx, cancel := context.WithCancel(sync.WithRunParams(context.Background(), r.RunParams))
+	defer cancel()
+
+	r.Outputs = t.TempDir()
+	r.Metrics = 10 * time.Millisecond
+	r.Network.Stats = &linkStats{TxBytes: 1500, RxBytes: 3000, TxPackets: 1, RxPackets: 2, Retransmits: 1}
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil && ctx.Err() == nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+
+	path := filepath.Join(r.Outputs, api.NetworkMetricsOutput)
+	assert.Eventually(t, func() bool {
+		fi, err := os.Stat(path)
+		return err == nil && fi.Size() > 0
+	}, 5*time.Second, 10*time.Millisecond, "the metrics should be written to the outputs of the instance")
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	counts := make(map[string]float64)
+	dec := json.NewDecoder(f)
+	for i := 0; i < 7; i++ {
+		var m runtime.Metric
+		assert.NoError(t, dec.Decode(&m))
+		assert.Equal(t, runtime.MetricCounter, m.Type)
+		counts[m.Name] = m.Measures["count"].(float64)
+	}
+	assert.Equal(t, 1500.0, counts["network.tx_bytes"])
+	assert.Equal(t, 3000.0, counts["network.rx_bytes"])
+	assert.Equal(t, 2.0, counts["network.rx_packets"])
+	assert.Equal(t, 1.0, counts["network.tcp_retransmits"])
+}
+
+// Resolves the names of the instances, but for the names that fail
+func TestDNSServer(t *testing.T) {
+	s := newDNSServer(&api.DNSSpec{
+		Domain:   "run.local",
+		Failures: []api.DNSFailure{{Name: "peers-2", Error: api.DNSServFail, Delay: time.Second}},
+	})
+	// the instances are numbered in the order of their addresses, not of
+	// their publication.
+	for _, ip := range []string{"16.0.0.2", "16.0.0.1"} {
+		_, ipnet, _ := net.ParseCIDR(ip + "/32")
+		s.add(&peerAddress{Group: "peers", IPv4: &ptypes.IPNet{IPNet: *ipnet}})
+	}
+
+	query := func(name string) (dnsmessage.RCode, []dnsmessage.Resource, time.Duration) {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+		assert.NoError(t, b.StartQuestions())
+		assert.NoError(t, b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+		q, err := b.Finish()
+		assert.NoError(t, err)
+
+		a, delay := s.answer(q)
+		var m dnsmessage.Message
+		assert.NoError(t, m.Unpack(a))
+		assert.Equal(t, uint16(1), m.ID)
+		return m.RCode, m.Answers, delay
+	}
+
+	rcode, answers, _ := query("peers.run.local.")
+	assert.Equal(t, dnsmessage.RCodeSuccess, rcode)
+	assert.Len(t, answers, 2)
+
+	rcode, answers, _ = query("PEERS-1.run.local.")
+	assert.Equal(t, dnsmessage.RCodeSuccess, rcode)
+	assert.Equal(t, [4]byte{16, 0, 0, 1}, answers[0].Body.(*dnsmessage.AResource).A)
+
+	rcode, _, delay := query("peers-2.run.local.")
+	assert.Equal(t, dnsmessage.RCodeServerFailure, rcode)
+	assert.Equal(t, time.Second, delay)
+
+	rcode, _, _ = query("peers-3.run.local.")
+	assert.Equal(t, dnsmessage.RCodeNameError, rcode)
+
+	rcode, _, _ = query("example.com.")
+	assert.Equal(t, dnsmessage.RCodeRefused, rcode)
+
+	s.setFailures([]api.DNSFailure{{Name: "peers-1.run.local", Error: api.DNSTimeout}})
+	rcode, _, _ = query("peers-2.run.local.")
+	assert.Equal(t, dnsmessage.RCodeSuccess, rcode)
+}
+
+// Serves the DNS view of the data network to an instance
+func TestNetworkDNS(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	ctx := sync.WithRunParams(context.Background(), r.RunParams)
+
+	r.DNS = &api.DNSSpec{Domain: "run.local"}
+	r.Network.IPv4 = &net.IPNet{IP: net.ParseIP("16.0.0.1").To4(), Mask: net.CIDRMask(16, 32)}
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+
+	r.Network.L.Lock()
+	addr := r.Network.DNSAddr.String()
+	r.Network.L.Unlock()
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", addr)
+		},
+	}
+
+	name := r.RunParams.TestGroupID + "-1.run.local."
+	ips, err := resolver.LookupHost(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"16.0.0.1"}, ips)
+
+	topic := sync.NewTopic(api.NetworkDNSTopic+r.Hostname, api.DNSFailures{})
+	failures := &api.DNSFailures{Failures: []api.DNSFailure{{Name: name, Error: api.DNSNXDomain}}, State: "failing"}
+	r.Client.MustPublish(ctx, topic, failures)
+	<-r.Client.MustBarrier(ctx, "failing", 1).C
+
+	_, err = resolver.LookupHost(ctx, name)
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+}
+
+// Injects the faults of the group, and of the test case, and records them
+func TestChaos(t *testing.T) {
+	reactor, err := NewMockReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reactor.(*MockReactor)
+	ctx, cancel := context.WithCancel(sync.WithRunParams(context.Background(), r.RunParams))
+	defer cancel()
+
+	r.Outputs, r.Root = t.TempDir(), t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(r.Root, "tmp"), 0755))
+	r.Chaos = []api.ChaosAction{
+		{Action: api.ChaosPause, Duration: 10 * time.Millisecond},
+		{Action: api.ChaosDiskFill, Size: 3 << 19},
+	}
+
+	go func() {
+		if err := r.Handle(ctx, handler); err != nil && ctx.Err() == nil {
+			t.Error(err)
+		}
+	}()
+
+	netclient := network.NewClient(r.Client, r.RunEnv)
+	netclient.MustWaitNetworkInitialized(ctx)
+
+	r.Client.MustPublish(ctx, sync.NewTopic(api.ChaosTopic, api.ChaosAction{}), &api.ChaosAction{Action: api.ChaosRestart, Group: "other"})
+	r.Client.MustPublish(ctx, sync.NewTopic(api.ChaosTopic, api.ChaosAction{}), &api.ChaosAction{Action: api.ChaosKill})
+
+	// the clock skew is recorded once the instance acknowledges it.
+	skews := make(chan *api.ClockSkew, 1)
+	r.Client.MustSubscribe(ctx, sync.NewTopic(api.ChaosClockTopic+r.Hostname, api.ClockSkew{}), skews)
+	r.Client.MustPublish(ctx, sync.NewTopic(api.ChaosTopic, api.ChaosAction{}), &api.ChaosAction{Action: api.ChaosClockSignal, Skew: time.Minute})
+	select {
+	case skew := <-skews:
+		assert.Equal(t, time.Minute, skew.Skew)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the clock skew should be published")
+	}
+	time.Sleep(100 * time.Millisecond)
+	b, _ := ioutil.ReadFile(filepath.Join(r.Outputs, api.ChaosOutput))
+	assert.NotContains(t, string(b), api.ChaosClockSignal, "the clock skew should not be recorded before it's acknowledged")
+	r.Client.MustSignalEntry(ctx, sync.State(api.ChaosClockAckState+r.Hostname))
+
+	ops := func() []string {
+		r.Network.L.Lock()
+		defer r.Network.L.Unlock()
+		return append([]string(nil), r.Network.Ops...)
+	}
+	assert.Eventually(t, func() bool {
+		return len(ops()) == 3
+	}, 5*time.Second, 10*time.Millisecond, "the instance should be paused, unpaused and killed")
+	assert.ElementsMatch(t, []string{"pause", "unpause", "kill"}, ops())
+
+	assert.Eventually(t, func() bool {
+		b, _ = ioutil.ReadFile(filepath.Join(r.Outputs, api.ChaosOutput))
+		return bytes.Count(b, []byte("\n")) == 4
+	}, 5*time.Second, 10*time.Millisecond, "the faults should be recorded to the outputs of the instance")
+
+	fi, err := os.Stat(filepath.Join(r.Root, chaosFillFile+"0"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3<<19), fi.Size())
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	sources := make(map[api.ChaosKind]string)
+	for dec.More() {
+		var ev api.ChaosEvent
+		assert.NoError(t, dec.Decode(&ev))
+		assert.Empty(t, ev.Error)
+		sources[ev.Action] = ev.Source
+	}
+	assert.Equal(t, map[api.ChaosKind]string{
+		api.ChaosPause:       "composition",
+		api.ChaosDiskFill:    "composition",
+		api.ChaosKill:        "plan",
+		api.ChaosClockSignal: "plan",
+	}, sources)
+}
+
 // Test that passing a misconfigured network config throws an appropriate error
 func TestNetworkConfiguredFailsMisconfigured(t *testing.T) {
 	reactor, err := NewMockReactor()
@@ -0,0 +1,109 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// prefixHistory is the database key prefix of the run history. Unlike the
+// archive, the history is kept when tasks are deleted.
+var prefixHistory = "history"
+
+// Record (kind: struct) is the permanent record of a completed task, kept in
+// the run history.
+type Record struct {
+	ID          string      `json:"id"`
+	Type        Type        `json:"type"`
+	Plan        string      `json:"plan"`
+	Case        string      `json:"case"`
+	Runner      string      `json:"runner,omitempty"`
+	State       State       `json:"state"`
+	Outcome     Outcome     `json:"outcome"`
+	Error       string      `json:"error,omitempty"`
+	Created     time.Time   `json:"created"`
+	Started     time.Time   `json:"started"` // Zero if the task was canceled before it started
+	Ended       time.Time   `json:"ended"`
+	CreatedBy   CreatedBy   `json:"created_by"`
+	Composition interface{} `json:"composition"`
+	Artifacts   []string    `json:"artifacts,omitempty"` // Locations of the artifacts built or run
+	Summary     string      `json:"summary,omitempty"`   // Summary of the result, e.g. the outcomes of the groups
+	Schedule    string      `json:"schedule,omitempty"`  // Schedule that queued the task, if any
+	Panic       string      `json:"panic,omitempty"`     // Panic that got the task quarantined, if any
+}
+
+// Took returns how long the task took, from its start to its end.
+func (r *Record) Took() time.Duration {
+	if r.Started.IsZero() {
+		return 0
+	}
+	return r.Ended.Sub(r.Started).Truncate(time.Second)
+}
+
+// historyKey indexes records by test plan, test case and end time, so that
+// the history of a test plan, or of a test case, is a range of keys.
+func historyKey(rec *Record) []byte {
+	ended := fmt.Sprintf("%012d", rec.Ended.Unix())
+	return []byte(strings.Join([]string{prefixHistory, rec.Plan, rec.Case, ended + "_" + rec.ID}, ":"))
+}
+
+// Record adds a record to the run history.
+func (s *Storage) Record(rec *Record) error {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(historyKey(rec), val, &opt.WriteOptions{
+		Sync: true,
+	})
+}
+
+// History returns the records of the tasks of the given test plan and test
+// case, either of which can be empty to match all, that ended since the
+// given time, oldest first. If limit is positive, only the last limit
+// records are returned.
+func (s *Storage) History(plan, tcase string, since time.Time, limit int) ([]*Record, error) {
+	prefix := prefixHistory + ":"
+	if plan != "" {
+		prefix += plan + ":"
+		if tcase != "" {
+			prefix += tcase + ":"
+		}
+	}
+
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	recs := make([]*Record, 0)
+	for iter.Next() {
+		rec := &Record{}
+		if err := json.Unmarshal(iter.Value(), rec); err != nil {
+			return nil, err
+		}
+		// plan and case names may contain the separator of the keys.
+		if (plan != "" && rec.Plan != plan) || (tcase != "" && rec.Case != tcase) {
+			continue
+		}
+		if rec.Ended.Before(since) {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	// keys are ordered by end time within a test case only.
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Ended.Before(recs[j].Ended)
+	})
+	if limit > 0 && len(recs) > limit {
+		recs = recs[len(recs)-limit:]
+	}
+	return recs, nil
+}
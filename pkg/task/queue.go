@@ -12,6 +12,10 @@ import (
 var (
 	ErrQueueEmpty = errors.New("queue empty")
 	ErrQueueFull  = errors.New("queue full")
+
+	// ErrQueueBlocked is returned by Pop when all the queued tasks wait
+	// for their runners to finish other tasks.
+	ErrQueueBlocked = errors.New("queue blocked by concurrency limits")
 )
 
 func NewQueue(ts *Storage, max int, converter func([]byte) (*Task, error)) (*Queue, error) {
@@ -30,19 +34,51 @@ func NewQueue(ts *Storage, max int, converter func([]byte) (*Task, error)) (*Que
 	}
 	// correct the eviction order so we will evict oldest items first
 	return &Queue{
-		tq:  tq,
-		ts:  ts,
-		max: max,
+		tq:      tq,
+		ts:      ts,
+		max:     max,
+		served:  make(map[string]float64),
+		running: make(map[string]int),
 	}, nil
 }
 
-// Queue is a priority queue for tasks.
+// Queue is a priority queue for tasks. Tasks of higher priority are popped
+// first. Among the tasks of the same priority, the queue is fair to their
+// owners: it pops the oldest task of the owner that was served the least,
+// relative to its weight, so that an owner who schedules many tasks doesn't
+// hold off everyone else.
 type Queue struct {
 	sync.Mutex
 	tq *taskQueue
 	ts *Storage
 
 	max int // the maximum number of tasks to keep in the database
+
+	weights map[string]int     // the scheduling weights of owners; 1 if unset
+	served  map[string]float64 // the weighted number of tasks popped per owner
+
+	limits  map[string]int // the maximum number of tasks processed per runner
+	running map[string]int // the number of tasks being processed per runner
+}
+
+// SetWeights sets the scheduling weights of owners. An owner with weight 2
+// gets twice as many tasks popped as an owner with weight 1, when both have
+// tasks scheduled. Owners without a weight have weight 1.
+func (q *Queue) SetWeights(weights map[string]int) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.weights = weights
+}
+
+// SetConcurrency sets the maximum number of tasks of every runner that can
+// be processed at the same time; runners without a limit are only limited by
+// the number of workers popping tasks.
+func (q *Queue) SetConcurrency(limits map[string]int) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.limits = limits
 }
 
 // Add an item to the priority queue
@@ -64,6 +100,45 @@ func (q *Queue) Push(tsk *Task) error {
 		return err
 	}
 	// Push this task to the queue
+	q.activate(tsk.Owner())
+	heap.Push(q.tq, tsk)
+
+	return nil
+}
+
+// Requeue pushes a task that was popped back into the queue, e.g. to retry
+// it, moving it back to the scheduled tasks of the database.
+func (q *Queue) Requeue(tsk *Task) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.tq.Len() >= q.max {
+		return ErrQueueFull
+	}
+
+	if err := q.ts.RescheduleTask(tsk); err != nil {
+		return err
+	}
+	q.activate(tsk.Owner())
+	heap.Push(q.tq, tsk)
+
+	return nil
+}
+
+// Revive pushes a dead letter back into the queue, moving it back to the
+// scheduled tasks of the database.
+func (q *Queue) Revive(tsk *Task) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.tq.Len() >= q.max {
+		return ErrQueueFull
+	}
+
+	if err := q.ts.ReviveTask(tsk); err != nil {
+		return err
+	}
+	q.activate(tsk.Owner())
 	heap.Push(q.tq, tsk)
 
 	return nil
@@ -80,16 +155,134 @@ func (q *Queue) Pop() (*Task, error) {
 		return nil, ErrQueueEmpty
 	}
 	logging.S().Debugw("queue.pop", "len", q.tq.Len())
-	tsk := heap.Pop(q.tq).(*Task)
+	i := q.next()
+	if i < 0 {
+		return nil, ErrQueueBlocked
+	}
+	tsk := heap.Remove(q.tq, i).(*Task)
+	q.served[tsk.Owner()] += 1 / float64(q.weight(tsk.Owner()))
 
 	logging.S().Debugw("queue.pop.got-task", "id", tsk.ID, "testname", tsk.Name())
-	err := q.ts.ProcessTask(tsk)
-	if err != nil {
-		return nil, err
+	// tasks that a restart of the daemon interrupted are stored as being
+	// processed already.
+	if !tsk.Interrupted() {
+		if err := q.ts.ProcessTask(tsk); err != nil {
+			return nil, err
+		}
 	}
+	q.running[tsk.Runner]++
 	return tsk, nil
 }
 
+// Done is called when a popped task is done being processed, whether it
+// completed or was queued again, so that its runner can take another task.
+func (q *Queue) Done(tsk *Task) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.running[tsk.Runner] > 0 {
+		q.running[tsk.Runner]--
+	}
+}
+
+// Remove removes a scheduled task from the queue, returning it. It returns
+// ErrNotFound if the task is not in the queue, e.g. because a worker has
+// already popped it. The task remains in the database.
+func (q *Queue) Remove(id string) (*Task, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, tsk := range *q.tq {
+		if tsk.ID == id {
+			heap.Remove(q.tq, i)
+			return tsk, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// next returns the index in the heap of the task to pop next: the oldest
+// task of the least served owner, among the tasks of the highest priority
+// whose runners can take more tasks. It returns -1 if no task can be popped.
+func (q *Queue) next() int {
+	top, eligible := 0, false
+	for _, tsk := range *q.tq {
+		if q.available(tsk) && (!eligible || tsk.Priority > top) {
+			top, eligible = tsk.Priority, true
+		}
+	}
+	if !eligible {
+		return -1
+	}
+
+	oldest := make(map[string]int)
+	for i, tsk := range *q.tq {
+		if tsk.Priority != top || !q.available(tsk) {
+			continue
+		}
+		if j, ok := oldest[tsk.Owner()]; !ok || q.tq.Less(i, j) {
+			oldest[tsk.Owner()] = i
+		}
+	}
+
+	next := -1
+	for owner, i := range oldest {
+		if next == -1 {
+			next = i
+			continue
+		}
+		o := (*q.tq)[next].Owner()
+		switch {
+		case q.served[owner] < q.served[o]:
+			next = i
+		case q.served[owner] == q.served[o] && q.tq.Less(i, next):
+			next = i
+		}
+	}
+	return next
+}
+
+// available returns whether the runner of the task can take more tasks.
+// Tasks without a runner, i.e. builds, are only limited by the workers.
+func (q *Queue) available(tsk *Task) bool {
+	limit, ok := q.limits[tsk.Runner]
+	return tsk.Runner == "" || !ok || limit <= 0 || q.running[tsk.Runner] < limit
+}
+
+// activate is called before a task of owner is pushed. If the owner has no
+// tasks in the queue, it catches up with the least served owner that has
+// some, so that it can't claim the service it missed while it was idle.
+func (q *Queue) activate(owner string) {
+	var (
+		least  float64
+		queued bool
+	)
+	for i, tsk := range *q.tq {
+		if tsk.Owner() == owner {
+			return
+		}
+		if s := q.served[tsk.Owner()]; i == 0 || s < least {
+			least = s
+		}
+		queued = true
+	}
+	if !queued {
+		// nobody is waiting; start afresh.
+		q.served = make(map[string]float64)
+		return
+	}
+	if q.served[owner] < least {
+		q.served[owner] = least
+	}
+}
+
+func (q *Queue) weight(owner string) int {
+	if w, ok := q.weights[owner]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
 // This is a priority queue which implements container/heap.Interface
 // Tasks are sorted by priority and then timestamp.
 type taskQueue []*Task
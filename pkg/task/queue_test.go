@@ -3,7 +3,9 @@ package task
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/rs/xid"
 	"github.com/stretchr/testify/assert"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -78,6 +80,46 @@ func TestQueueReloads(t *testing.T) {
 	assert.Equal(t, id, tsk.ID)
 }
 
+// Tasks that were being processed when the daemon stopped are popped again.
+func TestQueueReloadsInterrupted(t *testing.T) {
+	id := "bt4brhjpc98qra498sg0"
+	inmem := storage.NewMemStorage()
+	db, err := leveldb.Open(inmem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &Storage{db}
+
+	now := time.Now()
+	err = ts.PersistProcessing(&Task{
+		ID: id,
+		States: []DatedState{
+			{State: StateScheduled, Created: now},
+			{State: StateProcessing, Created: now},
+			{State: StateRunning, Created: now},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewQueue(ts, 1, convertTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsk, err := q.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, tsk.ID)
+	assert.True(t, tsk.Interrupted())
+
+	// the task is still stored as being processed.
+	if _, err := ts.get(prefixProcessing, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func convertTask(taskData []byte) (*Task, error) {
 	tsk := &Task{}
 	err := json.Unmarshal(taskData, tsk)
@@ -86,3 +128,208 @@ func convertTask(taskData []byte) (*Task, error) {
 	}
 	return tsk, nil
 }
+
+func TestQueueRemove(t *testing.T) {
+	inmem := storage.NewMemStorage()
+	db, err := leveldb.Open(inmem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &Storage{db}
+	q, err := NewQueue(ts, 10, convertTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"bt4brhjpc98qra498sg0", "bt4brhjpc98qra498sh0", "bt4brhjpc98qra498si0"}
+	for _, id := range ids {
+		tsk := &Task{
+			ID:     id,
+			States: []DatedState{{State: StateScheduled, Created: time.Now()}},
+		}
+		if err := q.Push(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsk, err := q.Remove(ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, ids[1], tsk.ID)
+	assert.Equal(t, 2, q.tq.Len())
+
+	_, err = q.Remove(ids[1])
+	assert.Equal(t, ErrNotFound, err)
+
+	// the removed task can be archived straight from the scheduled state.
+	assert.NoError(t, ts.ArchiveScheduledTask(tsk))
+	_, err = ts.get(prefixComplete, tsk.ID)
+	assert.NoError(t, err)
+	_, err = ts.get(prefixScheduled, tsk.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestQueueIsFairToOwners(t *testing.T) {
+	inmem := storage.NewMemStorage()
+	db, err := leveldb.Open(inmem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(&Storage{db}, 100, convertTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// task IDs must be xids; the tasks are named after their owners.
+	var (
+		now   = time.Now()
+		names = make(map[string]string)
+	)
+	push := func(name, owner string, priority int) {
+		id := xid.New().String()
+		names[id] = name
+		tsk := &Task{
+			ID:        id,
+			Priority:  priority,
+			CreatedBy: CreatedBy{User: owner},
+			States:    []DatedState{{State: StateScheduled, Created: now}},
+		}
+		now = now.Add(time.Second)
+		if err := q.Push(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pop := func() string {
+		tsk, err := q.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names[tsk.ID]
+	}
+
+	// alice schedules a sweep before bob and carol schedule a task each.
+	for _, id := range []string{"a1", "a2", "a3", "a4"} {
+		push(id, "alice", 0)
+	}
+	push("b1", "bob", 0)
+	push("c1", "carol", 0)
+
+	var got []string
+	for q.tq.Len() > 0 {
+		got = append(got, pop())
+	}
+	assert.Equal(t, []string{"a1", "b1", "c1", "a2", "a3", "a4"}, got)
+
+	// priorities win over fairness.
+	push("a5", "alice", 0)
+	push("b2", "bob", 0)
+	push("a6", "alice", 1)
+	assert.Equal(t, "a6", pop())
+	assert.Equal(t, "b2", pop())
+	assert.Equal(t, "a5", pop())
+
+	// weights let owners get more tasks scheduled.
+	q.SetWeights(map[string]int{"ci": 2})
+	for _, id := range []string{"ci1", "ci2", "ci3", "ci4"} {
+		push(id, "ci", 0)
+	}
+	for _, id := range []string{"b3", "b4"} {
+		push(id, "bob", 0)
+	}
+
+	got = got[:0]
+	for q.tq.Len() > 0 {
+		got = append(got, pop())
+	}
+	assert.Equal(t, []string{"ci1", "b3", "ci2", "ci3", "b4", "ci4"}, got)
+}
+
+func TestQueueConcurrencyLimits(t *testing.T) {
+	inmem := storage.NewMemStorage()
+	db, err := leveldb.Open(inmem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(&Storage{db}, 100, convertTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.SetConcurrency(map[string]int{"cluster:k8s": 1})
+
+	now := time.Now()
+	for i, runner := range []string{"cluster:k8s", "cluster:k8s", "local:docker", ""} {
+		tsk := &Task{
+			ID:     xid.New().String(),
+			Runner: runner,
+			States: []DatedState{{State: StateScheduled, Created: now.Add(time.Duration(i) * time.Second)}},
+		}
+		if err := q.Push(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the second k8s task waits for the first one, behind the others.
+	var popped []*Task
+	for _, runner := range []string{"cluster:k8s", "local:docker", ""} {
+		tsk, err := q.Pop()
+		assert.NoError(t, err)
+		assert.Equal(t, runner, tsk.Runner)
+		popped = append(popped, tsk)
+	}
+	_, err = q.Pop()
+	assert.Equal(t, ErrQueueBlocked, err)
+
+	q.Done(popped[0])
+	tsk, err := q.Pop()
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster:k8s", tsk.Runner)
+
+	_, err = q.Pop()
+	assert.Equal(t, ErrQueueEmpty, err)
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	inmem := storage.NewMemStorage()
+	db, err := leveldb.Open(inmem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &Storage{db}
+	q, err := NewQueue(ts, 10, convertTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tsk := &Task{
+		ID:     "bt4brhjpc98qra498sg0",
+		States: []DatedState{{State: StateScheduled, Created: time.Now()}},
+	}
+	assert.NoError(t, q.Push(tsk))
+	tsk, err = q.Pop()
+	assert.NoError(t, err)
+
+	// a quarantined task isn't reloaded into the queue.
+	tsk.Crashes = []Crash{{Time: time.Now(), Panic: "boom"}}
+	assert.NoError(t, ts.QuarantineTask(tsk))
+	q.Done(tsk)
+
+	q2, err := NewQueue(ts, 10, convertTask)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, q2.tq.Len())
+
+	dead, err := ts.DeadLetters()
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "boom", dead[0].Crashes[0].Panic)
+
+	got, err := ts.Get(tsk.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, tsk.ID, got.ID)
+
+	// a revived task is queued again.
+	assert.NoError(t, q2.Revive(dead[0]))
+	_, err = ts.GetDeadLetter(tsk.ID)
+	assert.Equal(t, ErrNotFound, err)
+	popped, err := q2.Pop()
+	assert.NoError(t, err)
+	assert.Equal(t, tsk.ID, popped.ID)
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// prefixSchedule is the database key prefix of the schedules of recurring
+// runs.
+var prefixSchedule = "schedule"
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule (kind: struct) is a recurring run: a run request that the daemon
+// queues whenever its cron expression matches.
+type Schedule struct {
+	ID        string    `json:"id"`
+	Cron      string    `json:"cron"`
+	Plan      string    `json:"plan"`
+	Case      string    `json:"case"`
+	Created   time.Time `json:"created"`
+	CreatedBy CreatedBy `json:"created_by"`
+
+	// Request is the run request that is queued, opaque to the storage.
+	Request json.RawMessage `json:"request"`
+
+	// Sources is the directory holding the sources of the test plan sent
+	// with the request, if any; every run is built from a copy of them.
+	Sources string `json:"sources,omitempty"`
+
+	// Last is the time of the last occurrence of the schedule, and LastTask
+	// the ID of the task it queued, if it could be queued.
+	Last     time.Time `json:"last,omitempty"`
+	LastTask string    `json:"last_task,omitempty"`
+}
+
+func scheduleKey(id string) []byte {
+	return []byte(prefixSchedule + ":" + id)
+}
+
+// PutSchedule stores a schedule, replacing the one with the same ID, if any.
+func (s *Storage) PutSchedule(sch *Schedule) error {
+	val, err := json.Marshal(sch)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(scheduleKey(sch.ID), val, &opt.WriteOptions{
+		Sync: true,
+	})
+}
+
+// GetSchedule returns the schedule with the given ID.
+func (s *Storage) GetSchedule(id string) (*Schedule, error) {
+	val, err := s.db.Get(scheduleKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	sch := &Schedule{}
+	return sch, json.Unmarshal(val, sch)
+}
+
+// DeleteSchedule removes the schedule with the given ID.
+func (s *Storage) DeleteSchedule(id string) error {
+	if _, err := s.GetSchedule(id); err != nil {
+		return err
+	}
+	return s.db.Delete(scheduleKey(id), &opt.WriteOptions{
+		Sync: true,
+	})
+}
+
+// Schedules returns all the schedules, oldest first.
+func (s *Storage) Schedules() ([]*Schedule, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefixSchedule+":")), nil)
+	defer iter.Release()
+
+	res := make([]*Schedule, 0)
+	for iter.Next() {
+		sch := &Schedule{}
+		if err := json.Unmarshal(iter.Value(), sch); err != nil {
+			return nil, err
+		}
+		res = append(res, sch)
+	}
+	return res, iter.Error()
+}
@@ -22,6 +22,10 @@ var (
 	prefixProcessing = "current"
 	prefixComplete   = "archive"
 
+	// prefixDeadLetter holds the tasks that crashed the daemon too many
+	// times, rather than queueing them forever.
+	prefixDeadLetter = "deadletter"
+
 	ErrNotFound = errors.New("task not found")
 )
 
@@ -106,6 +110,13 @@ func (s *Storage) Delete(id string) error {
 	if err != ErrNotFound {
 		return err
 	}
+	tsk, err = s.get(prefixDeadLetter, id)
+	if err == nil {
+		return s.delete(prefixDeadLetter, tsk)
+	}
+	if err != ErrNotFound {
+		return err
+	}
 	return errors.New("should have found task")
 }
 
@@ -134,7 +145,14 @@ func (s *Storage) Get(id string) (*Task, error) {
 	if err != ErrNotFound {
 		return nil, err
 	}
-	return s.get(prefixScheduled, id)
+	tsk, err = s.get(prefixScheduled, id)
+	if err == nil {
+		return tsk, nil
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+	return s.get(prefixDeadLetter, id)
 }
 
 func (s *Storage) PersistProcessing(tsk *Task) error {
@@ -153,6 +171,60 @@ func (s *Storage) ArchiveTask(tsk *Task) error {
 	return s.changePrefix(prefixComplete, prefixProcessing, tsk.ID)
 }
 
+// RescheduleTask moves a task that was being processed back to the queue,
+// persisting its current state.
+func (s *Storage) RescheduleTask(tsk *Task) error {
+	if err := s.put(prefixScheduled, tsk); err != nil {
+		return err
+	}
+	return s.delete(prefixProcessing, tsk)
+}
+
+// ArchiveScheduledTask archives a task that never got to be processed, e.g.
+// because it was canceled while queued.
+func (s *Storage) ArchiveScheduledTask(tsk *Task) error {
+	return s.changePrefix(prefixComplete, prefixScheduled, tsk.ID)
+}
+
+// QuarantineTask moves a task that was being processed to the dead letters,
+// persisting its current state, so that it's not queued again.
+func (s *Storage) QuarantineTask(tsk *Task) error {
+	if err := s.put(prefixDeadLetter, tsk); err != nil {
+		return err
+	}
+	return s.delete(prefixProcessing, tsk)
+}
+
+// ReviveTask moves a dead letter back to the queue, persisting its current
+// state.
+func (s *Storage) ReviveTask(tsk *Task) error {
+	if err := s.put(prefixScheduled, tsk); err != nil {
+		return err
+	}
+	return s.delete(prefixDeadLetter, tsk)
+}
+
+// GetDeadLetter returns the dead letter with the given ID.
+func (s *Storage) GetDeadLetter(id string) (*Task, error) {
+	return s.get(prefixDeadLetter, id)
+}
+
+// DeadLetters returns the tasks that were quarantined, oldest first.
+func (s *Storage) DeadLetters() ([]*Task, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefixDeadLetter+":")), nil)
+	defer iter.Release()
+
+	res := make([]*Task, 0)
+	for iter.Next() {
+		tsk := &Task{}
+		if err := json.Unmarshal(iter.Value(), tsk); err != nil {
+			return nil, err
+		}
+		res = append(res, tsk)
+	}
+	return res, iter.Error()
+}
+
 // Change the prefix of a task
 func (s *Storage) changePrefix(dst string, src string, id string) error {
 	oldkey, err := taskKey(src, id)
@@ -188,7 +260,7 @@ func (s *Storage) changePrefix(dst string, src string, id string) error {
 func (s *Storage) Filter(state State, start time.Time, end time.Time) (tasks []*Task, err error) {
 	var prefix string
 
-	switch state {
+	switch state.Phase() {
 	case StateScheduled:
 		prefix = prefixScheduled
 	case StateProcessing:
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -132,3 +133,92 @@ func TestArchive(t *testing.T) {
 
 	assert.Equal(t, 3, len(between))
 }
+
+func TestHistory(t *testing.T) {
+	ts, err := NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, rec := range []*Record{
+		{ID: "bt4brhjpc98qra498sg0", Plan: "network", Case: "ping-pong", Ended: now.Add(-3 * time.Hour)},
+		{ID: "bt4brhjpc98qra498sh0", Plan: "network", Case: "traffic", Ended: now.Add(-2 * time.Hour)},
+		{ID: "bt4brhjpc98qra498si0", Plan: "network", Case: "ping-pong", Ended: now.Add(-1 * time.Hour)},
+		{ID: "bt4brhjpc98qra498sj0", Plan: "network:v2", Case: "ping-pong", Ended: now},
+	} {
+		if err := ts.Record(rec); err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+	}
+
+	ids := func(recs []*Record, err error) []string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, rec := range recs {
+			res = append(res, rec.ID)
+		}
+		return res
+	}
+
+	// records are returned oldest first, across test cases.
+	assert.Equal(t, []string{"bt4brhjpc98qra498sg0", "bt4brhjpc98qra498sh0", "bt4brhjpc98qra498si0"},
+		ids(ts.History("network", "", time.Time{}, 0)))
+	assert.Equal(t, []string{"bt4brhjpc98qra498sg0", "bt4brhjpc98qra498si0"},
+		ids(ts.History("network", "ping-pong", time.Time{}, 0)))
+	assert.Equal(t, []string{"bt4brhjpc98qra498si0", "bt4brhjpc98qra498sj0"},
+		ids(ts.History("", "ping-pong", now.Add(-90*time.Minute), 0)))
+	assert.Equal(t, []string{"bt4brhjpc98qra498si0", "bt4brhjpc98qra498sj0"},
+		ids(ts.History("", "", time.Time{}, 2)))
+
+	// the history is kept when tasks are deleted.
+	if err := ts.put(prefixComplete, &Task{ID: "bt4brhjpc98qra498sg0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Delete("bt4brhjpc98qra498sg0"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, ids(ts.History("network", "ping-pong", time.Time{}, 0)), 2)
+}
+
+func TestSchedules(t *testing.T) {
+	ts, err := NewMemoryTaskStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"bt4brhjpc98qra498sg0", "bt4brhjpc98qra498sh0"} {
+		sch := &Schedule{ID: id, Cron: "0 2 * * *", Plan: "network", Request: json.RawMessage(`{"priority":1}`)}
+		if err := ts.PutSchedule(sch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sch, err := ts.GetSchedule("bt4brhjpc98qra498sg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "0 2 * * *", sch.Cron)
+	assert.JSONEq(t, `{"priority":1}`, string(sch.Request))
+
+	sch.LastTask = "bt4brhjpc98qra498si0"
+	if err := ts.PutSchedule(sch); err != nil {
+		t.Fatal(err)
+	}
+
+	schs, err := ts.Schedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, schs, 2)
+	assert.Equal(t, "bt4brhjpc98qra498si0", schs[0].LastTask)
+
+	if err := ts.DeleteSchedule("bt4brhjpc98qra498sh0"); err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, ts.DeleteSchedule("bt4brhjpc98qra498sh0"), ErrScheduleNotFound)
+	_, err = ts.GetSchedule("bt4brhjpc98qra498sh0")
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
@@ -5,20 +5,51 @@ import (
 	"time"
 )
 
-// State (kind: string) represents the last known state of a task.
-// A task can be in one of three states
-// StateScheduled: this is the initial state of the task when it enters the queue.
-// StateProcessing: once work begins on the task, it is put into this state.
-// StateComplete: work is no longer being done on this task. client should check task result.
+// State (kind: string) represents the last known state of a task. Tasks go
+// through the following states:
+//
+//	StateScheduled: this is the initial state of the task when it enters the queue.
+//	StateProcessing: a worker picked the task up, and is preparing it.
+//	StateBuilding: the test plan is being built.
+//	StateRunning: the test plan is running.
+//	StateCollecting: the run is over, and its result is being collected.
+//	StateComplete: work is no longer being done on this task. client should check task result.
+//	StateFailed: the task could not be completed, see its error.
+//	StateCanceled: the task was canceled before it completed.
+//
+// Builds skip the running and collecting states, and runs that don't need a
+// build skip the building state.
 type State string
 
 const (
 	StateScheduled  State = "scheduled"
 	StateProcessing State = "processing"
+	StateBuilding   State = "building"
+	StateRunning    State = "running"
+	StateCollecting State = "collecting"
 	StateComplete   State = "complete"
+	StateFailed     State = "failed"
 	StateCanceled   State = "canceled"
 )
 
+// Phase returns the phase of the state, under which the task is stored:
+// StateScheduled, StateProcessing while a worker processes the task, or
+// StateComplete once it's done.
+func (s State) Phase() State {
+	switch s {
+	case StateProcessing, StateBuilding, StateRunning, StateCollecting:
+		return StateProcessing
+	case StateComplete, StateFailed, StateCanceled:
+		return StateComplete
+	}
+	return s
+}
+
+// Done returns whether the state is final.
+func (s State) Done() bool {
+	return s.Phase() == StateComplete
+}
+
 type Outcome string
 
 const (
@@ -26,8 +57,19 @@ const (
 	OutcomeSuccess  Outcome = "success"
 	OutcomeFailure  Outcome = "failure"
 	OutcomeCanceled Outcome = "canceled"
+	OutcomeTimeout  Outcome = "timeout"
+
+	// OutcomeSuccessWithExceptions is the outcome of a run in which instances
+	// failed, but no more than the composition allows; see
+	// api.Global#AllowFailures.
+	OutcomeSuccessWithExceptions Outcome = "success_with_exceptions"
 )
 
+// Passed returns whether the outcome is a success, possibly with exceptions.
+func (o Outcome) Passed() bool {
+	return o == OutcomeSuccess || o == OutcomeSuccessWithExceptions
+}
+
 // Type (kind: string) represents the kind of activity the daemon asked to perform. In alignment
 // with the testground command-line we have two kinds of tasks
 // TypeBuild -- which functions similarly to `testground build`. The result of this task will contain
@@ -46,6 +88,35 @@ type DatedState struct {
 	State   State     `json:"state"`
 }
 
+// Attempt is an attempt of a task that failed, and was retried.
+type Attempt struct {
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended"`
+	Class   string    `json:"class"` // The class of the failure
+	Error   string    `json:"error"`
+}
+
+// Progress is how far along a task that is being processed is.
+type Progress struct {
+	Percent int       `json:"percent"`           // Of the whole task
+	Message string    `json:"message,omitempty"` // What the current stage is doing
+	Updated time.Time `json:"updated"`
+}
+
+func (p Progress) String() string {
+	if p.Message == "" {
+		return fmt.Sprintf("%d%%", p.Percent)
+	}
+	return fmt.Sprintf("%d%%, %s", p.Percent, p.Message)
+}
+
+// Crash is a panic of the daemon while it processed a task.
+type Crash struct {
+	Time  time.Time `json:"time"`
+	Panic string    `json:"panic"`
+	Stack string    `json:"stack"`
+}
+
 type CreatedBy struct {
 	User   string `json:"user,omitempty"`
 	Repo   string `json:"repo,omitempty"`
@@ -57,19 +128,23 @@ type CreatedBy struct {
 // metadata in our task storage database as well as the wire format returned when clients get the
 // state of a running or scheduled task.
 type Task struct {
-	Version     int          `json:"version"`     // Schema version
-	Priority    int          `json:"priority"`    // Scheduling priority
-	ID          string       `json:"id"`          // Unique identifier for this task
-	Runner      string       `json:"runner"`      // Runner that ran this task
-	Plan        string       `json:"plan"`        // Test plan
-	Case        string       `json:"case"`        // Test case
-	States      []DatedState `json:"states"`      // State of the task
-	Type        Type         `json:"type"`        // Type of the task
-	Composition interface{}  `json:"composition"` // Composition used for the task
-	Input       interface{}  `json:"input"`       // The input data for this task
-	Result      interface{}  `json:"result"`      // Result of the task, when terminal.
-	Error       string       `json:"error"`       // Error from Testground
-	CreatedBy   CreatedBy    `json:"created_by"`  // Who created the task
+	Version     int          `json:"version"`            // Schema version
+	Priority    int          `json:"priority"`           // Scheduling priority
+	ID          string       `json:"id"`                 // Unique identifier for this task
+	Runner      string       `json:"runner"`             // Runner that ran this task
+	Plan        string       `json:"plan"`               // Test plan
+	Case        string       `json:"case"`               // Test case
+	States      []DatedState `json:"states"`             // State of the task
+	Type        Type         `json:"type"`               // Type of the task
+	Composition interface{}  `json:"composition"`        // Composition used for the task
+	Input       interface{}  `json:"input"`              // The input data for this task
+	Result      interface{}  `json:"result"`             // Result of the task, when terminal.
+	Error       string       `json:"error"`              // Error from Testground
+	CreatedBy   CreatedBy    `json:"created_by"`         // Who created the task
+	Attempts    []Attempt    `json:"attempts,omitempty"` // Attempts that failed and were retried
+	Schedule    string       `json:"schedule,omitempty"` // Schedule that queued the task, if any
+	Crashes     []Crash      `json:"crashes,omitempty"`  // Panics while processing the task
+	Progress    *Progress    `json:"progress,omitempty"` // Progress of the current state of the task
 }
 
 func (t *Task) Created() time.Time {
@@ -106,6 +181,18 @@ func (t *Task) State() DatedState {
 	return t.States[len(t.States)-1]
 }
 
+// Interrupted returns whether the task was being processed when the daemon
+// stopped, as far as its last state tells. The queue loads such tasks back,
+// to be processed again.
+func (t *Task) Interrupted() bool {
+	return len(t.States) > 0 && t.State().State.Phase() == StateProcessing
+}
+
+// Owner returns the user who created the task, whom the queue is fair to.
+func (t *Task) Owner() string {
+	return t.CreatedBy.User
+}
+
 func (t *Task) CreatedByCI() bool {
 	return t.CreatedBy.Repo != "" && t.CreatedBy.Commit != "" && t.CreatedBy.Branch != ""
 }
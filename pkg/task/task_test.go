@@ -56,3 +56,19 @@ func TestQueueSortsPriorityAndTime(t *testing.T) {
 		head = next
 	}
 }
+
+func TestStatePhase(t *testing.T) {
+	for st, phase := range map[State]State{
+		StateScheduled:  StateScheduled,
+		StateProcessing: StateProcessing,
+		StateBuilding:   StateProcessing,
+		StateRunning:    StateProcessing,
+		StateCollecting: StateProcessing,
+		StateComplete:   StateComplete,
+		StateFailed:     StateComplete,
+		StateCanceled:   StateComplete,
+	} {
+		assert.Equal(t, phase, st.Phase(), st)
+		assert.Equal(t, phase == StateComplete, st.Done(), st)
+	}
+}